@@ -3,7 +3,6 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
@@ -41,25 +40,19 @@ func main() {
 
 	go func() {
 		defer close(inQueryChan)
-		reader := bufio.NewReader(file)
+		reader, err := query.NewCacheReader(bufio.NewReader(file))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading cache: %v\n", err)
+			return
+		}
 		for {
 			var q query.Query
-
-			if err := binary.Read(reader, binary.LittleEndian, &q.Hash); err != nil {
+			if err := reader.Read(&q); err != nil {
 				if err != io.EOF {
-					fmt.Fprintf(os.Stderr, "Error reading hash from cache: %v\n", err)
+					fmt.Fprintf(os.Stderr, "Error reading cache: %v\n", err)
 				}
 				break
 			}
-			if err := binary.Read(reader, binary.LittleEndian, &q.FingerprintHash); err != nil {
-				break
-			}
-			if err := binary.Read(reader, binary.LittleEndian, &q.Offset); err != nil {
-				break
-			}
-			if err := binary.Read(reader, binary.LittleEndian, &q.Length); err != nil {
-				break
-			}
 
 			inQueryChan <- &q
 		}
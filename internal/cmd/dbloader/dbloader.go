@@ -82,6 +82,15 @@ func (o *OutputDB) truncateTables(ctx context.Context) error {
 	return tx.Commit()
 }
 
+// fingerprintText is NULL for queries read from a version 1 cache file,
+// which doesn't carry the fingerprint
+func fingerprintText(q *query.Query) any {
+	if len(q.Fingerprint) == 0 {
+		return nil
+	}
+	return string(q.Fingerprint)
+}
+
 func (o *OutputDB) insertBatch(ctx context.Context, batch []*query.Query) (int, error) {
 	if len(batch) == 0 {
 		return 0, nil
@@ -94,33 +103,35 @@ func (o *OutputDB) insertBatch(ctx context.Context, batch []*query.Query) (int,
 	defer tx.Rollback()
 
 	fingerprintValues := make([]string, 0, len(batch))
-	fingerprintArgs := make([]any, 0, len(batch))
+	fingerprintArgs := make([]any, 0, len(batch)*2)
 	seenFingerprints := make(map[uint64]bool)
 
 	for _, q := range batch {
 		if !seenFingerprints[q.FingerprintHash] {
 			seenFingerprints[q.FingerprintHash] = true
-			fingerprintValues = append(fingerprintValues, "(?)")
-			fingerprintArgs = append(fingerprintArgs, q.FingerprintHash)
+			fingerprintValues = append(fingerprintValues, "(?, ?)")
+			fingerprintArgs = append(fingerprintArgs, q.FingerprintHash, fingerprintText(q))
 		}
 	}
 
 	if len(fingerprintValues) > 0 {
-		fingerprintSQL := fmt.Sprintf(`INSERT IGNORE INTO QueryFingerprint (Hash) VALUES %s`, strings.Join(fingerprintValues, ", "))
+		fingerprintSQL := fmt.Sprintf(`INSERT IGNORE INTO QueryFingerprint (Hash, Fingerprint) VALUES %s`, strings.Join(fingerprintValues, ", "))
 		if _, err := tx.ExecContext(ctx, fingerprintSQL, fingerprintArgs...); err != nil {
 			return 0, fmt.Errorf("failed to batch insert fingerprints: %w", err)
 		}
 	}
 
 	queryValues := make([]string, 0, len(batch))
-	queryArgs := make([]any, 0, len(batch)*4)
-	seenQueries := make(map[uint64]bool)
+	queryArgs := make([]any, 0, len(batch)*7)
+	// the queries of a session are kept once per execution
+	seenQueries := make(map[[3]uint64]bool)
 
 	for _, q := range batch {
-		if !seenQueries[q.Hash] {
-			seenQueries[q.Hash] = true
-			queryValues = append(queryValues, "(?, ?, ?, ?)")
-			queryArgs = append(queryArgs, q.Hash, q.Offset, q.Length, q.FingerprintHash)
+		key := [3]uint64{q.Hash, q.Session, q.SessionSeq}
+		if !seenQueries[key] {
+			seenQueries[key] = true
+			queryValues = append(queryValues, "(?, ?, ?, ?, ?, ?, ?)")
+			queryArgs = append(queryArgs, q.Hash, q.Offset, q.Length, q.FingerprintHash, q.Session, q.SessionSeq, q.Timestamp)
 		}
 	}
 
@@ -128,7 +139,7 @@ func (o *OutputDB) insertBatch(ctx context.Context, batch []*query.Query) (int,
 		return 0, tx.Commit()
 	}
 
-	querySQL := fmt.Sprintf(`INSERT IGNORE INTO Query (Hash, Offset, Length, FingerprintHash) VALUES %s`, strings.Join(queryValues, ", "))
+	querySQL := fmt.Sprintf(`INSERT IGNORE INTO Query (Hash, Offset, Length, FingerprintHash, Session, SessionSeq, Timestamp) VALUES %s`, strings.Join(queryValues, ", "))
 	if _, err := tx.ExecContext(ctx, querySQL, queryArgs...); err != nil {
 		return 0, fmt.Errorf("failed to batch insert queries: %w", err)
 	}
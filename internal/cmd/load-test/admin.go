@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type AdminConfig struct {
+	PoolSize       int           `mapstructure:"pool_size" yaml:"pool_size" validate:"omitempty,gte=1"`
+	StatusInterval time.Duration `mapstructure:"status_interval" yaml:"status_interval" validate:"omitempty"`
+}
+
+// statusVariables are polled from SHOW GLOBAL STATUS for the report
+var statusVariables = []string{
+	"Threads_connected",
+	"Threads_running",
+	"Questions",
+	"Slow_queries",
+	"Aborted_connects",
+	"Innodb_row_lock_waits",
+	"Innodb_buffer_pool_wait_free",
+}
+
+// AdminConn is a small pool to the target kept apart from the load-bearing
+// pool, so observability queries neither compete with nor wait behind the
+// load. SHOW WARNINGS is the exception: it has to run on the session of the
+// statement.
+type AdminConn struct {
+	db *DBConn
+
+	mu     sync.RWMutex
+	status map[string]string
+}
+
+func NewAdminConn(ctx context.Context, dsn string, poolSize int) (*AdminConn, error) {
+	db := NewDBConn(RetryConfig{
+		MaxRetries:   1,
+		InitialDelay: 100 * time.Millisecond,
+		MaxDelay:     time.Second,
+	})
+	if err := db.OpenWithTimeout(ctx, dsn, poolSize, 5*time.Second); err != nil {
+		return nil, fmt.Errorf("error opening admin connection: %w", err)
+	}
+	return &AdminConn{db: db}, nil
+}
+
+func (a *AdminConn) DB() *DBConn {
+	return a.db
+}
+
+func (a *AdminConn) Close() error {
+	return a.db.Close()
+}
+
+// Explain runs EXPLAIN for query
+func (a *AdminConn) Explain(ctx context.Context, query string) (*ExplainQueryResult, error) {
+	return explain(ctx, a.db, query)
+}
+
+// Kill terminates the statement running on connection id, or the whole
+// connection if queryOnly is false
+func (a *AdminConn) Kill(ctx context.Context, id uint64, queryOnly bool) error {
+	stmt := "KILL CONNECTION "
+	if queryOnly {
+		stmt = "KILL QUERY "
+	}
+	if _, err := a.db.ExecContext(ctx, stmt+fmt.Sprint(id)); err != nil {
+		return fmt.Errorf("error killing connection %d: %w", id, err)
+	}
+	return nil
+}
+
+// ShowGlobalStatus returns the given global status variables
+func (a *AdminConn) ShowGlobalStatus(ctx context.Context, names ...string) (map[string]string, error) {
+	return a.showGlobal(ctx, "STATUS", names)
+}
+
+// ShowGlobalVariables returns the named system variables, or all of them
+// when no names are given
+func (a *AdminConn) ShowGlobalVariables(ctx context.Context, names ...string) (map[string]string, error) {
+	return a.showGlobal(ctx, "VARIABLES", names)
+}
+
+func (a *AdminConn) showGlobal(ctx context.Context, kind string, names []string) (map[string]string, error) {
+	query := "SHOW GLOBAL " + kind
+	args := make([]any, len(names))
+	if len(names) > 0 {
+		query += " WHERE Variable_name IN (?" + strings.Repeat(", ?", len(names)-1) + ")"
+		for i, name := range names {
+			args[i] = name
+		}
+	}
+
+	rows, err := a.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error executing show global %s: %w", strings.ToLower(kind), err)
+	}
+	defer rows.Close()
+
+	values := make(map[string]string, len(names))
+	for rows.Next() {
+		var name, value string
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, fmt.Errorf("error scanning variable: %w", err)
+		}
+		values[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating variables: %w", err)
+	}
+	return values, nil
+}
+
+// PollStatus refreshes the status variables every interval until ctx is done
+func (a *AdminConn) PollStatus(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pollCtx, cancel := context.WithTimeout(ctx, interval)
+		status, err := a.ShowGlobalStatus(pollCtx, statusVariables...)
+		cancel()
+		if err != nil {
+			logger.Debug().Err(err).Msg("Error polling server status")
+		} else {
+			a.mu.Lock()
+			a.status = status
+			a.mu.Unlock()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the latest polled status variables
+func (a *AdminConn) Status() map[string]string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.status
+}
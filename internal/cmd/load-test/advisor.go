@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type AdvisorConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Step is how long each concurrency level of the calibration runs
+	Step time.Duration `mapstructure:"step" yaml:"step" validate:"omitempty,gt=0"`
+	// OversubscribedRatio is how far the configured concurrency may exceed
+	// the estimated knee before a warning is raised
+	OversubscribedRatio float64 `mapstructure:"oversubscribed_ratio" yaml:"oversubscribed_ratio" validate:"omitempty,gt=1"`
+}
+
+// CalibrationPoint is the throughput and mean latency measured at one
+// concurrency level
+type CalibrationPoint struct {
+	Concurrency int     `json:"concurrency"`
+	QPS         float64 `json:"qps"`
+	LatencyUs   float64 `json:"latency_us"`
+	// InFlight is QPS x latency (Little's Law), the concurrency the target
+	// actually worked on
+	InFlight float64 `json:"in_flight"`
+}
+
+// ConcurrencyAdvice relates the configured concurrency to what the target
+// can digest. The knee is the peak throughput times the latency of a single
+// worker: past it, extra workers only queue and add latency.
+type ConcurrencyAdvice struct {
+	Calibrating       bool               `json:"calibrating"`
+	Points            []CalibrationPoint `json:"points"`
+	Configured        int                `json:"configured"`
+	BaseLatencyUs     float64            `json:"base_latency_us"`
+	PeakQPS           float64            `json:"peak_qps"`
+	KneeConcurrency   float64            `json:"knee_concurrency"`
+	QueueingLatencyUs float64            `json:"queueing_latency_us"`
+	Oversubscribed    bool               `json:"oversubscribed"`
+}
+
+// ConcurrencyAdvisor ramps the active workers from 1 up to the configured
+// concurrency, doubling every step, and derives the knee from the
+// measurements
+type ConcurrencyAdvisor struct {
+	cfg     AdvisorConfig
+	querier *Querier
+
+	mu     sync.Mutex
+	advice ConcurrencyAdvice
+}
+
+func NewConcurrencyAdvisor(cfg AdvisorConfig, querier *Querier, concurrency int) *ConcurrencyAdvisor {
+	if cfg.Step <= 0 {
+		cfg.Step = aggregateInterval
+	}
+	if cfg.OversubscribedRatio <= 1 {
+		cfg.OversubscribedRatio = 2
+	}
+	return &ConcurrencyAdvisor{
+		cfg:     cfg,
+		querier: querier,
+		advice:  ConcurrencyAdvice{Calibrating: true, Configured: concurrency},
+	}
+}
+
+// Calibrate runs the ramp and lifts the worker limit when done
+func (a *ConcurrencyAdvisor) Calibrate(ctx context.Context) {
+	defer a.querier.SetActiveWorkers(0)
+
+	logger.Info().Dur("step", a.cfg.Step).Msg("Calibrating concurrency")
+	for level := 1; ; level *= 2 {
+		level = min(level, a.advice.Configured)
+		a.querier.SetActiveWorkers(level)
+
+		dispatched, busy := a.querier.DispatchStats()
+		start := time.Now()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(a.cfg.Step):
+		}
+		nowDispatched, nowBusy := a.querier.DispatchStats()
+
+		n := nowDispatched - dispatched
+		if n > 0 {
+			point := CalibrationPoint{
+				Concurrency: level,
+				QPS:         float64(n) / time.Since(start).Seconds(),
+				LatencyUs:   float64((nowBusy - busy).Microseconds()) / float64(n),
+			}
+			point.InFlight = point.QPS * point.LatencyUs / 1e6
+			a.mu.Lock()
+			a.advice.Points = append(a.advice.Points, point)
+			a.mu.Unlock()
+		}
+
+		if level >= a.advice.Configured {
+			break
+		}
+	}
+
+	a.mu.Lock()
+	a.estimate()
+	advice := a.advice
+	a.mu.Unlock()
+
+	event := logger.Info()
+	msg := "Concurrency calibration done"
+	if advice.Oversubscribed {
+		event = logger.Warn()
+		msg = "Configured concurrency exceeds what the target can digest, extra workers only add queueing latency"
+	}
+	event.
+		Int("configured", advice.Configured).
+		Float64("knee_concurrency", advice.KneeConcurrency).
+		Float64("peak_qps", advice.PeakQPS).
+		Float64("base_latency_us", advice.BaseLatencyUs).
+		Float64("queueing_latency_us", advice.QueueingLatencyUs).
+		Msg(msg)
+}
+
+func (a *ConcurrencyAdvisor) estimate() {
+	a.advice.Calibrating = false
+	if len(a.advice.Points) == 0 {
+		return
+	}
+	a.advice.BaseLatencyUs = a.advice.Points[0].LatencyUs
+	for _, p := range a.advice.Points {
+		a.advice.PeakQPS = max(a.advice.PeakQPS, p.QPS)
+	}
+	a.advice.KneeConcurrency = a.advice.PeakQPS * a.advice.BaseLatencyUs / 1e6
+	// by Little's Law the latency at the configured concurrency is N / X,
+	// anything above the base latency is time spent waiting
+	if a.advice.PeakQPS > 0 {
+		a.advice.QueueingLatencyUs = max(0, float64(a.advice.Configured)/a.advice.PeakQPS*1e6-a.advice.BaseLatencyUs)
+	}
+	a.advice.Oversubscribed = a.advice.KneeConcurrency > 0 &&
+		float64(a.advice.Configured) > a.advice.KneeConcurrency*a.cfg.OversubscribedRatio
+}
+
+func (a *ConcurrencyAdvisor) Advice() *ConcurrencyAdvice {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	advice := a.advice
+	advice.Points = append([]CalibrationPoint(nil), a.advice.Points...)
+	return &advice
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Annotation marks a moment of the run, e.g. a stage change or a replica
+// being killed, so it is kept next to the latency it explains
+type Annotation struct {
+	Time   time.Time         `json:"time"`
+	Source string            `json:"source"`
+	Text   string            `json:"text"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// Annotations collects the annotations of a run. It is safe for concurrent
+// use by users (through ServeHTTP) and internal subsystems.
+type Annotations struct {
+	mu    sync.Mutex
+	items []Annotation
+}
+
+// maxAnnotations bounds the annotations kept, the oldest but the first are
+// dropped
+const maxAnnotations = 10000
+
+// annotations of the current run
+var annotations = NewAnnotations()
+
+func NewAnnotations() *Annotations {
+	return &Annotations{}
+}
+
+// Add records an annotation at the current time
+func (a *Annotations) Add(source, text string, labels map[string]string) Annotation {
+	return a.Append(Annotation{Source: source, Text: text, Labels: labels})
+}
+
+// Append records ann, timestamping it now if it has no time
+func (a *Annotations) Append(ann Annotation) Annotation {
+	if ann.Time.IsZero() {
+		ann.Time = time.Now()
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.items) >= maxAnnotations {
+		// the first one marks the start of the run
+		a.items = append(a.items[:1], a.items[2:]...)
+	}
+	a.items = append(a.items, ann)
+	return ann
+}
+
+// List returns a copy of the annotations in the order they were added
+func (a *Annotations) List() []Annotation {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]Annotation(nil), a.items...)
+}
+
+// ServeHTTP lists annotations on GET and adds the JSON annotation in the
+// request body on POST
+func (a *Annotations) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a.List())
+	case http.MethodPost:
+		var ann Annotation
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&ann); err != nil {
+			http.Error(w, "invalid annotation: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if ann.Text == "" {
+			http.Error(w, "annotation text is required", http.StatusBadRequest)
+			return
+		}
+		if ann.Source == "" {
+			ann.Source = "user"
+		}
+		ann = a.Append(ann)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(ann)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
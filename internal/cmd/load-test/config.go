@@ -1,27 +1,79 @@
 package main
 
+import "time"
+
 type Config struct {
 	DBDSN             string                 `mapstructure:"db_dsn" yaml:"db_dsn" validate:"required"`
-	QueriesDataSource *QueryDataSourceConfig `mapstructure:"queries_data_source" yaml:"queries_data_source" validate:"required"`
+	DBTunnel          TunnelConfig           `mapstructure:"db_tunnel" yaml:"db_tunnel"`
+	QueriesDataSource *QueryDataSourceConfig `mapstructure:"queries_data_source" yaml:"queries_data_source" validate:"required_without=Workloads"`
 	Count             int                    `mapstructure:"count" yaml:"count" validate:"omitempty"`
+	Duration          time.Duration          `mapstructure:"duration" yaml:"duration" validate:"omitempty,gte=0"`
 	Concurrency       int                    `mapstructure:"concurrency" yaml:"concurrency" validate:"omitempty,gte=0"`
-	RunMode           string                 `mapstructure:"run_mode" yaml:"run_mode" validate:"required,oneof=sequential random"`
+	RunMode           string                 `mapstructure:"run_mode" yaml:"run_mode" validate:"required,oneof=sequential random session replay"`
 	QPS               int                    `mapstructure:"qps" yaml:"qps" validate:"omitempty,gte=0"`
+	Burst             BurstConfig            `mapstructure:"burst" yaml:"burst"`
+	TargetSchemas     []string               `mapstructure:"target_schemas" yaml:"target_schemas" validate:"omitempty"`
+	SourceSchema      string                 `mapstructure:"source_schema" yaml:"source_schema" validate:"omitempty"`
+	TenantRewrite     TenantRewriteConfig    `mapstructure:"tenant_rewrite" yaml:"tenant_rewrite"`
+	MaxResultRows     int64                  `mapstructure:"max_result_rows" yaml:"max_result_rows" validate:"omitempty,gte=0"`
+	MaxResultBytes    int64                  `mapstructure:"max_result_bytes" yaml:"max_result_bytes" validate:"omitempty,gte=0"`
+	ExecutionEngine   string                 `mapstructure:"execution_engine" yaml:"execution_engine" validate:"omitempty,oneof=sql raw"`
+	ConnectionMode    string                 `mapstructure:"connection_mode" yaml:"connection_mode" validate:"omitempty,oneof=pooled per_worker"`
 	Metrics           MetricsConfig          `mapstructure:"metrics" yaml:"metrics" validate:"required"`
-	// Reporting         ReportingConfig        `mapstructure:"reporting" yaml:"reporting" validate:"required"`
-}
+	ExecutionLog      ExecutionLogConfig     `mapstructure:"execution_log" yaml:"execution_log"`
+	SlowLog           SlowLogConfig          `mapstructure:"slow_log" yaml:"slow_log"`
+	Admin             AdminConfig            `mapstructure:"admin" yaml:"admin"`
+	Advisor           AdvisorConfig          `mapstructure:"advisor" yaml:"advisor"`
+	Warmup            WarmupConfig           `mapstructure:"warmup" yaml:"warmup"`
+	Soak              SoakConfig             `mapstructure:"soak" yaml:"soak"`
+	Maintenance       MaintenanceConfig      `mapstructure:"maintenance" yaml:"maintenance"`
+	Endpoints         EndpointsConfig        `mapstructure:"endpoints" yaml:"endpoints"`
+	Reconnect         ReconnectConfig        `mapstructure:"reconnect" yaml:"reconnect"`
+	Warnings          WarningsConfig         `mapstructure:"warnings" yaml:"warnings"`
+	PlanDiff          PlanDiffConfig         `mapstructure:"plan_diff" yaml:"plan_diff"`
+	ExplainAnalyze    ExplainAnalyzeConfig   `mapstructure:"explain_analyze" yaml:"explain_analyze"`
+	HintExperiments   []HintExperimentConfig `mapstructure:"hint_experiments" yaml:"hint_experiments" validate:"omitempty,dive"`
+	ReadYourWrites    ReadYourWritesConfig   `mapstructure:"read_your_writes" yaml:"read_your_writes"`
+	Reporters         ReportersConfig        `mapstructure:"reporters" yaml:"reporters"`
+	// OutputDir gets a run-YYYYMMDD-HHMM directory per run with all its
+	// artifacts and a manifest.json
+	OutputDir string `mapstructure:"output_dir" yaml:"output_dir" validate:"omitempty"`
+	// Sequential shards the corpus over the workers with run_mode sequential
+	Sequential SequentialConfig `mapstructure:"sequential" yaml:"sequential"`
+	// Sessions replays the corpus session by session with run_mode session
+	Sessions SessionReplayConfig `mapstructure:"sessions" yaml:"sessions"`
+	// Replay schedules the corpus on its capture timestamps with run_mode
+	// replay
+	Replay ReplayConfig `mapstructure:"replay" yaml:"replay"`
+	// Hooks run commands around the run and snapshot the dataset
+	Hooks HooksConfig `mapstructure:"hooks" yaml:"hooks"`
+	// WriteConflicts remaps the keys of writes so workers don't contend
+	WriteConflicts WriteConflictsConfig `mapstructure:"write_conflicts" yaml:"write_conflicts"`
+	// Consistency records GTID positions and table checksums around the run
+	Consistency ConsistencyConfig `mapstructure:"consistency" yaml:"consistency"`
+	// Workloads run side by side in the process, each with its own data
+	// source, connections and report
+	Workloads []WorkloadConfig `mapstructure:"workloads" yaml:"workloads" validate:"omitempty,dive"`
+	// Reporting writes the summary of the run once it ends
+	Reporting ReportingConfig `mapstructure:"reporting" yaml:"reporting"`
 
-type QueryDataSourceConfig struct {
-	Type              string              `mapstructure:"type" yaml:"type" validate:"required,oneof=db"`
-	QueryDataSourceDB QuerySourceDBConfig `mapstructure:"db" yaml:"db"`
+	// workload names the workload the config runs, secondary is set for
+	// all of them but the first
+	workload  string
+	secondary bool
 }
 
-type ReportingConfig struct {
-	OutFile string `mapstructure:"out_file" yaml:"out_file" validate:"required"`
-	Format  string `mapstructure:"format" yaml:"format" validate:"required,oneof=json human"`
+type QueryDataSourceConfig struct {
+	Type              string                `mapstructure:"type" yaml:"type" validate:"required,oneof=db"`
+	Name              string                `mapstructure:"name" yaml:"name" validate:"omitempty"`
+	QueryDataSourceDB QuerySourceDBConfig   `mapstructure:"db" yaml:"db"`
+	WeightSmoothing   WeightSmoothingConfig `mapstructure:"weight_smoothing" yaml:"weight_smoothing"`
+	TimeOfDay         TimeOfDayConfig       `mapstructure:"time_of_day" yaml:"time_of_day"`
+	Tags              FingerprintTagsConfig `mapstructure:"tags" yaml:"tags"`
 }
 
 type MetricsConfig struct {
-	Enabled bool   `mapstructure:"enabled" yaml:"enabled"`
-	Addr    string `mapstructure:"addr" yaml:"addr" validate:"required_if=Enabled true"`
+	Enabled bool          `mapstructure:"enabled" yaml:"enabled"`
+	Addr    string        `mapstructure:"addr" yaml:"addr" validate:"required_if=Enabled true"`
+	Control ControlConfig `mapstructure:"control" yaml:"control"`
 }
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+)
+
+// WriteConflictsConfig controls how much the replayed writes contend for the
+// same rows. Replaying a captured UPDATE from every worker at once locks the
+// same key over and over, a contention production never sees.
+type WriteConflictsConfig struct {
+	// Mode is reuse, the captured keys are written as is, or disjoint, the
+	// keys of every worker are remapped into a range of their own
+	Mode string `mapstructure:"mode" yaml:"mode" validate:"omitempty,oneof=reuse disjoint"`
+	// Columns are the key columns whose compared values are remapped, e.g. id
+	Columns []string `mapstructure:"columns" yaml:"columns" validate:"required_if=Mode disjoint"`
+	// Range is the number of keys of every worker, worker w writes keys
+	// Offset + w*Range up to Offset + (w+1)*Range - 1
+	Range  int64 `mapstructure:"range" yaml:"range" validate:"required_if=Mode disjoint,omitempty,gt=0"`
+	Offset int64 `mapstructure:"offset" yaml:"offset" validate:"omitempty,gte=0"`
+	// ConflictRate is the share of writes still replayed on their captured
+	// keys in disjoint mode, so some contention remains
+	ConflictRate float64 `mapstructure:"conflict_rate" yaml:"conflict_rate" validate:"omitempty,gte=0,lte=1"`
+}
+
+// WriteConflicts remaps the keys of INSERT, UPDATE and DELETE statements to
+// disjoint ranges per worker. A captured key maps to the same key of the
+// range every time, so a worker revisits its rows like production does.
+type WriteConflicts struct {
+	cfg     WriteConflictsConfig
+	columns []keyColumn
+	digits  *regexp.Regexp
+}
+
+type keyColumn struct {
+	eq *regexp.Regexp
+	in *regexp.Regexp
+}
+
+// NewWriteConflicts returns nil in reuse mode, where writes are replayed as
+// captured
+func NewWriteConflicts(cfg WriteConflictsConfig) (*WriteConflicts, error) {
+	if cfg.Mode != "disjoint" {
+		return nil, nil
+	}
+	if len(cfg.Columns) == 0 || cfg.Range <= 0 {
+		return nil, fmt.Errorf("disjoint write conflicts need key columns and a range")
+	}
+	w := &WriteConflicts{cfg: cfg, digits: regexp.MustCompile(`\d+`)}
+	for _, c := range cfg.Columns {
+		column := "(?i)(?:^|[^\\w$])`?" + regexp.QuoteMeta(c) + "`?"
+		w.columns = append(w.columns, keyColumn{
+			eq: regexp.MustCompile(column + `\s*=\s*'?(\d+)'?`),
+			in: regexp.MustCompile(column + `\s+IN\s*\(([\d\s,']*)\)`),
+		})
+	}
+	return w, nil
+}
+
+// Rewrite remaps the keys of query, a write of worker. Other statements are
+// returned untouched.
+func (w *WriteConflicts) Rewrite(query string, worker int) string {
+	switch classifyStatement(query) {
+	case StatementInsert, StatementUpdate, StatementDelete:
+	default:
+		return query
+	}
+	if w.cfg.ConflictRate > 0 && rand.Float64() < w.cfg.ConflictRate {
+		return query
+	}
+	remap := func(key string) string {
+		return w.remap(key, worker)
+	}
+	for _, c := range w.columns {
+		query = replaceSubmatch(c.eq, query, remap)
+		query = replaceSubmatch(c.in, query, func(list string) string {
+			return w.digits.ReplaceAllStringFunc(list, remap)
+		})
+	}
+	return query
+}
+
+func (w *WriteConflicts) remap(key string, worker int) string {
+	n, err := strconv.ParseInt(key, 10, 64)
+	if err != nil {
+		return key
+	}
+	return strconv.FormatInt(w.cfg.Offset+int64(worker)*w.cfg.Range+n%w.cfg.Range, 10)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ConsistencyConfig checkpoints the target before and after the run, so a
+// write replay against a candidate primary can be shown to end in the same
+// state as against the current one
+type ConsistencyConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Tables are checksummed like pt-table-checksum does, schema.table or a
+	// table of the target database
+	Tables []string `mapstructure:"tables" yaml:"tables" validate:"omitempty"`
+	// Timeout bounds how long the checkpoint after the run waits for the
+	// workers to stop
+	Timeout time.Duration `mapstructure:"timeout" yaml:"timeout" validate:"omitempty,gte=0"`
+}
+
+// TableChecksum is the row count and the BIT_XOR of the CRC32 of the rows
+// of a table, independent of the row order and the storage engine
+type TableChecksum struct {
+	Table    string `json:"table"`
+	Rows     int64  `json:"rows"`
+	Checksum string `json:"checksum"`
+}
+
+// ConsistencyCheckpoint is the replication position and the table checksums
+// at a point of the run. StateDigest hashes the table checksums, two runs
+// ended in the same state when their digests after the run match.
+type ConsistencyCheckpoint struct {
+	TakenAt      time.Time       `json:"taken_at"`
+	ServerUUID   string          `json:"server_uuid"`
+	GTIDExecuted string          `json:"gtid_executed"`
+	Tables       []TableChecksum `json:"tables,omitempty"`
+	StateDigest  string          `json:"state_digest,omitempty"`
+	Error        string          `json:"error,omitempty"`
+}
+
+type ConsistencyReport struct {
+	Before *ConsistencyCheckpoint `json:"before"`
+	After  *ConsistencyCheckpoint `json:"after,omitempty"`
+}
+
+// ConsistencyChecker takes the checkpoints of the run on the admin
+// connection
+type ConsistencyChecker struct {
+	cfg     ConsistencyConfig
+	admin   *AdminConn
+	report  ConsistencyReport
+	stopped chan struct{}
+}
+
+func NewConsistencyChecker(cfg ConsistencyConfig, admin *AdminConn) *ConsistencyChecker {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Minute
+	}
+	return &ConsistencyChecker{cfg: cfg, admin: admin, stopped: make(chan struct{})}
+}
+
+// Before takes the checkpoint before the workers start
+func (c *ConsistencyChecker) Before(ctx context.Context) error {
+	checkpoint, err := c.checkpoint(ctx)
+	if err != nil {
+		return fmt.Errorf("error taking consistency checkpoint: %w", err)
+	}
+	c.report.Before = checkpoint
+	logger.Info().Str("gtid_executed", checkpoint.GTIDExecuted).Str("state_digest", checkpoint.StateDigest).Msg("Consistency checkpoint taken before the run")
+	return nil
+}
+
+// Stopped tells the checker the workers are done writing
+func (c *ConsistencyChecker) Stopped() {
+	close(c.stopped)
+}
+
+// After waits for the workers to stop and takes the checkpoint after the
+// run. A failed checkpoint is kept with its error.
+func (c *ConsistencyChecker) After() *ConsistencyReport {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.Timeout)
+	defer cancel()
+	select {
+	case <-c.stopped:
+	case <-ctx.Done():
+		logger.Warn().Msg("Workers still running, taking the consistency checkpoint anyway")
+	}
+
+	checkpoint, err := c.checkpoint(ctx)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Error taking consistency checkpoint")
+		checkpoint = &ConsistencyCheckpoint{TakenAt: time.Now(), Error: err.Error()}
+	} else {
+		logger.Info().Str("gtid_executed", checkpoint.GTIDExecuted).Str("state_digest", checkpoint.StateDigest).Msg("Consistency checkpoint taken after the run")
+	}
+	c.report.After = checkpoint
+	report := c.report
+	return &report
+}
+
+// Report returns the checkpoints taken so far
+func (c *ConsistencyChecker) Report() *ConsistencyReport {
+	report := c.report
+	return &report
+}
+
+func (c *ConsistencyChecker) checkpoint(ctx context.Context) (*ConsistencyCheckpoint, error) {
+	checkpoint := &ConsistencyCheckpoint{TakenAt: time.Now()}
+	row, err := c.admin.DB().QueryRowContext(ctx, "SELECT @@GLOBAL.server_uuid, @@GLOBAL.gtid_executed")
+	if err != nil {
+		return nil, fmt.Errorf("error reading GTID position: %w", err)
+	}
+	if err := row.Scan(&checkpoint.ServerUUID, &checkpoint.GTIDExecuted); err != nil {
+		return nil, fmt.Errorf("error reading GTID position: %w", err)
+	}
+	// gtid_executed wraps its sets over lines
+	checkpoint.GTIDExecuted = strings.ReplaceAll(checkpoint.GTIDExecuted, "\n", "")
+
+	if len(c.cfg.Tables) == 0 {
+		return checkpoint, nil
+	}
+	digest := sha256.New()
+	for _, table := range c.cfg.Tables {
+		checksum, err := c.checksum(ctx, table)
+		if err != nil {
+			return nil, err
+		}
+		checkpoint.Tables = append(checkpoint.Tables, *checksum)
+		fmt.Fprintf(digest, "%s:%d:%s\n", checksum.Table, checksum.Rows, checksum.Checksum)
+	}
+	checkpoint.StateDigest = hex.EncodeToString(digest.Sum(nil))
+	return checkpoint, nil
+}
+
+// checksum checksums table over all its columns, in the way of
+// pt-table-checksum: NULLs are told apart from empty strings by the ISNULL
+// flags of the row
+func (c *ConsistencyChecker) checksum(ctx context.Context, table string) (*TableChecksum, error) {
+	schemaExpr, name := "DATABASE()", table
+	args := []any{}
+	if schema, rest, ok := strings.Cut(table, "."); ok {
+		schemaExpr, name = "?", rest
+		args = append(args, schema)
+	}
+	args = append(args, name)
+	rows, err := c.admin.DB().QueryContext(ctx, "SELECT column_name FROM information_schema.columns WHERE table_schema = "+
+		schemaExpr+" AND table_name = ? ORDER BY ordinal_position", args...)
+	if err != nil {
+		return nil, fmt.Errorf("error reading columns of table %s: %w", table, err)
+	}
+	var columns, nulls []string
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error reading columns of table %s: %w", table, err)
+		}
+		columns = append(columns, quoteIdentifier(column))
+		nulls = append(nulls, "ISNULL("+quoteIdentifier(column)+")")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading columns of table %s: %w", table, err)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s not found", table)
+	}
+
+	quoted := quoteIdentifier(name)
+	if schema, _, ok := strings.Cut(table, "."); ok {
+		quoted = quoteIdentifier(schema) + "." + quoted
+	}
+	row := strings.Join(columns, ", ") + ", CONCAT(" + strings.Join(nulls, ", ") + ")"
+	query := "SELECT COUNT(*), COALESCE(LOWER(CONV(BIT_XOR(CAST(CRC32(CONCAT_WS('#', " + row +
+		")) AS UNSIGNED)), 10, 16)), '0') FROM " + quoted
+	checksum := &TableChecksum{Table: table}
+	scan, err := c.admin.DB().QueryRowContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error checksumming table %s: %w", table, err)
+	}
+	if err := scan.Scan(&checksum.Rows, &checksum.Checksum); err != nil {
+		return nil, fmt.Errorf("error checksumming table %s: %w", table, err)
+	}
+	return checksum, nil
+}
+
+// finishConsistency takes the checkpoint after the run into r and reports r
+// once more. The results still sent meanwhile are dropped, so no worker
+// blocks on a full results channel.
+func (r *Report) finishConsistency(reporters []Reporter) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case _, ok := <-r.results:
+				if !ok {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	r.Consistency = r.consistency.After()
+	for _, reporter := range reporters {
+		if err := reporter.Report(r); err != nil {
+			logger.Warn().Err(err).Msg("Error reporting")
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ControlConfig exposes /control on the metrics server, letting the web UI
+// change the rate and concurrency, pause and stop the running load test
+type ControlConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Token is required as a bearer token on every change when set
+	Token string `mapstructure:"token" yaml:"token" validate:"omitempty"`
+}
+
+// errStoppedByControl ends a run stopped through the control API
+var errStoppedByControl = errors.New("stopped through the control API")
+
+// ControlState is what /control returns. QPS is 0 when the rate is
+// unlimited, it can only be changed when the run started with a QPS.
+type ControlState struct {
+	Enabled        bool    `json:"enabled"`
+	Running        bool    `json:"running"`
+	Paused         bool    `json:"paused"`
+	QPS            float64 `json:"qps"`
+	Concurrency    int     `json:"concurrency"`
+	MaxConcurrency int     `json:"max_concurrency"`
+}
+
+// ControlRequest is the body of a POST to /control. Action is one of set,
+// pause, resume or stop, set changes the fields given.
+type ControlRequest struct {
+	Action      string   `json:"action"`
+	QPS         *float64 `json:"qps,omitempty"`
+	Concurrency *int     `json:"concurrency,omitempty"`
+}
+
+// Control applies the control requests to the running load test. Every
+// change is logged with its origin and added as an annotation.
+type Control struct {
+	mu             sync.Mutex
+	cfg            ControlConfig
+	querier        *Querier
+	pacer          *Pacer
+	advisor        *ConcurrencyAdvisor
+	cancel         context.CancelCauseFunc
+	maxConcurrency int
+}
+
+// control of the current run
+var control = &Control{}
+
+// Configure enables the control API
+func (c *Control) Configure(cfg ControlConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cfg = cfg
+}
+
+// Attach hands the running load test to the control API, pacer and advisor
+// are nil when not used
+func (c *Control) Attach(querier *Querier, pacer *Pacer, advisor *ConcurrencyAdvisor, maxConcurrency int, cancel context.CancelCauseFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.querier, c.pacer, c.advisor = querier, pacer, advisor
+	c.maxConcurrency, c.cancel = maxConcurrency, cancel
+}
+
+func (c *Control) State() ControlState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state()
+}
+
+func (c *Control) state() ControlState {
+	state := ControlState{Enabled: c.cfg.Enabled, Running: c.querier != nil}
+	if c.querier == nil {
+		return state
+	}
+	state.Paused = c.querier.Paused()
+	state.MaxConcurrency = c.maxConcurrency
+	state.Concurrency = c.querier.ActiveWorkers()
+	if state.Concurrency == 0 {
+		state.Concurrency = c.maxConcurrency
+	}
+	if c.pacer != nil {
+		state.QPS = c.pacer.QPS()
+	}
+	return state
+}
+
+// Apply performs req and returns the resulting state, origin identifies the
+// requester in the audit log
+func (c *Control) Apply(req ControlRequest, origin string) (ControlState, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.querier == nil {
+		return c.state(), fmt.Errorf("no load test is running")
+	}
+
+	labels := map[string]string{"origin": origin}
+	var text string
+	switch req.Action {
+	case "set":
+		if req.QPS == nil && req.Concurrency == nil {
+			return c.state(), fmt.Errorf("set needs qps or concurrency")
+		}
+		var changes []string
+		if req.QPS != nil {
+			if c.pacer == nil {
+				return c.state(), fmt.Errorf("the run has no QPS limit, start it with a qps to change the rate")
+			}
+			if *req.QPS <= 0 {
+				return c.state(), fmt.Errorf("qps must be positive")
+			}
+			labels["qps"] = strconv.FormatFloat(*req.QPS, 'g', -1, 64)
+			changes = append(changes, "QPS to "+labels["qps"])
+		}
+		if req.Concurrency != nil {
+			if *req.Concurrency < 1 || *req.Concurrency > c.maxConcurrency {
+				return c.state(), fmt.Errorf("concurrency must be between 1 and %d", c.maxConcurrency)
+			}
+			if c.advisor != nil && c.advisor.Advice().Calibrating {
+				return c.state(), fmt.Errorf("concurrency is being calibrated")
+			}
+			labels["concurrency"] = strconv.Itoa(*req.Concurrency)
+			changes = append(changes, "concurrency to "+labels["concurrency"])
+		}
+		if req.QPS != nil {
+			c.pacer.SetQPS(*req.QPS)
+		}
+		if req.Concurrency != nil {
+			// the limit is lifted at the configured concurrency
+			c.querier.SetActiveWorkers(*req.Concurrency % c.maxConcurrency)
+		}
+		text = "Set " + strings.Join(changes, " and ")
+	case "pause", "resume":
+		paused := req.Action == "pause"
+		c.querier.SetPaused(paused)
+		if c.pacer != nil {
+			c.pacer.SetPaused(paused)
+		}
+		text = "Load test " + req.Action + "d"
+	case "stop":
+		text = "Load test stopped"
+	default:
+		return c.state(), fmt.Errorf("unknown action %q", req.Action)
+	}
+
+	logger.Info().Str("action", req.Action).Str("origin", origin).Msg("Control: " + text)
+	annotations.Add("control", text, labels)
+	if req.Action == "stop" {
+		c.cancel(errStoppedByControl)
+	}
+	return c.state(), nil
+}
+
+// ServeHTTP returns the state on GET and applies the JSON control request
+// in the body on POST
+func (c *Control) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	cfg := c.cfg
+	c.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.State())
+	case http.MethodPost:
+		if !cfg.Enabled {
+			http.Error(w, "control is disabled, enable it with metrics.control.enabled", http.StatusForbidden)
+			return
+		}
+		if cfg.Token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+				http.Error(w, "invalid control token", http.StatusUnauthorized)
+				return
+			}
+		}
+		var req ControlRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid control request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		state, err := c.Apply(req, r.RemoteAddr)
+		if err != nil {
+			logger.Warn().Err(err).Str("action", req.Action).Str("origin", r.RemoteAddr).Msg("Control request rejected")
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(state)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math/rand/v2"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
@@ -19,6 +21,20 @@ type RetryConfig struct {
 	MaxDelay        time.Duration
 	BackoffFactor   float64
 	ConnectionCheck bool
+	// Jitter randomly shortens each backoff delay by up to this fraction so
+	// failing workers don't retry in lockstep
+	Jitter float64
+	// MaxConcurrentReconnects caps the reconnect attempts in flight
+	MaxConcurrentReconnects int
+	// ReconnectStorm lets every failing worker reconnect at once without
+	// jitter, to reproduce a reconnection storm on purpose
+	ReconnectStorm bool
+}
+
+type ReconnectConfig struct {
+	Jitter        float64 `mapstructure:"jitter" yaml:"jitter" validate:"omitempty,gte=0,lte=1"`
+	MaxConcurrent int     `mapstructure:"max_concurrent" yaml:"max_concurrent" validate:"omitempty,gte=0"`
+	Storm         bool    `mapstructure:"storm" yaml:"storm"`
 }
 
 type DBConn struct {
@@ -27,6 +43,12 @@ type DBConn struct {
 	concurrency int
 	retryConfig RetryConfig
 	mu          sync.RWMutex
+
+	// reconnects holds a slot per reconnect attempt in flight, generation
+	// counts the connections opened so a failure already fixed by another
+	// worker doesn't reconnect again
+	reconnects chan struct{}
+	generation atomic.Uint64
 }
 
 func NewDBConn(retryConfig RetryConfig) *DBConn {
@@ -42,9 +64,13 @@ func NewDBConn(retryConfig RetryConfig) *DBConn {
 	if retryConfig.BackoffFactor == 0 {
 		retryConfig.BackoffFactor = 2.0
 	}
+	if retryConfig.MaxConcurrentReconnects == 0 {
+		retryConfig.MaxConcurrentReconnects = 1
+	}
 
 	return &DBConn{
 		retryConfig: retryConfig,
+		reconnects:  make(chan struct{}, retryConfig.MaxConcurrentReconnects),
 	}
 }
 
@@ -88,15 +114,29 @@ func (d *DBConn) OpenWithTimeout(ctx context.Context, dsn string, concurrency in
 }
 
 func (d *DBConn) connect(ctx context.Context) error {
+	db, err := d.open(ctx)
+	if err != nil {
+		return err
+	}
+	if d.db != nil {
+		d.db.Close()
+	}
+	d.db = db
+	d.generation.Add(1)
+
+	return nil
+}
+
+func (d *DBConn) open(ctx context.Context) (*sql.DB, error) {
 	db, err := sql.Open("mysql", d.dsn)
 	if err != nil {
-		return fmt.Errorf("failed to open database: %w", err)
+		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Test the connection
 	if err := db.PingContext(ctx); err != nil {
 		db.Close()
-		return fmt.Errorf("failed to ping database: %w", err)
+		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	// Configure connection pool
@@ -105,17 +145,57 @@ func (d *DBConn) connect(ctx context.Context) error {
 	db.SetConnMaxLifetime(5 * time.Minute)
 	db.SetConnMaxIdleTime(1 * time.Minute)
 
+	return db, nil
+}
+
+// reconnect replaces the connection that failed at generation. It is a no-op
+// when another worker already replaced it, and waits for a slot when
+// MaxConcurrentReconnects attempts are in flight.
+func (d *DBConn) reconnect(ctx context.Context, generation uint64) error {
+	if d.retryConfig.ReconnectStorm {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		log.Println("Attempting to reconnect to database...")
+		return d.connect(ctx)
+	}
+
+	select {
+	case d.reconnects <- struct{}{}:
+		defer func() { <-d.reconnects }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if d.generation.Load() != generation {
+		return nil
+	}
+
+	log.Println("Attempting to reconnect to database...")
+	// dial outside the lock so queries on the old connection aren't blocked
+	db, err := d.open(ctx)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.generation.Load() != generation {
+		// a concurrent attempt won
+		db.Close()
+		return nil
+	}
 	if d.db != nil {
 		d.db.Close()
 	}
 	d.db = db
-
+	d.generation.Add(1)
 	return nil
 }
 
-func (d *DBConn) reconnect(ctx context.Context) error {
-	log.Println("Attempting to reconnect to database...")
-	return d.connect(ctx)
+// backoff returns delay shortened by the jitter
+func (d *DBConn) backoff(delay time.Duration) time.Duration {
+	if d.retryConfig.ReconnectStorm || d.retryConfig.Jitter <= 0 {
+		return delay
+	}
+	return time.Duration(float64(delay) * (1 - d.retryConfig.Jitter*rand.Float64()))
 }
 
 func (d *DBConn) Close() error {
@@ -166,7 +246,7 @@ func (d *DBConn) withRetry(ctx context.Context, operation func() error) error {
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
-			case <-time.After(delay):
+			case <-time.After(d.backoff(delay)):
 			}
 
 			delay = time.Duration(float64(delay) * d.retryConfig.BackoffFactor)
@@ -175,6 +255,7 @@ func (d *DBConn) withRetry(ctx context.Context, operation func() error) error {
 			}
 		}
 
+		generation := d.generation.Load()
 		err := operation()
 		if err == nil {
 			return nil
@@ -183,9 +264,7 @@ func (d *DBConn) withRetry(ctx context.Context, operation func() error) error {
 		lastErr = err
 
 		if d.isConnectionError(err) {
-			d.mu.Lock()
-			reconnectErr := d.reconnect(ctx)
-			d.mu.Unlock()
+			reconnectErr := d.reconnect(ctx, generation)
 
 			if reconnectErr != nil {
 				lastErr = fmt.Errorf("reconnection failed: %w (original error: %v)", reconnectErr, err)
@@ -251,6 +330,17 @@ func (d *DBConn) ExecContext(ctx context.Context, query string, args ...any) (sq
 	return result, err
 }
 
+// Conn reserves a single connection from the pool with retry logic
+func (d *DBConn) Conn(ctx context.Context) (*sql.Conn, error) {
+	var conn *sql.Conn
+	err := d.withDB(ctx, func(db *sql.DB) error {
+		var err error
+		conn, err = db.Conn(ctx)
+		return err
+	})
+	return conn, err
+}
+
 // PrepareContext creates a prepared statement with retry logic
 func (d *DBConn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
 	var stmt *sql.Stmt
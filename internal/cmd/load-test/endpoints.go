@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mysql-load-test/internal/metrics"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type EndpointsConfig struct {
+	// ResolveInterval re-resolves the target host and rebalances the
+	// connections over the returned addresses, 0 disables
+	ResolveInterval time.Duration `mapstructure:"resolve_interval" yaml:"resolve_interval" validate:"omitempty,gte=0"`
+}
+
+// endpointsNet is the DSN network the balancer registers its dialer under
+const endpointsNet = "tcp-balanced"
+
+// errConnRetired fails the first write to a retired connection, before
+// anything was sent, so the pool transparently retries on a new connection
+var errConnRetired = errors.New("connection retired by rebalancing")
+
+// EndpointBalancer spreads connections over every address a host resolves
+// to. Scaling the target fleet behind DNS or a load balancer then shows up in
+// the test: new addresses get the new connections, and connections to
+// removed or overloaded addresses are retired.
+type EndpointBalancer struct {
+	host, port string
+	resolver   *net.Resolver
+	dial       func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu    sync.Mutex
+	addrs []string
+	conns map[string]map[*balancedConn]struct{}
+}
+
+func NewEndpointBalancer(addr string) (*EndpointBalancer, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing target address: %w", err)
+	}
+	return &EndpointBalancer{
+		host:     host,
+		port:     port,
+		resolver: net.DefaultResolver,
+		dial:     (&net.Dialer{}).DialContext,
+		conns:    make(map[string]map[*balancedConn]struct{}),
+	}, nil
+}
+
+// SetDialContext connects to the resolved addresses with dial, e.g. through
+// a tunnel. The addresses are still resolved locally.
+func (b *EndpointBalancer) SetDialContext(dial func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	b.dial = dial
+}
+
+// BalancedDSN registers the dialer and returns dsn rewritten to use it
+func (b *EndpointBalancer) BalancedDSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("error parsing target DSN: %w", err)
+	}
+	mysql.RegisterDialContext(endpointsNet, func(ctx context.Context, addr string) (net.Conn, error) {
+		return b.DialContext(ctx, "tcp", addr)
+	})
+	cfg.Net = endpointsNet
+	return cfg.FormatDSN(), nil
+}
+
+func (b *EndpointBalancer) resolve(ctx context.Context) error {
+	ips, err := b.resolver.LookupHost(ctx, b.host)
+	if err != nil {
+		return fmt.Errorf("error resolving %s: %w", b.host, err)
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip, b.port)
+	}
+	slices.Sort(addrs)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !slices.Equal(addrs, b.addrs) {
+		logger.Info().Strs("addrs", addrs).Str("host", b.host).Msg("Target endpoints changed")
+	}
+	b.addrs = addrs
+	b.rebalance()
+	return nil
+}
+
+// rebalance retires the connections of removed addresses and the excess of
+// addresses holding more than their share
+func (b *EndpointBalancer) rebalance() {
+	total := 0
+	for addr, conns := range b.conns {
+		if !slices.Contains(b.addrs, addr) {
+			for c := range conns {
+				b.retire(addr, c)
+			}
+			continue
+		}
+		total += len(conns)
+	}
+	if len(b.addrs) == 0 {
+		return
+	}
+
+	share := (total + len(b.addrs) - 1) / len(b.addrs)
+	for _, addr := range b.addrs {
+		excess := len(b.conns[addr]) - share
+		for c := range b.conns[addr] {
+			if excess <= 0 {
+				break
+			}
+			b.retire(addr, c)
+			excess--
+		}
+	}
+}
+
+// retire takes c out of the counts, it is closed on its next write
+func (b *EndpointBalancer) retire(addr string, c *balancedConn) {
+	c.retired.Store(true)
+	b.untrack(addr, c)
+}
+
+func (b *EndpointBalancer) untrack(addr string, c *balancedConn) {
+	if _, ok := b.conns[addr][c]; !ok {
+		return
+	}
+	delete(b.conns[addr], c)
+	metrics.EndpointConnections.WithLabelValues(addr).Set(float64(len(b.conns[addr])))
+	if len(b.conns[addr]) == 0 {
+		delete(b.conns, addr)
+	}
+}
+
+// Run re-resolves the host every interval until ctx is done
+func (b *EndpointBalancer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := b.resolve(ctx); err != nil {
+			logger.Warn().Err(err).Msg("Error re-resolving target endpoints")
+		}
+	}
+}
+
+// DialContext connects to the resolved address with the fewest connections,
+// addr only names the host and is ignored beyond the first resolution
+func (b *EndpointBalancer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	b.mu.Lock()
+	empty := len(b.addrs) == 0
+	b.mu.Unlock()
+	if empty {
+		if err := b.resolve(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	b.mu.Lock()
+	target := b.addrs[0]
+	for _, a := range b.addrs[1:] {
+		if len(b.conns[a]) < len(b.conns[target]) {
+			target = a
+		}
+	}
+	b.mu.Unlock()
+
+	nc, err := b.dial(ctx, network, target)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &balancedConn{Conn: nc, addr: target, balancer: b}
+	if dialed, ok := ctx.Value(dialedConnKey{}).(**balancedConn); ok {
+		*dialed = c
+	}
+	b.mu.Lock()
+	if b.conns[target] == nil {
+		b.conns[target] = make(map[*balancedConn]struct{})
+	}
+	b.conns[target][c] = struct{}{}
+	metrics.EndpointConnections.WithLabelValues(target).Set(float64(len(b.conns[target])))
+	b.mu.Unlock()
+	return c, nil
+}
+
+// Connections returns the open connections per address
+func (b *EndpointBalancer) Connections() map[string]int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	counts := make(map[string]int, len(b.addrs))
+	for _, addr := range b.addrs {
+		counts[addr] = len(b.conns[addr])
+	}
+	return counts
+}
+
+type balancedConn struct {
+	net.Conn
+	addr     string
+	balancer *EndpointBalancer
+	retired  atomic.Bool
+	closed   sync.Once
+	gone     atomic.Bool
+}
+
+func (c *balancedConn) Write(p []byte) (int, error) {
+	if c.retired.Load() {
+		c.Close()
+		return 0, errConnRetired
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *balancedConn) Close() error {
+	c.closed.Do(func() {
+		c.gone.Store(true)
+		c.balancer.mu.Lock()
+		c.balancer.untrack(c.addr, c)
+		c.balancer.mu.Unlock()
+	})
+	return c.Conn.Close()
+}
+
+// dialedConnKey is the context key of the slot DialContext records the
+// connection it dialed in
+type dialedConnKey struct{}
+
+// endpointHosts tells the endpoint each database/sql connection is connected
+// to. The driver hides its network connection, so the connection dialed
+// while connecting is recorded and remembered by driver connection.
+type endpointHosts struct {
+	mu    sync.Mutex
+	conns map[any]*balancedConn
+	// pruneAt is the size the map is next pruned of closed connections at
+	pruneAt int
+}
+
+func newEndpointHosts() *endpointHosts {
+	return &endpointHosts{conns: make(map[any]*balancedConn), pruneAt: 64}
+}
+
+// conn gets a connection with get and returns the endpoint it is connected
+// to, empty when it was connected outside of get
+func (h *endpointHosts) conn(ctx context.Context, get func(context.Context) (*sql.Conn, error)) (*sql.Conn, string, error) {
+	var dialed *balancedConn
+	conn, err := get(context.WithValue(ctx, dialedConnKey{}, &dialed))
+	if err != nil {
+		return nil, "", err
+	}
+	var dc any
+	conn.Raw(func(c any) error {
+		dc = c
+		return nil
+	})
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if dialed != nil {
+		h.conns[dc] = dialed
+		if len(h.conns) >= h.pruneAt {
+			for k, c := range h.conns {
+				if c.gone.Load() {
+					delete(h.conns, k)
+				}
+			}
+			h.pruneAt = max(64, 2*len(h.conns))
+		}
+	}
+	if c, ok := h.conns[dc]; ok {
+		return conn, c.addr, nil
+	}
+	return conn, "", nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"time"
+
+	"mysql-load-test/pkg/mysqlwire"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+type ExecutionLogConfig struct {
+	File       string  `mapstructure:"file" yaml:"file" validate:"omitempty"`
+	SampleRate float64 `mapstructure:"sample_rate" yaml:"sample_rate" validate:"omitempty,gte=0,lte=1"`
+}
+
+// executionLogEntry is one line of the execution log
+type executionLogEntry struct {
+	Timestamp       time.Time `json:"ts"`
+	WorkerID        int       `json:"worker_id"`
+	FingerprintHash uint64    `json:"fingerprint_hash"`
+	LatencyUs       int64     `json:"latency_us"`
+	Errno           uint16    `json:"errno,omitempty"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// ExecutionLog writes a sample of individual query executions as NDJSON
+// for offline analysis of tail behavior
+type ExecutionLog struct {
+	sampleRate float64
+	file       *os.File
+	mu         sync.Mutex
+	w          *bufio.Writer
+	enc        *json.Encoder
+}
+
+func NewExecutionLog(cfg ExecutionLogConfig) (*ExecutionLog, error) {
+	file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening execution log: %w", err)
+	}
+	w := bufio.NewWriterSize(file, 256*1024)
+	return &ExecutionLog{
+		sampleRate: cfg.SampleRate,
+		file:       file,
+		w:          w,
+		enc:        json.NewEncoder(w),
+	}, nil
+}
+
+// Record logs the execution if it is sampled
+func (l *ExecutionLog) Record(workerID int, fingerprintHash uint64, result *QueryResult, execErr error) {
+	if l.sampleRate < 1 && rand.Float64() >= l.sampleRate {
+		return
+	}
+
+	entry := executionLogEntry{
+		Timestamp:       result.CompletionTimestamp,
+		WorkerID:        workerID,
+		FingerprintHash: fingerprintHash,
+		LatencyUs:       result.ExecLatency.Microseconds(),
+	}
+	if execErr != nil {
+		entry.Error = execErr.Error()
+		entry.Errno = errno(execErr)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.enc.Encode(entry); err != nil {
+		logger.Error().Err(err).Msg("Error writing execution log")
+	}
+}
+
+func (l *ExecutionLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.w.Flush(); err != nil {
+		l.file.Close()
+		return fmt.Errorf("error flushing execution log: %w", err)
+	}
+	return l.file.Close()
+}
+
+// errno returns the server error number of err, or 0
+func errno(err error) uint16 {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number
+	}
+	var wireErr *mysqlwire.Error
+	if errors.As(err, &wireErr) {
+		return wireErr.Number
+	}
+	return 0
+}
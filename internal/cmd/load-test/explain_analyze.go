@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type ExplainAnalyzeConfig struct {
+	// SampleRate is the fraction of SELECT executions also run with EXPLAIN
+	// ANALYZE, which executes them a second time
+	SampleRate float64 `mapstructure:"sample_rate" yaml:"sample_rate" validate:"omitempty,gte=0,lte=1"`
+	// MinRatio is how far apart estimated and actual rows must be before a
+	// fingerprint is reported
+	MinRatio float64 `mapstructure:"min_ratio" yaml:"min_ratio" validate:"omitempty,gte=1"`
+	// Top is the number of fingerprints in the report
+	Top int `mapstructure:"top" yaml:"top" validate:"omitempty,gte=0"`
+}
+
+// EstimationError is the plan node of a fingerprint whose row estimate was
+// the furthest off the rows it actually produced
+type EstimationError struct {
+	FingerprintHash uint64    `json:"fingerprint_hash"`
+	Query           string    `json:"query"`
+	Node            string    `json:"node"`
+	EstimatedRows   float64   `json:"estimated_rows"`
+	ActualRows      float64   `json:"actual_rows"`
+	Ratio           float64   `json:"ratio"`
+	Samples         int64     `json:"samples"`
+	LastSeen        time.Time `json:"last_seen"`
+}
+
+// analyzeNodeRe matches a node of the EXPLAIN ANALYZE tree, the estimate and
+// actual rows are per loop
+var analyzeNodeRe = regexp.MustCompile(`->\s*(.*?)\s+\(cost=[^ ]+ rows=([0-9.e+]+)\)\s+\(actual time=[^ ]+ rows=([0-9.e+]+) loops=\d+\)`)
+
+// ExplainAnalyzer samples EXPLAIN ANALYZE of executed queries and keeps the
+// worst estimation error of each fingerprint
+type ExplainAnalyzer struct {
+	admin      *AdminConn
+	sampleRate float64
+	minRatio   float64
+	top        int
+
+	mu       sync.Mutex
+	worst    map[uint64]*EstimationError
+	analyzed int64
+}
+
+// NewExplainAnalyzer returns nil when the target has no EXPLAIN ANALYZE,
+// which came with MySQL 8.0.18
+func NewExplainAnalyzer(ctx context.Context, cfg ExplainAnalyzeConfig, admin *AdminConn) (*ExplainAnalyzer, error) {
+	row, err := admin.DB().QueryRowContext(ctx, "SELECT @@version")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching target version: %w", err)
+	}
+	var version string
+	if err := row.Scan(&version); err != nil {
+		return nil, fmt.Errorf("error fetching target version: %w", err)
+	}
+	if !supportsExplainAnalyze(version) {
+		logger.Warn().Str("version", version).Msg("Target has no EXPLAIN ANALYZE, estimation errors are not captured")
+		return nil, nil
+	}
+
+	if cfg.MinRatio < 1 {
+		cfg.MinRatio = 10
+	}
+	if cfg.Top <= 0 {
+		cfg.Top = 20
+	}
+	return &ExplainAnalyzer{
+		admin:      admin,
+		sampleRate: cfg.SampleRate,
+		minRatio:   cfg.MinRatio,
+		top:        cfg.Top,
+		worst:      make(map[uint64]*EstimationError),
+	}, nil
+}
+
+func supportsExplainAnalyze(version string) bool {
+	if strings.Contains(strings.ToLower(version), "mariadb") {
+		return false
+	}
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 3 {
+		return false
+	}
+	major, err1 := strconv.Atoi(parts[0])
+	minor, err2 := strconv.Atoi(parts[1])
+	patch, err3 := strconv.Atoi(strings.TrimRightFunc(parts[2], func(r rune) bool { return r < '0' || r > '9' }))
+	if err1 != nil || err2 != nil || err3 != nil {
+		return false
+	}
+	return major > 8 || (major == 8 && (minor > 0 || patch >= 18))
+}
+
+// Sampled reports whether the next execution should be analyzed
+func (ea *ExplainAnalyzer) Sampled() bool {
+	return ea.sampleRate >= 1 || rand.Float64() < ea.sampleRate
+}
+
+// Analyze runs EXPLAIN ANALYZE for query and records its worst estimate
+func (ea *ExplainAnalyzer) Analyze(ctx context.Context, fingerprintHash uint64, query string) error {
+	row, err := ea.admin.DB().QueryRowContext(ctx, "EXPLAIN ANALYZE "+query)
+	if err != nil {
+		return fmt.Errorf("error running explain analyze: %w", err)
+	}
+	var tree string
+	if err := row.Scan(&tree); err != nil {
+		return fmt.Errorf("error running explain analyze: %w", err)
+	}
+	worst, ok := worstEstimate(tree)
+
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+	ea.analyzed++
+	if !ok {
+		return nil
+	}
+	e, found := ea.worst[fingerprintHash]
+	if !found {
+		e = &EstimationError{FingerprintHash: fingerprintHash}
+		ea.worst[fingerprintHash] = e
+	}
+	e.Samples++
+	e.LastSeen = time.Now()
+	if worst.Ratio >= e.Ratio {
+		e.Query, e.Node = query, worst.Node
+		e.EstimatedRows, e.ActualRows, e.Ratio = worst.EstimatedRows, worst.ActualRows, worst.Ratio
+	}
+	return nil
+}
+
+// worstEstimate returns the node of the tree whose estimate is the furthest
+// off, nodes that never ran have no actual rows and are skipped
+func worstEstimate(tree string) (EstimationError, bool) {
+	var worst EstimationError
+	found := false
+	for _, m := range analyzeNodeRe.FindAllStringSubmatch(tree, -1) {
+		estimated, err1 := strconv.ParseFloat(m[2], 64)
+		actual, err2 := strconv.ParseFloat(m[3], 64)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		ratio := estimationRatio(estimated, actual)
+		if !found || ratio > worst.Ratio {
+			worst = EstimationError{Node: m[1], EstimatedRows: estimated, ActualRows: actual, Ratio: ratio}
+			found = true
+		}
+	}
+	return worst, found
+}
+
+// estimationRatio is how many times the larger of both row counts is the
+// smaller, one is added so empty results don't divide by zero
+func estimationRatio(estimated, actual float64) float64 {
+	return (max(estimated, actual) + 1) / (min(estimated, actual) + 1)
+}
+
+// Analyzed returns the number of executions analyzed
+func (ea *ExplainAnalyzer) Analyzed() int64 {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+	return ea.analyzed
+}
+
+// List returns the fingerprints with the worst estimation errors, at least
+// the minimum ratio off, worst first
+func (ea *ExplainAnalyzer) List() []EstimationError {
+	ea.mu.Lock()
+	defer ea.mu.Unlock()
+	var errs []EstimationError
+	for _, e := range ea.worst {
+		if e.Ratio >= ea.minRatio {
+			errs = append(errs, *e)
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Ratio > errs[j].Ratio
+	})
+	if len(errs) > ea.top {
+		errs = errs[:ea.top]
+	}
+	return errs
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// grafanaMetricPrefix selects the load test metrics from the registry, the
+// Go and process collectors are left to the usual dashboards
+const grafanaMetricPrefix = "mysql_load_test_"
+
+// grafanaDashboard is the part of the Grafana dashboard model the generated
+// dashboards use, see https://grafana.com/docs/grafana/latest/dashboards/build-dashboards/view-dashboard-json-model/
+type grafanaDashboard struct {
+	UID           string            `json:"uid"`
+	Title         string            `json:"title"`
+	Tags          []string          `json:"tags"`
+	Timezone      string            `json:"timezone"`
+	Refresh       string            `json:"refresh"`
+	SchemaVersion int               `json:"schemaVersion"`
+	Time          grafanaTimeRange  `json:"time"`
+	Templating    grafanaTemplating `json:"templating"`
+	Panels        []grafanaPanel    `json:"panels"`
+}
+
+type grafanaTimeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type grafanaTemplating struct {
+	List []grafanaVariable `json:"list"`
+}
+
+type grafanaVariable struct {
+	Name       string             `json:"name"`
+	Label      string             `json:"label"`
+	Type       string             `json:"type"`
+	Query      any                `json:"query"`
+	Datasource *grafanaDatasource `json:"datasource,omitempty"`
+	Refresh    int                `json:"refresh,omitempty"`
+	Multi      bool               `json:"multi,omitempty"`
+	IncludeAll bool               `json:"includeAll,omitempty"`
+	AllValue   string             `json:"allValue,omitempty"`
+}
+
+type grafanaDatasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type grafanaPanel struct {
+	ID          int                `json:"id"`
+	Type        string             `json:"type"`
+	Title       string             `json:"title"`
+	Description string             `json:"description,omitempty"`
+	GridPos     grafanaGridPos     `json:"gridPos"`
+	Datasource  grafanaDatasource  `json:"datasource"`
+	Targets     []grafanaTarget    `json:"targets"`
+	FieldConfig grafanaFieldConfig `json:"fieldConfig"`
+}
+
+type grafanaGridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type grafanaTarget struct {
+	RefID        string `json:"refId"`
+	Expr         string `json:"expr"`
+	LegendFormat string `json:"legendFormat,omitempty"`
+	Exemplar     bool   `json:"exemplar,omitempty"`
+}
+
+type grafanaFieldConfig struct {
+	Defaults struct {
+		Unit string `json:"unit"`
+	} `json:"defaults"`
+}
+
+// datasource and instance are dashboard variables, the Prometheus data
+// source is picked on import
+var grafanaDatasourceRef = grafanaDatasource{Type: "prometheus", UID: "${datasource}"}
+
+const grafanaInstanceFilter = `{instance=~"$instance"}`
+
+// newGrafanaDashboard builds a dashboard with a panel per load test metric
+// family of families, queried with the labels they actually carry
+func newGrafanaDashboard(families []*dto.MetricFamily) grafanaDashboard {
+	d := grafanaDashboard{
+		UID:           "mysql-load-test",
+		Title:         "MySQL Load Test",
+		Tags:          []string{"mysql", "load-test"},
+		Timezone:      "browser",
+		Refresh:       "10s",
+		SchemaVersion: 39,
+		Time:          grafanaTimeRange{From: "now-1h", To: "now"},
+		Templating: grafanaTemplating{List: []grafanaVariable{
+			{Name: "datasource", Label: "Data source", Type: "datasource", Query: "prometheus"},
+			{
+				Name: "instance", Label: "Instance", Type: "query",
+				Query:      map[string]string{"query": "label_values(" + grafanaMetricPrefix + "aggregate_qps, instance)"},
+				Datasource: &grafanaDatasourceRef,
+				Refresh:    2, Multi: true, IncludeAll: true, AllValue: ".*",
+			},
+		}},
+	}
+
+	sort.Slice(families, func(i, j int) bool { return families[i].GetName() < families[j].GetName() })
+	for _, mf := range families {
+		if !strings.HasPrefix(mf.GetName(), grafanaMetricPrefix) || len(mf.GetMetric()) == 0 {
+			continue
+		}
+		targets := grafanaTargets(mf)
+		if len(targets) == 0 {
+			continue
+		}
+		n := len(d.Panels)
+		panel := grafanaPanel{
+			ID:          n + 1,
+			Type:        "timeseries",
+			Title:       grafanaTitle(mf.GetName()),
+			Description: mf.GetHelp(),
+			GridPos:     grafanaGridPos{H: 8, W: 12, X: n % 2 * 12, Y: n / 2 * 8},
+			Datasource:  grafanaDatasourceRef,
+			Targets:     targets,
+		}
+		panel.FieldConfig.Defaults.Unit = grafanaUnit(mf)
+		d.Panels = append(d.Panels, panel)
+	}
+	return d
+}
+
+// grafanaTargets returns the queries of a panel, histograms as quantiles and
+// counters as rates, broken down by instance and every label of the family
+func grafanaTargets(mf *dto.MetricFamily) []grafanaTarget {
+	name := mf.GetName()
+	labels := append([]string{"instance"}, familyLabels(mf)...)
+	by := " by (" + strings.Join(labels, ", ") + ")"
+	legend := "{{" + strings.Join(labels, "}} {{") + "}}"
+
+	switch mf.GetType() {
+	case dto.MetricType_COUNTER:
+		return []grafanaTarget{{
+			RefID:        "A",
+			Expr:         fmt.Sprintf("sum%s (rate(%s%s[$__rate_interval]))", by, name, grafanaInstanceFilter),
+			LegendFormat: legend,
+		}}
+	case dto.MetricType_HISTOGRAM:
+		byLe := " by (" + strings.Join(append([]string{"le"}, labels...), ", ") + ")"
+		var targets []grafanaTarget
+		for i, q := range []string{"0.5", "0.99"} {
+			targets = append(targets, grafanaTarget{
+				RefID:        string(rune('A' + i)),
+				Expr:         fmt.Sprintf("histogram_quantile(%s, sum%s (rate(%s_bucket%s[$__rate_interval])))", q, byLe, name, grafanaInstanceFilter),
+				LegendFormat: "p" + strings.TrimPrefix(q, "0.") + " " + legend,
+				// exemplars link to the slow query log entries
+				Exemplar: true,
+			})
+		}
+		return targets
+	case dto.MetricType_GAUGE, dto.MetricType_UNTYPED, dto.MetricType_SUMMARY:
+		return []grafanaTarget{{
+			RefID:        "A",
+			Expr:         name + grafanaInstanceFilter,
+			LegendFormat: legend,
+		}}
+	}
+	return nil
+}
+
+// familyLabels returns the label names set on any metric of the family
+func familyLabels(mf *dto.MetricFamily) []string {
+	seen := make(map[string]bool)
+	var labels []string
+	for _, m := range mf.GetMetric() {
+		for _, l := range m.GetLabel() {
+			if !seen[l.GetName()] {
+				seen[l.GetName()] = true
+				labels = append(labels, l.GetName())
+			}
+		}
+	}
+	if mf.GetType() == dto.MetricType_SUMMARY {
+		labels = append(labels, "quantile")
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+func grafanaTitle(name string) string {
+	title := strings.ReplaceAll(strings.TrimPrefix(name, grafanaMetricPrefix), "_", " ")
+	return strings.ToUpper(title[:1]) + title[1:]
+}
+
+func grafanaUnit(mf *dto.MetricFamily) string {
+	name := strings.TrimSuffix(mf.GetName(), "_total")
+	switch {
+	case strings.HasSuffix(name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(name, "_bytes"):
+		return "bytes"
+	case mf.GetType() == dto.MetricType_COUNTER:
+		return "ops"
+	}
+	return "short"
+}
+
+// handleGrafanaDashboard serves the dashboard of the metrics emitted so far,
+// ready to import or provision
+func handleGrafanaDashboard(w http.ResponseWriter, r *http.Request) {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		http.Error(w, "error gathering metrics: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(newGrafanaDashboard(families))
+}
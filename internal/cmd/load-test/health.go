@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DataSourceReadiness tells how far a query data source got loading
+type DataSourceReadiness struct {
+	WeightsLoaded bool
+	Fingerprints  int
+	QueriesLoaded bool
+	Queries       int
+	// Lazy data sources fetch the queries on first use
+	Lazy         bool
+	CorpusMapped bool
+}
+
+// readinessReporter is implemented by the data sources that report their
+// loading progress
+type readinessReporter interface {
+	Readiness() DataSourceReadiness
+}
+
+// HealthCheck is the outcome of one check of /healthz
+type HealthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// HealthStatus is what /healthz returns, with 503 until Ready
+type HealthStatus struct {
+	Ready  bool                   `json:"ready"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+// Health answers /healthz, so orchestration can wait until the load test
+// produces load before shifting traffic
+type Health struct {
+	mu      sync.Mutex
+	source  QueryDataSource
+	target  *DBConn
+	started bool
+}
+
+// health of the current run
+var health = &Health{}
+
+// healthPingTimeout bounds the ping of the target database
+const healthPingTimeout = 2 * time.Second
+
+func (h *Health) SetDataSource(source QueryDataSource) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.source = source
+}
+
+func (h *Health) SetTarget(target *DBConn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.target = target
+}
+
+// MarkStarted records that the workers run
+func (h *Health) MarkStarted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.started = true
+}
+
+// Status runs the checks, pinging the target database
+func (h *Health) Status(ctx context.Context) HealthStatus {
+	h.mu.Lock()
+	source, target, started := h.source, h.target, h.started
+	h.mu.Unlock()
+
+	checks := make(map[string]HealthCheck)
+
+	switch r, ok := source.(readinessReporter); {
+	case source == nil:
+		msg := HealthCheck{Detail: "data source not created yet"}
+		checks["weights"], checks["queries"], checks["corpus"] = msg, msg, msg
+	case !ok:
+		// no progress to report, the workers only start once it is loaded
+		msg := HealthCheck{OK: started}
+		checks["weights"], checks["queries"], checks["corpus"] = msg, msg, msg
+	default:
+		readiness := r.Readiness()
+		checks["weights"] = HealthCheck{OK: readiness.WeightsLoaded, Detail: fmt.Sprintf("%d fingerprints", readiness.Fingerprints)}
+		if readiness.Lazy {
+			checks["queries"] = HealthCheck{OK: readiness.WeightsLoaded, Detail: "fetched on first use"}
+		} else {
+			checks["queries"] = HealthCheck{OK: readiness.QueriesLoaded, Detail: fmt.Sprintf("%d queries", readiness.Queries)}
+		}
+		checks["corpus"] = HealthCheck{OK: readiness.CorpusMapped}
+	}
+
+	if target == nil {
+		checks["target_db"] = HealthCheck{Detail: "not connected yet"}
+	} else {
+		pingCtx, cancel := context.WithTimeout(ctx, healthPingTimeout)
+		defer cancel()
+		if err := target.PingContext(pingCtx); err != nil {
+			checks["target_db"] = HealthCheck{Detail: err.Error()}
+		} else {
+			checks["target_db"] = HealthCheck{OK: true}
+		}
+	}
+
+	checks["workers"] = HealthCheck{OK: started}
+	if !started {
+		checks["workers"] = HealthCheck{Detail: "not started yet"}
+	}
+
+	status := HealthStatus{Ready: true, Checks: checks}
+	for _, check := range checks {
+		status.Ready = status.Ready && check.OK
+	}
+	return status
+}
+
+func (h *Health) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	status := h.Status(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	if !status.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
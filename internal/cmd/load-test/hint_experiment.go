@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// HintExperimentConfig rewrites a percentage of the executions of one
+// fingerprint so hinted and unhinted latencies can be compared
+type HintExperimentConfig struct {
+	Name            string  `mapstructure:"name" yaml:"name" validate:"required"`
+	FingerprintHash uint64  `mapstructure:"fingerprint_hash" yaml:"fingerprint_hash" validate:"required"`
+	Percentage      float64 `mapstructure:"percentage" yaml:"percentage" validate:"gte=0,lte=100"`
+	// OptimizerHint is inserted as /*+ ... */ after the leading keyword
+	OptimizerHint string `mapstructure:"optimizer_hint" yaml:"optimizer_hint" validate:"omitempty"`
+	// Find and Replace rewrite the query text, e.g. to add FORCE INDEX
+	Find    string `mapstructure:"find" yaml:"find" validate:"required_with=Replace"`
+	Replace string `mapstructure:"replace" yaml:"replace" validate:"omitempty"`
+	// OptimizerSwitch is set for the session running the hinted execution
+	OptimizerSwitch string `mapstructure:"optimizer_switch" yaml:"optimizer_switch" validate:"omitempty"`
+}
+
+type HintExperiments struct {
+	byFingerprint map[uint64]*HintExperimentConfig
+}
+
+func NewHintExperiments(cfgs []HintExperimentConfig) (*HintExperiments, error) {
+	he := &HintExperiments{byFingerprint: make(map[uint64]*HintExperimentConfig, len(cfgs))}
+	for i := range cfgs {
+		cfg := &cfgs[i]
+		if _, ok := he.byFingerprint[cfg.FingerprintHash]; ok {
+			return nil, fmt.Errorf("duplicate hint experiment for fingerprint %d", cfg.FingerprintHash)
+		}
+		if cfg.OptimizerHint == "" && cfg.Find == "" && cfg.OptimizerSwitch == "" {
+			return nil, fmt.Errorf("hint experiment %q has no hint", cfg.Name)
+		}
+		he.byFingerprint[cfg.FingerprintHash] = cfg
+	}
+	return he, nil
+}
+
+// Pick returns the experiment of the fingerprint, if any, and whether this
+// execution should be hinted
+func (he *HintExperiments) Pick(fingerprintHash uint64) (*HintExperimentConfig, bool) {
+	if he == nil {
+		return nil, false
+	}
+	cfg, ok := he.byFingerprint[fingerprintHash]
+	if !ok {
+		return nil, false
+	}
+	return cfg, rand.Float64()*100 < cfg.Percentage
+}
+
+// Rewrite applies the query text hints of the experiment
+func (cfg *HintExperimentConfig) Rewrite(query string) string {
+	if cfg.Find != "" {
+		query = strings.Replace(query, cfg.Find, cfg.Replace, 1)
+	}
+	if cfg.OptimizerHint != "" {
+		query = insertOptimizerHint(query, cfg.OptimizerHint)
+	}
+	return query
+}
+
+// insertOptimizerHint places the hint right after the leading statement
+// keyword, which is the only position MySQL accepts it
+func insertOptimizerHint(query, hint string) string {
+	start := len(query) - len(strings.TrimLeft(query, " \t\r\n("))
+	end := start
+	for end < len(query) && isKeywordByte(query[end]) {
+		end++
+	}
+	if end == start {
+		return query
+	}
+	return query[:end] + " /*+ " + hint + " */" + query[end:]
+}
+
+func isKeywordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// HistoryPoint is one aggregate of the run kept for the dashboard
+type HistoryPoint struct {
+	Time        time.Time `json:"time"`
+	QPS         float64   `json:"qps"`
+	DispatchQPS float64   `json:"dispatch_qps"`
+	Average     float64   `json:"average"`
+	LatP50      float64   `json:"query_latency_p50"`
+	LatP95      float64   `json:"query_latency_p95"`
+	LatP99      float64   `json:"query_latency_p99"`
+	NumRes      int64     `json:"num_res"`
+}
+
+// merge folds o into p. Throughput and averages are weighted by the number
+// of results; tail percentiles keep the worst value so spikes survive
+// downsampling.
+func (p *HistoryPoint) merge(o HistoryPoint, n int) {
+	w := float64(n)
+	p.QPS = (p.QPS*w + o.QPS) / (w + 1)
+	p.DispatchQPS = (p.DispatchQPS*w + o.DispatchQPS) / (w + 1)
+	total := p.NumRes + o.NumRes
+	if total > 0 {
+		p.Average = (p.Average*float64(p.NumRes) + o.Average*float64(o.NumRes)) / float64(total)
+		p.LatP50 = (p.LatP50*float64(p.NumRes) + o.LatP50*float64(o.NumRes)) / float64(total)
+	}
+	p.LatP95 = max(p.LatP95, o.LatP95)
+	p.LatP99 = max(p.LatP99, o.LatP99)
+	p.NumRes = total
+}
+
+type HistoryTierConfig struct {
+	// Resolution is the width of a point, zero keeps points as recorded
+	Resolution time.Duration
+	Retention  time.Duration
+}
+
+var defaultHistoryTiers = []HistoryTierConfig{
+	{Resolution: 0, Retention: 15 * time.Minute},
+	{Resolution: time.Minute, Retention: 6 * time.Hour},
+	{Resolution: 10 * time.Minute, Retention: 48 * time.Hour},
+}
+
+type historyTier struct {
+	cfg    HistoryTierConfig
+	points []HistoryPoint
+
+	pending      HistoryPoint
+	pendingCount int
+}
+
+func (t *historyTier) add(p HistoryPoint) {
+	if t.cfg.Resolution <= 0 {
+		t.points = append(t.points, p)
+	} else {
+		bucket := p.Time.Truncate(t.cfg.Resolution)
+		if t.pendingCount > 0 && !bucket.Equal(t.pending.Time) {
+			t.points = append(t.points, t.pending)
+			t.pendingCount = 0
+		}
+		if t.pendingCount == 0 {
+			t.pending = p
+			t.pending.Time = bucket
+		} else {
+			t.pending.merge(p, t.pendingCount)
+		}
+		t.pendingCount++
+	}
+
+	cutoff := p.Time.Add(-t.cfg.Retention)
+	i := 0
+	for i < len(t.points) && t.points[i].Time.Before(cutoff) {
+		i++
+	}
+	if i > 0 {
+		t.points = append(t.points[:0], t.points[i:]...)
+	}
+}
+
+// History keeps the aggregates of the run in tiers of decreasing resolution
+// so newly connected dashboards can show the whole run at bounded memory
+type History struct {
+	mu    sync.Mutex
+	tiers []*historyTier
+	last  time.Time
+}
+
+// NewHistory creates a history with tiers ordered from finest to coarsest
+func NewHistory(tiers []HistoryTierConfig) *History {
+	h := &History{}
+	for _, cfg := range tiers {
+		h.tiers = append(h.tiers, &historyTier{cfg: cfg})
+	}
+	return h
+}
+
+// Add records p in every tier. Points not newer than the last one are
+// ignored, so the same aggregate can be offered repeatedly.
+func (h *History) Add(p HistoryPoint) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !p.Time.After(h.last) {
+		return
+	}
+	h.last = p.Time
+	for _, t := range h.tiers {
+		t.add(p)
+	}
+}
+
+// Points returns the history oldest first, taking each time range from the
+// finest tier that still covers it
+func (h *History) Points() []HistoryPoint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var points []HistoryPoint
+	var covered time.Time
+	for _, t := range h.tiers {
+		var older []HistoryPoint
+		for _, p := range t.points {
+			if !covered.IsZero() && !p.Time.Before(covered) {
+				break
+			}
+			older = append(older, p)
+		}
+		if len(older) > 0 {
+			points = append(older, points...)
+		}
+		if len(t.points) > 0 && (covered.IsZero() || t.points[0].Time.Before(covered)) {
+			covered = t.points[0].Time
+		}
+	}
+	return points
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// snapshotPrefix names the shadow tables of the copy snapshot method
+const snapshotPrefix = "_mlt_snapshot_"
+
+// HooksConfig runs commands around the run and keeps the dataset replays
+// write to restorable, so every iteration of a capacity search, one
+// load-test invocation each, starts from the same data
+type HooksConfig struct {
+	// PreRun commands run through sh before the workers start, after the
+	// snapshot is taken or restored. The target is passed in MLT_TARGET_HOST,
+	// MLT_TARGET_PORT, MLT_TARGET_USER, MLT_TARGET_DATABASE and MYSQL_PWD.
+	PreRun []string `mapstructure:"pre_run" yaml:"pre_run" validate:"omitempty"`
+	// PostRun commands run once the workers stopped, not after a failed or
+	// interrupted run
+	PostRun  []string       `mapstructure:"post_run" yaml:"post_run" validate:"omitempty"`
+	Snapshot SnapshotConfig `mapstructure:"snapshot" yaml:"snapshot"`
+}
+
+// SnapshotConfig snapshots tables of the target database. The first run
+// takes the snapshot, every later run restores it before starting.
+type SnapshotConfig struct {
+	Tables []string `mapstructure:"tables" yaml:"tables" validate:"omitempty"`
+	// Method is copy, shadow tables next to the originals filled with
+	// INSERT ... SELECT, or mysqldump, a dump in File loaded back with the
+	// mysql client
+	Method string `mapstructure:"method" yaml:"method" validate:"omitempty,oneof=copy mysqldump"`
+	File   string `mapstructure:"file" yaml:"file" validate:"required_if=Method mysqldump"`
+	// RestoreAfter restores the snapshot once the run completed too
+	RestoreAfter bool `mapstructure:"restore_after" yaml:"restore_after"`
+}
+
+// Hooks runs the configured hooks against the target
+type Hooks struct {
+	cfg   HooksConfig
+	admin *AdminConn
+	dsn   *mysql.Config
+}
+
+func NewHooks(cfg HooksConfig, admin *AdminConn, dsn string) (*Hooks, error) {
+	parsed, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing target DSN: %w", err)
+	}
+	if len(cfg.Snapshot.Tables) > 0 && parsed.DBName == "" {
+		return nil, fmt.Errorf("the target DSN has no database to snapshot tables of")
+	}
+	if cfg.Snapshot.Method == "" {
+		cfg.Snapshot.Method = "copy"
+	}
+	return &Hooks{cfg: cfg, admin: admin, dsn: parsed}, nil
+}
+
+// PreRun takes or restores the snapshot, then runs the pre-run commands
+func (h *Hooks) PreRun(ctx context.Context) error {
+	if len(h.cfg.Snapshot.Tables) > 0 {
+		taken, err := h.snapshotTaken(ctx)
+		if err != nil {
+			return err
+		}
+		if taken {
+			logger.Info().Strs("tables", h.cfg.Snapshot.Tables).Str("method", h.cfg.Snapshot.Method).Msg("Restoring dataset snapshot")
+			err = h.restore(ctx)
+		} else {
+			logger.Info().Strs("tables", h.cfg.Snapshot.Tables).Str("method", h.cfg.Snapshot.Method).Msg("Taking dataset snapshot")
+			err = h.snapshot(ctx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return h.run(ctx, "pre_run", h.cfg.PreRun)
+}
+
+// PostRun runs the post-run commands, then restores the snapshot when asked
+func (h *Hooks) PostRun(ctx context.Context) error {
+	if err := h.run(ctx, "post_run", h.cfg.PostRun); err != nil {
+		return err
+	}
+	if len(h.cfg.Snapshot.Tables) > 0 && h.cfg.Snapshot.RestoreAfter {
+		logger.Info().Strs("tables", h.cfg.Snapshot.Tables).Msg("Restoring dataset snapshot")
+		return h.restore(ctx)
+	}
+	return nil
+}
+
+func (h *Hooks) run(ctx context.Context, stage string, commands []string) error {
+	for _, command := range commands {
+		logger.Info().Str("stage", stage).Str("command", command).Msg("Running hook")
+		cmd := exec.CommandContext(ctx, "sh", "-c", command)
+		cmd.Env = append(os.Environ(), h.env()...)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error running %s hook %q: %w", stage, command, err)
+		}
+	}
+	return nil
+}
+
+// env passes the target to the hooks, MYSQL_PWD is read by the mysql
+// clients
+func (h *Hooks) env() []string {
+	host, port := h.hostPort()
+	return []string{
+		"MLT_TARGET_HOST=" + host,
+		"MLT_TARGET_PORT=" + port,
+		"MLT_TARGET_USER=" + h.dsn.User,
+		"MLT_TARGET_DATABASE=" + h.dsn.DBName,
+		"MYSQL_PWD=" + h.dsn.Passwd,
+	}
+}
+
+// hostPort splits the target address, a unix socket is returned as host
+func (h *Hooks) hostPort() (string, string) {
+	host, port, err := net.SplitHostPort(h.dsn.Addr)
+	if err != nil {
+		return h.dsn.Addr, "3306"
+	}
+	return host, port
+}
+
+func (h *Hooks) snapshotTaken(ctx context.Context) (bool, error) {
+	if h.cfg.Snapshot.Method == "mysqldump" {
+		_, err := os.Stat(h.cfg.Snapshot.File)
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("error reading snapshot file: %w", err)
+		}
+		return true, nil
+	}
+	for _, table := range h.cfg.Snapshot.Tables {
+		row, err := h.admin.DB().QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?",
+			snapshotPrefix+table)
+		if err != nil {
+			return false, fmt.Errorf("error looking up snapshot table: %w", err)
+		}
+		var n int
+		if err := row.Scan(&n); err != nil {
+			return false, fmt.Errorf("error looking up snapshot table: %w", err)
+		}
+		if n == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (h *Hooks) snapshot(ctx context.Context) error {
+	if h.cfg.Snapshot.Method == "mysqldump" {
+		args := append([]string{"--single-transaction", "--skip-triggers"}, h.clientArgs()...)
+		args = append(args, h.cfg.Snapshot.Tables...)
+		file, err := os.Create(h.cfg.Snapshot.File)
+		if err != nil {
+			return fmt.Errorf("error creating snapshot file: %w", err)
+		}
+		defer file.Close()
+		if err := h.client(ctx, "mysqldump", args, file, nil); err != nil {
+			os.Remove(h.cfg.Snapshot.File)
+			return err
+		}
+		return nil
+	}
+	return h.copyTables(ctx, func(table string) []string {
+		shadow := quoteIdentifier(snapshotPrefix + table)
+		return []string{
+			"DROP TABLE IF EXISTS " + shadow,
+			"CREATE TABLE " + shadow + " LIKE " + quoteIdentifier(table),
+			"INSERT INTO " + shadow + " SELECT * FROM " + quoteIdentifier(table),
+		}
+	})
+}
+
+func (h *Hooks) restore(ctx context.Context) error {
+	if h.cfg.Snapshot.Method == "mysqldump" {
+		file, err := os.Open(h.cfg.Snapshot.File)
+		if err != nil {
+			return fmt.Errorf("error opening snapshot file: %w", err)
+		}
+		defer file.Close()
+		return h.client(ctx, "mysql", h.clientArgs(), nil, file)
+	}
+	return h.copyTables(ctx, func(table string) []string {
+		return []string{
+			"DELETE FROM " + quoteIdentifier(table),
+			"INSERT INTO " + quoteIdentifier(table) + " SELECT * FROM " + quoteIdentifier(snapshotPrefix+table),
+		}
+	})
+}
+
+// copyTables runs the statements of every table on a single connection
+// with the foreign key checks off, so the tables can be copied in any order
+func (h *Hooks) copyTables(ctx context.Context, statements func(table string) []string) error {
+	conn, err := h.admin.DB().Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting snapshot connection: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SET SESSION foreign_key_checks = 0"); err != nil {
+		return fmt.Errorf("error disabling foreign key checks: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SET SESSION foreign_key_checks = 1")
+	for _, table := range h.cfg.Snapshot.Tables {
+		for _, stmt := range statements(table) {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("error copying snapshot of table %s: %w", table, err)
+			}
+		}
+	}
+	return nil
+}
+
+// clientArgs points the mysql clients at the target database, the password
+// goes through MYSQL_PWD
+func (h *Hooks) clientArgs() []string {
+	args := []string{"--user=" + h.dsn.User}
+	if h.dsn.Net == "unix" {
+		args = append(args, "--socket="+h.dsn.Addr)
+	} else {
+		host, port := h.hostPort()
+		args = append(args, "--host="+host, "--port="+port, "--protocol=tcp")
+	}
+	return append(args, h.dsn.DBName)
+}
+
+func (h *Hooks) client(ctx context.Context, name string, args []string, stdout *os.File, stdin *os.File) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), "MYSQL_PWD="+h.dsn.Passwd)
+	cmd.Stderr = os.Stderr
+	if stdout != nil {
+		cmd.Stdout = stdout
+	}
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("error running %s: %w", name, err)
+	}
+	return nil
+}
@@ -2,18 +2,26 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
+
+	myerror "mysql-load-test/internal/error"
+	"mysql-load-test/pkg/mysqlwire"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 func createDataSource(cfg *Config) (QueryDataSource, error) {
 	switch cfg.QueriesDataSource.Type {
 	case "db":
-		return NewQuerySourceDB(&cfg.QueriesDataSource.QueryDataSourceDB, cfg.Concurrency, nil)
+		return NewQuerySourceDB(&cfg.QueriesDataSource.QueryDataSourceDB, cfg.Concurrency, nil, cfg.QueriesDataSource.WeightSmoothing, cfg.QueriesDataSource.TimeOfDay, cfg.QueriesDataSource.Tags)
 	// case "inline":
 	// 	return NewQuerySourceInline(cfg.QueryDataSourceDB)
 	default:
@@ -21,9 +29,24 @@ func createDataSource(cfg *Config) (QueryDataSource, error) {
 	}
 }
 
-func performLoadTest() error {
+var (
+	// errCountReached ends a run whose workers executed its count
+	errCountReached = errors.New("query count reached")
+	// errDurationElapsed ends a run once its duration elapsed
+	errDurationElapsed = errors.New("run duration elapsed")
+	// errInterrupted ends a run on SIGINT or SIGTERM
+	errInterrupted = errors.New("interrupted by user")
+)
+
+// performLoadTest runs the load test of config until it ends or is
+// interrupted
+func performLoadTest(config Config) (err error) {
 	ctx, cancel := context.WithCancelCause(context.Background())
 	defer cancel(nil)
+	logger := logger
+	if config.workload != "" {
+		logger = logger.With().Str("workload", config.workload).Logger()
+	}
 
 	dbConn := NewDBConn(RetryConfig{
 		MaxRetries:      1,                      // Retry up to 3 times
@@ -31,19 +54,75 @@ func performLoadTest() error {
 		MaxDelay:        5 * time.Second,        // Cap at 5 seconds
 		BackoffFactor:   2.0,                    // Double delay each retry
 		ConnectionCheck: true,                   // Ping before queries
+
+		Jitter:                  config.Reconnect.Jitter,
+		MaxConcurrentReconnects: config.Reconnect.MaxConcurrent,
+		ReconnectStorm:          config.Reconnect.Storm,
 	})
+	// tunneledDSN reaches the target through the tunnel when one is
+	// configured, targetDSN is also balanced over its addresses
+	tunneledDSN := config.DBDSN
+	tunnel, err := NewTunnel(config.DBTunnel)
+	if err != nil {
+		return fmt.Errorf("error setting up the tunnel: %w", err)
+	}
+	if tunnel != nil {
+		defer tunnel.Close()
+		if tunneledDSN, err = tunnel.DSN(config.DBDSN); err != nil {
+			return err
+		}
+		logger.Info().Str("ssh", config.DBTunnel.SSH).Str("socks", redactURL(config.DBTunnel.SOCKS)).Msg("Reaching the target through a tunnel")
+	}
+	targetDSN := tunneledDSN
+	var balancer *EndpointBalancer
+	if config.Endpoints.ResolveInterval > 0 {
+		dsn, err := mysql.ParseDSN(config.DBDSN)
+		if err != nil {
+			return fmt.Errorf("error parsing target DSN: %w", err)
+		}
+		if balancer, err = NewEndpointBalancer(dsn.Addr); err != nil {
+			return err
+		}
+		if tunnel != nil {
+			balancer.SetDialContext(tunnel.DialContext)
+		}
+		if targetDSN, err = balancer.BalancedDSN(tunneledDSN); err != nil {
+			return err
+		}
+		go balancer.Run(ctx, config.Endpoints.ResolveInterval)
+		logger.Info().Dur("resolve_interval", config.Endpoints.ResolveInterval).Msg("Balancing connections over the resolved target addresses")
+	}
+
+	// Start metrics server if enabled, before loading so /healthz answers
+	// while the generator gets ready
+	var metricsServer *MetricsServer
+	if config.Metrics.Enabled {
+		control.Configure(config.Metrics.Control)
+		metricsServer = NewMetricsServer(config.Metrics.Addr)
+		if err := metricsServer.Start(ctx); err != nil {
+			return fmt.Errorf("error starting metrics server: %w", err)
+		}
+		logger.Info().Str("addr", config.Metrics.Addr).Msg("Metrics server started - visit the dashboard at http://" + config.Metrics.Addr)
+	}
+
 	logger.Info().Msg("Opening connection to target database")
-	if err := dbConn.OpenWithTimeout(ctx, config.DBDSN, config.Concurrency, 5*time.Second); err != nil {
+	if err := dbConn.OpenWithTimeout(ctx, targetDSN, config.Concurrency, 5*time.Second); err != nil {
 		return fmt.Errorf("error opening database connection: %w", err)
 	}
 	defer dbConn.Close()
 	logger.Info().Msg("Connection to target database opened")
+	if !config.secondary {
+		health.SetTarget(dbConn)
+	}
 
 	logger.Info().Str("data_source_type", config.QueriesDataSource.Type).Msg("Creating query data source")
 	qds, qdsCreateErr := createDataSource(&config)
 	if qdsCreateErr != nil {
 		return fmt.Errorf("error creating query data source: %w", qdsCreateErr)
 	}
+	if !config.secondary {
+		health.SetDataSource(qds)
+	}
 	qdsInitErr := qds.Init(ctx)
 	if qdsInitErr != nil {
 		return fmt.Errorf("error initializing query data source: %w", qdsInitErr)
@@ -51,28 +130,29 @@ func performLoadTest() error {
 	// defer qds.Destroy()
 	logger.Info().Msg("Query data source ready")
 
-	// Start metrics server if enabled
-	var metricsServer *MetricsServer
-	if config.Metrics.Enabled {
-		metricsServer = NewMetricsServer(config.Metrics.Addr)
-		if err := metricsServer.Start(ctx); err != nil {
-			return fmt.Errorf("error starting metrics server: %w", err)
-		}
-		logger.Info().Str("addr", config.Metrics.Addr).Msg("Metrics server started - visit the dashboard at http://" + config.Metrics.Addr)
+	reporters, err := newReporters(config.Reporters, config.Reporting, metricsServer)
+	if err != nil {
+		return fmt.Errorf("error creating reporters: %w", err)
 	}
 
-	var qpsTicker *time.Ticker
+	var pacer *Pacer
+	if config.QPS > 0 && config.RunMode == "replay" {
+		return fmt.Errorf("the replay run mode follows the capture timestamps, set replay.speed instead of qps")
+	}
 	if config.QPS > 0 {
-		qpsTicker = time.NewTicker(time.Second / time.Duration(config.QPS))
-		defer qpsTicker.Stop()
+		pacer = NewPacer(config.QPS, config.Burst, config.Concurrency)
+		go pacer.Run(ctx)
 	}
 
 	resultsChan := make(chan *QueryResult, config.Concurrency*100)
-	fatalErrsChan := make(chan error)
+	// the reader stops at the first error, the buffer holds one error per
+	// worker plus the read your writes scenario so no sender blocks after it
+	fatalErrsChan := make(chan error, config.Concurrency+1)
 
 	go func() {
 		for err := range fatalErrsChan {
-			logger.Error().Err(err).Msg("Fatal error")
+			logger.Error().Stack().Err(err).Fields(myerror.Context(err)).Msg("Fatal error")
+			annotations.Add("load-test", "Fatal error: "+err.Error(), nil)
 			cancel(err)
 			return
 		}
@@ -80,48 +160,398 @@ func performLoadTest() error {
 
 	var wg sync.WaitGroup
 
-	querier := NewQuerier(qds, qpsTicker, &logger, dbConn, resultsChan)
+	var execLog *ExecutionLog
+	if config.ExecutionLog.File != "" {
+		var err error
+		execLog, err = NewExecutionLog(config.ExecutionLog)
+		if err != nil {
+			return err
+		}
+		defer execLog.Close()
+		logger.Info().Str("file", config.ExecutionLog.File).Float64("sample_rate", config.ExecutionLog.SampleRate).Msg("Writing execution log")
+	}
 
-	wg.Add(config.Concurrency)
+	var slowLog *SlowLog
+	if config.SlowLog.File != "" {
+		dsn, err := mysql.ParseDSN(config.DBDSN)
+		if err != nil {
+			return fmt.Errorf("error parsing target DSN: %w", err)
+		}
+		slowLog, err = NewSlowLog(config.SlowLog, dsn.Addr)
+		if err != nil {
+			return err
+		}
+		defer slowLog.Close()
+		logger.Info().Str("file", config.SlowLog.File).Dur("threshold", config.SlowLog.Threshold).Str("run_id", slowLog.RunID()).Msg("Writing slow query log")
+	}
+
+	admin, err := NewAdminConn(ctx, tunneledDSN, config.Admin.PoolSize)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	if config.Admin.StatusInterval > 0 {
+		go admin.PollStatus(ctx, config.Admin.StatusInterval)
+	}
+
+	hooks, err := NewHooks(config.Hooks, admin, config.DBDSN)
+	if err != nil {
+		return err
+	}
+	if err := hooks.PreRun(ctx); err != nil {
+		return err
+	}
+	// the post-run hooks also run when the run fails, so a destructive
+	// replay still gets its snapshot restored
+	defer func() {
+		if hookErr := hooks.PostRun(context.Background()); hookErr != nil {
+			err = errors.Join(err, hookErr)
+		}
+	}()
+
+	var consistency *ConsistencyChecker
+	if config.Consistency.Enabled {
+		consistency = NewConsistencyChecker(config.Consistency, admin)
+		if err := consistency.Before(ctx); err != nil {
+			return err
+		}
+	}
+
+	metadata, err := collectRunMetadata(ctx, admin, config)
+	if err != nil {
+		logger.Warn().Err(err).Msg("Error collecting run metadata")
+	} else {
+		logger.Info().
+			Str("target_version", metadata.Target.Version).
+			Str("corpus", metadata.Corpus.Name).
+			Str("corpus_sha256", metadata.Corpus.SHA256).
+			Msg("Collected run metadata")
+	}
+
+	var planDiffer *PlanDiffer
+	if config.PlanDiff.CompareDSN != "" {
+		compareConn := NewDBConn(RetryConfig{
+			MaxRetries:   1,
+			InitialDelay: 100 * time.Millisecond,
+			MaxDelay:     5 * time.Second,
+		})
+		logger.Info().Msg("Opening connection to compare database")
+		if err := compareConn.OpenWithTimeout(ctx, config.PlanDiff.CompareDSN, config.Concurrency, 5*time.Second); err != nil {
+			return fmt.Errorf("error opening compare database connection: %w", err)
+		}
+		defer compareConn.Close()
+		planDiffer = NewPlanDiffer(config.PlanDiff, admin, compareConn)
+		logger.Info().Float64("sample_rate", config.PlanDiff.SampleRate).Msg("Diffing EXPLAIN plans against compare database")
+	}
+
+	var analyzer *ExplainAnalyzer
+	if config.ExplainAnalyze.SampleRate > 0 {
+		if analyzer, err = NewExplainAnalyzer(ctx, config.ExplainAnalyze, admin); err != nil {
+			return err
+		}
+		if analyzer != nil {
+			logger.Info().Float64("sample_rate", config.ExplainAnalyze.SampleRate).Msg("Capturing EXPLAIN ANALYZE estimation errors")
+		}
+	}
+
+	var experiments *HintExperiments
+	if len(config.HintExperiments) > 0 {
+		var err error
+		experiments, err = NewHintExperiments(config.HintExperiments)
+		if err != nil {
+			return fmt.Errorf("error loading hint experiments: %w", err)
+		}
+		logger.Info().Int("count", len(config.HintExperiments)).Msg("Running hint experiments")
+	}
+
+	var readYourWrites *ReadYourWrites
+	if config.ReadYourWrites.Enabled {
+		rywCfg := config.ReadYourWrites
+		writer := NewDBConn(RetryConfig{
+			MaxRetries:   1,
+			InitialDelay: 100 * time.Millisecond,
+			MaxDelay:     5 * time.Second,
+		})
+		if err := writer.OpenWithTimeout(ctx, tunneledDSN, max(rywCfg.Workers, 1), 5*time.Second); err != nil {
+			return fmt.Errorf("error opening read your writes connection: %w", err)
+		}
+		defer writer.Close()
+		reader := writer
+		if rywCfg.ReadDSN != "" {
+			readDSN := rywCfg.ReadDSN
+			if tunnel != nil {
+				if readDSN, err = tunnel.DSN(readDSN); err != nil {
+					return err
+				}
+			}
+			reader = NewDBConn(RetryConfig{
+				MaxRetries:   1,
+				InitialDelay: 100 * time.Millisecond,
+				MaxDelay:     5 * time.Second,
+			})
+			if err := reader.OpenWithTimeout(ctx, readDSN, max(rywCfg.Workers, 1), 5*time.Second); err != nil {
+				return fmt.Errorf("error opening read your writes read connection: %w", err)
+			}
+			defer reader.Close()
+		}
+		readYourWrites, err = NewReadYourWrites(rywCfg, writer, reader)
+		if err != nil {
+			return err
+		}
+		go func() {
+			if err := readYourWrites.Run(ctx); err != nil {
+				fatalErrsChan <- err
+			}
+		}()
+		logger.Info().Bool("replica_reads", rywCfg.ReadDSN != "").Msg("Running read your writes scenario")
+	}
+
+	var rawPool *mysqlwire.Pool
+	var rawConfig *mysqlwire.Config
+	if config.ExecutionEngine == "raw" {
+		dsn, err := mysql.ParseDSN(config.DBDSN)
+		if err != nil {
+			return fmt.Errorf("error parsing target DSN: %w", err)
+		}
+		rawConfig = &mysqlwire.Config{
+			Net:         dsn.Net,
+			Addr:        dsn.Addr,
+			User:        dsn.User,
+			Password:    dsn.Passwd,
+			DBName:      dsn.DBName,
+			DialTimeout: 5 * time.Second,
+
+			MaxResultRows:  config.MaxResultRows,
+			MaxResultBytes: config.MaxResultBytes,
+		}
+		if balancer != nil {
+			rawConfig.DialContext = balancer.DialContext
+		} else if tunnel != nil {
+			rawConfig.DialContext = tunnel.DialContext
+		}
+		rawPool = mysqlwire.NewPool(*rawConfig, config.Concurrency)
+		defer rawPool.Close()
+		logger.Info().Msg("Executing queries with the raw wire protocol client")
+	}
+
+	var conns *workerConns
+	// a replayed session runs on a connection of its own
+	if config.ConnectionMode == ConnectionModePerWorker || config.RunMode == "session" {
+		conns = newWorkerConns(config.Concurrency, dbConn, rawConfig)
+		logger.Info().Msg("Each worker owns a dedicated connection")
+	}
+
+	var schemas *SchemaRouter
+	if len(config.TargetSchemas) > 0 {
+		var err error
+		schemas, err = NewSchemaRouter(config.TargetSchemas, config.SourceSchema)
+		if err != nil {
+			return fmt.Errorf("error loading target schemas: %w", err)
+		}
+		logger.Info().Int("count", schemas.Len()).Msg("Fanning queries out over target schemas")
+	}
+
+	var tenants *TenantRewriter
+	if config.TenantRewrite.Column != "" {
+		var err error
+		tenants, err = NewTenantRewriter(config.TenantRewrite)
+		if err != nil {
+			return fmt.Errorf("error loading tenant rewrite: %w", err)
+		}
+		logger.Info().Str("column", config.TenantRewrite.Column).Int64("min", config.TenantRewrite.Min).Int64("max", config.TenantRewrite.Max).Msg("Rewriting tenant ids")
+	}
+
+	querier := NewQuerier(qds, pacer, config.Concurrency, &logger, dbConn, resultsChan)
+	querier.SetRawPool(rawPool)
+	querier.SetWorkerConns(conns)
+	querier.SetExecutionLog(execLog)
+	querier.SetSlowLog(slowLog)
+	querier.SetWarningsSampleRate(config.Warnings.SampleRate)
+	querier.SetPlanDiffer(planDiffer)
+	querier.SetExplainAnalyzer(analyzer)
+	querier.SetHintExperiments(experiments)
+	querier.SetSchemaRouter(schemas)
+	querier.SetTenantRewriter(tenants)
+	querier.SetResultLimits(ResultLimits{
+		MaxRows:  config.MaxResultRows,
+		MaxBytes: config.MaxResultBytes,
+	})
+	if balancer != nil {
+		querier.SetEndpointHosts(newEndpointHosts())
+	}
+	querier.SetCount(config.Count)
+	if config.RunMode == "sequential" {
+		sequence, err := NewSequence(qds, config.Sequential, config.Concurrency)
+		if err != nil {
+			return fmt.Errorf("error sharding the corpus: %w", err)
+		}
+		querier.SetSequence(sequence)
+		logger.Info().Int("queries", len(sequence.queries)).Str("sharding", sequence.sharding).Bool("loop", sequence.loop).Msg("Replaying the corpus sequentially")
+	}
+	if config.RunMode == "session" {
+		sequence, err := NewSessionSequence(ctx, qds, config.Sessions, config.Concurrency)
+		if err != nil {
+			return fmt.Errorf("error grouping the corpus by session: %w", err)
+		}
+		querier.SetSequence(sequence)
+		logger.Info().Int("queries", len(sequence.queries)).Int("sessions", sequence.Sessions()).Bool("loop", sequence.loop).Msg("Replaying the corpus session by session")
+	}
+	if config.RunMode == "replay" {
+		timeline, err := NewTimeline(ctx, qds, config.Replay)
+		if err != nil {
+			return fmt.Errorf("error building the replay timeline: %w", err)
+		}
+		querier.SetTimeline(timeline)
+		logger.Info().Int("queries", len(timeline.queries)).Float64("speed", timeline.speed).Dur("duration", timeline.Duration()).Bool("loop", timeline.loop).Msg("Replaying the corpus at its capture times")
+	}
+	conflicts, err := NewWriteConflicts(config.WriteConflicts)
+	if err != nil {
+		return fmt.Errorf("error loading write conflicts: %w", err)
+	}
+	if conflicts != nil {
+		querier.SetWriteConflicts(conflicts)
+		logger.Info().Strs("columns", config.WriteConflicts.Columns).Int64("range", config.WriteConflicts.Range).Float64("conflict_rate", config.WriteConflicts.ConflictRate).Msg("Remapping write keys to disjoint ranges per worker")
+	}
+
+	annotations.Add("load-test", "Load test started", map[string]string{
+		"concurrency": strconv.Itoa(config.Concurrency),
+		"qps":         strconv.Itoa(config.QPS),
+	})
+
+	var warmup *WarmupDetector
+	if config.Warmup.Enabled {
+		warmup = NewWarmupDetector(config.Warmup, admin)
+		go warmup.Run(ctx)
+	}
+
+	var soak *soakMonitor
+	if config.Soak.Enabled {
+		var err error
+		if soak, err = newSoakMonitor(config.Soak); err != nil {
+			return err
+		}
+		logger.Info().Str("flush_dir", soak.cfg.FlushDir).Dur("flush_interval", soak.cfg.FlushInterval).Msg("Running in soak mode")
+	}
+
+	var advisor *ConcurrencyAdvisor
+	if config.Advisor.Enabled {
+		advisor = NewConcurrencyAdvisor(config.Advisor, querier, config.Concurrency)
+		querier.SetActiveWorkers(1)
+		go advisor.Calibrate(ctx)
+	}
+	// the control API, health and maintenance windows are process-wide,
+	// they follow the first workload
+	if !config.secondary {
+		control.Attach(querier, pacer, advisor, config.Concurrency, cancel)
+		maintenance.Configure(config.Maintenance)
+		maintenance.Schedule(ctx, config.Maintenance.Windows)
+	}
+
+	// the run ends once every worker replayed its shard or the timeline, or
+	// the count is executed, workers only stop early for these
+	var running atomic.Int64
+	running.Store(int64(config.Concurrency))
+	if config.Duration > 0 {
+		stop := time.AfterFunc(config.Duration, func() {
+			logger.Info().Dur("duration", config.Duration).Msg("Run duration elapsed")
+			cancel(errDurationElapsed)
+		})
+		defer stop.Stop()
+	}
+
+	// the workers are the only senders of results, the reporter ends once
+	// they all stopped
+	var workers sync.WaitGroup
+	workers.Add(config.Concurrency)
 	for i := 0; i < config.Concurrency; i++ {
 		go func() {
-			defer wg.Done()
+			defer workers.Done()
 			logger.Info().Int("goroutine_id", i).Msg("Starting querier goroutine")
-			if err := querier.Run(ctx); err != nil {
+			health.MarkStarted()
+			if err := querier.Run(ctx, i); err != nil {
 				fatalErrsChan <- fmt.Errorf("error running querier: %w", err)
 				return
 			}
+			if ctx.Err() == nil && running.Add(-1) == 0 {
+				if querier.CountReached() {
+					logger.Info().Int("count", config.Count).Msg("Every worker stopped at the query count")
+					cancel(errCountReached)
+					return
+				}
+				logger.Info().Msg("Every worker replayed its shard")
+				cancel(errSequentialDone)
+			}
 		}()
 	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		workers.Wait()
+		if consistency != nil {
+			consistency.Stopped()
+		}
+		close(resultsChan)
+	}()
 
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
 		r := newReport(resultsChan)
+		r.Workload = config.workload
+		r.ActiveConnections = config.Concurrency
+		if !config.secondary {
+			r.maintenance = maintenance
+		}
+		r.advisor = advisor
+		r.Metadata = metadata
+		r.balancer = balancer
+		r.readYourWrites = readYourWrites
+		r.consistency = consistency
+		r.analyzer = analyzer
+		r.soak = soak
+		if tagger, ok := qds.(fingerprintTagger); ok {
+			r.tags = tagger.FingerprintTags()
+		}
+		if warmup != nil {
+			r.warmup, r.Warming = warmup, true
+		}
 		logger.Info().Msg("Starting reporter")
-		runReporter(r, ctx, qds, querier, metricsServer)
+		runReporter(r, qds, querier, planDiffer, admin, reporters)
 	}()
 
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
 
+	// a fatal cause is returned once the workers stopped and the final report
+	// is written
+	var fatal error
 	select {
 	case <-ctx.Done():
-		if err := context.Cause(ctx); err != nil && err.Error() != "interrupted by user" {
-			return err
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, errStoppedByControl) && !errors.Is(cause, errSequentialDone) && !errors.Is(cause, errCountReached) && !errors.Is(cause, errDurationElapsed) {
+			fatal = cause
 		}
 	case <-signalChan:
-		fmt.Println("Received SIGTERM/SIGINT, exiting...")
-		return nil
+		// a second signal kills the process without waiting for the final
+		// report
+		signal.Stop(signalChan)
+		fmt.Println("Received SIGTERM/SIGINT, writing the final report...")
+		cancel(errInterrupted)
 	}
 
 	wg.Wait()
 	qds.Destroy()
+	if conns != nil {
+		conns.Close()
+	}
 
-	return nil
+	return fatal
 }
 
 // Add this method to QuerierInternalPerfStats
 func (st *QuerierInternalPerfStats) GetTotalQueries() int {
-	return st.getRandomWeightedQueryLats_rb.Count()
+	total := 0
+	for _, lats := range st.getRandomWeightedQueryLats {
+		total += lats.Count()
+	}
+	return total
 }
@@ -2,9 +2,12 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"time"
 
+	myerror "mysql-load-test/internal/error"
+
 	"github.com/go-playground/validator/v10"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -19,9 +22,11 @@ var (
 	logger   zerolog.Logger
 )
 
-func setupLogger() {
+// setupLogger logs to the console, and as JSON lines to files
+func setupLogger(files ...io.Writer) {
 	// Configure zerolog
 	zerolog.TimeFieldFormat = time.RFC3339
+	zerolog.ErrorStackMarshaler = myerror.MarshalStack
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 
 	// Pretty console output
@@ -31,8 +36,13 @@ func setupLogger() {
 		NoColor:    false,
 	}
 
+	var w io.Writer = output
+	if len(files) > 0 {
+		w = zerolog.MultiLevelWriter(append([]io.Writer{output}, files...)...)
+	}
+
 	// Create logger with context
-	logger = zerolog.New(output).
+	logger = zerolog.New(w).
 		With().
 		Timestamp().
 		Str("app", "mysql-load-test").
@@ -74,14 +84,29 @@ var rootCmd = &cobra.Command{
 		logger.Info().
 			Str("db_dsn", maskDSN(config.DBDSN)).
 			Int("count", config.Count).
+			Dur("duration", config.Duration).
 			Int("concurrency", config.Concurrency).
 			Str("run_mode", config.RunMode).
 			Int("qps", config.QPS).
-			// Str("reporting_format", config.Reporting.Format).
-			// Str("reporting_file", config.Reporting.OutFile).
+			Str("reporting_file", config.Reporting.OutFile).
 			Msg("Configuration loaded successfully")
 
-		return performLoadTest()
+		run := performLoadTest
+		if len(config.Workloads) > 0 {
+			run = runWorkloads
+		}
+		if config.OutputDir == "" {
+			return run(config)
+		}
+		runDir, err := prepareRunDir(&config)
+		if err != nil {
+			return err
+		}
+		err = run(config)
+		if finishErr := runDir.Finish(err); finishErr != nil {
+			logger.Error().Err(finishErr).Msg("Error completing the run manifest")
+		}
+		return err
 	},
 }
 
@@ -102,22 +127,136 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.yaml)")
 	rootCmd.PersistentFlags().String("db-dsn", "", "Database DSN (can also be set via config file)")
 	rootCmd.PersistentFlags().Int("count", 0, "Number of queries to execute (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("duration", 0, "Stop the run after this long and write the final report, 0 runs until stopped (can also be set via config file)")
 	rootCmd.PersistentFlags().Int("concurrency", 0, "Number of concurrent workers (can also be set via config file)")
-	rootCmd.PersistentFlags().String("run-mode", "", "Run mode: sequential or random (can also be set via config file)")
+	rootCmd.PersistentFlags().String("run-mode", "", "Run mode: sequential, random, session or replay (can also be set via config file)")
+	rootCmd.PersistentFlags().String("sequential-sharding", "modulo", "How the sequential run mode shards the corpus over the workers: modulo or range (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("sequential-loop", false, "Replay the shard of a worker again once done instead of ending the run (can also be set via config file)")
 	rootCmd.PersistentFlags().Int("qps", 0, "Queries per second (can also be set via config file)")
+	rootCmd.PersistentFlags().String("arrivals", "uniform", "Query arrivals at the QPS rate: uniform or poisson (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("spike-multiplier", 0, "Multiply the QPS by this factor during spikes, 0 disables (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("spike-duration", 30*time.Second, "Length of each spike (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("spike-interval", 5*time.Minute, "Time between the starts of two spikes (can also be set via config file)")
+	rootCmd.PersistentFlags().String("execution-engine", "sql", "Query execution engine: sql (database/sql) or raw (native wire protocol client) (can also be set via config file)")
+	rootCmd.PersistentFlags().String("connection-mode", "pooled", "Connection mode: pooled (workers share the pool) or per_worker (each worker owns one connection, like sysbench) (can also be set via config file)")
+	rootCmd.PersistentFlags().StringSlice("target-schemas", nil, "Schemas to run queries against round-robin, ranges like shard_001..shard_064 are expanded (can also be set via config file)")
+	rootCmd.PersistentFlags().String("source-schema", "", "Schema the queries were collected from, qualified references are rewritten to the target schema (can also be set via config file)")
+	rootCmd.PersistentFlags().Int64("max-result-rows", 0, "Stop reading SELECT results after this many rows, 0 is unlimited (can also be set via config file)")
+	rootCmd.PersistentFlags().Int64("max-result-bytes", 0, "Stop reading SELECT results after this many bytes, 0 is unlimited (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("max-fingerprint-weight", 0, "Cap the weight of any fingerprint at this percent, 0 disables (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("min-fingerprint-weight", 0, "Raise the weight of rare fingerprints to at least this percent, 0 disables (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("time-of-day", false, "Shift the query mix over the run to follow the captured per hour weights (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("time-of-day-hour-duration", time.Hour, "Run time one captured hour of day lasts in time of day replay (can also be set via config file)")
+	rootCmd.PersistentFlags().Int("time-of-day-start-hour", 0, "Captured hour of day (UTC) the time of day replay starts at (can also be set via config file)")
+	rootCmd.PersistentFlags().StringSlice("include-tag", nil, "Only run fingerprints with one of these name=value tags (can also be set via config file)")
+	rootCmd.PersistentFlags().StringSlice("exclude-tag", nil, "Skip fingerprints with any of these name=value tags (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("advisor-enabled", false, "Ramp the concurrency up from 1 at start and warn when the configured concurrency exceeds what the target can digest (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("advisor-step", 5*time.Second, "Duration of each concurrency level of the calibration (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("warmup-enabled", false, "Discard results until the InnoDB buffer pool miss rate settles (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("warmup-miss-rate", 0.001, "Buffer pool miss rate below which the target counts as warm (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("warmup-max-duration", 30*time.Minute, "Start measuring after this long even if the miss rate never settles (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("endpoints-resolve-interval", 0, "Re-resolve the target host at this interval and balance connections over its addresses, 0 disables (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("reconnect-jitter", 0.5, "Randomly shorten each reconnect backoff by up to this fraction (can also be set via config file)")
+	rootCmd.PersistentFlags().Int("reconnect-max-concurrent", 1, "Maximum reconnect attempts in flight (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("reconnect-storm", false, "Let every failing worker reconnect at once without jitter, to reproduce a reconnection storm (can also be set via config file)")
 	rootCmd.PersistentFlags().String("log-level", "info", "Log level (debug, info, warn, error)")
 	rootCmd.PersistentFlags().Bool("metrics-enabled", false, "Enable Prometheus metrics server (can also be set via config file)")
 	rootCmd.PersistentFlags().String("metrics-addr", ":2112", "Address to listen on for metrics server (can also be set via config file)")
+	rootCmd.PersistentFlags().String("execution-log-file", "", "Write sampled query executions as NDJSON to this file (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("execution-log-sample-rate", 0.01, "Fraction of query executions written to the execution log (can also be set via config file)")
+	rootCmd.PersistentFlags().String("slow-log-file", "", "Write every execution slower than the slow log threshold as NDJSON to this file (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("slow-log-threshold", time.Second, "Executions at or above this latency go to the slow log (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("report-console", false, "Log every report aggregate to the console (can also be set via config file)")
+	rootCmd.PersistentFlags().String("report-json-file", "", "Keep the latest full report as JSON in this file (can also be set via config file)")
+	rootCmd.PersistentFlags().String("report-statsd-addr", "", "Send report aggregates as StatsD gauges to this UDP address (can also be set via config file)")
+	rootCmd.PersistentFlags().String("output-dir", "", "Write the report, aggregates CSV, slow and execution logs, config snapshot and logs of every run to a run-YYYYMMDD-HHMM directory in this directory, with a manifest.json (can also be set via config file)")
+	rootCmd.PersistentFlags().String("results-db", "", "Write aggregates, fingerprint stats, errors and annotations to this SQLite database (can also be set via config file)")
+	rootCmd.PersistentFlags().String("trends-db", "", "Keep a summary row of the run in this trends database, a SQLite file or a mysql:// DSN, read by mlt trends (can also be set via config file)")
+	rootCmd.PersistentFlags().String("trends-target", "", "Name of the target in the trends database, the address and database of the DSN by default (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("control", false, "Let the web UI change the QPS and concurrency, pause and stop the run (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("soak", false, "Soak mode for runs of days: roll errors up without query text, flush partial reports and check the generator memory stays flat (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("soak-flush-interval", time.Hour, "How often soak mode writes a partial report (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("redact", false, "Strip query texts and mask hosts and DSNs in the JSON report and results database, to share them outside the team (can also be set via config file)")
+	rootCmd.PersistentFlags().String("pushgateway-url", "", "Push all metrics to this Pushgateway when the run ends (can also be set via config file)")
+	rootCmd.PersistentFlags().String("remote-write-url", "", "Send the report aggregates to this Prometheus remote write endpoint (can also be set via config file)")
+	rootCmd.PersistentFlags().Int("admin-pool-size", 2, "Size of the connection pool used for observability queries (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("admin-status-interval", 5*time.Second, "Interval between SHOW GLOBAL STATUS polls (can also be set via config file)")
+	rootCmd.PersistentFlags().String("plan-diff-dsn", "", "DSN of a second target whose EXPLAIN plans are compared against the main target (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("plan-diff-sample-rate", 0.01, "Fraction of query executions explained on both targets (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("plan-diff-rows-ratio", 2, "Minimum ratio between row estimates reported as a plan difference (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("explain-analyze-sample-rate", 0, "Fraction of SELECT executions run again with EXPLAIN ANALYZE on MySQL 8.0.18+ to capture row estimation errors (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("explain-analyze-min-ratio", 10, "Minimum ratio between estimated and actual rows reported as an estimation error (can also be set via config file)")
+	rootCmd.PersistentFlags().Bool("read-your-writes", false, "Insert rows and read them back next to the replay, measuring visibility lag (can also be set via config file)")
+	rootCmd.PersistentFlags().String("read-your-writes-dsn", "", "DSN the read your writes rows are read back from, e.g. a replica, the target by default (can also be set via config file)")
+	rootCmd.PersistentFlags().Duration("read-your-writes-interval", 100*time.Millisecond, "Pause between the writes of each read your writes worker (can also be set via config file)")
+	rootCmd.PersistentFlags().Float64("warnings-sample-rate", 0, "Fraction of query executions followed by SHOW WARNINGS, 0 disables (can also be set via config file)")
 
 	// Bind flags to viper
 	viper.BindPFlag("db_dsn", rootCmd.PersistentFlags().Lookup("db-dsn"))
 	viper.BindPFlag("count", rootCmd.PersistentFlags().Lookup("count"))
+	viper.BindPFlag("duration", rootCmd.PersistentFlags().Lookup("duration"))
 	viper.BindPFlag("concurrency", rootCmd.PersistentFlags().Lookup("concurrency"))
 	viper.BindPFlag("run_mode", rootCmd.PersistentFlags().Lookup("run-mode"))
+	viper.BindPFlag("sequential.sharding", rootCmd.PersistentFlags().Lookup("sequential-sharding"))
+	viper.BindPFlag("sequential.loop", rootCmd.PersistentFlags().Lookup("sequential-loop"))
 	viper.BindPFlag("qps", rootCmd.PersistentFlags().Lookup("qps"))
+	viper.BindPFlag("burst.arrivals", rootCmd.PersistentFlags().Lookup("arrivals"))
+	viper.BindPFlag("burst.spike_multiplier", rootCmd.PersistentFlags().Lookup("spike-multiplier"))
+	viper.BindPFlag("burst.spike_duration", rootCmd.PersistentFlags().Lookup("spike-duration"))
+	viper.BindPFlag("burst.spike_interval", rootCmd.PersistentFlags().Lookup("spike-interval"))
+	viper.BindPFlag("execution_engine", rootCmd.PersistentFlags().Lookup("execution-engine"))
+	viper.BindPFlag("connection_mode", rootCmd.PersistentFlags().Lookup("connection-mode"))
+	viper.BindPFlag("target_schemas", rootCmd.PersistentFlags().Lookup("target-schemas"))
+	viper.BindPFlag("source_schema", rootCmd.PersistentFlags().Lookup("source-schema"))
+	viper.BindPFlag("max_result_rows", rootCmd.PersistentFlags().Lookup("max-result-rows"))
+	viper.BindPFlag("max_result_bytes", rootCmd.PersistentFlags().Lookup("max-result-bytes"))
+	viper.BindPFlag("queries_data_source.weight_smoothing.max_weight", rootCmd.PersistentFlags().Lookup("max-fingerprint-weight"))
+	viper.BindPFlag("queries_data_source.weight_smoothing.min_weight", rootCmd.PersistentFlags().Lookup("min-fingerprint-weight"))
+	viper.BindPFlag("queries_data_source.time_of_day.enabled", rootCmd.PersistentFlags().Lookup("time-of-day"))
+	viper.BindPFlag("queries_data_source.time_of_day.hour_duration", rootCmd.PersistentFlags().Lookup("time-of-day-hour-duration"))
+	viper.BindPFlag("queries_data_source.time_of_day.start_hour", rootCmd.PersistentFlags().Lookup("time-of-day-start-hour"))
+	viper.BindPFlag("queries_data_source.tags.include", rootCmd.PersistentFlags().Lookup("include-tag"))
+	viper.BindPFlag("queries_data_source.tags.exclude", rootCmd.PersistentFlags().Lookup("exclude-tag"))
+	viper.BindPFlag("advisor.enabled", rootCmd.PersistentFlags().Lookup("advisor-enabled"))
+	viper.BindPFlag("advisor.step", rootCmd.PersistentFlags().Lookup("advisor-step"))
+	viper.BindPFlag("warmup.enabled", rootCmd.PersistentFlags().Lookup("warmup-enabled"))
+	viper.BindPFlag("warmup.miss_rate", rootCmd.PersistentFlags().Lookup("warmup-miss-rate"))
+	viper.BindPFlag("warmup.max_duration", rootCmd.PersistentFlags().Lookup("warmup-max-duration"))
+	viper.BindPFlag("endpoints.resolve_interval", rootCmd.PersistentFlags().Lookup("endpoints-resolve-interval"))
+	viper.BindPFlag("reconnect.jitter", rootCmd.PersistentFlags().Lookup("reconnect-jitter"))
+	viper.BindPFlag("reconnect.max_concurrent", rootCmd.PersistentFlags().Lookup("reconnect-max-concurrent"))
+	viper.BindPFlag("reconnect.storm", rootCmd.PersistentFlags().Lookup("reconnect-storm"))
 	viper.BindPFlag("log_level", rootCmd.PersistentFlags().Lookup("log-level"))
 	viper.BindPFlag("metrics.enabled", rootCmd.PersistentFlags().Lookup("metrics-enabled"))
 	viper.BindPFlag("metrics.addr", rootCmd.PersistentFlags().Lookup("metrics-addr"))
+	viper.BindPFlag("execution_log.file", rootCmd.PersistentFlags().Lookup("execution-log-file"))
+	viper.BindPFlag("execution_log.sample_rate", rootCmd.PersistentFlags().Lookup("execution-log-sample-rate"))
+	viper.BindPFlag("slow_log.file", rootCmd.PersistentFlags().Lookup("slow-log-file"))
+	viper.BindPFlag("slow_log.threshold", rootCmd.PersistentFlags().Lookup("slow-log-threshold"))
+	viper.BindPFlag("reporters.console", rootCmd.PersistentFlags().Lookup("report-console"))
+	viper.BindPFlag("reporters.json_file", rootCmd.PersistentFlags().Lookup("report-json-file"))
+	viper.BindPFlag("reporters.statsd.addr", rootCmd.PersistentFlags().Lookup("report-statsd-addr"))
+	viper.BindPFlag("output_dir", rootCmd.PersistentFlags().Lookup("output-dir"))
+	viper.BindPFlag("reporters.results_db", rootCmd.PersistentFlags().Lookup("results-db"))
+	viper.BindPFlag("reporters.trends_db", rootCmd.PersistentFlags().Lookup("trends-db"))
+	viper.BindPFlag("reporters.trends_target", rootCmd.PersistentFlags().Lookup("trends-target"))
+	viper.BindPFlag("metrics.control.enabled", rootCmd.PersistentFlags().Lookup("control"))
+	viper.BindPFlag("soak.enabled", rootCmd.PersistentFlags().Lookup("soak"))
+	viper.BindPFlag("soak.flush_interval", rootCmd.PersistentFlags().Lookup("soak-flush-interval"))
+	viper.BindPFlag("reporters.redact", rootCmd.PersistentFlags().Lookup("redact"))
+	viper.BindPFlag("reporters.pushgateway.url", rootCmd.PersistentFlags().Lookup("pushgateway-url"))
+	viper.BindPFlag("reporters.remote_write.url", rootCmd.PersistentFlags().Lookup("remote-write-url"))
+	viper.BindPFlag("admin.pool_size", rootCmd.PersistentFlags().Lookup("admin-pool-size"))
+	viper.BindPFlag("admin.status_interval", rootCmd.PersistentFlags().Lookup("admin-status-interval"))
+	viper.BindPFlag("plan_diff.compare_dsn", rootCmd.PersistentFlags().Lookup("plan-diff-dsn"))
+	viper.BindPFlag("plan_diff.sample_rate", rootCmd.PersistentFlags().Lookup("plan-diff-sample-rate"))
+	viper.BindPFlag("plan_diff.rows_ratio", rootCmd.PersistentFlags().Lookup("plan-diff-rows-ratio"))
+	viper.BindPFlag("explain_analyze.sample_rate", rootCmd.PersistentFlags().Lookup("explain-analyze-sample-rate"))
+	viper.BindPFlag("explain_analyze.min_ratio", rootCmd.PersistentFlags().Lookup("explain-analyze-min-ratio"))
+	viper.BindPFlag("read_your_writes.enabled", rootCmd.PersistentFlags().Lookup("read-your-writes"))
+	viper.BindPFlag("read_your_writes.read_dsn", rootCmd.PersistentFlags().Lookup("read-your-writes-dsn"))
+	viper.BindPFlag("read_your_writes.interval", rootCmd.PersistentFlags().Lookup("read-your-writes-interval"))
+	viper.BindPFlag("warnings.sample_rate", rootCmd.PersistentFlags().Lookup("warnings-sample-rate"))
 }
 
 func initConfig() {
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"mysql-load-test/internal/metrics"
+)
+
+// MaintenanceConfig describes windows where the target is expected to be
+// degraded, e.g. a rolling restart or a planned failover. Aggregates of a
+// window are flagged, and the time the p99 latency takes to get back to its
+// baseline afterwards is the recovery time.
+type MaintenanceConfig struct {
+	// Windows are scheduled from the start of the run, more can be opened
+	// with POST /maintenance
+	Windows []MaintenanceWindowConfig `mapstructure:"windows" yaml:"windows" validate:"omitempty,dive"`
+	// Tolerance is how many percent above the baseline p99 still counts as
+	// recovered
+	Tolerance float64 `mapstructure:"tolerance" yaml:"tolerance" validate:"omitempty,gte=0"`
+	// BaselineAggregates is the number of aggregates before a window whose
+	// p99 is averaged into the baseline
+	BaselineAggregates int `mapstructure:"baseline_aggregates" yaml:"baseline_aggregates" validate:"omitempty,gte=0"`
+}
+
+type MaintenanceWindowConfig struct {
+	Name     string        `mapstructure:"name" yaml:"name" validate:"required"`
+	After    time.Duration `mapstructure:"after" yaml:"after" validate:"gte=0"`
+	Duration time.Duration `mapstructure:"duration" yaml:"duration" validate:"gt=0"`
+}
+
+// MaintenanceWindow is a window and its recovery. Latencies are in
+// microseconds, End is zero while the window is open and RecoveredAt until
+// the p99 is back within the tolerance of the baseline.
+type MaintenanceWindow struct {
+	Name            string    `json:"name"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end,omitempty"`
+	BaselineP99     float64   `json:"baseline_p99"`
+	PeakP99         float64   `json:"peak_p99"`
+	MinQPS          float64   `json:"min_qps"`
+	RecoveredAt     time.Time `json:"recovered_at,omitempty"`
+	RecoverySeconds float64   `json:"recovery_seconds,omitempty"`
+}
+
+// Maintenance tracks the maintenance windows of the run. It is safe for
+// concurrent use by users (through ServeHTTP), the schedule and the
+// reporter.
+type Maintenance struct {
+	mu                 sync.Mutex
+	tolerance          float64
+	baselineAggregates int
+	// recent holds the p99 of the latest aggregates outside any window
+	recent  []float64
+	windows []*MaintenanceWindow
+}
+
+// maintenance of the current run
+var maintenance = NewMaintenance(MaintenanceConfig{})
+
+func NewMaintenance(cfg MaintenanceConfig) *Maintenance {
+	m := &Maintenance{}
+	m.Configure(cfg)
+	return m
+}
+
+func (m *Maintenance) Configure(cfg MaintenanceConfig) {
+	if cfg.Tolerance <= 0 {
+		cfg.Tolerance = 20
+	}
+	if cfg.BaselineAggregates <= 0 {
+		cfg.BaselineAggregates = 6
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tolerance, m.baselineAggregates = cfg.Tolerance, cfg.BaselineAggregates
+}
+
+// Schedule opens and closes the configured windows until ctx is done
+func (m *Maintenance) Schedule(ctx context.Context, windows []MaintenanceWindowConfig) {
+	start := time.Now()
+	for _, w := range windows {
+		go func() {
+			for _, step := range []struct {
+				at    time.Duration
+				start bool
+			}{{w.After, true}, {w.After + w.Duration, false}} {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(start.Add(step.at))):
+				}
+				var err error
+				if step.start {
+					_, err = m.Start(w.Name, "schedule")
+				} else {
+					_, err = m.End("schedule")
+				}
+				if err != nil {
+					logger.Warn().Err(err).Str("window", w.Name).Msg("Error applying scheduled maintenance window")
+				}
+			}
+		}()
+	}
+}
+
+func (m *Maintenance) active() *MaintenanceWindow {
+	if n := len(m.windows); n > 0 && m.windows[n-1].End.IsZero() {
+		return m.windows[n-1]
+	}
+	return nil
+}
+
+// Start opens a window, the baseline is the p99 before it
+func (m *Maintenance) Start(name, source string) (MaintenanceWindow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if active := m.active(); active != nil {
+		return MaintenanceWindow{}, fmt.Errorf("maintenance window %s is already open", active.Name)
+	}
+	w := &MaintenanceWindow{Name: name, Start: time.Now()}
+	for _, p99 := range m.recent {
+		w.BaselineP99 += p99
+	}
+	if len(m.recent) > 0 {
+		w.BaselineP99 /= float64(len(m.recent))
+	}
+	m.windows = append(m.windows, w)
+
+	logger.Info().Str("window", name).Float64("baseline_p99_us", w.BaselineP99).Msg("Maintenance window started, expecting a degraded target")
+	annotations.Add(source, "Maintenance window "+name+" started", map[string]string{"window": name})
+	return *w, nil
+}
+
+// End closes the open window
+func (m *Maintenance) End(source string) (MaintenanceWindow, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w := m.active()
+	if w == nil {
+		return MaintenanceWindow{}, fmt.Errorf("no maintenance window is open")
+	}
+	w.End = time.Now()
+
+	logger.Info().Str("window", w.Name).Dur("duration", w.End.Sub(w.Start)).Msg("Maintenance window ended, waiting for recovery")
+	annotations.Add(source, "Maintenance window "+w.Name+" ended", map[string]string{"window": w.Name})
+	return *w, nil
+}
+
+// observe flags a with the open window and checks the recovery of the last
+// closed one. Aggregates of a window or of a pending recovery are left out
+// of the baseline.
+func (m *Maintenance) observe(a *ReportAggregateStat) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var last *MaintenanceWindow
+	if n := len(m.windows); n > 0 {
+		last = m.windows[n-1]
+	}
+	switch {
+	case last != nil && (last.End.IsZero() || a.WindowStart.Before(last.End)):
+		// the aggregate overlaps the window
+		a.Maintenance = last.Name
+		last.PeakP99 = max(last.PeakP99, a.LatP99)
+		if last.MinQPS == 0 || a.QPS < last.MinQPS {
+			last.MinQPS = a.QPS
+		}
+	case last != nil && last.RecoveredAt.IsZero():
+		last.PeakP99 = max(last.PeakP99, a.LatP99)
+		// an empty window is an outage, not a recovery
+		if a.NumRes == 0 || last.BaselineP99 > 0 && a.LatP99 > last.BaselineP99*(1+m.tolerance/100) {
+			return
+		}
+		last.RecoveredAt = a.WindowEnd
+		last.RecoverySeconds = a.WindowEnd.Sub(last.End).Seconds()
+		metrics.MaintenanceRecovery.WithLabelValues(last.Name).Set(last.RecoverySeconds)
+		logger.Info().
+			Str("window", last.Name).
+			Float64("recovery_seconds", last.RecoverySeconds).
+			Float64("baseline_p99_us", last.BaselineP99).
+			Float64("peak_p99_us", last.PeakP99).
+			Msg("Target recovered from maintenance window")
+		annotations.Add("maintenance", "Recovered from "+last.Name+" in "+strconv.FormatFloat(last.RecoverySeconds, 'f', 0, 64)+"s",
+			map[string]string{"window": last.Name})
+	case a.NumRes > 0:
+		m.recent = append(m.recent, a.LatP99)
+		if len(m.recent) > m.baselineAggregates {
+			m.recent = m.recent[1:]
+		}
+	}
+}
+
+// List returns a copy of the windows in the order they were opened
+func (m *Maintenance) List() []MaintenanceWindow {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	windows := make([]MaintenanceWindow, len(m.windows))
+	for i, w := range m.windows {
+		windows[i] = *w
+	}
+	return windows
+}
+
+// ServeHTTP lists the windows on GET and starts or ends one on POST with a
+// JSON body like {"action": "start", "name": "failover"}
+func (m *Maintenance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(m.List())
+	case http.MethodPost:
+		var req struct {
+			Action string `json:"action"`
+			Name   string `json:"name"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req); err != nil {
+			http.Error(w, "invalid maintenance request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		var window MaintenanceWindow
+		var err error
+		switch req.Action {
+		case "start":
+			if req.Name == "" {
+				http.Error(w, "maintenance window name is required", http.StatusBadRequest)
+				return
+			}
+			window, err = m.Start(req.Name, "user")
+		case "end":
+			window, err = m.End("user")
+		default:
+			http.Error(w, fmt.Sprintf("unknown action %q, expected start or end", req.Action), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(window)
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
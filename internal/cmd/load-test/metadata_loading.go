@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetadataLoadingConfig speeds up the start on huge metadata databases. With
+// Parallelism the Query table, and a weights query written as a template of
+// .From, .To and .Limit, are read in keyset pages over that many key ranges
+// at once:
+//
+//	SELECT Hash, COUNT(*), 0, COUNT(*) FROM QueryFingerprint
+//	WHERE Hash BETWEEN {{.From}} AND {{.To}}
+//	GROUP BY Hash ORDER BY Hash LIMIT {{.Limit}}
+type MetadataLoadingConfig struct {
+	// Parallelism is the number of key ranges read at once, 0 reads each
+	// table in a single query
+	Parallelism int `mapstructure:"parallelism" yaml:"parallelism" validate:"omitempty,gte=0"`
+	// PageSize is the number of rows of a keyset page
+	PageSize int `mapstructure:"page_size" yaml:"page_size" validate:"omitempty,gte=0"`
+	// ProgressInterval is the time between two progress logs
+	ProgressInterval time.Duration `mapstructure:"progress_interval" yaml:"progress_interval" validate:"omitempty,gte=0"`
+	// StartupBudget fails the start when loading the metadata takes longer,
+	// or as soon as the Query table load is projected to
+	StartupBudget time.Duration `mapstructure:"startup_budget" yaml:"startup_budget" validate:"omitempty,gte=0"`
+	// MaxMemory fails the start when the in-memory index of the queries
+	// would exceed it, in bytes. The corpus is memory mapped and not counted.
+	MaxMemory int64 `mapstructure:"max_memory" yaml:"max_memory" validate:"omitempty,gte=0"`
+	// Lazy fetches the queries of a fingerprint on its first pick instead of
+	// loading the Query table at start, keeping those of LazyCacheSize
+	// fingerprints. While the database is unavailable only the cached
+	// fingerprints are picked.
+	Lazy          bool `mapstructure:"lazy" yaml:"lazy"`
+	LazyCacheSize int  `mapstructure:"lazy_cache_size" yaml:"lazy_cache_size" validate:"omitempty,gte=0"`
+}
+
+const (
+	// queryIndexBytes is about the memory a query takes in the index while
+	// loading: its metadata, its id in the fingerprint and the loaded row
+	queryIndexBytes = 100
+	// indexCheckRows is how often the load projects its duration, in rows
+	indexCheckRows = 10000
+)
+
+// indexGuard stops loading the query index once it outgrows MaxMemory, or
+// its projected duration the rest of the startup budget, rather than being
+// OOM-killed or timing out after a long wait
+type indexGuard struct {
+	maxRows int64
+	// expected is the estimated number of queries, 0 when unknown
+	expected int64
+	budget   time.Duration
+	start    time.Time
+}
+
+// indexGuard returns the guard of a load of about expected queries, failing
+// when they already don't fit. budget is what is left of the startup budget.
+func (c MetadataLoadingConfig) indexGuard(expected int64, budget time.Duration) (*indexGuard, error) {
+	g := &indexGuard{expected: expected, budget: budget, start: time.Now()}
+	if c.MaxMemory > 0 {
+		g.maxRows = max(c.MaxMemory/queryIndexBytes, 1)
+	}
+	if g.maxRows > 0 && expected > g.maxRows {
+		return nil, g.memoryError(expected)
+	}
+	return g, nil
+}
+
+// check is called with the number of queries loaded so far
+func (g *indexGuard) check(rows int64) error {
+	if g == nil {
+		return nil
+	}
+	if g.maxRows > 0 && rows > g.maxRows {
+		return g.memoryError(rows)
+	}
+	if g.budget <= 0 || g.expected <= 0 || rows%indexCheckRows != 0 {
+		return nil
+	}
+	// the first rows are too few to project from
+	elapsed := time.Since(g.start)
+	if elapsed < g.budget/10 {
+		return nil
+	}
+	if projected := time.Duration(float64(elapsed) * float64(g.expected) / float64(rows)); projected > g.budget {
+		return fmt.Errorf("loading the %d queries of the Query table would take about %s, over the %s left of the startup budget: "+
+			"set loading.lazy to fetch the queries of a fingerprint on its first pick, or loading.parallelism to read the table in parallel",
+			g.expected, projected.Round(time.Second), g.budget.Round(time.Second))
+	}
+	return nil
+}
+
+func (g *indexGuard) memoryError(rows int64) error {
+	return fmt.Errorf("the index of %d or more queries needs about %d MiB, over max_memory of %d MiB: "+
+		"set loading.lazy to fetch the queries of a fingerprint on its first pick, or narrow the corpus with tags",
+		rows, rows*queryIndexBytes>>20, g.maxRows*queryIndexBytes>>20)
+}
+
+func (c MetadataLoadingConfig) pageSize() int {
+	if c.PageSize > 0 {
+		return c.PageSize
+	}
+	return 100000
+}
+
+func (c MetadataLoadingConfig) lazyCacheSize() int {
+	if c.LazyCacheSize > 0 {
+		return c.LazyCacheSize
+	}
+	return 10000
+}
+
+func (c MetadataLoadingConfig) progressInterval() time.Duration {
+	if c.ProgressInterval > 0 {
+		return c.ProgressInterval
+	}
+	return 10 * time.Second
+}
+
+// loadProgress counts the rows of a load and logs them periodically
+type loadProgress struct {
+	name  string
+	rows  atomic.Int64
+	start time.Time
+	stop  chan struct{}
+	done  sync.WaitGroup
+	// guard bounds the rows of the load, nil for unbounded loads
+	guard *indexGuard
+}
+
+func startLoadProgress(name string, interval time.Duration) *loadProgress {
+	p := &loadProgress{name: name, start: time.Now(), stop: make(chan struct{})}
+	p.done.Add(1)
+	go func() {
+		defer p.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.log("Loading " + p.name)
+			}
+		}
+	}()
+	return p
+}
+
+func (p *loadProgress) log(msg string) {
+	rows := p.rows.Load()
+	elapsed := time.Since(p.start)
+	logger.Info().
+		Int64("rows", rows).
+		Dur("elapsed", elapsed).
+		Float64("rows_per_second", math.Round(float64(rows)/max(elapsed.Seconds(), 0.001))).
+		Msg(msg)
+}
+
+// Finish stops the periodic logs and logs the total
+func (p *loadProgress) Finish() {
+	close(p.stop)
+	p.done.Wait()
+	p.log("Loaded " + p.name)
+}
+
+// keyRange is an inclusive range of keys
+type keyRange struct {
+	From, To uint64
+}
+
+// splitKeyRange splits [from, to] into at most n ranges of about the same
+// size
+func splitKeyRange(from, to uint64, n int) []keyRange {
+	span := to - from
+	if n <= 1 || to < from {
+		return []keyRange{{From: from, To: to}}
+	}
+	if uint64(n-1) > span {
+		n = int(span) + 1
+	}
+	// span+1 keys, the first r ranges get one more
+	q, r := span/uint64(n), span%uint64(n)+1
+	if r == uint64(n) {
+		q, r = q+1, 0
+	}
+	ranges := make([]keyRange, 0, n)
+	start := from
+	for i := range uint64(n) {
+		size := q
+		if i < r {
+			size++
+		}
+		end := start + size - 1
+		ranges = append(ranges, keyRange{From: start, To: end})
+		start = end + 1
+	}
+	return ranges
+}
+
+// loadKeyset reads every range in pages at once. page returns the query of
+// the rows of r from its start, ordered by key, and scan reads a row and
+// returns its key. The results are returned in the order of the ranges.
+func loadKeyset[T any](ctx context.Context, db *DBConn, ranges []keyRange, limit int, progress *loadProgress,
+	page func(r keyRange, limit int) (string, []any, error), scan func(*sql.Rows) (uint64, T, error)) ([]T, error) {
+	results := make([][]T, len(ranges))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// the first error cancels the other ranges, keep it over theirs
+	var firstErr error
+	var failOnce sync.Once
+	fail := func(err error) {
+		failOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				query, args, err := page(r, limit)
+				if err != nil {
+					fail(err)
+					return
+				}
+				n, last, err := loadKeysetPage(ctx, db, query, args, progress, scan, &results[i])
+				if err != nil {
+					fail(fmt.Errorf("error loading keys %d to %d: %w", r.From, r.To, err))
+					return
+				}
+				if n < limit || last >= r.To {
+					return
+				}
+				r.From = last + 1
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	var merged []T
+	for _, rs := range results {
+		merged = append(merged, rs...)
+	}
+	return merged, nil
+}
+
+func loadKeysetPage[T any](ctx context.Context, db *DBConn, query string, args []any, progress *loadProgress,
+	scan func(*sql.Rows) (uint64, T, error), out *[]T) (int, uint64, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	var last uint64
+	for rows.Next() {
+		key, v, err := scan(rows)
+		if err != nil {
+			return 0, 0, err
+		}
+		*out = append(*out, v)
+		last = key
+		n++
+		if err := progress.guard.check(progress.rows.Add(1)); err != nil {
+			return 0, 0, err
+		}
+	}
+	return n, last, rows.Err()
+}
+
+// isKeysetTemplate tells if a weights query takes the key range of a page
+func isKeysetTemplate(query string) bool {
+	return strings.Contains(query, "{{")
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitKeyRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to uint64
+		n        int
+		want     []keyRange
+	}{
+		{"one worker", 1, 100, 1, []keyRange{{1, 100}}},
+		{"no workers", 1, 100, 0, []keyRange{{1, 100}}},
+		{"empty", 10, 9, 4, []keyRange{{10, 9}}},
+		{"single key", 7, 7, 4, []keyRange{{7, 7}}},
+		{"fewer keys than workers", 0, 2, 5, []keyRange{{0, 0}, {1, 1}, {2, 2}}},
+		{"as many keys as workers", 1, 4, 4, []keyRange{{1, 1}, {2, 2}, {3, 3}, {4, 4}}},
+		{"even", 1, 100, 4, []keyRange{{1, 25}, {26, 50}, {51, 75}, {76, 100}}},
+		{"last ranges shorter", 0, 9, 3, []keyRange{{0, 3}, {4, 6}, {7, 9}}},
+		{"whole key space", 0, math.MaxUint64, 2, []keyRange{{0, math.MaxUint64 / 2}, {math.MaxUint64/2 + 1, math.MaxUint64}}},
+		{"end of the key space", math.MaxUint64 - 2, math.MaxUint64, 2, []keyRange{{math.MaxUint64 - 2, math.MaxUint64 - 1}, {math.MaxUint64, math.MaxUint64}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, splitKeyRange(tt.from, tt.to, tt.n))
+		})
+	}
+}
+
+// openKeysetDB returns a connection to a Query table holding ids
+func openKeysetDB(t *testing.T, ids ...uint64) *DBConn {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "queries.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec("CREATE TABLE Query (ID INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	for _, id := range ids {
+		_, err := db.Exec("INSERT INTO Query (ID) VALUES (?)", id)
+		require.NoError(t, err)
+	}
+
+	conn := NewDBConn(RetryConfig{MaxRetries: 1})
+	conn.db = db
+	return conn
+}
+
+const keysetTestQuery = "SELECT ID FROM Query WHERE ID BETWEEN ? AND ? ORDER BY ID LIMIT ?"
+
+func scanKeysetID(rows *sql.Rows) (uint64, uint64, error) {
+	var id uint64
+	err := rows.Scan(&id)
+	return id, id, err
+}
+
+func TestLoadKeysetPage(t *testing.T) {
+	db := openKeysetDB(t, 1, 2, 3, 5, 8, 9)
+	tests := []struct {
+		name     string
+		r        keyRange
+		limit    int
+		want     []uint64
+		wantLast uint64
+	}{
+		{"full page", keyRange{1, 10}, 3, []uint64{1, 2, 3}, 3},
+		{"last page", keyRange{4, 10}, 3, []uint64{5, 8, 9}, 9},
+		{"short last page", keyRange{6, 10}, 3, []uint64{8, 9}, 9},
+		{"empty range", keyRange{6, 7}, 3, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []uint64
+			n, last, err := loadKeysetPage(context.Background(), db, keysetTestQuery, []any{tt.r.From, tt.r.To, tt.limit},
+				&loadProgress{}, scanKeysetID, &got)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.want), n)
+			assert.Equal(t, tt.wantLast, last)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestLoadKeyset(t *testing.T) {
+	ids := []uint64{1, 2, 3, 5, 8, 9, 13, 21, 34}
+	db := openKeysetDB(t, ids...)
+	page := func(r keyRange, limit int) (string, []any, error) {
+		return keysetTestQuery, []any{r.From, r.To, limit}, nil
+	}
+
+	for _, parallelism := range []int{1, 2, 4, 50} {
+		for _, limit := range []int{1, 2, 100} {
+			t.Run(fmt.Sprintf("%d workers %d rows per page", parallelism, limit), func(t *testing.T) {
+				progress := &loadProgress{}
+				got, err := loadKeyset(context.Background(), db, splitKeyRange(1, 34, parallelism), limit, progress, page, scanKeysetID)
+				require.NoError(t, err)
+				assert.Equal(t, ids, got)
+				assert.EqualValues(t, len(ids), progress.rows.Load())
+			})
+		}
+	}
+
+	t.Run("empty table", func(t *testing.T) {
+		got, err := loadKeyset(context.Background(), openKeysetDB(t), splitKeyRange(0, 0, 4), 2, &loadProgress{}, page, scanKeysetID)
+		require.NoError(t, err)
+		assert.Empty(t, got)
+	})
+
+	t.Run("over max memory", func(t *testing.T) {
+		progress := &loadProgress{guard: &indexGuard{maxRows: 4}}
+		_, err := loadKeyset(context.Background(), db, splitKeyRange(1, 34, 2), 2, progress, page, scanKeysetID)
+		assert.ErrorContains(t, err, "over max_memory")
+	})
+}
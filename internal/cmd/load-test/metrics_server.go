@@ -5,25 +5,35 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 )
 
 type MetricsServer struct {
-	server *http.Server
-	webUI  *WebUI
+	server  *http.Server
+	webUI   *WebUI
+	history *History
 }
 
 func NewMetricsServer(addr string) *MetricsServer {
 	mux := http.NewServeMux()
 
 	// Create WebUI instance
-	webUI := NewWebUI()
+	history := NewHistory(defaultHistoryTiers)
+	webUI := NewWebUI(history)
 
 	// Add routes
-	mux.Handle("/metrics", promhttp.Handler())
+	// OpenMetrics carries the exemplars linking latencies to the slow query log
+	mux.Handle("/metrics", promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true})))
 	mux.HandleFunc("/", webUI.handleIndex)
 	mux.HandleFunc("/ws", webUI.handleWebSocket)
+	mux.Handle("/annotations", annotations)
+	mux.Handle("/control", control)
+	mux.Handle("/maintenance", maintenance)
+	mux.Handle("/healthz", health)
+	mux.HandleFunc("/grafana/dashboard.json", handleGrafanaDashboard)
 
 	server := &http.Server{
 		Addr:         addr,
@@ -33,8 +43,9 @@ func NewMetricsServer(addr string) *MetricsServer {
 	}
 
 	return &MetricsServer{
-		server: server,
-		webUI:  webUI,
+		server:  server,
+		webUI:   webUI,
+		history: history,
 	}
 }
 
@@ -59,8 +70,29 @@ func (s *MetricsServer) Start(ctx context.Context) error {
 	return nil
 }
 
-func (s *MetricsServer) BroadcastStats(report *Report) {
+// Report records the latest aggregate in the history and pushes the report
+// to the web UI clients
+func (s *MetricsServer) Report(report *Report) error {
+	if n := len(report.Aggregates); n > 0 {
+		a := report.Aggregates[n-1]
+		s.history.Add(HistoryPoint{
+			Time:        a.Time,
+			QPS:         a.QPS,
+			DispatchQPS: a.DispatchQPS,
+			Average:     a.Average,
+			LatP50:      a.LatP50,
+			LatP95:      a.LatP95,
+			LatP99:      a.LatP99,
+			NumRes:      a.NumRes,
+		})
+	}
 	if s.webUI != nil {
 		s.webUI.broadcastStats(report)
 	}
+	return nil
+}
+
+// Close is a no-op, the server shuts down with the run context
+func (s *MetricsServer) Close() error {
+	return nil
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"sync/atomic"
+	"time"
+)
+
+type BurstConfig struct {
+	// Arrivals spaces queries evenly (uniform) or with exponential gaps
+	// (poisson), which produces the natural clumping of independent clients
+	Arrivals string `mapstructure:"arrivals" yaml:"arrivals" validate:"omitempty,oneof=uniform poisson"`
+	// Every SpikeInterval the rate is multiplied by SpikeMultiplier for
+	// SpikeDuration, e.g. 10x for 30s every 5m
+	SpikeMultiplier float64       `mapstructure:"spike_multiplier" yaml:"spike_multiplier" validate:"omitempty,gte=1"`
+	SpikeDuration   time.Duration `mapstructure:"spike_duration" yaml:"spike_duration" validate:"omitempty,gte=0"`
+	SpikeInterval   time.Duration `mapstructure:"spike_interval" yaml:"spike_interval" validate:"omitempty,gtfield=SpikeDuration"`
+}
+
+// maxPacerLag bounds how far the pacer catches up after falling behind, so a
+// stall does not turn into an unbounded burst
+const maxPacerLag = time.Second
+
+// Pacer emits one token on C per query arrival at the base QPS, shaped by
+// the burst config. Tokens are dropped when no worker is free to take them,
+// like a time.Ticker drops ticks. Each token is the arrival's intended
+// dispatch time, the corrected latencies are measured from it.
+type Pacer struct {
+	C <-chan time.Time
+
+	c   chan time.Time
+	cfg BurstConfig
+	// qps holds the float64 bits of the base rate, it can change mid-run
+	qps     atomic.Uint64
+	paused  atomic.Bool
+	start   time.Time
+	offered atomic.Int64
+	dropped atomic.Int64
+}
+
+func NewPacer(qps int, cfg BurstConfig, buffer int) *Pacer {
+	c := make(chan time.Time, max(buffer, 1))
+	p := &Pacer{C: c, c: c, cfg: cfg, start: time.Now()}
+	p.SetQPS(float64(qps))
+	return p
+}
+
+// QPS returns the base rate
+func (p *Pacer) QPS() float64 {
+	return math.Float64frombits(p.qps.Load())
+}
+
+// SetQPS changes the base rate from the next arrival on
+func (p *Pacer) SetQPS(qps float64) {
+	p.qps.Store(math.Float64bits(qps))
+}
+
+// SetPaused stops offering arrivals until it is unset again
+func (p *Pacer) SetPaused(paused bool) {
+	p.paused.Store(paused)
+}
+
+// InSpike reports whether now falls into a spike
+func (p *Pacer) InSpike(now time.Time) bool {
+	if p.cfg.SpikeMultiplier <= 1 || p.cfg.SpikeDuration <= 0 || p.cfg.SpikeInterval <= 0 {
+		return false
+	}
+	// spikes start at the end of each interval, so the run begins at base load
+	return now.Sub(p.start)%p.cfg.SpikeInterval >= p.cfg.SpikeInterval-p.cfg.SpikeDuration
+}
+
+// Rate returns the offered rate at now
+func (p *Pacer) Rate(now time.Time) float64 {
+	if p.InSpike(now) {
+		return p.QPS() * p.cfg.SpikeMultiplier
+	}
+	return p.QPS()
+}
+
+// Stats returns the number of arrivals offered and dropped so far
+func (p *Pacer) Stats() (offered, dropped int64) {
+	return p.offered.Load(), p.dropped.Load()
+}
+
+// Run emits tokens until ctx is done
+func (p *Pacer) Run(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	p.start = time.Now()
+	next := p.start
+	for {
+		// a paused pacer offers nothing, arrivals resume from now
+		if p.paused.Load() {
+			timer.Reset(idleWorkerPoll)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			next = time.Now()
+			continue
+		}
+
+		gap := 1 / p.Rate(next)
+		if p.cfg.Arrivals == "poisson" {
+			gap = rand.ExpFloat64() / p.Rate(next)
+		}
+		next = next.Add(time.Duration(gap * float64(time.Second)))
+
+		now := time.Now()
+		if now.Sub(next) > maxPacerLag {
+			next = now
+		}
+		if wait := next.Sub(now); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+		} else if ctx.Err() != nil {
+			return
+		}
+
+		p.offered.Add(1)
+		select {
+		case p.c <- next:
+		default:
+			p.dropped.Add(1)
+		}
+	}
+}
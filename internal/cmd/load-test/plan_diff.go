@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"time"
+)
+
+type PlanDiffConfig struct {
+	CompareDSN string  `mapstructure:"compare_dsn" yaml:"compare_dsn" validate:"omitempty"`
+	SampleRate float64 `mapstructure:"sample_rate" yaml:"sample_rate" validate:"omitempty,gte=0,lte=1"`
+	// RowsRatio is how far apart the row estimates of both targets must be
+	// before they are reported
+	RowsRatio float64 `mapstructure:"rows_ratio" yaml:"rows_ratio" validate:"omitempty,gte=1"`
+}
+
+// PlanDiff is a fingerprint whose EXPLAIN differs between the target and the
+// compare database
+type PlanDiff struct {
+	FingerprintHash uint64       `json:"fingerprint_hash"`
+	Query           string       `json:"query"`
+	Differences     []string     `json:"differences"`
+	Base            []ExplainRow `json:"base"`
+	Compare         []ExplainRow `json:"compare"`
+	Occurrences     int64        `json:"occurrences"`
+	LastSeen        time.Time    `json:"last_seen"`
+}
+
+// PlanDiffer samples EXPLAIN for the same query against two targets and keeps
+// the fingerprints whose plans differ
+type PlanDiffer struct {
+	admin      *AdminConn
+	compare    *DBConn
+	sampleRate float64
+	rowsRatio  float64
+
+	mu      sync.Mutex
+	diffs   map[uint64]*PlanDiff
+	checked int64
+}
+
+func NewPlanDiffer(cfg PlanDiffConfig, admin *AdminConn, compare *DBConn) *PlanDiffer {
+	if cfg.RowsRatio < 1 {
+		cfg.RowsRatio = 2
+	}
+	return &PlanDiffer{
+		admin:      admin,
+		compare:    compare,
+		sampleRate: cfg.SampleRate,
+		rowsRatio:  cfg.RowsRatio,
+		diffs:      make(map[uint64]*PlanDiff),
+	}
+}
+
+// Sampled reports whether the next execution should be checked
+func (pd *PlanDiffer) Sampled() bool {
+	return pd.sampleRate >= 1 || rand.Float64() < pd.sampleRate
+}
+
+// Check explains query on both targets and records any difference
+func (pd *PlanDiffer) Check(ctx context.Context, fingerprintHash uint64, query string) error {
+	base, err := pd.admin.Explain(ctx, query)
+	if err != nil {
+		return fmt.Errorf("error explaining query on target: %w", err)
+	}
+	compare, err := explain(ctx, pd.compare, query)
+	if err != nil {
+		return fmt.Errorf("error explaining query on compare target: %w", err)
+	}
+
+	differences := diffPlans(base.Rows, compare.Rows, pd.rowsRatio)
+
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	pd.checked++
+	if len(differences) == 0 {
+		return nil
+	}
+	diff, ok := pd.diffs[fingerprintHash]
+	if !ok {
+		diff = &PlanDiff{FingerprintHash: fingerprintHash}
+		pd.diffs[fingerprintHash] = diff
+	}
+	diff.Query = query
+	diff.Differences = differences
+	diff.Base = base.Rows
+	diff.Compare = compare.Rows
+	diff.Occurrences++
+	diff.LastSeen = time.Now()
+	return nil
+}
+
+// Checked returns the number of queries explained on both targets
+func (pd *PlanDiffer) Checked() int64 {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	return pd.checked
+}
+
+// List returns copies of the differing plans, most frequent first
+func (pd *PlanDiffer) List() []PlanDiff {
+	pd.mu.Lock()
+	defer pd.mu.Unlock()
+	diffs := make([]PlanDiff, 0, len(pd.diffs))
+	for _, diff := range pd.diffs {
+		diffs = append(diffs, *diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Occurrences > diffs[j].Occurrences
+	})
+	return diffs
+}
+
+// diffPlans compares the access type, chosen index and row estimate of each
+// table in the plans
+func diffPlans(base, compare []ExplainRow, rowsRatio float64) []string {
+	var differences []string
+	if len(base) != len(compare) {
+		differences = append(differences, fmt.Sprintf("plan has %d rows on target and %d on compare", len(base), len(compare)))
+	}
+
+	for i := range min(len(base), len(compare)) {
+		b, c := base[i], compare[i]
+		table := b.Table.String
+		if b.Table.String != c.Table.String {
+			differences = append(differences, fmt.Sprintf("row %d: table %q vs %q", i+1, b.Table.String, c.Table.String))
+			continue
+		}
+		if b.Type.String != c.Type.String {
+			differences = append(differences, fmt.Sprintf("%s: access type %q vs %q", table, b.Type.String, c.Type.String))
+		}
+		if b.Key.String != c.Key.String {
+			differences = append(differences, fmt.Sprintf("%s: key %q vs %q", table, b.Key.String, c.Key.String))
+		}
+		if rowsDiffer(b.Rows.Int64, c.Rows.Int64, rowsRatio) {
+			differences = append(differences, fmt.Sprintf("%s: rows %d vs %d", table, b.Rows.Int64, c.Rows.Int64))
+		}
+	}
+	return differences
+}
+
+func rowsDiffer(a, b int64, ratio float64) bool {
+	lo, hi := float64(min(a, b)), float64(max(a, b))
+	return lo != hi && hi >= ratio*max(lo, 1)
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const defaultPushJob = "mysql_load_test"
+
+// PushgatewayConfig pushes every metric of /metrics to a Pushgateway when
+// the run ends, for runs too short to be scraped
+type PushgatewayConfig struct {
+	URL string `mapstructure:"url" yaml:"url" validate:"omitempty,url"`
+	Job string `mapstructure:"job" yaml:"job" validate:"omitempty"`
+	// Grouping labels tell the pushes of concurrent runs apart, the
+	// Pushgateway replaces the metrics of the same job and grouping
+	Grouping map[string]string `mapstructure:"grouping" yaml:"grouping"`
+}
+
+// RemoteWriteConfig sends the report aggregates over the Prometheus remote
+// write protocol as they close, timestamped with their window end
+type RemoteWriteConfig struct {
+	URL string `mapstructure:"url" yaml:"url" validate:"omitempty,url"`
+	Job string `mapstructure:"job" yaml:"job" validate:"omitempty"`
+	// Labels are added to every series, e.g. the CI pipeline or commit
+	Labels map[string]string `mapstructure:"labels" yaml:"labels"`
+	// Headers are sent with every request, e.g. Authorization
+	Headers map[string]string `mapstructure:"headers" yaml:"headers"`
+	Timeout time.Duration     `mapstructure:"timeout" yaml:"timeout" validate:"omitempty,gte=0"`
+}
+
+// pushgatewayReporter keeps the aggregate gauges current and pushes the
+// default registry once the run ends
+type pushgatewayReporter struct {
+	pusher *push.Pusher
+}
+
+func newPushgatewayReporter(cfg PushgatewayConfig) *pushgatewayReporter {
+	job := cfg.Job
+	if job == "" {
+		job = defaultPushJob
+	}
+	pusher := push.New(cfg.URL, job).Gatherer(prometheus.DefaultGatherer)
+	for name, value := range cfg.Grouping {
+		pusher = pusher.Grouping(name, value)
+	}
+	return &pushgatewayReporter{pusher: pusher}
+}
+
+func (p *pushgatewayReporter) Report(r *Report) error {
+	if r.Warming {
+		return nil
+	}
+	return prometheusReporter{}.Report(r)
+}
+
+func (p *pushgatewayReporter) Close() error {
+	if err := p.pusher.Push(); err != nil {
+		return fmt.Errorf("error pushing metrics to pushgateway: %w", err)
+	}
+	logger.Info().Msg("Pushed metrics to pushgateway")
+	return nil
+}
+
+// maxRemoteWritePending bounds the aggregates kept while the remote write
+// endpoint fails, the oldest are dropped
+const maxRemoteWritePending = 1000
+
+// remoteWriteReporter sends every closed aggregate once, aggregates that
+// failed to send are retried with the next one and on close
+type remoteWriteReporter struct {
+	url     string
+	headers map[string]string
+	labels  [][2]string
+	client  *http.Client
+
+	pending    []*ReportAggregateStat
+	lastWindow time.Time
+}
+
+func newRemoteWriteReporter(cfg RemoteWriteConfig) *remoteWriteReporter {
+	job := cfg.Job
+	if job == "" {
+		job = defaultPushJob
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	labels := [][2]string{{"job", job}}
+	for name, value := range cfg.Labels {
+		if name != "job" {
+			labels = append(labels, [2]string{name, value})
+		}
+	}
+	return &remoteWriteReporter{
+		url:     cfg.URL,
+		headers: cfg.Headers,
+		labels:  labels,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (rw *remoteWriteReporter) Report(r *Report) error {
+	if r.Warming {
+		return nil
+	}
+	for _, a := range r.Aggregates {
+		if a.WindowEnd.After(rw.lastWindow) {
+			rw.pending = append(rw.pending, a)
+			rw.lastWindow = a.WindowEnd
+		}
+	}
+	if n := len(rw.pending); n > maxRemoteWritePending {
+		rw.pending = rw.pending[n-maxRemoteWritePending:]
+	}
+	return rw.flush()
+}
+
+func (rw *remoteWriteReporter) Close() error {
+	return rw.flush()
+}
+
+func (rw *remoteWriteReporter) flush() error {
+	if len(rw.pending) == 0 {
+		return nil
+	}
+	body := s2.EncodeSnappy(nil, encodeWriteRequest(rw.series(rw.pending)))
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, rw.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating remote write request: %w", err)
+	}
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for name, value := range rw.headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending remote write request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		// client errors won't succeed on retry
+		if resp.StatusCode/100 == 4 {
+			rw.pending = nil
+		}
+		return fmt.Errorf("remote write returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	rw.pending = nil
+	return nil
+}
+
+type remoteSeries struct {
+	labels  [][2]string
+	samples []remoteSample
+}
+
+type remoteSample struct {
+	value     float64
+	timestamp int64
+}
+
+// series turns the aggregates into one series per value, samples in window
+// order. Latencies are converted to seconds.
+func (rw *remoteWriteReporter) series(aggregates []*ReportAggregateStat) []*remoteSeries {
+	var all []*remoteSeries
+	byKey := make(map[string]*remoteSeries)
+	add := func(name string, value float64, ts int64, extra ...[2]string) {
+		key := name
+		for _, l := range extra {
+			key += "," + l[0] + "=" + l[1]
+		}
+		s, ok := byKey[key]
+		if !ok {
+			labels := append([][2]string{{"__name__", name}}, rw.labels...)
+			labels = append(labels, extra...)
+			sort.Slice(labels, func(i, j int) bool { return labels[i][0] < labels[j][0] })
+			s = &remoteSeries{labels: labels}
+			byKey[key] = s
+			all = append(all, s)
+		}
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return
+		}
+		s.samples = append(s.samples, remoteSample{value: value, timestamp: ts})
+	}
+	for _, a := range aggregates {
+		ts := a.WindowEnd.UnixMilli()
+		add("mysql_load_test_aggregate_qps", a.QPS, ts)
+		add("mysql_load_test_aggregate_dispatch_qps", a.DispatchQPS, ts)
+		add("mysql_load_test_aggregate_worker_utilization", a.WorkerUtilization, ts)
+		add("mysql_load_test_aggregate_results", float64(a.NumRes), ts)
+		add("mysql_load_test_aggregate_latency_avg_seconds", a.Average/1e6, ts)
+		for _, q := range []struct {
+			quantile float64
+			value    float64
+		}{{0.5, a.LatP50}, {0.95, a.LatP95}, {0.99, a.LatP99}} {
+			add("mysql_load_test_aggregate_latency_seconds", q.value/1e6, ts, [2]string{"quantile", strconv.FormatFloat(q.quantile, 'g', -1, 64)})
+		}
+	}
+	return all
+}
+
+// encodeWriteRequest encodes the series as a prometheus.WriteRequest
+// protobuf message
+func encodeWriteRequest(series []*remoteSeries) []byte {
+	var req []byte
+	for _, s := range series {
+		if len(s.samples) == 0 {
+			continue
+		}
+		var ts []byte
+		for _, l := range s.labels {
+			var label []byte
+			label = protowire.AppendTag(label, 1, protowire.BytesType)
+			label = protowire.AppendString(label, l[0])
+			label = protowire.AppendTag(label, 2, protowire.BytesType)
+			label = protowire.AppendString(label, l[1])
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, label)
+		}
+		for _, smp := range s.samples {
+			var sample []byte
+			sample = protowire.AppendTag(sample, 1, protowire.Fixed64Type)
+			sample = protowire.AppendFixed64(sample, math.Float64bits(smp.value))
+			sample = protowire.AppendTag(sample, 2, protowire.VarintType)
+			sample = protowire.AppendVarint(sample, uint64(smp.timestamp))
+			ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, sample)
+		}
+		req = protowire.AppendTag(req, 1, protowire.BytesType)
+		req = protowire.AppendBytes(req, ts)
+	}
+	return req
+}
@@ -3,65 +3,291 @@ package main
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"math/rand/v2"
+	myerror "mysql-load-test/internal/error"
 	"mysql-load-test/internal/ringbuffer"
+	"mysql-load-test/pkg/mysqlwire"
+	"sync/atomic"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 )
 
 type QueryResult struct {
 	CompletionTimestamp         time.Time
+	StatementType               StatementType
+	FingerprintHash             uint64
 	ExplainLatency, ExecLatency time.Duration
 	Err                         error
 	Explain                     *ExplainQueryResult
+	// Warnings is set only for executions sampled for SHOW WARNINGS
+	Warnings []QueryWarning
+	// Experiment is the hint experiment of the fingerprint, Hinted tells
+	// which arm this execution ran in
+	Experiment string
+	Hinted     bool
+	// Truncated is set when the result exceeded the result limits and was
+	// not read to the end
+	Truncated bool
+	// CorrectedLatency runs from the arrival's intended dispatch time to
+	// completion, so time spent waiting behind a lagging generator counts
+	// (coordinated omission). Zero without a QPS schedule.
+	CorrectedLatency time.Duration
+	// Breakdown splits the execution into waiting for a connection, the
+	// server answering and reading the results
+	Breakdown mysqlwire.Timings
+	// Exemplar links the latency observation to the slow query log entry of
+	// the execution, nil when it was not logged
+	Exemplar prometheus.Labels
+	// Host is the target endpoint the execution ran on, only set when
+	// connections are balanced over the resolved addresses
+	Host string
 }
 
 type Querier struct {
 	qds       QueryDataSource
-	qpsTicker *time.Ticker
+	pacer     *Pacer
 	results   chan<- *QueryResult
 	perfStats *QuerierInternalPerfStats
 	logger    *zerolog.Logger
 	db        *DBConn
+	// raw replaces db for plain executions when the raw engine is selected
+	raw *mysqlwire.Pool
+	// workerConns gives every worker its own connection in per_worker
+	// connection mode, nil when workers share the pools
+	workerConns *workerConns
+	execLog     *ExecutionLog
+	slowLog     *SlowLog
+
+	warningsSampleRate float64
+	planDiffer         *PlanDiffer
+	analyzer           *ExplainAnalyzer
+	experiments        *HintExperiments
+	schemas            *SchemaRouter
+	tenants            *TenantRewriter
+	limits             ResultLimits
+
+	// dispatched counts queries handed to the server and busy the time
+	// workers spent executing them, to tell generator from server limits
+	dispatched atomic.Int64
+	busy       atomic.Int64
+
+	// activeWorkers limits the workers running queries, 0 lets all run
+	activeWorkers atomic.Int64
+	// paused idles every worker
+	paused atomic.Bool
+
+	// workers holds the counters of each goroutine by worker id
+	workers []workerCounters
+
+	// sequence replaces the weighted picks in the sequential and session run
+	// modes
+	sequence *Sequence
+	// timeline replaces the weighted picks and the pacer in the replay run
+	// mode
+	timeline *Timeline
+	// limit is the number of queries of the run, 0 runs until stopped.
+	// issued counts the queries the workers took, executed those they
+	// executed, a pick that failed gives its slot back.
+	limit    int64
+	issued   atomic.Int64
+	executed atomic.Int64
+	// conflicts remaps the keys of writes to disjoint ranges per worker
+	conflicts *WriteConflicts
+	// hosts labels the results with their endpoint when connections are
+	// balanced
+	hosts *endpointHosts
 }
 
 type QuerierInternalPerfStats struct {
-	getRandomWeightedQueryLats_rb *ringbuffer.RingBuffer[time.Duration]
-	getRandomWeightedQueryLats    []time.Duration
+	// getRandomWeightedQueryLats holds a buffer per worker, so recording
+	// on the hot path takes no lock
+	getRandomWeightedQueryLats []*ringbuffer.SingleProducer[time.Duration]
 }
 
-func NewQuerierInternalPerfStats() *QuerierInternalPerfStats {
-	return &QuerierInternalPerfStats{
-		getRandomWeightedQueryLats_rb: ringbuffer.NewRingBuffer[time.Duration](maxGetRandomWeightedQueryLats),
-		getRandomWeightedQueryLats:    make([]time.Duration, maxGetRandomWeightedQueryLats),
+func NewQuerierInternalPerfStats(workers int) *QuerierInternalPerfStats {
+	st := &QuerierInternalPerfStats{
+		getRandomWeightedQueryLats: make([]*ringbuffer.SingleProducer[time.Duration], workers),
 	}
+	for i := range st.getRandomWeightedQueryLats {
+		st.getRandomWeightedQueryLats[i] = ringbuffer.NewSingleProducer[time.Duration](maxGetRandomWeightedQueryLats)
+	}
+	return st
 }
 
-func (st *QuerierInternalPerfStats) RecordGetRandomWeightedQueryLat(lat time.Duration) {
-	st.getRandomWeightedQueryLats_rb.Append(lat)
+// RecordGetRandomWeightedQueryLat must only be called by the worker owning
+// workerID.
+func (st *QuerierInternalPerfStats) RecordGetRandomWeightedQueryLat(workerID int, lat time.Duration) {
+	st.getRandomWeightedQueryLats[workerID].Append(lat)
 }
 
-func (st *QuerierInternalPerfStats) GetRandomWeightedQueryLats() []time.Duration {
-	lats := st.getRandomWeightedQueryLats[:0]
-	return st.getRandomWeightedQueryLats_rb.GetAll(lats)
+func (st *QuerierInternalPerfStats) GetRandomWeightedQueryLatsSnapshot(percentiles ...float64) ringbuffer.Snapshot[time.Duration] {
+	return ringbuffer.SnapshotAll(st.getRandomWeightedQueryLats, percentiles...)
 }
 
 const (
-	maxGetRandomWeightedQueryLats = 5000 * 8 // 8 bytes since time.Duration is int64
+	// maxGetRandomWeightedQueryLats is the size of the buffer of a worker
+	maxGetRandomWeightedQueryLats = 5000
 )
 
-func NewQuerier(qds QueryDataSource, qpsTicker *time.Ticker, logger *zerolog.Logger, db *DBConn, resultsChan chan<- *QueryResult) *Querier {
+// NewQuerier runs the queries of qds on db at the pace of pacer, nil for an
+// unlimited rate. The optional parts of a run are set with the setters.
+func NewQuerier(qds QueryDataSource, pacer *Pacer, concurrency int, logger *zerolog.Logger, db *DBConn, resultsChan chan<- *QueryResult) *Querier {
 	return &Querier{
 		qds:       qds,
-		qpsTicker: qpsTicker,
+		pacer:     pacer,
 		results:   resultsChan,
-		perfStats: NewQuerierInternalPerfStats(),
+		perfStats: NewQuerierInternalPerfStats(concurrency),
 		logger:    logger,
 		db:        db,
+		workers:   make([]workerCounters, concurrency),
 	}
 }
 
+// SetRawPool executes the queries over the raw protocol engine instead of
+// database/sql
+func (q *Querier) SetRawPool(raw *mysqlwire.Pool) {
+	q.raw = raw
+}
+
+// SetWorkerConns gives every worker its own connection
+func (q *Querier) SetWorkerConns(conns *workerConns) {
+	q.workerConns = conns
+}
+
+// SetExecutionLog logs every execution
+func (q *Querier) SetExecutionLog(execLog *ExecutionLog) {
+	q.execLog = execLog
+}
+
+// SetSlowLog logs the executions over its threshold
+func (q *Querier) SetSlowLog(slowLog *SlowLog) {
+	q.slowLog = slowLog
+}
+
+// SetWarningsSampleRate reads the warnings of this share of the executions
+func (q *Querier) SetWarningsSampleRate(rate float64) {
+	q.warningsSampleRate = rate
+}
+
+// SetPlanDiffer checks the plans of the executed fingerprints
+func (q *Querier) SetPlanDiffer(planDiffer *PlanDiffer) {
+	q.planDiffer = planDiffer
+}
+
+// SetExplainAnalyzer samples EXPLAIN ANALYZE of the executed fingerprints
+func (q *Querier) SetExplainAnalyzer(analyzer *ExplainAnalyzer) {
+	q.analyzer = analyzer
+}
+
+// SetHintExperiments runs the hint experiments on their fingerprints
+func (q *Querier) SetHintExperiments(experiments *HintExperiments) {
+	q.experiments = experiments
+}
+
+// SetSchemaRouter routes the queries to their schema
+func (q *Querier) SetSchemaRouter(schemas *SchemaRouter) {
+	q.schemas = schemas
+}
+
+// SetTenantRewriter rewrites the tenant ids of the queries
+func (q *Querier) SetTenantRewriter(tenants *TenantRewriter) {
+	q.tenants = tenants
+}
+
+// SetResultLimits cuts oversized results short
+func (q *Querier) SetResultLimits(limits ResultLimits) {
+	q.limits = limits
+}
+
+// DispatchStats returns the number of queries dispatched so far and the
+// total time workers spent executing them
+func (q *Querier) DispatchStats() (int64, time.Duration) {
+	return q.dispatched.Load(), time.Duration(q.busy.Load())
+}
+
+// WorkerStats returns the cumulative counters of every worker
+func (q *Querier) WorkerStats() []WorkerStats {
+	stats := make([]WorkerStats, len(q.workers))
+	for i := range q.workers {
+		w := &q.workers[i]
+		stats[i] = WorkerStats{
+			Executed: w.executed.Load(),
+			Busy:     time.Duration(w.busy.Load()),
+			Wait:     time.Duration(w.wait.Load()),
+		}
+	}
+	return stats
+}
+
+// SetActiveWorkers lets only the workers with an id below n run queries, the
+// others idle until it is raised again. 0 lets all run.
+func (q *Querier) SetActiveWorkers(n int) {
+	q.activeWorkers.Store(int64(n))
+}
+
+// ActiveWorkers returns the worker limit, 0 when all run
+func (q *Querier) ActiveWorkers() int {
+	return int(q.activeWorkers.Load())
+}
+
+// SetSequence makes every worker replay its shard of the corpus instead of
+// weighted picks
+func (q *Querier) SetSequence(sequence *Sequence) {
+	q.sequence = sequence
+}
+
+// SetTimeline makes the workers replay the corpus at its capture times
+// instead of weighted picks
+func (q *Querier) SetTimeline(timeline *Timeline) {
+	q.timeline = timeline
+}
+
+// SetCount stops the workers once count queries were executed, 0 or less
+// runs until stopped
+func (q *Querier) SetCount(count int) {
+	q.limit = int64(max(count, 0))
+}
+
+// CountReached tells whether the workers executed the count of the run
+func (q *Querier) CountReached() bool {
+	return q.limit > 0 && q.executed.Load() >= q.limit
+}
+
+// SetEndpointHosts labels every result with the endpoint it ran on
+func (q *Querier) SetEndpointHosts(hosts *endpointHosts) {
+	q.hosts = hosts
+}
+
+// SetWriteConflicts remaps the keys of the writes of every worker
+func (q *Querier) SetWriteConflicts(conflicts *WriteConflicts) {
+	q.conflicts = conflicts
+}
+
+// SetPaused idles the workers until it is unset again
+func (q *Querier) SetPaused(paused bool) {
+	q.paused.Store(paused)
+}
+
+// Paused reports whether the workers are idled
+func (q *Querier) Paused() bool {
+	return q.paused.Load()
+}
+
+// OfferedStats returns the number of arrivals offered by the pacer so far,
+// zero when the rate is unlimited
+func (q *Querier) OfferedStats() int64 {
+	if q.pacer == nil {
+		return 0
+	}
+	offered, _ := q.pacer.Stats()
+	return offered
+}
+
 func (q *Querier) PerfStats() QuerierInternalPerfStats {
 	return *q.perfStats
 }
@@ -86,8 +312,12 @@ type ExplainQueryResult struct {
 }
 
 func (q *Querier) explainQuery(ctx context.Context, query string, args ...any) (*ExplainQueryResult, error) {
+	return explain(ctx, q.db, query, args...)
+}
+
+func explain(ctx context.Context, db *DBConn, query string, args ...any) (*ExplainQueryResult, error) {
 	explainQuery := "EXPLAIN " + query
-	rows, err := q.db.QueryContext(ctx, explainQuery, args...)
+	rows, err := db.QueryContext(ctx, explainQuery, args...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute explain query: %w", err)
 	}
@@ -126,7 +356,10 @@ func (q *Querier) explainQuery(ctx context.Context, query string, args ...any) (
 	return &result, nil
 }
 
-func (q *Querier) executeQuery(ctx context.Context, query string, args ...any) (*QueryResult, error) {
+// executeQuery executes the query on the pool, or the worker's connection in
+// per_worker mode. A schema is only supported by the raw engine, which tracks
+// it per connection.
+func (q *Querier) executeQuery(ctx context.Context, workerID int, schema, query string, args ...any) (*QueryResult, error) {
 	var explainQueryResult *ExplainQueryResult
 	var explainLatency time.Duration
 	var execErr error
@@ -144,8 +377,23 @@ func (q *Querier) executeQuery(ctx context.Context, query string, args ...any) (
 	// 	}
 	// }()
 
+	var truncated bool
+	var timings mysqlwire.Timings
 	start := time.Now()
-	_, execErr = q.db.ExecContext(ctx, query, args...)
+	if q.raw != nil && len(args) == 0 {
+		raw := q.raw
+		if q.workerConns != nil {
+			raw = q.workerConns.Raw(workerID)
+		}
+		timings, execErr = raw.ExecInTimed(ctx, schema, query)
+		if errors.Is(execErr, mysqlwire.ErrResultTruncated) {
+			truncated, execErr = true, nil
+		}
+	} else if q.workerConns != nil {
+		timings, truncated, execErr = q.execOnWorkerConn(ctx, workerID, query, args...)
+	} else {
+		timings, truncated, execErr = q.execPooled(ctx, query, args...)
+	}
 	execLatency := time.Since(start)
 
 	// wg.Wait()
@@ -156,32 +404,356 @@ func (q *Querier) executeQuery(ctx context.Context, query string, args ...any) (
 		CompletionTimestamp: time.Now(),
 		ExplainLatency:      explainLatency,
 		ExecLatency:         execLatency,
+		Breakdown:           timings,
+		Truncated:           truncated,
+		Host:                q.host(timings),
 	}, execErr
 }
 
-func (q *Querier) do(ctx context.Context) error {
-	// a := time.Now()
-	query, err := q.qds.GetRandomWeightedQuery(ctx)
-	// fmt.Println(query.Query, query.Fingerprint)
-	// q.perfStats.RecordGetRandomWeightedQueryLat(time.Since(a))
+// host returns the endpoint the execution ran on when connections are
+// balanced
+func (q *Querier) host(timings mysqlwire.Timings) string {
+	if q.hosts == nil {
+		return ""
+	}
+	return timings.Addr
+}
+
+// connect gets a connection with get, also returning the endpoint it is
+// connected to when connections are balanced
+func (q *Querier) connect(ctx context.Context, get func(context.Context) (*sql.Conn, error)) (*sql.Conn, string, error) {
+	if q.hosts == nil {
+		conn, err := get(ctx)
+		return conn, "", err
+	}
+	return q.hosts.conn(ctx, get)
+}
+
+type ResultLimits struct {
+	MaxRows  int64
+	MaxBytes int64
+}
+
+func (l ResultLimits) enabled() bool {
+	return l.MaxRows > 0 || l.MaxBytes > 0
+}
+
+// queryExecer is implemented by both DBConn and sql.Conn
+type queryExecer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// exec executes the query. SELECT results are read row by row so the time
+// to the first response and the time draining the rows are told apart, and
+// oversized results can be cut short when result limits are set.
+func (q *Querier) exec(ctx context.Context, db queryExecer, query string, args ...any) (timings mysqlwire.Timings, truncated bool, err error) {
+	start := time.Now()
+	if classifyStatement(query) != StatementSelect {
+		_, err := db.ExecContext(ctx, query, args...)
+		return mysqlwire.Timings{Execute: time.Since(start)}, false, err
+	}
+
+	// Canceling makes the driver drop the connection instead of draining
+	// the rest of the result on Close
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query, args...)
+	timings.Execute = time.Since(start)
+	if err != nil {
+		return timings, false, err
+	}
+	firstResponse := time.Now()
+	defer func() {
+		rows.Close()
+		timings.Drain = time.Since(firstResponse)
+	}()
+
+	if !q.limits.enabled() {
+		for rows.Next() {
+		}
+		return timings, false, rows.Err()
+	}
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return timings, false, err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]any, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+
+	var numRows, size int64
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return timings, false, err
+		}
+		numRows++
+		for _, v := range values {
+			size += int64(len(v))
+		}
+		if q.limits.MaxRows > 0 && numRows > q.limits.MaxRows || q.limits.MaxBytes > 0 && size > q.limits.MaxBytes {
+			cancel()
+			return timings, true, nil
+		}
+	}
+	return timings, false, rows.Err()
+}
+
+// maxBadConnRetries matches database/sql, which retries an execution on
+// another connection when the driver reports the connection bad before
+// sending anything
+const maxBadConnRetries = 2
+
+// execPooled executes the query on a connection reserved from the shared
+// pool, so the wait for it is measured apart from the execution
+func (q *Querier) execPooled(ctx context.Context, query string, args ...any) (mysqlwire.Timings, bool, error) {
+	var wait time.Duration
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		conn, addr, err := q.connect(ctx, q.db.Conn)
+		wait += time.Since(start)
+		if err != nil {
+			return mysqlwire.Timings{Wait: wait}, false, err
+		}
+		timings, truncated, err := q.exec(ctx, conn, query, args...)
+		conn.Close()
+		if errors.Is(err, driver.ErrBadConn) && attempt < maxBadConnRetries {
+			continue
+		}
+		timings.Wait, timings.Addr = wait, addr
+		return timings, truncated, err
+	}
+}
+
+func (q *Querier) execOnWorkerConn(ctx context.Context, workerID int, query string, args ...any) (mysqlwire.Timings, bool, error) {
+	start := time.Now()
+	conn, addr, err := q.connect(ctx, q.workerConn(workerID))
+	wait := time.Since(start)
 	if err != nil {
-		return fmt.Errorf("error getting random weighted query: %w", err)
+		return mysqlwire.Timings{Wait: wait}, false, err
+	}
+	timings, truncated, err := q.exec(ctx, conn, query, args...)
+	timings.Wait, timings.Addr = wait, addr
+	// a truncated result was canceled, which killed the connection
+	if truncated || isBadConn(err) {
+		q.workerConns.Discard(workerID)
+	}
+	return timings, truncated, err
+}
+
+// sessionOptions is the session state an execution needs
+type sessionOptions struct {
+	schema          string
+	optimizerSwitch string
+	withWarnings    bool
+}
+
+// workerConn returns the getter of the connection the worker owns
+func (q *Querier) workerConn(workerID int) func(context.Context) (*sql.Conn, error) {
+	return func(ctx context.Context) (*sql.Conn, error) {
+		return q.workerConns.Conn(ctx, workerID)
+	}
+}
+
+// conn reserves a connection from the pool, or returns the worker's own in
+// per_worker mode, along with its endpoint when connections are balanced.
+// release hands it back, discard closes it instead.
+func (q *Querier) conn(ctx context.Context, workerID int) (*sql.Conn, string, func(discard bool), error) {
+	if q.workerConns != nil {
+		conn, addr, err := q.connect(ctx, q.workerConn(workerID))
+		if err != nil {
+			return nil, "", nil, err
+		}
+		return conn, addr, func(discard bool) {
+			if discard {
+				q.workerConns.Discard(workerID)
+			}
+		}, nil
 	}
 
-	// fmt.Println(query.Query, query.Fingerprint)
+	conn, addr, err := q.connect(ctx, q.db.Conn)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return conn, addr, func(discard bool) {
+		if discard {
+			conn.Raw(func(any) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, nil
+}
+
+// executeQueryOnConn executes the query on a dedicated connection, for
+// executions needing session state: a default schema, an optimizer_switch
+// set for the statement only, or SHOW WARNINGS right after it.
+func (q *Querier) executeQueryOnConn(ctx context.Context, workerID int, session sessionOptions, query string, args ...any) (*QueryResult, error) {
+	acquired := time.Now()
+	conn, addr, release, err := q.conn(ctx, workerID)
+	if err != nil {
+		return &QueryResult{Err: err, CompletionTimestamp: time.Now()}, err
+	}
+	var discard bool
+	defer func() { release(discard) }()
+
+	if session.schema != "" {
+		if _, err := conn.ExecContext(ctx, "USE "+quoteIdentifier(session.schema)); err != nil {
+			discard = isBadConn(err)
+			err = fmt.Errorf("error selecting schema %s: %w", session.schema, err)
+			return &QueryResult{Err: err, CompletionTimestamp: time.Now(), Host: addr}, err
+		}
+	}
+
+	if optimizerSwitch := session.optimizerSwitch; optimizerSwitch != "" {
+		if _, err := conn.ExecContext(ctx, "SET SESSION optimizer_switch = ?", optimizerSwitch); err != nil {
+			discard = isBadConn(err)
+			err = fmt.Errorf("error setting optimizer_switch: %w", err)
+			return &QueryResult{Err: err, CompletionTimestamp: time.Now(), Host: addr}, err
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SET SESSION optimizer_switch = DEFAULT"); err != nil {
+				// Don't return a modified session to the pool
+				discard = true
+			}
+		}()
+	}
+
+	// the session setup counts as waiting for the connection
+	start := time.Now()
+	timings, truncated, execErr := q.exec(ctx, conn, query, args...)
+	execLatency := time.Since(start)
+	timings.Wait, timings.Addr = start.Sub(acquired), addr
+	discard = truncated || isBadConn(execErr)
+
+	result := &QueryResult{
+		Err:                 execErr,
+		CompletionTimestamp: time.Now(),
+		ExecLatency:         execLatency,
+		Breakdown:           timings,
+		Truncated:           truncated,
+		Host:                addr,
+	}
 
+	if session.withWarnings {
+		warnings, err := showWarnings(ctx, conn)
+		if err != nil {
+			q.logger.Debug().Err(err).Msg("Error fetching warnings")
+		} else {
+			result.Warnings = warnings
+			if result.Warnings == nil {
+				result.Warnings = []QueryWarning{}
+			}
+		}
+	}
+
+	return result, execErr
+}
+
+// do executes one query. intended is the dispatch time the pacer scheduled
+// the arrival for, zero when unpaced.
+func (q *Querier) do(ctx context.Context, workerID int, intended time.Time) error {
+	var query *QueryDataSourceResult
+	var err error
+	if q.timeline != nil {
+		query, intended, err = q.timeline.Next(ctx)
+		if errors.Is(err, errShardDone) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+	} else if q.sequence != nil {
+		query, err = q.sequence.Next(workerID)
+		if errors.Is(err, errShardDone) {
+			return err
+		}
+		if q.sequence.SessionStarted(workerID) {
+			q.workerConns.Reset(workerID)
+		}
+	} else {
+		start := time.Now()
+		query, err = q.qds.GetRandomWeightedQuery(ctx)
+		q.perfStats.RecordGetRandomWeightedQueryLat(workerID, time.Since(start))
+	}
+	if err != nil {
+		return myerror.Wrap(err, "error getting random weighted query")
+	}
+
+	var session sessionOptions
+	execQuery := query.Query
+	experiment, hinted := q.experiments.Pick(query.FingerprintHash)
+	if hinted {
+		execQuery, session.optimizerSwitch = experiment.Rewrite(query.Query), experiment.OptimizerSwitch
+	}
+	if q.tenants != nil {
+		execQuery = q.tenants.Rewrite(execQuery)
+	}
+	if q.conflicts != nil {
+		execQuery = q.conflicts.Rewrite(execQuery, workerID)
+	}
+	if q.schemas != nil {
+		session.schema = q.schemas.Next()
+		execQuery = q.schemas.Rewrite(execQuery, session.schema)
+	}
+	session.withWarnings = q.warningsSampleRate > 0 && rand.Float64() < q.warningsSampleRate
+
+	q.dispatched.Add(1)
 	execStart := time.Now()
-	result, err := q.executeQuery(ctx, query.Query)
+	var result *QueryResult
+	if session.withWarnings || session.optimizerSwitch != "" || (session.schema != "" && q.raw == nil) {
+		result, err = q.executeQueryOnConn(ctx, workerID, session, execQuery)
+	} else {
+		result, err = q.executeQuery(ctx, workerID, session.schema, execQuery)
+	}
 	execLat := time.Since(execStart)
-	_ = execLat
+	q.busy.Add(int64(execLat))
+	if workerID < len(q.workers) {
+		q.workers[workerID].executed.Add(1)
+		q.workers[workerID].busy.Add(int64(execLat))
+	}
 
 	// if err != nil {
 	// 	return fmt.Errorf("error executing query \"%s\" with fingerprint \"%s\": %w", query.Query, query.Fingerprint, err)
 	// }
 
+	result.StatementType = classifyStatement(query.Query)
+	result.FingerprintHash = query.FingerprintHash
+	if !intended.IsZero() {
+		result.CorrectedLatency = result.CompletionTimestamp.Sub(intended)
+	}
+	if experiment != nil {
+		result.Experiment = experiment.Name
+		result.Hinted = hinted
+	}
+
+	if q.execLog != nil {
+		q.execLog.Record(workerID, query.FingerprintHash, result, err)
+	}
+	if q.slowLog != nil {
+		result.Exemplar = q.slowLog.Record(workerID, query.FingerprintHash, session.schema, execQuery, result, err)
+	}
+
+	if q.planDiffer != nil && q.planDiffer.Sampled() {
+		if err := q.planDiffer.Check(ctx, query.FingerprintHash, query.Query); err != nil {
+			q.logger.Debug().Err(err).Uint64("fingerprint_hash", query.FingerprintHash).Msg("Error diffing plans")
+		}
+	}
+	// EXPLAIN ANALYZE executes the statement, only reads are analyzed
+	if q.analyzer != nil && err == nil && result.StatementType == StatementSelect && q.analyzer.Sampled() {
+		if err := q.analyzer.Analyze(ctx, query.FingerprintHash, query.Query); err != nil {
+			q.logger.Debug().Err(err).Uint64("fingerprint_hash", query.FingerprintHash).Msg("Error analyzing plan")
+		}
+	}
+
+	// executions cut short by the end of the run are left out of the report
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
 	if err != nil {
 		result.Err = querierError{
-			query: query.Query,
+			query: execQuery,
 			// fingerprint: query.Fingerprint,
 			err: err,
 		}
@@ -194,16 +766,71 @@ func (q *Querier) do(ctx context.Context) error {
 	return nil
 }
 
-func (q *Querier) Run(ctx context.Context) error {
+const idleWorkerPoll = 10 * time.Millisecond
+
+// giveBack returns the count slot of a query that wasn't executed
+func (q *Querier) giveBack() {
+	if q.limit > 0 {
+		q.issued.Add(-1)
+	}
+}
+
+// Run executes queries until ctx is done, the count of the run is executed,
+// or the shard of the worker is replayed in the sequential and session run
+// modes, or the timeline in the replay run mode. workerID identifies the
+// calling goroutine in the execution log.
+func (q *Querier) Run(ctx context.Context, workerID int) error {
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
 		default:
-			if q.qpsTicker != nil {
-				<-q.qpsTicker.C
+			if limit := q.activeWorkers.Load(); q.paused.Load() || (limit > 0 && int64(workerID) >= limit) {
+				time.Sleep(idleWorkerPoll)
+				continue
+			}
+			if q.limit > 0 {
+				if q.CountReached() {
+					q.logger.Info().Int("worker_id", workerID).Msg("Worker reached the query count")
+					return nil
+				}
+				// the last slots are taken, wait for them to be executed
+				// or given back
+				if q.issued.Add(1) > q.limit {
+					q.issued.Add(-1)
+					time.Sleep(idleWorkerPoll)
+					continue
+				}
+			}
+			var intended time.Time
+			if q.pacer != nil {
+				waitStart := time.Now()
+				select {
+				case <-ctx.Done():
+					q.giveBack()
+					return nil
+				case intended = <-q.pacer.C:
+				}
+				if workerID < len(q.workers) {
+					q.workers[workerID].wait.Add(int64(time.Since(waitStart)))
+				}
+			}
+			err := q.do(ctx, workerID, intended)
+			if err == nil && q.limit > 0 {
+				q.executed.Add(1)
 			}
-			if err := q.do(ctx); err != nil {
+			if err != nil {
+				q.giveBack()
+				if errors.Is(err, errShardDone) {
+					q.logger.Info().Int("worker_id", workerID).Msg("Worker replayed its shard")
+					return nil
+				}
+				// the data source reports its outage once and counts the
+				// skipped picks
+				if errors.Is(err, errSourceDegraded) {
+					time.Sleep(idleWorkerPoll)
+					continue
+				}
 				q.logger.Error().Err(err).Msg("Error executing query")
 			}
 		}
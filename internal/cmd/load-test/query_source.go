@@ -6,7 +6,8 @@ import (
 )
 
 type QueryDataSourceResult struct {
-	Query string
+	Query           string
+	FingerprintHash uint64
 	// Fingerprint string
 }
 
@@ -68,3 +69,79 @@ func (qw *QueryFingerprintWeights) GetRandomWeighted() *QueryFingerprintData {
 
 	return nil
 }
+
+// WeightSmoothingConfig bounds the fingerprint weights, in percent of the
+// total, so a single dominant fingerprint does not crowd out the rest
+type WeightSmoothingConfig struct {
+	MaxWeight float64 `mapstructure:"max_weight" yaml:"max_weight" validate:"omitempty,gt=0,lte=100"`
+	MinWeight float64 `mapstructure:"min_weight" yaml:"min_weight" validate:"omitempty,gte=0,lt=100"`
+}
+
+func (c WeightSmoothingConfig) Enabled() bool {
+	return c.MaxWeight > 0 || c.MinWeight > 0
+}
+
+// Smooth caps every weight at MaxWeight and raises every weight to at least
+// MinWeight percent. The mass taken from capped fingerprints, or given to
+// floored ones, is spread proportionally over the others, so their relative
+// weights hold. Returns the number of adjusted fingerprints.
+func (qw *QueryFingerprintWeights) Smooth(cfg WeightSmoothingConfig) int {
+	if !cfg.Enabled() || qw.totalWeight <= 0 {
+		return 0
+	}
+	maxShare, minShare := cfg.MaxWeight/100, cfg.MinWeight/100
+	if maxShare <= 0 {
+		maxShare = 1
+	}
+
+	shares := make([]float64, len(qw.weights))
+	for i, w := range qw.weights {
+		shares[i] = w.weight / qw.totalWeight
+	}
+	fixed := make([]bool, len(qw.weights))
+	adjusted := 0
+
+	// every round fixes at least one fingerprint at a bound, or ends
+	for range len(shares) + 1 {
+		var fixedTotal, freeTotal float64
+		for i, s := range shares {
+			if fixed[i] {
+				fixedTotal += s
+			} else {
+				freeTotal += s
+			}
+		}
+		if freeTotal <= 0 {
+			break
+		}
+		scale := (1 - fixedTotal) / freeTotal
+
+		changed := false
+		for i := range shares {
+			if fixed[i] {
+				continue
+			}
+			shares[i] *= scale
+			switch {
+			case shares[i] > maxShare:
+				shares[i], fixed[i] = maxShare, true
+			case shares[i] < minShare:
+				shares[i], fixed[i] = minShare, true
+			default:
+				continue
+			}
+			changed = true
+			adjusted++
+		}
+		if !changed {
+			break
+		}
+	}
+
+	qw.totalWeight = 0
+	for i, w := range qw.weights {
+		w.weight = shares[i] * 100
+		qw.totalWeight += w.weight
+	}
+	return adjusted
+}
@@ -1,25 +1,62 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/rand"
+	myerror "mysql-load-test/internal/error"
 	"mysql-load-test/internal/lrucache"
+	"mysql-load-test/pkg/filemap"
+	"mysql-load-test/pkg/query"
+	"os"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
-
-	"golang.org/x/exp/mmap"
 )
 
 type QuerySourceDBConfig struct {
-	DSN                     string `mapstructure:"dsn" yaml:"dsn" validate:"required"`
+	DSN                     string `mapstructure:"dsn" yaml:"dsn" validate:"required_without=CacheFile"`
 	FingerprintWeightsQuery string `mapstructure:"fingerprint_weights_query" yaml:"fingerprint_weights_query" validate:"omitempty"`
 	QueriesFetchQuery       string `mapstructure:"queries_fetch_query" yaml:"queries_fetch_query" validate:"omitempty"`
 	QueriesIdsFetchQuery    string `mapstructure:"queries_ids_fetch_query" yaml:"queries_ids_fetch_query" validate:"omitempty"`
+	HourlyWeightsQuery      string `mapstructure:"hourly_weights_query" yaml:"hourly_weights_query" validate:"omitempty"`
 	InputFile               string `mapstructure:"input_file" yaml:"input_file" validate:"required"`
+	// FingerprintWeightsFile replaces FingerprintWeightsQuery with a CSV or
+	// JSON file, see loadWeightsFile
+	FingerprintWeightsFile string `mapstructure:"fingerprint_weights_file" yaml:"fingerprint_weights_file" validate:"omitempty,excluded_with=FingerprintWeightsQuery"`
+	// CacheFile reads the queries of every fingerprint from the cache output
+	// of the collector instead of the Query table
+	CacheFile string                `mapstructure:"cache_file" yaml:"cache_file" validate:"omitempty"`
+	Loading   MetadataLoadingConfig `mapstructure:"loading" yaml:"loading"`
+	// QueryCache keeps the texts of the recently picked queries
+	QueryCache QueryCacheConfig `mapstructure:"query_cache" yaml:"query_cache"`
+}
+
+// QueryCacheConfig caches the query texts read from the corpus. MaxBytes
+// bounds the size of the cached texts, query sizes vary too much for a count
+// to bound the memory, 0 disables the cache. TTL ages the texts out so a
+// long soak test doesn't keep serving those that were hot at its start,
+// 0 keeps them until evicted.
+type QueryCacheConfig struct {
+	MaxBytes int64         `mapstructure:"max_bytes" yaml:"max_bytes" validate:"omitempty,gte=0"`
+	TTL      time.Duration `mapstructure:"ttl" yaml:"ttl" validate:"omitempty,gte=0"`
+}
+
+// queryCacheEntryBytes is about the memory a cached query takes besides its
+// text
+const queryCacheEntryBytes = 150
+
+func queryCacheCost(_ int, query *QueryDataSourceResult) int64 {
+	return int64(len(query.Query)) + queryCacheEntryBytes
 }
 
 type queryMetadata struct {
@@ -30,14 +67,40 @@ type queryMetadata struct {
 type QuerySourceDB struct {
 	cfg *QuerySourceDBConfig
 
-	// Each fingerprint hash has cache to cache query by offset.
-	queriesCaches map[uint64]*lrucache.LRUCache[int, *QueryDataSourceResult]
+	// queryTexts caches the queries read from the corpus by query id, nil
+	// when disabled
+	queryTexts *lrucache.CostLRUCache[int, *QueryDataSourceResult]
 
 	fingerprintWeights    *QueryFingerprintWeights
+	smoothing             WeightSmoothingConfig
+	timeOfDay             TimeOfDayConfig
+	tagsCfg               FingerprintTagsConfig
+	tags                  FingerprintTags
+	hourlyWeights         *HourlyWeights
 	queryIdsByFingerprint map[uint64][]int
 
 	// Map of fingerprint hash to query id
 	queryMetadataByID map[int]queryMetadata
+	// sessions holds the session of the queries of a cache file collected
+	// with their sessions, by query id
+	sessions map[int]querySession
+	// timestamps holds the capture time of the queries of a cache file, by
+	// query id
+	timestamps map[int]uint64
+
+	// lazyQueries holds the queries of the recently picked fingerprints when
+	// they are fetched on first use, see MetadataLoadingConfig.Lazy
+	lazyQueries *lrucache.ShardedLRUCache[uint64, []lazyQuery]
+	// fingerprintQueriesStmt fetches the queries of a fingerprint on a lazy
+	// miss, prepared once so MySQL doesn't plan it again on every miss. It is
+	// prepared again after the database reconnected, stmtGeneration being
+	// the connection it was prepared on.
+	fingerprintQueriesStmt *sql.Stmt
+	stmtGeneration         uint64
+	stmtMu                 sync.Mutex
+	// health tracks the outages of the metadata database, which only lazy
+	// loading depends on during the run
+	health sourceHealth
 
 	queriesCountTotal uint64
 	db                *DBConn
@@ -50,8 +113,29 @@ type QuerySourceDB struct {
 
 	concurrency int
 
-	mmapReader *mmap.ReaderAt
-	mmapData   []byte
+	corpus *filemap.File
+
+	// loadStart is when load started, the startup budget runs from there
+	loadStart time.Time
+
+	// loading progress for Readiness, written by load
+	corpusMapped  atomic.Bool
+	weightsLoaded atomic.Bool
+	queriesLoaded atomic.Bool
+	fingerprints  atomic.Int64
+	queries       atomic.Int64
+}
+
+type lazyQuery struct {
+	id   int
+	meta queryMetadata
+}
+
+// querySession is the client connection a query was captured on and its
+// position in it
+type querySession struct {
+	id  uint64
+	seq uint64
 }
 
 type FileOffsetResult struct {
@@ -71,15 +155,38 @@ func executeTemplate(tmpl string, data any, templateName string) (string, error)
 	return buf.String(), nil
 }
 
-func NewQuerySourceDB(cfg *QuerySourceDBConfig, concurrency int, fingerprintWeights *QueryFingerprintWeights) (*QuerySourceDB, error) {
+func NewQuerySourceDB(cfg *QuerySourceDBConfig, concurrency int, fingerprintWeights *QueryFingerprintWeights, smoothing WeightSmoothingConfig, timeOfDay TimeOfDayConfig, tags FingerprintTagsConfig) (*QuerySourceDB, error) {
+	if err := tags.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Loading.Lazy && cfg.CacheFile != "" {
+		return nil, fmt.Errorf("lazy loading reads the Query table and can't be used with cache_file")
+	}
+	if cfg.DSN == "" {
+		// everything else lives in the metadata database
+		switch {
+		case cfg.FingerprintWeightsFile == "":
+			return nil, fmt.Errorf("a query data source without dsn requires fingerprint_weights_file")
+		case timeOfDay.Enabled:
+			return nil, fmt.Errorf("time of day replay requires the dsn of the query data source")
+		case tags.Enabled():
+			return nil, fmt.Errorf("tag filters require the dsn of the query data source")
+		}
+	}
 	qsdb := &QuerySourceDB{
 		fingerprintWeights:    fingerprintWeights,
+		smoothing:             smoothing,
+		timeOfDay:             timeOfDay,
+		tagsCfg:               tags,
 		cfg:                   cfg,
 		perfStats:             &QuerySourceDBInternalPerfStats{},
 		concurrency:           concurrency,
 		queryIdsByFingerprint: make(map[uint64][]int),
 		queryMetadataByID:     make(map[int]queryMetadata),
 	}
+	if cfg.QueryCache.MaxBytes > 0 {
+		qsdb.queryTexts = lrucache.NewCostWithTTL(cfg.QueryCache.MaxBytes, queryCacheCost, cfg.QueryCache.TTL)
+	}
 	return qsdb, nil
 }
 
@@ -88,57 +195,231 @@ func (qsdb *QuerySourceDB) fetchWeights(ctx context.Context) error {
 		return nil
 	}
 
-	qsdb.fingerprintWeights = NewQueryFingerprintWeights()
+	if qsdb.cfg.FingerprintWeightsFile != "" {
+		weights, err := loadWeightsFile(qsdb.cfg.FingerprintWeightsFile)
+		if err != nil {
+			return err
+		}
+		qsdb.fingerprintWeights = weights
+		logger.Info().
+			Str("file", qsdb.cfg.FingerprintWeightsFile).
+			Int("fingerprints", len(weights.weights)).
+			Msg("Loaded fingerprint weights from file")
+	} else {
+		weights, err := qsdb.queryWeights(ctx)
+		if err != nil {
+			return err
+		}
+		qsdb.fingerprintWeights = weights
 
-	rows, err := qsdb.db.QueryContext(ctx, qsdb.cfg.FingerprintWeightsQuery)
+		if qsdb.fingerprintWeights.totalWeight == 0 {
+			return fmt.Errorf("no query weights were loaded from the database")
+		}
+	}
+
+	selected, err := qsdb.applyTags(qsdb.fingerprintWeights)
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
+	qsdb.fingerprintWeights = selected
+
+	if adjusted := qsdb.fingerprintWeights.Smooth(qsdb.smoothing); adjusted > 0 {
+		logger.Info().
+			Int("adjusted", adjusted).
+			Float64("max_weight", qsdb.smoothing.MaxWeight).
+			Float64("min_weight", qsdb.smoothing.MinWeight).
+			Msg("Smoothed fingerprint weights")
+	}
 
-	for rows.Next() {
-		var hash uint64
-		var count, total int64
-		var weight float64
+	return nil
 
-		if err := rows.Scan(&hash, &count, &total, &weight); err != nil {
-			return err
-		}
-		qsdb.fingerprintWeights.Add(weight, &QueryFingerprintData{
-			Hash:      hash,
-			FreqTotal: count,
-		})
-	}
+}
 
-	if qsdb.fingerprintWeights.totalWeight == 0 {
-		return fmt.Errorf("no query weights were loaded from the database")
+// queryWeights runs the weights query, in keyset pages of hash ranges when
+// it is a template and loading is parallel
+func (qsdb *QuerySourceDB) queryWeights(ctx context.Context) (*QueryFingerprintWeights, error) {
+	type weightRow struct {
+		hash   uint64
+		count  int64
+		weight float64
+	}
+	scan := func(rows *sql.Rows) (uint64, weightRow, error) {
+		var row weightRow
+		var total int64
+		err := rows.Scan(&row.hash, &row.count, &total, &row.weight)
+		return row.hash, row, err
 	}
 
-	return nil
+	loading := qsdb.cfg.Loading
+	progress := startLoadProgress("fingerprint weights", loading.progressInterval())
+	var rows []weightRow
+	var err error
+	if loading.Parallelism > 0 && isKeysetTemplate(qsdb.cfg.FingerprintWeightsQuery) {
+		ranges := splitKeyRange(0, math.MaxUint64, loading.Parallelism)
+		rows, err = loadKeyset(ctx, qsdb.db, ranges, loading.pageSize(), progress,
+			func(r keyRange, limit int) (string, []any, error) {
+				query, err := executeTemplate(qsdb.cfg.FingerprintWeightsQuery, map[string]any{"From": r.From, "To": r.To, "Limit": limit}, "fingerprint_weights_query")
+				return query, nil, err
+			}, scan)
+	} else {
+		if loading.Parallelism > 0 {
+			logger.Warn().Msg("fingerprint_weights_query takes no key range, loading it in one pass")
+		}
+		rows, err = loadKeyset(ctx, qsdb.db, []keyRange{{From: 0, To: math.MaxUint64}}, math.MaxInt, progress,
+			func(keyRange, int) (string, []any, error) {
+				return qsdb.cfg.FingerprintWeightsQuery, nil, nil
+			}, scan)
+	}
+	progress.Finish()
+	if err != nil {
+		return nil, err
+	}
 
+	weights := NewQueryFingerprintWeights()
+	for _, row := range rows {
+		weights.Add(row.weight, &QueryFingerprintData{
+			Hash:      row.hash,
+			FreqTotal: row.count,
+		})
+	}
+	return weights, nil
 }
 
 func (qsdb *QuerySourceDB) fetchAllQueryMetadata(ctx context.Context) error {
+	if qsdb.cfg.CacheFile != "" {
+		return qsdb.loadCacheQueryMetadata()
+	}
 	logger.Info().Msg("Pre-loading all query metadata into memory...")
 
+	type metadataRow struct {
+		id              int
+		fingerprintHash uint64
+		meta            queryMetadata
+	}
+	scan := func(rows *sql.Rows) (uint64, metadataRow, error) {
+		var row metadataRow
+		err := rows.Scan(&row.id, &row.fingerprintHash, &row.meta.Offset, &row.meta.Length)
+		return uint64(row.id), row, err
+	}
+
+	loading := qsdb.cfg.Loading
+	guard, err := loading.indexGuard(qsdb.estimateQueries(ctx), qsdb.budgetLeft())
+	if err != nil {
+		return err
+	}
+	ranges := []keyRange{{From: 0, To: math.MaxUint64}}
+	limit := math.MaxInt
 	query := "SELECT ID, FingerprintHash, `Offset`, `Length` FROM Query"
+	if loading.Parallelism > 0 {
+		var minID, maxID uint64
+		row, err := qsdb.db.QueryRowContext(ctx, "SELECT COALESCE(MIN(ID), 0), COALESCE(MAX(ID), 0) FROM Query")
+		if err != nil {
+			return err
+		}
+		if err := row.Scan(&minID, &maxID); err != nil {
+			return err
+		}
+		ranges = splitKeyRange(minID, maxID, loading.Parallelism)
+		limit = loading.pageSize()
+		query += " WHERE ID BETWEEN ? AND ? ORDER BY ID LIMIT ?"
+	}
 
-	rows, err := qsdb.db.QueryContext(ctx, query)
+	progress := startLoadProgress("query metadata", loading.progressInterval())
+	progress.guard = guard
+	rows, err := loadKeyset(ctx, qsdb.db, ranges, limit, progress,
+		func(r keyRange, limit int) (string, []any, error) {
+			if loading.Parallelism > 0 {
+				return query, []any{r.From, r.To, limit}, nil
+			}
+			return query, nil, nil
+		}, scan)
+	progress.Finish()
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
+	for _, row := range rows {
+		qsdb.queryIdsByFingerprint[row.fingerprintHash] = append(qsdb.queryIdsByFingerprint[row.fingerprintHash], row.id)
+		qsdb.queryMetadataByID[row.id] = row.meta
+	}
+
+	logger.Info().Int("count", len(rows)).Msg("Successfully pre-loaded query metadata.")
+	return nil
+}
+
+// estimateQueries returns the row count estimate of the Query table from its
+// statistics, 0 when unknown. Counting the rows would take as long as the
+// load on the tables that need the estimate.
+func (qsdb *QuerySourceDB) estimateQueries(ctx context.Context) int64 {
+	row, err := qsdb.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(TABLE_ROWS), 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'Query'")
+	if err != nil {
+		logger.Debug().Err(err).Msg("Error estimating the size of the Query table")
+		return 0
+	}
+	var rows int64
+	if err := row.Scan(&rows); err != nil {
+		logger.Debug().Err(err).Msg("Error estimating the size of the Query table")
+		return 0
+	}
+	return rows
+}
+
+// budgetLeft returns what is left of the startup budget, 0 without one
+func (qsdb *QuerySourceDB) budgetLeft() time.Duration {
+	budget := qsdb.cfg.Loading.StartupBudget
+	if budget <= 0 {
+		return 0
+	}
+	return max(budget-time.Since(qsdb.loadStart), time.Nanosecond)
+}
+
+// loadCacheQueryMetadata reads the query metadata from the cache output of
+// the collector, numbering the queries in their order
+func (qsdb *QuerySourceDB) loadCacheQueryMetadata() error {
+	logger.Info().Str("file", qsdb.cfg.CacheFile).Msg("Pre-loading all query metadata from the cache file...")
+
+	f, err := os.Open(qsdb.cfg.CacheFile)
+	if err != nil {
+		return fmt.Errorf("error opening cache file: %w", err)
+	}
+	defer f.Close()
+	reader, err := query.NewCacheReader(bufio.NewReaderSize(f, 1024*1024))
+	if err != nil {
+		return fmt.Errorf("error reading cache file: %w", err)
+	}
+
+	// the number of queries of the cache is only known once read
+	guard, err := qsdb.cfg.Loading.indexGuard(0, 0)
+	if err != nil {
+		return err
+	}
 	loadedCount := 0
-	for rows.Next() {
-		var id int
-		var fingerprintHash, offset, length uint64
-		if err := rows.Scan(&id, &fingerprintHash, &offset, &length); err != nil {
+	var q query.Query
+	for {
+		if err := reader.Read(&q); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("error reading cache file: %w", err)
+		}
+		if err := guard.check(int64(loadedCount + 1)); err != nil {
 			return err
 		}
-
-		qsdb.queryIdsByFingerprint[fingerprintHash] = append(qsdb.queryIdsByFingerprint[fingerprintHash], id)
-		qsdb.queryMetadataByID[id] = queryMetadata{Offset: offset, Length: length}
+		id := loadedCount
+		qsdb.queryIdsByFingerprint[q.FingerprintHash] = append(qsdb.queryIdsByFingerprint[q.FingerprintHash], id)
+		qsdb.queryMetadataByID[id] = queryMetadata{Offset: q.Offset, Length: q.Length}
+		if q.Session != 0 {
+			if qsdb.sessions == nil {
+				qsdb.sessions = make(map[int]querySession)
+			}
+			qsdb.sessions[id] = querySession{id: q.Session, seq: q.SessionSeq}
+		}
+		if q.Timestamp != 0 {
+			if qsdb.timestamps == nil {
+				qsdb.timestamps = make(map[int]uint64)
+			}
+			qsdb.timestamps[id] = q.Timestamp
+		}
 		loadedCount++
 	}
 
@@ -146,19 +427,65 @@ func (qsdb *QuerySourceDB) fetchAllQueryMetadata(ctx context.Context) error {
 	return nil
 }
 
+// dropMissingFingerprints removes the weights of fingerprints without any
+// query, which a weights file may list for another corpus
+func (qsdb *QuerySourceDB) dropMissingFingerprints() error {
+	kept := NewQueryFingerprintWeights()
+	dropped := 0
+	for _, w := range qsdb.fingerprintWeights.weights {
+		if len(qsdb.queryIdsByFingerprint[w.fingerprintData.Hash]) == 0 {
+			dropped++
+			continue
+		}
+		kept.Add(w.weight, w.fingerprintData)
+	}
+	if kept.totalWeight <= 0 {
+		return fmt.Errorf("none of the fingerprints of %s has queries in the corpus", qsdb.cfg.FingerprintWeightsFile)
+	}
+	if dropped > 0 {
+		logger.Warn().Int("dropped", dropped).Msg("Dropped weighted fingerprints without queries in the corpus")
+	}
+	qsdb.fingerprintWeights = kept
+	return nil
+}
+
 func (qsdb *QuerySourceDB) Init(ctx context.Context) error {
+	if qsdb.queryTexts != nil && qsdb.cfg.QueryCache.TTL > 0 {
+		qsdb.queryTexts.StartExpiry(ctx, qsdb.cfg.QueryCache.TTL)
+	}
 	qsdb.fetchWeightsOnce = sync.OnceValue(func() error {
 		return qsdb.fetchWeights(ctx)
 	})
 
 	qsdb.initOnce = sync.OnceValue(func() error {
-		logger.Info().Str("file", qsdb.cfg.InputFile).Msg("Memory mapping the input file")
-		reader, err := mmap.Open(qsdb.cfg.InputFile)
-		if err != nil {
-			return fmt.Errorf("failed to memory-map input file: %w", err)
+		budget := qsdb.cfg.Loading.StartupBudget
+		if budget <= 0 {
+			return qsdb.load(ctx)
+		}
+		loadCtx, cancel := context.WithTimeout(ctx, budget)
+		defer cancel()
+		err := qsdb.load(loadCtx)
+		if err != nil && errors.Is(loadCtx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("loading the query metadata took longer than the startup budget of %s: %w", budget, err)
 		}
-		qsdb.mmapReader = reader
+		return err
+	})
+	return qsdb.initOnce()
+}
+
+// load opens the corpus and the metadata database and loads everything the
+// weighted picks need
+func (qsdb *QuerySourceDB) load(ctx context.Context) error {
+	qsdb.loadStart = time.Now()
+	logger.Info().Str("file", qsdb.cfg.InputFile).Msg("Memory mapping the input file")
+	corpus, err := filemap.Open(qsdb.cfg.InputFile)
+	if err != nil {
+		return fmt.Errorf("failed to memory-map input file: %w", err)
+	}
+	qsdb.corpus = corpus
+	qsdb.corpusMapped.Store(true)
 
+	if qsdb.cfg.DSN != "" {
 		logger.Info().Msg("Opening database connection for query data source DB")
 		db := NewDBConn(RetryConfig{
 			MaxRetries:    3,
@@ -166,28 +493,79 @@ func (qsdb *QuerySourceDB) Init(ctx context.Context) error {
 			MaxDelay:      5 * time.Second,
 			BackoffFactor: 2.0,
 		})
-		if err := db.Open(qsdb.cfg.DSN, qsdb.concurrency); err != nil {
+		if err := db.Open(qsdb.cfg.DSN, max(qsdb.concurrency, qsdb.cfg.Loading.Parallelism)); err != nil {
 			return fmt.Errorf("error opening database: %w", err)
 		}
 		qsdb.db = db
 
-		logger.Info().Msg("Fetching query weights...")
-		if err := qsdb.fetchWeights(ctx); err != nil {
-			return fmt.Errorf("error fetching weights: %w", err)
+		logger.Info().Msg("Fetching fingerprint tags...")
+		if err := qsdb.fetchTags(ctx); err != nil {
+			return fmt.Errorf("error fetching fingerprint tags: %w", err)
 		}
+	}
+
+	logger.Info().Msg("Fetching query weights...")
+	if err := qsdb.fetchWeights(ctx); err != nil {
+		return fmt.Errorf("error fetching weights: %w", err)
+	}
 
-		if err := qsdb.fetchAllQueryMetadata(ctx); err != nil {
-			return fmt.Errorf("error pre-loading query metadata: %w", err)
+	if qsdb.timeOfDay.Enabled {
+		logger.Info().Msg("Fetching hourly query weights...")
+		if err := qsdb.fetchHourlyWeights(ctx); err != nil {
+			return fmt.Errorf("error fetching hourly weights: %w", err)
 		}
+	}
 
-		return nil
-	})
-	return qsdb.initOnce()
+	qsdb.fingerprints.Store(int64(len(qsdb.fingerprintWeights.weights)))
+	qsdb.weightsLoaded.Store(true)
+
+	if qsdb.cfg.Loading.Lazy {
+		size := qsdb.cfg.Loading.lazyCacheSize()
+		logger.Info().Int("cache_size", size).Msg("Fetching the queries of every fingerprint on first use")
+		qsdb.lazyQueries = lrucache.NewSharded[uint64, []lazyQuery](16, size)
+		if _, err := qsdb.fingerprintStmt(ctx); err != nil {
+			return err
+		}
+	} else if err := qsdb.fetchAllQueryMetadata(ctx); err != nil {
+		return fmt.Errorf("error pre-loading query metadata: %w", err)
+	}
+	if qsdb.cfg.FingerprintWeightsFile != "" && qsdb.lazyQueries == nil {
+		if err := qsdb.dropMissingFingerprints(); err != nil {
+			return err
+		}
+	}
+
+	qsdb.fingerprints.Store(int64(len(qsdb.fingerprintWeights.weights)))
+	qsdb.queries.Store(int64(len(qsdb.queryMetadataByID)))
+	qsdb.queriesLoaded.Store(true)
+
+	if qsdb.hourlyWeights != nil {
+		qsdb.hourlyWeights.Restart()
+	}
+
+	return nil
+}
+
+func (qsdb *QuerySourceDB) Readiness() DataSourceReadiness {
+	return DataSourceReadiness{
+		WeightsLoaded: qsdb.weightsLoaded.Load(),
+		Fingerprints:  int(qsdb.fingerprints.Load()),
+		QueriesLoaded: qsdb.queriesLoaded.Load(),
+		Queries:       int(qsdb.queries.Load()),
+		Lazy:          qsdb.cfg.Loading.Lazy,
+		CorpusMapped:  qsdb.corpusMapped.Load(),
+	}
 }
 
 func (qsdb *QuerySourceDB) Destroy() error {
-	if qsdb.mmapReader != nil {
-		qsdb.mmapReader.Close()
+	qsdb.stmtMu.Lock()
+	if qsdb.fingerprintQueriesStmt != nil {
+		qsdb.fingerprintQueriesStmt.Close()
+		qsdb.fingerprintQueriesStmt = nil
+	}
+	qsdb.stmtMu.Unlock()
+	if qsdb.corpus != nil {
+		qsdb.corpus.Close()
 	}
 	if qsdb.db != nil {
 		return qsdb.db.Close()
@@ -199,51 +577,367 @@ func (qsdb *QuerySourceDB) PerfStats() any {
 	qsdb.mu.RLock()
 	defer qsdb.mu.RUnlock()
 	cacheStats := lrucache.LRUCacheStats{}
-	for _, queriesCache := range qsdb.queriesCaches {
-		cacheStats.HitsTotal += queriesCache.Stats().HitsTotal
-		cacheStats.MissesTotal += queriesCache.Stats().MissesTotal
-		cacheStats.EvictionsTotal += queriesCache.Stats().EvictionsTotal
-		cacheStats.MoveToFrontTotal += queriesCache.Stats().MoveToFrontTotal
-		cacheStats.NewItemsTotal += queriesCache.Stats().NewItemsTotal
+	caches := make([]interface{ Stats() lrucache.LRUCacheStats }, 0, 2)
+	if qsdb.queryTexts != nil {
+		caches = append(caches, qsdb.queryTexts)
+	}
+	if qsdb.lazyQueries != nil {
+		caches = append(caches, qsdb.lazyQueries)
+	}
+	for _, cache := range caches {
+		stats := cache.Stats()
+		cacheStats.HitsTotal += stats.HitsTotal
+		cacheStats.MissesTotal += stats.MissesTotal
+		cacheStats.EvictionsTotal += stats.EvictionsTotal
+		cacheStats.ExpirationsTotal += stats.ExpirationsTotal
+		cacheStats.MoveToFrontTotal += stats.MoveToFrontTotal
+		cacheStats.NewItemsTotal += stats.NewItemsTotal
 	}
 	qsdb.perfStats.CacheStats = cacheStats
 	return *qsdb.perfStats
 }
 
 func (qsdb *QuerySourceDB) GetRandomWeightedQuery(ctx context.Context) (*QueryDataSourceResult, error) {
-	fingerprintData := qsdb.fingerprintWeights.GetRandomWeighted()
+	weights := qsdb.fingerprintWeights
+	if qsdb.hourlyWeights != nil {
+		weights = qsdb.hourlyWeights.At(time.Now())
+	}
+	fingerprintData := weights.GetRandomWeighted()
 	if fingerprintData == nil {
 		return nil, fmt.Errorf("failed to get random weighted fingerprint")
 	}
+	if qsdb.lazyQueries != nil && qsdb.health.degraded.Load() {
+		if fingerprintData = qsdb.cachedFingerprint(weights, fingerprintData); fingerprintData == nil {
+			qsdb.health.skipped.Add(1)
+			return nil, errSourceDegraded
+		}
+	}
 	fingerprintHash := fingerprintData.Hash
 
-	queryIds, ok := qsdb.queryIdsByFingerprint[fingerprintHash]
-	if !ok || len(queryIds) == 0 {
-		return nil, fmt.Errorf("no query IDs found in-memory for fingerprint hash: %d", fingerprintHash)
+	queryId, meta, err := qsdb.pickQuery(ctx, fingerprintHash)
+	if err != nil {
+		return nil, err
 	}
-	queryId := queryIds[rand.Intn(len(queryIds))]
+	return qsdb.readQuery(queryId, fingerprintHash, meta)
+}
 
-	meta, ok := qsdb.queryMetadataByID[queryId]
-	if !ok {
-		return nil, fmt.Errorf("no query metadata found in-memory for ID: %d", queryId)
+// cachedFingerprint returns picked when its queries are cached, or another
+// weighted pick among the cached fingerprints, nil when none came up
+func (qsdb *QuerySourceDB) cachedFingerprint(weights *QueryFingerprintWeights, picked *QueryFingerprintData) *QueryFingerprintData {
+	if _, ok := qsdb.lazyQueries.Peek(picked.Hash); ok {
+		return picked
+	}
+	for range maxCachedPicks {
+		fingerprintData := weights.GetRandomWeighted()
+		if _, ok := qsdb.lazyQueries.Peek(fingerprintData.Hash); ok {
+			qsdb.health.substituted.Add(1)
+			return fingerprintData
+		}
 	}
+	return nil
+}
 
-	lineBytes := make([]byte, meta.Length)
-	if _, err := qsdb.mmapReader.ReadAt(lineBytes, int64(meta.Offset)); err != nil {
-		return nil, fmt.Errorf("failed to read segment data from mmap: %w", err)
+// Health returns the availability of the metadata database, nil unless
+// queries are loaded lazily
+func (qsdb *QuerySourceDB) Health() *QuerySourceHealth {
+	if qsdb.lazyQueries == nil {
+		return nil
+	}
+	return qsdb.health.report()
+}
+
+// readQuery returns the text of a query, from the query cache or the corpus
+func (qsdb *QuerySourceDB) readQuery(queryId int, fingerprintHash uint64, meta queryMetadata) (*QueryDataSourceResult, error) {
+	if qsdb.queryTexts == nil {
+		return qsdb.readCorpusQuery(queryId, fingerprintHash, meta)
+	}
+	var err error
+	query, _ := qsdb.queryTexts.GetOrSet(queryId, func() (*QueryDataSourceResult, error) {
+		var query *QueryDataSourceResult
+		query, err = qsdb.readCorpusQuery(queryId, fingerprintHash, meta)
+		return query, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return query, nil
+}
+
+// readCorpusQuery reads the text of a query from the corpus
+func (qsdb *QuerySourceDB) readCorpusQuery(queryId int, fingerprintHash uint64, meta queryMetadata) (*QueryDataSourceResult, error) {
+	lineBytes, err := qsdb.corpus.Segment(int64(meta.Offset), int64(meta.Length))
+	if err != nil {
+		return nil, myerror.Wrap(err, "failed to read segment data from mmap", "query_id", queryId)
 	}
 
 	parts := bytes.SplitN(lineBytes, []byte("\t"), 2)
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid query format in file at offset %d", meta.Offset)
+		return nil, myerror.New("invalid query format in file", "query_id", queryId, "offset", meta.Offset)
 	}
 	rawQuery := bytes.TrimSpace(parts[1])
 
 	return &QueryDataSourceResult{
-		Query: string(rawQuery),
+		Query:           string(rawQuery),
+		FingerprintHash: fingerprintHash,
 	}, nil
 }
 
+// SequentialQueries lists the queries of the weighted fingerprints by ID,
+// the order they were collected in
+func (qsdb *QuerySourceDB) SequentialQueries() ([]sequentialQuery, error) {
+	if qsdb.lazyQueries != nil {
+		return nil, fmt.Errorf("the sequential run mode needs the query metadata loaded at start, not lazily")
+	}
+	var queries []sequentialQuery
+	for _, w := range qsdb.fingerprintWeights.weights {
+		hash := w.fingerprintData.Hash
+		for _, id := range qsdb.queryIdsByFingerprint[hash] {
+			queries = append(queries, sequentialQuery{id: id, fingerprintHash: hash})
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool { return queries[i].id < queries[j].id })
+	return queries, nil
+}
+
+func (qsdb *QuerySourceDB) SequentialQuery(q sequentialQuery) (*QueryDataSourceResult, error) {
+	meta, ok := qsdb.queryMetadataByID[q.id]
+	if !ok {
+		return nil, myerror.New("no query metadata found in-memory", "query_id", q.id)
+	}
+	return qsdb.readQuery(q.id, q.fingerprintHash, meta)
+}
+
+// SessionQueries lists the queries collected with their session, grouped by
+// session in the order the sessions started. The queries of a session are in
+// their order in it, whatever the weights and tags.
+func (qsdb *QuerySourceDB) SessionQueries(ctx context.Context) ([][]sequentialQuery, error) {
+	if qsdb.lazyQueries != nil {
+		return nil, fmt.Errorf("the session run mode needs the query metadata loaded at start, not lazily")
+	}
+	sessions := qsdb.sessions
+	if qsdb.cfg.CacheFile == "" && qsdb.db != nil {
+		var err error
+		if sessions, err = qsdb.fetchSessions(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	fingerprints := make(map[int]uint64, len(sessions))
+	for hash, ids := range qsdb.queryIdsByFingerprint {
+		for _, id := range ids {
+			if _, ok := sessions[id]; ok {
+				fingerprints[id] = hash
+			}
+		}
+	}
+	type sessionQuery struct {
+		sequentialQuery
+		seq uint64
+	}
+	byID := make(map[uint64][]sessionQuery)
+	for id, s := range sessions {
+		hash, ok := fingerprints[id]
+		if !ok {
+			continue
+		}
+		byID[s.id] = append(byID[s.id], sessionQuery{sequentialQuery{id: id, fingerprintHash: hash}, s.seq})
+	}
+
+	grouped := make([][]sequentialQuery, 0, len(byID))
+	for _, queries := range byID {
+		sort.Slice(queries, func(i, j int) bool {
+			if queries[i].seq != queries[j].seq {
+				return queries[i].seq < queries[j].seq
+			}
+			return queries[i].id < queries[j].id
+		})
+		session := make([]sequentialQuery, len(queries))
+		for i, q := range queries {
+			session[i] = q.sequentialQuery
+		}
+		grouped = append(grouped, session)
+	}
+	sort.Slice(grouped, func(i, j int) bool { return grouped[i][0].id < grouped[j][0].id })
+	return grouped, nil
+}
+
+// fetchSessions reads the session of the queries collected with one from
+// the Query table
+func (qsdb *QuerySourceDB) fetchSessions(ctx context.Context) (map[int]querySession, error) {
+	rows, err := qsdb.db.QueryContext(ctx, "SELECT ID, `Session`, SessionSeq FROM Query WHERE `Session` <> 0")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching query sessions: %w", err)
+	}
+	defer rows.Close()
+	sessions := make(map[int]querySession)
+	for rows.Next() {
+		var id int
+		var s querySession
+		if err := rows.Scan(&id, &s.id, &s.seq); err != nil {
+			return nil, fmt.Errorf("error scanning query session: %w", err)
+		}
+		sessions[id] = s
+	}
+	return sessions, rows.Err()
+}
+
+// TimelineQueries lists the queries of the weighted fingerprints with a
+// capture timestamp, by timestamp then ID
+func (qsdb *QuerySourceDB) TimelineQueries(ctx context.Context) ([]timelineQuery, error) {
+	if qsdb.lazyQueries != nil {
+		return nil, fmt.Errorf("the replay run mode needs the query metadata loaded at start, not lazily")
+	}
+	timestamps := qsdb.timestamps
+	if qsdb.cfg.CacheFile == "" && qsdb.db != nil {
+		var err error
+		if timestamps, err = qsdb.fetchTimestamps(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var queries []timelineQuery
+	for _, w := range qsdb.fingerprintWeights.weights {
+		hash := w.fingerprintData.Hash
+		for _, id := range qsdb.queryIdsByFingerprint[hash] {
+			if ts, ok := timestamps[id]; ok {
+				queries = append(queries, timelineQuery{sequentialQuery{id: id, fingerprintHash: hash}, ts})
+			}
+		}
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		if queries[i].timestamp != queries[j].timestamp {
+			return queries[i].timestamp < queries[j].timestamp
+		}
+		return queries[i].id < queries[j].id
+	})
+	return queries, nil
+}
+
+// fetchTimestamps reads the capture time of the queries that have one from
+// the Query table
+func (qsdb *QuerySourceDB) fetchTimestamps(ctx context.Context) (map[int]uint64, error) {
+	rows, err := qsdb.db.QueryContext(ctx, "SELECT ID, `Timestamp` FROM Query WHERE `Timestamp` <> 0")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching query timestamps: %w", err)
+	}
+	defer rows.Close()
+	timestamps := make(map[int]uint64)
+	for rows.Next() {
+		var id int
+		var ts uint64
+		if err := rows.Scan(&id, &ts); err != nil {
+			return nil, fmt.Errorf("error scanning query timestamp: %w", err)
+		}
+		timestamps[id] = ts
+	}
+	return timestamps, rows.Err()
+}
+
+// pickQuery picks a random query of the fingerprint
+func (qsdb *QuerySourceDB) pickQuery(ctx context.Context, fingerprintHash uint64) (int, queryMetadata, error) {
+	if qsdb.lazyQueries != nil {
+		queries, err := qsdb.fingerprintQueries(ctx, fingerprintHash)
+		if err != nil {
+			return 0, queryMetadata{}, err
+		}
+		q := queries[rand.Intn(len(queries))]
+		return q.id, q.meta, nil
+	}
+
+	queryIds, ok := qsdb.queryIdsByFingerprint[fingerprintHash]
+	if !ok || len(queryIds) == 0 {
+		return 0, queryMetadata{}, myerror.New("no query IDs found in-memory for fingerprint", "fingerprint_hash", fingerprintHash)
+	}
+	queryId := queryIds[rand.Intn(len(queryIds))]
+
+	meta, ok := qsdb.queryMetadataByID[queryId]
+	if !ok {
+		return 0, queryMetadata{}, myerror.New("no query metadata found in-memory", "query_id", queryId)
+	}
+	return queryId, meta, nil
+}
+
+// fingerprintQueries returns the queries of a fingerprint from the cache, or
+// fetches them on a miss
+func (qsdb *QuerySourceDB) fingerprintQueries(ctx context.Context, fingerprintHash uint64) ([]lazyQuery, error) {
+	var fetchErr error
+	queries, _ := qsdb.lazyQueries.GetOrSet(fingerprintHash, func() ([]lazyQuery, error) {
+		queries, err := qsdb.fetchFingerprintQueries(ctx, fingerprintHash)
+		fetchErr = err
+		return queries, err
+	})
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	return queries, nil
+}
+
+func (qsdb *QuerySourceDB) fetchFingerprintQueries(ctx context.Context, fingerprintHash uint64) ([]lazyQuery, error) {
+	start := time.Now()
+	queries, err := qsdb.queryFingerprintQueries(ctx, fingerprintHash)
+	if err != nil {
+		qsdb.health.fail(ctx, err, qsdb.db.PingContext)
+		return nil, myerror.Wrap(fmt.Errorf("%w: %w", errSourceDegraded, err), "failed to fetch the queries of fingerprint", "fingerprint_hash", fingerprintHash)
+	}
+
+	qsdb.mu.Lock()
+	qsdb.perfStats.QueriesFetchTotal++
+	qsdb.perfStats.FetchIdsLat += time.Since(start)
+	qsdb.mu.Unlock()
+
+	if len(queries) == 0 {
+		return nil, myerror.New("no queries found for fingerprint", "fingerprint_hash", fingerprintHash)
+	}
+	return queries, nil
+}
+
+// fingerprintStmt returns the statement fetching the queries of a
+// fingerprint, preparing it when the database reconnected since, which closed
+// the statement with the old connection pool
+func (qsdb *QuerySourceDB) fingerprintStmt(ctx context.Context) (*sql.Stmt, error) {
+	qsdb.stmtMu.Lock()
+	defer qsdb.stmtMu.Unlock()
+	generation := qsdb.db.generation.Load()
+	if qsdb.fingerprintQueriesStmt != nil && qsdb.stmtGeneration == generation {
+		return qsdb.fingerprintQueriesStmt, nil
+	}
+	stmt, err := qsdb.db.PrepareContext(ctx, "SELECT ID, `Offset`, `Length` FROM Query WHERE FingerprintHash = ?")
+	if err != nil {
+		return nil, fmt.Errorf("error preparing the fingerprint queries statement: %w", err)
+	}
+	if qsdb.fingerprintQueriesStmt != nil {
+		qsdb.fingerprintQueriesStmt.Close()
+	}
+	qsdb.fingerprintQueriesStmt = stmt
+	qsdb.stmtGeneration = qsdb.db.generation.Load()
+	return stmt, nil
+}
+
+func (qsdb *QuerySourceDB) queryFingerprintQueries(ctx context.Context, fingerprintHash uint64) ([]lazyQuery, error) {
+	stmt, err := qsdb.fingerprintStmt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, fingerprintHash)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching the queries of fingerprint: %w", err)
+	}
+	defer rows.Close()
+
+	var queries []lazyQuery
+	for rows.Next() {
+		var q lazyQuery
+		if err := rows.Scan(&q.id, &q.meta.Offset, &q.meta.Length); err != nil {
+			return nil, fmt.Errorf("error scanning the queries of fingerprint: %w", err)
+		}
+		queries = append(queries, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching the queries of fingerprint: %w", err)
+	}
+	return queries, nil
+}
+
 type QuerySourceDBInternalPerfStats struct {
 	QueriesFetchTotal int
 	CacheStats        lrucache.LRUCacheStats
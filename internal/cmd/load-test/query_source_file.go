@@ -4,9 +4,8 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
-	"io"
 	"math/rand"
-	"os"
+	"mysql-load-test/pkg/filemap"
 	"sync"
 	"time"
 )
@@ -23,6 +22,7 @@ type queryInfo struct {
 type QuerySourceFile struct {
 	cfg *QuerySourceFileConfig
 
+	corpus     *filemap.File
 	dataBuffer []byte
 
 	queryInfos []queryInfo
@@ -30,6 +30,7 @@ type QuerySourceFile struct {
 	fingerprintIndex map[uint64][]int
 
 	fingerprintWeights *QueryFingerprintWeights
+	smoothing          WeightSmoothingConfig
 
 	perfStats QuerySourceFileInternalPerfStats
 	mu        sync.RWMutex
@@ -42,9 +43,10 @@ type QuerySourceFileInternalPerfStats struct {
 	UniqueFingerprints int
 }
 
-func NewQuerySourceFile(cfg *QuerySourceFileConfig) (*QuerySourceFile, error) {
+func NewQuerySourceFile(cfg *QuerySourceFileConfig, smoothing WeightSmoothingConfig) (*QuerySourceFile, error) {
 	qsf := &QuerySourceFile{
 		cfg:                cfg,
+		smoothing:          smoothing,
 		fingerprintIndex:   make(map[uint64][]int),
 		queryInfos:         make([]queryInfo, 0, 1000000),
 		fingerprintWeights: NewQueryFingerprintWeights(),
@@ -57,16 +59,12 @@ func (qsf *QuerySourceFile) Init(ctx context.Context) error {
 		startTime := time.Now()
 		logger.Info().Str("file", qsf.cfg.InputFile).Msg("Initializing QuerySourceFile: loading and indexing binary cache...")
 
-		file, err := os.Open(qsf.cfg.InputFile)
+		corpus, err := filemap.Open(qsf.cfg.InputFile)
 		if err != nil {
-			return fmt.Errorf("failed to open binary cache file: %w", err)
-		}
-		defer file.Close()
-
-		qsf.dataBuffer, err = io.ReadAll(file)
-		if err != nil {
-			return fmt.Errorf("failed to read binary cache file into memory: %w", err)
+			return fmt.Errorf("failed to memory-map binary cache file: %w", err)
 		}
+		qsf.corpus = corpus
+		qsf.dataBuffer = corpus.Bytes()
 
 		cursor := 0
 		fingerprintCounts := make(map[uint64]int)
@@ -113,6 +111,9 @@ func (qsf *QuerySourceFile) Init(ctx context.Context) error {
 				&QueryFingerprintData{Hash: hash},
 			)
 		}
+		if adjusted := qsf.fingerprintWeights.Smooth(qsf.smoothing); adjusted > 0 {
+			logger.Info().Int("adjusted", adjusted).Msg("Smoothed fingerprint weights")
+		}
 
 		qsf.perfStats.InitLatency = time.Since(startTime)
 		qsf.perfStats.QueriesLoaded = totalQueries
@@ -130,6 +131,9 @@ func (qsf *QuerySourceFile) Init(ctx context.Context) error {
 }
 
 func (qsf *QuerySourceFile) Destroy() error {
+	if qsf.corpus != nil {
+		return qsf.corpus.Close()
+	}
 	return nil
 }
 
@@ -161,7 +165,8 @@ func (qsf *QuerySourceFile) GetRandomWeightedQuery(ctx context.Context) (*QueryD
 	queryBytes := qsf.dataBuffer[info.offset : info.offset+info.length]
 
 	return &QueryDataSourceResult{
-		Query: string(queryBytes),
+		Query:           string(queryBytes),
+		FingerprintHash: fingerprintHash,
 		// Fingerprint: "",
 	}, nil
 }
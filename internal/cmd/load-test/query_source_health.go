@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"mysql-load-test/internal/metrics"
+)
+
+const (
+	// sourceProbeInterval is how often an unavailable metadata database is
+	// probed for its recovery
+	sourceProbeInterval = 5 * time.Second
+	// maxCachedPicks bounds the weighted picks looking for a cached
+	// fingerprint while degraded
+	maxCachedPicks = 16
+)
+
+// errSourceDegraded fails the picks no cached fingerprint could replace while
+// the metadata database is unavailable
+var errSourceDegraded = errors.New("metadata database unavailable and no cached fingerprint was picked")
+
+// QuerySourceHealth tells whether the metadata database stayed available
+// during the run
+type QuerySourceHealth struct {
+	Degraded      bool      `json:"degraded"`
+	DegradedSince time.Time `json:"degraded_since,omitzero"`
+	Outages       int64     `json:"outages"`
+	// Downtime sums the outages the database recovered from
+	Downtime time.Duration `json:"downtime"`
+	// Substituted counts picks of an uncached fingerprint replaced by a
+	// cached one, Skipped the picks nothing could replace
+	Substituted int64 `json:"substituted"`
+	Skipped     int64 `json:"skipped"`
+}
+
+// sourceHealthReporter is implemented by the data sources depending on a
+// database during the run
+type sourceHealthReporter interface {
+	Health() *QuerySourceHealth
+}
+
+// sourceHealth tracks the outages of the metadata database. While it is
+// down the picks are served from the cached fingerprints, and a probe waits
+// for it to return.
+type sourceHealth struct {
+	degraded    atomic.Bool
+	substituted atomic.Int64
+	skipped     atomic.Int64
+
+	mu            sync.Mutex
+	degradedSince time.Time
+	outages       int64
+	downtime      time.Duration
+}
+
+// fail marks the source degraded after a failed fetch and starts probing
+// with probe until ctx is done. Failures of a canceled ctx are the run
+// ending, not an outage.
+func (h *sourceHealth) fail(ctx context.Context, err error, probe func(context.Context) error) {
+	if ctx.Err() != nil || !h.degraded.CompareAndSwap(false, true) {
+		return
+	}
+	h.mu.Lock()
+	h.degradedSince = time.Now()
+	h.outages++
+	h.mu.Unlock()
+	metrics.QuerySourceDegraded.Set(1)
+	logger.Warn().Err(err).Msg("Metadata database unavailable, serving the cached fingerprints until it recovers")
+	go h.probe(ctx, probe)
+}
+
+func (h *sourceHealth) probe(ctx context.Context, probe func(context.Context) error) {
+	ticker := time.NewTicker(sourceProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, sourceProbeInterval)
+		err := probe(probeCtx)
+		cancel()
+		if err != nil {
+			continue
+		}
+
+		h.mu.Lock()
+		down := time.Since(h.degradedSince)
+		h.downtime += down
+		h.degradedSince = time.Time{}
+		h.degraded.Store(false)
+		h.mu.Unlock()
+		metrics.QuerySourceDegraded.Set(0)
+		logger.Info().Dur("downtime", down).Msg("Metadata database recovered")
+		return
+	}
+}
+
+func (h *sourceHealth) report() *QuerySourceHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return &QuerySourceHealth{
+		Degraded:      h.degraded.Load(),
+		DegradedSince: h.degradedSince,
+		Outages:       h.outages,
+		Downtime:      h.downtime,
+		Substituted:   h.substituted.Load(),
+		Skipped:       h.skipped.Load(),
+	}
+}
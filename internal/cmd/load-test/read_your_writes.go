@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"mysql-load-test/internal/metrics"
+)
+
+// ReadYourWritesConfig runs a scenario next to the replayed queries where a
+// worker inserts a row and immediately reads it back, possibly on another
+// target, measuring how long the write takes to become visible
+type ReadYourWritesConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// ReadDSN is where the rows are read back, e.g. a replica or a proxy
+	// routing reads, the target by default
+	ReadDSN string `mapstructure:"read_dsn" yaml:"read_dsn" validate:"omitempty"`
+	Workers int    `mapstructure:"workers" yaml:"workers" validate:"omitempty,gte=0"`
+	// Interval is the pause between the writes of each worker
+	Interval time.Duration `mapstructure:"interval" yaml:"interval" validate:"omitempty,gte=0"`
+	// Table is created on the target if missing, rows are deleted once read
+	Table string `mapstructure:"table" yaml:"table" validate:"omitempty"`
+	// Timeout is how long a row may stay invisible before it counts as missing
+	Timeout      time.Duration `mapstructure:"timeout" yaml:"timeout" validate:"omitempty,gte=0"`
+	PollInterval time.Duration `mapstructure:"poll_interval" yaml:"poll_interval" validate:"omitempty,gte=0"`
+}
+
+var tableNameRe = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)?$`)
+
+// maxReadYourWritesLags bounds the visibility lags kept for percentiles,
+// the latest ones win
+const maxReadYourWritesLags = 10000
+
+// ReadYourWritesReport sums the outcome of every write since the start.
+// Lags are in microseconds from the write returning to the row being read.
+type ReadYourWritesReport struct {
+	Writes int64 `json:"writes"`
+	// Immediate rows were visible on the first read, Lagged ones only after
+	// retrying, Missing ones not within the timeout
+	Immediate  int64   `json:"immediate"`
+	Lagged     int64   `json:"lagged"`
+	Missing    int64   `json:"missing"`
+	Mismatches int64   `json:"mismatches"`
+	Errors     int64   `json:"errors"`
+	LagP50     float64 `json:"lag_p50_us"`
+	LagP95     float64 `json:"lag_p95_us"`
+	LagP99     float64 `json:"lag_p99_us"`
+	LagMax     float64 `json:"lag_max_us"`
+}
+
+type ReadYourWrites struct {
+	cfg    ReadYourWritesConfig
+	writer *DBConn
+	reader *DBConn
+
+	mu     sync.Mutex
+	report ReadYourWritesReport
+	lags   []float64
+	next   int
+}
+
+func NewReadYourWrites(cfg ReadYourWritesConfig, writer, reader *DBConn) (*ReadYourWrites, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 100 * time.Millisecond
+	}
+	if cfg.Table == "" {
+		cfg.Table = "mlt_read_your_writes"
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = time.Millisecond
+	}
+	if !tableNameRe.MatchString(cfg.Table) {
+		return nil, fmt.Errorf("invalid read your writes table name %q", cfg.Table)
+	}
+	return &ReadYourWrites{cfg: cfg, writer: writer, reader: reader}, nil
+}
+
+// Run creates the table and runs the workers until ctx is done
+func (rw *ReadYourWrites) Run(ctx context.Context) error {
+	_, err := rw.writer.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+rw.cfg.Table+` (
+	Token BIGINT UNSIGNED NOT NULL PRIMARY KEY,
+	Payload CHAR(32) NOT NULL,
+	WrittenAt TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6)
+)`)
+	if err != nil {
+		return fmt.Errorf("error creating read your writes table: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	for range rw.cfg.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw.work(ctx)
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+func (rw *ReadYourWrites) work(ctx context.Context) {
+	ticker := time.NewTicker(rw.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		if err := rw.check(ctx); err != nil && ctx.Err() == nil {
+			rw.record(readYourWritesError, 0)
+			logger.Debug().Err(err).Msg("Read your writes check failed")
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type readYourWritesOutcome string
+
+const (
+	readYourWritesImmediate readYourWritesOutcome = "immediate"
+	readYourWritesLagged    readYourWritesOutcome = "lagged"
+	readYourWritesMissing   readYourWritesOutcome = "missing"
+	readYourWritesMismatch  readYourWritesOutcome = "mismatch"
+	readYourWritesError     readYourWritesOutcome = "error"
+)
+
+// check writes one row, polls for it on the reader and records the outcome
+func (rw *ReadYourWrites) check(ctx context.Context) error {
+	var buf [24]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return err
+	}
+	token := binary.LittleEndian.Uint64(buf[:8])
+	payload := hex.EncodeToString(buf[8:])
+
+	if _, err := rw.writer.ExecContext(ctx, "INSERT INTO "+rw.cfg.Table+" (Token, Payload) VALUES (?, ?)", token, payload); err != nil {
+		return fmt.Errorf("error writing row: %w", err)
+	}
+	written := time.Now()
+	defer rw.writer.ExecContext(context.WithoutCancel(ctx), "DELETE FROM "+rw.cfg.Table+" WHERE Token = ?", token)
+
+	deadline := written.Add(rw.cfg.Timeout)
+	for attempt := 0; ; attempt++ {
+		row, err := rw.reader.QueryRowContext(ctx, "SELECT Payload FROM "+rw.cfg.Table+" WHERE Token = ?", token)
+		if err != nil {
+			return fmt.Errorf("error reading row: %w", err)
+		}
+		var read string
+		err = row.Scan(&read)
+		lag := time.Since(written)
+		switch {
+		case err == nil && read != payload:
+			rw.record(readYourWritesMismatch, lag)
+			return nil
+		case err == nil && attempt == 0:
+			rw.record(readYourWritesImmediate, lag)
+			return nil
+		case err == nil:
+			rw.record(readYourWritesLagged, lag)
+			return nil
+		case !errors.Is(err, sql.ErrNoRows):
+			return fmt.Errorf("error reading row: %w", err)
+		}
+
+		if time.Now().Add(rw.cfg.PollInterval).After(deadline) {
+			rw.record(readYourWritesMissing, 0)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(rw.cfg.PollInterval):
+		}
+	}
+}
+
+func (rw *ReadYourWrites) record(outcome readYourWritesOutcome, lag time.Duration) {
+	metrics.ReadYourWritesOutcomes.WithLabelValues(string(outcome)).Inc()
+
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	switch outcome {
+	case readYourWritesImmediate:
+		rw.report.Immediate++
+	case readYourWritesLagged:
+		rw.report.Lagged++
+	case readYourWritesMissing:
+		rw.report.Missing++
+	case readYourWritesMismatch:
+		rw.report.Mismatches++
+	case readYourWritesError:
+		rw.report.Errors++
+		return
+	}
+	rw.report.Writes++
+	if outcome != readYourWritesImmediate && outcome != readYourWritesLagged {
+		return
+	}
+
+	metrics.ReadYourWritesLag.Observe(lag.Seconds())
+	us := float64(lag.Microseconds())
+	rw.report.LagMax = max(rw.report.LagMax, us)
+	if len(rw.lags) < maxReadYourWritesLags {
+		rw.lags = append(rw.lags, us)
+	} else {
+		rw.lags[rw.next] = us
+		rw.next = (rw.next + 1) % maxReadYourWritesLags
+	}
+}
+
+// Report returns the outcomes so far
+func (rw *ReadYourWrites) Report() *ReadYourWritesReport {
+	rw.mu.Lock()
+	report := rw.report
+	lags := append([]float64(nil), rw.lags...)
+	rw.mu.Unlock()
+
+	if len(lags) > 0 {
+		sort.Float64s(lags)
+		report.LagP50 = lags[len(lags)*50/100]
+		report.LagP95 = lags[len(lags)*95/100]
+		report.LagP99 = lags[len(lags)*99/100]
+	}
+	return &report
+}
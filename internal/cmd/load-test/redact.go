@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	redactedQuery = "[redacted query]"
+	redactedHost  = "[host]"
+	redactedValue = "[redacted]"
+)
+
+var (
+	// errors of the querier quote the whole query, up to the error of the
+	// driver
+	queryErrorRe = regexp.MustCompile(`(?s)error executing query ".*": `)
+	dsnRe        = regexp.MustCompile(`[^\s"'@]+@[a-z]*\([^)]*\)[^\s"']*`)
+	ipRe         = regexp.MustCompile(`\b\d{1,3}(?:\.\d{1,3}){3}(?::\d+)?\b|\[[0-9a-fA-F:]+\](?::\d+)?`)
+	// names with a port, e.g. db-1.internal:3306, other names are only
+	// masked when known since they can't be told apart from table names
+	hostPortRe = regexp.MustCompile(`\b[A-Za-z][A-Za-z0-9-]*(?:\.[A-Za-z0-9-]+)*:\d{2,5}\b`)
+	// quoted literals of warnings and plans are values of the data
+	quotedLiteralRe = regexp.MustCompile(`'[^']*'`)
+)
+
+// redactedSettingSuffixes are the config keys whose values point at hosts
+var redactedSettingSuffixes = []string{"dsn", "addr", "url", "host", "hosts", "headers", "token", "password"}
+
+// Redactor turns reports into artifacts that can be shared outside the
+// team: query texts are dropped while fingerprint hashes stay, hosts,
+// addresses and DSNs are masked
+type Redactor struct {
+	// hosts are the known host names, masked wherever they appear
+	hosts []string
+}
+
+// NewRedactor collects the hosts of the DSNs in settings and the generator
+// host name
+func NewRedactor(settings map[string]any) *Redactor {
+	rd := &Redactor{}
+	rd.collectHosts(settings)
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		rd.hosts = append(rd.hosts, hostname)
+	}
+	// longest first so a host is not masked by a prefix of it
+	sort.Slice(rd.hosts, func(i, j int) bool { return len(rd.hosts[i]) > len(rd.hosts[j]) })
+	return rd
+}
+
+func (rd *Redactor) collectHosts(settings map[string]any) {
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]any:
+			rd.collectHosts(v)
+		case string:
+			if !strings.HasSuffix(key, "dsn") || v == "" {
+				continue
+			}
+			if parsed, err := mysql.ParseDSN(v); err == nil && parsed.Addr != "" {
+				host, _, _ := strings.Cut(parsed.Addr, ":")
+				rd.hosts = append(rd.hosts, parsed.Addr, host)
+			}
+		}
+	}
+}
+
+// Text masks the queries, DSNs and hosts in s
+func (rd *Redactor) Text(s string) string {
+	s = queryErrorRe.ReplaceAllString(s, `error executing query `+redactedQuery+`: `)
+	s = dsnRe.ReplaceAllString(s, redactedValue)
+	for _, host := range rd.hosts {
+		if host != "" {
+			s = strings.ReplaceAll(s, host, redactedHost)
+		}
+	}
+	s = ipRe.ReplaceAllString(s, redactedHost)
+	return hostPortRe.ReplaceAllString(s, redactedHost)
+}
+
+// Report returns a redacted copy of r, r is left untouched
+func (rd *Redactor) Report(r *Report) *Report {
+	redacted := *r
+
+	if r.Metadata != nil {
+		md := *r.Metadata
+		md.Generator.Hostname = redactedHost
+		if md.Corpus.File != "" {
+			md.Corpus.File = filepath.Base(md.Corpus.File)
+		}
+		md.Config = redactConfig(md.Config)
+		redacted.Metadata = &md
+	}
+
+	// the endpoints are numbered over both maps so their names agree
+	var addrs []string
+	for addr := range r.EndpointConnections {
+		addrs = append(addrs, addr)
+	}
+	for addr := range r.HostAggregates {
+		if _, ok := r.EndpointConnections[addr]; !ok {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	if r.EndpointConnections != nil {
+		redacted.EndpointConnections = make(map[string]int, len(r.EndpointConnections))
+	}
+	if r.HostAggregates != nil {
+		redacted.HostAggregates = make(map[string]*ReportAggregateStat, len(r.HostAggregates))
+	}
+	for i, addr := range addrs {
+		name := fmt.Sprintf("endpoint-%d", i+1)
+		if n, ok := r.EndpointConnections[addr]; ok {
+			redacted.EndpointConnections[name] = n
+		}
+		if a, ok := r.HostAggregates[addr]; ok {
+			redacted.HostAggregates[name] = a
+		}
+	}
+
+	if r.ErrorDist != nil {
+		redacted.ErrorDist = make(map[string]int, len(r.ErrorDist))
+		for msg, n := range r.ErrorDist {
+			redacted.ErrorDist[rd.Text(msg)] += n
+		}
+	}
+
+	if r.Annotations != nil {
+		redacted.Annotations = make([]Annotation, len(r.Annotations))
+	}
+	for i, ann := range r.Annotations {
+		ann.Text = rd.Text(ann.Text)
+		if ann.Labels != nil {
+			labels := make(map[string]string, len(ann.Labels))
+			for k, v := range ann.Labels {
+				labels[k] = rd.Text(v)
+			}
+			ann.Labels = labels
+		}
+		redacted.Annotations[i] = ann
+	}
+
+	if r.Warnings != nil {
+		redacted.Warnings = make(map[uint64]*FingerprintWarnings, len(r.Warnings))
+		for hash, fw := range r.Warnings {
+			codes := make(map[uint16]*WarningCodeStat, len(fw.Codes))
+			for code, stat := range fw.Codes {
+				s := *stat
+				s.Message = quotedLiteralRe.ReplaceAllString(rd.Text(s.Message), "'?'")
+				codes[code] = &s
+			}
+			redacted.Warnings[hash] = &FingerprintWarnings{Sampled: fw.Sampled, Codes: codes}
+		}
+	}
+
+	redacted.PlanDiffs = make([]PlanDiff, len(r.PlanDiffs))
+	for i, d := range r.PlanDiffs {
+		d.Query = redactedQuery
+		redacted.PlanDiffs[i] = d
+	}
+	redacted.EstimationErrors = make([]EstimationError, len(r.EstimationErrors))
+	for i, e := range r.EstimationErrors {
+		e.Query = redactedQuery
+		// filter nodes quote the values they compare to
+		e.Node = quotedLiteralRe.ReplaceAllString(e.Node, "'?'")
+		redacted.EstimationErrors[i] = e
+	}
+	return &redacted
+}
+
+// redactConfig returns a copy of the settings with the values pointing at
+// hosts replaced
+func redactConfig(settings map[string]any) map[string]any {
+	if settings == nil {
+		return nil
+	}
+	redacted := make(map[string]any, len(settings))
+	for key, value := range settings {
+		redacted[key] = value
+		if slices.ContainsFunc(redactedSettingSuffixes, func(suffix string) bool { return strings.HasSuffix(key, suffix) }) {
+			if value != nil && value != "" {
+				redacted[key] = redactedValue
+			}
+		} else if m, ok := value.(map[string]any); ok {
+			redacted[key] = redactConfig(m)
+		}
+	}
+	return redacted
+}
+
+// redactingReporter hands the sink a redacted copy of every report
+type redactingReporter struct {
+	Reporter
+	redactor *Redactor
+}
+
+func (r redactingReporter) Report(report *Report) error {
+	return r.Reporter.Report(r.redactor.Report(report))
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
-	"context"
 	"io"
-	"sort"
+	"strconv"
 	"time"
+
+	"mysql-load-test/internal/metrics"
+	"mysql-load-test/pkg/hdrhistogram"
+
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type InternalStats struct {
@@ -16,6 +20,14 @@ type InternalStats struct {
 	CacheNewItems   int64   `json:"cache_new_items"`
 	FetchWeightsLat string  `json:"fetch_weights_lat"`
 
+	// WorkerFairness is Jain's fairness index of the queries executed per
+	// worker in the last window, 1 when evenly spread. StarvedWorkers ran
+	// less than a quarter of the mean, WorkerWaitShare is the share of worker
+	// time spent waiting for the pacer.
+	WorkerFairness  float64 `json:"worker_fairness"`
+	StarvedWorkers  int     `json:"starved_workers"`
+	WorkerWaitShare float64 `json:"worker_wait_share"`
+
 	Lats   []float64 `json:"lats"`
 	LatP50 string    `json:"lat_p50"`
 	LatP95 string    `json:"lat_p95"`
@@ -23,145 +35,680 @@ type InternalStats struct {
 }
 
 type ReportAggregateStat struct {
-	Fastest float64 `json:"fastest"`
-	Slowest float64 `json:"slowest"`
-	Average float64 `json:"average"`
-	QPS     float64 `json:"qps"`
-	LatP50  float64 `json:"query_latency_p50"`
-	LatP95  float64 `json:"query_latency_p95"`
-	LatP99  float64 `json:"query_latency_p99"`
-	NumRes  int64   `json:"num_res"`
+	Time    time.Time `json:"time"`
+	Fastest float64   `json:"fastest"`
+	Slowest float64   `json:"slowest"`
+	Average float64   `json:"average"`
+	QPS     float64   `json:"qps"`
+	LatP50  float64   `json:"query_latency_p50"`
+	LatP95  float64   `json:"query_latency_p95"`
+	LatP99  float64   `json:"query_latency_p99"`
+	NumRes  int64     `json:"num_res"`
+
+	// CorrectedLatP50, P95 and P99 measure from the intended dispatch time
+	// of each arrival, only set with a QPS schedule. The gap to the plain
+	// percentiles is queueing the target caused in the generator.
+	CorrectedLatP50 float64 `json:"corrected_latency_p50,omitempty"`
+	CorrectedLatP95 float64 `json:"corrected_latency_p95,omitempty"`
+	CorrectedLatP99 float64 `json:"corrected_latency_p99,omitempty"`
+
+	// ConnWait, Execute and Drain split the average latency of successful
+	// executions: waiting for a connection, the server answering and reading
+	// the results. ConnWaitMax tells pool exhaustion spikes apart.
+	ConnWait    float64 `json:"conn_wait_avg"`
+	ConnWaitMax float64 `json:"conn_wait_max"`
+	Execute     float64 `json:"execute_avg"`
+	Drain       float64 `json:"drain_avg"`
+
+	// WindowStart and WindowEnd bound the results of the aggregate. Windows
+	// end on wall-clock multiples of the aggregate interval so they line up
+	// with server-side metrics.
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+
+	// Errors counts the failed executions, only set on the statement and
+	// host aggregates
+	Errors int64 `json:"errors,omitempty"`
+
+	// OfferedQPS is the rate arrivals were offered at, spikes included, zero
+	// when unlimited. DispatchQPS is
+	// the rate queries were actually sent at, while QPS counts completions.
+	OfferedQPS        float64 `json:"offered_qps"`
+	DispatchQPS       float64 `json:"dispatch_qps"`
+	WorkerUtilization float64 `json:"worker_utilization"`
+	// GeneratorBound is set when dispatch lags the offered rate while
+	// workers sit idle, meaning the load generator is the bottleneck
+	GeneratorBound bool `json:"generator_bound"`
+	// Maintenance names the maintenance window the aggregate overlaps, the
+	// target is expected to be degraded and checks should skip it
+	Maintenance string `json:"maintenance,omitempty"`
+
+	Generator GeneratorStats `json:"generator"`
 }
 
 type Report struct {
+	// Workload names the workload of the report when the config runs
+	// several
+	Workload      string         `json:"workload,omitempty"`
 	InternalStats *InternalStats `json:"internal_stats"`
 
-	Lats              []float64     `json:"lats"`
 	Total             time.Duration `json:"total"`
 	StartAt           time.Time     `json:"start_at"`
 	NumRes            int64         `json:"num_res"`
 	ActiveConnections int           `json:"active_connections"`
 	AvgTotal          float64       `json:"avg_total"`
+	// lats and correctedLats hold the latencies of the window in
+	// microseconds, runLats those of the whole run
+	lats, correctedLats, runLats *hdrhistogram.Histogram
+	breakdown                    latencyBreakdown
 
 	Aggregates []*ReportAggregateStat `json:"aggregates"`
+	// StatementAggregates holds the latest aggregate of each statement type
+	StatementAggregates map[StatementType]*ReportAggregateStat `json:"statement_aggregates"`
+	statementWindows    map[StatementType]*latencyWindow
+	// HostAggregates holds the latest aggregate of each target endpoint when
+	// connections are balanced
+	HostAggregates map[string]*ReportAggregateStat `json:"host_aggregates,omitempty"`
+	hostWindows    map[string]*latencyWindow
+
+	// SaturatedWindows counts aggregates taken while the generator itself
+	// was saturated
+	SaturatedWindows int64 `json:"saturated_windows"`
+	monitor          *selfMonitor
+
+	Metadata *RunMetadata `json:"metadata,omitempty"`
+
+	// EndpointConnections counts the load connections per resolved target
+	// address when connections are balanced
+	EndpointConnections map[string]int `json:"endpoint_connections,omitempty"`
+	balancer            *EndpointBalancer
+
+	// Warming is set until the warm-up detector reports the buffer pool
+	// warm, results are discarded meanwhile
+	Warming bool `json:"warming"`
+	warmup  *WarmupDetector
+
+	// ReadYourWrites is set when the read your writes scenario runs
+	ReadYourWrites *ReadYourWritesReport `json:"read_your_writes,omitempty"`
+	readYourWrites *ReadYourWrites
+
+	// Consistency holds the GTID positions and table checksums taken before
+	// and after the run
+	Consistency *ConsistencyReport `json:"consistency,omitempty"`
+	consistency *ConsistencyChecker
+
+	// QuerySource tells whether the metadata database stayed available, set
+	// when the data source depends on it during the run
+	QuerySource *QuerySourceHealth `json:"query_source,omitempty"`
+
+	// ConcurrencyAdvice is set when the concurrency advisor is enabled
+	ConcurrencyAdvice *ConcurrencyAdvice `json:"concurrency_advice,omitempty"`
+	advisor           *ConcurrencyAdvisor
+
+	dispatched, prevDispatched int64
+	offered, prevOffered       int64
+	prevWorkers                []WorkerStats
+	busy, prevBusy             time.Duration
+
+	Annotations []Annotation `json:"annotations"`
 
 	w         io.Writer
 	output    string
 	ErrorDist map[string]int `json:"error_dist"`
+	// Truncated counts results cut short by the result limits
+	Truncated int64 `json:"truncated"`
+	// Warnings aggregates SHOW WARNINGS of sampled executions by fingerprint hash
+	Warnings map[uint64]*FingerprintWarnings `json:"warnings"`
+	// PlanDiffs lists fingerprints whose plans differ on the compare database
+	PlanDiffs        []PlanDiff `json:"plan_diffs,omitempty"`
+	PlanDiffsChecked int64      `json:"plan_diffs_checked,omitempty"`
+	// EstimationErrors lists the fingerprints whose row estimates were the
+	// furthest off in sampled EXPLAIN ANALYZE runs
+	EstimationErrors []EstimationError `json:"estimation_errors,omitempty"`
+	ExplainAnalyzed  int64             `json:"explain_analyzed,omitempty"`
+	analyzer         *ExplainAnalyzer
+	// Experiments compares hinted and unhinted executions of each hint
+	// experiment since the start of the run
+	Experiments map[string]*ExperimentReport `json:"experiments,omitempty"`
+	// fingerprints accumulates the executions of each fingerprint since the
+	// start of the measurement, only the results database reads it
+	fingerprints map[uint64]*fingerprintStats
+	// TagStats breaks the fingerprint stats down by the fingerprint tags
+	TagStats map[string]*TagStat `json:"tag_stats,omitempty"`
+	tags     FingerprintTags
+	// Soak is the memory self-check of soak mode
+	Soak *SoakReport `json:"soak,omitempty"`
+	soak *soakMonitor
+	// MaintenanceWindows lists the windows of expected degradation and the
+	// time the target took to recover from each
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty"`
+	// maintenance observes the aggregates, nil for the workloads not
+	// tracking the windows
+	maintenance *Maintenance
+	// ServerStatus holds the latest SHOW GLOBAL STATUS poll
+	ServerStatus map[string]string `json:"server_status,omitempty"`
+	startedAt    time.Time
 
 	results chan *QueryResult
 	done    chan bool
 }
 
-func (r *Report) aggregate() {
-	if len(r.Lats) > 0 {
-		totalTime := time.Since(r.StartAt)
-		sort.Float64s(r.Lats)
-		aggregate := &ReportAggregateStat{
-			QPS:     float64(r.NumRes) / totalTime.Seconds(),
-			Average: r.AvgTotal / float64(len(r.Lats)),
-			NumRes:  r.NumRes,
-			Fastest: r.Lats[0],
-			Slowest: r.Lats[len(r.Lats)-1],
-			LatP50:  r.Lats[len(r.Lats)*50/100],
-			LatP95:  r.Lats[len(r.Lats)*95/100],
-			LatP99:  r.Lats[len(r.Lats)*99/100],
+// latencyBreakdown sums the latency components of the successful results of
+// a window, in microseconds
+type latencyBreakdown struct {
+	connWait, connWaitMax, execute, drain float64
+	n                                     int64
+}
+
+func (b *latencyBreakdown) add(res *QueryResult) {
+	wait := float64(res.Breakdown.Wait.Microseconds())
+	b.connWait += wait
+	b.connWaitMax = max(b.connWaitMax, wait)
+	b.execute += float64(res.Breakdown.Execute.Microseconds())
+	b.drain += float64(res.Breakdown.Drain.Microseconds())
+	b.n++
+	metrics.QueryLatencyBreakdown.WithLabelValues("conn_wait").Observe(res.Breakdown.Wait.Seconds())
+	metrics.QueryLatencyBreakdown.WithLabelValues("execute").Observe(res.Breakdown.Execute.Seconds())
+	metrics.QueryLatencyBreakdown.WithLabelValues("drain").Observe(res.Breakdown.Drain.Seconds())
+}
+
+func (b *latencyBreakdown) setAggregate(a *ReportAggregateStat) {
+	if b.n == 0 {
+		return
+	}
+	n := float64(b.n)
+	a.ConnWait, a.ConnWaitMax = b.connWait/n, b.connWaitMax
+	a.Execute, a.Drain = b.execute/n, b.drain/n
+}
+
+// latencyWindow accumulates the results of one statement type or target host
+// between two aggregations
+type latencyWindow struct {
+	lats     *hdrhistogram.Histogram
+	avgTotal float64
+	numRes   int64
+	errors   int64
+}
+
+func (w *latencyWindow) add(res *QueryResult) {
+	w.numRes++
+	if res.Err != nil {
+		w.errors++
+	} else {
+		dur := res.ExecLatency.Microseconds()
+		w.avgTotal += float64(dur)
+		w.lats.Record(dur)
+	}
+}
+
+func newLatencyWindow() *latencyWindow {
+	return &latencyWindow{lats: newLatencyHistogram()}
+}
+
+// newLatencyHistogram tracks latencies in microseconds, those past
+// maxTrackedLatency are counted as maxTrackedLatency
+func newLatencyHistogram() *hdrhistogram.Histogram {
+	return hdrhistogram.New(maxTrackedLatency.Microseconds())
+}
+
+func (w *latencyWindow) reset() {
+	w.lats.Reset()
+	w.avgTotal = 0
+	w.numRes = 0
+	w.errors = 0
+}
+
+// aggregateWindows closes the statement or host windows, keys without results
+// in the window are dropped
+func aggregateWindows[K comparable](aggregates map[K]*ReportAggregateStat, windows map[K]*latencyWindow, start, end time.Time) {
+	elapsed := end.Sub(start)
+	for key, w := range windows {
+		switch {
+		case w.lats.Count() > 0:
+			aggregates[key] = newAggregate(w.lats, w.avgTotal, w.numRes, elapsed)
+		case w.numRes > 0:
+			// every execution failed, there are no latencies
+			aggregates[key] = &ReportAggregateStat{QPS: float64(w.numRes) / elapsed.Seconds(), NumRes: w.numRes}
+		default:
+			delete(aggregates, key)
+			continue
+		}
+		aggregates[key].Errors = w.errors
+		aggregates[key].setWindow(start, end)
+		w.reset()
+	}
+}
+
+// newAggregate summarizes latencies in microseconds
+func newAggregate(lats *hdrhistogram.Histogram, avgTotal float64, numRes int64, elapsed time.Duration) *ReportAggregateStat {
+	return &ReportAggregateStat{
+		Time:    time.Now(),
+		QPS:     float64(numRes) / elapsed.Seconds(),
+		Average: avgTotal / float64(lats.Count()),
+		NumRes:  numRes,
+		Fastest: float64(lats.Min()),
+		Slowest: float64(lats.Max()),
+		LatP50:  float64(lats.ValueAtQuantile(50)),
+		LatP95:  float64(lats.ValueAtQuantile(95)),
+		LatP99:  float64(lats.ValueAtQuantile(99)),
+	}
+}
+
+// aggregate closes the window at end
+func (r *Report) aggregate(end time.Time) {
+	if r.lats.Count() > 0 {
+		totalTime := end.Sub(r.StartAt)
+		aggregate := newAggregate(r.lats, r.AvgTotal, r.NumRes, totalTime)
+		aggregate.setWindow(r.StartAt, end)
+		r.setDispatch(aggregate, totalTime)
+		aggregate.Generator = r.monitor.sample()
+		if aggregate.Generator.Saturated {
+			r.SaturatedWindows++
+		}
+		if r.correctedLats.Count() > 0 {
+			aggregate.CorrectedLatP50 = float64(r.correctedLats.ValueAtQuantile(50))
+			aggregate.CorrectedLatP95 = float64(r.correctedLats.ValueAtQuantile(95))
+			aggregate.CorrectedLatP99 = float64(r.correctedLats.ValueAtQuantile(99))
+			r.correctedLats.Reset()
 		}
+		r.breakdown.setAggregate(aggregate)
+		r.breakdown = latencyBreakdown{}
 		r.insertAggregate(aggregate)
 
-		r.StartAt = time.Now()
+		for _, e := range r.Experiments {
+			e.aggregate(time.Since(r.startedAt))
+		}
+
+		aggregateWindows(r.StatementAggregates, r.statementWindows, r.StartAt, end)
+		aggregateWindows(r.HostAggregates, r.hostWindows, r.StartAt, end)
+
+		r.StartAt = end
 		r.AvgTotal = 0
-		r.Lats = r.Lats[:0]
+		r.lats.Reset()
 		r.NumRes = 0
 	}
 }
 
-// We report for max 1M results.
-const maxRes = 1000000
+func (a *ReportAggregateStat) setWindow(start, end time.Time) {
+	a.Time, a.WindowStart, a.WindowEnd = end, start, end
+}
+
+// windowEnd returns the last wall-clock multiple of the aggregate interval
+// at or before now
+func windowEnd(now time.Time) time.Time {
+	return now.Truncate(aggregateInterval)
+}
+
+// startMeasurement ends the warm-up, the first window starts now
+func (r *Report) startMeasurement() {
+	r.Warming = false
+	r.StartAt = time.Now()
+	r.startedAt = r.StartAt
+	r.prevDispatched, r.prevBusy, r.prevOffered = r.dispatched, r.busy, r.offered
+}
+
+// Below these fractions of the offered rate and of worker time spent in
+// queries, the generator is considered the bottleneck.
+const generatorBoundDispatchRatio = 0.95
+const generatorBoundUtilization = 0.9
+
+func (r *Report) setDispatch(aggregate *ReportAggregateStat, elapsed time.Duration) {
+	aggregate.OfferedQPS = float64(r.offered-r.prevOffered) / elapsed.Seconds()
+	aggregate.DispatchQPS = float64(r.dispatched-r.prevDispatched) / elapsed.Seconds()
+	if r.ActiveConnections > 0 {
+		aggregate.WorkerUtilization = float64(r.busy-r.prevBusy) / float64(elapsed*time.Duration(r.ActiveConnections))
+	}
+	// workers are held back on purpose while calibrating
+	calibrating := r.ConcurrencyAdvice != nil && r.ConcurrencyAdvice.Calibrating
+	aggregate.GeneratorBound = !calibrating && aggregate.OfferedQPS > 0 &&
+		aggregate.DispatchQPS < aggregate.OfferedQPS*generatorBoundDispatchRatio &&
+		aggregate.WorkerUtilization < generatorBoundUtilization
+	r.prevDispatched, r.prevBusy, r.prevOffered = r.dispatched, r.busy, r.offered
+}
+
+func (r *Report) setWorkerFairness(workers []WorkerStats) {
+	deltas := make([]WorkerStats, len(workers))
+	for i, w := range workers {
+		if i < len(r.prevWorkers) {
+			deltas[i] = w.sub(r.prevWorkers[i])
+		} else {
+			deltas[i] = w
+		}
+	}
+	r.prevWorkers = workers
+	r.InternalStats.WorkerFairness, r.InternalStats.StarvedWorkers, r.InternalStats.WorkerWaitShare = workerFairness(deltas)
+	if r.InternalStats.StarvedWorkers > 0 {
+		logger.Warn().
+			Int("starved_workers", r.InternalStats.StarvedWorkers).
+			Float64("worker_fairness", r.InternalStats.WorkerFairness).
+			Msg("Some workers barely ran queries, a few workers monopolize the pacer or connection pool")
+	}
+}
+
+// Latencies are tracked up to an hour, at 3 significant digits
+const maxTrackedLatency = time.Hour
 const maxAggregatesHistory = 100
+
+// Past these, new error messages are rolled up under otherErrorsKey and new
+// fingerprints under otherFingerprintsHash, so long runs stay memory stable
+const maxErrorKeys = 1000
+const maxFingerprintStats = 100000
+const otherErrorsKey = "other errors"
+const otherFingerprintsHash = 0
 const aggregateInterval = 5 * time.Second
 
 func (r *Report) insertAggregate(aggregate *ReportAggregateStat) {
 	if len(r.Aggregates) >= cap(r.Aggregates) {
 		// shift left by one, remove the first
 		r.Aggregates = append(r.Aggregates[:0], r.Aggregates[1:]...)
-	} else {
-		r.Aggregates = append(r.Aggregates, aggregate)
 	}
+	r.Aggregates = append(r.Aggregates, aggregate)
 }
 
 func newReport(results chan *QueryResult) *Report {
 	return &Report{
 		results:       results,
+		StartAt:       time.Now(),
 		done:          make(chan bool, 1),
 		ErrorDist:     make(map[string]int),
-		Lats:          make([]float64, 0, maxRes),
+		lats:          newLatencyHistogram(),
+		correctedLats: newLatencyHistogram(),
+		runLats:       newLatencyHistogram(),
 		Aggregates:    make([]*ReportAggregateStat, 0, maxAggregatesHistory),
 		InternalStats: &InternalStats{},
+
+		Warnings:            make(map[uint64]*FingerprintWarnings),
+		Experiments:         make(map[string]*ExperimentReport),
+		fingerprints:        make(map[uint64]*fingerprintStats),
+		startedAt:           time.Now(),
+		monitor:             newSelfMonitor(),
+		StatementAggregates: make(map[StatementType]*ReportAggregateStat),
+		statementWindows:    make(map[StatementType]*latencyWindow),
+		HostAggregates:      make(map[string]*ReportAggregateStat),
+		hostWindows:         make(map[string]*latencyWindow),
 	}
 }
 
-func runReporter(r *Report, ctx context.Context, qds QueryDataSource, querier *Querier, metricsServer *MetricsServer) {
+func runReporter(r *Report, qds QueryDataSource, querier *Querier, planDiffer *PlanDiffer, admin *AdminConn, reporters []Reporter) {
+	defer closeReporters(reporters)
+	if r.consistency != nil {
+		defer r.finishConsistency(reporters)
+	}
+	if r.soak != nil {
+		defer r.soak.done()
+	}
 
-	ticker := time.NewTicker(aggregateInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(time.Until(windowEnd(time.Now()).Add(aggregateInterval)))
+	defer timer.Stop()
 
-	for res := range r.results {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			// collect stats from internal components
-
-			qdsPerfStats := qds.PerfStats().(QuerySourceDBInternalPerfStats)
-			querierPerfStats := querier.PerfStats()
-
-			lats := querierPerfStats.GetRandomWeightedQueryLats()
-			var p50, p95, p99 time.Duration
-			if len(lats) > 0 {
-				sort.Slice(lats, func(i, j int) bool { return lats[i] < lats[j] })
-				p50 = lats[len(lats)*50/100]
-				p95 = lats[len(lats)*95/100]
-				p99 = lats[len(lats)*99/100]
-			}
+	// report aggregates the window up to end and hands the report to the
+	// reporters
+	report := func(end time.Time) {
+		// collect stats from internal components
+
+		qdsPerfStats := qds.PerfStats().(QuerySourceDBInternalPerfStats)
+		querierPerfStats := querier.PerfStats()
+
+		lats := querierPerfStats.GetRandomWeightedQueryLatsSnapshot(50, 95, 99)
+		p50, p95, p99 := lats.Percentiles[0], lats.Percentiles[1], lats.Percentiles[2]
 
-			r.InternalStats.QueriesFetched = int64(qdsPerfStats.QueriesFetchTotal)
-			r.InternalStats.CacheHits = int64(qdsPerfStats.CacheStats.HitsTotal)
-			r.InternalStats.CacheMisses = int64(qdsPerfStats.CacheStats.MissesTotal)
-			r.InternalStats.CacheHitRate = float64(qdsPerfStats.CacheStats.HitsTotal) / float64(qdsPerfStats.CacheStats.HitsTotal+qdsPerfStats.CacheStats.MissesTotal) * 100
-			r.InternalStats.CacheEvictions = int64(qdsPerfStats.CacheStats.EvictionsTotal)
-			r.InternalStats.CacheNewItems = int64(qdsPerfStats.CacheStats.NewItemsTotal)
-			r.InternalStats.FetchWeightsLat = qdsPerfStats.FetchWeightsLat.Round(time.Millisecond).String()
-			r.InternalStats.LatP50 = p50.Round(time.Millisecond).String()
-			r.InternalStats.LatP95 = p95.Round(time.Millisecond).String()
-			r.InternalStats.LatP99 = p99.Round(time.Millisecond).String()
-			r.ActiveConnections = config.Concurrency
-
-			r.aggregate()
-
-			// Broadcast the report struct
-			if metricsServer != nil {
-				metricsServer.BroadcastStats(r)
+		r.InternalStats.QueriesFetched = int64(qdsPerfStats.QueriesFetchTotal)
+		r.InternalStats.CacheHits = int64(qdsPerfStats.CacheStats.HitsTotal)
+		r.InternalStats.CacheMisses = int64(qdsPerfStats.CacheStats.MissesTotal)
+		r.InternalStats.CacheHitRate = float64(qdsPerfStats.CacheStats.HitsTotal) / float64(qdsPerfStats.CacheStats.HitsTotal+qdsPerfStats.CacheStats.MissesTotal) * 100
+		r.InternalStats.CacheEvictions = int64(qdsPerfStats.CacheStats.EvictionsTotal)
+		r.InternalStats.CacheNewItems = int64(qdsPerfStats.CacheStats.NewItemsTotal)
+		r.InternalStats.FetchWeightsLat = qdsPerfStats.FetchWeightsLat.Round(time.Millisecond).String()
+		r.InternalStats.LatP50 = p50.Round(time.Millisecond).String()
+		r.InternalStats.LatP95 = p95.Round(time.Millisecond).String()
+		r.InternalStats.LatP99 = p99.Round(time.Millisecond).String()
+		r.Annotations = annotations.List()
+		r.dispatched, r.busy = querier.DispatchStats()
+		r.offered = querier.OfferedStats()
+		r.setWorkerFairness(querier.WorkerStats())
+		if r.advisor != nil {
+			r.ConcurrencyAdvice = r.advisor.Advice()
+		}
+		if r.balancer != nil {
+			r.EndpointConnections = r.balancer.Connections()
+		}
+		if h, ok := qds.(sourceHealthReporter); ok {
+			r.QuerySource = h.Health()
+		}
+		if r.analyzer != nil {
+			r.EstimationErrors = r.analyzer.List()
+			r.ExplainAnalyzed = r.analyzer.Analyzed()
+		}
+		if r.readYourWrites != nil {
+			r.ReadYourWrites = r.readYourWrites.Report()
+		}
+		if r.consistency != nil {
+			r.Consistency = r.consistency.Report()
+		}
+		r.ServerStatus = admin.Status()
+		if len(r.tags) > 0 {
+			r.TagStats = tagStats(r.fingerprints, r.tags)
+		}
+		if planDiffer != nil {
+			r.PlanDiffs = planDiffer.List()
+			r.PlanDiffsChecked = planDiffer.Checked()
+		}
+
+		r.aggregate(end)
+		if n := len(r.Aggregates); n > 0 && r.maintenance != nil {
+			r.maintenance.observe(r.Aggregates[n-1])
+			r.MaintenanceWindows = r.maintenance.List()
+		}
+		if r.soak != nil {
+			r.Soak = r.soak.check(processRSS())
+		}
+		if n := len(r.Aggregates); n > 0 && r.Aggregates[n-1].Generator.Saturated {
+			g := r.Aggregates[n-1].Generator
+			logger.Warn().
+				Float64("cpu_percent", g.CPUPercent).
+				Float64("gc_pause_total_us", g.GCPauseTotal).
+				Int("goroutines", g.Goroutines).
+				Msg("Load generator is saturated, latencies of this window are unreliable")
+		}
+		if n := len(r.Aggregates); n > 0 && r.Aggregates[n-1].GeneratorBound {
+			a := r.Aggregates[n-1]
+			logger.Warn().
+				Float64("offered_qps", a.OfferedQPS).
+				Float64("dispatch_qps", a.DispatchQPS).
+				Float64("worker_utilization", a.WorkerUtilization).
+				Msg("Load generator cannot keep up with the offered QPS")
+		}
+
+		for _, reporter := range reporters {
+			if err := reporter.Report(r); err != nil {
+				logger.Warn().Err(err).Msg("Error reporting")
 			}
+		}
+		if r.soak != nil {
+			r.soak.flush(r)
+		}
+	}
 
-			goto collect
+	// the workers stop when the run ends, the results they sent are drained
+	// before the final report
+	for res := range r.results {
+		select {
+		case <-timer.C:
+			end := windowEnd(time.Now())
+			timer.Reset(time.Until(end.Add(aggregateInterval)))
+			report(end)
 		default:
 		}
 
-	collect:
+		if r.Warming {
+			if !r.warmup.Warm() {
+				continue
+			}
+			r.startMeasurement()
+		}
 
 		r.NumRes++
+		r.recordStatement(res)
+		r.recordHost(res)
+		r.recordWarnings(res)
+		r.recordExperiment(res)
+		r.recordFingerprint(res)
+		if res.Truncated {
+			r.Truncated++
+			metrics.QueryResultsTruncated.Inc()
+		}
 		if res.Err != nil {
-			r.ErrorDist[res.Err.Error()]++
+			r.recordError(res.Err)
 		} else {
-			dur := float64(res.ExecLatency.Microseconds())
-			r.AvgTotal += dur
-			if len(r.Lats) < maxRes {
-				r.Lats = append(r.Lats, dur)
+			dur := res.ExecLatency.Microseconds()
+			r.AvgTotal += float64(dur)
+			r.breakdown.add(res)
+			r.lats.Record(dur)
+			r.runLats.Record(dur)
+			if res.CorrectedLatency > 0 {
+				r.correctedLats.Record(res.CorrectedLatency.Microseconds())
 			}
 		}
 	}
 
+	// the partial window up to the stop
+	report(time.Now())
 	r.done <- true
+}
+
+type fingerprintStats struct {
+	executions, errors int64
+	total, max         time.Duration
+}
+
+// recordError counts the error by message. Soak runs drop the query text
+// of the message, which would make nearly every error unique.
+func (r *Report) recordError(err error) {
+	key := err.Error()
+	if r.soak != nil {
+		key = queryErrorRe.ReplaceAllString(key, "error executing query: ")
+	}
+	if _, ok := r.ErrorDist[key]; !ok && len(r.ErrorDist) >= maxErrorKeys {
+		key = otherErrorsKey
+	}
+	r.ErrorDist[key]++
+}
+
+func (r *Report) recordFingerprint(res *QueryResult) {
+	hash := res.FingerprintHash
+	if _, ok := r.fingerprints[hash]; !ok && len(r.fingerprints) >= maxFingerprintStats {
+		hash = otherFingerprintsHash
+	}
+	st, ok := r.fingerprints[hash]
+	if !ok {
+		st = &fingerprintStats{}
+		r.fingerprints[hash] = st
+	}
+	st.executions++
+	if res.Err != nil {
+		st.errors++
+		return
+	}
+	st.total += res.ExecLatency
+	st.max = max(st.max, res.ExecLatency)
+}
 
+func (r *Report) recordStatement(res *QueryResult) {
+	stmt := res.StatementType
+	if stmt == "" {
+		stmt = StatementOther
+	}
+	w, ok := r.statementWindows[stmt]
+	if !ok {
+		w = newLatencyWindow()
+		r.statementWindows[stmt] = w
+	}
+	w.add(res)
+
+	if res.Err != nil {
+		metrics.StatementExecutionErrors.WithLabelValues(string(stmt)).Inc()
+	} else {
+		observeWithExemplar(metrics.StatementExecutionLatency.WithLabelValues(string(stmt)), res.ExecLatency.Seconds(), res.Exemplar)
+	}
+}
+
+func (r *Report) recordHost(res *QueryResult) {
+	if res.Host == "" {
+		return
+	}
+	w, ok := r.hostWindows[res.Host]
+	if !ok {
+		w = newLatencyWindow()
+		r.hostWindows[res.Host] = w
+	}
+	w.add(res)
+
+	if res.Err != nil {
+		metrics.EndpointExecutionErrors.WithLabelValues(res.Host).Inc()
+	} else {
+		observeWithExemplar(metrics.EndpointExecutionLatency.WithLabelValues(res.Host), res.ExecLatency.Seconds(), res.Exemplar)
+	}
+}
+
+// observeWithExemplar attaches the exemplar when there is one, exemplars are
+// only exposed to scrapers negotiating OpenMetrics
+func observeWithExemplar(o prometheus.Observer, v float64, exemplar prometheus.Labels) {
+	if eo, ok := o.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		eo.ObserveWithExemplar(v, exemplar)
+		return
+	}
+	o.Observe(v)
+}
+
+func (r *Report) recordWarnings(res *QueryResult) {
+	if res.Warnings == nil {
+		return
+	}
+	fw, ok := r.Warnings[res.FingerprintHash]
+	if !ok {
+		if len(r.Warnings) >= maxFingerprintStats {
+			return
+		}
+		fw = &FingerprintWarnings{Codes: make(map[uint16]*WarningCodeStat)}
+		r.Warnings[res.FingerprintHash] = fw
+	}
+	fw.add(res.Warnings)
+
+	for _, w := range res.Warnings {
+		metrics.QueryWarnings.WithLabelValues(w.Level, strconv.Itoa(int(w.Code))).Inc()
+	}
+}
+
+// ExperimentReport holds both arms of a hint experiment
+type ExperimentReport struct {
+	Hinted   *ReportAggregateStat `json:"hinted"`
+	Unhinted *ReportAggregateStat `json:"unhinted"`
+
+	hinted, unhinted *latencyWindow
+}
+
+func (e *ExperimentReport) aggregate(elapsed time.Duration) {
+	// Windows are never reset, the aggregates cover the whole run
+	if e.hinted.lats.Count() > 0 {
+		e.Hinted = newAggregate(e.hinted.lats, e.hinted.avgTotal, e.hinted.numRes, elapsed)
+	}
+	if e.unhinted.lats.Count() > 0 {
+		e.Unhinted = newAggregate(e.unhinted.lats, e.unhinted.avgTotal, e.unhinted.numRes, elapsed)
+	}
+}
+
+func (r *Report) recordExperiment(res *QueryResult) {
+	if res.Experiment == "" {
+		return
+	}
+	e, ok := r.Experiments[res.Experiment]
+	if !ok {
+		e = &ExperimentReport{hinted: newLatencyWindow(), unhinted: newLatencyWindow()}
+		r.Experiments[res.Experiment] = e
+	}
+	if res.Hinted {
+		e.hinted.add(res)
+	} else {
+		e.unhinted.add(res)
+	}
 }
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"mysql-load-test/internal/metrics"
+
+	"github.com/spf13/viper"
+)
+
+type ReportersConfig struct {
+	// Console logs a line per aggregate
+	Console bool `mapstructure:"console" yaml:"console"`
+	// JSONFile is rewritten with the full report after every aggregate
+	JSONFile string `mapstructure:"json_file" yaml:"json_file" validate:"omitempty"`
+	// AggregatesCSV gets a row per aggregate
+	AggregatesCSV string       `mapstructure:"aggregates_csv" yaml:"aggregates_csv" validate:"omitempty"`
+	StatsD        StatsDConfig `mapstructure:"statsd" yaml:"statsd"`
+	// ResultsDB is a SQLite database the run is written to, replaced if it
+	// exists. Read it back with mlt report query.
+	ResultsDB string `mapstructure:"results_db" yaml:"results_db" validate:"omitempty"`
+	// TrendsDB accumulates a summary row of every run, a SQLite file or a
+	// mysql:// DSN. Read it back with mlt trends.
+	TrendsDB string `mapstructure:"trends_db" yaml:"trends_db" validate:"omitempty"`
+	// TrendsTarget names the target in the trends database, the address and
+	// database of db_dsn by default
+	TrendsTarget string `mapstructure:"trends_target" yaml:"trends_target" validate:"omitempty"`
+	// Pushgateway and RemoteWrite get the results of runs that end before
+	// Prometheus scrapes them into the monitoring stack
+	Pushgateway PushgatewayConfig `mapstructure:"pushgateway" yaml:"pushgateway"`
+	RemoteWrite RemoteWriteConfig `mapstructure:"remote_write" yaml:"remote_write"`
+	// Redact strips query texts and masks hosts and DSNs in the JSON file
+	// and the results database, so they can be shared outside the team
+	Redact bool `mapstructure:"redact" yaml:"redact"`
+}
+
+type StatsDConfig struct {
+	Addr   string `mapstructure:"addr" yaml:"addr" validate:"omitempty,hostname_port"`
+	Prefix string `mapstructure:"prefix" yaml:"prefix" validate:"omitempty"`
+}
+
+// Reporter is a sink for the report. Report is called after every
+// aggregation with the same report for every sink, Close once the run ends.
+type Reporter interface {
+	Report(r *Report) error
+	Close() error
+}
+
+// newReporters creates the sinks enabled in cfg and the summary of
+// reporting, metricsServer is added when set
+func newReporters(cfg ReportersConfig, reporting ReportingConfig, metricsServer *MetricsServer) ([]Reporter, error) {
+	var redactor *Redactor
+	if cfg.Redact {
+		redactor = NewRedactor(viper.AllSettings())
+	}
+	// artifact sinks get redacted reports
+	artifact := func(reporter Reporter) Reporter {
+		if redactor == nil {
+			return reporter
+		}
+		return redactingReporter{Reporter: reporter, redactor: redactor}
+	}
+
+	var reporters []Reporter
+	if cfg.Console {
+		reporters = append(reporters, consoleReporter{})
+	}
+	if cfg.JSONFile != "" {
+		reporters = append(reporters, artifact(&jsonFileReporter{path: cfg.JSONFile}))
+	}
+	if cfg.AggregatesCSV != "" {
+		aggregates, err := newAggregatesCSVReporter(cfg.AggregatesCSV)
+		if err != nil {
+			closeReporters(reporters)
+			return nil, err
+		}
+		reporters = append(reporters, aggregates)
+	}
+	if cfg.StatsD.Addr != "" {
+		statsd, err := newStatsDReporter(cfg.StatsD)
+		if err != nil {
+			closeReporters(reporters)
+			return nil, err
+		}
+		reporters = append(reporters, statsd)
+	}
+	if cfg.ResultsDB != "" {
+		resultsDB, err := newResultsDBReporter(cfg.ResultsDB)
+		if err != nil {
+			closeReporters(reporters)
+			return nil, err
+		}
+		reporters = append(reporters, artifact(resultsDB))
+	}
+	if cfg.TrendsDB != "" {
+		trendsDB, err := newTrendsDBReporter(cfg.TrendsDB, cfg.TrendsTarget)
+		if err != nil {
+			closeReporters(reporters)
+			return nil, err
+		}
+		reporters = append(reporters, trendsDB)
+	}
+	if reporting.OutFile != "" {
+		reporters = append(reporters, artifact(newSummaryReporter(reporting)))
+	}
+	if cfg.Pushgateway.URL != "" {
+		reporters = append(reporters, newPushgatewayReporter(cfg.Pushgateway))
+	}
+	if cfg.RemoteWrite.URL != "" {
+		reporters = append(reporters, newRemoteWriteReporter(cfg.RemoteWrite))
+	}
+	if metricsServer != nil {
+		reporters = append(reporters, prometheusReporter{}, metricsServer)
+	}
+	return reporters, nil
+}
+
+func closeReporters(reporters []Reporter) {
+	for _, reporter := range reporters {
+		if err := reporter.Close(); err != nil {
+			logger.Warn().Err(err).Msg("Error closing reporter")
+		}
+	}
+}
+
+func latestAggregate(r *Report) *ReportAggregateStat {
+	if n := len(r.Aggregates); n > 0 {
+		return r.Aggregates[n-1]
+	}
+	return nil
+}
+
+// consoleReporter logs the latest aggregate
+type consoleReporter struct{}
+
+func (consoleReporter) Report(r *Report) error {
+	a := latestAggregate(r)
+	if a == nil || r.Warming {
+		return nil
+	}
+	e := logger.Info()
+	if r.Workload != "" {
+		e = e.Str("workload", r.Workload)
+	}
+	e.Float64("qps", a.QPS).
+		Float64("dispatch_qps", a.DispatchQPS).
+		Float64("avg_us", a.Average).
+		Float64("p50_us", a.LatP50).
+		Float64("p95_us", a.LatP95).
+		Float64("p99_us", a.LatP99).
+		Float64("corrected_p99_us", a.CorrectedLatP99).
+		Float64("conn_wait_us", a.ConnWait).
+		Float64("execute_us", a.Execute).
+		Float64("drain_us", a.Drain).
+		Int64("num_res", a.NumRes).
+		Int("errors", len(r.ErrorDist)).
+		Msg("Report")
+	return nil
+}
+
+func (consoleReporter) Close() error {
+	return nil
+}
+
+// jsonFileReporter keeps a file holding the latest full report. The file is
+// replaced atomically so readers never see a partial report.
+type jsonFileReporter struct {
+	path string
+}
+
+func (j *jsonFileReporter) Report(r *Report) error {
+	sanitized, _ := sanitizeReport(r)
+	data, err := json.Marshal(sanitized)
+	if err != nil {
+		return fmt.Errorf("error marshaling report: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(j.path), filepath.Base(j.path)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating report file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing report file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing report file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), j.path); err != nil {
+		return fmt.Errorf("error replacing report file: %w", err)
+	}
+	return nil
+}
+
+func (j *jsonFileReporter) Close() error {
+	return nil
+}
+
+// aggregatesCSVReporter appends the aggregates not written yet as CSV rows
+type aggregatesCSVReporter struct {
+	f    *os.File
+	w    *csv.Writer
+	last time.Time
+}
+
+var aggregatesCSVHeader = []string{
+	"time", "window_start", "window_end", "qps", "dispatch_qps", "offered_qps", "num_res",
+	"average", "fastest", "slowest", "query_latency_p50", "query_latency_p95", "query_latency_p99",
+	"corrected_latency_p99", "worker_utilization", "generator_bound", "maintenance",
+}
+
+func newAggregatesCSVReporter(path string) (*aggregatesCSVReporter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("error creating aggregates CSV file: %w", err)
+	}
+	w := csv.NewWriter(f)
+	w.Write(aggregatesCSVHeader)
+	w.Flush()
+	if err := w.Error(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error writing aggregates CSV file: %w", err)
+	}
+	return &aggregatesCSVReporter{f: f, w: w}, nil
+}
+
+func (a *aggregatesCSVReporter) Report(r *Report) error {
+	float := func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) }
+	for _, agg := range r.Aggregates {
+		if !agg.Time.After(a.last) {
+			continue
+		}
+		a.last = agg.Time
+		a.w.Write([]string{
+			agg.Time.Format(time.RFC3339Nano), agg.WindowStart.Format(time.RFC3339Nano), agg.WindowEnd.Format(time.RFC3339Nano),
+			float(agg.QPS), float(agg.DispatchQPS), float(agg.OfferedQPS), strconv.FormatInt(agg.NumRes, 10),
+			float(agg.Average), float(agg.Fastest), float(agg.Slowest), float(agg.LatP50), float(agg.LatP95), float(agg.LatP99),
+			float(agg.CorrectedLatP99), float(agg.WorkerUtilization), strconv.FormatBool(agg.GeneratorBound), agg.Maintenance,
+		})
+	}
+	a.w.Flush()
+	if err := a.w.Error(); err != nil {
+		return fmt.Errorf("error writing aggregates CSV file: %w", err)
+	}
+	return nil
+}
+
+func (a *aggregatesCSVReporter) Close() error {
+	return a.f.Close()
+}
+
+// prometheusReporter exposes the latest aggregate as gauges on /metrics
+type prometheusReporter struct{}
+
+func (prometheusReporter) Report(r *Report) error {
+	a := latestAggregate(r)
+	if a == nil {
+		return nil
+	}
+	metrics.AggregateQPS.Set(a.QPS)
+	metrics.AggregateLatency.WithLabelValues("0.5").Set(a.LatP50 / 1e6)
+	metrics.AggregateLatency.WithLabelValues("0.95").Set(a.LatP95 / 1e6)
+	metrics.AggregateLatency.WithLabelValues("0.99").Set(a.LatP99 / 1e6)
+	return nil
+}
+
+func (prometheusReporter) Close() error {
+	return nil
+}
+
+// statsDReporter sends the latest aggregate as StatsD gauges over UDP, one
+// datagram per aggregate
+type statsDReporter struct {
+	conn   net.Conn
+	prefix string
+}
+
+func newStatsDReporter(cfg StatsDConfig) (*statsDReporter, error) {
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to statsd: %w", err)
+	}
+	prefix := cfg.Prefix
+	if prefix == "" {
+		prefix = "mysql_load_test"
+	}
+	return &statsDReporter{conn: conn, prefix: strings.TrimSuffix(prefix, ".")}, nil
+}
+
+func (s *statsDReporter) Report(r *Report) error {
+	a := latestAggregate(r)
+	if a == nil || r.Warming {
+		return nil
+	}
+	var buf bytes.Buffer
+	gauge := func(name string, value float64) {
+		fmt.Fprintf(&buf, "%s.%s:%g|g\n", s.prefix, name, value)
+	}
+	gauge("qps", a.QPS)
+	gauge("dispatch_qps", a.DispatchQPS)
+	gauge("latency_avg_us", a.Average)
+	gauge("latency_p50_us", a.LatP50)
+	gauge("latency_p95_us", a.LatP95)
+	gauge("latency_p99_us", a.LatP99)
+	gauge("worker_utilization", a.WorkerUtilization)
+	if _, err := s.conn.Write(bytes.TrimSuffix(buf.Bytes(), []byte("\n"))); err != nil {
+		return fmt.Errorf("error sending statsd metrics: %w", err)
+	}
+	return nil
+}
+
+func (s *statsDReporter) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"time"
+
+	"mysql-load-test/internal/resultsdb"
+)
+
+// resultsDBReporter writes the run into a SQLite database. Aggregates are
+// appended as they close, the cumulative tables are rewritten on every
+// report so the database is complete even if the run is killed.
+type resultsDBReporter struct {
+	db         *sql.DB
+	started    bool
+	lastWindow time.Time
+}
+
+func newResultsDBReporter(path string) (*resultsDBReporter, error) {
+	// one database per run
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("error removing previous results database: %w", err)
+	}
+	db, err := resultsdb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &resultsDBReporter{db: db}, nil
+}
+
+func (d *resultsDBReporter) Report(r *Report) error {
+	tx, err := d.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return fmt.Errorf("error starting results database transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if !d.started {
+		if err := insertRun(tx, r); err != nil {
+			return err
+		}
+		if err := insertFingerprintTags(tx, r); err != nil {
+			return err
+		}
+	}
+	if err := d.insertAggregates(tx, r); err != nil {
+		return err
+	}
+	if err := replaceFingerprintStats(tx, r); err != nil {
+		return err
+	}
+	if err := replaceErrors(tx, r); err != nil {
+		return err
+	}
+	if err := replaceAnnotations(tx, r); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing results database transaction: %w", err)
+	}
+	d.started = true
+	if a := latestAggregate(r); a != nil {
+		d.lastWindow = a.WindowEnd
+	}
+	return nil
+}
+
+func (d *resultsDBReporter) Close() error {
+	return d.db.Close()
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(resultsdb.TimeFormat)
+}
+
+func insertRun(tx *sql.Tx, r *Report) error {
+	startedAt := r.startedAt
+	var metadata []byte
+	if r.Metadata != nil {
+		startedAt = r.Metadata.StartedAt
+		var err error
+		if metadata, err = json.Marshal(r.Metadata); err != nil {
+			return fmt.Errorf("error marshaling run metadata: %w", err)
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO run (started_at, metadata) VALUES (?, ?)", formatTime(startedAt), string(metadata)); err != nil {
+		return fmt.Errorf("error inserting run: %w", err)
+	}
+	return nil
+}
+
+const insertAggregateQuery = `INSERT OR REPLACE INTO aggregates (window_start, window_end, statement_type,
+	qps, offered_qps, dispatch_qps, worker_utilization, generator_bound,
+	avg_us, fastest_us, slowest_us, p50_us, p95_us, p99_us,
+	corrected_p50_us, corrected_p95_us, corrected_p99_us,
+	conn_wait_avg_us, conn_wait_max_us, execute_avg_us, drain_avg_us, num_res)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+const insertHostAggregateQuery = `INSERT OR REPLACE INTO host_aggregates (window_start, window_end, host,
+	qps, avg_us, p50_us, p95_us, p99_us, errors, num_res)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// insertAggregates appends the aggregates closed since the last report, the
+// statement aggregates are only kept for the latest window
+func (d *resultsDBReporter) insertAggregates(tx *sql.Tx, r *Report) error {
+	stmt, err := tx.Prepare(insertAggregateQuery)
+	if err != nil {
+		return fmt.Errorf("error preparing aggregate insert: %w", err)
+	}
+	defer stmt.Close()
+
+	insert := func(statementType string, a *ReportAggregateStat) error {
+		_, err := stmt.Exec(formatTime(a.WindowStart), formatTime(a.WindowEnd), statementType,
+			a.QPS, a.OfferedQPS, a.DispatchQPS, a.WorkerUtilization, a.GeneratorBound,
+			a.Average, a.Fastest, a.Slowest, a.LatP50, a.LatP95, a.LatP99,
+			a.CorrectedLatP50, a.CorrectedLatP95, a.CorrectedLatP99,
+			a.ConnWait, a.ConnWaitMax, a.Execute, a.Drain, a.NumRes)
+		if err != nil {
+			return fmt.Errorf("error inserting aggregate: %w", err)
+		}
+		return nil
+	}
+
+	latest := latestAggregate(r)
+	if latest == nil || !latest.WindowEnd.After(d.lastWindow) {
+		return nil
+	}
+	for _, a := range r.Aggregates {
+		if !a.WindowEnd.After(d.lastWindow) {
+			continue
+		}
+		if err := insert("", a); err != nil {
+			return err
+		}
+	}
+	for stmtType, a := range r.StatementAggregates {
+		if err := insert(string(stmtType), a); err != nil {
+			return err
+		}
+	}
+	return insertHostAggregates(tx, r)
+}
+
+// insertHostAggregates stores the latest aggregate of each target endpoint
+func insertHostAggregates(tx *sql.Tx, r *Report) error {
+	if len(r.HostAggregates) == 0 {
+		return nil
+	}
+	stmt, err := tx.Prepare(insertHostAggregateQuery)
+	if err != nil {
+		return fmt.Errorf("error preparing host aggregate insert: %w", err)
+	}
+	defer stmt.Close()
+	for host, a := range r.HostAggregates {
+		if _, err := stmt.Exec(formatTime(a.WindowStart), formatTime(a.WindowEnd), host,
+			a.QPS, a.Average, a.LatP50, a.LatP95, a.LatP99, a.Errors, a.NumRes); err != nil {
+			return fmt.Errorf("error inserting host aggregate: %w", err)
+		}
+	}
+	return nil
+}
+
+func replaceFingerprintStats(tx *sql.Tx, r *Report) error {
+	if _, err := tx.Exec("DELETE FROM fingerprint_stats"); err != nil {
+		return fmt.Errorf("error clearing fingerprint stats: %w", err)
+	}
+	stmt, err := tx.Prepare("INSERT INTO fingerprint_stats (fingerprint_hash, executions, errors, total_us, max_us) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return fmt.Errorf("error preparing fingerprint stats insert: %w", err)
+	}
+	defer stmt.Close()
+	for hash, st := range r.fingerprints {
+		// sqlite integers are signed, the hash is stored as decimal text
+		if _, err := stmt.Exec(strconv.FormatUint(hash, 10), st.executions, st.errors, st.total.Microseconds(), st.max.Microseconds()); err != nil {
+			return fmt.Errorf("error inserting fingerprint stats: %w", err)
+		}
+	}
+	return nil
+}
+
+// insertFingerprintTags stores the tags once, they don't change during a run
+func insertFingerprintTags(tx *sql.Tx, r *Report) error {
+	stmt, err := tx.Prepare("INSERT OR IGNORE INTO fingerprint_tags (fingerprint_hash, tag) VALUES (?, ?)")
+	if err != nil {
+		return fmt.Errorf("error preparing fingerprint tags insert: %w", err)
+	}
+	defer stmt.Close()
+	for hash, tags := range r.tags {
+		for _, tag := range tags {
+			if _, err := stmt.Exec(strconv.FormatUint(hash, 10), tag); err != nil {
+				return fmt.Errorf("error inserting fingerprint tag: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
+func replaceErrors(tx *sql.Tx, r *Report) error {
+	if _, err := tx.Exec("DELETE FROM errors"); err != nil {
+		return fmt.Errorf("error clearing errors: %w", err)
+	}
+	for msg, count := range r.ErrorDist {
+		if _, err := tx.Exec("INSERT INTO errors (error, count) VALUES (?, ?)", msg, count); err != nil {
+			return fmt.Errorf("error inserting error: %w", err)
+		}
+	}
+	return nil
+}
+
+func replaceAnnotations(tx *sql.Tx, r *Report) error {
+	if _, err := tx.Exec("DELETE FROM annotations"); err != nil {
+		return fmt.Errorf("error clearing annotations: %w", err)
+	}
+	for _, a := range r.Annotations {
+		var labels []byte
+		if len(a.Labels) > 0 {
+			var err error
+			if labels, err = json.Marshal(a.Labels); err != nil {
+				return fmt.Errorf("error marshaling annotation labels: %w", err)
+			}
+		}
+		if _, err := tx.Exec("INSERT INTO annotations (time, source, text, labels) VALUES (?, ?, ?, ?)", formatTime(a.Time), a.Source, a.Text, string(labels)); err != nil {
+			return fmt.Errorf("error inserting annotation: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// runDirTimeLayout names the directory of a run, e.g. run-20240601-1000
+const runDirTimeLayout = "20060102-1504"
+
+// RunArtifact is a file written by the run. Path is relative to the run
+// directory when the file is inside it.
+type RunArtifact struct {
+	Kind  string `json:"kind"`
+	Path  string `json:"path"`
+	Bytes int64  `json:"bytes"`
+}
+
+// RunManifest ties the artifacts of a run together, it is written to
+// manifest.json at the start and completed at the end of the run
+type RunManifest struct {
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt *time.Time    `json:"finished_at,omitempty"`
+	Error      string        `json:"error,omitempty"`
+	Artifacts  []RunArtifact `json:"artifacts"`
+}
+
+// RunDir collects the artifacts of a run in a directory of Config.OutputDir.
+// Every artifact not configured otherwise is written there.
+type RunDir struct {
+	path     string
+	logFile  *os.File
+	manifest RunManifest
+}
+
+// prepareRunDir creates the directory of the run in cfg.OutputDir and points
+// the artifacts of cfg without a path of their own at it
+func prepareRunDir(cfg *Config) (*RunDir, error) {
+	started := time.Now()
+	if err := os.MkdirAll(cfg.OutputDir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating output directory: %w", err)
+	}
+	name := "run-" + started.Format(runDirTimeLayout)
+	path := filepath.Join(cfg.OutputDir, name)
+	// runs started in the same minute get a suffix
+	for n := 2; ; n++ {
+		err := os.Mkdir(path, 0o755)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("error creating run directory: %w", err)
+		}
+		path = filepath.Join(cfg.OutputDir, fmt.Sprintf("%s-%d", name, n))
+	}
+
+	d := &RunDir{path: path, manifest: RunManifest{StartedAt: started}}
+	setDefault := func(p *string, file string) {
+		if *p == "" {
+			*p = filepath.Join(path, file)
+		}
+	}
+	setDefault(&cfg.Reporters.JSONFile, "report.json")
+	setDefault(&cfg.Reporters.AggregatesCSV, "aggregates.csv")
+	setDefault(&cfg.SlowLog.File, "slow.ndjson")
+	setDefault(&cfg.ExecutionLog.File, "executions.ndjson")
+	if cfg.Reporting.Format == "json" {
+		setDefault(&cfg.Reporting.OutFile, "summary.json")
+	} else {
+		setDefault(&cfg.Reporting.OutFile, "summary.txt")
+	}
+
+	configPath := filepath.Join(path, "config.json")
+	data, err := json.MarshalIndent(redactSettings(viper.AllSettings()), "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling config snapshot: %w", err)
+	}
+	if err := os.WriteFile(configPath, data, 0o644); err != nil {
+		return nil, fmt.Errorf("error writing config snapshot: %w", err)
+	}
+
+	logPath := filepath.Join(path, "load-test.log")
+	if d.logFile, err = os.Create(logPath); err != nil {
+		return nil, fmt.Errorf("error creating log file: %w", err)
+	}
+	setupLogger(d.logFile)
+
+	// every workload writes files of its own
+	runs := []Config{*cfg}
+	if len(cfg.Workloads) > 0 {
+		if runs, err = cfg.workloadConfigs(); err != nil {
+			return nil, err
+		}
+	}
+	var artifacts []RunArtifact
+	for _, run := range runs {
+		artifacts = append(artifacts,
+			RunArtifact{Kind: "report", Path: run.Reporters.JSONFile},
+			RunArtifact{Kind: "aggregates", Path: run.Reporters.AggregatesCSV},
+			RunArtifact{Kind: "slow_log", Path: run.SlowLog.File},
+			RunArtifact{Kind: "execution_log", Path: run.ExecutionLog.File},
+			RunArtifact{Kind: "results_db", Path: run.Reporters.ResultsDB},
+			RunArtifact{Kind: "summary", Path: run.Reporting.OutFile},
+		)
+	}
+	artifacts = append(artifacts,
+		RunArtifact{Kind: "config", Path: configPath},
+		RunArtifact{Kind: "log", Path: logPath},
+	)
+	for _, artifact := range artifacts {
+		if artifact.Path != "" {
+			d.manifest.Artifacts = append(d.manifest.Artifacts, artifact)
+		}
+	}
+	if err := d.writeManifest(); err != nil {
+		return nil, err
+	}
+
+	logger.Info().Str("dir", path).Msg("Writing the artifacts of the run")
+	return d, nil
+}
+
+// Finish records the end of the run and the size of every artifact in the
+// manifest
+func (d *RunDir) Finish(runErr error) error {
+	finished := time.Now()
+	d.manifest.FinishedAt = &finished
+	if runErr != nil {
+		d.manifest.Error = runErr.Error()
+	}
+	for i, artifact := range d.manifest.Artifacts {
+		if info, err := os.Stat(artifact.Path); err == nil {
+			d.manifest.Artifacts[i].Bytes = info.Size()
+		}
+	}
+	err := d.writeManifest()
+	setupLogger()
+	if closeErr := d.logFile.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("error closing log file: %w", closeErr)
+	}
+	return err
+}
+
+func (d *RunDir) writeManifest() error {
+	manifest := d.manifest
+	manifest.Artifacts = make([]RunArtifact, len(d.manifest.Artifacts))
+	for i, artifact := range d.manifest.Artifacts {
+		if rel, err := filepath.Rel(d.path, artifact.Path); err == nil && filepath.IsLocal(rel) {
+			artifact.Path = rel
+		}
+		manifest.Artifacts[i] = artifact
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(d.path, "manifest.json"), data, 0o644); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	return nil
+}
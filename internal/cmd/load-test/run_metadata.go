@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/viper"
+)
+
+// RunMetadata records what a run was made against, so results stay
+// interpretable long after the environment changed
+type RunMetadata struct {
+	StartedAt time.Time         `json:"started_at"`
+	Target    TargetMetadata    `json:"target"`
+	Generator GeneratorMetadata `json:"generator"`
+	Corpus    CorpusMetadata    `json:"corpus"`
+	// Config is the effective configuration with the DSN passwords redacted
+	Config map[string]any `json:"config"`
+}
+
+type TargetMetadata struct {
+	Version   string            `json:"version"`
+	Variables map[string]string `json:"variables"`
+}
+
+type GeneratorMetadata struct {
+	Hostname   string `json:"hostname"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	NumCPU     int    `json:"num_cpu"`
+	GOMAXPROCS int    `json:"gomaxprocs"`
+	GoVersion  string `json:"go_version"`
+	Revision   string `json:"revision,omitempty"`
+}
+
+type CorpusMetadata struct {
+	Name    string    `json:"name"`
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	SHA256  string    `json:"sha256"`
+}
+
+// targetVariables are the system variables that most often explain a
+// difference between two runs
+var targetVariables = []string{
+	"version_comment",
+	"innodb_buffer_pool_size",
+	"innodb_buffer_pool_instances",
+	"innodb_flush_log_at_trx_commit",
+	"innodb_flush_method",
+	"innodb_io_capacity",
+	"innodb_redo_log_capacity",
+	"innodb_log_file_size",
+	"innodb_thread_concurrency",
+	"sync_binlog",
+	"log_bin",
+	"binlog_format",
+	"max_connections",
+	"thread_cache_size",
+	"table_open_cache",
+	"transaction_isolation",
+	"optimizer_switch",
+	"query_cache_type",
+	"performance_schema",
+}
+
+func collectRunMetadata(ctx context.Context, admin *AdminConn, cfg Config) (*RunMetadata, error) {
+	md := &RunMetadata{
+		StartedAt: time.Now(),
+		Generator: generatorMetadata(),
+		Config:    redactSettings(viper.AllSettings()),
+	}
+
+	row, err := admin.DB().QueryRowContext(ctx, "SELECT @@version")
+	if err != nil {
+		return nil, fmt.Errorf("error fetching target version: %w", err)
+	}
+	if err := row.Scan(&md.Target.Version); err != nil {
+		return nil, fmt.Errorf("error fetching target version: %w", err)
+	}
+	variables, err := admin.ShowGlobalVariables(ctx, targetVariables...)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching target variables: %w", err)
+	}
+	md.Target.Variables = variables
+
+	if cfg.QueriesDataSource != nil {
+		corpus, err := corpusMetadata(cfg.QueriesDataSource.QueryDataSourceDB.InputFile)
+		if err != nil {
+			return nil, err
+		}
+		corpus.Name = cfg.QueriesDataSource.Name
+		if corpus.Name == "" {
+			corpus.Name = filepath.Base(corpus.File)
+		}
+		md.Corpus = corpus
+	}
+	return md, nil
+}
+
+func generatorMetadata() GeneratorMetadata {
+	md := GeneratorMetadata{
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		NumCPU:     runtime.NumCPU(),
+		GOMAXPROCS: runtime.GOMAXPROCS(0),
+		GoVersion:  runtime.Version(),
+	}
+	md.Hostname, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				md.Revision = setting.Value
+			}
+		}
+	}
+	return md
+}
+
+func corpusMetadata(path string) (CorpusMetadata, error) {
+	md := CorpusMetadata{File: path}
+	if path == "" {
+		return md, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return md, fmt.Errorf("error opening corpus: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return md, fmt.Errorf("error reading corpus info: %w", err)
+	}
+	md.Size, md.ModTime = info.Size(), info.ModTime()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return md, fmt.Errorf("error hashing corpus: %w", err)
+	}
+	md.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	return md, nil
+}
+
+// redactSettings redacts the passwords of every dsn setting, of the tunnel
+// proxy and the key passphrase in place
+func redactSettings(settings map[string]any) map[string]any {
+	for key, value := range settings {
+		switch v := value.(type) {
+		case map[string]any:
+			redactSettings(v)
+		case string:
+			switch {
+			case strings.HasSuffix(key, "dsn"):
+				settings[key] = redactDSN(v)
+			case key == "socks" && v != "":
+				settings[key] = redactURL(v)
+			case key == "key_passphrase" && v != "":
+				settings[key] = "****"
+			}
+		}
+	}
+	return settings
+}
+
+func redactDSN(dsn string) string {
+	if dsn == "" {
+		return ""
+	}
+	parsed, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return maskDSN(dsn)
+	}
+	if parsed.Passwd != "" {
+		parsed.Passwd = "****"
+	}
+	return parsed.FormatDSN()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// SchemaRouter fans the corpus out over identical schemas, picking them
+// round-robin
+type SchemaRouter struct {
+	schemas []string
+	source  string
+	next    atomic.Uint64
+}
+
+// NewSchemaRouter expands ranges such as "shard_001..shard_064" in targets.
+// References qualified with the source schema are rewritten to the target.
+func NewSchemaRouter(targets []string, source string) (*SchemaRouter, error) {
+	var schemas []string
+	for _, target := range targets {
+		expanded, err := expandSchemaRange(target)
+		if err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, expanded...)
+	}
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("no target schemas")
+	}
+	return &SchemaRouter{schemas: schemas, source: source}, nil
+}
+
+func (r *SchemaRouter) Len() int {
+	return len(r.schemas)
+}
+
+// Next returns the schema of the next execution
+func (r *SchemaRouter) Next() string {
+	return r.schemas[(r.next.Add(1)-1)%uint64(len(r.schemas))]
+}
+
+// Rewrite replaces references qualified with the source schema, as src.t or
+// `src`.t, by target
+func (r *SchemaRouter) Rewrite(query, target string) string {
+	if r.source == "" || r.source == target {
+		return query
+	}
+	query = strings.ReplaceAll(query, "`"+r.source+"`.", "`"+target+"`.")
+
+	var b strings.Builder
+	prefix := r.source + "."
+	for {
+		i := strings.Index(query, prefix)
+		if i < 0 {
+			break
+		}
+		// Only whole identifiers, not the tail of another name
+		if i > 0 && isIdentByte(query[i-1]) {
+			b.WriteString(query[:i+len(prefix)])
+		} else {
+			b.WriteString(query[:i])
+			b.WriteString(target)
+			b.WriteByte('.')
+		}
+		query = query[i+len(prefix):]
+	}
+	if b.Len() == 0 {
+		return query
+	}
+	b.WriteString(query)
+	return b.String()
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func isIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c == '`' || c == '.' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// expandSchemaRange expands "shard_001..shard_064" to every schema in the
+// range, keeping the zero padding. Other names are returned as is.
+func expandSchemaRange(s string) ([]string, error) {
+	from, to, ok := strings.Cut(s, "..")
+	if !ok {
+		return []string{s}, nil
+	}
+	fromPrefix, fromDigits := splitTrailingDigits(from)
+	toPrefix, toDigits := splitTrailingDigits(to)
+	if fromDigits == "" || toDigits == "" || fromPrefix != toPrefix {
+		return nil, fmt.Errorf("invalid schema range %q", s)
+	}
+	start, _ := strconv.Atoi(fromDigits)
+	end, _ := strconv.Atoi(toDigits)
+	if end < start {
+		return nil, fmt.Errorf("invalid schema range %q: end before start", s)
+	}
+
+	schemas := make([]string, 0, end-start+1)
+	for i := start; i <= end; i++ {
+		schemas = append(schemas, fmt.Sprintf("%s%0*d", fromPrefix, len(fromDigits), i))
+	}
+	return schemas, nil
+}
+
+func splitTrailingDigits(s string) (string, string) {
+	i := len(s)
+	for i > 0 && s[i-1] >= '0' && s[i-1] <= '9' {
+		i--
+	}
+	return s[:i], s[i:]
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// GeneratorStats is the resource usage of the load generator itself over an
+// aggregation window
+type GeneratorStats struct {
+	// CPUPercent is relative to GOMAXPROCS, 100 means every usable core is busy
+	CPUPercent   float64 `json:"cpu_percent"`
+	HeapAlloc    uint64  `json:"heap_alloc"`
+	Sys          uint64  `json:"sys"`
+	RSS          uint64  `json:"rss"`
+	NumGC        uint32  `json:"num_gc"`
+	GCPauseTotal float64 `json:"gc_pause_total_us"`
+	GCPauseMax   float64 `json:"gc_pause_max_us"`
+	Goroutines   int     `json:"goroutines"`
+	// Saturated is set when the generator was short of CPU or stalled by GC,
+	// which makes the latencies of the window unreliable
+	Saturated bool `json:"saturated"`
+}
+
+// Above these, the generator is considered saturated
+const saturatedCPUPercent = 90
+const saturatedGCPauseFraction = 0.05
+
+type selfMonitor struct {
+	prevCPU   time.Duration
+	prevWall  time.Time
+	prevNumGC uint32
+}
+
+func newSelfMonitor() *selfMonitor {
+	m := &selfMonitor{}
+	m.sample()
+	return m
+}
+
+// sample returns the usage since the previous sample
+func (m *selfMonitor) sample() GeneratorStats {
+	now := time.Now()
+	cpu := processCPUTime()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := GeneratorStats{
+		HeapAlloc:  mem.HeapAlloc,
+		Sys:        mem.Sys,
+		RSS:        processRSS(),
+		NumGC:      mem.NumGC - m.prevNumGC,
+		Goroutines: runtime.NumGoroutine(),
+	}
+
+	// PauseNs is a ring of the last 256 pauses
+	for i := uint32(0); i < min(stats.NumGC, 256); i++ {
+		pause := float64(mem.PauseNs[(mem.NumGC-i+255)%256]) / 1e3
+		stats.GCPauseTotal += pause
+		stats.GCPauseMax = max(stats.GCPauseMax, pause)
+	}
+
+	if wall := now.Sub(m.prevWall); !m.prevWall.IsZero() && wall > 0 {
+		stats.CPUPercent = float64(cpu-m.prevCPU) / float64(wall) / float64(runtime.GOMAXPROCS(0)) * 100
+		stats.Saturated = stats.CPUPercent >= saturatedCPUPercent ||
+			stats.GCPauseTotal*1e3 >= float64(wall)*saturatedGCPauseFraction
+	}
+
+	m.prevCPU, m.prevWall, m.prevNumGC = cpu, now, mem.NumGC
+	return stats
+}
+
+// processCPUTime returns the user and system CPU time used by the process
+func processCPUTime() time.Duration {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+}
+
+// processRSS returns the resident set size of the process, the memory
+// obtained from the OS by the runtime where /proc is missing
+func processRSS() uint64 {
+	statm, err := os.ReadFile("/proc/self/statm")
+	if err == nil {
+		if fields := bytes.Fields(statm); len(fields) > 1 {
+			if pages, err := strconv.ParseUint(string(fields[1]), 10, 64); err == nil {
+				return pages * uint64(os.Getpagesize())
+			}
+		}
+	}
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return mem.Sys
+}
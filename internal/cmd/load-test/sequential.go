@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// SequentialConfig shards the corpus over the workers in the sequential run
+// mode. The shards only depend on the corpus and the concurrency, so a rerun
+// executes the same queries in the same order on the same workers.
+type SequentialConfig struct {
+	// Sharding is modulo, query i of the corpus goes to worker i mod N, or
+	// range, every worker gets a contiguous block of the corpus
+	Sharding string `mapstructure:"sharding" yaml:"sharding" validate:"omitempty,oneof=modulo range"`
+	// Loop replays a shard from its start once done, otherwise the worker
+	// stops and the run ends with the last worker
+	Loop bool `mapstructure:"loop" yaml:"loop"`
+}
+
+// SessionReplayConfig replays the corpus session by session with run_mode
+// session
+type SessionReplayConfig struct {
+	// Loop replays the sessions of a worker from the first once done,
+	// otherwise the worker stops and the run ends with the last worker
+	Loop bool `mapstructure:"loop" yaml:"loop"`
+}
+
+// errShardDone is returned by Sequence.Next once the shard of a worker is
+// replayed
+var errShardDone = errors.New("shard replayed")
+
+// errSequentialDone ends a sequential run whose workers replayed their
+// shards
+var errSequentialDone = errors.New("sequential replay done")
+
+// sequentialQuery is a query of the corpus in corpus order
+type sequentialQuery struct {
+	id              int
+	fingerprintHash uint64
+}
+
+// sequentialSource is implemented by the data sources able to list their
+// queries in corpus order
+type sequentialSource interface {
+	SequentialQueries() ([]sequentialQuery, error)
+	SequentialQuery(sequentialQuery) (*QueryDataSourceResult, error)
+}
+
+// sessionSource is implemented by the data sources able to group their
+// queries by the client connection they were captured on
+type sessionSource interface {
+	sequentialSource
+	SessionQueries(ctx context.Context) ([][]sequentialQuery, error)
+}
+
+// Sequence hands every worker the queries of its shard in corpus order
+type Sequence struct {
+	source   sequentialSource
+	queries  []sequentialQuery
+	workers  int
+	sharding string
+	loop     bool
+	// next is the position of each worker in its shard, only touched by
+	// the worker itself
+	next []int
+
+	// shards are the corpus indexes of every worker in the session run
+	// mode, starts marks the indexes starting a session
+	shards [][]int
+	starts []bool
+	// started tells whether the last query handed to each worker starts a
+	// session
+	started []bool
+}
+
+func NewSequence(qds QueryDataSource, cfg SequentialConfig, workers int) (*Sequence, error) {
+	source, ok := qds.(sequentialSource)
+	if !ok {
+		return nil, fmt.Errorf("the query data source does not support the sequential run mode")
+	}
+	queries, err := source.SequentialQueries()
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no queries to replay sequentially")
+	}
+	sharding := cfg.Sharding
+	if sharding == "" {
+		sharding = "modulo"
+	}
+	return &Sequence{
+		source:   source,
+		queries:  queries,
+		workers:  max(workers, 1),
+		sharding: sharding,
+		loop:     cfg.Loop,
+		next:     make([]int, max(workers, 1)),
+	}, nil
+}
+
+// NewSessionSequence hands every worker whole sessions of the corpus, each
+// replayed in its order. Sessions go to the worker with the fewest queries so
+// far, in the order they started.
+func NewSessionSequence(ctx context.Context, qds QueryDataSource, cfg SessionReplayConfig, workers int) (*Sequence, error) {
+	source, ok := qds.(sessionSource)
+	if !ok {
+		return nil, fmt.Errorf("the query data source does not support the session run mode")
+	}
+	sessions, err := source.SessionQueries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, fmt.Errorf("no queries with a session to replay, collect the corpus with --input.pcap.sessions")
+	}
+
+	workers = max(workers, 1)
+	s := &Sequence{
+		source:   source,
+		workers:  workers,
+		sharding: "session",
+		loop:     cfg.Loop,
+		next:     make([]int, workers),
+		shards:   make([][]int, workers),
+		started:  make([]bool, workers),
+	}
+	for _, session := range sessions {
+		worker := 0
+		for w := range s.shards {
+			if len(s.shards[w]) < len(s.shards[worker]) {
+				worker = w
+			}
+		}
+		for i, q := range session {
+			s.shards[worker] = append(s.shards[worker], len(s.queries))
+			s.queries = append(s.queries, q)
+			s.starts = append(s.starts, i == 0)
+		}
+	}
+	return s, nil
+}
+
+// Sessions returns the number of sessions of the corpus in the session run
+// mode
+func (s *Sequence) Sessions() int {
+	n := 0
+	for _, start := range s.starts {
+		if start {
+			n++
+		}
+	}
+	return n
+}
+
+// SessionStarted tells whether the last query Next returned to worker starts
+// a session, whose connection must not carry the state of the previous one
+func (s *Sequence) SessionStarted(worker int) bool {
+	return s.started != nil && worker >= 0 && worker < len(s.started) && s.started[worker]
+}
+
+// shardSize returns the number of queries of the shard of worker
+func (s *Sequence) shardSize(worker int) int {
+	if s.shards != nil {
+		return len(s.shards[worker])
+	}
+	if s.sharding == "range" {
+		start, end := s.shardRange(worker)
+		return end - start
+	}
+	if worker >= len(s.queries) {
+		return 0
+	}
+	return (len(s.queries)-worker-1)/s.workers + 1
+}
+
+// shardRange returns the block of worker in range sharding, the first
+// len%workers blocks get one more query
+func (s *Sequence) shardRange(worker int) (int, int) {
+	n, w := len(s.queries), s.workers
+	size, rest := n/w, n%w
+	start := worker*size + min(worker, rest)
+	end := start + size
+	if worker < rest {
+		end++
+	}
+	return start, end
+}
+
+// index returns the corpus index of the i-th query of the shard of worker
+func (s *Sequence) index(worker, i int) int {
+	if s.shards != nil {
+		return s.shards[worker][i]
+	}
+	if s.sharding == "range" {
+		start, _ := s.shardRange(worker)
+		return start + i
+	}
+	return worker + i*s.workers
+}
+
+// Next returns the next query of the shard of worker, errShardDone once the
+// shard is replayed and not looped
+func (s *Sequence) Next(worker int) (*QueryDataSourceResult, error) {
+	if worker < 0 || worker >= s.workers {
+		return nil, errShardDone
+	}
+	size := s.shardSize(worker)
+	if size == 0 {
+		return nil, errShardDone
+	}
+	i := s.next[worker]
+	if i >= size {
+		if !s.loop {
+			return nil, errShardDone
+		}
+		i = 0
+	}
+	s.next[worker] = i + 1
+	index := s.index(worker, i)
+	if s.started != nil {
+		s.started[worker] = s.starts[index]
+	}
+	return s.source.SequentialQuery(s.queries[index])
+}
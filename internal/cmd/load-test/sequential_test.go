@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// corpusSource is a sequential source whose query i reads "i"
+type corpusSource struct{}
+
+func (corpusSource) SequentialQueries() ([]sequentialQuery, error) {
+	return nil, nil
+}
+
+func (corpusSource) SequentialQuery(q sequentialQuery) (*QueryDataSourceResult, error) {
+	return &QueryDataSourceResult{Query: fmt.Sprint(q.id)}, nil
+}
+
+func newCorpusSequence(queries, workers int, sharding string, loop bool) *Sequence {
+	s := &Sequence{
+		source:   corpusSource{},
+		queries:  make([]sequentialQuery, queries),
+		workers:  workers,
+		sharding: sharding,
+		loop:     loop,
+		next:     make([]int, workers),
+	}
+	for i := range s.queries {
+		s.queries[i].id = i
+	}
+	return s
+}
+
+func TestSequenceShards(t *testing.T) {
+	tests := []struct {
+		name     string
+		sharding string
+		queries  int
+		workers  int
+		want     [][]int
+	}{
+		{"modulo empty corpus", "modulo", 0, 3, [][]int{{}, {}, {}}},
+		{"range empty corpus", "range", 0, 3, [][]int{{}, {}, {}}},
+		{"modulo fewer queries than workers", "modulo", 2, 4, [][]int{{0}, {1}, {}, {}}},
+		{"range fewer queries than workers", "range", 2, 4, [][]int{{0}, {1}, {}, {}}},
+		{"modulo one worker", "modulo", 3, 1, [][]int{{0, 1, 2}}},
+		{"range one worker", "range", 3, 1, [][]int{{0, 1, 2}}},
+		{"modulo", "modulo", 7, 3, [][]int{{0, 3, 6}, {1, 4}, {2, 5}}},
+		{"range even", "range", 6, 3, [][]int{{0, 1}, {2, 3}, {4, 5}}},
+		// the first blocks get the rest, the last one ends with the corpus
+		{"range uneven", "range", 8, 3, [][]int{{0, 1, 2}, {3, 4, 5}, {6, 7}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newCorpusSequence(tt.queries, tt.workers, tt.sharding, false)
+			got := make([][]int, tt.workers)
+			for w := range got {
+				got[w] = []int{}
+				for i := range s.shardSize(w) {
+					got[w] = append(got[w], s.index(w, i))
+				}
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSequenceShardRange(t *testing.T) {
+	tests := []struct {
+		queries, workers int
+		want             [][2]int
+	}{
+		{0, 2, [][2]int{{0, 0}, {0, 0}}},
+		{1, 3, [][2]int{{0, 1}, {1, 1}, {1, 1}}},
+		{5, 2, [][2]int{{0, 3}, {3, 5}}},
+		{9, 3, [][2]int{{0, 3}, {3, 6}, {6, 9}}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d queries %d workers", tt.queries, tt.workers), func(t *testing.T) {
+			s := newCorpusSequence(tt.queries, tt.workers, "range", false)
+			for w, want := range tt.want {
+				start, end := s.shardRange(w)
+				assert.Equal(t, want, [2]int{start, end}, "worker %d", w)
+			}
+		})
+	}
+}
+
+func TestSequenceNext(t *testing.T) {
+	replay := func(s *Sequence, worker, n int) []string {
+		var got []string
+		for range n {
+			r, err := s.Next(worker)
+			if err != nil {
+				got = append(got, err.Error())
+				continue
+			}
+			got = append(got, r.Query)
+		}
+		return got
+	}
+	done := errShardDone.Error()
+
+	s := newCorpusSequence(5, 2, "range", false)
+	assert.Equal(t, []string{"3", "4", done, done}, replay(s, 1, 4))
+	assert.Equal(t, []string{"0", "1", "2", done}, replay(s, 0, 4))
+
+	s = newCorpusSequence(5, 2, "modulo", true)
+	assert.Equal(t, []string{"1", "3", "1", "3", "1"}, replay(s, 1, 5))
+
+	// workers without a shard stop at once, even when looping
+	s = newCorpusSequence(1, 3, "range", true)
+	assert.Equal(t, []string{done}, replay(s, 2, 1))
+	assert.Equal(t, []string{done}, replay(s, 3, 1))
+
+	_, err := s.Next(-1)
+	require.ErrorIs(t, err, errShardDone)
+}
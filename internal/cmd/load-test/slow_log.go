@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type SlowLogConfig struct {
+	File      string        `mapstructure:"file" yaml:"file" validate:"omitempty"`
+	Threshold time.Duration `mapstructure:"threshold" yaml:"threshold" validate:"omitempty,gt=0"`
+}
+
+// slowLogEntry is one line of the slow query log
+type slowLogEntry struct {
+	RunID           string    `json:"run_id"`
+	ID              uint64    `json:"id"`
+	Timestamp       time.Time `json:"ts"`
+	WorkerID        int       `json:"worker_id"`
+	Target          string    `json:"target"`
+	Schema          string    `json:"schema,omitempty"`
+	FingerprintHash uint64    `json:"fingerprint_hash"`
+	LatencyUs       int64     `json:"latency_us"`
+	Errno           uint16    `json:"errno,omitempty"`
+	Error           string    `json:"error,omitempty"`
+	Query           string    `json:"query"`
+}
+
+// SlowLog writes every execution slower than the threshold as NDJSON. Lines
+// are written through unbuffered so the file can be tailed during the run.
+// Entries are numbered within a random run id, both are attached as
+// exemplars to the latency histograms so a spike links to its entries.
+type SlowLog struct {
+	threshold time.Duration
+	target    string
+	runID     string
+	file      *os.File
+	mu        sync.Mutex
+	enc       *json.Encoder
+	seq       uint64
+}
+
+func NewSlowLog(cfg SlowLogConfig, target string) (*SlowLog, error) {
+	file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("error opening slow query log: %w", err)
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("error generating slow query log run id: %w", err)
+	}
+	return &SlowLog{
+		threshold: cfg.Threshold,
+		target:    target,
+		runID:     hex.EncodeToString(id[:]),
+		file:      file,
+		enc:       json.NewEncoder(file),
+	}, nil
+}
+
+// RunID identifies the entries of this run
+func (l *SlowLog) RunID() string {
+	return l.runID
+}
+
+// Record logs the execution if it exceeded the threshold and returns the
+// exemplar labels of its entry, nil when it was not logged
+func (l *SlowLog) Record(workerID int, fingerprintHash uint64, schema, query string, result *QueryResult, execErr error) prometheus.Labels {
+	if result.ExecLatency < l.threshold {
+		return nil
+	}
+
+	entry := slowLogEntry{
+		RunID:           l.runID,
+		Timestamp:       result.CompletionTimestamp,
+		WorkerID:        workerID,
+		Target:          l.target,
+		Schema:          schema,
+		FingerprintHash: fingerprintHash,
+		LatencyUs:       result.ExecLatency.Microseconds(),
+		Query:           query,
+	}
+	if execErr != nil {
+		entry.Error = execErr.Error()
+		entry.Errno = errno(execErr)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	entry.ID = l.seq
+	if err := l.enc.Encode(entry); err != nil {
+		logger.Error().Err(err).Msg("Error writing slow query log")
+		return nil
+	}
+	return prometheus.Labels{"run_id": l.runID, "slow_query_id": strconv.FormatUint(entry.ID, 10)}
+}
+
+func (l *SlowLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.file.Close()
+}
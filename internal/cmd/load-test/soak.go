@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SoakConfig tunes long runs of days: errors are rolled up without their
+// query text, the report is flushed to disk periodically and the generator
+// checks its own memory stays flat
+type SoakConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// FlushInterval is how often a partial report is written to FlushDir
+	FlushInterval time.Duration `mapstructure:"flush_interval" yaml:"flush_interval" validate:"omitempty,gte=0"`
+	FlushDir      string        `mapstructure:"flush_dir" yaml:"flush_dir" validate:"omitempty"`
+	// BaselineAfter is how long the generator runs before its RSS is taken
+	// as the baseline, caches and pools fill up meanwhile
+	BaselineAfter time.Duration `mapstructure:"baseline_after" yaml:"baseline_after" validate:"omitempty,gte=0"`
+	// MaxRSSGrowth is how many percent the RSS may grow over the baseline
+	// before the self-check fails
+	MaxRSSGrowth float64 `mapstructure:"max_rss_growth" yaml:"max_rss_growth" validate:"omitempty,gt=0"`
+}
+
+// SoakReport is the memory self-check of the generator. RSS values are in
+// bytes, Stable is false once the RSS grew past the allowed growth.
+type SoakReport struct {
+	BaselineRSS   uint64    `json:"baseline_rss"`
+	RSS           uint64    `json:"rss"`
+	PeakRSS       uint64    `json:"peak_rss"`
+	GrowthPercent float64   `json:"growth_percent"`
+	Stable        bool      `json:"stable"`
+	Flushes       int       `json:"flushes"`
+	LastFlush     time.Time `json:"last_flush,omitempty"`
+}
+
+type soakMonitor struct {
+	cfg     SoakConfig
+	started time.Time
+	report  SoakReport
+	warned  bool
+}
+
+func newSoakMonitor(cfg SoakConfig) (*soakMonitor, error) {
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Hour
+	}
+	if cfg.FlushDir == "" {
+		cfg.FlushDir = "soak-reports"
+	}
+	if cfg.BaselineAfter <= 0 {
+		cfg.BaselineAfter = 30 * time.Minute
+	}
+	if cfg.MaxRSSGrowth <= 0 {
+		cfg.MaxRSSGrowth = 20
+	}
+	if err := os.MkdirAll(cfg.FlushDir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating soak report directory: %w", err)
+	}
+	return &soakMonitor{
+		cfg:     cfg,
+		started: time.Now(),
+		report:  SoakReport{Stable: true, LastFlush: time.Now()},
+	}, nil
+}
+
+// check samples the RSS and returns the self-check so far
+func (s *soakMonitor) check(rss uint64) *SoakReport {
+	s.report.RSS = rss
+	s.report.PeakRSS = max(s.report.PeakRSS, rss)
+	if s.report.BaselineRSS == 0 {
+		if time.Since(s.started) >= s.cfg.BaselineAfter {
+			s.report.BaselineRSS = rss
+			logger.Info().Uint64("rss", rss).Msg("Soak RSS baseline taken")
+		}
+		report := s.report
+		return &report
+	}
+
+	s.report.GrowthPercent = (float64(rss) - float64(s.report.BaselineRSS)) / float64(s.report.BaselineRSS) * 100
+	if s.report.GrowthPercent > s.cfg.MaxRSSGrowth {
+		s.report.Stable = false
+		if !s.warned {
+			s.warned = true
+			logger.Warn().
+				Uint64("baseline_rss", s.report.BaselineRSS).
+				Uint64("rss", rss).
+				Float64("growth_percent", s.report.GrowthPercent).
+				Msg("Soak self-check failed, the generator RSS keeps growing")
+		}
+	}
+	report := s.report
+	return &report
+}
+
+// flush writes the report to the flush directory once the flush interval
+// passed since the last flush
+func (s *soakMonitor) flush(r *Report) {
+	if time.Since(s.report.LastFlush) < s.cfg.FlushInterval {
+		return
+	}
+	s.report.LastFlush = time.Now()
+	s.report.Flushes++
+	path := filepath.Join(s.cfg.FlushDir, "report-"+s.report.LastFlush.UTC().Format("20060102T150405Z")+".json")
+	if err := (&jsonFileReporter{path: path}).Report(r); err != nil {
+		logger.Warn().Err(err).Msg("Error flushing soak report")
+		return
+	}
+	logger.Info().Str("file", path).Msg("Flushed soak report")
+}
+
+// done logs the outcome of the self-check at the end of the run
+func (s *soakMonitor) done() {
+	event := logger.Info()
+	msg := "Soak self-check passed, the generator RSS stayed flat"
+	switch {
+	case s.report.BaselineRSS == 0:
+		msg = "Soak self-check skipped, the run ended before the RSS baseline"
+	case !s.report.Stable:
+		event = logger.Warn()
+		msg = "Soak self-check failed, the generator RSS grew"
+	}
+	event.
+		Uint64("baseline_rss", s.report.BaselineRSS).
+		Uint64("peak_rss", s.report.PeakRSS).
+		Float64("growth_percent", s.report.GrowthPercent).
+		Msg(msg)
+}
@@ -0,0 +1,57 @@
+package main
+
+import "strings"
+
+type StatementType string
+
+const (
+	StatementSelect StatementType = "select"
+	StatementInsert StatementType = "insert"
+	StatementUpdate StatementType = "update"
+	StatementDelete StatementType = "delete"
+	StatementOther  StatementType = "other"
+)
+
+// classifyStatement returns the type of query from its first keyword,
+// skipping leading whitespace, comments and parentheses
+func classifyStatement(query string) StatementType {
+	q := query
+	for {
+		q = strings.TrimLeft(q, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(q, "/*"):
+			end := strings.Index(q, "*/")
+			if end < 0 {
+				return StatementOther
+			}
+			q = q[end+2:]
+		case strings.HasPrefix(q, "--"), strings.HasPrefix(q, "#"):
+			end := strings.IndexByte(q, '\n')
+			if end < 0 {
+				return StatementOther
+			}
+			q = q[end+1:]
+		default:
+			return statementFromKeyword(q)
+		}
+	}
+}
+
+func statementFromKeyword(q string) StatementType {
+	end := strings.IndexAny(q, " \t\r\n(")
+	if end < 0 {
+		end = len(q)
+	}
+	switch strings.ToLower(q[:end]) {
+	case "select", "with":
+		return StatementSelect
+	case "insert", "replace":
+		return StatementInsert
+	case "update":
+		return StatementUpdate
+	case "delete":
+		return StatementDelete
+	default:
+		return StatementOther
+	}
+}
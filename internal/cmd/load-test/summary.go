@@ -0,0 +1,226 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// ReportingConfig writes a summary of the whole run to OutFile once it ends
+type ReportingConfig struct {
+	OutFile string `mapstructure:"out_file" yaml:"out_file" validate:"omitempty"`
+	// Format is human, aligned text, by default or json
+	Format string `mapstructure:"format" yaml:"format" validate:"omitempty,oneof=json human"`
+}
+
+// summaryFingerprints bounds the fingerprints of the summary, the ones the
+// run spent the most time on
+const summaryFingerprints = 50
+
+// RunSummary sums up a run, latencies are in microseconds
+type RunSummary struct {
+	Workload   string    `json:"workload,omitempty"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Duration   float64   `json:"duration_seconds"`
+
+	Queries   int64   `json:"queries"`
+	Errors    int64   `json:"errors"`
+	ErrorRate float64 `json:"error_rate"`
+	QPS       float64 `json:"qps"`
+	Average   float64 `json:"avg_us"`
+	LatP50    float64 `json:"p50_us"`
+	LatP95    float64 `json:"p95_us"`
+	LatP99    float64 `json:"p99_us"`
+	Slowest   float64 `json:"max_us"`
+
+	ErrorDist map[string]int `json:"error_dist"`
+	// Fingerprints are the fingerprints the run spent the most time on,
+	// FingerprintCount counts them all
+	Fingerprints     []FingerprintSummary `json:"fingerprints"`
+	FingerprintCount int                  `json:"fingerprint_count"`
+}
+
+// FingerprintSummary holds the executions of a fingerprint over the run,
+// hash 0 gathers the fingerprints past the tracked ones
+type FingerprintSummary struct {
+	Hash       uint64  `json:"hash,string"`
+	Executions int64   `json:"executions"`
+	Errors     int64   `json:"errors"`
+	Average    float64 `json:"avg_us"`
+	Slowest    float64 `json:"max_us"`
+	// TimeShare is the fraction of the execution time of the run spent on
+	// the fingerprint
+	TimeShare float64 `json:"time_share"`
+}
+
+// summaryReporter writes the run summary when the run ends. It follows the
+// windows as they are aggregated, the report only keeps the latest ones.
+type summaryReporter struct {
+	cfg     ReportingConfig
+	summary RunSummary
+
+	lastWindow  time.Time
+	firstWindow time.Time
+	sum         float64
+}
+
+func newSummaryReporter(cfg ReportingConfig) *summaryReporter {
+	if cfg.Format == "" {
+		cfg.Format = "human"
+	}
+	return &summaryReporter{cfg: cfg}
+}
+
+func (s *summaryReporter) Report(r *Report) error {
+	for _, a := range r.Aggregates {
+		if !a.WindowEnd.After(s.lastWindow) {
+			continue
+		}
+		if s.firstWindow.IsZero() {
+			s.firstWindow = a.WindowStart
+		}
+		s.summary.Queries += a.NumRes
+		s.sum += a.Average * float64(a.NumRes)
+		s.lastWindow = a.WindowEnd
+	}
+
+	s.summary.Workload = r.Workload
+	s.summary.StartedAt = r.startedAt
+	if r.Metadata != nil {
+		s.summary.StartedAt = r.Metadata.StartedAt
+	}
+	s.summary.FinishedAt = s.lastWindow
+	s.summary.ErrorDist = r.ErrorDist
+	s.summary.Errors = 0
+	for _, count := range r.ErrorDist {
+		s.summary.Errors += int64(count)
+	}
+	if s.summary.Queries > 0 {
+		queries := float64(s.summary.Queries)
+		s.summary.Duration = s.lastWindow.Sub(s.firstWindow).Seconds()
+		s.summary.ErrorRate = float64(s.summary.Errors) / queries
+		s.summary.QPS = queries / s.summary.Duration
+		s.summary.Average = s.sum / queries
+	}
+	if r.runLats.Count() > 0 {
+		s.summary.LatP50 = float64(r.runLats.ValueAtQuantile(50))
+		s.summary.LatP95 = float64(r.runLats.ValueAtQuantile(95))
+		s.summary.LatP99 = float64(r.runLats.ValueAtQuantile(99))
+		s.summary.Slowest = float64(r.runLats.Max())
+	}
+	s.summary.Fingerprints, s.summary.FingerprintCount = summarizeFingerprints(r.fingerprints)
+	return nil
+}
+
+// summarizeFingerprints returns the fingerprints the run spent the most time
+// on, and the count of all of them
+func summarizeFingerprints(stats map[uint64]*fingerprintStats) ([]FingerprintSummary, int) {
+	var total time.Duration
+	for _, st := range stats {
+		total += st.total
+	}
+	fingerprints := make([]FingerprintSummary, 0, len(stats))
+	for hash, st := range stats {
+		f := FingerprintSummary{
+			Hash:       hash,
+			Executions: st.executions,
+			Errors:     st.errors,
+			Slowest:    float64(st.max.Microseconds()),
+		}
+		if ok := st.executions - st.errors; ok > 0 {
+			f.Average = float64(st.total.Microseconds()) / float64(ok)
+		}
+		if total > 0 {
+			f.TimeShare = float64(st.total) / float64(total)
+		}
+		fingerprints = append(fingerprints, f)
+	}
+	slices.SortFunc(fingerprints, func(a, b FingerprintSummary) int {
+		return cmp.Or(cmp.Compare(b.TimeShare, a.TimeShare), cmp.Compare(b.Executions, a.Executions), cmp.Compare(a.Hash, b.Hash))
+	})
+	return fingerprints[:min(len(fingerprints), summaryFingerprints)], len(stats)
+}
+
+// Close writes the summary
+func (s *summaryReporter) Close() error {
+	f, err := os.Create(s.cfg.OutFile)
+	if err != nil {
+		return fmt.Errorf("error creating summary file: %w", err)
+	}
+	if s.cfg.Format == "json" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(s.summary)
+	} else {
+		err = writeHumanSummary(f, s.summary)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("error writing summary file: %w", err)
+	}
+	logger.Info().Str("file", s.cfg.OutFile).Msg("Wrote the run summary")
+	return nil
+}
+
+func writeHumanSummary(w io.Writer, s RunSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if s.Workload != "" {
+		fmt.Fprintf(tw, "Workload\t%s\n", s.Workload)
+	}
+	fmt.Fprintf(tw, "Started\t%s\n", s.StartedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Finished\t%s\n", s.FinishedAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(tw, "Duration\t%s\n", (time.Duration(s.Duration * float64(time.Second))).Round(time.Second))
+	fmt.Fprintf(tw, "Queries\t%d\n", s.Queries)
+	fmt.Fprintf(tw, "QPS\t%.1f\n", s.QPS)
+	fmt.Fprintf(tw, "Errors\t%d (%.2f%%)\n", s.Errors, s.ErrorRate*100)
+	fmt.Fprintf(tw, "Latency\tavg %s  p50 %s  p95 %s  p99 %s  max %s\n", formatMicros(s.Average), formatMicros(s.LatP50), formatMicros(s.LatP95), formatMicros(s.LatP99), formatMicros(s.Slowest))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(s.ErrorDist) > 0 {
+		errs := make([]string, 0, len(s.ErrorDist))
+		for msg := range s.ErrorDist {
+			errs = append(errs, msg)
+		}
+		slices.SortFunc(errs, func(a, b string) int {
+			return cmp.Or(cmp.Compare(s.ErrorDist[b], s.ErrorDist[a]), cmp.Compare(a, b))
+		})
+		fmt.Fprintln(tw, "\nCOUNT\tERROR")
+		for _, msg := range errs {
+			fmt.Fprintf(tw, "%d\t%s\n", s.ErrorDist[msg], msg)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(s.Fingerprints) > 0 {
+		fmt.Fprintf(tw, "\nFINGERPRINT\tEXECUTIONS\tERRORS\tAVG\tMAX\tTIME SHARE\n")
+		for _, f := range s.Fingerprints {
+			hash := strconv.FormatUint(f.Hash, 10)
+			if f.Hash == otherFingerprintsHash {
+				hash = "other"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%.1f%%\n", hash, f.Executions, f.Errors, formatMicros(f.Average), formatMicros(f.Slowest), f.TimeShare*100)
+		}
+		if s.FingerprintCount > len(s.Fingerprints) {
+			fmt.Fprintf(tw, "... %d more\n", s.FingerprintCount-len(s.Fingerprints))
+		}
+	}
+	return tw.Flush()
+}
+
+// formatMicros formats a latency in microseconds
+func formatMicros(us float64) string {
+	return (time.Duration(us * float64(time.Microsecond))).Round(time.Microsecond).String()
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+const defaultFingerprintTagsQuery = "SELECT FingerprintHash, `Name`, `Value` FROM QueryFingerprintTag"
+
+// FingerprintTagsConfig selects and reweights fingerprints by the tags set
+// with mlt tag, every tag is written name=value
+type FingerprintTagsConfig struct {
+	// Include keeps only the fingerprints with at least one of the tags
+	Include []string `mapstructure:"include" yaml:"include"`
+	// Exclude drops the fingerprints with any of the tags
+	Exclude []string `mapstructure:"exclude" yaml:"exclude"`
+	// Mix gives the fingerprints of each tag a fixed share of the load, the
+	// untagged rest shares what is left
+	Mix   []TagShareConfig `mapstructure:"mix" yaml:"mix" validate:"omitempty,dive"`
+	Query string           `mapstructure:"query" yaml:"query" validate:"omitempty"`
+}
+
+type TagShareConfig struct {
+	Tag string `mapstructure:"tag" yaml:"tag" validate:"required"`
+	// Weight is the share of the load in percent
+	Weight float64 `mapstructure:"weight" yaml:"weight" validate:"gt=0,lte=100"`
+}
+
+// Enabled reports whether the tags change which fingerprints run
+func (c FingerprintTagsConfig) Enabled() bool {
+	return len(c.Include) > 0 || len(c.Exclude) > 0 || len(c.Mix) > 0
+}
+
+func (c FingerprintTagsConfig) validate() error {
+	var total float64
+	for _, tag := range slices.Concat(c.Include, c.Exclude) {
+		if _, _, ok := parseTag(tag); !ok {
+			return fmt.Errorf("invalid tag %q, expected name=value", tag)
+		}
+	}
+	for _, share := range c.Mix {
+		if _, _, ok := parseTag(share.Tag); !ok {
+			return fmt.Errorf("invalid mix tag %q, expected name=value", share.Tag)
+		}
+		total += share.Weight
+	}
+	if total > 100 {
+		return fmt.Errorf("tag mix weights add up to %g%%, more than 100%%", total)
+	}
+	return nil
+}
+
+func parseTag(tag string) (name, value string, ok bool) {
+	name, value, ok = strings.Cut(tag, "=")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	return name, value, ok && name != "" && value != ""
+}
+
+// FingerprintTags maps fingerprint hashes to their name=value tags
+type FingerprintTags map[uint64][]string
+
+func (t FingerprintTags) has(hash uint64, tags []string) bool {
+	for _, tag := range t[hash] {
+		if slices.Contains(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// fingerprintTagger is implemented by the data sources that know the tags of
+// their fingerprints, the report breaks its stats down by them
+type fingerprintTagger interface {
+	FingerprintTags() FingerprintTags
+}
+
+func (qsdb *QuerySourceDB) FingerprintTags() FingerprintTags {
+	return qsdb.tags
+}
+
+// fetchTags loads the fingerprint tags. Without tag selection they only feed
+// the report, so a metadata database without the tag table is fine.
+func (qsdb *QuerySourceDB) fetchTags(ctx context.Context) error {
+	query := qsdb.tagsCfg.Query
+	if query == "" {
+		query = defaultFingerprintTagsQuery
+	}
+	rows, err := qsdb.db.QueryContext(ctx, query)
+	if err != nil {
+		if !qsdb.tagsCfg.Enabled() {
+			logger.Debug().Err(err).Msg("No fingerprint tags loaded")
+			return nil
+		}
+		return err
+	}
+	defer rows.Close()
+
+	tags := make(FingerprintTags)
+	for rows.Next() {
+		var hash uint64
+		var name, value string
+		if err := rows.Scan(&hash, &name, &value); err != nil {
+			return err
+		}
+		tags[hash] = append(tags[hash], name+"="+value)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	qsdb.tags = tags
+	logger.Info().Int("fingerprints", len(tags)).Msg("Loaded fingerprint tags")
+	return nil
+}
+
+// selectByTags applies the include and exclude filters and the mix of the
+// tags config, returning new weights
+func selectByTags(qw *QueryFingerprintWeights, tags FingerprintTags, cfg FingerprintTagsConfig) *QueryFingerprintWeights {
+	selected := NewQueryFingerprintWeights()
+	for _, w := range qw.weights {
+		hash := w.fingerprintData.Hash
+		if len(cfg.Include) > 0 && !tags.has(hash, cfg.Include) {
+			continue
+		}
+		if tags.has(hash, cfg.Exclude) {
+			continue
+		}
+		selected.Add(w.weight, w.fingerprintData)
+	}
+	if len(cfg.Mix) == 0 || selected.totalWeight <= 0 {
+		return selected
+	}
+
+	// group every fingerprint under the first mix tag it has, the last group
+	// holds the rest
+	groups := make([]int, len(selected.weights))
+	totals := make([]float64, len(cfg.Mix)+1)
+	shares := make([]float64, len(cfg.Mix)+1)
+	shares[len(cfg.Mix)] = 100
+	for i, share := range cfg.Mix {
+		shares[i] = share.Weight
+		shares[len(cfg.Mix)] -= share.Weight
+	}
+	for i, w := range selected.weights {
+		groups[i] = len(cfg.Mix)
+		for j, share := range cfg.Mix {
+			if tags.has(w.fingerprintData.Hash, []string{share.Tag}) {
+				groups[i] = j
+				break
+			}
+		}
+		totals[groups[i]] += w.weight
+	}
+	for j, share := range cfg.Mix {
+		if totals[j] == 0 {
+			logger.Warn().Str("tag", share.Tag).Msg("No selected fingerprint has the mix tag, its share goes to the others")
+		}
+	}
+
+	mixed := NewQueryFingerprintWeights()
+	for i, w := range selected.weights {
+		g := groups[i]
+		mixed.Add(w.weight/totals[g]*shares[g], w.fingerprintData)
+	}
+	return mixed
+}
+
+// applyTags narrows the weights to the configured tags
+func (qsdb *QuerySourceDB) applyTags(qw *QueryFingerprintWeights) (*QueryFingerprintWeights, error) {
+	if !qsdb.tagsCfg.Enabled() {
+		return qw, nil
+	}
+	selected := selectByTags(qw, qsdb.tags, qsdb.tagsCfg)
+	if selected.totalWeight <= 0 {
+		return nil, fmt.Errorf("no fingerprints match the tag filters")
+	}
+	return selected, nil
+}
+
+// TagStat sums the executions of the fingerprints with one tag since the
+// start of the measurement. Latencies are in microseconds.
+type TagStat struct {
+	Fingerprints int     `json:"fingerprints"`
+	Executions   int64   `json:"executions"`
+	Errors       int64   `json:"errors"`
+	Share        float64 `json:"share"`
+	AvgUs        float64 `json:"avg_us"`
+	MaxUs        float64 `json:"max_us"`
+}
+
+// tagStats breaks the fingerprint stats down by tag, a fingerprint counts
+// toward each of its tags
+func tagStats(fingerprints map[uint64]*fingerprintStats, tags FingerprintTags) map[string]*TagStat {
+	stats := make(map[string]*TagStat)
+	totals := make(map[string]time.Duration)
+	var executions int64
+	for hash, st := range fingerprints {
+		executions += st.executions
+		for _, tag := range tags[hash] {
+			ts, ok := stats[tag]
+			if !ok {
+				ts = &TagStat{}
+				stats[tag] = ts
+			}
+			ts.Fingerprints++
+			ts.Executions += st.executions
+			ts.Errors += st.errors
+			ts.MaxUs = max(ts.MaxUs, float64(st.max.Microseconds()))
+			totals[tag] += st.total
+		}
+	}
+	for tag, ts := range stats {
+		if executions > 0 {
+			ts.Share = float64(ts.Executions) / float64(executions) * 100
+		}
+		if ok := ts.Executions - ts.Errors; ok > 0 {
+			ts.AvgUs = float64(totals[tag].Microseconds()) / float64(ok)
+		}
+	}
+	return stats
+}
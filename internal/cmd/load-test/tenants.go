@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"regexp"
+	"strconv"
+)
+
+type TenantRewriteConfig struct {
+	// Column is the tenant id column, e.g. tenant_id
+	Column string `mapstructure:"column" yaml:"column" validate:"omitempty"`
+	Min    int64  `mapstructure:"min" yaml:"min" validate:"omitempty,gte=0"`
+	Max    int64  `mapstructure:"max" yaml:"max" validate:"omitempty,gtefield=Min"`
+}
+
+// TenantRewriter replaces the tenant ids compared against the tenant column
+// with ids drawn uniformly from a range, so a capture from a few tenants
+// simulates the access patterns of many
+type TenantRewriter struct {
+	min, max int64
+	eq       *regexp.Regexp
+	in       *regexp.Regexp
+	digits   *regexp.Regexp
+}
+
+func NewTenantRewriter(cfg TenantRewriteConfig) (*TenantRewriter, error) {
+	if cfg.Max < cfg.Min {
+		return nil, fmt.Errorf("tenant range max %d is below min %d", cfg.Max, cfg.Min)
+	}
+	column := "(?i)(?:^|[^\\w$])`?" + regexp.QuoteMeta(cfg.Column) + "`?"
+	return &TenantRewriter{
+		min:    cfg.Min,
+		max:    cfg.Max,
+		eq:     regexp.MustCompile(column + `\s*=\s*'?(\d+)'?`),
+		in:     regexp.MustCompile(column + `\s+IN\s*\(([\d\s,']*)\)`),
+		digits: regexp.MustCompile(`\d+`),
+	}, nil
+}
+
+// Rewrite replaces the tenant ids of query. Equality comparisons of one
+// query all get the same tenant, IN lists get distinct draws.
+func (t *TenantRewriter) Rewrite(query string) string {
+	tenant := strconv.FormatInt(t.draw(), 10)
+	query = replaceSubmatch(t.eq, query, func(string) string {
+		return tenant
+	})
+	return replaceSubmatch(t.in, query, func(list string) string {
+		return t.digits.ReplaceAllStringFunc(list, func(string) string {
+			return strconv.FormatInt(t.draw(), 10)
+		})
+	})
+}
+
+func (t *TenantRewriter) draw() int64 {
+	return t.min + rand.Int64N(t.max-t.min+1)
+}
+
+// replaceSubmatch replaces the first capture group of every match of re
+func replaceSubmatch(re *regexp.Regexp, s string, repl func(string) string) string {
+	matches := re.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+	var out []byte
+	last := 0
+	for _, m := range matches {
+		out = append(out, s[last:m[2]]...)
+		out = append(out, repl(s[m[2]:m[3]])...)
+		last = m[3]
+	}
+	return string(append(out, s[last:]...))
+}
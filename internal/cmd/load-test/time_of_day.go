@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type TimeOfDayConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// HourDuration is how long one captured hour lasts in the run, e.g. 1m
+	// replays the whole daily profile in 24 minutes
+	HourDuration time.Duration `mapstructure:"hour_duration" yaml:"hour_duration" validate:"omitempty,gt=0"`
+	// StartHour is the captured hour of day (UTC) the run starts at
+	StartHour int `mapstructure:"start_hour" yaml:"start_hour" validate:"omitempty,gte=0,lt=24"`
+}
+
+// HourlyWeights holds a fingerprint weight distribution per hour of day and
+// shifts between them as the run progresses
+type HourlyWeights struct {
+	cfg      TimeOfDayConfig
+	hours    [24]*QueryFingerprintWeights
+	fallback *QueryFingerprintWeights
+	start    time.Time
+}
+
+func NewHourlyWeights(cfg TimeOfDayConfig, fallback *QueryFingerprintWeights) *HourlyWeights {
+	if cfg.HourDuration <= 0 {
+		cfg.HourDuration = time.Hour
+	}
+	return &HourlyWeights{cfg: cfg, fallback: fallback, start: time.Now()}
+}
+
+func (h *HourlyWeights) Add(hour int, weight float64, fingerprintData *QueryFingerprintData) {
+	if h.hours[hour] == nil {
+		h.hours[hour] = NewQueryFingerprintWeights()
+	}
+	h.hours[hour].Add(weight, fingerprintData)
+}
+
+// Hour returns the captured hour of day replayed at now
+func (h *HourlyWeights) Hour(now time.Time) int {
+	elapsed := int(now.Sub(h.start) / h.cfg.HourDuration)
+	return (h.cfg.StartHour + elapsed) % 24
+}
+
+// At returns the weights of the hour replayed at now, or the static weights
+// if nothing was captured in that hour
+func (h *HourlyWeights) At(now time.Time) *QueryFingerprintWeights {
+	if weights := h.hours[h.Hour(now)]; weights != nil {
+		return weights
+	}
+	return h.fallback
+}
+
+// Covered returns the number of hours with captured weights
+func (h *HourlyWeights) Covered() int {
+	covered := 0
+	for _, weights := range h.hours {
+		if weights != nil {
+			covered++
+		}
+	}
+	return covered
+}
+
+// Restart makes the replayed profile begin at the start hour from now
+func (h *HourlyWeights) Restart() {
+	h.start = time.Now()
+}
+
+func (qsdb *QuerySourceDB) fetchHourlyWeights(ctx context.Context) error {
+	if qsdb.cfg.HourlyWeightsQuery == "" {
+		return fmt.Errorf("time of day replay requires hourly_weights_query")
+	}
+
+	hourly := NewHourlyWeights(qsdb.timeOfDay, qsdb.fingerprintWeights)
+	rows, err := qsdb.db.QueryContext(ctx, qsdb.cfg.HourlyWeightsQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var hour int
+		var hash uint64
+		var weight float64
+		if err := rows.Scan(&hour, &hash, &weight); err != nil {
+			return err
+		}
+		if hour < 0 || hour >= 24 {
+			return fmt.Errorf("hourly weights query returned invalid hour %d", hour)
+		}
+		hourly.Add(hour, weight, &QueryFingerprintData{Hash: hash})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for hour, weights := range hourly.hours {
+		if weights == nil {
+			continue
+		}
+		// an hour without any selected fingerprint falls back to the static weights
+		if weights = selectByTags(weights, qsdb.tags, qsdb.tagsCfg); weights.totalWeight <= 0 {
+			hourly.hours[hour] = nil
+			continue
+		}
+		weights.Smooth(qsdb.smoothing)
+		hourly.hours[hour] = weights
+	}
+
+	if hourly.Covered() == 0 {
+		return fmt.Errorf("no hourly weights were loaded from the database")
+	}
+	qsdb.hourlyWeights = hourly
+
+	logger.Info().
+		Int("hours_covered", hourly.Covered()).
+		Dur("hour_duration", hourly.cfg.HourDuration).
+		Int("start_hour", hourly.cfg.StartHour).
+		Msg("Loaded time of day weights")
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ReplayConfig schedules the corpus on its capture timestamps with run_mode
+// replay
+type ReplayConfig struct {
+	// Speed multiplies the pace of the capture, 2 replays an hour of
+	// traffic in 30 minutes and 0.5 in two hours. 1 by default.
+	Speed float64 `mapstructure:"speed" yaml:"speed" validate:"omitempty,gt=0"`
+	// Loop starts the timeline over once replayed, otherwise the run ends
+	// with its last query
+	Loop bool `mapstructure:"loop" yaml:"loop"`
+}
+
+// timelineQuery is a query of the corpus with its capture time in Unix
+// seconds
+type timelineQuery struct {
+	sequentialQuery
+	timestamp uint64
+}
+
+// timelineSource is implemented by the data sources able to list their
+// queries by capture time
+type timelineSource interface {
+	sequentialSource
+	TimelineQueries(ctx context.Context) ([]timelineQuery, error)
+}
+
+// Timeline hands the queries of the corpus to the workers at their capture
+// time relative to the first query, scaled by the speed. The timestamps only
+// have a second of precision, the queries of a second are spread evenly over
+// it in their order. Queries the workers pick up late are executed right
+// away, their corrected latency counts the delay.
+type Timeline struct {
+	source  sequentialSource
+	queries []timelineQuery
+	// due is when each query is due from the start of a pass, span the
+	// length of a pass
+	due   []time.Duration
+	span  time.Duration
+	speed float64
+	loop  bool
+
+	mu    sync.Mutex
+	start time.Time
+	next  int
+	pass  int
+}
+
+func NewTimeline(ctx context.Context, qds QueryDataSource, cfg ReplayConfig) (*Timeline, error) {
+	source, ok := qds.(timelineSource)
+	if !ok {
+		return nil, fmt.Errorf("the query data source does not support the replay run mode")
+	}
+	queries, err := source.TimelineQueries(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(queries) == 0 {
+		return nil, fmt.Errorf("no queries with a capture timestamp to replay")
+	}
+	speed := cfg.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	t := &Timeline{
+		source:  source,
+		queries: queries,
+		due:     make([]time.Duration, len(queries)),
+		speed:   speed,
+		loop:    cfg.Loop,
+	}
+	first := queries[0].timestamp
+	for i := 0; i < len(queries); {
+		// the queries of the same second
+		end := i
+		for end < len(queries) && queries[end].timestamp == queries[i].timestamp {
+			end++
+		}
+		second := time.Duration(queries[i].timestamp-first) * time.Second
+		for j := i; j < end; j++ {
+			t.due[j] = t.scale(second + time.Second*time.Duration(j-i)/time.Duration(end-i))
+		}
+		i = end
+	}
+	t.span = t.scale(time.Duration(queries[len(queries)-1].timestamp-first+1) * time.Second)
+	return t, nil
+}
+
+// scale turns a capture duration into a replay duration
+func (t *Timeline) scale(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / t.speed)
+}
+
+// Duration is how long a pass over the timeline takes
+func (t *Timeline) Duration() time.Duration {
+	return t.span
+}
+
+// Next waits for the next query of the timeline to be due and returns it
+// with its due time, errShardDone once the timeline is replayed and not
+// looped. The timeline starts with the first call.
+func (t *Timeline) Next(ctx context.Context) (*QueryDataSourceResult, time.Time, error) {
+	t.mu.Lock()
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	if t.next == len(t.queries) {
+		if !t.loop {
+			t.mu.Unlock()
+			return nil, time.Time{}, errShardDone
+		}
+		t.next = 0
+		t.pass++
+	}
+	i := t.next
+	t.next++
+	due := t.start.Add(time.Duration(t.pass)*t.span + t.due[i])
+	t.mu.Unlock()
+
+	if wait := time.Until(due); wait > 0 {
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, due, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	query, err := t.source.SequentialQuery(t.queries[i].sequentialQuery)
+	return query, due, err
+}
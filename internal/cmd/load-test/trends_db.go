@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"mysql-load-test/internal/resultsdb"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/spf13/viper"
+)
+
+// trendsIgnoredSettings don't change the load, runs differing only there
+// share their config hash
+var trendsIgnoredSettings = []string{"log_level", "metrics", "reporters", "reporting", "output_dir", "execution_log", "slow_log"}
+
+// trendsDBReporter keeps the summary row of the run in the trends database
+// up to date, so killed runs leave a row too. The latencies are the
+// averages of the aggregate percentiles weighted by their results.
+type trendsDBReporter struct {
+	db      *sql.DB
+	summary resultsdb.RunSummary
+
+	lastWindow    time.Time
+	firstWindow   time.Time
+	p50, p95, p99 float64
+}
+
+func newTrendsDBReporter(dsn, target string) (*trendsDBReporter, error) {
+	if target == "" {
+		target = trendsTarget(config.DBDSN)
+	}
+	configHash, err := trendsConfigHash(viper.AllSettings())
+	if err != nil {
+		return nil, err
+	}
+	db, err := resultsdb.OpenTrends(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &trendsDBReporter{
+		db:      db,
+		summary: resultsdb.RunSummary{Target: target, ConfigHash: configHash},
+	}, nil
+}
+
+// trendsTarget names the target by the address and database of its DSN
+func trendsTarget(dsn string) string {
+	parsed, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return maskDSN(dsn)
+	}
+	return parsed.Addr + "/" + parsed.DBName
+}
+
+// trendsConfigHash hashes the redacted settings that shape the load
+func trendsConfigHash(settings map[string]any) (string, error) {
+	settings = redactSettings(settings)
+	for _, key := range trendsIgnoredSettings {
+		delete(settings, key)
+	}
+	// maps marshal with sorted keys
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return "", fmt.Errorf("error marshaling config for hashing: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (t *trendsDBReporter) Report(r *Report) error {
+	for _, a := range r.Aggregates {
+		if !a.WindowEnd.After(t.lastWindow) {
+			continue
+		}
+		if t.firstWindow.IsZero() {
+			t.firstWindow = a.WindowStart
+		}
+		t.summary.Results += a.NumRes
+		n := float64(a.NumRes)
+		t.p50 += a.LatP50 * n
+		t.p95 += a.LatP95 * n
+		t.p99 += a.LatP99 * n
+		t.lastWindow = a.WindowEnd
+	}
+	if t.summary.Results == 0 {
+		return nil
+	}
+
+	if t.summary.StartedAt == "" {
+		started := r.startedAt
+		if r.Metadata != nil {
+			started = r.Metadata.StartedAt
+			t.summary.Corpus = r.Metadata.Corpus.Name
+			t.summary.CorpusSHA = r.Metadata.Corpus.SHA256
+		}
+		t.summary.StartedAt = formatTime(started)
+	}
+	t.summary.FinishedAt = formatTime(t.lastWindow)
+	t.summary.Errors = 0
+	for _, count := range r.ErrorDist {
+		t.summary.Errors += int64(count)
+	}
+	results := float64(t.summary.Results)
+	t.summary.ErrorRate = float64(t.summary.Errors) / results
+	t.summary.QPS = results / t.lastWindow.Sub(t.firstWindow).Seconds()
+	t.summary.P50, t.summary.P95, t.summary.P99 = t.p50/results, t.p95/results, t.p99/results
+
+	id, err := resultsdb.SaveRunSummary(context.Background(), t.db, t.summary)
+	if err != nil {
+		return err
+	}
+	t.summary.ID = id
+	return nil
+}
+
+func (t *trendsDBReporter) Close() error {
+	return t.db.Close()
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/net/proxy"
+)
+
+// TunnelConfig reaches the target through a jump host, for databases only
+// reachable from a bastion. SSH and SOCKS are exclusive.
+type TunnelConfig struct {
+	// SSH is the bastion as user@host[:port], the port defaults to 22
+	SSH string `mapstructure:"ssh" yaml:"ssh"`
+	// KeyFile is the private key the SSH user authenticates with, the keys
+	// of the running ssh-agent are offered without it. KeyPassphrase
+	// decrypts an encrypted key.
+	KeyFile       string `mapstructure:"key_file" yaml:"key_file"`
+	KeyPassphrase string `mapstructure:"key_passphrase" yaml:"key_passphrase"`
+	// KnownHostsFile verifies the host key of the bastion, ~/.ssh/known_hosts
+	// by default. InsecureIgnoreHostKey accepts any key.
+	KnownHostsFile        string `mapstructure:"known_hosts_file" yaml:"known_hosts_file"`
+	InsecureIgnoreHostKey bool   `mapstructure:"insecure_ignore_host_key" yaml:"insecure_ignore_host_key"`
+	// SOCKS is a SOCKS5 proxy as socks5://[user:password@]host:port
+	SOCKS string `mapstructure:"socks" yaml:"socks"`
+}
+
+// Enabled tells whether the target is reached through a tunnel
+func (c TunnelConfig) Enabled() bool {
+	return c.SSH != "" || c.SOCKS != ""
+}
+
+// tunnelNet is the DSN network the tunnel registers its dialer under
+const tunnelNet = "tcp-tunnel"
+
+const sshDialTimeout = 10 * time.Second
+
+// Tunnel dials the target connections through an SSH bastion or a SOCKS5
+// proxy. All the connections of an SSH tunnel share one SSH connection,
+// which is redialed once broken.
+type Tunnel struct {
+	cfg   TunnelConfig
+	socks proxy.ContextDialer
+
+	// sshAddr and sshConfig dial the bastion
+	sshAddr   string
+	sshConfig *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *ssh.Client
+}
+
+// NewTunnel returns the tunnel of cfg, nil when none is configured
+func NewTunnel(cfg TunnelConfig) (*Tunnel, error) {
+	if !cfg.Enabled() {
+		return nil, nil
+	}
+	if cfg.SSH != "" && cfg.SOCKS != "" {
+		return nil, fmt.Errorf("db_tunnel takes either ssh or socks, not both")
+	}
+	t := &Tunnel{cfg: cfg}
+	if cfg.SOCKS != "" {
+		u, err := url.Parse(cfg.SOCKS)
+		if err != nil || u.Scheme != "socks5" || u.Host == "" {
+			return nil, fmt.Errorf("invalid db_tunnel.socks %q, expected socks5://[user:password@]host:port", redactURL(cfg.SOCKS))
+		}
+		dialer, err := proxy.FromURL(u, &net.Dialer{})
+		if err != nil {
+			return nil, fmt.Errorf("error creating SOCKS dialer: %w", err)
+		}
+		socks, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("the SOCKS dialer does not support contexts")
+		}
+		t.socks = socks
+		return t, nil
+	}
+
+	user, host, ok := strings.Cut(cfg.SSH, "@")
+	if !ok || user == "" || host == "" {
+		return nil, fmt.Errorf("invalid db_tunnel.ssh %q, expected user@host[:port]", cfg.SSH)
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, "22")
+	}
+	auth, err := sshAuth(cfg)
+	if err != nil {
+		return nil, err
+	}
+	hostKeys, err := sshHostKeys(cfg)
+	if err != nil {
+		return nil, err
+	}
+	t.sshAddr = host
+	t.sshConfig = &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{auth},
+		HostKeyCallback: hostKeys,
+	}
+	return t, nil
+}
+
+// sshAuth authenticates with the key file, or the keys of the ssh-agent
+func sshAuth(cfg TunnelConfig) (ssh.AuthMethod, error) {
+	if cfg.KeyFile == "" {
+		sock := os.Getenv("SSH_AUTH_SOCK")
+		if sock == "" {
+			return nil, fmt.Errorf("db_tunnel.ssh needs key_file or a running ssh-agent")
+		}
+		// the agent connection lives as long as the process
+		conn, err := net.Dial("unix", sock)
+		if err != nil {
+			return nil, fmt.Errorf("error connecting to the ssh-agent: %w", err)
+		}
+		return ssh.PublicKeysCallback(agent.NewClient(conn).Signers), nil
+	}
+
+	key, err := os.ReadFile(expandHome(cfg.KeyFile))
+	if err != nil {
+		return nil, fmt.Errorf("error reading SSH key: %w", err)
+	}
+	var signer ssh.Signer
+	if cfg.KeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(cfg.KeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("the SSH key %s is encrypted, set db_tunnel.key_passphrase", cfg.KeyFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error parsing SSH key: %w", err)
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
+// sshHostKeys verifies the bastion against the known hosts
+func sshHostKeys(cfg TunnelConfig) (ssh.HostKeyCallback, error) {
+	if cfg.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	file := cfg.KnownHostsFile
+	if file == "" {
+		file = "~/.ssh/known_hosts"
+	}
+	callback, err := knownhosts.New(expandHome(file))
+	if err != nil {
+		return nil, fmt.Errorf("error reading known hosts, set db_tunnel.known_hosts_file or insecure_ignore_host_key: %w", err)
+	}
+	return callback, nil
+}
+
+// expandHome expands a leading ~/ to the home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+// DSN registers the dialer and returns dsn rewritten to use it
+func (t *Tunnel) DSN(dsn string) (string, error) {
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		return "", fmt.Errorf("error parsing target DSN: %w", err)
+	}
+	if cfg.Net == "unix" {
+		return "", fmt.Errorf("a unix socket DSN can't be reached through db_tunnel")
+	}
+	mysql.RegisterDialContext(tunnelNet, func(ctx context.Context, addr string) (net.Conn, error) {
+		return t.DialContext(ctx, "tcp", addr)
+	})
+	cfg.Net = tunnelNet
+	return cfg.FormatDSN(), nil
+}
+
+// DialContext connects to addr from the far end of the tunnel
+func (t *Tunnel) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if t.socks != nil {
+		return t.socks.DialContext(ctx, network, addr)
+	}
+	client, err := t.sshClient(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.DialContext(ctx, network, addr)
+	var refused *ssh.OpenChannelError
+	if err == nil || ctx.Err() != nil || errors.As(err, &refused) {
+		return conn, err
+	}
+	// the bastion may have dropped the SSH connection, redial it once
+	if client, err = t.sshClient(ctx, client); err != nil {
+		return nil, err
+	}
+	return client.DialContext(ctx, network, addr)
+}
+
+// sshClient returns the SSH connection to the bastion, dialing it when there
+// is none or when it is still broken
+func (t *Tunnel) sshClient(ctx context.Context, broken *ssh.Client) (*ssh.Client, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != nil && t.client != broken {
+		return t.client, nil
+	}
+	if t.client != nil {
+		t.client.Close()
+		t.client = nil
+	}
+
+	d := net.Dialer{Timeout: sshDialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", t.sshAddr)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the SSH bastion %s: %w", t.sshAddr, err)
+	}
+	// bounds the handshake
+	deadline := time.Now().Add(sshDialTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	nc.SetDeadline(deadline)
+	c, chans, reqs, err := ssh.NewClientConn(nc, t.sshAddr, t.sshConfig)
+	if err != nil {
+		nc.Close()
+		return nil, fmt.Errorf("error opening the SSH session to %s: %w", t.sshAddr, err)
+	}
+	nc.SetDeadline(time.Time{})
+	t.client = ssh.NewClient(c, chans, reqs)
+	logger.Info().Str("bastion", t.sshAddr).Msg("Connected to the SSH bastion")
+	return t.client, nil
+}
+
+// Close closes the SSH connection, and with it every tunneled connection
+func (t *Tunnel) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == nil {
+		return nil
+	}
+	err := t.client.Close()
+	t.client = nil
+	return err
+}
+
+// redactURL hides the password of a URL
+func redactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "****"
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type WarmupConfig struct {
+	Enabled bool `mapstructure:"enabled" yaml:"enabled"`
+	// Interval between buffer pool status polls
+	Interval time.Duration `mapstructure:"interval" yaml:"interval" validate:"omitempty,gt=0"`
+	// MissRate is the fraction of buffer pool read requests going to disk
+	// below which an interval counts as warm
+	MissRate float64 `mapstructure:"miss_rate" yaml:"miss_rate" validate:"omitempty,gt=0,lt=1"`
+	// StableIntervals is the number of consecutive warm intervals required
+	StableIntervals int `mapstructure:"stable_intervals" yaml:"stable_intervals" validate:"omitempty,gt=0"`
+	// MaxDuration ends the warm-up even if the miss rate never settles
+	MaxDuration time.Duration `mapstructure:"max_duration" yaml:"max_duration" validate:"omitempty,gt=0"`
+}
+
+// WarmupDetector watches the InnoDB buffer pool miss rate while the load
+// runs and reports warm once it stayed below the threshold for a few
+// intervals. Results are not measured before that.
+type WarmupDetector struct {
+	cfg   WarmupConfig
+	admin *AdminConn
+	warm  atomic.Bool
+}
+
+func NewWarmupDetector(cfg WarmupConfig, admin *AdminConn) *WarmupDetector {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.MissRate <= 0 {
+		cfg.MissRate = 0.001
+	}
+	if cfg.StableIntervals <= 0 {
+		cfg.StableIntervals = 3
+	}
+	if cfg.MaxDuration <= 0 {
+		cfg.MaxDuration = 30 * time.Minute
+	}
+	return &WarmupDetector{cfg: cfg, admin: admin}
+}
+
+func (w *WarmupDetector) Warm() bool {
+	return w.warm.Load()
+}
+
+// Run polls the buffer pool counters until the pool is warm or ctx is done
+func (w *WarmupDetector) Run(ctx context.Context) {
+	start := time.Now()
+	ticker := time.NewTicker(w.cfg.Interval)
+	defer ticker.Stop()
+
+	logger.Info().
+		Float64("miss_rate", w.cfg.MissRate).
+		Int("stable_intervals", w.cfg.StableIntervals).
+		Msg("Warming up, waiting for the buffer pool miss rate to settle")
+
+	var prevRequests, prevReads int64
+	stable := 0
+	for {
+		requests, reads, err := w.poll(ctx)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Error polling buffer pool status")
+		} else if prevRequests > 0 && requests > prevRequests {
+			missRate := float64(reads-prevReads) / float64(requests-prevRequests)
+			if missRate < w.cfg.MissRate {
+				stable++
+			} else {
+				stable = 0
+			}
+			logger.Info().Float64("miss_rate", missRate).Int("stable", stable).Msg("Buffer pool warm-up")
+		}
+		if err == nil {
+			prevRequests, prevReads = requests, reads
+		}
+
+		if stable >= w.cfg.StableIntervals {
+			w.finish(start, "Buffer pool is warm, starting measurement")
+			return
+		}
+		if time.Since(start) >= w.cfg.MaxDuration {
+			w.finish(start, "Buffer pool miss rate did not settle, starting measurement after max warm-up duration")
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *WarmupDetector) poll(ctx context.Context) (requests, reads int64, err error) {
+	pollCtx, cancel := context.WithTimeout(ctx, w.cfg.Interval)
+	defer cancel()
+	status, err := w.admin.ShowGlobalStatus(pollCtx, "Innodb_buffer_pool_read_requests", "Innodb_buffer_pool_reads")
+	if err != nil {
+		return 0, 0, err
+	}
+	if requests, err = strconv.ParseInt(status["Innodb_buffer_pool_read_requests"], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if reads, err = strconv.ParseInt(status["Innodb_buffer_pool_reads"], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	return requests, reads, nil
+}
+
+func (w *WarmupDetector) finish(start time.Time, msg string) {
+	elapsed := time.Since(start).Round(time.Second)
+	logger.Info().Dur("warmup", elapsed).Msg(msg)
+	annotations.Add("load-test", "Warm-up done, measurement started", map[string]string{
+		"warmup": elapsed.String(),
+	})
+	w.warm.Store(true)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+type WarningsConfig struct {
+	SampleRate float64 `mapstructure:"sample_rate" yaml:"sample_rate" validate:"omitempty,gte=0,lte=1"`
+}
+
+// QueryWarning is one row of SHOW WARNINGS. Server-side errors of the
+// statement show up with level "Error".
+type QueryWarning struct {
+	Level   string
+	Code    uint16
+	Message string
+}
+
+// WarningCodeStat counts one warning code, keeping the latest message as an
+// example
+type WarningCodeStat struct {
+	Level   string `json:"level"`
+	Count   int64  `json:"count"`
+	Message string `json:"message"`
+}
+
+// FingerprintWarnings aggregates the warnings of the sampled executions of
+// one fingerprint
+type FingerprintWarnings struct {
+	Sampled int64                       `json:"sampled"`
+	Codes   map[uint16]*WarningCodeStat `json:"codes"`
+}
+
+func (fw *FingerprintWarnings) add(warnings []QueryWarning) {
+	fw.Sampled++
+	for _, w := range warnings {
+		stat, ok := fw.Codes[w.Code]
+		if !ok {
+			stat = &WarningCodeStat{}
+			fw.Codes[w.Code] = stat
+		}
+		stat.Level = w.Level
+		stat.Count++
+		stat.Message = w.Message
+	}
+}
+
+// showWarnings reads the diagnostics of the last statement executed on conn
+func showWarnings(ctx context.Context, conn *sql.Conn) ([]QueryWarning, error) {
+	rows, err := conn.QueryContext(ctx, "SHOW WARNINGS")
+	if err != nil {
+		return nil, fmt.Errorf("error executing show warnings: %w", err)
+	}
+	defer rows.Close()
+
+	var warnings []QueryWarning
+	for rows.Next() {
+		var w QueryWarning
+		if err := rows.Scan(&w.Level, &w.Code, &w.Message); err != nil {
+			return nil, fmt.Errorf("error scanning warning: %w", err)
+		}
+		warnings = append(warnings, w)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating warnings: %w", err)
+	}
+	return warnings, nil
+}
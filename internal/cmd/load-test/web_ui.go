@@ -18,11 +18,19 @@ var webFiles embed.FS
 type WebUI struct {
 	upgrader websocket.Upgrader
 	clients  map[*websocket.Conn]bool
+	history  *History
 	mu       sync.Mutex
 }
 
-func NewWebUI() *WebUI {
+// historyMessage is sent to newly connected clients before live reports
+type historyMessage struct {
+	Type   string         `json:"type"`
+	Points []HistoryPoint `json:"points"`
+}
+
+func NewWebUI(history *History) *WebUI {
 	return &WebUI{
+		history: history,
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Allow all origins for local development
@@ -40,6 +48,12 @@ func (ui *WebUI) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ui.mu.Lock()
+	if ui.history != nil {
+		msg := historyMessage{Type: "history", Points: ui.history.Points()}
+		if err := conn.WriteJSON(msg); err != nil {
+			log.Error().Err(err).Msg("Failed to send history to client")
+		}
+	}
 	ui.clients[conn] = true
 	ui.mu.Unlock()
 
@@ -80,13 +94,6 @@ func sanitizeReport(report *Report) (sanitized *Report, changed bool) {
 			}
 		}
 	}
-	// Sanitize Lats in Report
-	for i, v := range copy.Lats {
-		if math.IsNaN(v) || math.IsInf(v, 0) {
-			copy.Lats[i] = 0
-			changed = true
-		}
-	}
 	return &copy, changed
 }
 
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// weightsFileRecord is a row of a fingerprint weights file. Weights are
+// relative, they don't need to add up to 100.
+type weightsFileRecord struct {
+	Hash   json.Number `json:"hash"`
+	Weight float64     `json:"weight"`
+	Count  int64       `json:"count"`
+}
+
+// loadWeightsFile reads the fingerprint weights of a CSV file with a
+// hash,weight[,count] header, or of a JSON array of {"hash", "weight",
+// "count"} objects when the file ends in .json
+func loadWeightsFile(path string) (*QueryFingerprintWeights, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening weights file: %w", err)
+	}
+	defer f.Close()
+
+	var records []weightsFileRecord
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		records, err = readWeightsJSON(f)
+	} else {
+		records, err = readWeightsCSV(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading weights file %s: %w", path, err)
+	}
+
+	weights := NewQueryFingerprintWeights()
+	seen := make(map[uint64]bool, len(records))
+	for n, r := range records {
+		hash, err := strconv.ParseUint(r.Hash.String(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("weights file %s, record %d: invalid hash %q", path, n+1, r.Hash)
+		}
+		if seen[hash] {
+			return nil, fmt.Errorf("weights file %s, record %d: duplicate hash %d", path, n+1, hash)
+		}
+		seen[hash] = true
+		if r.Weight < 0 || math.IsNaN(r.Weight) || math.IsInf(r.Weight, 0) {
+			return nil, fmt.Errorf("weights file %s, record %d: invalid weight %v", path, n+1, r.Weight)
+		}
+		if r.Count < 0 {
+			return nil, fmt.Errorf("weights file %s, record %d: invalid count %d", path, n+1, r.Count)
+		}
+		weights.Add(r.Weight, &QueryFingerprintData{Hash: hash, FreqTotal: r.Count})
+	}
+	if weights.totalWeight <= 0 {
+		return nil, fmt.Errorf("weights file %s has no positive weight", path)
+	}
+	return weights, nil
+}
+
+func readWeightsCSV(r io.Reader) ([]weightsFileRecord, error) {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.TrimLeadingSpace = true
+
+	header, err := cr.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("missing header")
+		}
+		return nil, err
+	}
+	columns := map[string]int{"hash": -1, "weight": -1, "count": -1}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		pos, ok := columns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q, expected hash, weight and optionally count", name)
+		}
+		if pos >= 0 {
+			return nil, fmt.Errorf("duplicate column %q", name)
+		}
+		columns[name] = i
+	}
+	if columns["hash"] < 0 || columns["weight"] < 0 {
+		return nil, fmt.Errorf("header needs the hash and weight columns")
+	}
+
+	var records []weightsFileRecord
+	for {
+		row, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		record := weightsFileRecord{Hash: json.Number(strings.TrimSpace(row[columns["hash"]]))}
+		if record.Weight, err = strconv.ParseFloat(strings.TrimSpace(row[columns["weight"]]), 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid weight: %w", line, err)
+		}
+		if pos := columns["count"]; pos >= 0 && strings.TrimSpace(row[pos]) != "" {
+			if record.Count, err = strconv.ParseInt(strings.TrimSpace(row[pos]), 10, 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid count: %w", line, err)
+			}
+		}
+		records = append(records, record)
+	}
+}
+
+func readWeightsJSON(r io.Reader) ([]weightsFileRecord, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var records []weightsFileRecord
+	if err := dec.Decode(&records); err != nil {
+		return nil, err
+	}
+	for n, record := range records {
+		if record.Hash == "" {
+			return nil, fmt.Errorf("record %d: missing hash", n+1)
+		}
+	}
+	return records, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+
+	"mysql-load-test/pkg/mysqlwire"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	ConnectionModePooled    = "pooled"
+	ConnectionModePerWorker = "per_worker"
+)
+
+// workerConns holds the connection each worker owns in per_worker connection
+// mode, like thread-per-connection clients such as sysbench. A connection is
+// only used by its worker and replaced once broken.
+type workerConns struct {
+	db  *DBConn
+	sql []*sql.Conn
+	// raw holds a single connection pool per worker, which redials when its
+	// connection broke
+	raw []*mysqlwire.Pool
+}
+
+// newWorkerConns creates the connections of n workers lazily, rawCfg is nil
+// unless the raw engine is used
+func newWorkerConns(n int, db *DBConn, rawCfg *mysqlwire.Config) *workerConns {
+	w := &workerConns{db: db, sql: make([]*sql.Conn, n)}
+	if rawCfg != nil {
+		w.raw = make([]*mysqlwire.Pool, n)
+		for i := range w.raw {
+			w.raw[i] = mysqlwire.NewPool(*rawCfg, 1)
+		}
+	}
+	return w
+}
+
+// Conn returns the connection of the worker, connecting if needed
+func (w *workerConns) Conn(ctx context.Context, workerID int) (*sql.Conn, error) {
+	if w.sql[workerID] == nil {
+		conn, err := w.db.Conn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		w.sql[workerID] = conn
+	}
+	return w.sql[workerID], nil
+}
+
+// Discard closes the connection of the worker, the next Conn reconnects
+func (w *workerConns) Discard(workerID int) {
+	if conn := w.sql[workerID]; conn != nil {
+		conn.Raw(func(any) error { return driver.ErrBadConn })
+		conn.Close()
+		w.sql[workerID] = nil
+	}
+}
+
+// Reset closes the connections of the worker, so its next query starts a
+// fresh session
+func (w *workerConns) Reset(workerID int) {
+	w.Discard(workerID)
+	if w.raw != nil {
+		w.raw[workerID].CloseIdle()
+	}
+}
+
+func (w *workerConns) Raw(workerID int) *mysqlwire.Pool {
+	return w.raw[workerID]
+}
+
+// Close closes the connections, it must only be called once the workers
+// stopped
+func (w *workerConns) Close() {
+	for i := range w.sql {
+		w.Discard(i)
+	}
+	for _, p := range w.raw {
+		p.Close()
+	}
+}
+
+// isBadConn reports whether err left the connection unusable
+func isBadConn(err error) bool {
+	return errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.Is(err, mysql.ErrInvalidConn)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// workerCounters are the cumulative counters of one querier goroutine
+type workerCounters struct {
+	executed atomic.Int64
+	busy     atomic.Int64
+	// wait is the time spent waiting for a pacer token
+	wait atomic.Int64
+}
+
+type WorkerStats struct {
+	Executed int64
+	Busy     time.Duration
+	Wait     time.Duration
+}
+
+func (s WorkerStats) sub(prev WorkerStats) WorkerStats {
+	return WorkerStats{
+		Executed: s.Executed - prev.Executed,
+		Busy:     s.Busy - prev.Busy,
+		Wait:     s.Wait - prev.Wait,
+	}
+}
+
+// starvedWorkerRatio is the fraction of the mean executions below which a
+// worker counts as starved
+const starvedWorkerRatio = 0.25
+
+// workerFairness computes Jain's fairness index of the executions per
+// worker, 1 when evenly spread and 1/n when one worker did everything, the
+// number of starved workers and the share of worker time spent waiting for
+// the pacer. Workers held back by the concurrency advisor neither executed
+// nor waited and are left out.
+func workerFairness(deltas []WorkerStats) (fairness float64, starved int, waitShare float64) {
+	var n int
+	var sum, sumSq float64
+	var busy, wait time.Duration
+	for _, d := range deltas {
+		if d.Executed == 0 && d.Wait == 0 {
+			continue
+		}
+		n++
+		x := float64(d.Executed)
+		sum += x
+		sumSq += x * x
+		busy += d.Busy
+		wait += d.Wait
+	}
+	if n == 0 || sumSq == 0 {
+		return 0, 0, 0
+	}
+	fairness = sum * sum / (float64(n) * sumSq)
+
+	mean := sum / float64(n)
+	for _, d := range deltas {
+		if (d.Executed > 0 || d.Wait > 0) && float64(d.Executed) < mean*starvedWorkerRatio {
+			starved++
+		}
+	}
+	if busy+wait > 0 {
+		waitShare = float64(wait) / float64(busy+wait)
+	}
+	return fairness, starved, waitShare
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WorkloadConfig is a workload run at the same time as the others of the
+// config, e.g. the OLTP mix of one application against the primary and the
+// analytics of another against a replica. The settings left out are taken
+// from the top level of the config.
+type WorkloadConfig struct {
+	Name              string                 `mapstructure:"name" yaml:"name" validate:"required"`
+	DBDSN             string                 `mapstructure:"db_dsn" yaml:"db_dsn" validate:"omitempty"`
+	QueriesDataSource *QueryDataSourceConfig `mapstructure:"queries_data_source" yaml:"queries_data_source"`
+	Concurrency       int                    `mapstructure:"concurrency" yaml:"concurrency" validate:"omitempty,gte=0"`
+	QPS               int                    `mapstructure:"qps" yaml:"qps" validate:"omitempty,gte=0"`
+	RunMode           string                 `mapstructure:"run_mode" yaml:"run_mode" validate:"omitempty,oneof=sequential random session replay"`
+	Count             int                    `mapstructure:"count" yaml:"count" validate:"omitempty"`
+	Duration          time.Duration          `mapstructure:"duration" yaml:"duration" validate:"omitempty,gte=0"`
+	// Reporters replace the top-level ones, which otherwise write to files
+	// named after the workload, report.oltp.json for report.json
+	Reporters *ReportersConfig `mapstructure:"reporters" yaml:"reporters"`
+}
+
+// workloadConfigs returns the config of every workload, the top-level
+// settings with those of the workload applied
+func (c Config) workloadConfigs() ([]Config, error) {
+	if len(c.Hooks.PreRun) > 0 || len(c.Hooks.PostRun) > 0 || len(c.Hooks.Snapshot.Tables) > 0 || c.Consistency.Enabled {
+		return nil, fmt.Errorf("hooks and consistency checks can't be combined with workloads, the other workloads would run during them")
+	}
+	configs := make([]Config, len(c.Workloads))
+	seen := make(map[string]bool, len(c.Workloads))
+	for i, w := range c.Workloads {
+		if seen[w.Name] {
+			return nil, fmt.Errorf("duplicate workload name %q", w.Name)
+		}
+		seen[w.Name] = true
+		configs[i] = w.apply(c, i > 0)
+	}
+	return configs, nil
+}
+
+// apply returns base with the settings of the workload. A secondary workload
+// leaves the process-wide metrics server, control API and maintenance
+// windows to the first one.
+func (w WorkloadConfig) apply(base Config, secondary bool) Config {
+	cfg := base
+	cfg.Workloads = nil
+	cfg.workload = w.Name
+	cfg.secondary = secondary
+	if w.DBDSN != "" {
+		cfg.DBDSN = w.DBDSN
+	}
+	if w.QueriesDataSource != nil {
+		cfg.QueriesDataSource = w.QueriesDataSource
+	}
+	if w.Concurrency > 0 {
+		cfg.Concurrency = w.Concurrency
+	}
+	if w.QPS > 0 {
+		cfg.QPS = w.QPS
+	}
+	if w.RunMode != "" {
+		cfg.RunMode = w.RunMode
+	}
+	if w.Count != 0 {
+		cfg.Count = w.Count
+	}
+	if w.Duration > 0 {
+		cfg.Duration = w.Duration
+	}
+	if w.Reporters != nil {
+		cfg.Reporters = *w.Reporters
+	} else {
+		cfg.Reporters.JSONFile = workloadPath(cfg.Reporters.JSONFile, w.Name)
+		cfg.Reporters.AggregatesCSV = workloadPath(cfg.Reporters.AggregatesCSV, w.Name)
+		cfg.Reporters.ResultsDB = workloadPath(cfg.Reporters.ResultsDB, w.Name)
+	}
+	if cfg.Reporters.TrendsTarget == "" && w.DBDSN != "" {
+		cfg.Reporters.TrendsTarget = trendsTarget(w.DBDSN)
+	}
+	cfg.Reporting.OutFile = workloadPath(cfg.Reporting.OutFile, w.Name)
+	cfg.ExecutionLog.File = workloadPath(cfg.ExecutionLog.File, w.Name)
+	cfg.SlowLog.File = workloadPath(cfg.SlowLog.File, w.Name)
+	if secondary {
+		cfg.Metrics.Enabled = false
+		cfg.Maintenance.Windows = nil
+	}
+	return cfg
+}
+
+// workloadPath names a file after the workload, before its extension.
+// Standard streams are shared.
+func workloadPath(path, workload string) string {
+	if path == "" || strings.HasPrefix(path, "/dev/") {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + workload + ext
+}
+
+// runWorkloads runs the workloads of config side by side, each with its own
+// data source, connections and report. A failed workload doesn't stop the
+// others.
+func runWorkloads(config Config) error {
+	configs, err := config.workloadConfigs()
+	if err != nil {
+		return err
+	}
+	for _, cfg := range configs {
+		if err := validate.Struct(cfg); err != nil {
+			return fmt.Errorf("workload %s: config validation failed: %w", cfg.workload, err)
+		}
+	}
+
+	errs := make([]error, len(configs))
+	var wg sync.WaitGroup
+	for i, cfg := range configs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			logger.Info().Str("workload", cfg.workload).Int("concurrency", cfg.Concurrency).Str("run_mode", cfg.RunMode).Int("qps", cfg.QPS).Msg("Starting workload")
+			if err := performLoadTest(cfg); err != nil {
+				logger.Error().Err(err).Str("workload", cfg.workload).Msg("Workload failed")
+				errs[i] = fmt.Errorf("workload %s: %w", cfg.workload, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
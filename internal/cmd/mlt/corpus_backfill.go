@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"mysql-load-test/pkg/filemap"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/spf13/cobra"
+)
+
+type corpusBackfillOptions struct {
+	dsn       string
+	queries   string
+	maxLength int
+	compress  bool
+	batchSize int
+	overwrite bool
+}
+
+func newCorpusBackfillCmd() *cobra.Command {
+	opts := corpusBackfillOptions{}
+	cmd := &cobra.Command{
+		Use:   "backfill-text",
+		Short: "Copy the raw query text from the queries file into the metadata DB",
+		Long: `Walks the Query rows of the metadata database, reads the query each one
+points to in the queries file and stores it in the QueryText column, so the
+corpus can be browsed with SQL. Compressed values are in the format of MySQL's
+COMPRESS(), read them with UNCOMPRESS(QueryText).`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCorpusBackfill(cmd.Context(), cmd.OutOrStdout(), opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.dsn, "dsn", "", "DSN of the metadata database, e.g. root:root@tcp(127.0.0.1:13306)/MySQLLoadTester")
+	cmd.Flags().StringVar(&opts.queries, "queries", "", "Queries file the Query rows point into")
+	cmd.Flags().IntVar(&opts.maxLength, "max-length", 0, "Truncate the text to this many bytes, 0 keeps it whole")
+	cmd.Flags().BoolVar(&opts.compress, "compress", false, "Store the text compressed")
+	cmd.Flags().IntVar(&opts.batchSize, "batch-size", 1000, "Number of rows updated per transaction")
+	cmd.Flags().BoolVar(&opts.overwrite, "overwrite", false, "Also rewrite rows that already have a text")
+	cmd.MarkFlagRequired("dsn")
+	cmd.MarkFlagRequired("queries")
+	return cmd
+}
+
+type backfillRow struct {
+	id             uint64
+	offset, length int64
+}
+
+func runCorpusBackfill(ctx context.Context, w io.Writer, opts corpusBackfillOptions) error {
+	if opts.batchSize <= 0 {
+		return fmt.Errorf("--batch-size must be greater than 0")
+	}
+	queries, err := filemap.Open(opts.queries)
+	if err != nil {
+		return fmt.Errorf("error opening queries file %s: %w", opts.queries, err)
+	}
+	defer queries.Close()
+
+	db, err := sql.Open("mysql", opts.dsn)
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+
+	selectQuery := "SELECT ID, `Offset`, `Length` FROM Query WHERE ID > ? AND QueryText IS NULL ORDER BY ID LIMIT ?"
+	if opts.overwrite {
+		selectQuery = "SELECT ID, `Offset`, `Length` FROM Query WHERE ID > ? ORDER BY ID LIMIT ?"
+	}
+
+	var lastID uint64
+	var rows, stored int64
+	for {
+		batch, err := selectBackfillRows(ctx, db, selectQuery, lastID, opts.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		n, err := backfillBatch(ctx, db, queries, batch, opts)
+		if err != nil {
+			return err
+		}
+		rows += int64(len(batch))
+		stored += n
+		lastID = batch[len(batch)-1].id
+		fmt.Fprintf(w, "\rbackfilled %d queries", rows)
+	}
+	fmt.Fprintf(w, "\rbackfilled %d queries, %d bytes stored\n", rows, stored)
+	return nil
+}
+
+func selectBackfillRows(ctx context.Context, db *sql.DB, query string, afterID uint64, limit int) ([]backfillRow, error) {
+	rows, err := db.QueryContext(ctx, query, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error selecting queries: %w", err)
+	}
+	defer rows.Close()
+
+	var batch []backfillRow
+	for rows.Next() {
+		var r backfillRow
+		if err := rows.Scan(&r.id, &r.offset, &r.length); err != nil {
+			return nil, fmt.Errorf("error scanning query: %w", err)
+		}
+		batch = append(batch, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error selecting queries: %w", err)
+	}
+	return batch, nil
+}
+
+// backfillBatch stores the text of the rows in one transaction and returns
+// the number of bytes stored
+func backfillBatch(ctx context.Context, db *sql.DB, queries *filemap.File, batch []backfillRow, opts corpusBackfillOptions) (int64, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "UPDATE Query SET QueryText = ? WHERE ID = ?")
+	if err != nil {
+		return 0, fmt.Errorf("error preparing update: %w", err)
+	}
+	defer stmt.Close()
+
+	var stored int64
+	for _, r := range batch {
+		line, err := queries.Segment(r.offset, r.length)
+		if err != nil {
+			return 0, fmt.Errorf("error reading query %d: %w", r.id, err)
+		}
+		text := truncateText(queryText(line), opts.maxLength)
+		if opts.compress {
+			if text, err = mysqlCompress(text); err != nil {
+				return 0, fmt.Errorf("error compressing query %d: %w", r.id, err)
+			}
+		}
+		if _, err := stmt.ExecContext(ctx, text, r.id); err != nil {
+			return 0, fmt.Errorf("error updating query %d: %w", r.id, err)
+		}
+		stored += int64(len(text))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return stored, nil
+}
+
+// queryText strips the capture timestamp of a queries file line
+func queryText(line []byte) []byte {
+	if _, q, ok := bytes.Cut(line, []byte("\t")); ok {
+		line = q
+	}
+	return bytes.TrimSpace(line)
+}
+
+// truncateText cuts text to at most maxLength bytes without splitting a UTF-8
+// sequence, 0 keeps it whole
+func truncateText(text []byte, maxLength int) []byte {
+	if maxLength <= 0 || len(text) <= maxLength {
+		return text
+	}
+	text = text[:maxLength]
+	for len(text) > 0 {
+		if r, size := utf8.DecodeLastRune(text); r != utf8.RuneError || size > 1 {
+			break
+		}
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+// mysqlCompress compresses like COMPRESS(): the uncompressed length as a
+// little endian uint32 followed by a zlib stream, empty stays empty
+func mysqlCompress(text []byte) ([]byte, error) {
+	if len(text) == 0 {
+		return text, nil
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, uint32(len(text)))
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(text); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
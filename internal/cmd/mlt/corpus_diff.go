@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"mysql-load-test/pkg/filemap"
+	"mysql-load-test/pkg/query"
+
+	"github.com/spf13/cobra"
+)
+
+type corpusDiffOptions struct {
+	queriesA, queriesB string
+	format             string
+	export             string
+	top                int
+}
+
+func newCorpusDiffCmd() *cobra.Command {
+	opts := corpusDiffOptions{}
+	cmd := &cobra.Command{
+		Use:   "diff <cache-a> <cache-b>",
+		Short: "Compare the fingerprints and weights of two collected corpora",
+		Long: `Compares two query caches written by the collector: fingerprints that
+appeared or disappeared, weight shifts of the common ones and queries only
+present in one of them.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCorpusDiff(cmd.OutOrStdout(), args[0], args[1], opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.queriesA, "queries-a", "", "Queries file of corpus a, to show example queries")
+	cmd.Flags().StringVar(&opts.queriesB, "queries-b", "", "Queries file of corpus b, to show example queries")
+	cmd.Flags().StringVar(&opts.format, "format", "human", "Output format: human or json")
+	cmd.Flags().StringVar(&opts.export, "export", "", "Also write the full delta as JSON to this file")
+	cmd.Flags().IntVar(&opts.top, "top", 20, "Number of fingerprints shown per section in human output")
+	return cmd
+}
+
+// corpusStats is the content of one corpus cache
+type corpusStats struct {
+	total        int64
+	fingerprints map[uint64]*fingerprintStats
+	queries      map[uint64]struct{}
+}
+
+type fingerprintStats struct {
+	count int64
+	// first query of the fingerprint, to show an example
+	offset, length uint64
+}
+
+func readCorpus(path string) (*corpusStats, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening corpus %s: %w", path, err)
+	}
+	defer file.Close()
+
+	stats := &corpusStats{
+		fingerprints: make(map[uint64]*fingerprintStats),
+		queries:      make(map[uint64]struct{}),
+	}
+	r, err := query.NewCacheReader(bufio.NewReaderSize(file, 1024*1024))
+	if err != nil {
+		return nil, fmt.Errorf("error reading corpus %s: %w", path, err)
+	}
+	var q query.Query
+	for {
+		if err := r.Read(&q); err != nil {
+			if err == io.EOF {
+				return stats, nil
+			}
+			return nil, fmt.Errorf("error reading corpus %s: %w", path, err)
+		}
+		stats.total++
+		stats.queries[q.Hash] = struct{}{}
+		fp, ok := stats.fingerprints[q.FingerprintHash]
+		if !ok {
+			fp = &fingerprintStats{offset: q.Offset, length: q.Length}
+			stats.fingerprints[q.FingerprintHash] = fp
+		}
+		fp.count++
+	}
+}
+
+func (s *corpusStats) weight(hash uint64) float64 {
+	fp, ok := s.fingerprints[hash]
+	if !ok || s.total == 0 {
+		return 0
+	}
+	return float64(fp.count) / float64(s.total) * 100
+}
+
+const (
+	fingerprintNew       = "new"
+	fingerprintGone      = "gone"
+	fingerprintCommon    = "common"
+	weightShiftPrecision = 1e-9
+)
+
+// FingerprintDelta is the change of one fingerprint between the corpora.
+// Weights are percentages of all queries of the corpus.
+type FingerprintDelta struct {
+	Hash     uint64  `json:"hash"`
+	Status   string  `json:"status"`
+	CountA   int64   `json:"count_a"`
+	CountB   int64   `json:"count_b"`
+	WeightA  float64 `json:"weight_a"`
+	WeightB  float64 `json:"weight_b"`
+	Shift    float64 `json:"shift"`
+	ExampleA string  `json:"example_a,omitempty"`
+	ExampleB string  `json:"example_b,omitempty"`
+}
+
+type CorpusDiff struct {
+	TotalA        int64              `json:"total_a"`
+	TotalB        int64              `json:"total_b"`
+	FingerprintsA int                `json:"fingerprints_a"`
+	FingerprintsB int                `json:"fingerprints_b"`
+	QueriesA      int                `json:"unique_queries_a"`
+	QueriesB      int                `json:"unique_queries_b"`
+	NewQueries    int                `json:"new_queries"`
+	GoneQueries   int                `json:"gone_queries"`
+	Fingerprints  []FingerprintDelta `json:"fingerprints"`
+}
+
+func diffCorpora(a, b *corpusStats) *CorpusDiff {
+	diff := &CorpusDiff{
+		TotalA:        a.total,
+		TotalB:        b.total,
+		FingerprintsA: len(a.fingerprints),
+		FingerprintsB: len(b.fingerprints),
+		QueriesA:      len(a.queries),
+		QueriesB:      len(b.queries),
+	}
+	for hash := range b.queries {
+		if _, ok := a.queries[hash]; !ok {
+			diff.NewQueries++
+		}
+	}
+	for hash := range a.queries {
+		if _, ok := b.queries[hash]; !ok {
+			diff.GoneQueries++
+		}
+	}
+
+	hashes := make(map[uint64]struct{}, len(a.fingerprints))
+	for hash := range a.fingerprints {
+		hashes[hash] = struct{}{}
+	}
+	for hash := range b.fingerprints {
+		hashes[hash] = struct{}{}
+	}
+	for hash := range hashes {
+		d := FingerprintDelta{
+			Hash:    hash,
+			Status:  fingerprintCommon,
+			WeightA: a.weight(hash),
+			WeightB: b.weight(hash),
+		}
+		fpA, inA := a.fingerprints[hash]
+		fpB, inB := b.fingerprints[hash]
+		if inA {
+			d.CountA = fpA.count
+		} else {
+			d.Status = fingerprintNew
+		}
+		if inB {
+			d.CountB = fpB.count
+		} else {
+			d.Status = fingerprintGone
+		}
+		d.Shift = d.WeightB - d.WeightA
+		diff.Fingerprints = append(diff.Fingerprints, d)
+	}
+
+	sort.Slice(diff.Fingerprints, func(i, j int) bool {
+		si, sj := math.Abs(diff.Fingerprints[i].Shift), math.Abs(diff.Fingerprints[j].Shift)
+		if si != sj {
+			return si > sj
+		}
+		return diff.Fingerprints[i].Hash < diff.Fingerprints[j].Hash
+	})
+	return diff
+}
+
+// addExamples fills the example queries of the fingerprints from the
+// queries file of the corpus
+func addExamples(diff *CorpusDiff, stats *corpusStats, path string, setExample func(*FingerprintDelta, string)) error {
+	if path == "" {
+		return nil
+	}
+	file, err := filemap.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening queries file %s: %w", path, err)
+	}
+	defer file.Close()
+
+	for i := range diff.Fingerprints {
+		d := &diff.Fingerprints[i]
+		fp, ok := stats.fingerprints[d.Hash]
+		if !ok {
+			continue
+		}
+		line, err := file.Segment(int64(fp.offset), int64(fp.length))
+		if err != nil {
+			return fmt.Errorf("error reading example of fingerprint %d: %w", d.Hash, err)
+		}
+		if _, q, ok := bytes.Cut(line, []byte("\t")); ok {
+			line = q
+		}
+		setExample(d, string(bytes.TrimSpace(line)))
+	}
+	return nil
+}
+
+func runCorpusDiff(w io.Writer, pathA, pathB string, opts corpusDiffOptions) error {
+	a, err := readCorpus(pathA)
+	if err != nil {
+		return err
+	}
+	b, err := readCorpus(pathB)
+	if err != nil {
+		return err
+	}
+
+	diff := diffCorpora(a, b)
+	if err := addExamples(diff, a, opts.queriesA, func(d *FingerprintDelta, q string) { d.ExampleA = q }); err != nil {
+		return err
+	}
+	if err := addExamples(diff, b, opts.queriesB, func(d *FingerprintDelta, q string) { d.ExampleB = q }); err != nil {
+		return err
+	}
+
+	if opts.export != "" {
+		if err := exportCorpusDiff(opts.export, diff); err != nil {
+			return err
+		}
+	}
+
+	switch opts.format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	case "human":
+		printCorpusDiff(w, diff, opts.top)
+		return nil
+	default:
+		return fmt.Errorf("unsupported format: %s", opts.format)
+	}
+}
+
+func exportCorpusDiff(path string, diff *CorpusDiff) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("error creating export file: %w", err)
+	}
+	if err := json.NewEncoder(file).Encode(diff); err != nil {
+		file.Close()
+		return fmt.Errorf("error writing export file: %w", err)
+	}
+	return file.Close()
+}
+
+func printCorpusDiff(w io.Writer, diff *CorpusDiff, top int) {
+	fmt.Fprintf(w, "Queries:       %d -> %d\n", diff.TotalA, diff.TotalB)
+	fmt.Fprintf(w, "Unique:        %d -> %d (%d new, %d gone)\n", diff.QueriesA, diff.QueriesB, diff.NewQueries, diff.GoneQueries)
+	fmt.Fprintf(w, "Fingerprints:  %d -> %d\n\n", diff.FingerprintsA, diff.FingerprintsB)
+
+	sections := []struct {
+		title  string
+		status string
+	}{
+		{"New fingerprints", fingerprintNew},
+		{"Disappeared fingerprints", fingerprintGone},
+		{"Largest weight shifts", fingerprintCommon},
+	}
+	for _, section := range sections {
+		var rows []FingerprintDelta
+		for _, d := range diff.Fingerprints {
+			if d.Status != section.status || math.Abs(d.Shift) < weightShiftPrecision {
+				continue
+			}
+			rows = append(rows, d)
+			if len(rows) == top {
+				break
+			}
+		}
+		if len(rows) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(w, "%s:\n", section.title)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+		fmt.Fprintln(tw, "fingerprint\tcount a\tcount b\tweight a\tweight b\tshift\t")
+		for _, d := range rows {
+			fmt.Fprintf(tw, "%d\t%d\t%d\t%.3f%%\t%.3f%%\t%+.3f%%\t\n", d.Hash, d.CountA, d.CountB, d.WeightA, d.WeightB, d.Shift)
+		}
+		tw.Flush()
+		for _, d := range rows {
+			if example := firstNonEmpty(d.ExampleB, d.ExampleA); example != "" {
+				fmt.Fprintf(w, "  %d: %s\n", d.Hash, truncateQuery(example, 100))
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// firstNonEmpty returns the first non-empty string
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func truncateQuery(q string, maxLen int) string {
+	q = strings.Join(strings.Fields(q), " ")
+	if len(q) <= maxLen {
+		return q
+	}
+	return q[:maxLen-3] + "..."
+}
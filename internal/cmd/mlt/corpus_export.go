@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+
+	"mysql-load-test/pkg/filemap"
+	"mysql-load-test/pkg/query"
+
+	"github.com/spf13/cobra"
+)
+
+const (
+	exportFormatSysbench  = "sysbench"
+	exportFormatMysqlslap = "mysqlslap"
+)
+
+type corpusExportOptions struct {
+	output     string
+	queries    string
+	format     string
+	maxQueries int64
+	delimiter  string
+}
+
+func newCorpusExportCmd() *cobra.Command {
+	opts := corpusExportOptions{}
+	cmd := &cobra.Command{
+		Use:   "export <cache>",
+		Short: "Convert a corpus into a sysbench script or a mysqlslap query file",
+		Long: `Converts a corpus for teams standardized on other load generators.
+
+sysbench writes a Lua script whose event picks a fingerprint by its weight in
+the corpus and runs one of its queries:
+
+  sysbench corpus.lua --mysql-host=db --mysql-db=app --threads=16 --time=300 --mysql-ignore-errors=all run
+
+mysqlslap writes the queries in capture order, separated by --delimiter:
+
+  mysqlslap --host=db --create-schema=app --query=corpus.sql --delimiter=";" --concurrency=16
+
+--max-queries downsamples every fingerprint evenly like corpus trim, so the
+weights hold in smaller files.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCorpusExport(cmd.OutOrStdout(), args[0], opts)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "Output file")
+	cmd.Flags().StringVar(&opts.queries, "queries", "", "Queries file of the corpus")
+	cmd.Flags().StringVar(&opts.format, "format", "", "Output format: sysbench or mysqlslap")
+	cmd.Flags().Int64Var(&opts.maxQueries, "max-queries", 0, "Downsample every fingerprint evenly to about this many queries in total, 0 keeps all")
+	cmd.Flags().StringVar(&opts.delimiter, "delimiter", ";", "Statement delimiter of the mysqlslap format, queries containing it are skipped")
+	cmd.MarkFlagRequired("output")
+	cmd.MarkFlagRequired("queries")
+	cmd.MarkFlagRequired("format")
+	return cmd
+}
+
+// exportedFingerprint collects the queries of a fingerprint for the
+// sysbench script, weighted as in the whole corpus
+type exportedFingerprint struct {
+	weight  float64
+	queries [][]byte
+}
+
+func runCorpusExport(w io.Writer, path string, opts corpusExportOptions) error {
+	if opts.format != exportFormatSysbench && opts.format != exportFormatMysqlslap {
+		return fmt.Errorf("unsupported format %q, expected %s or %s", opts.format, exportFormatSysbench, exportFormatMysqlslap)
+	}
+	if opts.format == exportFormatMysqlslap && opts.delimiter == "" {
+		return fmt.Errorf("--delimiter must not be empty")
+	}
+
+	stats, err := readCorpus(path)
+	if err != nil {
+		return err
+	}
+	selected, err := selectFingerprints(stats, nil, corpusTrimOptions{maxQueries: opts.maxQueries})
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("corpus %s has no queries", path)
+	}
+	keep := make(map[uint64]int64, len(selected))
+	fingerprints := make(map[uint64]*exportedFingerprint, len(selected))
+	for _, fp := range selected {
+		keep[fp.hash] = fp.keep
+		fingerprints[fp.hash] = &exportedFingerprint{weight: fp.weight}
+	}
+
+	queries, err := filemap.Open(opts.queries)
+	if err != nil {
+		return fmt.Errorf("error opening queries file %s: %w", opts.queries, err)
+	}
+	defer queries.Close()
+
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening corpus %s: %w", path, err)
+	}
+	defer in.Close()
+	reader, err := query.NewCacheReader(bufio.NewReaderSize(in, 1024*1024))
+	if err != nil {
+		return fmt.Errorf("error reading corpus %s: %w", path, err)
+	}
+
+	out, err := os.Create(opts.output)
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	defer out.Close()
+	writer := bufio.NewWriterSize(out, 1024*1024)
+
+	var written, skipped int64
+	var q query.Query
+	for {
+		if err := reader.Read(&q); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return fmt.Errorf("error reading corpus %s: %w", path, err)
+		}
+		if keep[q.FingerprintHash] <= 0 {
+			continue
+		}
+		keep[q.FingerprintHash]--
+
+		line, err := queries.Segment(int64(q.Offset), int64(q.Length))
+		if err != nil {
+			return fmt.Errorf("error reading query %d: %w", q.Hash, err)
+		}
+		text := bytes.TrimSpace(queryText(line))
+		text = bytes.TrimSpace(bytes.TrimSuffix(text, []byte(";")))
+		if len(text) == 0 {
+			skipped++
+			continue
+		}
+
+		switch opts.format {
+		case exportFormatMysqlslap:
+			// mysqlslap splits the file on the delimiter without parsing it
+			if bytes.Contains(text, []byte(opts.delimiter)) {
+				skipped++
+				continue
+			}
+			writer.Write(text)
+			writer.WriteString(opts.delimiter + "\n")
+		case exportFormatSysbench:
+			fp := fingerprints[q.FingerprintHash]
+			fp.queries = append(fp.queries, bytes.Clone(text))
+		}
+		written++
+	}
+
+	if opts.format == exportFormatSysbench {
+		writeSysbenchScript(writer, path, selected, fingerprints)
+	}
+	if err := writer.Flush(); err != nil {
+		return fmt.Errorf("error writing output file: %w", err)
+	}
+
+	fmt.Fprintf(w, "Exported %d queries of %d fingerprints to %s (%s)\n", written, len(selected), opts.output, opts.format)
+	if skipped > 0 {
+		fmt.Fprintf(w, "Skipped %d queries that are empty or contain the delimiter\n", skipped)
+	}
+	return nil
+}
+
+// writeSysbenchScript writes a sysbench Lua script running the queries of
+// each fingerprint with its weight in the corpus
+func writeSysbenchScript(w *bufio.Writer, path string, selected []*trimmedFingerprint, fingerprints map[uint64]*exportedFingerprint) {
+	fmt.Fprintf(w, "#!/usr/bin/env sysbench\n")
+	fmt.Fprintf(w, "-- Generated by mlt corpus export from %s.\n", path)
+	fmt.Fprintf(w, "-- Every event runs one query of a fingerprint picked by its weight.\n\n")
+
+	w.WriteString("local fingerprints = {\n")
+	for _, fp := range selected {
+		exported := fingerprints[fp.hash]
+		if len(exported.queries) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "  { hash = \"%d\", weight = %g, queries = {\n", fp.hash, exported.weight)
+		for _, text := range exported.queries {
+			w.WriteString("    " + luaLongString(text) + ",\n")
+		}
+		w.WriteString("  } },\n")
+	}
+	w.WriteString("}\n\n")
+
+	w.WriteString(`local cumulative, total = {}, 0
+for i, fp in ipairs(fingerprints) do
+  total = total + fp.weight
+  cumulative[i] = total
+end
+
+function thread_init()
+  drv = sysbench.sql.driver()
+  con = drv:connect()
+end
+
+function thread_done()
+  con:disconnect()
+end
+
+function event()
+  local r = sysbench.rand.uniform_double() * total
+  local lo, hi = 1, #cumulative
+  while lo < hi do
+    local mid = math.floor((lo + hi) / 2)
+    if cumulative[mid] < r then lo = mid + 1 else hi = mid end
+  end
+  local queries = fingerprints[lo].queries
+  con:query(queries[sysbench.rand.uniform(1, #queries)])
+end
+`)
+}
+
+// luaLongString quotes s as a Lua long string, with a level of = signs its
+// closing bracket doesn't occur at
+func luaLongString(s []byte) string {
+	level := ""
+	for bytes.Contains(s, []byte("]"+level+"]")) {
+		level += "="
+	}
+	// a leading newline of a long string is dropped, keep it
+	prefix := ""
+	if len(s) > 0 && s[0] == '\n' {
+		prefix = "\n"
+	}
+	return "[" + level + "[" + prefix + string(s) + "]" + level + "]"
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"mysql-load-test/pkg/filemap"
+	"mysql-load-test/pkg/inventory"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/spf13/cobra"
+)
+
+const (
+	objectFound   = "found"
+	objectMissing = "missing"
+)
+
+type corpusObjectsOptions struct {
+	queries string
+	dsn     string
+	format  string
+	ddl     string
+}
+
+func newCorpusObjectsCmd() *cobra.Command {
+	opts := corpusObjectsOptions{}
+	cmd := &cobra.Command{
+		Use:   "objects <cache>",
+		Short: "List the tables and columns the corpus references and check them on a target",
+		Long: `Lists every table and column referenced by the example query of each
+fingerprint, with the number of fingerprints and queries referencing them.
+Columns are attributed to the table they are qualified with, or to the only
+table of their statement; the others are listed apart with the tables they may
+belong to.
+
+With --dsn the objects are looked up in information_schema of the target,
+unqualified tables in the database of the DSN, and the command fails when any
+is missing, so a run is not started only to fail with ER_NO_SUCH_TABLE or
+ER_BAD_FIELD_ERROR.
+
+--ddl writes CREATE TABLE stubs of the referenced tables, to stand up a
+syntactically valid schema for smoke replays when the real one isn't at hand.
+Column types are guessed from the literals the columns are compared with or
+assigned, else from names ending in _id or _at, else VARCHAR(255). Every
+column is nullable. An
+unqualified column of several tables goes to the first of them.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCorpusObjects(cmd.Context(), cmd.OutOrStdout(), args[0], opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.queries, "queries", "", "Queries file of the corpus")
+	cmd.Flags().StringVar(&opts.dsn, "dsn", "", "DSN of the target to check the objects on, e.g. root:root@tcp(127.0.0.1:3306)/app")
+	cmd.Flags().StringVar(&opts.format, "format", "human", "Output format: human or json")
+	cmd.Flags().StringVar(&opts.ddl, "ddl", "", "Also write CREATE TABLE stubs of the referenced tables to this file")
+	cmd.MarkFlagRequired("queries")
+	return cmd
+}
+
+// ObjectUsage counts the fingerprints, and their queries, referencing an
+// object. Status is found or missing once checked on a target.
+type ObjectUsage struct {
+	Fingerprints int    `json:"fingerprints"`
+	Queries      int64  `json:"queries"`
+	Status       string `json:"status,omitempty"`
+}
+
+// ColumnInventory is a column of a table. Kind is guessed from the literals
+// it is compared with or assigned.
+type ColumnInventory struct {
+	Name string         `json:"name"`
+	Kind inventory.Kind `json:"kind,omitempty"`
+	ObjectUsage
+}
+
+type TableInventory struct {
+	inventory.Table
+	ObjectUsage
+	Columns []ColumnInventory `json:"columns"`
+}
+
+// AmbiguousColumn is an unqualified column of statements over several
+// tables. It is missing when none of its tables found has it.
+type AmbiguousColumn struct {
+	Name   string         `json:"name"`
+	Tables []string       `json:"tables"`
+	Kind   inventory.Kind `json:"kind,omitempty"`
+	ObjectUsage
+}
+
+type ObjectInventory struct {
+	Fingerprints     int               `json:"fingerprints"`
+	Tables           []TableInventory  `json:"tables"`
+	AmbiguousColumns []AmbiguousColumn `json:"ambiguous_columns,omitempty"`
+	MissingTables    int               `json:"missing_tables"`
+	MissingColumns   int               `json:"missing_columns"`
+}
+
+func runCorpusObjects(ctx context.Context, w io.Writer, path string, opts corpusObjectsOptions) error {
+	if opts.format != "human" && opts.format != "json" {
+		return fmt.Errorf("unsupported format: %s", opts.format)
+	}
+	inv, err := buildObjectInventory(path, opts.queries)
+	if err != nil {
+		return err
+	}
+	if opts.dsn != "" {
+		if err := checkObjectInventory(ctx, inv, opts.dsn); err != nil {
+			return err
+		}
+	}
+
+	if opts.ddl != "" {
+		if err := writeDDLStubs(opts.ddl, inv); err != nil {
+			return err
+		}
+	}
+
+	if opts.format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(inv); err != nil {
+			return err
+		}
+	} else {
+		printObjectInventory(w, inv, opts.dsn != "")
+	}
+	if inv.MissingTables > 0 || inv.MissingColumns > 0 {
+		return fmt.Errorf("%d tables and %d columns referenced by the corpus are missing on the target", inv.MissingTables, inv.MissingColumns)
+	}
+	return nil
+}
+
+func buildObjectInventory(path, queriesPath string) (*ObjectInventory, error) {
+	stats, err := readCorpus(path)
+	if err != nil {
+		return nil, err
+	}
+	queries, err := filemap.Open(queriesPath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening queries file %s: %w", queriesPath, err)
+	}
+	defer queries.Close()
+
+	hashes := make([]uint64, 0, len(stats.fingerprints))
+	for hash := range stats.fingerprints {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+
+	tables := make(map[inventory.Table]*TableInventory)
+	columns := make(map[inventory.Table]map[string]*ColumnInventory)
+	ambiguous := make(map[string]*AmbiguousColumn)
+	table := func(t inventory.Table) *TableInventory {
+		if _, ok := tables[t]; !ok {
+			tables[t] = &TableInventory{Table: t}
+			columns[t] = make(map[string]*ColumnInventory)
+		}
+		return tables[t]
+	}
+
+	extractor := inventory.NewExtractor()
+	for _, hash := range hashes {
+		fp := stats.fingerprints[hash]
+		line, err := queries.Segment(int64(fp.offset), int64(fp.length))
+		if err != nil {
+			return nil, fmt.Errorf("error reading example of fingerprint %d: %w", hash, err)
+		}
+		refs := extractor.Extract(queryText(line))
+		for _, t := range refs.Tables {
+			table(t).ObjectUsage.add(fp.count)
+		}
+		for _, c := range refs.Columns {
+			if len(c.Tables) == 1 {
+				table(c.Tables[0])
+				key := strings.ToLower(c.Name)
+				column, ok := columns[c.Tables[0]][key]
+				if !ok {
+					column = &ColumnInventory{Name: c.Name}
+					columns[c.Tables[0]][key] = column
+				}
+				column.Kind = inventory.MergeKinds(column.Kind, c.Kind)
+				column.ObjectUsage.add(fp.count)
+				continue
+			}
+			names := make([]string, len(c.Tables))
+			for i, t := range c.Tables {
+				names[i] = t.String()
+			}
+			key := strings.ToLower(c.Name) + " " + strings.Join(names, ",")
+			column, ok := ambiguous[key]
+			if !ok {
+				column = &AmbiguousColumn{Name: c.Name, Tables: names}
+				ambiguous[key] = column
+			}
+			column.Kind = inventory.MergeKinds(column.Kind, c.Kind)
+			column.ObjectUsage.add(fp.count)
+		}
+	}
+
+	inv := &ObjectInventory{Fingerprints: len(hashes)}
+	for t, ti := range tables {
+		for _, c := range columns[t] {
+			ti.Columns = append(ti.Columns, *c)
+		}
+		sort.Slice(ti.Columns, func(i, j int) bool {
+			return ti.Columns[i].ObjectUsage.before(ti.Columns[j].ObjectUsage, ti.Columns[i].Name, ti.Columns[j].Name)
+		})
+		inv.Tables = append(inv.Tables, *ti)
+	}
+	sort.Slice(inv.Tables, func(i, j int) bool {
+		return inv.Tables[i].ObjectUsage.before(inv.Tables[j].ObjectUsage, inv.Tables[i].String(), inv.Tables[j].String())
+	})
+	for _, c := range ambiguous {
+		inv.AmbiguousColumns = append(inv.AmbiguousColumns, *c)
+	}
+	sort.Slice(inv.AmbiguousColumns, func(i, j int) bool {
+		a, b := inv.AmbiguousColumns[i], inv.AmbiguousColumns[j]
+		return a.ObjectUsage.before(b.ObjectUsage, a.Name+" "+strings.Join(a.Tables, ","), b.Name+" "+strings.Join(b.Tables, ","))
+	})
+	return inv, nil
+}
+
+func (u *ObjectUsage) add(queries int64) {
+	u.Fingerprints++
+	u.Queries += queries
+}
+
+// before orders the most used objects first, then by name
+func (u ObjectUsage) before(other ObjectUsage, name, otherName string) bool {
+	if u.Queries != other.Queries {
+		return u.Queries > other.Queries
+	}
+	return name < otherName
+}
+
+// targetSchema holds the columns of the tables of the target, lowercased
+// when the target ignores the case of table names
+type targetSchema struct {
+	database  string
+	lowerCase bool
+	columns   map[inventory.Table]map[string]bool
+}
+
+func (s *targetSchema) lookup(t inventory.Table) (map[string]bool, bool) {
+	if t.Schema == "" {
+		t.Schema = s.database
+	}
+	if s.lowerCase {
+		t = inventory.Table{Schema: strings.ToLower(t.Schema), Name: strings.ToLower(t.Name)}
+	}
+	columns, ok := s.columns[t]
+	return columns, ok
+}
+
+// checkObjectInventory sets the status of the objects of inv from the
+// information_schema of the target. Columns of missing tables are left
+// unchecked, the table is reported.
+func checkObjectInventory(ctx context.Context, inv *ObjectInventory, dsn string) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return fmt.Errorf("error opening target: %w", err)
+	}
+	defer db.Close()
+
+	schema := &targetSchema{columns: make(map[inventory.Table]map[string]bool)}
+	var database sql.NullString
+	var lowerCaseTableNames int
+	if err := db.QueryRowContext(ctx, "SELECT DATABASE(), @@lower_case_table_names").Scan(&database, &lowerCaseTableNames); err != nil {
+		return fmt.Errorf("error connecting to target: %w", err)
+	}
+	schema.database = database.String
+	schema.lowerCase = lowerCaseTableNames != 0
+
+	schemas := make(map[string]bool)
+	for _, t := range inv.Tables {
+		if t.Schema != "" {
+			schemas[t.Schema] = true
+		} else if schema.database != "" {
+			schemas[schema.database] = true
+		}
+	}
+	if len(schemas) > 0 {
+		args := make([]any, 0, len(schemas))
+		for s := range schemas {
+			args = append(args, s)
+		}
+		rows, err := db.QueryContext(ctx, "SELECT table_schema, table_name, column_name FROM information_schema.columns WHERE table_schema IN (?"+
+			strings.Repeat(", ?", len(args)-1)+")", args...)
+		if err != nil {
+			return fmt.Errorf("error reading columns of the target: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var t inventory.Table
+			var column string
+			if err := rows.Scan(&t.Schema, &t.Name, &column); err != nil {
+				return fmt.Errorf("error reading columns of the target: %w", err)
+			}
+			if schema.lowerCase {
+				t = inventory.Table{Schema: strings.ToLower(t.Schema), Name: strings.ToLower(t.Name)}
+			}
+			if schema.columns[t] == nil {
+				schema.columns[t] = make(map[string]bool)
+			}
+			schema.columns[t][strings.ToLower(column)] = true
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("error reading columns of the target: %w", err)
+		}
+	}
+
+	for i := range inv.Tables {
+		t := &inv.Tables[i]
+		if t.Schema == "" && schema.database == "" {
+			// nothing to look the table up in
+			continue
+		}
+		columns, ok := schema.lookup(t.Table)
+		if !ok {
+			t.Status = objectMissing
+			inv.MissingTables++
+			continue
+		}
+		t.Status = objectFound
+		for j := range t.Columns {
+			c := &t.Columns[j]
+			c.Status = objectFound
+			if !columns[strings.ToLower(c.Name)] {
+				c.Status = objectMissing
+				inv.MissingColumns++
+			}
+		}
+	}
+	tables := make(map[string]inventory.Table, len(inv.Tables))
+	for _, t := range inv.Tables {
+		tables[t.String()] = t.Table
+	}
+	for i := range inv.AmbiguousColumns {
+		c := &inv.AmbiguousColumns[i]
+		for _, name := range c.Tables {
+			columns, ok := schema.lookup(tables[name])
+			if !ok {
+				continue
+			}
+			if columns[strings.ToLower(c.Name)] {
+				c.Status = objectFound
+				break
+			}
+			c.Status = objectMissing
+		}
+		if c.Status == objectMissing {
+			inv.MissingColumns++
+		}
+	}
+	return nil
+}
+
+func printObjectInventory(w io.Writer, inv *ObjectInventory, checked bool) {
+	fmt.Fprintf(w, "%d fingerprints reference %d tables\n", inv.Fingerprints, len(inv.Tables))
+	if checked {
+		fmt.Fprintf(w, "missing on the target: %d tables, %d columns\n", inv.MissingTables, inv.MissingColumns)
+	}
+	fmt.Fprintln(w)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "OBJECT\tKIND\tFINGERPRINTS\tQUERIES\tSTATUS")
+	for _, t := range inv.Tables {
+		fmt.Fprintf(tw, "%s\t\t%d\t%d\t%s\n", t.String(), t.Fingerprints, t.Queries, t.Status)
+		for _, c := range t.Columns {
+			fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\t%s\n", c.Name, c.Kind, c.Fingerprints, c.Queries, c.Status)
+		}
+	}
+	tw.Flush()
+
+	if len(inv.AmbiguousColumns) > 0 {
+		fmt.Fprintln(w, "\nunqualified columns of statements over several tables")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "COLUMN\tTABLES\tKIND\tFINGERPRINTS\tQUERIES\tSTATUS")
+		for _, c := range inv.AmbiguousColumns {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%s\n", c.Name, strings.Join(c.Tables, ", "), c.Kind, c.Fingerprints, c.Queries, c.Status)
+		}
+		tw.Flush()
+	}
+}
+
+// ddlTypes are the column types of the stubs per kind
+var ddlTypes = map[inventory.Kind]string{
+	inventory.KindInteger:  "BIGINT",
+	inventory.KindDecimal:  "DECIMAL(20,6)",
+	inventory.KindString:   "VARCHAR(255)",
+	inventory.KindDate:     "DATE",
+	inventory.KindDatetime: "DATETIME(6)",
+	inventory.KindBinary:   "VARBINARY(255)",
+}
+
+// writeDDLStubs writes a CREATE TABLE stub of every table of inv. An integer
+// or untyped id column becomes an auto-increment primary key, a table
+// without columns gets one.
+func writeDDLStubs(path string, inv *ObjectInventory) error {
+	type stubColumn struct {
+		name string
+		kind inventory.Kind
+	}
+	columns := make(map[string][]stubColumn, len(inv.Tables))
+	has := make(map[string]map[string]bool, len(inv.Tables))
+	for _, t := range inv.Tables {
+		has[t.String()] = make(map[string]bool)
+		for _, c := range t.Columns {
+			columns[t.String()] = append(columns[t.String()], stubColumn{c.Name, c.Kind})
+			has[t.String()][strings.ToLower(c.Name)] = true
+		}
+	}
+	for _, c := range inv.AmbiguousColumns {
+		placed := false
+		for _, t := range c.Tables {
+			if has[t][strings.ToLower(c.Name)] {
+				placed = true
+				break
+			}
+		}
+		if !placed {
+			columns[c.Tables[0]] = append(columns[c.Tables[0]], stubColumn{c.Name, c.Kind})
+			has[c.Tables[0]][strings.ToLower(c.Name)] = true
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "-- CREATE TABLE stubs of the %d tables referenced by the corpus, column types\n-- are guessed from the literals of the queries\n", len(inv.Tables))
+	schemas := make(map[string]bool)
+	for _, t := range inv.Tables {
+		if t.Schema != "" && !schemas[t.Schema] {
+			schemas[t.Schema] = true
+			fmt.Fprintf(&b, "\nCREATE DATABASE IF NOT EXISTS %s;\n", quoteName(t.Schema))
+		}
+	}
+	for _, t := range inv.Tables {
+		name := quoteName(t.Name)
+		if t.Schema != "" {
+			name = quoteName(t.Schema) + "." + name
+		}
+		var defs []string
+		primaryKey := false
+		for _, c := range columns[t.String()] {
+			if strings.EqualFold(c.name, "id") && (c.kind == "" || c.kind == inventory.KindInteger) {
+				defs = append(defs, quoteName(c.name)+" BIGINT NOT NULL AUTO_INCREMENT")
+				primaryKey = true
+				continue
+			}
+			kind := c.kind
+			if kind == "" {
+				// nothing compared, fall back on the naming conventions
+				switch lower := strings.ToLower(c.name); {
+				case strings.HasSuffix(lower, "_id"):
+					kind = inventory.KindInteger
+				case strings.HasSuffix(lower, "_at"):
+					kind = inventory.KindDatetime
+				default:
+					kind = inventory.KindString
+				}
+			}
+			typ := ddlTypes[kind]
+			defs = append(defs, quoteName(c.name)+" "+typ+" NULL")
+		}
+		if len(defs) == 0 {
+			defs = append(defs, "`id` BIGINT NOT NULL AUTO_INCREMENT")
+			primaryKey = true
+		}
+		if primaryKey {
+			defs = append(defs, "PRIMARY KEY (`id`)")
+		}
+		fmt.Fprintf(&b, "\nCREATE TABLE IF NOT EXISTS %s (\n  %s\n);\n", name, strings.Join(defs, ",\n  "))
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("error writing DDL stubs: %w", err)
+	}
+	return nil
+}
+
+func quoteName(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
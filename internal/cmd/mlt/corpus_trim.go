@@ -0,0 +1,276 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"mysql-load-test/pkg/filemap"
+	"mysql-load-test/pkg/query"
+
+	"github.com/spf13/cobra"
+)
+
+type corpusTrimOptions struct {
+	output        string
+	queries       string
+	outputQueries string
+	top           int
+	minWeight     float64
+	fingerprints  []string
+	match         string
+	maxQueries    int64
+}
+
+func newCorpusTrimCmd() *cobra.Command {
+	opts := corpusTrimOptions{}
+	cmd := &cobra.Command{
+		Use:   "trim <cache>",
+		Short: "Write a reduced corpus with only the top fingerprints",
+		Long: `Writes a corpus containing only the top-N fingerprints by weight, or those
+matching the filters. Weights are derived from the query counts, so the kept
+fingerprints keep their relative weights and sum to 100% in the new corpus.
+
+The records keep pointing into the original queries file unless
+--output-queries is given, in which case only the referenced queries are
+copied and the offsets rewritten.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCorpusTrim(cmd.OutOrStdout(), args[0], opts)
+		},
+	}
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "Output cache file")
+	cmd.Flags().StringVar(&opts.queries, "queries", "", "Queries file of the corpus, required by --match and --output-queries")
+	cmd.Flags().StringVar(&opts.outputQueries, "output-queries", "", "Write a queries file with only the kept queries")
+	cmd.Flags().IntVar(&opts.top, "top", 0, "Keep the N fingerprints with the highest weight, 0 keeps all")
+	cmd.Flags().Float64Var(&opts.minWeight, "min-weight", 0, "Keep only fingerprints with at least this weight in percent")
+	cmd.Flags().StringSliceVar(&opts.fingerprints, "fingerprint", nil, "Keep only these fingerprint hashes")
+	cmd.Flags().StringVar(&opts.match, "match", "", "Keep only fingerprints whose example query matches this regexp")
+	cmd.Flags().Int64Var(&opts.maxQueries, "max-queries", 0, "Downsample every fingerprint evenly to about this many queries in total, 0 keeps all")
+	cmd.MarkFlagRequired("output")
+	return cmd
+}
+
+type trimmedFingerprint struct {
+	hash      uint64
+	count     int64
+	keep      int64
+	weight    float64
+	newWeight float64
+}
+
+func runCorpusTrim(w io.Writer, path string, opts corpusTrimOptions) error {
+	if (opts.match != "" || opts.outputQueries != "") && opts.queries == "" {
+		return fmt.Errorf("--match and --output-queries require --queries")
+	}
+
+	stats, err := readCorpus(path)
+	if err != nil {
+		return err
+	}
+
+	var queries *filemap.File
+	if opts.queries != "" {
+		queries, err = filemap.Open(opts.queries)
+		if err != nil {
+			return fmt.Errorf("error opening queries file %s: %w", opts.queries, err)
+		}
+		defer queries.Close()
+	}
+
+	selected, err := selectFingerprints(stats, queries, opts)
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no fingerprint matches the filters")
+	}
+
+	written, err := writeTrimmedCorpus(path, queries, selected, opts)
+	if err != nil {
+		return err
+	}
+
+	printTrimSummary(w, stats, selected, written)
+	return nil
+}
+
+// selectFingerprints applies the filters and returns the kept fingerprints,
+// sorted by weight, with their rewritten weights and downsampled counts
+func selectFingerprints(stats *corpusStats, queries *filemap.File, opts corpusTrimOptions) ([]*trimmedFingerprint, error) {
+	var match *regexp.Regexp
+	if opts.match != "" {
+		var err error
+		if match, err = regexp.Compile(opts.match); err != nil {
+			return nil, fmt.Errorf("error compiling match: %w", err)
+		}
+	}
+	allowed := make(map[uint64]bool, len(opts.fingerprints))
+	for _, s := range opts.fingerprints {
+		hash, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing fingerprint %q: %w", s, err)
+		}
+		allowed[hash] = true
+	}
+
+	var selected []*trimmedFingerprint
+	for hash, fp := range stats.fingerprints {
+		if len(allowed) > 0 && !allowed[hash] {
+			continue
+		}
+		weight := stats.weight(hash)
+		if weight < opts.minWeight {
+			continue
+		}
+		if match != nil {
+			example, err := queries.Segment(int64(fp.offset), int64(fp.length))
+			if err != nil {
+				return nil, fmt.Errorf("error reading example of fingerprint %d: %w", hash, err)
+			}
+			if !match.Match(example) {
+				continue
+			}
+		}
+		selected = append(selected, &trimmedFingerprint{hash: hash, count: fp.count, keep: fp.count, weight: weight})
+	}
+
+	sort.Slice(selected, func(i, j int) bool {
+		if selected[i].count != selected[j].count {
+			return selected[i].count > selected[j].count
+		}
+		return selected[i].hash < selected[j].hash
+	})
+	if opts.top > 0 && len(selected) > opts.top {
+		selected = selected[:opts.top]
+	}
+
+	var total int64
+	for _, fp := range selected {
+		total += fp.count
+	}
+	// scale every fingerprint by the same factor so the weights hold, but
+	// keep at least one query of each
+	if opts.maxQueries > 0 && total > opts.maxQueries {
+		ratio := float64(opts.maxQueries) / float64(total)
+		for _, fp := range selected {
+			fp.keep = max(1, int64(math.Round(float64(fp.count)*ratio)))
+		}
+	}
+
+	var kept int64
+	for _, fp := range selected {
+		kept += fp.keep
+	}
+	for _, fp := range selected {
+		fp.newWeight = float64(fp.keep) / float64(kept) * 100
+	}
+	return selected, nil
+}
+
+func writeTrimmedCorpus(path string, queries *filemap.File, selected []*trimmedFingerprint, opts corpusTrimOptions) (int64, error) {
+	keep := make(map[uint64]int64, len(selected))
+	for _, fp := range selected {
+		keep[fp.hash] = fp.keep
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("error opening corpus %s: %w", path, err)
+	}
+	defer in.Close()
+
+	out, err := os.Create(opts.output)
+	if err != nil {
+		return 0, fmt.Errorf("error creating output file: %w", err)
+	}
+	defer out.Close()
+	writer := bufio.NewWriterSize(out, 1024*1024)
+
+	var queriesWriter *bufio.Writer
+	if opts.outputQueries != "" {
+		queriesOut, err := os.Create(opts.outputQueries)
+		if err != nil {
+			return 0, fmt.Errorf("error creating output queries file: %w", err)
+		}
+		defer queriesOut.Close()
+		queriesWriter = bufio.NewWriterSize(queriesOut, 1024*1024)
+	}
+
+	reader, err := query.NewCacheReader(bufio.NewReaderSize(in, 1024*1024))
+	if err != nil {
+		return 0, fmt.Errorf("error reading corpus %s: %w", path, err)
+	}
+	cacheWriter, err := query.NewCacheWriter(writer)
+	if err != nil {
+		return 0, fmt.Errorf("error writing output file: %w", err)
+	}
+	var written int64
+	var offset uint64
+	var q query.Query
+	for {
+		if err := reader.Read(&q); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return 0, fmt.Errorf("error reading corpus %s: %w", path, err)
+		}
+		if keep[q.FingerprintHash] <= 0 {
+			continue
+		}
+		keep[q.FingerprintHash]--
+
+		if queriesWriter != nil {
+			line, err := queries.Segment(int64(q.Offset), int64(q.Length))
+			if err != nil {
+				return 0, fmt.Errorf("error reading query %d: %w", q.Hash, err)
+			}
+			if _, err := queriesWriter.Write(line); err != nil {
+				return 0, fmt.Errorf("error writing output queries file: %w", err)
+			}
+			q.Offset = offset
+			offset += q.Length
+		}
+
+		if err := cacheWriter.Write(&q); err != nil {
+			return 0, fmt.Errorf("error writing output file: %w", err)
+		}
+		written++
+	}
+
+	if queriesWriter != nil {
+		if err := queriesWriter.Flush(); err != nil {
+			return 0, fmt.Errorf("error writing output queries file: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, fmt.Errorf("error writing output file: %w", err)
+	}
+	return written, nil
+}
+
+func printTrimSummary(w io.Writer, stats *corpusStats, selected []*trimmedFingerprint, written int64) {
+	var covered float64
+	for _, fp := range selected {
+		covered += fp.weight
+	}
+	fmt.Fprintf(w, "Queries:       %d -> %d\n", stats.total, written)
+	fmt.Fprintf(w, "Fingerprints:  %d -> %d (%.3f%% of the original weight)\n\n", len(stats.fingerprints), len(selected), covered)
+
+	shown := selected[:min(len(selected), 20)]
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "fingerprint\tcount\tkept\tweight\tnew weight\t")
+	for _, fp := range shown {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%.3f%%\t%.3f%%\t\n", fp.hash, fp.count, fp.keep, fp.weight, fp.newWeight)
+	}
+	tw.Flush()
+	if len(shown) < len(selected) {
+		fmt.Fprintf(w, "... %d more\n", len(selected)-len(shown))
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"mysql-load-test/pkg/fingerprint"
+
+	"github.com/spf13/cobra"
+)
+
+// fingerprintServer serves the batch fingerprinting API the collector
+// offloads to with --processor.fingerprint-servers
+type fingerprintServer struct {
+	fingerprinter *fingerprint.Fingerprinter
+	maxBatchSize  int
+}
+
+func newFingerprintServer(maxBatchSize int) *fingerprintServer {
+	return &fingerprintServer{
+		fingerprinter: fingerprint.NewFingerprinter(),
+		maxBatchSize:  maxBatchSize,
+	}
+}
+
+func (s *fingerprintServer) handleFingerprint(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req fingerprint.BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(req.Queries) > s.maxBatchSize {
+		http.Error(w, fmt.Sprintf("batch too large: %d > %d", len(req.Queries), s.maxBatchSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	resp := fingerprint.BatchResponse{
+		Results: make([]fingerprint.Result, len(req.Queries)),
+	}
+	for i, q := range req.Queries {
+		res, err := s.fingerprinter.Fingerprint([]byte(q))
+		if err != nil {
+			res.Error = err.Error()
+		}
+		resp.Results[i] = res
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing response: %v\n", err)
+	}
+}
+
+func (s *fingerprintServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+func (s *fingerprintServer) ListenAndServe(ctx context.Context, addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(fingerprint.BatchPath, s.handleFingerprint)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+
+	server := &http.Server{
+		Addr:         addr,
+		Handler:      mux,
+		ReadTimeout:  30 * time.Second,
+		WriteTimeout: 30 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Fingerprint server listening on %s\n", addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("error serving: %w", err)
+	}
+	return nil
+}
+
+func newFingerprintServerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "fingerprint-server",
+		Short: "Serve a batch query normalization and hashing API",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr, _ := cmd.Flags().GetString("addr")
+			maxBatchSize, _ := cmd.Flags().GetInt("max-batch-size")
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+			defer stop()
+
+			return newFingerprintServer(maxBatchSize).ListenAndServe(ctx, addr)
+		},
+	}
+
+	cmd.Flags().String("addr", ":6617", "Address to listen on")
+	cmd.Flags().Int("max-batch-size", 10000, "Maximum number of queries accepted in a single request")
+
+	return cmd
+}
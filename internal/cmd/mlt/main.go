@@ -0,0 +1,48 @@
+// Command mlt groups the offline tooling around collected corpora, and the
+// fingerprint server of the collector.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var rootCmd = &cobra.Command{
+	Use:           "mlt",
+	Short:         "MySQL load test tooling",
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Analyze the results of load test runs",
+}
+
+var corpusCmd = &cobra.Command{
+	Use:   "corpus",
+	Short: "Inspect collected corpora",
+}
+
+func init() {
+	corpusCmd.AddCommand(newCorpusDiffCmd())
+	corpusCmd.AddCommand(newCorpusTrimCmd())
+	corpusCmd.AddCommand(newCorpusBackfillCmd())
+	corpusCmd.AddCommand(newCorpusExportCmd())
+	corpusCmd.AddCommand(newCorpusObjectsCmd())
+	rootCmd.AddCommand(corpusCmd)
+	rootCmd.AddCommand(newTagCmd())
+	rootCmd.AddCommand(newTrendsCmd())
+	rootCmd.AddCommand(newFingerprintServerCmd())
+	reportCmd.AddCommand(newReportQueryCmd())
+	rootCmd.AddCommand(reportCmd)
+}
+
+func main() {
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
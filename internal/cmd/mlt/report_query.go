@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mysql-load-test/internal/resultsdb"
+
+	"github.com/spf13/cobra"
+)
+
+type reportQueryOptions struct {
+	preset string
+	format string
+}
+
+func newReportQueryCmd() *cobra.Command {
+	opts := reportQueryOptions{}
+	cmd := &cobra.Command{
+		Use:   "query <results-db> [sql]",
+		Short: "Query the results database of a run",
+		Long: `Runs a SQL query, or one of the presets, against the SQLite results
+database written by load-test --results-db. Presets: ` + strings.Join(resultsdb.PresetNames(), ", ") + `.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := ""
+			if len(args) == 2 {
+				query = args[1]
+			}
+			return runReportQuery(cmd.OutOrStdout(), args[0], query, opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.preset, "preset", "", "Run a canned query instead of sql: "+strings.Join(resultsdb.PresetNames(), ", "))
+	cmd.Flags().StringVar(&opts.format, "format", "human", "Output format: human or json")
+	return cmd
+}
+
+func runReportQuery(w io.Writer, path, query string, opts reportQueryOptions) error {
+	switch {
+	case opts.preset != "" && query != "":
+		return fmt.Errorf("pass either sql or --preset, not both")
+	case opts.preset != "":
+		var ok bool
+		if query, ok = resultsdb.Presets[opts.preset]; !ok {
+			return fmt.Errorf("unknown preset %s, available: %s", opts.preset, strings.Join(resultsdb.PresetNames(), ", "))
+		}
+	case query == "":
+		query = resultsdb.Presets["summary"]
+	}
+	if opts.format != "human" && opts.format != "json" {
+		return fmt.Errorf("unsupported format: %s", opts.format)
+	}
+
+	// the database is opened read-only, the schema must already be there
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("error opening results database: %w", err)
+	}
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("error opening results database: %w", err)
+	}
+	defer db.Close()
+
+	columns, rows, err := queryRows(db, query)
+	if err != nil {
+		return err
+	}
+	if opts.format == "json" {
+		return printRowsJSON(w, columns, rows)
+	}
+	return printRowsTable(w, columns, rows)
+}
+
+func queryRows(db *sql.DB, query string) ([]string, [][]any, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error running query: %w", err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading columns: %w", err)
+	}
+	var result [][]any
+	for rows.Next() {
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, nil, fmt.Errorf("error scanning row: %w", err)
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		result = append(result, values)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading rows: %w", err)
+	}
+	return columns, result, nil
+}
+
+func printRowsTable(w io.Writer, columns []string, rows [][]any) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, v := range row {
+			if v == nil {
+				cells[i] = "NULL"
+				continue
+			}
+			cells[i] = fmt.Sprint(v)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+func printRowsJSON(w io.Writer, columns []string, rows [][]any) error {
+	objects := make([]map[string]any, len(rows))
+	for i, row := range rows {
+		obj := make(map[string]any, len(columns))
+		for j, col := range columns {
+			obj[col] = row[j]
+		}
+		objects[i] = obj
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(objects)
+}
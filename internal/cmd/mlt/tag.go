@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/spf13/cobra"
+)
+
+// maxTagFingerprintLen is how much of the fingerprint text tag ls shows
+const maxTagFingerprintLen = 80
+
+func newTagCmd() *cobra.Command {
+	var dsn string
+	cmd := &cobra.Command{
+		Use:   "tag",
+		Short: "Label fingerprints with name=value tags",
+		Long: `Labels fingerprints of the metadata database, e.g. service=checkout or
+team=payments. The load test selects and mixes fingerprints by tag with
+queries_data_source.tags and breaks its report down by them.`,
+	}
+	cmd.PersistentFlags().StringVar(&dsn, "dsn", "", "DSN of the metadata database, e.g. root:root@tcp(127.0.0.1:13306)/MySQLLoadTester")
+	cmd.MarkPersistentFlagRequired("dsn")
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <fingerprint-hash> <name=value>...",
+		Short: "Set tags of a fingerprint, replacing the values of the same names",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMetadataDB(cmd.Context(), dsn, func(db *sql.DB) error {
+				return setTags(cmd.Context(), db, args[0], args[1:])
+			})
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rm <fingerprint-hash> [name]...",
+		Short: "Remove tags of a fingerprint, all of them without names",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMetadataDB(cmd.Context(), dsn, func(db *sql.DB) error {
+				return removeTags(cmd.Context(), cmd.OutOrStdout(), db, args[0], args[1:])
+			})
+		},
+	})
+
+	var filter string
+	ls := &cobra.Command{
+		Use:   "ls",
+		Short: "List tagged fingerprints",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withMetadataDB(cmd.Context(), dsn, func(db *sql.DB) error {
+				return listTags(cmd.Context(), cmd.OutOrStdout(), db, filter)
+			})
+		},
+	}
+	ls.Flags().StringVar(&filter, "tag", "", "Only list fingerprints with this name=value tag")
+	cmd.AddCommand(ls)
+	return cmd
+}
+
+func withMetadataDB(ctx context.Context, dsn string, fn func(db *sql.DB) error) error {
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer db.Close()
+	if err := db.PingContext(ctx); err != nil {
+		return fmt.Errorf("error connecting to database: %w", err)
+	}
+	return fn(db)
+}
+
+func parseFingerprintHash(s string) (uint64, error) {
+	hash, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid fingerprint hash %s: %w", s, err)
+	}
+	return hash, nil
+}
+
+func parseTag(tag string) (name, value string, err error) {
+	name, value, ok := strings.Cut(tag, "=")
+	name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+	if !ok || name == "" || value == "" {
+		return "", "", fmt.Errorf("invalid tag %q, expected name=value", tag)
+	}
+	return name, value, nil
+}
+
+func setTags(ctx context.Context, db *sql.DB, hashArg string, tags []string) error {
+	hash, err := parseFingerprintHash(hashArg)
+	if err != nil {
+		return err
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, tag := range tags {
+		name, value, err := parseTag(tag)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "INSERT INTO QueryFingerprintTag (FingerprintHash, `Name`, `Value`) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE `Value` = VALUES(`Value`)", hash, name, value)
+		if err != nil {
+			return fmt.Errorf("error setting tag %s: %w", tag, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+func removeTags(ctx context.Context, w io.Writer, db *sql.DB, hashArg string, names []string) error {
+	hash, err := parseFingerprintHash(hashArg)
+	if err != nil {
+		return err
+	}
+	query, args := "DELETE FROM QueryFingerprintTag WHERE FingerprintHash = ?", []any{hash}
+	if len(names) > 0 {
+		query += " AND `Name` IN (?" + strings.Repeat(", ?", len(names)-1) + ")"
+		for _, name := range names {
+			args = append(args, name)
+		}
+	}
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("error removing tags: %w", err)
+	}
+	removed, _ := res.RowsAffected()
+	fmt.Fprintf(w, "removed %d tags\n", removed)
+	return nil
+}
+
+func listTags(ctx context.Context, w io.Writer, db *sql.DB, filter string) error {
+	query := "SELECT t.FingerprintHash, GROUP_CONCAT(CONCAT(t.`Name`, '=', t.`Value`) ORDER BY t.`Name` SEPARATOR ' '), COALESCE(f.Fingerprint, '')" +
+		" FROM QueryFingerprintTag t LEFT JOIN QueryFingerprint f ON f.Hash = t.FingerprintHash"
+	var args []any
+	if filter != "" {
+		name, value, err := parseTag(filter)
+		if err != nil {
+			return err
+		}
+		query += " WHERE t.FingerprintHash IN (SELECT FingerprintHash FROM QueryFingerprintTag WHERE `Name` = ? AND `Value` = ?)"
+		args = append(args, name, value)
+	}
+	query += " GROUP BY t.FingerprintHash, f.Fingerprint ORDER BY t.FingerprintHash"
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("error listing tags: %w", err)
+	}
+	defer rows.Close()
+
+	var result [][]any
+	for rows.Next() {
+		var hash uint64
+		var tags, fingerprint string
+		if err := rows.Scan(&hash, &tags, &fingerprint); err != nil {
+			return fmt.Errorf("error scanning tags: %w", err)
+		}
+		if len(fingerprint) > maxTagFingerprintLen {
+			fingerprint = string(truncateText([]byte(fingerprint), maxTagFingerprintLen-3)) + "..."
+		}
+		result = append(result, []any{hash, tags, fingerprint})
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error listing tags: %w", err)
+	}
+	return printRowsTable(w, []string{"fingerprint_hash", "tags", "fingerprint"}, result)
+}
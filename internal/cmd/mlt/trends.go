@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"mysql-load-test/internal/resultsdb"
+
+	"github.com/spf13/cobra"
+)
+
+// trendMetrics read the plotted metric of a run summary
+var trendMetrics = map[string]func(resultsdb.RunSummary) float64{
+	"p50":        func(s resultsdb.RunSummary) float64 { return s.P50 },
+	"p95":        func(s resultsdb.RunSummary) float64 { return s.P95 },
+	"p99":        func(s resultsdb.RunSummary) float64 { return s.P99 },
+	"qps":        func(s resultsdb.RunSummary) float64 { return s.QPS },
+	"error_rate": func(s resultsdb.RunSummary) float64 { return s.ErrorRate },
+}
+
+// trendsBarWidth is the width of the bar of the largest value
+const trendsBarWidth = 40
+
+type trendsOptions struct {
+	target string
+	metric string
+	last   int
+	format string
+}
+
+func newTrendsCmd() *cobra.Command {
+	opts := trendsOptions{}
+	cmd := &cobra.Command{
+		Use:   "trends <trends-db>",
+		Short: "Show the metric history of a target across runs",
+		Long: `Prints the runs against a target recorded in the trends database written by
+load-test --trends-db, with a bar of the chosen metric, oldest first. Without
+--target the targets of the database are listed. The database is a SQLite
+file or a mysql:// DSN.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runTrends(cmd.Context(), cmd.OutOrStdout(), args[0], opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.target, "target", "", "Target whose runs are shown, as recorded by load-test")
+	cmd.Flags().StringVar(&opts.metric, "metric", "p99", "Metric plotted: p50, p95, p99, qps or error_rate")
+	cmd.Flags().IntVar(&opts.last, "last", 30, "Number of latest runs shown, 0 for all")
+	cmd.Flags().StringVar(&opts.format, "format", "human", "Output format: human or json")
+	return cmd
+}
+
+func runTrends(ctx context.Context, w io.Writer, dsn string, opts trendsOptions) error {
+	metric, ok := trendMetrics[opts.metric]
+	if !ok {
+		return fmt.Errorf("unknown metric %s, expected p50, p95, p99, qps or error_rate", opts.metric)
+	}
+	if opts.format != "human" && opts.format != "json" {
+		return fmt.Errorf("unsupported format: %s", opts.format)
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// don't leave an empty database behind a mistyped path
+	if !strings.HasPrefix(dsn, resultsdb.TrendsMySQLPrefix) {
+		if _, err := os.Stat(dsn); err != nil {
+			return fmt.Errorf("error opening trends database: %w", err)
+		}
+	}
+	db, err := resultsdb.OpenTrends(dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if opts.target == "" {
+		targets, err := resultsdb.TrendTargets(ctx, db)
+		if err != nil {
+			return err
+		}
+		if opts.format == "json" {
+			return printTrendsJSON(w, targets)
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "target\truns\tfirst_run\tlast_run")
+		for _, t := range targets {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", t.Target, t.Runs, t.FirstRun, t.LastRun)
+		}
+		return tw.Flush()
+	}
+
+	runs, err := resultsdb.RunHistory(ctx, db, opts.target, opts.last)
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return fmt.Errorf("no runs against target %s", opts.target)
+	}
+	if opts.format == "json" {
+		return printTrendsJSON(w, runs)
+	}
+
+	top := 0.0
+	for _, run := range runs {
+		top = max(top, metric(run))
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "started_at\tcorpus\tconfig\t%s\t\n", opts.metric)
+	for _, run := range runs {
+		value := metric(run)
+		bar := 0
+		if top > 0 {
+			bar = int(value / top * trendsBarWidth)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%.8s\t%s\t%s\n", run.StartedAt, run.Corpus, run.ConfigHash,
+			formatTrendValue(opts.metric, value), strings.Repeat("#", bar))
+	}
+	return tw.Flush()
+}
+
+func formatTrendValue(metric string, value float64) string {
+	switch metric {
+	case "qps":
+		return fmt.Sprintf("%.1f", value)
+	case "error_rate":
+		return fmt.Sprintf("%.3f%%", value*100)
+	default:
+		return fmt.Sprintf("%.0fus", value)
+	}
+}
+
+func printTrendsJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// literalPattern is a kind of sensitive literal the anonymization audit looks
+// for in queries
+type literalPattern struct {
+	name string
+	re   *regexp.Regexp
+}
+
+var literalPatterns = []literalPattern{
+	{"email", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)},
+	{"card_number", regexp.MustCompile(`\b\d{4}[ -]?\d{4}[ -]?\d{4}[ -]?\d{4}\b`)},
+	{"ipv4", regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)},
+}
+
+const (
+	// maxAuditSamples bounds the suspicious queries kept for the report
+	maxAuditSamples = 100
+	// maxAuditSampleLen truncates each kept query
+	maxAuditSampleLen = 256
+)
+
+// AnonymizationAudit counts the sensitive literals found in extracted queries
+// and how many the transforms scrubbed, and keeps samples of queries that
+// still contain one so a corpus can be signed off before it leaves the
+// environment it was captured in.
+type AnonymizationAudit struct {
+	queries    atomic.Uint64
+	found      []atomic.Uint64
+	scrubbed   []atomic.Uint64
+	suspicious atomic.Uint64
+
+	mu      sync.Mutex
+	samples []AuditSample
+	seen    map[string]bool
+}
+
+type AuditSample struct {
+	Literals []string `json:"literals"`
+	Query    string   `json:"query"`
+}
+
+func NewAnonymizationAudit() *AnonymizationAudit {
+	return &AnonymizationAudit{
+		found:    make([]atomic.Uint64, len(literalPatterns)),
+		scrubbed: make([]atomic.Uint64, len(literalPatterns)),
+		seen:     make(map[string]bool),
+	}
+}
+
+// matchLiterals returns a bit per literal pattern found in q
+func matchLiterals(q []byte) uint {
+	var mask uint
+	for i, p := range literalPatterns {
+		if p.re.Match(q) {
+			mask |= 1 << i
+		}
+	}
+	return mask
+}
+
+// Observe records a query before and after the transforms, after is nil when
+// a transform dropped the query
+func (a *AnonymizationAudit) Observe(before uint, after []byte) {
+	a.queries.Add(1)
+	var remaining uint
+	if after != nil {
+		remaining = matchLiterals(after)
+	}
+	for i := range literalPatterns {
+		if before&(1<<i) == 0 {
+			continue
+		}
+		a.found[i].Add(1)
+		if remaining&(1<<i) == 0 {
+			a.scrubbed[i].Add(1)
+		}
+	}
+	if remaining != 0 {
+		a.suspicious.Add(1)
+		a.sample(remaining, after)
+	}
+}
+
+func (a *AnonymizationAudit) sample(mask uint, q []byte) {
+	text := string(q)
+	if len(text) > maxAuditSampleLen {
+		text = text[:maxAuditSampleLen] + "..."
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.samples) >= maxAuditSamples || a.seen[text] {
+		return
+	}
+	a.seen[text] = true
+	var literals []string
+	for i, p := range literalPatterns {
+		if mask&(1<<i) != 0 {
+			literals = append(literals, p.name)
+		}
+	}
+	a.samples = append(a.samples, AuditSample{Literals: literals, Query: text})
+}
+
+type AuditLiteralCounts struct {
+	Found     uint64 `json:"found"`
+	Scrubbed  uint64 `json:"scrubbed"`
+	Remaining uint64 `json:"remaining"`
+}
+
+type AnonymizationAuditReport struct {
+	Queries           uint64                        `json:"queries"`
+	Literals          map[string]AuditLiteralCounts `json:"literals"`
+	SuspiciousQueries uint64                        `json:"suspicious_queries"`
+	Samples           []AuditSample                 `json:"samples,omitempty"`
+}
+
+// Passed reports whether no audited query still contains a sensitive literal
+func (r AnonymizationAuditReport) Passed() bool {
+	return r.SuspiciousQueries == 0
+}
+
+func (a *AnonymizationAudit) Report() AnonymizationAuditReport {
+	r := AnonymizationAuditReport{
+		Queries:           a.queries.Load(),
+		Literals:          make(map[string]AuditLiteralCounts, len(literalPatterns)),
+		SuspiciousQueries: a.suspicious.Load(),
+	}
+	for i, p := range literalPatterns {
+		found, scrubbed := a.found[i].Load(), a.scrubbed[i].Load()
+		r.Literals[p.name] = AuditLiteralCounts{Found: found, Scrubbed: scrubbed, Remaining: found - scrubbed}
+	}
+	a.mu.Lock()
+	r.Samples = append([]AuditSample(nil), a.samples...)
+	a.mu.Unlock()
+	return r
+}
+
+func (r AnonymizationAuditReport) Print() {
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("ANONYMIZATION AUDIT")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Printf("%-32s %d\n", "queries audited", r.Queries)
+	fmt.Printf("  %-30s %10s %10s %10s\n", "literal", "found", "scrubbed", "remaining")
+	for _, p := range literalPatterns {
+		c := r.Literals[p.name]
+		fmt.Printf("  %-30s %10d %10d %10d\n", p.name, c.Found, c.Scrubbed, c.Remaining)
+	}
+	fmt.Printf("%-32s %d\n", "suspicious queries", r.SuspiciousQueries)
+	for _, s := range r.Samples {
+		fmt.Printf("  [%s] %s\n", strings.Join(s.Literals, ","), s.Query)
+	}
+	if r.Passed() {
+		fmt.Println("result: PASS")
+	} else {
+		fmt.Println("result: FAIL, queries still contain sensitive literals")
+	}
+	fmt.Println(strings.Repeat("=", 80))
+}
+
+func (r AnonymizationAuditReport) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling anonymization audit: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing anonymization audit: %w", err)
+	}
+	return nil
+}
@@ -9,12 +9,43 @@ type AppConfig struct {
 	InputTsharkTxt InputTsharkTxtConfig `json:"input_tshark_txt"`
 	// InputCache InputCacheConfig `json:"input_cache"`
 	InputPcap InputPcapConfig `json:"input_pcap"`
+	// InputLive captures from a network interface, filtered like InputPcap
+	InputLive InputLiveConfig `json:"input_live"`
+	// InputBinlog reads the writes of a MySQL binary log
+	InputBinlog InputBinlogConfig `json:"input_binlog"`
+	// InputPtDigest reads the query classes of a pt-query-digest report
+	InputPtDigest InputPtDigestConfig `json:"input_pt_digest"`
+	// InputVtgateQueryLog reads the query log of a Vitess VTGate
+	InputVtgateQueryLog InputVtgateQueryLogConfig `json:"input_vtgate_querylog"`
+	// InputAuditLog reads the audit logs of RDS, Aurora and Azure MySQL
+	InputAuditLog InputAuditLogConfig `json:"input_audit_log"`
+	// InputSlowLog reads a MySQL or Percona Server slow query log
+	InputSlowLog InputSlowLogConfig `json:"input_slowlog"`
 
 	Output      OutputCommonConfig `json:"output"`
 	OutputCache OutputCacheConfig  `json:"output_cache"`
 	OutputDB    OutputDBConfig     `json:"output_db"`
+	// OutputStdout prints the processed queries, for a look before a load
+	OutputStdout OutputStdoutConfig `json:"output_stdout"`
 
 	Processor ProcessorConfig `json:"processor"`
+
+	// ErrorPolicy decides what the recoverable errors of every stage do to
+	// the run
+	ErrorPolicy ErrorPolicyConfig `json:"error_policy"`
+
+	// SummaryFile, when set, receives the extraction summary as JSON.
+	SummaryFile string `json:"summary_file"`
+
+	// AuditFile, when set, enables the anonymization audit and receives its
+	// report as JSON.
+	AuditFile string `json:"audit_file"`
+
+	// MetricsAddr, when set, serves Prometheus metrics of the pipeline.
+	MetricsAddr string `json:"metrics_addr"`
+
+	// Daemon runs the live capture continuously instead of a single pass.
+	Daemon DaemonConfig `json:"daemon"`
 }
 
 // New creates a new Config with default values
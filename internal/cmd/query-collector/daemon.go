@@ -0,0 +1,466 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+type DaemonConfig struct {
+	Enabled bool `json:"enabled"`
+	// Interface is the network interface captured by tcpdump
+	Interface string `json:"interface"`
+	// Filter is the BPF filter of the capture
+	Filter string `json:"filter"`
+	// Dir receives the pcap segments, their processed output and the
+	// latest.pcap/latest.cache links to the newest published segment
+	Dir string `json:"dir"`
+	// Rotate is how often the capture starts a new segment, which bounds
+	// how stale the published corpus gets
+	Rotate time.Duration `json:"rotate"`
+	// Keep is the number of processed segments kept, 0 keeps all of them
+	Keep int `json:"keep"`
+	// Addr serves /healthz, /stats and /metrics
+	Addr  string      `json:"addr"`
+	Drift DriftConfig `json:"drift"`
+}
+
+const (
+	segmentPrefix = "segment-"
+	// segmentTimeLayout is the strftime pattern tcpdump names segments with
+	segmentTimeLayout = "%Y%m%d%H%M%S"
+	// doneSuffix marks a segment whose output was fully written
+	doneSuffix = ".done"
+	// daemonPollInterval is how often the daemon looks for completed segments
+	daemonPollInterval = 10 * time.Second
+	// captureRestartDelay is the pause before a failed capture is restarted
+	captureRestartDelay = 5 * time.Second
+)
+
+var (
+	segmentsProcessedDesc = prometheus.NewDesc(metricsNamespace+"_daemon_segments_processed_total",
+		"Capture segments run through the pipeline.", nil, nil)
+	segmentsFailedDesc = prometheus.NewDesc(metricsNamespace+"_daemon_segments_failed_total",
+		"Capture segments the pipeline failed on.", nil, nil)
+	daemonExtractedDesc = prometheus.NewDesc(metricsNamespace+"_daemon_queries_extracted_total",
+		"Queries extracted from every processed segment.", nil, nil)
+	lastPublishDesc = prometheus.NewDesc(metricsNamespace+"_daemon_last_publish_timestamp_seconds",
+		"Time the latest segment was published.", nil, nil)
+	captureRunningDesc = prometheus.NewDesc(metricsNamespace+"_daemon_capture_running",
+		"Whether the live capture is running.", nil, nil)
+)
+
+// Daemon captures live traffic into rotating pcap segments and runs every
+// completed segment through the collect pipeline, so the published corpus is
+// at most one rotation stale. The db output is never truncated and is
+// updated incrementally, the cache output is written next to each segment.
+type Daemon struct {
+	cfg   *AppConfig
+	drift *DriftDetector
+
+	captureRunning atomic.Bool
+
+	mu                sync.Mutex
+	segmentsProcessed uint64
+	segmentsFailed    uint64
+	extracted         uint64
+	lastSegment       string
+	lastPublish       time.Time
+	lastError         string
+}
+
+func NewDaemon(cfg *AppConfig) *Daemon {
+	return &Daemon{cfg: cfg}
+}
+
+// DaemonStats is the body of /stats
+type DaemonStats struct {
+	CaptureRunning    bool      `json:"capture_running"`
+	SegmentsProcessed uint64    `json:"segments_processed"`
+	SegmentsFailed    uint64    `json:"segments_failed"`
+	QueriesExtracted  uint64    `json:"queries_extracted"`
+	LastSegment       string    `json:"last_segment,omitempty"`
+	LastPublish       time.Time `json:"last_publish,omitzero"`
+	StaleSeconds      float64   `json:"stale_seconds,omitempty"`
+	LastError         string    `json:"last_error,omitempty"`
+	// Drift is the divergence of the latest window from the baseline, unset
+	// until both are known
+	Drift      *float64 `json:"drift,omitempty"`
+	DriftAlert bool     `json:"drift_alert"`
+}
+
+func (d *Daemon) Stats() DaemonStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	stats := DaemonStats{
+		CaptureRunning:    d.captureRunning.Load(),
+		SegmentsProcessed: d.segmentsProcessed,
+		SegmentsFailed:    d.segmentsFailed,
+		QueriesExtracted:  d.extracted,
+		LastSegment:       d.lastSegment,
+		LastPublish:       d.lastPublish,
+		LastError:         d.lastError,
+	}
+	if !d.lastPublish.IsZero() {
+		stats.StaleSeconds = time.Since(d.lastPublish).Seconds()
+	}
+	if d.drift != nil {
+		if drift, ok, alert := d.drift.Latest(); ok {
+			stats.Drift, stats.DriftAlert = &drift, alert
+		}
+	}
+	return stats
+}
+
+func (d *Daemon) Run() error {
+	cfg := d.cfg.Daemon
+	if cfg.Interface == "" {
+		return fmt.Errorf("daemon.interface is required in daemon mode")
+	}
+	if cfg.Rotate < time.Minute {
+		return fmt.Errorf("daemon.rotate must be at least 1m")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return fmt.Errorf("error creating daemon directory: %w", err)
+	}
+	drift, err := NewDriftDetector(cfg.Drift)
+	if err != nil {
+		return err
+	}
+	d.drift = drift
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
+
+	if cfg.Addr != "" {
+		if err := d.serve(ctx, cfg.Addr); err != nil {
+			return err
+		}
+	}
+
+	go d.capture(ctx)
+
+	ticker := time.NewTicker(daemonPollInterval)
+	defer ticker.Stop()
+	for {
+		d.processSegments(ctx)
+		select {
+		case <-ctx.Done():
+			fmt.Println("Received SIGTERM/SIGINT, exiting...")
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// capture runs tcpdump until ctx is done, restarting it when it exits
+func (d *Daemon) capture(ctx context.Context) {
+	cfg := d.cfg.Daemon
+	args := []string{
+		"-i", cfg.Interface,
+		"-s", "0",
+		"-U",
+		"-G", strconv.Itoa(int(cfg.Rotate.Seconds())),
+		"-w", filepath.Join(cfg.Dir, segmentPrefix+segmentTimeLayout+".pcap"),
+	}
+	if cfg.Filter != "" {
+		args = append(args, cfg.Filter)
+	}
+
+	for ctx.Err() == nil {
+		cmd := exec.CommandContext(ctx, "tcpdump", args...)
+		cmd.Stderr = os.Stderr
+		fmt.Printf("Starting capture: tcpdump %s\n", strings.Join(args, " "))
+		d.captureRunning.Store(true)
+		err := cmd.Run()
+		d.captureRunning.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		d.setError(fmt.Errorf("capture exited: %w", err))
+		select {
+		case <-ctx.Done():
+		case <-time.After(captureRestartDelay):
+		}
+	}
+}
+
+// completedSegments lists the segments tcpdump moved past, oldest first. The
+// newest segment is still being written.
+func (d *Daemon) completedSegments() ([]string, error) {
+	segments, err := filepath.Glob(filepath.Join(d.cfg.Daemon.Dir, segmentPrefix+"*.pcap"))
+	if err != nil {
+		return nil, err
+	}
+	// the timestamped names sort chronologically
+	sort.Strings(segments)
+	if len(segments) == 0 {
+		return nil, nil
+	}
+	return segments[:len(segments)-1], nil
+}
+
+func (d *Daemon) processSegments(ctx context.Context) {
+	segments, err := d.completedSegments()
+	if err != nil {
+		d.setError(fmt.Errorf("error listing segments: %w", err))
+		return
+	}
+	for _, segment := range segments {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := os.Stat(segment + doneSuffix); err == nil {
+			continue
+		}
+		if err := d.processSegment(ctx, segment); err != nil {
+			d.mu.Lock()
+			d.segmentsFailed++
+			d.mu.Unlock()
+			d.setError(err)
+			continue
+		}
+	}
+	if err := d.prune(segments); err != nil {
+		d.setError(err)
+	}
+}
+
+func segmentOutput(segment string) string {
+	return strings.TrimSuffix(segment, ".pcap") + ".cache"
+}
+
+func segmentAudit(segment string) string {
+	return strings.TrimSuffix(segment, ".pcap") + ".audit.json"
+}
+
+func segmentDeadLetter(segment string) string {
+	return strings.TrimSuffix(segment, ".pcap") + ".dead-letter.ndjson"
+}
+
+// processSegment runs the pipeline over segment and publishes its output
+func (d *Daemon) processSegment(ctx context.Context, segment string) error {
+	fmt.Printf("Processing segment %s\n", segment)
+	cfg := *d.cfg
+	cfg.Input.Type = "pcap"
+	cfg.Input.Encoding = "plain"
+	cfg.InputPcap.File = segment
+	cfg.OutputCache.File = segmentOutput(segment)
+	cfg.OutputDB.Truncate = false
+	// a segment interrupted by a restart is loaded again, the batch ledger
+	// skips what was committed before
+	cfg.OutputDB.Resume = true
+	// the daemon serves the metrics of every segment
+	cfg.MetricsAddr = ""
+	cfg.SummaryFile = ""
+	if cfg.AuditFile != "" {
+		cfg.AuditFile = segmentAudit(segment)
+	}
+	if cfg.ErrorPolicy.DeadLetterFile != "" {
+		cfg.ErrorPolicy.DeadLetterFile = segmentDeadLetter(segment)
+	}
+
+	summary := NewExtractionSummary()
+	summary.TrackFingerprints()
+	if err := NewImportCmd(&cfg).collect(ctx, summary, nil); err != nil {
+		return fmt.Errorf("error processing segment %s: %w", segment, err)
+	}
+	if ctx.Err() != nil {
+		// interrupted, the segment is processed again on the next start
+		return nil
+	}
+
+	if cfg.Output.Type == "cache" {
+		if err := publish(segment, filepath.Join(cfg.Daemon.Dir, "latest.pcap")); err != nil {
+			return err
+		}
+		if err := publish(cfg.OutputCache.File, filepath.Join(cfg.Daemon.Dir, "latest.cache")); err != nil {
+			return err
+		}
+	}
+	if err := os.WriteFile(segment+doneSuffix, nil, 0o644); err != nil {
+		return fmt.Errorf("error marking segment %s done: %w", segment, err)
+	}
+
+	d.observeDrift(segment, summary.FingerprintCounts())
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.segmentsProcessed++
+	d.extracted += summary.Snapshot().Extracted
+	d.lastSegment = segment
+	d.lastPublish = time.Now()
+	return nil
+}
+
+// observeDrift compares the segment to the baseline and warns when the drift
+// alert starts firing
+func (d *Daemon) observeDrift(segment string, counts map[uint64]uint64) {
+	wasAlerting := d.drift.Alerting()
+	drift, ok := d.drift.Observe(counts)
+	if !ok {
+		return
+	}
+	fmt.Printf("Workload drift after %s: %.4f\n", segment, drift)
+	if d.drift.Alerting() && !wasAlerting {
+		fmt.Fprintf(os.Stderr, "warning: workload drift %.4f exceeds %.4f, the load test corpus no longer represents the traffic\n", drift, d.cfg.Daemon.Drift.Threshold)
+	}
+}
+
+// publish atomically points the link at target
+func publish(target, link string) error {
+	tmp := link + ".tmp"
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Base(target), tmp); err != nil {
+		return fmt.Errorf("error linking %s: %w", link, err)
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		return fmt.Errorf("error publishing %s: %w", link, err)
+	}
+	return nil
+}
+
+// prune removes the processed segments beyond the retention
+func (d *Daemon) prune(segments []string) error {
+	keep := d.cfg.Daemon.Keep
+	if keep <= 0 {
+		return nil
+	}
+	var processed []string
+	for _, segment := range segments {
+		if _, err := os.Stat(segment + doneSuffix); err == nil {
+			processed = append(processed, segment)
+		}
+	}
+	if len(processed) <= keep {
+		return nil
+	}
+	for _, segment := range processed[:len(processed)-keep] {
+		for _, path := range []string{segment, segmentOutput(segment), segmentAudit(segment), segmentDeadLetter(segment), segment + doneSuffix} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("error removing %s: %w", path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Daemon) setError(err error) {
+	fmt.Fprintf(os.Stderr, "error: %v\n", err)
+	d.mu.Lock()
+	d.lastError = err.Error()
+	d.mu.Unlock()
+}
+
+// healthy reports whether the capture runs and a segment was published within
+// two rotations, once the first one had time to complete
+func (d *Daemon) healthy(started time.Time) (bool, string) {
+	stats := d.Stats()
+	if !stats.CaptureRunning {
+		return false, "capture not running"
+	}
+	maxStale := 2 * d.cfg.Daemon.Rotate
+	if stats.LastPublish.IsZero() {
+		if time.Since(started) > maxStale {
+			return false, "no segment published"
+		}
+		return true, "ok"
+	}
+	if time.Since(stats.LastPublish) > maxStale {
+		return false, "corpus stale"
+	}
+	return true, "ok"
+}
+
+// Describe lists every descriptor, Collect leaves some out until they have
+// a value
+func (d *Daemon) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		segmentsProcessedDesc, segmentsFailedDesc, daemonExtractedDesc,
+		lastPublishDesc, captureRunningDesc, driftDesc, driftAlertDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (d *Daemon) Collect(ch chan<- prometheus.Metric) {
+	stats := d.Stats()
+	ch <- prometheus.MustNewConstMetric(segmentsProcessedDesc, prometheus.CounterValue, float64(stats.SegmentsProcessed))
+	ch <- prometheus.MustNewConstMetric(segmentsFailedDesc, prometheus.CounterValue, float64(stats.SegmentsFailed))
+	ch <- prometheus.MustNewConstMetric(daemonExtractedDesc, prometheus.CounterValue, float64(stats.QueriesExtracted))
+	if !stats.LastPublish.IsZero() {
+		ch <- prometheus.MustNewConstMetric(lastPublishDesc, prometheus.GaugeValue, float64(stats.LastPublish.Unix()))
+	}
+	running := 0.0
+	if stats.CaptureRunning {
+		running = 1
+	}
+	ch <- prometheus.MustNewConstMetric(captureRunningDesc, prometheus.GaugeValue, running)
+	if d.drift != nil {
+		d.drift.Collect(ch)
+	}
+}
+
+// serve starts an HTTP server exposing /healthz, /stats and /metrics on addr
+// until ctx is done
+func (d *Daemon) serve(ctx context.Context, addr string) error {
+	started := time.Now()
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		d,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		ok, msg := d.healthy(started)
+		if !ok {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		fmt.Fprintln(w, msg)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(d.Stats())
+	})
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+	server := &http.Server{
+		Handler:     mux,
+		ReadTimeout: 10 * time.Second,
+	}
+
+	go server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving daemon health on http://%s/healthz\n", listener.Addr())
+	return nil
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+
+	"mysql-load-test/pkg/query"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type DriftConfig struct {
+	// Baseline is the cache file of the corpus the load tests replay. Without
+	// it the first window processed after the start is the baseline.
+	Baseline string `json:"baseline"`
+	// Window is the number of latest segments compared to the baseline
+	Window int `json:"window"`
+	// Threshold is the Jensen-Shannon divergence, between 0 and 1, above
+	// which the drift alert fires, 0 disables the alert
+	Threshold float64 `json:"threshold"`
+}
+
+var (
+	driftDesc = prometheus.NewDesc(metricsNamespace+"_daemon_workload_drift",
+		"Jensen-Shannon divergence between the fingerprint weights of the latest window and the baseline.", nil, nil)
+	driftAlertDesc = prometheus.NewDesc(metricsNamespace+"_daemon_workload_drift_alert",
+		"Whether the workload drift exceeds the threshold.", nil, nil)
+)
+
+// fingerprintCounts is the number of queries per fingerprint hash
+type fingerprintCounts map[uint64]uint64
+
+// DriftDetector compares the fingerprint weights of the latest segments to a
+// baseline, telling when the saved corpus no longer represents the traffic
+type DriftDetector struct {
+	cfg DriftConfig
+
+	mu       sync.Mutex
+	baseline fingerprintCounts
+	window   []fingerprintCounts
+	drift    float64
+	computed bool
+}
+
+func NewDriftDetector(cfg DriftConfig) (*DriftDetector, error) {
+	if cfg.Window <= 0 {
+		cfg.Window = 1
+	}
+	if cfg.Threshold < 0 || cfg.Threshold > 1 {
+		return nil, fmt.Errorf("daemon.drift.threshold must be between 0 and 1")
+	}
+	d := &DriftDetector{cfg: cfg}
+	if cfg.Baseline != "" {
+		baseline, err := readCacheCounts(cfg.Baseline)
+		if err != nil {
+			return nil, err
+		}
+		if len(baseline) == 0 {
+			return nil, fmt.Errorf("drift baseline %s holds no queries", cfg.Baseline)
+		}
+		d.baseline = baseline
+	}
+	return d, nil
+}
+
+// readCacheCounts counts the queries per fingerprint of a cache file
+func readCacheCounts(path string) (fingerprintCounts, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening drift baseline: %w", err)
+	}
+	defer f.Close()
+	r, err := query.NewCacheReader(f)
+	if err != nil {
+		return nil, err
+	}
+	counts := make(fingerprintCounts)
+	var q query.Query
+	for {
+		if err := r.Read(&q); err != nil {
+			if errors.Is(err, io.EOF) {
+				return counts, nil
+			}
+			return nil, fmt.Errorf("error reading drift baseline: %w", err)
+		}
+		counts[q.FingerprintHash]++
+	}
+}
+
+// Observe adds the counts of a processed segment and returns the drift of
+// the latest window, ok is false until there is a baseline to compare to
+func (d *DriftDetector) Observe(counts map[uint64]uint64) (drift float64, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(counts) == 0 {
+		return d.drift, d.computed
+	}
+	d.window = append(d.window, counts)
+	if len(d.window) > d.cfg.Window {
+		d.window = d.window[1:]
+	}
+	if d.baseline == nil {
+		if len(d.window) < d.cfg.Window {
+			return 0, false
+		}
+		d.baseline = mergeCounts(d.window)
+		d.window = nil
+		return 0, false
+	}
+	d.drift = jensenShannon(d.baseline, mergeCounts(d.window))
+	d.computed = true
+	return d.drift, true
+}
+
+// Latest returns the drift of the latest window and whether it exceeds the
+// threshold, ok is false until a drift was computed
+func (d *DriftDetector) Latest() (drift float64, ok, alert bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	alert = d.computed && d.cfg.Threshold > 0 && d.drift > d.cfg.Threshold
+	return d.drift, d.computed, alert
+}
+
+// Alerting reports whether the latest drift exceeds the threshold
+func (d *DriftDetector) Alerting() bool {
+	_, _, alert := d.Latest()
+	return alert
+}
+
+func (d *DriftDetector) Collect(ch chan<- prometheus.Metric) {
+	drift, ok, alert := d.Latest()
+	if !ok {
+		return
+	}
+	alertValue := 0.0
+	if alert {
+		alertValue = 1
+	}
+	ch <- prometheus.MustNewConstMetric(driftDesc, prometheus.GaugeValue, drift)
+	ch <- prometheus.MustNewConstMetric(driftAlertDesc, prometheus.GaugeValue, alertValue)
+}
+
+func mergeCounts(window []fingerprintCounts) fingerprintCounts {
+	merged := make(fingerprintCounts)
+	for _, counts := range window {
+		for hash, n := range counts {
+			merged[hash] += n
+		}
+	}
+	return merged
+}
+
+// jensenShannon is the Jensen-Shannon divergence of the weight distributions
+// of p and q in bits: 0 when identical, 1 when they share no fingerprint
+func jensenShannon(p, q fingerprintCounts) float64 {
+	var totalP, totalQ float64
+	for _, n := range p {
+		totalP += float64(n)
+	}
+	for _, n := range q {
+		totalQ += float64(n)
+	}
+	if totalP == 0 || totalQ == 0 {
+		return 0
+	}
+
+	// sum over the union of fingerprints, each term is
+	// (pi*log(pi/mi) + qi*log(qi/mi)) / 2 with mi the mean of pi and qi
+	var divergence float64
+	term := func(a, b float64) float64 {
+		m := (a + b) / 2
+		var t float64
+		if a > 0 {
+			t += a * math.Log2(a/m)
+		}
+		if b > 0 {
+			t += b * math.Log2(b/m)
+		}
+		return t / 2
+	}
+	for hash, n := range p {
+		divergence += term(float64(n)/totalP, float64(q[hash])/totalQ)
+	}
+	for hash, n := range q {
+		if _, ok := p[hash]; !ok {
+			divergence += term(0, float64(n)/totalQ)
+		}
+	}
+	return min(max(divergence, 0), 1)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// kindError tags an error with a short, stable kind used to aggregate
+// similar errors, e.g. all timestamp parse failures.
+type kindError struct {
+	kind string
+	err  error
+}
+
+func newKindError(kind string, format string, args ...any) error {
+	return &kindError{
+		kind: kind,
+		err:  fmt.Errorf(format, args...),
+	}
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() error {
+	return e.err
+}
+
+func errorKind(err error) string {
+	var ke *kindError
+	if errors.As(err, &ke) {
+		return ke.kind
+	}
+	return "other"
+}
+
+type errorKindLog struct {
+	lastLogged time.Time
+	suppressed uint64
+	lastErr    error
+}
+
+// rateLimitedErrorLog writes at most one sample per error kind per interval,
+// reporting how many errors of that kind were suppressed in between. Counts
+// still pending when errors stop coming are flushed every interval and on
+// Close.
+type rateLimitedErrorLog struct {
+	out      io.Writer
+	interval time.Duration
+	prefix   string
+
+	mu    sync.Mutex
+	kinds map[string]*errorKindLog
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newRateLimitedErrorLog(out io.Writer, prefix string, interval time.Duration) *rateLimitedErrorLog {
+	l := &rateLimitedErrorLog{
+		out:      out,
+		interval: interval,
+		prefix:   prefix,
+		kinds:    make(map[string]*errorKindLog),
+		done:     make(chan struct{}),
+	}
+	if interval > 0 {
+		go l.flushLoop()
+	}
+	return l
+}
+
+func (l *rateLimitedErrorLog) flushLoop() {
+	ticker := time.NewTicker(l.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case now := <-ticker.C:
+			l.flush(now, false)
+		}
+	}
+}
+
+// flush reports the kinds with suppressed errors whose interval has passed,
+// or all of them when force is set.
+func (l *rateLimitedErrorLog) flush(now time.Time, force bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for kind, k := range l.kinds {
+		if k.suppressed == 0 || (!force && now.Sub(k.lastLogged) < l.interval) {
+			continue
+		}
+		fmt.Fprintf(l.out, "%s (%s): %d similar errors suppressed, last: %v\n", l.prefix, kind, k.suppressed, k.lastErr)
+		k.lastLogged = now
+		k.suppressed = 0
+		k.lastErr = nil
+	}
+}
+
+// Close stops the periodic flush and reports the remaining suppressed counts.
+func (l *rateLimitedErrorLog) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.flush(time.Now(), true)
+	})
+}
+
+func (l *rateLimitedErrorLog) Log(err error) {
+	kind := errorKind(err)
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	k, ok := l.kinds[kind]
+	if !ok {
+		k = &errorKindLog{}
+		l.kinds[kind] = k
+	}
+	if ok && now.Sub(k.lastLogged) < l.interval {
+		k.suppressed++
+		k.lastErr = err
+		return
+	}
+
+	if k.suppressed > 0 {
+		fmt.Fprintf(l.out, "%s (%s): %v (%d similar errors suppressed)\n", l.prefix, kind, err, k.suppressed)
+	} else {
+		fmt.Fprintf(l.out, "%s (%s): %v\n", l.prefix, kind, err)
+	}
+	k.lastLogged = now
+	k.suppressed = 0
+	k.lastErr = nil
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"mysql-load-test/pkg/query"
+)
+
+const (
+	// ErrorPolicyFailFast stops the run on the first recoverable error
+	ErrorPolicyFailFast = "fail-fast"
+	// ErrorPolicySkip drops the failed record and counts it, the default
+	ErrorPolicySkip = "skip-and-count"
+	// ErrorPolicyDeadLetter drops the failed record and keeps it in the
+	// dead letter file
+	ErrorPolicyDeadLetter = "dead-letter"
+)
+
+// ErrorPolicyConfig decides what a recoverable error of any stage does to
+// the run: a line that fails to parse, a query that fails to normalize or a
+// batch the database rejects. Errors of the stage itself, like an unreadable
+// input file, always stop the run.
+type ErrorPolicyConfig struct {
+	Mode string `json:"mode"`
+	// DeadLetterFile receives the failed records as NDJSON in dead-letter
+	// mode
+	DeadLetterFile string `json:"dead_letter_file"`
+}
+
+type ErrorStage int
+
+const (
+	StageInput ErrorStage = iota
+	StageProcessor
+	StageOutput
+	numErrorStages
+)
+
+var errorStageNames = [numErrorStages]string{
+	StageInput:     "input",
+	StageProcessor: "processor",
+	StageOutput:    "output",
+}
+
+func (s ErrorStage) String() string {
+	return errorStageNames[s]
+}
+
+type ErrorOutcome int
+
+const (
+	ErrorSkipped ErrorOutcome = iota
+	ErrorDeadLettered
+	ErrorFailed
+	numErrorOutcomes
+)
+
+var errorOutcomeNames = [numErrorOutcomes]string{
+	ErrorSkipped:      "skipped",
+	ErrorDeadLettered: "dead_lettered",
+	ErrorFailed:       "failed",
+}
+
+func (o ErrorOutcome) String() string {
+	return errorOutcomeNames[o]
+}
+
+// failedRecord is an input record, or the query extracted from it, that
+// an error dropped
+type failedRecord struct {
+	offset uint64
+	raw    []byte
+}
+
+// recordError ties an error to the records it dropped, so the outcomes are
+// counted by record and the dead letter file can keep them
+type recordError struct {
+	err     error
+	records []failedRecord
+}
+
+func withRecord(err error, offset uint64, raw []byte) error {
+	return &recordError{err: err, records: []failedRecord{{offset: offset, raw: raw}}}
+}
+
+// withQueries ties err to every query of a batch
+func withQueries(err error, queries []*query.Query) error {
+	records := make([]failedRecord, len(queries))
+	for i, q := range queries {
+		records[i] = failedRecord{offset: q.Offset, raw: q.Raw}
+	}
+	return &recordError{err: err, records: records}
+}
+
+func (e *recordError) Error() string {
+	return e.err.Error()
+}
+
+func (e *recordError) Unwrap() error {
+	return e.err
+}
+
+// deadLetter is a line of the dead letter file
+type deadLetter struct {
+	Stage  string  `json:"stage"`
+	Kind   string  `json:"kind"`
+	Error  string  `json:"error"`
+	Offset *uint64 `json:"offset,omitempty"`
+	Record string  `json:"record,omitempty"`
+}
+
+// ErrorPolicy applies ErrorPolicyConfig to the errors of every stage and
+// counts their outcomes in the summary
+type ErrorPolicy struct {
+	mode    string
+	summary *ExtractionSummary
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+}
+
+func NewErrorPolicy(cfg ErrorPolicyConfig, summary *ExtractionSummary) (*ErrorPolicy, error) {
+	p := &ErrorPolicy{mode: cfg.Mode, summary: summary}
+	switch cfg.Mode {
+	case "":
+		p.mode = ErrorPolicySkip
+	case ErrorPolicyFailFast, ErrorPolicySkip:
+	case ErrorPolicyDeadLetter:
+		if cfg.DeadLetterFile == "" {
+			return nil, fmt.Errorf("error policy %s needs a dead letter file", cfg.Mode)
+		}
+		file, err := os.OpenFile(cfg.DeadLetterFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening dead letter file: %w", err)
+		}
+		p.file, p.writer = file, bufio.NewWriter(file)
+	default:
+		return nil, fmt.Errorf("unsupported error policy: %s", cfg.Mode)
+	}
+	return p, nil
+}
+
+// Handle applies the policy to a recoverable error of stage. It returns the
+// error the run must stop with, nil when the run goes on.
+func (p *ErrorPolicy) Handle(stage ErrorStage, err error) error {
+	var records []failedRecord
+	var re *recordError
+	if errors.As(err, &re) {
+		records = re.records
+	}
+	n := uint64(max(1, len(records)))
+
+	switch p.mode {
+	case ErrorPolicyFailFast:
+		p.summary.StageError(stage, ErrorFailed, n)
+		return fmt.Errorf("%s error with error policy %s: %w", stage, p.mode, err)
+	case ErrorPolicyDeadLetter:
+		if werr := p.deadLetter(stage, err, records); werr != nil {
+			p.summary.StageError(stage, ErrorFailed, n)
+			return fmt.Errorf("error writing dead letter: %w", werr)
+		}
+		p.summary.StageError(stage, ErrorDeadLettered, n)
+	default:
+		p.summary.StageError(stage, ErrorSkipped, n)
+	}
+	return nil
+}
+
+// deadLetter writes a line per record, or a single line without record
+func (p *ErrorPolicy) deadLetter(stage ErrorStage, err error, records []failedRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	line := deadLetter{Stage: stage.String(), Kind: errorKind(err), Error: err.Error()}
+	if len(records) == 0 {
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+	for _, r := range records {
+		line.Offset, line.Record = &r.offset, string(r.raw)
+		if err := enc.Encode(line); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, werr := p.writer.Write(buf.Bytes())
+	return werr
+}
+
+// Close flushes the dead letter file
+func (p *ErrorPolicy) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.writer.Flush(); err != nil {
+		p.file.Close()
+		return fmt.Errorf("error flushing dead letter file: %w", err)
+	}
+	if err := p.file.Close(); err != nil {
+		return fmt.Errorf("error closing dead letter file: %w", err)
+	}
+	return nil
+}
@@ -22,15 +22,27 @@ type InputCommonConfig struct {
 }
 
 type InputCommon struct {
-	cfg InputCommonConfig
+	cfg     InputCommonConfig
+	summary *ExtractionSummary
+	errors  *ErrorPolicy
 }
 
-func NewInputCommon(cfg InputCommonConfig) *InputCommon {
+func NewInputCommon(cfg InputCommonConfig, summary *ExtractionSummary, errors *ErrorPolicy) *InputCommon {
 	return &InputCommon{
-		cfg: cfg,
+		cfg:     cfg,
+		summary: summary,
+		errors:  errors,
 	}
 }
 
+// SkipRecord counts a record that failed to parse and applies the error
+// policy, the returned error stops the input
+func (i *InputCommon) SkipRecord(err error, offset int64, record []byte) error {
+	i.summary.Skip(SkipParseError)
+	i.summary.ParseError(errorKind(err))
+	return i.errors.Handle(StageInput, withRecord(err, uint64(offset), record))
+}
+
 func (i *InputCommon) WrapReader(r io.Reader) (io.Reader, error) {
 	var reader io.Reader
 
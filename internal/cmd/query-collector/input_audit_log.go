@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mysql-load-test/pkg/query"
+)
+
+// InputAuditLogConfig reads the audit logs of managed MySQL, where the
+// traffic can't be captured on the server. The format is detected per line:
+//   - RDS for MySQL and MariaDB (MARIADB_AUDIT_PLUGIN) CSV lines
+//   - Aurora MySQL advanced auditing CSV lines, timestamped in microseconds
+//   - Azure Database for MySQL audit records in JSON (MySqlAuditLogs)
+//
+// Lines of CloudWatch Logs exports keep their leading timestamp, it is
+// stripped. Only queries that succeeded are collected.
+type InputAuditLogConfig struct {
+	File string
+	// ErrorLogInterval is the minimum time between two logged samples of
+	// the same kind of parse error.
+	ErrorLogInterval time.Duration
+}
+
+const (
+	// timestamp, serverhost, username, host, connectionid, queryid,
+	// operation, database come before the object and the return code
+	auditLogFixedColumns = 8
+	auditLogOperation    = 6
+
+	auditLogRDSTimeLayout = "20060102 15:04:05"
+)
+
+// cloudWatchPrefixRe matches the timestamp CloudWatch Logs exports put
+// before every event
+var cloudWatchPrefixRe = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?Z `)
+
+// azureAuditRecord is an audit record of Azure Database for MySQL. Exports
+// to Log Analytics suffix the properties with their type.
+type azureAuditRecord struct {
+	Time       string `json:"time"`
+	Properties struct {
+		EventClass     string `json:"event_class"`
+		EventClassS    string `json:"event_class_s"`
+		EventSubclass  string `json:"event_subclass"`
+		EventSubclassS string `json:"event_subclass_s"`
+		SQLText        string `json:"sql_text"`
+		SQLTextS       string `json:"sql_text_s"`
+	} `json:"properties"`
+}
+
+type InputAuditLog struct {
+	cfg     InputAuditLogConfig
+	reader  io.Reader
+	closers []io.Closer
+	common  *InputCommon
+	errLog  *rateLimitedErrorLog
+}
+
+func NewInputAuditLog(cfg InputAuditLogConfig, common *InputCommon) (*InputAuditLog, error) {
+	file, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+
+	closers := []io.Closer{file}
+
+	r, err := common.WrapReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping reader: %w", err)
+	}
+
+	return &InputAuditLog{
+		cfg:     cfg,
+		reader:  r,
+		closers: closers,
+		common:  common,
+		errLog:  newRateLimitedErrorLog(os.Stderr, "error parsing audit log line, skipping", cfg.ErrorLogInterval),
+	}, nil
+}
+
+func (i *InputAuditLog) StartExtractor(ctx context.Context, outChan chan<- *query.Query) error {
+	br := bufio.NewReaderSize(i.reader, 1<<20)
+	var offset int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		lineStart := offset
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading file: %w", err)
+		}
+		offset += int64(len(line))
+		i.common.summary.RecordRead()
+
+		q, skip, parseErr := parseAuditLogLine(bytes.TrimSpace(line))
+		switch {
+		case parseErr != nil:
+			i.errLog.Log(parseErr)
+			if err := i.common.SkipRecord(parseErr, lineStart, line); err != nil {
+				return err
+			}
+		case q == nil:
+			i.common.summary.Skip(skip)
+		default:
+			i.common.summary.Extracted()
+			q.Offset = uint64(lineStart)
+			q.Length = uint64(len(line))
+			outChan <- q
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (i *InputAuditLog) Destroy() error {
+	i.errLog.Close()
+
+	var errs []error
+
+	for _, closer := range i.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error closing input audit log: %w", errs[0])
+	}
+
+	return nil
+}
+
+// parseAuditLogLine returns the query of a line, or the reason to skip it
+// when the line holds another event
+func parseAuditLogLine(line []byte) (*query.Query, SkipReason, error) {
+	if loc := cloudWatchPrefixRe.FindIndex(line); loc != nil {
+		line = line[loc[1]:]
+	}
+	if len(line) == 0 {
+		return nil, SkipNoPayload, nil
+	}
+	if line[0] == '{' {
+		return parseAzureAuditRecord(line)
+	}
+	return parseAuditLogCSV(string(line))
+}
+
+// parseAuditLogCSV parses a line of the MariaDB audit plugin as RDS and
+// Aurora write it, the quoted object may contain commas
+func parseAuditLogCSV(line string) (*query.Query, SkipReason, error) {
+	columns := strings.SplitN(line, ",", auditLogFixedColumns+1)
+	if len(columns) <= auditLogFixedColumns {
+		return nil, 0, newKindError("invalid_line_format", "expected %d comma separated columns, got %d", auditLogFixedColumns+2, len(columns))
+	}
+	if !strings.HasPrefix(columns[auditLogOperation], "QUERY") {
+		// CONNECT, DISCONNECT, TABLE and the like
+		return nil, SkipNotComQuery, nil
+	}
+
+	rest := columns[auditLogFixedColumns]
+	comma := strings.LastIndexByte(rest, ',')
+	if comma < 0 {
+		return nil, 0, newKindError("invalid_line_format", "missing return code")
+	}
+	object, retcode := rest[:comma], strings.TrimSpace(rest[comma+1:])
+	if retcode != "0" {
+		return nil, SkipFiltered, nil
+	}
+	if len(object) < 2 || object[0] != '\'' || object[len(object)-1] != '\'' {
+		return nil, 0, newKindError("invalid_object", "query is not quoted")
+	}
+	text, err := unescapeBackslashes(object[1 : len(object)-1])
+	if err != nil {
+		return nil, 0, newKindError("invalid_object", "error unescaping query: %w", err)
+	}
+
+	q := &query.Query{Raw: []byte(text)}
+	if t, ok := parseAuditLogTimestamp(columns[0]); ok {
+		q.Timestamp = uint64(t.Unix())
+	}
+	return q, 0, nil
+}
+
+// parseAuditLogTimestamp reads the "20240601 10:00:00" of RDS or the
+// microseconds since the epoch of Aurora
+func parseAuditLogTimestamp(s string) (time.Time, bool) {
+	if t, err := time.Parse(auditLogRDSTimeLayout, s); err == nil {
+		return t, true
+	}
+	if us, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMicro(us), true
+	}
+	return time.Time{}, false
+}
+
+func parseAzureAuditRecord(line []byte) (*query.Query, SkipReason, error) {
+	var record azureAuditRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return nil, 0, newKindError("invalid_json", "error decoding JSON record: %w", err)
+	}
+	p := record.Properties
+	class, subclass, text := firstNonEmpty(p.EventClass, p.EventClassS), firstNonEmpty(p.EventSubclass, p.EventSubclassS), firstNonEmpty(p.SQLText, p.SQLTextS)
+	// queries are logged by the general_log class, the connection and
+	// table access classes carry no replayable text
+	if class != "general_log" || (subclass != "" && subclass != "LOG") || strings.TrimSpace(text) == "" {
+		return nil, SkipNotComQuery, nil
+	}
+
+	q := &query.Query{Raw: []byte(text)}
+	if t, err := time.Parse(time.RFC3339Nano, record.Time); err == nil {
+		q.Timestamp = uint64(t.Unix())
+	}
+	return q, 0, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const rdsAuditLog = `20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,0,CONNECT,shop,,0
+20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY,shop,'SELECT * FROM orders WHERE note = \'a,b\'',0
+20240601 10:00:01,ip-10-0-0-1,app,10.0.0.5,12,346,QUERY,shop,'SELECT * FROM missing',1146
+
+2024-06-01T10:00:02.000Z 1717236002000000,ip-10-0-0-1,app,10.0.0.5,12,347,QUERY,shop,'UPDATE orders\nSET status = \'paid\'',0
+20240601 10:00:03,ip-10-0-0-1,app,10.0.0.5,12,348,QUERY,shop
+20240601 10:00:04,ip-10-0-0-1,app,10.0.0.5,12,349,QUERY,shop,SELECT 1,0
+{"time": "2024-06-01T10:00:05.5Z", "properties": {"event_class": "general_log", "event_subclass": "LOG", "sql_text": "SELECT 2"}}
+{"time": "2024-06-01T10:00:06Z"`
+
+func Test_InputAuditLogExtract(t *testing.T) {
+	common, summary := newTestInputCommon(t)
+	in := &InputAuditLog{
+		reader: strings.NewReader(rdsAuditLog),
+		common: common,
+		errLog: newRateLimitedErrorLog(io.Discard, "", 0),
+	}
+	defer in.errLog.Close()
+
+	queries, err := runExtractor(in.StartExtractor)
+	if err != nil {
+		t.Fatalf("StartExtractor() error = %v", err)
+	}
+
+	lines := strings.SplitAfter(rdsAuditLog, "\n")
+	lineOffset := func(n int) uint64 {
+		return uint64(len(strings.Join(lines[:n], "")))
+	}
+	want := []struct {
+		raw       string
+		line      int
+		timestamp uint64
+	}{
+		{`SELECT * FROM orders WHERE note = 'a,b'`, 1, 1717236000},
+		{"UPDATE orders\nSET status = 'paid'", 4, 1717236002},
+		{"SELECT 2", 7, 1717236005},
+	}
+	if len(queries) != len(want) {
+		t.Fatalf("StartExtractor() extracted %d queries, want %d", len(queries), len(want))
+	}
+	for n, w := range want {
+		q := queries[n]
+		if string(q.Raw) != w.raw || q.Timestamp != w.timestamp {
+			t.Errorf("query %d = %q @%d, want %q @%d", n, q.Raw, q.Timestamp, w.raw, w.timestamp)
+		}
+		if q.Offset != lineOffset(w.line) || q.Length != uint64(len(lines[w.line])) {
+			t.Errorf("query %d at %d+%d, want %d+%d", n, q.Offset, q.Length, lineOffset(w.line), len(lines[w.line]))
+		}
+	}
+
+	snap := summary.Snapshot()
+	skipped := map[SkipReason]uint64{SkipNotComQuery: 1, SkipFiltered: 1, SkipNoPayload: 1}
+	for reason, n := range skipped {
+		if got := snap.Skipped[reason.String()]; got != n {
+			t.Errorf("skipped %s = %d, want %d", reason, got, n)
+		}
+	}
+	parseErrors := map[string]uint64{"invalid_line_format": 1, "invalid_object": 1, "invalid_json": 1}
+	if !reflect.DeepEqual(snap.ParseErrors, parseErrors) {
+		t.Errorf("parse errors = %v, want %v", snap.ParseErrors, parseErrors)
+	}
+}
+
+func Test_ParseAuditLogLine(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		want      string
+		timestamp time.Time
+		skip      SkipReason
+		errKind   string
+	}{
+		{
+			name:      "rds",
+			line:      `20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY,shop,'SELECT \'it\'\'s\', "a\\b"',0`,
+			want:      `SELECT 'it''s', "a\b"`,
+			timestamp: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "rds ddl",
+			line:      `20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY_DDL,shop,'CREATE TABLE t (a int)',0`,
+			want:      "CREATE TABLE t (a int)",
+			timestamp: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "aurora",
+			line:      `1717236000123456,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY,shop,'SELECT 1',0`,
+			want:      "SELECT 1",
+			timestamp: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "cloudwatch export",
+			line: `2024-06-01T10:00:00.123Z 20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY,shop,'SELECT 1',0`,
+			want: "SELECT 1",
+			// the event timestamp wins over the export one
+			timestamp: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "unknown timestamp",
+			line: `yesterday,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY,shop,'SELECT 1',0`,
+			want: "SELECT 1",
+		},
+		{
+			name: "table access",
+			line: `20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,345,READ,shop,orders,`,
+			skip: SkipNotComQuery,
+		},
+		{
+			name: "failed query",
+			line: `20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY,shop,'SELECT * FROM missing',1146`,
+			skip: SkipFiltered,
+		},
+		{
+			name: "cloudwatch export only",
+			line: `2024-06-01T10:00:00Z `,
+			skip: SkipNoPayload,
+		},
+		{
+			name:    "missing return code",
+			line:    `20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY,shop,'SELECT 1'`,
+			errKind: "invalid_line_format",
+		},
+		{
+			name:    "trailing backslash",
+			line:    `20240601 10:00:00,ip-10-0-0-1,app,10.0.0.5,12,345,QUERY,shop,'SELECT 1\',0`,
+			errKind: "invalid_object",
+		},
+		{
+			name:      "azure",
+			line:      `{"time": "2024-06-01T10:00:00.5Z", "properties": {"event_class": "general_log", "event_subclass": "LOG", "sql_text": "SELECT 1"}}`,
+			want:      "SELECT 1",
+			timestamp: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "azure log analytics",
+			line: `{"time": "2024-06-01T10:00:00Z", "properties": {"event_class_s": "general_log", "event_subclass_s": "LOG", "sql_text_s": "SELECT 1"}}`,
+			want: "SELECT 1",
+			// the suffixed properties are read as well
+			timestamp: time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "azure connection",
+			line: `{"time": "2024-06-01T10:00:00Z", "properties": {"event_class": "connection_log", "event_subclass": "CONNECT"}}`,
+			skip: SkipNotComQuery,
+		},
+		{
+			name: "azure error",
+			line: `{"time": "2024-06-01T10:00:00Z", "properties": {"event_class": "general_log", "event_subclass": "ERROR", "sql_text": "SELECT * FROM missing"}}`,
+			skip: SkipNotComQuery,
+		},
+		{
+			name:    "azure truncated",
+			line:    `{"time": "2024-06-01T10:00:00Z"`,
+			errKind: "invalid_json",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q, skip, err := parseAuditLogLine([]byte(tt.line))
+			if tt.errKind != "" {
+				if kind := errorKind(err); err == nil || kind != tt.errKind {
+					t.Fatalf("parseAuditLogLine() error = %v (%s), want kind %s", err, kind, tt.errKind)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAuditLogLine() error = %v", err)
+			}
+			if tt.want == "" {
+				if q != nil || skip != tt.skip {
+					t.Errorf("parseAuditLogLine() = %v, %s, want skip %s", q, skip, tt.skip)
+				}
+				return
+			}
+			if q == nil {
+				t.Fatalf("parseAuditLogLine() skipped %s", skip)
+			}
+			if string(q.Raw) != tt.want {
+				t.Errorf("parseAuditLogLine() = %q, want %q", q.Raw, tt.want)
+			}
+			var timestamp uint64
+			if !tt.timestamp.IsZero() {
+				timestamp = uint64(tt.timestamp.Unix())
+			}
+			if q.Timestamp != timestamp {
+				t.Errorf("timestamp = %d, want %d", q.Timestamp, timestamp)
+			}
+		})
+	}
+}
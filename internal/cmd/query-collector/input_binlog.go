@@ -0,0 +1,843 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"mysql-load-test/pkg/query"
+)
+
+// InputBinlogConfig reads a MySQL binary log (v4, MySQL 5.0 and later).
+// Statement events are taken as they are. Row events are turned back into
+// one statement per row; UPDATE and DELETE need the column names of
+// binlog_row_metadata=FULL, INSERT falls back to the column order.
+type InputBinlogConfig struct {
+	File string
+	// ErrorLogInterval is the minimum time between two logged samples of
+	// the same kind of parse error.
+	ErrorLogInterval time.Duration
+}
+
+const (
+	binlogMagic        = "\xfebin"
+	binlogHeaderLength = 19
+
+	binlogQueryEvent             = 2
+	binlogFormatDescriptionEvent = 15
+	binlogTableMapEvent          = 19
+	binlogWriteRowsEventV1       = 23
+	binlogUpdateRowsEventV1      = 24
+	binlogDeleteRowsEventV1      = 25
+	binlogWriteRowsEventV2       = 30
+	binlogUpdateRowsEventV2      = 31
+	binlogDeleteRowsEventV2      = 32
+
+	binlogChecksumCRC32 = 1
+
+	// optional table map metadata of binlog_row_metadata=FULL
+	binlogMetadataSignedness = 1
+	binlogMetadataColumnName = 4
+)
+
+// column types as written to the table map
+const (
+	mysqlTypeDecimal    = 0
+	mysqlTypeTiny       = 1
+	mysqlTypeShort      = 2
+	mysqlTypeLong       = 3
+	mysqlTypeFloat      = 4
+	mysqlTypeDouble     = 5
+	mysqlTypeNull       = 6
+	mysqlTypeTimestamp  = 7
+	mysqlTypeLongLong   = 8
+	mysqlTypeInt24      = 9
+	mysqlTypeDate       = 10
+	mysqlTypeTime       = 11
+	mysqlTypeDatetime   = 12
+	mysqlTypeYear       = 13
+	mysqlTypeVarchar    = 15
+	mysqlTypeBit        = 16
+	mysqlTypeTimestamp2 = 17
+	mysqlTypeDatetime2  = 18
+	mysqlTypeTime2      = 19
+	mysqlTypeJSON       = 245
+	mysqlTypeNewDecimal = 246
+	mysqlTypeEnum       = 247
+	mysqlTypeSet        = 248
+	mysqlTypeTinyBlob   = 249
+	mysqlTypeMediumBlob = 250
+	mysqlTypeLongBlob   = 251
+	mysqlTypeBlob       = 252
+	mysqlTypeVarString  = 253
+	mysqlTypeString     = 254
+	mysqlTypeGeometry   = 255
+)
+
+type binlogColumn struct {
+	typ      byte
+	meta     uint16
+	unsigned bool
+	name     string
+}
+
+type binlogTable struct {
+	schema, name string
+	columns      []binlogColumn
+}
+
+type InputBinlog struct {
+	cfg     InputBinlogConfig
+	reader  io.Reader
+	closers []io.Closer
+	common  *InputCommon
+	errLog  *rateLimitedErrorLog
+
+	// postHeaderLengths and checksum come from the format description
+	postHeaderLengths []byte
+	checksum          bool
+	tables            map[uint64]*binlogTable
+}
+
+func NewInputBinlog(cfg InputBinlogConfig, common *InputCommon) (*InputBinlog, error) {
+	file, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+
+	closers := []io.Closer{file}
+
+	r, err := common.WrapReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping reader: %w", err)
+	}
+
+	return &InputBinlog{
+		cfg:     cfg,
+		reader:  r,
+		closers: closers,
+		common:  common,
+		errLog:  newRateLimitedErrorLog(os.Stderr, "error parsing binlog event, skipping", cfg.ErrorLogInterval),
+		tables:  make(map[uint64]*binlogTable),
+	}, nil
+}
+
+func (i *InputBinlog) StartExtractor(ctx context.Context, outChan chan<- *query.Query) error {
+	return i.extractQueries(ctx, outChan)
+}
+
+func (i *InputBinlog) Destroy() error {
+	i.errLog.Close()
+
+	var errs []error
+
+	for _, closer := range i.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error closing input binlog: %w", errs[0])
+	}
+
+	return nil
+}
+
+func (i *InputBinlog) extractQueries(ctx context.Context, outChan chan<- *query.Query) error {
+	br := bufio.NewReaderSize(i.reader, 1<<20)
+
+	magic := make([]byte, len(binlogMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != binlogMagic {
+		return fmt.Errorf("not a binary log: missing magic number")
+	}
+	offset := int64(len(binlogMagic))
+
+	header := make([]byte, binlogHeaderLength)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if _, err := io.ReadFull(br, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			// the server may still be writing the last event
+			if err == io.ErrUnexpectedEOF {
+				i.common.summary.Skip(SkipTruncatedCapture)
+				return nil
+			}
+			return fmt.Errorf("error reading binlog event header: %w", err)
+		}
+		timestamp := binary.LittleEndian.Uint32(header[0:])
+		eventType := header[4]
+		eventSize := int64(binary.LittleEndian.Uint32(header[9:]))
+		if eventSize < binlogHeaderLength {
+			return fmt.Errorf("invalid binlog event size %d at offset %d", eventSize, offset)
+		}
+
+		body := make([]byte, eventSize-binlogHeaderLength)
+		if _, err := io.ReadFull(br, body); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				i.common.summary.Skip(SkipTruncatedCapture)
+				return nil
+			}
+			return fmt.Errorf("error reading binlog event: %w", err)
+		}
+		eventStart := offset
+		offset += eventSize
+		i.common.summary.RecordRead()
+
+		if eventType == binlogFormatDescriptionEvent {
+			if err := i.parseFormatDescription(body); err != nil {
+				return err
+			}
+			i.common.summary.Skip(SkipNotComQuery)
+			continue
+		}
+		if i.checksum {
+			if len(body) < 4 {
+				if err := i.skipEvent(newKindError("truncated_event", "binlog event at offset %d too short for its checksum", eventStart), eventStart); err != nil {
+					return err
+				}
+				continue
+			}
+			body = body[:len(body)-4]
+		}
+
+		var statements [][]byte
+		var err error
+		switch eventType {
+		case binlogQueryEvent:
+			statements, err = i.parseQueryEvent(body)
+		case binlogTableMapEvent:
+			err = i.parseTableMap(body)
+		case binlogWriteRowsEventV1, binlogWriteRowsEventV2,
+			binlogUpdateRowsEventV1, binlogUpdateRowsEventV2,
+			binlogDeleteRowsEventV1, binlogDeleteRowsEventV2:
+			statements, err = i.parseRowsEvent(eventType, body)
+		}
+		if err != nil {
+			if err := i.skipEvent(err, eventStart); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(statements) == 0 {
+			i.common.summary.Skip(SkipNotComQuery)
+			continue
+		}
+
+		for n, statement := range statements {
+			i.common.summary.Extracted()
+			// every row of an event gets its own offset inside the event so
+			// resumed runs can tell them apart
+			outChan <- &query.Query{
+				Raw:       statement,
+				Offset:    uint64(eventStart) + uint64(n),
+				Length:    uint64(eventSize) - uint64(n),
+				Timestamp: uint64(timestamp),
+			}
+		}
+	}
+}
+
+// skipEvent skips the event at offset, binary events are dead lettered
+// without their body
+func (i *InputBinlog) skipEvent(err error, offset int64) error {
+	i.errLog.Log(err)
+	return i.common.SkipRecord(err, offset, nil)
+}
+
+func (i *InputBinlog) postHeaderLength(eventType byte, fallback int) int {
+	if int(eventType) <= len(i.postHeaderLengths) && eventType > 0 {
+		return int(i.postHeaderLengths[eventType-1])
+	}
+	return fallback
+}
+
+// parseFormatDescription reads the post-header lengths and whether events
+// carry a CRC32 checksum, written since MySQL 5.6.1
+func (i *InputBinlog) parseFormatDescription(body []byte) error {
+	// binlog version, server version, create timestamp, header length
+	const fixed = 2 + 50 + 4 + 1
+	if len(body) < fixed {
+		return fmt.Errorf("invalid binlog format description event")
+	}
+	if version := binary.LittleEndian.Uint16(body); version != 4 {
+		return fmt.Errorf("unsupported binlog version %d, only v4 binlogs are supported", version)
+	}
+	serverVersion := string(bytes.TrimRight(body[2:52], "\x00"))
+
+	lengths := body[fixed:]
+	if binlogServerVersionAtLeast(serverVersion, 5, 6, 1) && len(lengths) >= 5 {
+		i.checksum = lengths[len(lengths)-5] == binlogChecksumCRC32
+		lengths = lengths[:len(lengths)-5]
+	}
+	i.postHeaderLengths = lengths
+	return nil
+}
+
+func binlogServerVersionAtLeast(version string, major, minor, patch int) bool {
+	var parts [3]int
+	for n, field := range strings.SplitN(version, ".", 3) {
+		end := strings.IndexFunc(field, func(r rune) bool { return r < '0' || r > '9' })
+		if end >= 0 {
+			field = field[:end]
+		}
+		parts[n], _ = strconv.Atoi(field)
+	}
+	if parts[0] != major {
+		return parts[0] > major
+	}
+	if parts[1] != minor {
+		return parts[1] > minor
+	}
+	return parts[2] >= patch
+}
+
+// parseQueryEvent returns the statement of a statement-based event,
+// transaction boundaries are left out
+func (i *InputBinlog) parseQueryEvent(body []byte) ([][]byte, error) {
+	postHeader := i.postHeaderLength(binlogQueryEvent, 13)
+	if len(body) < postHeader || postHeader < 11 {
+		return nil, newKindError("invalid_query_event", "query event too short")
+	}
+	schemaLength := int(body[8])
+	statusLength := 0
+	if postHeader >= 13 {
+		statusLength = int(binary.LittleEndian.Uint16(body[11:]))
+	}
+	start := postHeader + statusLength + schemaLength + 1
+	if start > len(body) {
+		return nil, newKindError("invalid_query_event", "query event too short for its schema and status variables")
+	}
+
+	statement := bytes.TrimSpace(body[start:])
+	switch strings.ToUpper(string(statement)) {
+	case "", "BEGIN", "COMMIT", "ROLLBACK":
+		return nil, nil
+	}
+	return [][]byte{statement}, nil
+}
+
+func binlogTableID(body []byte, postHeader int) uint64 {
+	if postHeader == 6 {
+		return uint64(binary.LittleEndian.Uint32(body))
+	}
+	var id [8]byte
+	copy(id[:], body[:6])
+	return binary.LittleEndian.Uint64(id[:])
+}
+
+// parseTableMap remembers the columns of the table rows events refer to
+func (i *InputBinlog) parseTableMap(body []byte) error {
+	postHeader := i.postHeaderLength(binlogTableMapEvent, 8)
+	if len(body) < postHeader {
+		return newKindError("invalid_table_map", "table map event too short")
+	}
+	id := binlogTableID(body, postHeader)
+	d := binlogDecoder{buf: body[postHeader:]}
+
+	table := &binlogTable{}
+	table.schema = string(d.bytes(int(d.byte())))
+	d.skip(1)
+	table.name = string(d.bytes(int(d.byte())))
+	d.skip(1)
+	count := int(d.lenenc())
+	types := d.bytes(count)
+	meta := binlogDecoder{buf: d.bytes(int(d.lenenc()))}
+	d.skip((count + 7) / 8) // nullability
+	if d.err != nil {
+		return newKindError("invalid_table_map", "table map event too short")
+	}
+
+	table.columns = make([]binlogColumn, count)
+	for n, typ := range types {
+		col := &table.columns[n]
+		col.typ = typ
+		switch typ {
+		case mysqlTypeFloat, mysqlTypeDouble, mysqlTypeTinyBlob, mysqlTypeMediumBlob, mysqlTypeLongBlob, mysqlTypeBlob,
+			mysqlTypeGeometry, mysqlTypeJSON, mysqlTypeTimestamp2, mysqlTypeDatetime2, mysqlTypeTime2:
+			col.meta = uint16(meta.byte())
+		case mysqlTypeVarchar, mysqlTypeVarString, mysqlTypeBit:
+			col.meta = meta.uint16LE()
+		case mysqlTypeString, mysqlTypeNewDecimal, mysqlTypeEnum, mysqlTypeSet:
+			col.meta = uint16(meta.byte())<<8 | uint16(meta.byte())
+		}
+	}
+	if meta.err != nil {
+		return newKindError("invalid_table_map", "table map metadata too short")
+	}
+
+	// optional metadata, a type, a length and a value each
+	for d.err == nil && len(d.buf) > 0 {
+		field := d.byte()
+		value := binlogDecoder{buf: d.bytes(int(d.lenenc()))}
+		switch field {
+		case binlogMetadataSignedness:
+			numeric := 0
+			for n := range table.columns {
+				if !binlogNumericType(table.columns[n].typ) {
+					continue
+				}
+				if numeric/8 < len(value.buf) {
+					table.columns[n].unsigned = value.buf[numeric/8]&(0x80>>(numeric%8)) != 0
+				}
+				numeric++
+			}
+		case binlogMetadataColumnName:
+			for n := range table.columns {
+				table.columns[n].name = string(value.bytes(int(value.lenenc())))
+			}
+			if value.err != nil {
+				for n := range table.columns {
+					table.columns[n].name = ""
+				}
+			}
+		}
+	}
+
+	i.tables[id] = table
+	return nil
+}
+
+func binlogNumericType(typ byte) bool {
+	switch typ {
+	case mysqlTypeTiny, mysqlTypeShort, mysqlTypeInt24, mysqlTypeLong, mysqlTypeLongLong,
+		mysqlTypeFloat, mysqlTypeDouble, mysqlTypeDecimal, mysqlTypeNewDecimal:
+		return true
+	}
+	return false
+}
+
+// parseRowsEvent converts the rows of a rows event into statements
+func (i *InputBinlog) parseRowsEvent(eventType byte, body []byte) ([][]byte, error) {
+	v2 := eventType >= binlogWriteRowsEventV2
+	fallback := 8
+	if v2 {
+		fallback = 10
+	}
+	postHeader := i.postHeaderLength(eventType, fallback)
+	if len(body) < postHeader {
+		return nil, newKindError("invalid_rows_event", "rows event too short")
+	}
+	table, ok := i.tables[binlogTableID(body, postHeader)]
+	if !ok {
+		return nil, newKindError("unknown_table", "rows event without a preceding table map")
+	}
+	d := binlogDecoder{buf: body[postHeader:]}
+	if v2 {
+		// the extra data length counts its own two bytes
+		d.skip(int(binary.LittleEndian.Uint16(body[postHeader-2:])) - 2)
+	}
+
+	count := int(d.lenenc())
+	if count != len(table.columns) {
+		return nil, newKindError("invalid_rows_event", "rows event has %d columns, table map %d", count, len(table.columns))
+	}
+	before := d.bytes((count + 7) / 8)
+	after := before
+	update := eventType == binlogUpdateRowsEventV1 || eventType == binlogUpdateRowsEventV2
+	if update {
+		after = d.bytes((count + 7) / 8)
+	}
+	if d.err != nil {
+		return nil, newKindError("invalid_rows_event", "rows event too short")
+	}
+
+	var statements [][]byte
+	for len(d.buf) > 0 {
+		image, err := d.row(table.columns, before)
+		if err != nil {
+			return nil, err
+		}
+		var statement string
+		switch eventType {
+		case binlogWriteRowsEventV1, binlogWriteRowsEventV2:
+			statement, err = table.insert(image)
+		case binlogDeleteRowsEventV1, binlogDeleteRowsEventV2:
+			statement, err = table.delete(image)
+		default:
+			var afterImage []binlogValue
+			if afterImage, err = d.row(table.columns, after); err != nil {
+				return nil, err
+			}
+			statement, err = table.update(image, afterImage)
+		}
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, []byte(statement))
+	}
+	return statements, nil
+}
+
+// binlogValue is a column of a row image as an SQL literal
+type binlogValue struct {
+	column  int
+	literal string
+}
+
+func (t *binlogTable) qualifiedName() string {
+	if t.schema == "" {
+		return quoteIdentifier(t.name)
+	}
+	return quoteIdentifier(t.schema) + "." + quoteIdentifier(t.name)
+}
+
+func (t *binlogTable) hasNames(image []binlogValue) bool {
+	for _, v := range image {
+		if t.columns[v.column].name == "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *binlogTable) insert(image []binlogValue) (string, error) {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO " + t.qualifiedName())
+	if t.hasNames(image) {
+		names := make([]string, len(image))
+		for n, v := range image {
+			names[n] = quoteIdentifier(t.columns[v.column].name)
+		}
+		sb.WriteString(" (" + strings.Join(names, ", ") + ")")
+	} else if len(image) != len(t.columns) {
+		return "", newKindError("missing_column_names", "partial row image of %s without column names, set binlog_row_metadata=FULL", t.qualifiedName())
+	}
+	literals := make([]string, len(image))
+	for n, v := range image {
+		literals[n] = v.literal
+	}
+	sb.WriteString(" VALUES (" + strings.Join(literals, ", ") + ")")
+	return sb.String(), nil
+}
+
+func (t *binlogTable) where(image []binlogValue) string {
+	conditions := make([]string, len(image))
+	for n, v := range image {
+		name := quoteIdentifier(t.columns[v.column].name)
+		if v.literal == "NULL" {
+			conditions[n] = name + " IS NULL"
+		} else {
+			conditions[n] = name + " = " + v.literal
+		}
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+func (t *binlogTable) update(before, after []binlogValue) (string, error) {
+	if !t.hasNames(before) || !t.hasNames(after) {
+		return "", newKindError("missing_column_names", "update of %s without column names, set binlog_row_metadata=FULL", t.qualifiedName())
+	}
+	assignments := make([]string, len(after))
+	for n, v := range after {
+		assignments[n] = quoteIdentifier(t.columns[v.column].name) + " = " + v.literal
+	}
+	return "UPDATE " + t.qualifiedName() + " SET " + strings.Join(assignments, ", ") + t.where(before), nil
+}
+
+func (t *binlogTable) delete(image []binlogValue) (string, error) {
+	if !t.hasNames(image) {
+		return "", newKindError("missing_column_names", "delete from %s without column names, set binlog_row_metadata=FULL", t.qualifiedName())
+	}
+	return "DELETE FROM " + t.qualifiedName() + t.where(image), nil
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// binlogDecoder reads the little-endian fields of an event, the first
+// short read sets err and every later read returns zero values
+type binlogDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *binlogDecoder) bytes(n int) []byte {
+	if d.err != nil || n < 0 || n > len(d.buf) {
+		d.err = newKindError("truncated_event", "binlog event truncated")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *binlogDecoder) skip(n int) { d.bytes(n) }
+
+func (d *binlogDecoder) byte() byte {
+	if b := d.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *binlogDecoder) uint16LE() uint16 {
+	if b := d.bytes(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+// uintLE reads an n byte little-endian unsigned integer
+func (d *binlogDecoder) uintLE(n int) uint64 {
+	var v uint64
+	for k, b := range d.bytes(n) {
+		v |= uint64(b) << (8 * k)
+	}
+	return v
+}
+
+// uintBE reads an n byte big-endian unsigned integer
+func (d *binlogDecoder) uintBE(n int) uint64 {
+	var v uint64
+	for _, b := range d.bytes(n) {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+// lenenc reads a length-encoded integer of the client/server protocol
+func (d *binlogDecoder) lenenc() uint64 {
+	switch first := d.byte(); first {
+	case 0xfc:
+		return d.uintLE(2)
+	case 0xfd:
+		return d.uintLE(3)
+	case 0xfe:
+		return d.uintLE(8)
+	default:
+		return uint64(first)
+	}
+}
+
+// row reads a row image holding the columns set in present
+func (d *binlogDecoder) row(columns []binlogColumn, present []byte) ([]binlogValue, error) {
+	var indexes []int
+	for n := range columns {
+		if present[n/8]&(1<<(n%8)) != 0 {
+			indexes = append(indexes, n)
+		}
+	}
+	nulls := d.bytes((len(indexes) + 7) / 8)
+
+	image := make([]binlogValue, len(indexes))
+	for k, n := range indexes {
+		image[k].column = n
+		if d.err == nil && nulls[k/8]&(1<<(k%8)) != 0 {
+			image[k].literal = "NULL"
+			continue
+		}
+		literal, err := d.value(columns[n])
+		if err != nil {
+			return nil, err
+		}
+		image[k].literal = literal
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return image, nil
+}
+
+var binlogIntegerSizes = map[byte]int{mysqlTypeTiny: 1, mysqlTypeShort: 2, mysqlTypeInt24: 3, mysqlTypeLong: 4, mysqlTypeLongLong: 8}
+
+// value reads a column value and formats it as an SQL literal
+func (d *binlogDecoder) value(col binlogColumn) (string, error) {
+	switch col.typ {
+	case mysqlTypeTiny, mysqlTypeShort, mysqlTypeInt24, mysqlTypeLong, mysqlTypeLongLong:
+		size := binlogIntegerSizes[col.typ]
+		v := d.uintLE(size)
+		if col.unsigned {
+			return strconv.FormatUint(v, 10), nil
+		}
+		// sign-extend
+		shift := 64 - 8*size
+		return strconv.FormatInt(int64(v<<shift)>>shift, 10), nil
+	case mysqlTypeYear:
+		if v := d.byte(); v != 0 {
+			return strconv.Itoa(1900 + int(v)), nil
+		}
+		return "0", nil
+	case mysqlTypeFloat:
+		return strconv.FormatFloat(float64(math.Float32frombits(uint32(d.uintLE(4)))), 'g', -1, 32), nil
+	case mysqlTypeDouble:
+		return strconv.FormatFloat(math.Float64frombits(d.uintLE(8)), 'g', -1, 64), nil
+	case mysqlTypeNewDecimal:
+		return d.decimal(int(col.meta>>8), int(col.meta&0xff))
+	case mysqlTypeVarchar, mysqlTypeVarString:
+		return quoteStringLiteral(d.bytes(d.stringLength(int(col.meta)))), nil
+	case mysqlTypeString:
+		realType, maxLength := byte(col.meta>>8), int(col.meta&0xff)
+		if realType&0x30 != 0x30 {
+			maxLength |= int((realType&0x30)^0x30) << 4
+			realType |= 0x30
+		}
+		switch realType {
+		case mysqlTypeEnum, mysqlTypeSet:
+			// the index or bit set works as a value too
+			return strconv.FormatUint(d.uintLE(maxLength), 10), nil
+		}
+		return quoteStringLiteral(d.bytes(d.stringLength(maxLength))), nil
+	case mysqlTypeEnum, mysqlTypeSet:
+		return strconv.FormatUint(d.uintLE(int(col.meta&0xff)), 10), nil
+	case mysqlTypeTinyBlob, mysqlTypeMediumBlob, mysqlTypeLongBlob, mysqlTypeBlob:
+		return quoteStringLiteral(d.bytes(int(d.uintLE(int(col.meta))))), nil
+	case mysqlTypeBit:
+		bits := int(col.meta>>8)*8 + int(col.meta&0xff)
+		return strconv.FormatUint(d.uintBE((bits+7)/8), 10), nil
+	case mysqlTypeDate:
+		v := d.uintLE(3)
+		return fmt.Sprintf("'%04d-%02d-%02d'", v>>9, (v>>5)&15, v&31), nil
+	case mysqlTypeTimestamp:
+		return fmt.Sprintf("FROM_UNIXTIME(%d)", d.uintLE(4)), nil
+	case mysqlTypeDatetime:
+		v := d.uintLE(8)
+		date, clock := v/1000000, v%1000000
+		return fmt.Sprintf("'%04d-%02d-%02d %02d:%02d:%02d'", date/10000, date/100%100, date%100, clock/10000, clock/100%100, clock%100), nil
+	case mysqlTypeTimestamp2:
+		seconds := d.uintBE(4)
+		if frac := d.fraction(int(col.meta)); frac != "" {
+			return fmt.Sprintf("FROM_UNIXTIME(%d%s)", seconds, frac), nil
+		}
+		return fmt.Sprintf("FROM_UNIXTIME(%d)", seconds), nil
+	case mysqlTypeDatetime2:
+		v := int64(d.uintBE(5)) - 0x8000000000
+		ymd, hms := v>>17, v%(1<<17)
+		ym := ymd >> 5
+		return fmt.Sprintf("'%04d-%02d-%02d %02d:%02d:%02d%s'", ym/13, ym%13, ymd%32, hms>>12, (hms>>6)%64, hms%64, d.fraction(int(col.meta))), nil
+	case mysqlTypeTime2:
+		// the integer and fractional parts are stored as one biased value
+		fracBytes := (int(col.meta) + 1) / 2
+		v := int64(d.uintBE(3+fracBytes)) - 0x800000<<(8*fracBytes)
+		sign := ""
+		if v < 0 {
+			sign, v = "-", -v
+		}
+		hms, frac := v>>(8*fracBytes), uint64(v)&(1<<(8*fracBytes)-1)
+		return fmt.Sprintf("'%s%02d:%02d:%02d%s'", sign, (hms>>12)&0x3ff, (hms>>6)%64, hms%64, formatBinlogFraction(frac, int(col.meta))), nil
+	}
+	return "", newKindError("unsupported_column_type", "column type %d can't be turned into a statement", col.typ)
+}
+
+// stringLength reads the length prefix of a string of at most maxLength
+// bytes
+func (d *binlogDecoder) stringLength(maxLength int) int {
+	if maxLength > 255 {
+		return int(d.uintLE(2))
+	}
+	return int(d.byte())
+}
+
+// fraction reads the fractional seconds of a temporal column with fsp
+// digits, as ".123" or empty
+func (d *binlogDecoder) fraction(fsp int) string {
+	return formatBinlogFraction(d.uintBE((fsp+1)/2), fsp)
+}
+
+func formatBinlogFraction(frac uint64, fsp int) string {
+	if fsp <= 0 {
+		return ""
+	}
+	// the stored value has two digits per byte
+	digits := (fsp + 1) / 2 * 2
+	s := fmt.Sprintf("%0*d", digits, frac)
+	return "." + s[:fsp]
+}
+
+var binlogDecimalDigits = [10]int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// decimal reads a DECIMAL in the binary format of MySQL 5.0.3 and later:
+// groups of nine digits in four bytes, the sign bit inverted and negative
+// values stored as complement
+func (d *binlogDecoder) decimal(precision, scale int) (string, error) {
+	integral := precision - scale
+	size := integral/9*4 + binlogDecimalDigits[integral%9] + scale/9*4 + binlogDecimalDigits[scale%9]
+	raw := d.bytes(size)
+	if raw == nil {
+		return "", d.err
+	}
+	buf := append([]byte(nil), raw...)
+	negative := buf[0]&0x80 == 0
+	buf[0] ^= 0x80
+	if negative {
+		for k := range buf {
+			buf[k] ^= 0xff
+		}
+	}
+	group := binlogDecoder{buf: buf}
+
+	var digits strings.Builder
+	if n := integral % 9; n > 0 {
+		digits.WriteString(strconv.FormatUint(group.uintBE(binlogDecimalDigits[n]), 10))
+	}
+	for range integral / 9 {
+		fmt.Fprintf(&digits, "%09d", group.uintBE(4))
+	}
+	s := strings.TrimLeft(digits.String(), "0")
+	if s == "" {
+		s = "0"
+	}
+	if negative {
+		s = "-" + s
+	}
+	if scale > 0 {
+		digits.Reset()
+		for range scale / 9 {
+			fmt.Fprintf(&digits, "%09d", group.uintBE(4))
+		}
+		if n := scale % 9; n > 0 {
+			fmt.Fprintf(&digits, "%0*d", n, group.uintBE(binlogDecimalDigits[n]))
+		}
+		s += "." + digits.String()
+	}
+	return s, nil
+}
+
+// quoteStringLiteral returns b as a string literal, or a hex literal when
+// it isn't valid UTF-8
+func quoteStringLiteral(b []byte) string {
+	if !utf8.Valid(b) {
+		return "X'" + hex.EncodeToString(b) + "'"
+	}
+	var sb strings.Builder
+	sb.Grow(len(b) + 2)
+	sb.WriteByte('\'')
+	for _, c := range b {
+		switch c {
+		case '\'':
+			sb.WriteString(`\'`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case 0:
+			sb.WriteString(`\0`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case 0x1a:
+			sb.WriteString(`\Z`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mysql-load-test/pkg/query"
+)
+
+// The fixtures below follow the v4 binlog layout byte for byte: a 19 byte
+// header, the event body and, since MySQL 5.6.1, a trailing CRC32.
+
+// binlogEvent decodes the hex encoded fields of an event
+func binlogEvent(fields ...string) []byte {
+	b, err := hex.DecodeString(strings.Join(fields, ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func binlogFile(events ...[]byte) []byte {
+	return append([]byte(binlogMagic), bytes.Join(events, nil)...)
+}
+
+// ordersBinlog is a MySQL 8.0.32 binlog with statement and row events on a
+// table logged with binlog_row_metadata=FULL
+var ordersBinlog = binlogFile(
+	// format description of MySQL 8.0.32, crc32 checksums
+	binlogEvent(
+		"eeabe165",     // timestamp
+		"0f",           // event type
+		"01000000",     // server id
+		"7a000000",     // event size
+		"7e000000",     // next position
+		"0000",         // flags
+		"0400",         // binlog version
+		"382e302e3332", // server version 8.0.32
+		"0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000", // server version padding
+		"00000000", // create timestamp
+		"13",       // header length
+		"380d0008000000000400040000006200041a08000000080808020000000a0a0a2a2a001234000a2800", // post-header lengths by event type
+		"01",       // checksum algorithm crc32
+		"9c2c6a7f", // crc32
+	),
+	// statement-based transaction start, left out
+	binlogEvent(
+		"eeabe165", // timestamp
+		"02",       // event type
+		"01000000", // server id
+		"43000000", // event size
+		"c1000000", // next position
+		"0000",     // flags
+		"08000000", // thread id
+		"00000000", // execution time
+		"04",       // schema length
+		"0000",     // error code
+		"1500",     // status variables length
+		"000000000001a002004a0000000004ff00ff00ff00", // status variables: flags2, sql_mode, charset
+		"73686f7000", // schema shop
+		"424547494e", // BEGIN
+		"a82afec3",   // crc32
+	),
+	// statement-based DDL
+	binlogEvent(
+		"eeabe165", // timestamp
+		"02",       // event type
+		"01000000", // server id
+		"77000000", // event size
+		"38010000", // next position
+		"0000",     // flags
+		"08000000", // thread id
+		"00000000", // execution time
+		"04",       // schema length
+		"0000",     // error code
+		"1500",     // status variables length
+		"000000000001a002004a0000000004ff00ff00ff00", // status variables: flags2, sql_mode, charset
+		"73686f7000", // schema shop
+		"435245415445205441424c4520746167732028696420494e54205052494d415259204b45592c206c6162656c20564152434841522831302929", // CREATE TABLE tags (id INT PRIMARY KEY, label VARCHAR(10))
+		"5ef889e7", // crc32
+	),
+	// table map of shop.orders with binlog_row_metadata=FULL
+	binlogEvent(
+		"eeabe165",         // timestamp
+		"13",               // event type
+		"01000000",         // server id
+		"5f000000",         // event size
+		"97010000",         // next position
+		"0000",             // flags
+		"6c0000000000",     // table id
+		"0100",             // flags
+		"0473686f7000",     // schema shop
+		"066f726465727300", // table orders
+		"05",               // column count
+		"080ff612fc",       // column types
+		"0600010a020002",   // column metadata
+		"1e",               // nullable columns
+		"010180",           // signedness: id unsigned
+		"0201ff",           // default charset, ignored
+		"041c026964046e616d6506616d6f756e740763726561746564046e6f7465", // column names
+		"d17bed49", // crc32
+	),
+	// two inserted rows
+	binlogEvent(
+		"eeabe165",           // timestamp
+		"1e",                 // event type
+		"01000000",           // server id
+		"5c000000",           // event size
+		"f3010000",           // next position
+		"0000",               // flags
+		"6c0000000000",       // table id
+		"0100",               // flags: end of statement
+		"0200",               // extra data length
+		"05",                 // column count
+		"1f",                 // columns present
+		"10",                 // row 1 nulls: note
+		"0100000000000000",   // id 1
+		"0300416e6e",         // name Ann
+		"8000000c22",         // amount 12.34
+		"99b2c2a51e",         // created 2024-03-01 10:20:30
+		"00",                 // row 2 nulls: none
+		"ffffffffffffffff",   // id 18446744073709551615
+		"07004f27427269656e", // name O'Brien
+		"7ffffffaff",         // amount -5.00
+		"9963ff7efb",         // created 1999-12-31 23:59:59
+		"030068690a",         // note hi\n
+		"6680a0a3",           // crc32
+	),
+	// update with a full before image and a partial after image
+	binlogEvent(
+		"eeabe165",         // timestamp
+		"1f",               // event type
+		"01000000",         // server id
+		"4b000000",         // event size
+		"3e020000",         // next position
+		"0000",             // flags
+		"6c0000000000",     // table id
+		"0100",             // flags: end of statement
+		"0200",             // extra data length
+		"05",               // column count
+		"1f",               // columns present
+		"03",               // columns present after update
+		"10",               // before nulls: note
+		"0100000000000000", // id 1
+		"0300416e6e",       // name Ann
+		"8000000c22",       // amount 12.34
+		"99b2c2a51e",       // created 2024-03-01 10:20:30
+		"00",               // after nulls: none
+		"0100000000000000", // id 1
+		"0400416e6e61",     // name Anna
+		"19c8a5c1",         // crc32
+	),
+	// delete with a primary key only before image
+	binlogEvent(
+		"eeabe165",         // timestamp
+		"20",               // event type
+		"01000000",         // server id
+		"2c000000",         // event size
+		"6a020000",         // next position
+		"0000",             // flags
+		"6c0000000000",     // table id
+		"0100",             // flags: end of statement
+		"0200",             // extra data length
+		"05",               // column count
+		"01",               // columns present
+		"00",               // nulls: none
+		"0200000000000000", // id 2
+		"0cdd833a",         // crc32
+	),
+	// transaction commit (xid), left out
+	binlogEvent(
+		"eeabe165",         // timestamp
+		"10",               // event type
+		"01000000",         // server id
+		"1f000000",         // event size
+		"89020000",         // next position
+		"0000",             // flags
+		"2a00000000000000", // xid
+		"7c867146",         // crc32
+	),
+)
+
+// tagsBinlog holds rows of a table logged with binlog_row_metadata=MINIMAL,
+// which has no column names, and rows of an unknown table
+var tagsBinlog = binlogFile(
+	// format description of MySQL 8.0.32, crc32 checksums
+	binlogEvent(
+		"eeabe165",     // timestamp
+		"0f",           // event type
+		"01000000",     // server id
+		"7a000000",     // event size
+		"7e000000",     // next position
+		"0000",         // flags
+		"0400",         // binlog version
+		"382e302e3332", // server version 8.0.32
+		"0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000", // server version padding
+		"00000000", // create timestamp
+		"13",       // header length
+		"380d0008000000000400040000006200041a08000000080808020000000a0a0a2a2a001234000a2800", // post-header lengths by event type
+		"01",       // checksum algorithm crc32
+		"9c2c6a7f", // crc32
+	),
+	// table map of shop.tags with binlog_row_metadata=MINIMAL
+	binlogEvent(
+		"eeabe165",     // timestamp
+		"13",           // event type
+		"01000000",     // server id
+		"32000000",     // event size
+		"b0000000",     // next position
+		"0000",         // flags
+		"6d0000000000", // table id
+		"0100",         // flags
+		"0473686f7000", // schema shop
+		"047461677300", // table tags
+		"02",           // column count
+		"030f",         // column types
+		"022800",       // column metadata
+		"02",           // nullable columns
+		"7a49cd0d",     // crc32
+	),
+	// insert, the columns are in table order
+	binlogEvent(
+		"eeabe165",     // timestamp
+		"1e",           // event type
+		"01000000",     // server id
+		"2b000000",     // event size
+		"db000000",     // next position
+		"0000",         // flags
+		"6d0000000000", // table id
+		"0100",         // flags: end of statement
+		"0200",         // extra data length
+		"02",           // column count
+		"03",           // columns present
+		"00",           // nulls: none
+		"f9ffffff",     // id -7
+		"02fffe",       // label, not valid UTF-8
+		"fc5555af",     // crc32
+	),
+	// delete needs the column names, skipped
+	binlogEvent(
+		"eeabe165",     // timestamp
+		"20",           // event type
+		"01000000",     // server id
+		"28000000",     // event size
+		"03010000",     // next position
+		"0000",         // flags
+		"6d0000000000", // table id
+		"0100",         // flags: end of statement
+		"0200",         // extra data length
+		"02",           // column count
+		"03",           // columns present
+		"02",           // nulls: label
+		"03000000",     // id 3
+		"81ec66d5",     // crc32
+	),
+	// rows of a table without a table map, skipped
+	binlogEvent(
+		"eeabe165",     // timestamp
+		"1e",           // event type
+		"01000000",     // server id
+		"28000000",     // event size
+		"2b010000",     // next position
+		"0000",         // flags
+		"6e0000000000", // table id
+		"0100",         // flags: end of statement
+		"0200",         // extra data length
+		"01",           // column count
+		"01",           // columns present
+		"00",           // nulls: none
+		"01000000",     // id 1
+		"9648f9a5",     // crc32
+	),
+)
+
+// mysql55Binlog is written by a server without binlog checksums
+var mysql55Binlog = binlogFile(
+	// format description of MySQL 5.5.62, no checksums
+	binlogEvent(
+		"eeabe165",     // timestamp
+		"0f",           // event type
+		"01000000",     // server id
+		"67000000",     // event size
+		"6b000000",     // next position
+		"0000",         // flags
+		"0400",         // binlog version
+		"352e352e3632", // server version 5.5.62
+		"0000000000000000000000000000000000000000000000000000000000000000000000000000000000000000", // server version padding
+		"00000000", // create timestamp
+		"13",       // header length
+		"380d0008000000000400040000006200041a080000000808080200", // post-header lengths by event type
+	),
+	// statement-based insert
+	binlogEvent(
+		"eeabe165", // timestamp
+		"02",       // event type
+		"01000000", // server id
+		"5a000000", // event size
+		"c5000000", // next position
+		"0000",     // flags
+		"08000000", // thread id
+		"00000000", // execution time
+		"04",       // schema length
+		"0000",     // error code
+		"1500",     // status variables length
+		"000000000001a002004a0000000004ff00ff00ff00", // status variables: flags2, sql_mode, charset
+		"73686f7000", // schema shop
+		"494e5345525420494e544f20746167732056414c5545532028312c2027612729", // INSERT INTO tags VALUES (1, 'a')
+	),
+)
+
+func extractBinlog(t *testing.T, data []byte) ([]*query.Query, ExtractionSummarySnapshot, error) {
+	t.Helper()
+	common, summary := newTestInputCommon(t)
+	in := &InputBinlog{
+		reader: bytes.NewReader(data),
+		common: common,
+		errLog: newRateLimitedErrorLog(io.Discard, "", 0),
+		tables: make(map[uint64]*binlogTable),
+	}
+	defer in.errLog.Close()
+
+	queries, err := runExtractor(in.extractQueries)
+	return queries, summary.Snapshot(), err
+}
+
+func Test_InputBinlogExtract(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		want        []string
+		parseErrors map[string]uint64
+		truncated   uint64
+	}{
+		{
+			name: "statement and row events",
+			data: ordersBinlog,
+			want: []string{
+				"CREATE TABLE tags (id INT PRIMARY KEY, label VARCHAR(10))",
+				"INSERT INTO `shop`.`orders` (`id`, `name`, `amount`, `created`, `note`) VALUES (1, 'Ann', 12.34, '2024-03-01 10:20:30', NULL)",
+				"INSERT INTO `shop`.`orders` (`id`, `name`, `amount`, `created`, `note`) VALUES (18446744073709551615, 'O\\'Brien', -5.00, '1999-12-31 23:59:59', 'hi\\n')",
+				"UPDATE `shop`.`orders` SET `id` = 1, `name` = 'Anna' WHERE `id` = 1 AND `name` = 'Ann' AND `amount` = 12.34 AND `created` = '2024-03-01 10:20:30' AND `note` IS NULL",
+				"DELETE FROM `shop`.`orders` WHERE `id` = 2",
+			},
+		},
+		{
+			name: "minimal row metadata and unknown table",
+			data: tagsBinlog,
+			want: []string{
+				"INSERT INTO `shop`.`tags` VALUES (-7, X'fffe')",
+			},
+			parseErrors: map[string]uint64{"missing_column_names": 1, "unknown_table": 1},
+		},
+		{
+			name: "no checksums",
+			data: mysql55Binlog,
+			want: []string{"INSERT INTO tags VALUES (1, 'a')"},
+		},
+		{
+			name:      "last event still being written",
+			data:      mysql55Binlog[:len(mysql55Binlog)-3],
+			truncated: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queries, summary, err := extractBinlog(t, tt.data)
+			if err != nil {
+				t.Fatalf("extractQueries() error = %v", err)
+			}
+			var got []string
+			for _, q := range queries {
+				got = append(got, string(q.Raw))
+				if q.Timestamp != 1709288430 {
+					t.Errorf("timestamp = %d, want 1709288430", q.Timestamp)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extractQueries()\n\tgot  %q\n\twant %q", got, tt.want)
+			}
+			if len(summary.ParseErrors) != 0 || len(tt.parseErrors) != 0 {
+				if !reflect.DeepEqual(summary.ParseErrors, tt.parseErrors) {
+					t.Errorf("parse errors = %v, want %v", summary.ParseErrors, tt.parseErrors)
+				}
+			}
+			if summary.Skipped[SkipTruncatedCapture.String()] != tt.truncated {
+				t.Errorf("truncated = %d, want %d", summary.Skipped[SkipTruncatedCapture.String()], tt.truncated)
+			}
+		})
+	}
+}
+
+func Test_InputBinlogRowOffsets(t *testing.T) {
+	queries, _, err := extractBinlog(t, ordersBinlog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the two rows of the insert share an event
+	if queries[2].Offset != queries[1].Offset+1 || queries[2].Length != queries[1].Length-1 {
+		t.Errorf("row offsets = %d+%d, %d+%d", queries[1].Offset, queries[1].Length, queries[2].Offset, queries[2].Length)
+	}
+}
+
+func Test_InputBinlogNotABinlog(t *testing.T) {
+	if _, _, err := extractBinlog(t, []byte("SELECT 1\n")); err == nil {
+		t.Error("extractQueries() error = nil, want missing magic number")
+	}
+}
+
+func Test_BinlogServerVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"5.6.1", true},
+		{"5.6.0", false},
+		{"5.5.62-log", false},
+		{"5.6.51-log", true},
+		{"8.0.32", true},
+		{"10.6.12-MariaDB-log", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := binlogServerVersionAtLeast(tt.version, 5, 6, 1); got != tt.want {
+				t.Errorf("binlogServerVersionAtLeast(%q) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_BinlogDecoderValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		col   binlogColumn
+		bytes string
+		want  string
+	}{
+		{"tinyint", binlogColumn{typ: mysqlTypeTiny}, "ff", "-1"},
+		{"tinyint unsigned", binlogColumn{typ: mysqlTypeTiny, unsigned: true}, "ff", "255"},
+		{"smallint", binlogColumn{typ: mysqlTypeShort}, "0080", "-32768"},
+		{"mediumint", binlogColumn{typ: mysqlTypeInt24}, "feffff", "-2"},
+		{"int", binlogColumn{typ: mysqlTypeLong}, "d2040000", "1234"},
+		{"bigint", binlogColumn{typ: mysqlTypeLongLong}, "ffffffffffffffff", "-1"},
+		{"year", binlogColumn{typ: mysqlTypeYear}, "7c", "2024"},
+		{"year zero", binlogColumn{typ: mysqlTypeYear}, "00", "0"},
+		{"float", binlogColumn{typ: mysqlTypeFloat}, "0000c03f", "1.5"},
+		{"double", binlogColumn{typ: mysqlTypeDouble}, "000000000000f0bf", "-1"},
+		{"decimal", binlogColumn{typ: mysqlTypeNewDecimal, meta: 10<<8 | 2}, "800000 0c22", "12.34"},
+		{"decimal negative", binlogColumn{typ: mysqlTypeNewDecimal, meta: 10<<8 | 2}, "7ffffffaff", "-5.00"},
+		{"decimal zero integral", binlogColumn{typ: mysqlTypeNewDecimal, meta: 4<<8 | 4}, "8007", "0.0007"},
+		{"decimal two groups", binlogColumn{typ: mysqlTypeNewDecimal, meta: 20 << 8}, "8b 3b9ac9ff 00000001", "11999999999000000001"},
+		{"varchar", binlogColumn{typ: mysqlTypeVarchar, meta: 40}, "03616263", "'abc'"},
+		{"varchar long", binlogColumn{typ: mysqlTypeVarchar, meta: 1024}, "03006162 63", "'abc'"},
+		{"varchar escapes", binlogColumn{typ: mysqlTypeVarchar, meta: 40}, "06275c000d0a1a", `'\'\\\0\r\n\Z'`},
+		{"varchar binary", binlogColumn{typ: mysqlTypeVarchar, meta: 40}, "02c328", "X'c328'"},
+		{"char", binlogColumn{typ: mysqlTypeString, meta: mysqlTypeString<<8 | 20}, "026869", "'hi'"},
+		{"enum", binlogColumn{typ: mysqlTypeString, meta: mysqlTypeEnum<<8 | 1}, "02", "2"},
+		{"set", binlogColumn{typ: mysqlTypeString, meta: mysqlTypeSet<<8 | 2}, "0500", "5"},
+		{"blob", binlogColumn{typ: mysqlTypeBlob, meta: 2}, "0300 78797a", "'xyz'"},
+		{"bit", binlogColumn{typ: mysqlTypeBit, meta: 1<<8 | 2}, "0105", "261"},
+		{"date", binlogColumn{typ: mysqlTypeDate}, "61d00f", "'2024-03-01'"},
+		{"timestamp", binlogColumn{typ: mysqlTypeTimestamp}, "eeabe165", "FROM_UNIXTIME(1709288430)"},
+		{"timestamp2", binlogColumn{typ: mysqlTypeTimestamp2}, "65e1abee", "FROM_UNIXTIME(1709288430)"},
+		{"timestamp2 fsp 3", binlogColumn{typ: mysqlTypeTimestamp2, meta: 3}, "65e1abee 04d2", "FROM_UNIXTIME(1709288430.123)"},
+		{"datetime", binlogColumn{typ: mysqlTypeDatetime}, "ce13f58f68120000", "'2024-03-01 10:20:30'"},
+		{"datetime2", binlogColumn{typ: mysqlTypeDatetime2}, "99b2c2a51e", "'2024-03-01 10:20:30'"},
+		{"datetime2 fsp 6", binlogColumn{typ: mysqlTypeDatetime2, meta: 6}, "99b2c2a51e 01e240", "'2024-03-01 10:20:30.123456'"},
+		{"time2", binlogColumn{typ: mysqlTypeTime2}, "80a51e", "'10:20:30'"},
+		{"time2 negative", binlogColumn{typ: mysqlTypeTime2}, "7ff000", "'-01:00:00'"},
+		{"time2 fsp 2", binlogColumn{typ: mysqlTypeTime2, meta: 2}, "80a51e 32", "'10:20:30.50'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := binlogDecoder{buf: binlogEvent(strings.Fields(tt.bytes)...)}
+			got, err := d.value(tt.col)
+			if err != nil || d.err != nil {
+				t.Fatalf("value() error = %v, %v", err, d.err)
+			}
+			if got != tt.want {
+				t.Errorf("value() = %s, want %s", got, tt.want)
+			}
+			if len(d.buf) != 0 {
+				t.Errorf("value() left %d bytes", len(d.buf))
+			}
+		})
+	}
+}
+
+func Test_BinlogDecoderTruncated(t *testing.T) {
+	d := binlogDecoder{buf: binlogEvent("0500")}
+	d.value(binlogColumn{typ: mysqlTypeLong})
+	if d.err == nil {
+		t.Error("value() of a short int, err = nil")
+	}
+	if _, err := d.value(binlogColumn{typ: mysqlTypeGeometry}); err == nil {
+		t.Error("value() of a geometry, err = nil")
+	}
+}
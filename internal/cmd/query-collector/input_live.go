@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/bpf"
+)
+
+// defaultLiveSnapLen fits the packets the kernel coalesces with GRO/TSO,
+// which exceed the MTU
+const defaultLiveSnapLen = 262144
+
+type InputLiveConfig struct {
+	Interface string
+	// Filter is a tcpdump expression, compiled to BPF by tcpdump -ddd, or the
+	// output of tcpdump -ddd itself when tcpdump isn't installed
+	Filter string
+	// Duration stops the capture after this long, zero captures until
+	// interrupted
+	Duration time.Duration
+	SnapLen  int
+}
+
+// compileFilter returns the BPF program of filter
+func compileFilter(filter string) ([]bpf.RawInstruction, error) {
+	if program, err := parseBPF(filter); err == nil {
+		return program, nil
+	}
+	out, err := exec.Command("tcpdump", "-ddd", filter).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error compiling filter %q with tcpdump -ddd: %w", filter, err)
+	}
+	return parseBPF(string(out))
+}
+
+// parseBPF reads the output of tcpdump -ddd: the instruction count, then one
+// instruction per line as "code jt jf k". Commas may stand for newlines.
+func parseBPF(s string) ([]bpf.RawInstruction, error) {
+	lines := strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' })
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("empty BPF program")
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil || n != len(lines)-1 {
+		return nil, fmt.Errorf("not a tcpdump -ddd program")
+	}
+	program := make([]bpf.RawInstruction, 0, n)
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("invalid BPF instruction %q", line)
+		}
+		var values [4]uint64
+		for i, field := range fields {
+			bits := 32
+			if i == 1 || i == 2 {
+				bits = 8
+			} else if i == 0 {
+				bits = 16
+			}
+			if values[i], err = strconv.ParseUint(field, 10, bits); err != nil {
+				return nil, fmt.Errorf("invalid BPF instruction %q: %w", line, err)
+			}
+		}
+		program = append(program, bpf.RawInstruction{
+			Op: uint16(values[0]),
+			Jt: uint8(values[1]),
+			Jf: uint8(values[2]),
+			K:  uint32(values[3]),
+		})
+	}
+	return program, nil
+}
@@ -0,0 +1,134 @@
+//go:build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+
+	"mysql-load-test/pkg/query"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// InputLive captures the queries off a network interface with an AF_PACKET
+// socket, so neither tcpdump nor a pcap file are needed. It needs
+// CAP_NET_RAW.
+type InputLive struct {
+	cfg    InputLiveConfig
+	handle *pcapgo.EthernetHandle
+	pcap   *InputPcap
+	// loopback interfaces hand every frame out twice, as sent and as
+	// received
+	loopback bool
+}
+
+func NewInputLive(cfg InputLiveConfig, pcapCfg InputPcapConfig, common *InputCommon) (*InputLive, error) {
+	if cfg.Interface == "" {
+		return nil, fmt.Errorf("the live input needs an interface")
+	}
+	intf, err := net.InterfaceByName(cfg.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("error opening interface %s: %w", cfg.Interface, err)
+	}
+	if cfg.SnapLen <= 0 {
+		cfg.SnapLen = defaultLiveSnapLen
+	}
+	handle, err := pcapgo.NewEthernetHandle(cfg.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("error opening interface %s: %w", cfg.Interface, err)
+	}
+	if err := handle.SetCaptureLength(cfg.SnapLen); err != nil {
+		handle.Close()
+		return nil, fmt.Errorf("error setting capture length: %w", err)
+	}
+	if cfg.Filter != "" {
+		program, err := compileFilter(cfg.Filter)
+		if err != nil {
+			handle.Close()
+			return nil, err
+		}
+		if err := handle.SetBPF(program); err != nil {
+			handle.Close()
+			return nil, fmt.Errorf("error attaching filter: %w", err)
+		}
+	}
+	return &InputLive{
+		cfg:      cfg,
+		handle:   handle,
+		pcap:     newInputPcap(pcapCfg, nil, nil, common),
+		loopback: intf.Flags&net.FlagLoopback != 0,
+	}, nil
+}
+
+type livePacket struct {
+	data []byte
+	ci   gopacket.CaptureInfo
+	err  error
+}
+
+func (i *InputLive) StartExtractor(ctx context.Context, outChan chan<- *query.Query) error {
+	if i.cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.cfg.Duration)
+		defer cancel()
+	}
+
+	// reads block until a packet arrives, the reader is left behind when
+	// the capture ends and stops with the closed socket
+	packets := make(chan livePacket, 1024)
+	go func() {
+		defer close(packets)
+		for {
+			data, ci, err := i.handle.ReadPacketData()
+			select {
+			case packets <- livePacket{data: data, ci: ci, err: err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// offsets count the captured bytes, there is no file to point into
+	var offset int64
+	var previous []byte
+	for {
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil
+			}
+			return ctx.Err()
+		case pkt, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			if pkt.err != nil {
+				return fmt.Errorf("error reading packet: %w", pkt.err)
+			}
+			if i.loopback && bytes.Equal(pkt.data, previous) {
+				previous = nil
+				continue
+			}
+			previous = pkt.data
+			i.pcap.common.summary.RecordRead()
+			length := int64(len(pkt.data))
+			if err := i.pcap.handlePacket(outChan, pkt.data, pkt.ci, layers.LinkTypeEthernet, offset, length); err != nil {
+				return err
+			}
+			offset += length
+		}
+	}
+}
+
+func (i *InputLive) Destroy() error {
+	i.handle.Close()
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "fmt"
+
+func NewInputLive(cfg InputLiveConfig, pcapCfg InputPcapConfig, common *InputCommon) (Input, error) {
+	return nil, fmt.Errorf("the live input captures with AF_PACKET sockets and is only supported on Linux")
+}
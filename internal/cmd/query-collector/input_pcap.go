@@ -1,19 +1,41 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"net"
 	"os"
+	"strings"
+	"time"
 
 	"mysql-load-test/pkg/query"
 
 	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcapgo"
 )
 
+func init() {
+	// Linux's vxlan driver defaults to 8472 rather than the IANA port that
+	// gopacket already knows about.
+	layers.RegisterUDPPortLayerType(8472, layers.LayerTypeVXLAN)
+}
+
 type InputPcapConfig struct {
 	File string
+	// Ports restricts extraction to packets sent to these server ports. An
+	// empty list accepts every port and relies on payload sniffing alone.
+	Ports []uint16
+	// ServerIPs restricts extraction to packets sent to these addresses.
+	ServerIPs []net.IP
+	// Sessions records the client connection of every query and its
+	// position in it, for the session run mode of load-test. SET statements
+	// are kept and the queries of a session are never deduplicated.
+	Sessions bool
 }
 
 type InputPcap struct {
@@ -21,6 +43,26 @@ type InputPcap struct {
 	reader  io.Reader
 	closers []io.Closer
 	common  *InputCommon
+
+	ports     map[layers.TCPPort]bool
+	serverIPs map[string]bool
+
+	// clients holds the capabilities of the connections whose handshake was
+	// captured, by flow
+	clients map[string]clientCapabilities
+	// statements holds the prepared statements of the connections by flow,
+	// prepares the text of the prepares awaiting the server's response
+	statements map[string]*connStatements
+	prepares   map[string][]byte
+	// sessions numbers the queries of the connections by flow when sessions
+	// are recorded
+	sessions map[string]*captureSession
+}
+
+// captureSession is a client connection followed by the capture
+type captureSession struct {
+	id  uint64
+	seq uint64
 }
 
 func NewInputPcap(cfg InputPcapConfig, common *InputCommon) (*InputPcap, error) {
@@ -36,18 +78,121 @@ func NewInputPcap(cfg InputPcapConfig, common *InputCommon) (*InputPcap, error)
 		return nil, fmt.Errorf("error wrapping reader: %w", err)
 	}
 
+	return newInputPcap(cfg, r, closers, common), nil
+}
+
+// newInputPcap returns the extractor of the packets read from reader, the
+// live input feeds it captured packets instead
+func newInputPcap(cfg InputPcapConfig, reader io.Reader, closers []io.Closer, common *InputCommon) *InputPcap {
+	var ports map[layers.TCPPort]bool
+	if len(cfg.Ports) > 0 {
+		ports = make(map[layers.TCPPort]bool, len(cfg.Ports))
+		for _, port := range cfg.Ports {
+			ports[layers.TCPPort(port)] = true
+		}
+	}
+	var serverIPs map[string]bool
+	if len(cfg.ServerIPs) > 0 {
+		serverIPs = make(map[string]bool, len(cfg.ServerIPs))
+		for _, ip := range cfg.ServerIPs {
+			serverIPs[string(normalizeIP(ip))] = true
+		}
+	}
+
 	return &InputPcap{
-		cfg:     cfg,
-		reader:  r,
-		closers: closers,
-		common:  common,
-	}, nil
+		cfg:       cfg,
+		reader:    reader,
+		closers:   closers,
+		common:    common,
+		ports:     ports,
+		serverIPs: serverIPs,
+		clients:   make(map[string]clientCapabilities),
+
+		statements: make(map[string]*connStatements),
+		prepares:   make(map[string][]byte),
+		sessions:   make(map[string]*captureSession),
+	}
 }
 
 func (i *InputPcap) StartExtractor(ctx context.Context, outChan chan<- *query.Query) error {
 	return i.extractQueriesFromPcap(ctx, outChan)
 }
 
+// encapsulationLayers are the layers counted per packet when looking for the
+// innermost TCP segment.
+var encapsulationLayers = map[gopacket.LayerType]string{
+	layers.LayerTypeDot1Q:    "dot1q",
+	layers.LayerTypeIPv4:     "ipv4",
+	layers.LayerTypeIPv6:     "ipv6",
+	layers.LayerTypeVXLAN:    "vxlan",
+	layers.LayerTypeGeneve:   "geneve",
+	layers.LayerTypeGRE:      "gre",
+	layers.LayerTypeMPLS:     "mpls",
+	layers.LayerTypeERSPANII: "erspan",
+}
+
+// innermostTCP returns the last TCP layer in the packet and the source and
+// destination addresses of the IP layer carrying it, so tunnelled traffic is
+// inspected rather than the outer transport. The returned name describes the
+// encapsulation stack, e.g. "dot1q/ipv4/vxlan/ipv6".
+func innermostTCP(pkt gopacket.Packet) (*layers.TCP, net.IP, net.IP, string) {
+	var tcp *layers.TCP
+	var srcIP, dstIP, lastSrcIP, lastDstIP net.IP
+	var stack []string
+	for _, layer := range pkt.Layers() {
+		if name, ok := encapsulationLayers[layer.LayerType()]; ok {
+			stack = append(stack, name)
+		}
+		switch l := layer.(type) {
+		case *layers.IPv4:
+			lastSrcIP, lastDstIP = l.SrcIP, l.DstIP
+		case *layers.IPv6:
+			lastSrcIP, lastDstIP = l.SrcIP, l.DstIP
+		case *layers.TCP:
+			tcp = l
+			srcIP, dstIP = lastSrcIP, lastDstIP
+		}
+	}
+	return tcp, srcIP, dstIP, strings.Join(stack, "/")
+}
+
+func flowKey(srcIP net.IP, srcPort layers.TCPPort, dstIP net.IP, dstPort layers.TCPPort) string {
+	return net.JoinHostPort(srcIP.String(), srcPort.String()) + ">" + net.JoinHostPort(dstIP.String(), dstPort.String())
+}
+
+func normalizeIP(ip net.IP) net.IP {
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
+
+// acceptsPort cheaply rejects packets not addressed to a configured server
+// port, or sent from one while a prepare awaits its response. The packet is
+// decoded lazily, so only the layers up to the first TCP header are parsed
+// for rejected packets.
+func (i *InputPcap) acceptsPort(pkt gopacket.Packet) bool {
+	if i.ports == nil {
+		return true
+	}
+	tcp, ok := pkt.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	if !ok {
+		// Possibly tunnelled over UDP, let the full decode decide.
+		return pkt.Layer(layers.LayerTypeUDP) != nil
+	}
+	return i.ports[tcp.DstPort] || len(i.prepares) > 0 && i.ports[tcp.SrcPort]
+}
+
+func (i *InputPcap) acceptsServer(tcp *layers.TCP, dstIP net.IP) bool {
+	if i.ports != nil && !i.ports[tcp.DstPort] {
+		return false
+	}
+	if i.serverIPs != nil && !i.serverIPs[string(normalizeIP(dstIP))] {
+		return false
+	}
+	return true
+}
+
 func (i *InputPcap) Destroy() error {
 	var errs []error
 
@@ -91,33 +236,156 @@ func (i *InputPcap) extractQueriesFromPcap(ctx context.Context, outChan chan<- *
 				return fmt.Errorf("error reading packet: %w", err)
 			}
 
+			i.common.summary.RecordRead()
 			posAfter, _ := file.Seek(0, io.SeekCurrent)
 			offset = posBefore
 			length := posAfter - posBefore
 
-			if ci.CaptureLength < ci.Length {
-				continue
+			if err := i.handlePacket(outChan, pktBytes, ci, pcapReader.LinkType(), offset, length); err != nil {
+				return err
 			}
+		}
+	}
+}
 
-			newPkt := gopacket.NewPacket(pktBytes, pcapReader.LinkType(), gopacket.Default)
-			appLayer := newPkt.ApplicationLayer()
-			if appLayer == nil {
-				continue
-			}
+// handlePacket extracts the query of a captured packet. offset and length
+// locate the packet in the input, the returned error stops the input.
+func (i *InputPcap) handlePacket(outChan chan<- *query.Query, pktBytes []byte, ci gopacket.CaptureInfo, linkType layers.LinkType, offset, length int64) error {
+	if ci.CaptureLength < ci.Length {
+		i.common.summary.Skip(SkipTruncatedCapture)
+		return nil
+	}
 
-			payload := appLayer.Payload()
-			if len(payload) < 5 {
-				continue
-			}
-			if payload[4] != 0x03 {
-				continue
-			}
+	newPkt := gopacket.NewPacket(pktBytes, linkType, gopacket.DecodeOptions{Lazy: true, NoCopy: true})
+	if !i.acceptsPort(newPkt) {
+		i.common.summary.Skip(SkipFiltered)
+		return nil
+	}
+	tcp, srcIP, dstIP, encapsulation := innermostTCP(newPkt)
+	if tcp == nil {
+		i.common.summary.Skip(SkipNoPayload)
+		return nil
+	}
+
+	flow := flowKey(srcIP, tcp.SrcPort, dstIP, tcp.DstPort)
+	reverse := flowKey(dstIP, tcp.DstPort, srcIP, tcp.SrcPort)
+	if text, ok := i.prepares[reverse]; ok && len(tcp.Payload) > 0 {
+		// the server answering a prepare of the client
+		delete(i.prepares, reverse)
+		i.connStatements(reverse).prepared(text, tcp.Payload)
+		i.common.summary.Skip(SkipNotComQuery)
+		return nil
+	}
+	if !i.acceptsServer(tcp, dstIP) {
+		i.common.summary.Skip(SkipFiltered)
+		return nil
+	}
+
+	if tcp.FIN || tcp.RST {
+		delete(i.clients, flow)
+		for _, f := range []string{flow, reverse} {
+			delete(i.statements, f)
+			delete(i.prepares, f)
+			delete(i.sessions, f)
+		}
+	}
+
+	payload := tcp.Payload
+	if len(payload) < 5 {
+		i.common.summary.Skip(SkipNoPayload)
+		return nil
+	}
+	if caps, ok := parseHandshakeResponse(payload); ok {
+		i.clients[flow] = caps
+		i.common.summary.Skip(SkipNotComQuery)
+		return nil
+	}
 
-			outChan <- &query.Query{
-				Offset:    uint64(offset),
-				Length:    uint64(length),
-				Timestamp: uint64(ci.Timestamp.Unix()),
+	body := payload[5:]
+	caps, capsKnown := i.clients[flow]
+	var text []byte
+	switch payload[4] {
+	case comQuery:
+		queryAttributes := caps.queryAttributes()
+		if !capsKnown {
+			queryAttributes = looksLikeQueryAttributes(body)
+		}
+		var err error
+		if text, err = comQueryText(body, queryAttributes); err != nil {
+			err = &kindError{kind: "query_attributes", err: fmt.Errorf("error reading query attributes: %w", err)}
+			return i.common.SkipRecord(err, offset, nil)
+		}
+	case comStmtPrepare:
+		i.prepares[flow] = bytes.Clone(body)
+		i.common.summary.Skip(SkipNotComQuery)
+		return nil
+	case comStmtExecute:
+		known := false
+		if stmts, ok := i.statements[flow]; ok {
+			var err error
+			if text, known, err = stmts.execute(body, caps.queryAttributes(), capsKnown); err != nil {
+				err = &kindError{kind: "prepared_statement", err: fmt.Errorf("error binding statement parameters: %w", err)}
+				return i.common.SkipRecord(err, offset, nil)
+			}
+		}
+		if !known {
+			// prepared before the capture started
+			i.common.summary.Skip(SkipUnknownStatement)
+			return nil
+		}
+	case comStmtLongData, comStmtClose:
+		if stmts, ok := i.statements[flow]; ok {
+			if payload[4] == comStmtLongData {
+				stmts.longData(body)
+			} else {
+				stmts.close(body)
 			}
 		}
+		i.common.summary.Skip(SkipNotComQuery)
+		return nil
+	default:
+		i.common.summary.Skip(SkipNotComQuery)
+		return nil
+	}
+	i.common.summary.Encapsulation(encapsulation)
+	i.common.summary.Extracted()
+
+	q := &query.Query{
+		Raw:       text,
+		Offset:    uint64(offset),
+		Length:    uint64(length),
+		Timestamp: uint64(ci.Timestamp.Unix()),
+	}
+	if i.cfg.Sessions {
+		s := i.session(flow, ci.Timestamp)
+		s.seq++
+		q.Session, q.SessionSeq = s.id, s.seq
+	}
+	outChan <- q
+	return nil
+}
+
+// session returns the session of the connection of flow. Its id hashes the
+// flow with the time it was first seen, so a reused client port starts a new
+// session.
+func (i *InputPcap) session(flow string, seen time.Time) *captureSession {
+	s, ok := i.sessions[flow]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(flow))
+		binary.Write(h, binary.LittleEndian, seen.UnixNano())
+		s = &captureSession{id: max(h.Sum64(), 1)}
+		i.sessions[flow] = s
+	}
+	return s
+}
+
+// connStatements returns the prepared statements of the connection of flow
+func (i *InputPcap) connStatements(flow string) *connStatements {
+	c, ok := i.statements[flow]
+	if !ok {
+		c = newConnStatements()
+		i.statements[flow] = c
 	}
+	return c
 }
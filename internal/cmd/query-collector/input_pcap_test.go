@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var (
+	pcapClientIP = net.IPv4(10, 0, 0, 5)
+	pcapServerIP = net.IPv4(10, 0, 0, 1)
+)
+
+const (
+	pcapClientPort = 50000
+	pcapServerPort = 3306
+)
+
+// pcapSegment is a TCP segment of the connection between the test client
+// and server
+type pcapSegment struct {
+	fromServer bool
+	fin        bool
+	payload    []byte
+}
+
+// clientCommand frames a command packet, the sequence id reset to 0
+func clientCommand(command byte, body []byte) []byte {
+	n := len(body) + 1
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), 0, command}, body...)
+}
+
+// handshakeResponse is the HandshakeResponse41 of a client negotiating flags
+func handshakeResponse(flags uint32) []byte {
+	body := []byte{byte(flags), byte(flags >> 8), byte(flags >> 16), byte(flags >> 24)}
+	body = append(body, 0, 0, 0, 1) // max packet size
+	body = append(body, 0xff)       // charset
+	body = append(body, make([]byte, 23)...)
+	body = append(body, "app\x00"...) // username
+	body = append(body, 0)            // auth response length
+	n := len(body)
+	return append([]byte{byte(n), byte(n >> 8), byte(n >> 16), handshakeResponseSeq}, body...)
+}
+
+// pcapFile writes the segments into a capture file
+func pcapFile(t *testing.T, segments []pcapSegment) []byte {
+	t.Helper()
+	var file bytes.Buffer
+	w := pcapgo.NewWriter(&file)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeEthernet); err != nil {
+		t.Fatal(err)
+	}
+	for n, s := range segments {
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: pcapClientIP, DstIP: pcapServerIP}
+		tcp := &layers.TCP{SrcPort: pcapClientPort, DstPort: pcapServerPort, ACK: true, PSH: len(s.payload) > 0, FIN: s.fin}
+		if s.fromServer {
+			ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+			tcp.SrcPort, tcp.DstPort = tcp.DstPort, tcp.SrcPort
+		}
+		eth := &layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 1},
+			DstMAC:       net.HardwareAddr{0, 0, 0, 0, 0, 2},
+			EthernetType: layers.EthernetTypeIPv4,
+		}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, eth, ip, tcp, gopacket.Payload(s.payload)); err != nil {
+			t.Fatal(err)
+		}
+		data := buf.Bytes()
+		ci := gopacket.CaptureInfo{Timestamp: time.Unix(1709288430+int64(n), 0), CaptureLength: len(data), Length: len(data)}
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return file.Bytes()
+}
+
+func Test_InputPcapPreparedStatements(t *testing.T) {
+	prepare := pcapSegment{payload: clientCommand(comStmtPrepare, []byte("SELECT * FROM orders WHERE id = ?"))}
+	prepared := pcapSegment{fromServer: true, payload: prepareOK(1)}
+	execute := pcapSegment{payload: clientCommand(comStmtExecute, protocolPacket(
+		executeHeader,
+		"00",       // null bitmap
+		"01",       // new params bound
+		"0300",     // long
+		"07000000", // 7
+	))}
+
+	tests := []struct {
+		name        string
+		segments    []pcapSegment
+		want        []string
+		unknown     uint64
+		parseErrors map[string]uint64
+	}{
+		{
+			name:     "prepare and execute",
+			segments: []pcapSegment{prepare, prepared, execute, execute},
+			want:     []string{"SELECT * FROM orders WHERE id = 7", "SELECT * FROM orders WHERE id = 7"},
+		},
+		{
+			name: "query attributes",
+			segments: []pcapSegment{
+				{payload: handshakeResponse(clientMySQL | clientProtocol41 | clientQueryAttributes)},
+				prepare,
+				prepared,
+				{payload: clientCommand(comStmtExecute, protocolPacket(
+					"01000000",   // statement id
+					"08",         // flags: parameter count available
+					"01000000",   // iteration count
+					"02",         // parameter count, one attribute
+					"00",         // null bitmap
+					"01",         // new params bound
+					"0300", "00", // long, no name
+					"fd00",             // var string
+					"0774726163656964", // name traceid
+					"07000000",         // 7
+					"03616263",         // abc
+				))},
+				{payload: clientCommand(comQuery, protocolPacket(
+					"00",               // parameter count
+					"01",               // parameter set count
+					"53454c4543542031", // SELECT 1
+				))},
+			},
+			want: []string{"SELECT * FROM orders WHERE id = 7", "SELECT 1"},
+		},
+		{
+			name:     "prepared before the capture",
+			segments: []pcapSegment{execute},
+			unknown:  1,
+		},
+		{
+			name:     "error response",
+			segments: []pcapSegment{prepare, {fromServer: true, payload: protocolPacket("090000", "01", "ff", "2804", "233432303030")}, execute},
+			unknown:  1,
+		},
+		{
+			name:     "closed statement",
+			segments: []pcapSegment{prepare, prepared, {payload: clientCommand(comStmtClose, protocolPacket("01000000"))}, execute},
+			unknown:  1,
+		},
+		{
+			name:     "closed connection",
+			segments: []pcapSegment{prepare, prepared, {fin: true}, execute},
+			unknown:  1,
+		},
+		{
+			name: "truncated parameters",
+			segments: []pcapSegment{prepare, prepared, {payload: clientCommand(comStmtExecute, protocolPacket(
+				executeHeader,
+				"00",   // null bitmap
+				"01",   // new params bound
+				"fd00", // var string
+				"0561", // 5 bytes announced, 1 sent
+			))}},
+			parseErrors: map[string]uint64{"prepared_statement": 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			common, summary := newTestInputCommon(t)
+			cfg := InputPcapConfig{Ports: []uint16{pcapServerPort}}
+			in := newInputPcap(cfg, bytes.NewReader(pcapFile(t, tt.segments)), nil, common)
+
+			queries, err := runExtractor(in.StartExtractor)
+			if err != nil {
+				t.Fatalf("StartExtractor() error = %v", err)
+			}
+			var got []string
+			for _, q := range queries {
+				got = append(got, string(q.Raw))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StartExtractor() = %q, want %q", got, tt.want)
+			}
+
+			snap := summary.Snapshot()
+			if n := snap.Skipped[SkipUnknownStatement.String()]; n != tt.unknown {
+				t.Errorf("unknown statements = %d, want %d", n, tt.unknown)
+			}
+			if len(snap.ParseErrors) != 0 || len(tt.parseErrors) != 0 {
+				if !reflect.DeepEqual(snap.ParseErrors, tt.parseErrors) {
+					t.Errorf("parse errors = %v, want %v", snap.ParseErrors, tt.parseErrors)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mysql-load-test/pkg/query"
+)
+
+// InputPtDigestConfig reads the report of pt-query-digest. The example of
+// every query class is emitted in proportion to its count, so the corpus
+// carries the weights, and the first copy carries the stats of the class.
+type InputPtDigestConfig struct {
+	File string
+	// MaxCopies is how many times the example of the most frequent class is
+	// emitted, the others are scaled down to at least one copy
+	MaxCopies int
+}
+
+var (
+	// # Query 1: 0.02 QPS, 0.00x concurrency, ID 0x8F1E5C8C1B3A6BDA... at byte 1234
+	ptDigestClassRe = regexp.MustCompile(`^# Query \d+: .*\bID (0x[0-9A-Fa-f]+)`)
+	// # Exec time     40     10s   100us    50ms    10ms    20ms     5ms     8ms
+	ptDigestAttributeRe = regexp.MustCompile(`^# (Count|Exec time|Rows sent|Rows examine)\s+\d+\s+(\S+)(?:\s+(\S+)\s+(\S+)\s+(\S+))?`)
+)
+
+// ptDigestClass is a query class of the report
+type ptDigestClass struct {
+	id      string
+	example []byte
+	stats   query.FingerprintStats
+	offset  int64
+	length  int64
+}
+
+type InputPtDigest struct {
+	cfg     InputPtDigestConfig
+	reader  io.Reader
+	closers []io.Closer
+	common  *InputCommon
+}
+
+func NewInputPtDigest(cfg InputPtDigestConfig, common *InputCommon) (*InputPtDigest, error) {
+	if cfg.MaxCopies <= 0 {
+		cfg.MaxCopies = 1000
+	}
+
+	file, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+
+	closers := []io.Closer{file}
+
+	r, err := common.WrapReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping reader: %w", err)
+	}
+
+	return &InputPtDigest{
+		cfg:     cfg,
+		reader:  r,
+		closers: closers,
+		common:  common,
+	}, nil
+}
+
+func (i *InputPtDigest) StartExtractor(ctx context.Context, outChan chan<- *query.Query) error {
+	classes, err := i.parseReport()
+	if err != nil {
+		return err
+	}
+
+	var maxCount uint64
+	for _, c := range classes {
+		maxCount = max(maxCount, c.stats.Count)
+	}
+
+	for _, c := range classes {
+		if len(c.example) == 0 {
+			err := newKindError("missing_example", "query class %s has no example", c.id)
+			if err := i.common.SkipRecord(err, c.offset, nil); err != nil {
+				return err
+			}
+			continue
+		}
+		copies := 1
+		if maxCount > 0 {
+			copies = max(1, int(math.Round(float64(c.stats.Count)/float64(maxCount)*float64(i.cfg.MaxCopies))))
+		}
+		stats := c.stats
+		for n := range copies {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			q := &query.Query{
+				Raw: c.example,
+				// copies are told apart by their offset inside the class
+				Offset: uint64(c.offset) + uint64(n),
+				Length: uint64(c.length),
+			}
+			if n == 0 {
+				q.Stats = &stats
+			}
+			i.common.summary.Extracted()
+			outChan <- q
+		}
+	}
+	return nil
+}
+
+func (i *InputPtDigest) Destroy() error {
+	var errs []error
+
+	for _, closer := range i.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error closing input pt-query-digest: %w", errs[0])
+	}
+
+	return nil
+}
+
+// parseReport reads the query classes of the report, the profile and the
+// overall stats before the first class are skipped
+func (i *InputPtDigest) parseReport() ([]*ptDigestClass, error) {
+	br := bufio.NewReader(i.reader)
+
+	var classes []*ptDigestClass
+	var class *ptDigestClass
+	// statement collects the lines of the example until its \G
+	var statement []byte
+	var offset int64
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("error reading file: %w", err)
+		}
+		if len(line) == 0 && err == io.EOF {
+			break
+		}
+		lineStart := offset
+		offset += int64(len(line))
+		i.common.summary.RecordRead()
+
+		text := strings.TrimRight(string(line), "\r\n")
+		if m := ptDigestClassRe.FindStringSubmatch(text); m != nil {
+			class = &ptDigestClass{id: m[1], offset: lineStart, length: int64(len(line))}
+			classes = append(classes, class)
+			statement = nil
+			continue
+		}
+		if class == nil {
+			continue
+		}
+		// a class runs up to the header of the next one
+		class.length = offset - class.offset
+
+		switch {
+		case strings.HasPrefix(text, "#"):
+			if m := ptDigestAttributeRe.FindStringSubmatch(text); m != nil {
+				if err := class.setAttribute(m); err != nil {
+					i.common.summary.ParseError("invalid_attribute")
+				}
+			}
+		case class.example == nil && strings.TrimSpace(text) != "":
+			statement = append(statement, line...)
+			trimmed := bytes.TrimSpace(statement)
+			if !bytes.HasSuffix(trimmed, []byte(`\G`)) {
+				continue
+			}
+			example := bytes.TrimSpace(bytes.TrimSuffix(trimmed, []byte(`\G`)))
+			statement = nil
+			// the database of the class is switched to before the example,
+			// administrator commands have no text to replay
+			lower := strings.ToLower(string(example))
+			if strings.HasPrefix(lower, "use ") || strings.HasPrefix(lower, "administrator command") {
+				continue
+			}
+			class.example = example
+		}
+
+		if err == io.EOF {
+			break
+		}
+	}
+	return classes, nil
+}
+
+// setAttribute reads the total of the Count line and the averages of the
+// others, m is a match of ptDigestAttributeRe
+func (c *ptDigestClass) setAttribute(m []string) error {
+	if m[1] == "Count" {
+		count, err := parsePtDigestNumber(m[2])
+		c.stats.Count = uint64(count)
+		return err
+	}
+	// total, min, max, avg
+	if m[5] == "" {
+		return fmt.Errorf("missing average of %s", m[1])
+	}
+	avg := m[5]
+	switch m[1] {
+	case "Exec time":
+		d, err := parsePtDigestDuration(avg)
+		c.stats.AvgExecTime = d
+		return err
+	case "Rows sent":
+		n, err := parsePtDigestNumber(avg)
+		c.stats.AvgRowsSent = uint64(math.Round(n))
+		return err
+	case "Rows examine":
+		n, err := parsePtDigestNumber(avg)
+		c.stats.AvgRowsExamined = uint64(math.Round(n))
+		return err
+	}
+	return nil
+}
+
+// parsePtDigestNumber parses the shortened numbers of the report, e.g. 1.23k
+func parsePtDigestNumber(s string) (float64, error) {
+	multiplier := 1.0
+	switch s[len(s)-1] {
+	case 'k':
+		multiplier = 1e3
+	case 'M':
+		multiplier = 1e6
+	case 'G':
+		multiplier = 1e9
+	case 'T':
+		multiplier = 1e12
+	}
+	if multiplier != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q: %w", s, err)
+	}
+	return n * multiplier, nil
+}
+
+// parsePtDigestDuration parses the times of the report, e.g. 100us, 5ms or
+// 2s
+func parsePtDigestDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: %w", s, err)
+	}
+	return d, nil
+}
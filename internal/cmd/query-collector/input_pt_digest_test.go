@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mysql-load-test/pkg/query"
+)
+
+const ptDigestReport = `# 250ms user time, 20ms system time, 25.00M rss, 220.00M vsz
+# Current date: Fri Mar  1 10:30:00 2024
+# Hostname: db1
+# Files: slow.log
+# Overall: 1.20k total, 4 unique, 2.00 QPS, 0.01x concurrency ___________
+# Time range: 2024-03-01T10:20:30 to 2024-03-01T10:30:30
+# Attribute          total     min     max     avg     95%  stddev  median
+# ============     ======= ======= ======= ======= ======= ======= =======
+# Exec time             6s   100us    50ms     5ms    20ms     5ms     3ms
+
+# Profile
+# Rank Query ID                           Response time Calls R/Call V/M
+# ==== ================================== ============= ===== ====== =====
+#    1 0x8F1E5C8C1B3A6BDA7B2D4E6F10203040  5.0000 83.3%  1000 0.0050  0.01 SELECT orders
+#    2 0xAAAABBBBCCCCDDDD1111222233334444  1.0000 16.7%   200 0.0050  0.01 UPDATE orders
+
+# Query 1: 1.67 QPS, 0.01x concurrency, ID 0x8F1E5C8C1B3A6BDA7B2D4E6F10203040 at byte 1234
+# This item is included in the report because it matches --limit.
+# Scores: V/M = 0.01
+# Time range: 2024-03-01T10:20:30 to 2024-03-01T10:30:30
+# Attribute    pct   total     min     max     avg     95%  stddev  median
+# ============ === ======= ======= ======= ======= ======= ======= =======
+# Count         83    1000
+# Exec time     83      5s   100us    50ms     5ms    20ms     5ms     3ms
+# Lock time     50    10ms       0   100us    10us    20us    10us     9us
+# Rows sent     90   1.00k       1       1       1       1       0       1
+# Rows examine  10   1.00k       1       1       1       1       0       1
+# Query size    80  35.00k      35      35      35      35       0      35
+# String:
+# Databases    shop
+# Hosts        10.0.0.5
+# Users        app
+# Query_time distribution
+#   1ms  ################################################################
+#  10ms  ###
+# Tables
+#    SHOW TABLE STATUS FROM ` + "`shop`" + ` LIKE 'orders'\G
+#    SHOW CREATE TABLE ` + "`shop`.`orders`" + `\G
+# EXPLAIN /*!50100 PARTITIONS*/
+SELECT * FROM orders WHERE id = 1\G
+
+# Query 2: 0.33 QPS, 0.00x concurrency, ID 0xAAAABBBBCCCCDDDD1111222233334444 at byte 5678
+# Attribute    pct   total     min     max     avg     95%  stddev  median
+# ============ === ======= ======= ======= ======= ======= ======= =======
+# Count         17     200
+# Exec time     17      1s     1ms    20ms     5ms    10ms     2ms     4ms
+# Rows sent      0       0       0       0       0       0       0       0
+# Rows examine  90 300.00k   1.00k   2.00k   1.50k   2.00k  100.00   1.50k
+use shop\G
+UPDATE orders
+SET status = 'paid'
+WHERE id = 2\G
+
+# Query 3: 0.01 QPS, 0.00x concurrency, ID 0x0000000000000000000000000000AAAA at byte 9012
+# Count          0       6
+# Exec time      0     6ms   100us     2ms     1ms     2ms   500us     1ms
+administrator command: Ping\G
+
+# Query 4: 0.00 QPS, 0.00x concurrency, ID 0x0000000000000000000000000000BBBB at byte 9999
+# Count          0       1
+# Exec time      0    fast    fast    fast    fast    fast       0    fast
+SELECT 4\G
+`
+
+func Test_InputPtDigestExtract(t *testing.T) {
+	common, summary := newTestInputCommon(t)
+	in := &InputPtDigest{
+		cfg:    InputPtDigestConfig{MaxCopies: 10},
+		reader: strings.NewReader(ptDigestReport),
+		common: common,
+	}
+	queries, err := runExtractor(in.StartExtractor)
+	if err != nil {
+		t.Fatalf("StartExtractor() error = %v", err)
+	}
+
+	// the copies follow the counts, 1000 for the most frequent class
+	copies := map[string]int{}
+	stats := map[string]query.FingerprintStats{}
+	for _, q := range queries {
+		copies[string(q.Raw)]++
+		if q.Stats != nil {
+			stats[string(q.Raw)] = *q.Stats
+		}
+	}
+	wantCopies := map[string]int{
+		"SELECT * FROM orders WHERE id = 1":                10,
+		"UPDATE orders\nSET status = 'paid'\nWHERE id = 2": 2,
+		"SELECT 4": 1,
+	}
+	if !reflect.DeepEqual(copies, wantCopies) {
+		t.Errorf("copies = %v, want %v", copies, wantCopies)
+	}
+	wantStats := map[string]query.FingerprintStats{
+		"SELECT * FROM orders WHERE id = 1":                {Count: 1000, AvgExecTime: 5 * time.Millisecond, AvgRowsSent: 1, AvgRowsExamined: 1},
+		"UPDATE orders\nSET status = 'paid'\nWHERE id = 2": {Count: 200, AvgExecTime: 5 * time.Millisecond, AvgRowsExamined: 1500},
+		"SELECT 4": {Count: 1},
+	}
+	if !reflect.DeepEqual(stats, wantStats) {
+		t.Errorf("stats = %v, want %v", stats, wantStats)
+	}
+
+	// the copies of a class are told apart by their offset in the class
+	start := strings.Index(ptDigestReport, "# Query 1:")
+	length := strings.Index(ptDigestReport, "# Query 2:") - start
+	for n, q := range queries[:10] {
+		if q.Offset != uint64(start+n) || q.Length != uint64(length) {
+			t.Errorf("copy %d at %d+%d, want %d+%d", n, q.Offset, q.Length, start+n, length)
+		}
+	}
+
+	parseErrors := map[string]uint64{"missing_example": 1, "invalid_attribute": 1}
+	if got := summary.Snapshot().ParseErrors; !reflect.DeepEqual(got, parseErrors) {
+		t.Errorf("parse errors = %v, want %v", got, parseErrors)
+	}
+}
+
+func Test_ParsePtDigestNumber(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    float64
+		wantErr bool
+	}{
+		{"1000", 1000, false},
+		{"1.50k", 1500, false},
+		{"2.5M", 2.5e6, false},
+		{"1G", 1e9, false},
+		{"3T", 3e12, false},
+		{"100.00", 100, false},
+		{"k", 0, true},
+		{"many", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parsePtDigestNumber(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePtDigestNumber() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parsePtDigestNumber() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_PtDigestClassSetAttribute(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    query.FingerprintStats
+		wantErr bool
+	}{
+		{"# Count         83    1.20k", query.FingerprintStats{Count: 1200}, false},
+		{"# Exec time     83      5s   100us    50ms     5ms    20ms     5ms     3ms", query.FingerprintStats{AvgExecTime: 5 * time.Millisecond}, false},
+		{"# Exec time     83      5s", query.FingerprintStats{}, true},
+		{"# Rows sent     90   1.00k       1       3       2.4       1       0       1", query.FingerprintStats{AvgRowsSent: 2}, false},
+		{"# Rows examine  10   1.00M   1.00k   2.00k   1.50k   2.00k  100.00   1.50k", query.FingerprintStats{AvgRowsExamined: 1500}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			m := ptDigestAttributeRe.FindStringSubmatch(tt.line)
+			if m == nil {
+				t.Fatalf("%q doesn't match", tt.line)
+			}
+			c := &ptDigestClass{}
+			if err := c.setAttribute(m); (err != nil) != tt.wantErr {
+				t.Fatalf("setAttribute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if c.stats != tt.want {
+				t.Errorf("stats = %+v, want %+v", c.stats, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mysql-load-test/pkg/query"
+)
+
+// InputSlowLogConfig reads a MySQL or Percona Server slow query log, for
+// servers whose traffic can't be captured. Every query carries its
+// Query_time, Rows_sent and Rows_examined as stats, set long_query_time to 0
+// to log them all.
+type InputSlowLogConfig struct {
+	File string
+	// ErrorLogInterval is the minimum time between two logged samples of
+	// the same kind of parse error.
+	ErrorLogInterval time.Duration
+}
+
+const (
+	// 240601  9:00:00 of MySQL 5.6 and older Percona Server
+	slowLogTimeLayout = "060102 15:04:05"
+)
+
+var (
+	// # Query_time: 0.000123  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 1
+	slowLogAttributeRe = regexp.MustCompile(`([A-Za-z_]+): (\S+)`)
+	slowLogTimestampRe = regexp.MustCompile(`^(?i)SET timestamp=(\d+);$`)
+	slowLogUseRe       = regexp.MustCompile("^(?i)use `?[^`\\s]+`?;$")
+)
+
+// slowLogEntry is an entry of the log: its comment headers, then the
+// statement
+type slowLogEntry struct {
+	attributes map[string]string
+	timestamp  time.Time
+	statement  []byte
+	// offset and length of the statement lines in the file
+	offset, length int64
+}
+
+type InputSlowLog struct {
+	cfg     InputSlowLogConfig
+	reader  io.Reader
+	closers []io.Closer
+	common  *InputCommon
+	errLog  *rateLimitedErrorLog
+}
+
+func NewInputSlowLog(cfg InputSlowLogConfig, common *InputCommon) (*InputSlowLog, error) {
+	file, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+
+	closers := []io.Closer{file}
+
+	r, err := common.WrapReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping reader: %w", err)
+	}
+
+	return &InputSlowLog{
+		cfg:     cfg,
+		reader:  r,
+		closers: closers,
+		common:  common,
+		errLog:  newRateLimitedErrorLog(os.Stderr, "error parsing slow log entry, skipping", cfg.ErrorLogInterval),
+	}, nil
+}
+
+func (i *InputSlowLog) StartExtractor(ctx context.Context, outChan chan<- *query.Query) error {
+	br := bufio.NewReaderSize(i.reader, 1<<20)
+	var offset int64
+	entry := &slowLogEntry{attributes: make(map[string]string)}
+	// headers tells the entry has header lines, the lines before the first
+	// header are the banner of the log
+	headers := false
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		lineStart := offset
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading file: %w", err)
+		}
+		offset += int64(len(line))
+
+		if len(line) > 0 && line[0] == '#' {
+			if len(entry.statement) > 0 {
+				if err := i.emit(outChan, entry); err != nil {
+					return err
+				}
+				entry = &slowLogEntry{attributes: make(map[string]string)}
+			}
+			headers = true
+			entry.parseHeader(string(bytes.TrimSpace(line)))
+		} else if headers && len(line) > 0 {
+			entry.addLine(line, lineStart)
+		}
+
+		if err == io.EOF {
+			if len(entry.statement) > 0 {
+				return i.emit(outChan, entry)
+			}
+			return nil
+		}
+	}
+}
+
+// emit sends the query of entry, or skips the entry
+func (i *InputSlowLog) emit(outChan chan<- *query.Query, entry *slowLogEntry) error {
+	i.common.summary.RecordRead()
+	q, skip, err := entry.query()
+	switch {
+	case err != nil:
+		i.errLog.Log(err)
+		return i.common.SkipRecord(err, entry.offset, entry.statement)
+	case q == nil:
+		i.common.summary.Skip(skip)
+	default:
+		i.common.summary.Extracted()
+		outChan <- q
+	}
+	return nil
+}
+
+func (i *InputSlowLog) Destroy() error {
+	i.errLog.Close()
+
+	var errs []error
+
+	for _, closer := range i.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error closing input slow log: %w", errs[0])
+	}
+
+	return nil
+}
+
+// parseHeader reads a comment line of the entry. # Time holds a timestamp
+// with spaces in the old format, the other lines hold Name: value pairs.
+func (e *slowLogEntry) parseHeader(line string) {
+	if t, ok := strings.CutPrefix(line, "# Time:"); ok {
+		t = strings.Join(strings.Fields(t), " ")
+		if ts, err := time.Parse(time.RFC3339Nano, t); err == nil {
+			e.timestamp = ts
+		} else if ts, err := time.ParseInLocation(slowLogTimeLayout, t, time.Local); err == nil {
+			e.timestamp = ts
+		}
+		return
+	}
+	for _, m := range slowLogAttributeRe.FindAllStringSubmatch(line, -1) {
+		e.attributes[m[1]] = m[2]
+	}
+}
+
+// addLine adds a statement line. The use and SET timestamp lines the server
+// writes before the query are left out, the timestamp is the start of the
+// query.
+func (e *slowLogEntry) addLine(line []byte, offset int64) {
+	if len(e.statement) == 0 {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 || slowLogUseRe.Match(trimmed) {
+			return
+		}
+		if m := slowLogTimestampRe.FindSubmatch(trimmed); m != nil {
+			if sec, err := strconv.ParseInt(string(m[1]), 10, 64); err == nil {
+				e.timestamp = time.Unix(sec, 0)
+			}
+			return
+		}
+		e.offset = offset
+	}
+	e.statement = append(e.statement, line...)
+	e.length = offset + int64(len(line)) - e.offset
+}
+
+// query returns the query of the entry with its execution as stats, or the
+// reason to skip it
+func (e *slowLogEntry) query() (*query.Query, SkipReason, error) {
+	text := bytes.TrimSpace(e.statement)
+	text = bytes.TrimSpace(bytes.TrimSuffix(text, []byte(";")))
+	if len(text) == 0 {
+		return nil, SkipNoPayload, nil
+	}
+	// Percona Server logs the error of the query
+	if errno, ok := e.attributes["Last_errno"]; ok && errno != "0" {
+		return nil, SkipFiltered, nil
+	}
+
+	stats := &query.FingerprintStats{Count: 1}
+	if v, ok := e.attributes["Query_time"]; ok {
+		seconds, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, 0, newKindError("invalid_query_time", "invalid Query_time %q", v)
+		}
+		stats.AvgExecTime = time.Duration(seconds * float64(time.Second))
+	}
+	for name, field := range map[string]*uint64{"Rows_sent": &stats.AvgRowsSent, "Rows_examined": &stats.AvgRowsExamined} {
+		v, ok := e.attributes[name]
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, 0, newKindError("invalid_rows", "invalid %s %q", name, v)
+		}
+		*field = n
+	}
+
+	q := &query.Query{
+		Raw:    text,
+		Offset: uint64(e.offset),
+		Length: uint64(e.length),
+		Stats:  stats,
+	}
+	if !e.timestamp.IsZero() {
+		q.Timestamp = uint64(e.timestamp.Unix())
+	}
+	return q, 0, nil
+}
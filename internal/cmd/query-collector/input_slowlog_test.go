@@ -0,0 +1,186 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mysql-load-test/pkg/query"
+)
+
+const mysql8SlowLog = `/usr/sbin/mysqld, Version: 8.0.32 (MySQL Community Server - GPL). started with:
+Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+Time                 Id Command    Argument
+# Time: 2024-03-01T10:20:30.123456Z
+# User@Host: app[app] @  [10.0.0.5]  Id:    12
+# Query_time: 0.001500  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 42
+use shop;
+SET timestamp=1709288430;
+SELECT * FROM orders WHERE id = 1;
+# Time: 2024-03-01T10:20:31.000000Z
+# User@Host: app[app] @  [10.0.0.5]  Id:    12
+# Query_time: 0.250000  Lock_time: 0.000000 Rows_sent: 0  Rows_examined: 1000
+SET timestamp=1709288431;
+UPDATE orders
+SET status = 'paid'
+WHERE id = 2;
+`
+
+const perconaSlowLog = `# Time: 240301 10:20:30
+# User@Host: app[app] @ localhost []
+# Thread_id: 7  Schema: shop  Last_errno: 0  Killed: 0
+# Query_time: 2.000000  Lock_time: 0.000100  Rows_sent: 10  Rows_examined: 10  Rows_affected: 0
+SELECT name FROM tags;
+# User@Host: app[app] @ localhost []
+# Thread_id: 7  Schema: shop  Last_errno: 1146  Killed: 0
+# Query_time: 0.000100  Lock_time: 0.000000  Rows_sent: 0  Rows_examined: 0  Rows_affected: 0
+SELECT * FROM missing;
+# User@Host: app[app] @ localhost []
+# Query_time: fast  Lock_time: 0.000000  Rows_sent: 0  Rows_examined: 0
+SELECT 1;
+# User@Host: app[app] @ localhost []
+# Query_time: 0.000100  Lock_time: 0.000000  Rows_sent: many  Rows_examined: 0
+SELECT 2;
+# User@Host: app[app] @ localhost []
+# Query_time: 0.000100  Lock_time: 0.000000  Rows_sent: 0  Rows_examined: 0
+;
+# User@Host: app[app] @ localhost []
+# Query_time: 0.000300  Lock_time: 0.000000  Rows_sent: 3  Rows_examined: 3
+SELECT 3`
+
+func Test_InputSlowLogExtract(t *testing.T) {
+	tests := []struct {
+		name        string
+		log         string
+		want        []*query.Query
+		parseErrors map[string]uint64
+		filtered    uint64
+		noPayload   uint64
+	}{
+		{
+			name: "mysql 8",
+			log:  mysql8SlowLog,
+			want: []*query.Query{
+				{
+					Raw:       []byte("SELECT * FROM orders WHERE id = 1"),
+					Offset:    uint64(strings.Index(mysql8SlowLog, "SELECT")),
+					Length:    uint64(len("SELECT * FROM orders WHERE id = 1;\n")),
+					Timestamp: 1709288430,
+					Stats:     &query.FingerprintStats{Count: 1, AvgExecTime: 1500 * time.Microsecond, AvgRowsSent: 1, AvgRowsExamined: 42},
+				},
+				{
+					Raw:       []byte("UPDATE orders\nSET status = 'paid'\nWHERE id = 2"),
+					Offset:    uint64(strings.Index(mysql8SlowLog, "UPDATE")),
+					Length:    uint64(len("UPDATE orders\nSET status = 'paid'\nWHERE id = 2;\n")),
+					Timestamp: 1709288431,
+					Stats:     &query.FingerprintStats{Count: 1, AvgExecTime: 250 * time.Millisecond, AvgRowsExamined: 1000},
+				},
+			},
+		},
+		{
+			name: "percona server",
+			log:  perconaSlowLog,
+			want: []*query.Query{
+				{
+					Raw:       []byte("SELECT name FROM tags"),
+					Offset:    uint64(strings.Index(perconaSlowLog, "SELECT name")),
+					Length:    uint64(len("SELECT name FROM tags;\n")),
+					Timestamp: uint64(time.Date(2024, 3, 1, 10, 20, 30, 0, time.Local).Unix()),
+					Stats:     &query.FingerprintStats{Count: 1, AvgExecTime: 2 * time.Second, AvgRowsSent: 10, AvgRowsExamined: 10},
+				},
+				{
+					Raw:    []byte("SELECT 3"),
+					Offset: uint64(strings.Index(perconaSlowLog, "SELECT 3")),
+					Length: uint64(len("SELECT 3")),
+					Stats:  &query.FingerprintStats{Count: 1, AvgExecTime: 300 * time.Microsecond, AvgRowsSent: 3, AvgRowsExamined: 3},
+				},
+			},
+			parseErrors: map[string]uint64{"invalid_query_time": 1, "invalid_rows": 1},
+			filtered:    1,
+			noPayload:   1,
+		},
+		{
+			name: "banner only",
+			log:  "/usr/sbin/mysqld, Version: 8.0.32. started with:\nTcp port: 3306\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			common, summary := newTestInputCommon(t)
+			in := &InputSlowLog{
+				reader: strings.NewReader(tt.log),
+				common: common,
+				errLog: newRateLimitedErrorLog(io.Discard, "", 0),
+			}
+			defer in.errLog.Close()
+
+			got, err := runExtractor(in.StartExtractor)
+			if err != nil {
+				t.Fatalf("StartExtractor() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StartExtractor()")
+				for _, q := range got {
+					t.Errorf("\tgot  %q %d+%d @%d %+v", q.Raw, q.Offset, q.Length, q.Timestamp, *q.Stats)
+				}
+				for _, q := range tt.want {
+					t.Errorf("\twant %q %d+%d @%d %+v", q.Raw, q.Offset, q.Length, q.Timestamp, *q.Stats)
+				}
+			}
+
+			snap := summary.Snapshot()
+			if len(snap.ParseErrors) != 0 || len(tt.parseErrors) != 0 {
+				if !reflect.DeepEqual(snap.ParseErrors, tt.parseErrors) {
+					t.Errorf("parse errors = %v, want %v", snap.ParseErrors, tt.parseErrors)
+				}
+			}
+			if n := snap.Skipped[SkipFiltered.String()]; n != tt.filtered {
+				t.Errorf("filtered = %d, want %d", n, tt.filtered)
+			}
+			if n := snap.Skipped[SkipNoPayload.String()]; n != tt.noPayload {
+				t.Errorf("no payload = %d, want %d", n, tt.noPayload)
+			}
+		})
+	}
+}
+
+func Test_SlowLogEntryParseHeader(t *testing.T) {
+	tests := []struct {
+		line       string
+		timestamp  time.Time
+		attributes map[string]string
+	}{
+		{
+			line:       "# Time: 2024-03-01T10:20:30.123456Z",
+			timestamp:  time.Date(2024, 3, 1, 10, 20, 30, 123456000, time.UTC),
+			attributes: map[string]string{},
+		},
+		{
+			line:       "# Time: 240301  9:05:00",
+			timestamp:  time.Date(2024, 3, 1, 9, 5, 0, 0, time.Local),
+			attributes: map[string]string{},
+		},
+		{
+			line:       "# Time: yesterday",
+			attributes: map[string]string{},
+		},
+		{
+			line:       "# Query_time: 0.001500  Lock_time: 0.000010 Rows_sent: 1  Rows_examined: 42",
+			attributes: map[string]string{"Query_time": "0.001500", "Lock_time": "0.000010", "Rows_sent": "1", "Rows_examined": "42"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			e := &slowLogEntry{attributes: make(map[string]string)}
+			e.parseHeader(tt.line)
+			if !e.timestamp.Equal(tt.timestamp) {
+				t.Errorf("timestamp = %v, want %v", e.timestamp, tt.timestamp)
+			}
+			if !reflect.DeepEqual(e.attributes, tt.attributes) {
+				t.Errorf("attributes = %v, want %v", e.attributes, tt.attributes)
+			}
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"mysql-load-test/pkg/query"
+)
+
+// newTestInputCommon returns the common part of an input reading raw files
+// and skipping the records that fail to parse
+func newTestInputCommon(t *testing.T) (*InputCommon, *ExtractionSummary) {
+	t.Helper()
+	summary := NewExtractionSummary()
+	policy, err := NewErrorPolicy(ErrorPolicyConfig{}, summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewInputCommon(InputCommonConfig{}, summary, policy), summary
+}
+
+// runExtractor collects the queries an extractor emits
+func runExtractor(extract func(ctx context.Context, outChan chan<- *query.Query) error) ([]*query.Query, error) {
+	queries := make(chan *query.Query, 1000)
+	err := extract(context.Background(), queries)
+	close(queries)
+	var out []*query.Query
+	for q := range queries {
+		out = append(out, q)
+	}
+	return out, err
+}
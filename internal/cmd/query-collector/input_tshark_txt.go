@@ -14,6 +14,9 @@ import (
 
 type InputTsharkTxtConfig struct {
 	File string
+	// ErrorLogInterval is the minimum time between two logged samples of
+	// the same kind of parse error.
+	ErrorLogInterval time.Duration
 }
 
 type InputTsharkTxt struct {
@@ -21,6 +24,7 @@ type InputTsharkTxt struct {
 	reader  io.Reader
 	closers []io.Closer
 	common  *InputCommon
+	errLog  *rateLimitedErrorLog
 }
 
 func NewInputTsharkTxt(cfg InputTsharkTxtConfig, common *InputCommon) (*InputTsharkTxt, error) {
@@ -41,6 +45,7 @@ func NewInputTsharkTxt(cfg InputTsharkTxtConfig, common *InputCommon) (*InputTsh
 		reader:  r,
 		closers: closers,
 		common:  common,
+		errLog:  newRateLimitedErrorLog(os.Stderr, "error parsing line, skipping", cfg.ErrorLogInterval),
 	}, nil
 }
 
@@ -49,6 +54,8 @@ func (i *InputTsharkTxt) StartExtractor(ctx context.Context, outChan chan<- *que
 }
 
 func (i *InputTsharkTxt) Destroy() error {
+	i.errLog.Close()
+
 	var errs []error
 
 	for _, closer := range i.closers {
@@ -90,12 +97,17 @@ func (i *InputTsharkTxt) extractQueries(ctx context.Context, outChan chan<- *que
 
 			lineLen := len(line)
 			offset += int64(lineLen)
+			i.common.summary.RecordRead()
 
 			q, parseErr := i.parseTsharkTxtLine(line)
 			if parseErr != nil {
-				fmt.Fprintf(os.Stderr, "error parsing line, skipping: %v\n", parseErr)
+				i.errLog.Log(parseErr)
+				if err := i.common.SkipRecord(parseErr, lineStart, line); err != nil {
+					return err
+				}
 				continue
 			}
+			i.common.summary.Extracted()
 
 			// q.Raw = nil
 			q.Offset = uint64(lineStart)
@@ -118,14 +130,14 @@ func (i *InputTsharkTxt) parseTsharkTxtLine(line []byte) (*query.Query, error) {
 	// Jun 23, 2025 10:20:26.262728119 UTC     set session sql_mode='ONLY_FULL_GROUP_BY,STRICT_TRANS_TABLES,NO_ZERO_IN_DATE,NO_ZERO_DATE,ERROR_FOR_DIVISION_BY_ZERO,NO_ENGINE_SUBSTITUTION'
 	tabsSeparated := bytes.Split(line, []byte("\t"))
 	if len(tabsSeparated) != 2 {
-		return nil, fmt.Errorf("invalid line format: %s", line)
+		return nil, newKindError("invalid_line_format", "invalid line format: %s", line)
 	}
 
 	timestamp := string(tabsSeparated[0])
 	rawQuery := bytes.TrimSpace(tabsSeparated[1])
 
 	if len(rawQuery) == 0 {
-		return nil, fmt.Errorf("empty query in line")
+		return nil, newKindError("empty_query", "empty query in line")
 	}
 
 	var parsedTime time.Time
@@ -137,7 +149,7 @@ func (i *InputTsharkTxt) parseTsharkTxtLine(line []byte) (*query.Query, error) {
 		}
 	}
 	if parseErr != nil {
-		return nil, fmt.Errorf("error parsing timestamp: %w", parseErr)
+		return nil, newKindError("invalid_timestamp", "error parsing timestamp: %w", parseErr)
 	}
 
 	return &query.Query{
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mysql-load-test/pkg/query"
+)
+
+// InputVtgateQueryLogConfig reads the query log of VTGate, in the text or
+// the JSON format (--querylog-format). The bind variables of normalized
+// queries are put back into the SQL, so the log needs full bind variables
+// (no --redact-debug-ui-queries).
+type InputVtgateQueryLogConfig struct {
+	File string
+	// ErrorLogInterval is the minimum time between two logged samples of
+	// the same kind of parse error.
+	ErrorLogInterval time.Duration
+}
+
+const (
+	// columns of the text format
+	vtgateTextStartColumn    = 5
+	vtgateTextSQLColumn      = 12
+	vtgateTextBindVarsColumn = 13
+
+	vtgateTimeLayout = "2006-01-02 15:04:05.000000"
+)
+
+var (
+	// bind variables of the text format, e.g.
+	// map[vtg1:type:INT64 value:"1" vtg2:type:TUPLE values:{type:INT64 value:"1"}]
+	vtgateTextBindVarRe = regexp.MustCompile(`(\w+):type:(\w+)((?: value:"(?:[^"\\]|\\.)*")?(?: values:\{type:\w+ value:"(?:[^"\\]|\\.)*"\})*)`)
+	vtgateTextValueRe   = regexp.MustCompile(`(?:type:(\w+) )?value:"((?:[^"\\]|\\.)*)"`)
+	// values left out of the log past its size limit
+	vtgateTruncatedValueRe = regexp.MustCompile(`^\[\d+ bytes\]$`)
+)
+
+// vtgateBindVar is a bind variable as an SQL literal, list for tuples
+type vtgateBindVar struct {
+	literal string
+	list    bool
+}
+
+type vtgateJSONLine struct {
+	Start    string `json:"Start"`
+	SQL      string `json:"SQL"`
+	BindVars map[string]struct {
+		Type  string          `json:"type"`
+		Value json.RawMessage `json:"value"`
+	} `json:"BindVars"`
+}
+
+type InputVtgateQueryLog struct {
+	cfg     InputVtgateQueryLogConfig
+	reader  io.Reader
+	closers []io.Closer
+	common  *InputCommon
+	errLog  *rateLimitedErrorLog
+}
+
+func NewInputVtgateQueryLog(cfg InputVtgateQueryLogConfig, common *InputCommon) (*InputVtgateQueryLog, error) {
+	file, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("error opening file: %w", err)
+	}
+
+	closers := []io.Closer{file}
+
+	r, err := common.WrapReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("error wrapping reader: %w", err)
+	}
+
+	return &InputVtgateQueryLog{
+		cfg:     cfg,
+		reader:  r,
+		closers: closers,
+		common:  common,
+		errLog:  newRateLimitedErrorLog(os.Stderr, "error parsing query log line, skipping", cfg.ErrorLogInterval),
+	}, nil
+}
+
+func (i *InputVtgateQueryLog) StartExtractor(ctx context.Context, outChan chan<- *query.Query) error {
+	br := bufio.NewReaderSize(i.reader, 1<<20)
+	var offset int64
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		lineStart := offset
+		line, err := br.ReadBytes('\n')
+		if len(line) == 0 && err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading file: %w", err)
+		}
+		offset += int64(len(line))
+		i.common.summary.RecordRead()
+
+		q, parseErr := i.parseLine(bytes.TrimSpace(line))
+		switch {
+		case parseErr != nil:
+			i.errLog.Log(parseErr)
+			if err := i.common.SkipRecord(parseErr, lineStart, line); err != nil {
+				return err
+			}
+		case q == nil:
+			// not a query, e.g. a Prepare without SQL
+			i.common.summary.Skip(SkipNotComQuery)
+		default:
+			i.common.summary.Extracted()
+			q.Offset = uint64(lineStart)
+			q.Length = uint64(len(line))
+			outChan <- q
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+func (i *InputVtgateQueryLog) Destroy() error {
+	i.errLog.Close()
+
+	var errs []error
+
+	for _, closer := range i.closers {
+		if err := closer.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error closing input vtgate query log: %w", errs[0])
+	}
+
+	return nil
+}
+
+// parseLine returns the query of a text or JSON log line, nil when the line
+// has no SQL
+func (i *InputVtgateQueryLog) parseLine(line []byte) (*query.Query, error) {
+	if len(line) == 0 {
+		return nil, nil
+	}
+
+	var start, sql string
+	var bindVars map[string]vtgateBindVar
+	var err error
+	if line[0] == '{' {
+		start, sql, bindVars, err = parseVtgateJSONLine(line)
+	} else {
+		start, sql, bindVars, err = parseVtgateTextLine(line)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(sql) == "" {
+		return nil, nil
+	}
+
+	raw, err := substituteVtgateBindVars(sql, bindVars)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &query.Query{Raw: []byte(raw)}
+	for _, layout := range []string{vtgateTimeLayout, time.RFC3339Nano} {
+		if t, err := time.Parse(layout, start); err == nil {
+			q.Timestamp = uint64(t.Unix())
+			break
+		}
+	}
+	return q, nil
+}
+
+func parseVtgateTextLine(line []byte) (string, string, map[string]vtgateBindVar, error) {
+	columns := strings.Split(string(line), "\t")
+	if len(columns) <= vtgateTextBindVarsColumn {
+		return "", "", nil, newKindError("invalid_line_format", "expected at least %d tab separated columns, got %d", vtgateTextBindVarsColumn+1, len(columns))
+	}
+	sql, err := strconv.Unquote(columns[vtgateTextSQLColumn])
+	if err != nil {
+		return "", "", nil, newKindError("invalid_sql", "error unquoting SQL: %w", err)
+	}
+
+	raw := columns[vtgateTextBindVarsColumn]
+	if raw == "[REDACTED]" {
+		return "", "", nil, newKindError("redacted_bind_vars", "bind variables are redacted")
+	}
+	bindVars := make(map[string]vtgateBindVar)
+	for _, m := range vtgateTextBindVarRe.FindAllStringSubmatch(raw, -1) {
+		name, typ, rest := m[1], m[2], m[3]
+		var literals []string
+		for _, v := range vtgateTextValueRe.FindAllStringSubmatch(rest, -1) {
+			valueType := typ
+			if v[1] != "" {
+				valueType = v[1]
+			}
+			value, err := unescapeBackslashes(v[2])
+			if err != nil {
+				return "", "", nil, newKindError("invalid_bind_var", "error unquoting bind variable %s: %w", name, err)
+			}
+			literals = append(literals, vtgateLiteral(valueType, value))
+		}
+		if typ == "TUPLE" {
+			bindVars[name] = vtgateBindVar{literal: "(" + strings.Join(literals, ", ") + ")", list: true}
+		} else if len(literals) == 1 {
+			bindVars[name] = vtgateBindVar{literal: literals[0]}
+		} else if typ == "NULL_TYPE" {
+			bindVars[name] = vtgateBindVar{literal: "NULL"}
+		}
+	}
+	return columns[vtgateTextStartColumn], sql, bindVars, nil
+}
+
+// unescapeBackslashes reverses C-style escapes like those of protobuf text
+// values, which write bytes that aren't printable as octal escapes
+func unescapeBackslashes(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for pos := 0; pos < len(s); pos++ {
+		if s[pos] != '\\' {
+			sb.WriteByte(s[pos])
+			continue
+		}
+		if pos+1 >= len(s) {
+			return "", fmt.Errorf("trailing backslash")
+		}
+		pos++
+		switch c := s[pos]; c {
+		case 'n':
+			sb.WriteByte('\n')
+		case 'r':
+			sb.WriteByte('\r')
+		case 't':
+			sb.WriteByte('\t')
+		case '0', '1', '2', '3':
+			if pos+2 >= len(s) {
+				return "", fmt.Errorf("short octal escape")
+			}
+			n, err := strconv.ParseUint(s[pos:pos+3], 8, 8)
+			if err != nil {
+				return "", fmt.Errorf("invalid octal escape: %w", err)
+			}
+			sb.WriteByte(byte(n))
+			pos += 2
+		default:
+			// quotes and backslashes
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String(), nil
+}
+
+func parseVtgateJSONLine(line []byte) (string, string, map[string]vtgateBindVar, error) {
+	var l vtgateJSONLine
+	if err := json.Unmarshal(line, &l); err != nil {
+		return "", "", nil, newKindError("invalid_json", "error decoding JSON line: %w", err)
+	}
+	bindVars := make(map[string]vtgateBindVar, len(l.BindVars))
+	for name, bv := range l.BindVars {
+		// numbers are written as they are, everything else quoted
+		var value string
+		if err := json.Unmarshal(bv.Value, &value); err != nil {
+			value = string(bv.Value)
+		}
+		if bv.Type == "TUPLE" {
+			// the JSON format doesn't log the values of tuples
+			continue
+		}
+		bindVars[name] = vtgateBindVar{literal: vtgateLiteral(bv.Type, value)}
+	}
+	return l.Start, l.SQL, bindVars, nil
+}
+
+// vtgateLiteral formats a value of a Vitess type as an SQL literal
+func vtgateLiteral(typ, value string) string {
+	switch {
+	case typ == "NULL_TYPE":
+		return "NULL"
+	case strings.HasPrefix(typ, "INT"), strings.HasPrefix(typ, "UINT"), strings.HasPrefix(typ, "FLOAT"),
+		typ == "DECIMAL", typ == "YEAR", typ == "BIT":
+		return value
+	case typ == "HEXNUM", typ == "HEXVAL", typ == "BITNUM":
+		// already written as a literal, e.g. 0x1f or X'1f'
+		return value
+	}
+	return quoteStringLiteral([]byte(value))
+}
+
+// substituteVtgateBindVars replaces the :name and ::name placeholders of
+// sql outside of quotes with the bind variables
+func substituteVtgateBindVars(sql string, bindVars map[string]vtgateBindVar) (string, error) {
+	var sb strings.Builder
+	sb.Grow(len(sql))
+	var quote byte
+	for pos := 0; pos < len(sql); pos++ {
+		c := sql[pos]
+		if quote != 0 {
+			sb.WriteByte(c)
+			switch {
+			case c == '\\' && quote != '`' && pos+1 < len(sql):
+				pos++
+				sb.WriteByte(sql[pos])
+			case c == quote:
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '\'', '"', '`':
+			quote = c
+			sb.WriteByte(c)
+			continue
+		case ':':
+		default:
+			sb.WriteByte(c)
+			continue
+		}
+
+		start := pos + 1
+		list := start < len(sql) && sql[start] == ':'
+		if list {
+			start++
+		}
+		end := start
+		for end < len(sql) && (sql[end] == '_' || isAlnum(sql[end])) {
+			end++
+		}
+		if end == start || isDigit(sql[start]) {
+			sb.WriteByte(c)
+			continue
+		}
+		name := sql[start:end]
+		bv, ok := bindVars[name]
+		if !ok {
+			return "", newKindError("missing_bind_var", "no value for bind variable %s", name)
+		}
+		if vtgateTruncatedValueRe.MatchString(strings.Trim(bv.literal, "'")) {
+			return "", newKindError("truncated_bind_var", "value of bind variable %s was left out of the log", name)
+		}
+		if list != bv.list {
+			return "", newKindError("invalid_bind_var", "bind variable %s used as a list and a value", name)
+		}
+		sb.WriteString(bv.literal)
+		pos = end - 1
+	}
+	return sb.String(), nil
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isAlnum(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/pprof"
 	"syscall"
@@ -15,6 +16,7 @@ import (
 
 	"github.com/spf13/cobra"
 
+	myerror "mysql-load-test/internal/error"
 	"mysql-load-test/pkg/query"
 
 	_ "net/http/pprof"
@@ -36,6 +38,18 @@ func createInput(cfg *AppConfig, inputCommon *InputCommon) (Input, error) {
 		return NewInputTsharkTxt(cfg.InputTsharkTxt, inputCommon)
 	case "pcap":
 		return NewInputPcap(cfg.InputPcap, inputCommon)
+	case "live":
+		return NewInputLive(cfg.InputLive, cfg.InputPcap, inputCommon)
+	case "binlog":
+		return NewInputBinlog(cfg.InputBinlog, inputCommon)
+	case "pt-query-digest":
+		return NewInputPtDigest(cfg.InputPtDigest, inputCommon)
+	case "vtgate-querylog":
+		return NewInputVtgateQueryLog(cfg.InputVtgateQueryLog, inputCommon)
+	case "audit-log":
+		return NewInputAuditLog(cfg.InputAuditLog, inputCommon)
+	case "slowlog":
+		return NewInputSlowLog(cfg.InputSlowLog, inputCommon)
 	default:
 		return nil, fmt.Errorf("unsupported input type: %s", cfg.Input.Type)
 	}
@@ -46,26 +60,81 @@ func createOutput(cfg *AppConfig, outputCommon *OutputCommon) (Output, error) {
 	case "cache":
 		return NewCacheOutput(cfg.OutputCache, outputCommon)
 	case "db":
-		return NewDBOutput(cfg.OutputDB)
+		dbCfg := cfg.OutputDB
+		dbCfg.Source = inputSource(cfg)
+		return NewDBOutput(dbCfg, outputCommon)
 	case "stats":
-		return NewOutputStats(), nil
+		return NewOutputStats(outputCommon), nil
+	case "stdout":
+		return NewOutputStdout(cfg.OutputStdout, outputCommon)
 	default:
 		return nil, fmt.Errorf("unsupported output type: %s", cfg.Output.Type)
 	}
 }
 
+// inputSource names the input file in the batch ledger
+func inputSource(cfg *AppConfig) string {
+	var file string
+	switch cfg.Input.Type {
+	case "tshark-txt":
+		file = cfg.InputTsharkTxt.File
+	case "pcap":
+		file = cfg.InputPcap.File
+	case "live":
+		return cfg.Input.Type + ":" + cfg.InputLive.Interface
+	case "binlog":
+		file = cfg.InputBinlog.File
+	case "pt-query-digest":
+		file = cfg.InputPtDigest.File
+	case "vtgate-querylog":
+		file = cfg.InputVtgateQueryLog.File
+	case "audit-log":
+		file = cfg.InputAuditLog.File
+	case "slowlog":
+		file = cfg.InputSlowLog.File
+	}
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	return cfg.Input.Type + ":" + file
+}
+
 func (c *CollectCmd) Execute() error {
-	ctx, cancel := context.WithCancelCause(context.Background())
+	if c.cfg.Daemon.Enabled {
+		return NewDaemon(c.cfg).Run()
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
+	return c.collect(context.Background(), NewExtractionSummary(), signalChan)
+}
+
+// collect runs the pipeline once, until the output consumed the whole input,
+// parent is done or a signal arrives on signalChan
+func (c *CollectCmd) collect(parent context.Context, summary *ExtractionSummary, signalChan <-chan os.Signal) error {
+	ctx, cancel := context.WithCancelCause(parent)
 	defer cancel(nil)
 
+	defer c.reportSummary(summary)
+
 	extractedQueriesChan := make(chan *query.Query, 1_000_000)
 	processedQueriesChan := make(chan *query.Query, 1_000_000)
 
+	errorPolicy, err := NewErrorPolicy(c.cfg.ErrorPolicy, summary)
+	if err != nil {
+		return fmt.Errorf("error creating error policy: %w", err)
+	}
+	defer func() {
+		if err := errorPolicy.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}()
+
 	// input
 	inCommon := NewInputCommon(InputCommonConfig{
 		Type:     c.cfg.Input.Type,
 		Encoding: c.cfg.Input.Encoding,
-	})
+	}, summary, errorPolicy)
 	in, err := createInput(c.cfg, inCommon)
 	if err != nil {
 		return fmt.Errorf("error creating input: %w", err)
@@ -82,13 +151,38 @@ func (c *CollectCmd) Execute() error {
 
 	// processor
 	proc, err := NewProcessor(ProcessorConfig{
-		MaxConcurrency:   c.cfg.Processor.MaxConcurrency,
-		ProgressInterval: c.cfg.Processor.ProgressInterval,
-	})
+		MaxConcurrency:     c.cfg.Processor.MaxConcurrency,
+		ProgressInterval:   c.cfg.Processor.ProgressInterval,
+		Dedup:              c.cfg.Processor.Dedup,
+		DedupDir:           c.cfg.Processor.DedupDir,
+		DedupExpectedItems: c.cfg.Processor.DedupExpectedItems,
+		Transforms:         c.cfg.Processor.Transforms,
+		Audit:              c.cfg.AuditFile != "",
+
+		FingerprintServers:   c.cfg.Processor.FingerprintServers,
+		FingerprintBatchSize: c.cfg.Processor.FingerprintBatchSize,
+
+		FingerprintTimeout:          c.cfg.Processor.FingerprintTimeout,
+		FingerprintRetries:          c.cfg.Processor.FingerprintRetries,
+		FingerprintBreakerThreshold: c.cfg.Processor.FingerprintBreakerThreshold,
+		FingerprintBreakerCooldown:  c.cfg.Processor.FingerprintBreakerCooldown,
+	}, summary, errorPolicy)
 	if err != nil {
 		return fmt.Errorf("error creating processor: %w", err)
 	}
 	defer proc.Close()
+	if proc.audit != nil {
+		defer c.reportAudit(proc.audit)
+	}
+
+	var metrics *collectorMetrics
+	if c.cfg.MetricsAddr != "" {
+		metrics = newCollectorMetrics(summary, proc)
+		if err := metrics.Serve(ctx, c.cfg.MetricsAddr); err != nil {
+			return fmt.Errorf("error starting metrics server: %w", err)
+		}
+	}
+
 	go func() {
 		if err := proc.StartProcessingQueries(ctx, extractedQueriesChan, processedQueriesChan); err != nil {
 			cancel(fmt.Errorf("error processing queries: %w", err))
@@ -103,7 +197,10 @@ func (c *CollectCmd) Execute() error {
 		outCommon := NewOutputCommon(OutputCommonConfig{
 			Type:     c.cfg.Output.Type,
 			Encoding: c.cfg.Output.Encoding,
-		})
+		}, errorPolicy)
+		if metrics != nil {
+			outCommon.SetWrittenCounter(&metrics.written)
+		}
 		out, err := createOutput(c.cfg, outCommon)
 		if err != nil {
 			return fmt.Errorf("error creating output: %w", err)
@@ -125,9 +222,6 @@ func (c *CollectCmd) Execute() error {
 		cancel(nil)
 	}
 
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGTERM, syscall.SIGINT)
-
 	select {
 	case <-ctx.Done():
 		fmt.Printf("Received interrupt, exiting...\n")
@@ -143,6 +237,23 @@ func (c *CollectCmd) Execute() error {
 	}
 }
 
+func (c *CollectCmd) reportSummary(summary *ExtractionSummary) {
+	summary.Print()
+	if c.cfg.SummaryFile != "" {
+		if err := summary.WriteJSON(c.cfg.SummaryFile); err != nil {
+			fmt.Fprintf(os.Stderr, "error writing summary file: %v\n", err)
+		}
+	}
+}
+
+func (c *CollectCmd) reportAudit(audit *AnonymizationAudit) {
+	report := audit.Report()
+	report.Print()
+	if err := report.WriteJSON(c.cfg.AuditFile); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing audit file: %v\n", err)
+	}
+}
+
 // NewCommand creates a new cobra command for importing queries
 func NewCommand() *cobra.Command {
 	cfg := NewAppConfig()
@@ -159,21 +270,64 @@ func NewCommand() *cobra.Command {
 			cfg.Input.Type, _ = cmd.Flags().GetString("input.type")
 
 			cfg.InputTsharkTxt.File, _ = cmd.Flags().GetString("input.tshark-txt.file")
+			cfg.InputTsharkTxt.ErrorLogInterval, _ = cmd.Flags().GetDuration("input.tshark-txt.error-log-interval")
 
 			// cfg.InputCache.File, _ = cmd.Flags().GetString("input.cache.file")
 			// cfg.InputCache.ImportName = importnName
 
 			cfg.InputPcap.File, _ = cmd.Flags().GetString("input.pcap.file")
+			pcapPorts, _ := cmd.Flags().GetUintSlice("input.pcap.ports")
+			for _, port := range pcapPorts {
+				if port == 0 || port > 65535 {
+					return fmt.Errorf("invalid port in input.pcap.ports: %d", port)
+				}
+				cfg.InputPcap.Ports = append(cfg.InputPcap.Ports, uint16(port))
+			}
+			pcapServerIPs, _ := cmd.Flags().GetIPSlice("input.pcap.server-ips")
+			cfg.InputPcap.ServerIPs = pcapServerIPs
+			cfg.InputPcap.Sessions, _ = cmd.Flags().GetBool("input.pcap.sessions")
+
+			cfg.InputLive.Interface, _ = cmd.Flags().GetString("input.live.interface")
+			cfg.InputLive.Filter, _ = cmd.Flags().GetString("input.live.filter")
+			cfg.InputLive.Duration, _ = cmd.Flags().GetDuration("input.live.duration")
+			cfg.InputLive.SnapLen, _ = cmd.Flags().GetInt("input.live.snaplen")
+
+			cfg.InputBinlog.File, _ = cmd.Flags().GetString("input.binlog.file")
+			cfg.InputBinlog.ErrorLogInterval, _ = cmd.Flags().GetDuration("input.binlog.error-log-interval")
+
+			cfg.InputPtDigest.File, _ = cmd.Flags().GetString("input.pt-query-digest.file")
+			cfg.InputPtDigest.MaxCopies, _ = cmd.Flags().GetInt("input.pt-query-digest.max-copies")
+
+			cfg.InputVtgateQueryLog.File, _ = cmd.Flags().GetString("input.vtgate-querylog.file")
+			cfg.InputVtgateQueryLog.ErrorLogInterval, _ = cmd.Flags().GetDuration("input.vtgate-querylog.error-log-interval")
+
+			cfg.InputAuditLog.File, _ = cmd.Flags().GetString("input.audit-log.file")
+			cfg.InputAuditLog.ErrorLogInterval, _ = cmd.Flags().GetDuration("input.audit-log.error-log-interval")
+
+			cfg.InputSlowLog.File, _ = cmd.Flags().GetString("input.slowlog.file")
+			cfg.InputSlowLog.ErrorLogInterval, _ = cmd.Flags().GetDuration("input.slowlog.error-log-interval")
 
 			cfg.Processor.MaxConcurrency, _ = cmd.Flags().GetInt("processor.max-concurrency")
 			cfg.Processor.ProgressInterval, _ = cmd.Flags().GetDuration("processor.progress-interval")
-			cfg.Processor.FingerprintServers = []string{"http://localhost:6617"}
+			cfg.Processor.FingerprintServers, _ = cmd.Flags().GetStringSlice("processor.fingerprint-servers")
+			cfg.Processor.FingerprintBatchSize, _ = cmd.Flags().GetInt("processor.fingerprint-batch-size")
+			cfg.Processor.FingerprintTimeout, _ = cmd.Flags().GetDuration("processor.fingerprint-timeout")
+			cfg.Processor.FingerprintRetries, _ = cmd.Flags().GetInt("processor.fingerprint-retries")
+			cfg.Processor.FingerprintBreakerThreshold, _ = cmd.Flags().GetInt("processor.fingerprint-breaker-threshold")
+			cfg.Processor.FingerprintBreakerCooldown, _ = cmd.Flags().GetDuration("processor.fingerprint-breaker-cooldown")
+			cfg.Processor.Dedup, _ = cmd.Flags().GetString("processor.dedup")
+			cfg.Processor.DedupDir, _ = cmd.Flags().GetString("processor.dedup-dir")
+			cfg.Processor.DedupExpectedItems, _ = cmd.Flags().GetUint64("processor.dedup-expected-items")
+			cfg.Processor.Transforms, _ = cmd.Flags().GetStringSlice("processor.transforms")
 
 			cfg.Output.Encoding, _ = cmd.Flags().GetString("output.encoding")
 			cfg.Output.Type, _ = cmd.Flags().GetString("output.type")
 
 			cfg.OutputCache.File, _ = cmd.Flags().GetString("output.cache.file")
 
+			cfg.OutputStdout.Rate, _ = cmd.Flags().GetFloat64("output.stdout.rate")
+			cfg.OutputStdout.Format, _ = cmd.Flags().GetString("output.stdout.format")
+
 			cfg.OutputDB.Host, _ = cmd.Flags().GetString("output.db.host")
 			cfg.OutputDB.Port, _ = cmd.Flags().GetInt("output.db.port")
 			cfg.OutputDB.User, _ = cmd.Flags().GetString("output.db.user")
@@ -181,34 +335,101 @@ func NewCommand() *cobra.Command {
 			cfg.OutputDB.DBName, _ = cmd.Flags().GetString("output.db.name")
 			cfg.OutputDB.Truncate, _ = cmd.Flags().GetBool("output.db.truncate")
 			cfg.OutputDB.BatchSize, _ = cmd.Flags().GetInt("output.db.batch-size")
+			cfg.OutputDB.Resume, _ = cmd.Flags().GetBool("output.db.resume")
+
+			cfg.ErrorPolicy.Mode, _ = cmd.Flags().GetString("error-policy")
+			cfg.ErrorPolicy.DeadLetterFile, _ = cmd.Flags().GetString("error-policy.dead-letter-file")
+
+			cfg.SummaryFile, _ = cmd.Flags().GetString("summary.file")
+			cfg.AuditFile, _ = cmd.Flags().GetString("audit.file")
+			cfg.MetricsAddr, _ = cmd.Flags().GetString("metrics-addr")
+
+			cfg.Daemon.Enabled, _ = cmd.Flags().GetBool("daemon")
+			cfg.Daemon.Interface, _ = cmd.Flags().GetString("daemon.interface")
+			cfg.Daemon.Filter, _ = cmd.Flags().GetString("daemon.filter")
+			cfg.Daemon.Dir, _ = cmd.Flags().GetString("daemon.dir")
+			cfg.Daemon.Rotate, _ = cmd.Flags().GetDuration("daemon.rotate")
+			cfg.Daemon.Keep, _ = cmd.Flags().GetInt("daemon.keep")
+			cfg.Daemon.Addr, _ = cmd.Flags().GetString("daemon.addr")
+			cfg.Daemon.Drift.Baseline, _ = cmd.Flags().GetString("daemon.drift.baseline")
+			cfg.Daemon.Drift.Window, _ = cmd.Flags().GetInt("daemon.drift.window")
+			cfg.Daemon.Drift.Threshold, _ = cmd.Flags().GetFloat64("daemon.drift.threshold")
+
+			if !cfg.Daemon.Enabled && (cfg.Input.Type == "" || cfg.Input.Encoding == "") {
+				return fmt.Errorf("input.type and input.encoding are required")
+			}
 
 			return NewImportCmd(cfg).Execute()
 		},
 	}
 
 	// input
-	cmd.Flags().String("input.type", "", "Type of the input file (cache, pcap)")
+	cmd.Flags().String("input.type", "", "Type of the input file (tshark-txt, pcap, live, binlog, pt-query-digest, vtgate-querylog, audit-log, slowlog)")
 	cmd.Flags().String("input.encoding", "", "Encoding of the input file (plain, gzip, zstd)")
 
 	// input.tshark-txt
 	cmd.Flags().String("input.tshark-txt.file", "", "Path to the tshark-txt file containing queries")
+	cmd.Flags().Duration("input.tshark-txt.error-log-interval", 10*time.Second, "Minimum interval between logged samples of the same kind of parse error")
 
 	// input.cache
 	cmd.Flags().String("input.cache.file", "", "Path to the cache file containing queries")
 
 	// input.pcap
 	cmd.Flags().String("input.pcap.file", "", "Path to the pcap file containing queries")
+	cmd.Flags().UintSlice("input.pcap.ports", nil, "Server ports carrying MySQL traffic, e.g. 3306,3307,6033, for the pcap and live inputs (default: any port)")
+	cmd.Flags().IPSlice("input.pcap.server-ips", nil, "Only extract queries sent to these server IPs, for the pcap and live inputs (default: any address)")
+	cmd.Flags().Bool("input.pcap.sessions", false, "Record the client connection of every query for the session run mode of load-test, for the pcap and live inputs")
+
+	// input.live
+	cmd.Flags().String("input.live.interface", "", "Network interface to capture queries from, needs CAP_NET_RAW (Linux only)")
+	cmd.Flags().String("input.live.filter", "", "BPF filter applied in the kernel, a tcpdump expression compiled with tcpdump -ddd or the output of tcpdump -ddd")
+	cmd.Flags().Duration("input.live.duration", 0, "Stop the capture after this long and finish the run (default: until interrupted)")
+	cmd.Flags().Int("input.live.snaplen", defaultLiveSnapLen, "Maximum bytes captured per packet, longer packets are skipped as truncated")
+
+	// input.binlog
+	cmd.Flags().String("input.binlog.file", "", "Path to the MySQL binary log, row events need binlog_row_metadata=FULL for UPDATE and DELETE")
+	cmd.Flags().Duration("input.binlog.error-log-interval", 10*time.Second, "Minimum interval between logged samples of the same kind of parse error")
+
+	// input.pt-query-digest
+	cmd.Flags().String("input.pt-query-digest.file", "", "Path to a pt-query-digest report, the example of every query class is collected")
+	cmd.Flags().Int("input.pt-query-digest.max-copies", 1000, "Copies of the example of the most frequent class, the others are scaled by their count")
+
+	// input.vtgate-querylog
+	cmd.Flags().String("input.vtgate-querylog.file", "", "Path to a VTGate query log in the text or JSON format, bind variables are put back into the SQL")
+	cmd.Flags().Duration("input.vtgate-querylog.error-log-interval", 10*time.Second, "Minimum interval between logged samples of the same kind of parse error")
+
+	// input.audit-log
+	cmd.Flags().String("input.audit-log.file", "", "Path to an RDS, Aurora or Azure MySQL audit log, e.g. a CloudWatch Logs export")
+	cmd.Flags().Duration("input.audit-log.error-log-interval", 10*time.Second, "Minimum interval between logged samples of the same kind of parse error")
+
+	// input.slowlog
+	cmd.Flags().String("input.slowlog.file", "", "Path to a MySQL or Percona Server slow query log")
+	cmd.Flags().Duration("input.slowlog.error-log-interval", 10*time.Second, "Minimum interval between logged samples of the same kind of parse error")
 
 	// processor
 	cmd.Flags().Int("processor.max-concurrency", runtime.NumCPU(), "Maximum number of concurrent workers")
 	cmd.Flags().Duration("processor.progress-interval", 5*time.Second, "Interval for reporting progress")
+	cmd.Flags().StringSlice("processor.fingerprint-servers", nil, "Fingerprint server URLs to normalize and hash queries remotely, e.g. http://localhost:6617")
+	cmd.Flags().Int("processor.fingerprint-batch-size", 500, "Number of queries sent to a fingerprint server per request")
+	cmd.Flags().Duration("processor.fingerprint-timeout", 10*time.Second, "Timeout of a single request to a fingerprint server (0 to disable)")
+	cmd.Flags().Int("processor.fingerprint-retries", 2, "Number of other fingerprint servers tried when one is unreachable")
+	cmd.Flags().Int("processor.fingerprint-breaker-threshold", 5, "Consecutive failures before a fingerprint server is skipped (0 to disable)")
+	cmd.Flags().Duration("processor.fingerprint-breaker-cooldown", 10*time.Second, "How long a failing fingerprint server is skipped")
+	cmd.Flags().String("processor.dedup", "", "Drop queries already seen by hash (memory, disk)")
+	cmd.Flags().String("processor.dedup-dir", os.TempDir(), "Directory for the disk-backed dedup index")
+	cmd.Flags().StringSlice("processor.transforms", nil, "Registered transformers to apply to queries, in order")
+	cmd.Flags().Uint64("processor.dedup-expected-items", 100_000_000, "Expected number of unique queries, the initial size of the disk-backed dedup index, which grows past it")
 
 	// output
 	cmd.Flags().String("output.encoding", "", "Encoding of the output file (plain, gzip, zstd)")
-	cmd.Flags().String("output.type", "", "Type of the output file (cache)")
+	cmd.Flags().String("output.type", "", "Type of the output (cache, db, stats, stdout)")
 
 	cmd.Flags().String("output.cache.file", "", "Path to the cache file containing queries")
 
+	// output stdout
+	cmd.Flags().Float64("output.stdout.rate", 0, "Maximum number of queries printed per second, the others are skipped (0 to print all)")
+	cmd.Flags().String("output.stdout.format", "pretty", "Format of the printed queries (pretty, json)")
+
 	// output db
 	cmd.Flags().String("output.db.host", "", "Host of the database")
 	cmd.Flags().Int("output.db.port", 3306, "Port of the database")
@@ -217,10 +438,28 @@ func NewCommand() *cobra.Command {
 	cmd.Flags().String("output.db.name", "", "Name of the database")
 	cmd.Flags().Bool("output.db.truncate", false, "Truncate tables before inserting queries")
 	cmd.Flags().Int("output.db.batch-size", 1000, "Maximum number of queries to insert in a single batch")
+	cmd.Flags().Bool("output.db.resume", false, "Skip the queries a previous run over the same input already committed")
+
+	cmd.Flags().String("error-policy", ErrorPolicySkip, "What a record failing in the input, processor or output does: fail-fast stops the run, skip-and-count drops and counts it, dead-letter also writes it to the dead letter file")
+	cmd.Flags().String("error-policy.dead-letter-file", "", "NDJSON file receiving the failed records with the dead-letter error policy")
+
+	cmd.Flags().String("summary.file", "", "Write the extraction summary as JSON to this file")
+	cmd.Flags().String("audit.file", "", "Audit the queries for emails, card numbers and IPs left after the transforms and write the report as JSON to this file")
+	cmd.Flags().String("metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9100")
+
+	// daemon
+	cmd.Flags().Bool("daemon", false, "Capture live traffic continuously and process it in rotated segments")
+	cmd.Flags().String("daemon.interface", "", "Network interface to capture in daemon mode")
+	cmd.Flags().String("daemon.filter", "tcp port 3306", "BPF filter of the live capture")
+	cmd.Flags().String("daemon.dir", "collector-segments", "Directory receiving the capture segments and their output")
+	cmd.Flags().Duration("daemon.rotate", time.Hour, "How often the capture starts a new segment")
+	cmd.Flags().Int("daemon.keep", 24, "Number of processed segments kept (0 keeps all)")
+	cmd.Flags().String("daemon.addr", ":9100", "Serve /healthz, /stats and /metrics of the daemon on this address")
+	cmd.Flags().String("daemon.drift.baseline", "", "Cache file of the load test corpus to measure workload drift against, defaults to the first window")
+	cmd.Flags().Int("daemon.drift.window", 1, "Number of latest segments compared to the drift baseline")
+	cmd.Flags().Float64("daemon.drift.threshold", 0.2, "Jensen-Shannon divergence above which the drift alert fires (0 disables it)")
 
 	// Mark required flags
-	cmd.MarkFlagRequired("input.type")
-	cmd.MarkFlagRequired("input.encoding")
 	// cmd.MarkFlagRequired("import-name")
 
 	return cmd
@@ -262,7 +501,7 @@ func main() {
 	}
 
 	if err := NewCommand().Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Println(myerror.Verbose(err))
 		os.Exit(1)
 	}
 }
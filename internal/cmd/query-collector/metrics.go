@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "query_collector"
+
+var (
+	recordsReadDesc = prometheus.NewDesc(metricsNamespace+"_records_read_total",
+		"Packets or lines read from the input.", nil, nil)
+	extractedDesc = prometheus.NewDesc(metricsNamespace+"_queries_extracted_total",
+		"Queries handed from the input to the processor.", nil, nil)
+	processedDesc = prometheus.NewDesc(metricsNamespace+"_queries_processed_total",
+		"Queries picked up by the processor.", nil, nil)
+	emittedDesc = prometheus.NewDesc(metricsNamespace+"_queries_emitted_total",
+		"Queries handed from the processor to the output.", nil, nil)
+	writtenDesc = prometheus.NewDesc(metricsNamespace+"_queries_written_total",
+		"Queries the output wrote successfully.", nil, nil)
+	skippedDesc = prometheus.NewDesc(metricsNamespace+"_queries_skipped_total",
+		"Records or queries dropped by the pipeline, by reason.", []string{"reason"}, nil)
+	parseErrorsDesc = prometheus.NewDesc(metricsNamespace+"_parse_errors_total",
+		"Input records that failed to parse, by error kind.", []string{"kind"}, nil)
+	processingErrorsDesc = prometheus.NewDesc(metricsNamespace+"_processing_errors_total",
+		"Errors reported while processing queries.", nil, nil)
+	dedupEntriesDesc = prometheus.NewDesc(metricsNamespace+"_dedup_index_entries",
+		"Unique query hashes held by the dedup index.", nil, nil)
+	cacheHitsDesc = prometheus.NewDesc(metricsNamespace+"_cache_hits_total",
+		"Processor normalization cache hits.", []string{"cache"}, nil)
+	cacheMissesDesc = prometheus.NewDesc(metricsNamespace+"_cache_misses_total",
+		"Processor normalization cache misses.", []string{"cache"}, nil)
+	cacheEntriesDesc = prometheus.NewDesc(metricsNamespace+"_cache_entries",
+		"Processor normalization cache entries.", []string{"cache"}, nil)
+)
+
+// collectorMetrics exposes the pipeline counters to Prometheus. Values are
+// read from the summary and the processor at scrape time so the hot path
+// doesn't pay for metrics.
+type collectorMetrics struct {
+	summary *ExtractionSummary
+	proc    *Processor
+	written atomic.Uint64
+}
+
+func newCollectorMetrics(summary *ExtractionSummary, proc *Processor) *collectorMetrics {
+	return &collectorMetrics{
+		summary: summary,
+		proc:    proc,
+	}
+}
+
+func (m *collectorMetrics) Describe(ch chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(m, ch)
+}
+
+func (m *collectorMetrics) Collect(ch chan<- prometheus.Metric) {
+	snap := m.summary.Snapshot()
+	counter := func(desc *prometheus.Desc, v uint64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(v), labels...)
+	}
+	gauge := func(desc *prometheus.Desc, v float64, labels ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, labels...)
+	}
+
+	counter(recordsReadDesc, snap.RecordsRead)
+	counter(extractedDesc, snap.Extracted)
+	counter(processedDesc, uint64(m.proc.progress.Load()))
+	counter(emittedDesc, snap.Emitted)
+	counter(writtenDesc, m.written.Load())
+	for reason, n := range snap.Skipped {
+		counter(skippedDesc, n, reason)
+	}
+	for kind, n := range snap.ParseErrors {
+		counter(parseErrorsDesc, n, kind)
+	}
+	counter(processingErrorsDesc, m.proc.errors.Load())
+
+	if m.proc.dedupIndex != nil {
+		gauge(dedupEntriesDesc, float64(m.proc.dedupIndex.Len()))
+	}
+
+	caches := []struct {
+		name  string
+		stats func() (uint64, uint64, int)
+	}{
+		{"raw_queries", m.proc.rawQueriesCache.Stats},
+		{"raw_queries_hash", m.proc.rawQueriesHashCache.Stats},
+		{"fingerprints", m.proc.fingerprintsCache.Stats},
+		{"fingerprints_hash", m.proc.fingerprintsHashCache.Stats},
+	}
+	for _, c := range caches {
+		hits, misses, entries := c.stats()
+		counter(cacheHitsDesc, hits, c.name)
+		counter(cacheMissesDesc, misses, c.name)
+		gauge(cacheEntriesDesc, float64(entries), c.name)
+	}
+}
+
+// Serve starts an HTTP server exposing /metrics on addr until ctx is done
+func (m *collectorMetrics) Serve(ctx context.Context, addr string) error {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		m,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %s: %w", addr, err)
+	}
+	server := &http.Server{
+		Handler:     mux,
+		ReadTimeout: 10 * time.Second,
+	}
+
+	go server.Serve(listener)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Printf("Serving metrics on http://%s/metrics\n", listener.Addr())
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	comQuery = 0x03
+
+	// capability flags of the handshake response
+	clientMySQL           = 1 << 0
+	clientProtocol41      = 1 << 9
+	clientQueryAttributes = 1 << 27
+
+	// handshakeResponseSeq is the sequence id of the client's handshake
+	// response, commands always start at 0
+	handshakeResponseSeq = 1
+)
+
+var errShortQueryAttributes = errors.New("query attributes truncated")
+
+// clientCapabilities is what the client of a connection negotiated
+type clientCapabilities struct {
+	flags uint32
+	// mariaDB is set when the client cleared CLIENT_MYSQL, MariaDB then
+	// reuses the reserved bytes for its extended capabilities and the other
+	// flags don't mean the same
+	mariaDB bool
+}
+
+// queryAttributes reports whether COM_QUERY payloads carry query attributes,
+// a MySQL 8.0.23+ extension MariaDB doesn't have
+func (c clientCapabilities) queryAttributes() bool {
+	return !c.mariaDB && c.flags&clientQueryAttributes != 0
+}
+
+// parseHandshakeResponse reads the capabilities of a HandshakeResponse41
+// packet, header included
+func parseHandshakeResponse(packet []byte) (clientCapabilities, bool) {
+	// header, flags, max packet size, charset and 23 reserved bytes
+	if len(packet) < 4+4+4+1+23 || packet[3] != handshakeResponseSeq {
+		return clientCapabilities{}, false
+	}
+	if int(packet[0])|int(packet[1])<<8|int(packet[2])<<16 != len(packet)-4 {
+		return clientCapabilities{}, false
+	}
+	flags := binary.LittleEndian.Uint32(packet[4:8])
+	if flags&clientProtocol41 == 0 {
+		return clientCapabilities{}, false
+	}
+	return clientCapabilities{flags: flags, mariaDB: flags&clientMySQL == 0}, true
+}
+
+// looksLikeQueryAttributes guesses whether a COM_QUERY body of a connection
+// whose handshake wasn't captured starts with query attributes. The
+// parameter count is followed by a parameter set count of 1, and a query
+// text never starts with a control character followed by 0x01.
+func looksLikeQueryAttributes(body []byte) bool {
+	return len(body) >= 2 && body[0] < 0x20 && body[1] == 0x01
+}
+
+// comQueryText returns the query text of a COM_QUERY body, the payload after
+// the command byte, skipping the query attributes when present
+func comQueryText(body []byte, queryAttributes bool) ([]byte, error) {
+	if !queryAttributes {
+		return body, nil
+	}
+	paramCount, n := readLenEncInt(body)
+	if n == 0 {
+		return nil, errShortQueryAttributes
+	}
+	body = body[n:]
+	// parameter set count, always 1
+	if _, n = readLenEncInt(body); n == 0 {
+		return nil, errShortQueryAttributes
+	}
+	body = body[n:]
+	if paramCount == 0 {
+		return body, nil
+	}
+	if paramCount > uint64(len(body)) {
+		return nil, errShortQueryAttributes
+	}
+
+	nullBitmapLen := int((paramCount + 7) / 8)
+	if len(body) < nullBitmapLen+1 {
+		return nil, errShortQueryAttributes
+	}
+	nullBitmap := body[:nullBitmapLen]
+	newParamsBound := body[nullBitmapLen] == 1
+	body = body[nullBitmapLen+1:]
+	if !newParamsBound {
+		// the types are only left out when rebinding a prepared statement
+		return nil, errShortQueryAttributes
+	}
+
+	types := make([]byte, paramCount)
+	for i := range types {
+		if len(body) < 2 {
+			return nil, errShortQueryAttributes
+		}
+		types[i] = body[0]
+		body = body[2:]
+		// attribute name
+		nameLen, n := readLenEncInt(body)
+		if n == 0 || uint64(len(body)-n) < nameLen {
+			return nil, errShortQueryAttributes
+		}
+		body = body[n+int(nameLen):]
+	}
+	for i, t := range types {
+		if nullBitmap[i/8]&(1<<(i%8)) != 0 {
+			continue
+		}
+		n := binaryValueLen(t, body)
+		if n < 0 || n > len(body) {
+			return nil, errShortQueryAttributes
+		}
+		body = body[n:]
+	}
+	return body, nil
+}
+
+// binaryValueLen is the length of a binary protocol value of the given type
+// at the start of b, -1 when it can't be told
+func binaryValueLen(fieldType byte, b []byte) int {
+	switch fieldType {
+	case 0x06: // NULL
+		return 0
+	case 0x01: // TINY
+		return 1
+	case 0x02, 0x0d: // SHORT, YEAR
+		return 2
+	case 0x03, 0x09, 0x04: // LONG, INT24, FLOAT
+		return 4
+	case 0x08, 0x05: // LONGLONG, DOUBLE
+		return 8
+	case 0x07, 0x0a, 0x0b, 0x0c: // TIMESTAMP, DATE, TIME, DATETIME
+		if len(b) == 0 {
+			return -1
+		}
+		return 1 + int(b[0])
+	default: // strings, blobs, decimals and json
+		l, n := readLenEncInt(b)
+		if n == 0 || uint64(len(b)-n) < l {
+			return -1
+		}
+		return n + int(l)
+	}
+}
+
+// readLenEncInt reads a length-encoded integer, n is 0 when b is too short
+func readLenEncInt(b []byte) (v uint64, n int) {
+	if len(b) == 0 {
+		return 0, 0
+	}
+	switch b[0] {
+	case 0xfc:
+		if len(b) < 3 {
+			return 0, 0
+		}
+		return uint64(binary.LittleEndian.Uint16(b[1:])), 3
+	case 0xfd:
+		if len(b) < 4 {
+			return 0, 0
+		}
+		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, 4
+	case 0xfe:
+		if len(b) < 9 {
+			return 0, 0
+		}
+		return binary.LittleEndian.Uint64(b[1:]), 9
+	case 0xfb, 0xff:
+		return 0, 0
+	default:
+		return uint64(b[0]), 1
+	}
+}
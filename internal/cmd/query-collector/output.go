@@ -5,6 +5,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sync/atomic"
 
 	"mysql-load-test/pkg/query"
 
@@ -28,12 +29,29 @@ type OutputCommonConfig struct {
 }
 
 type OutputCommon struct {
-	cfg OutputCommonConfig
+	cfg    OutputCommonConfig
+	errors *ErrorPolicy
+	// written counts the queries the output wrote, nil without metrics
+	written *atomic.Uint64
 }
 
-func NewOutputCommon(cfg OutputCommonConfig) *OutputCommon {
+func NewOutputCommon(cfg OutputCommonConfig, errors *ErrorPolicy) *OutputCommon {
 	return &OutputCommon{
-		cfg: cfg,
+		cfg:    cfg,
+		errors: errors,
+	}
+}
+
+// SetWrittenCounter counts the queries the output wrote successfully into
+// written
+func (o *OutputCommon) SetWrittenCounter(written *atomic.Uint64) {
+	o.written = written
+}
+
+// countWritten is called by the outputs once n queries were written
+func (o *OutputCommon) countWritten(n int) {
+	if o.written != nil {
+		o.written.Add(uint64(n))
 	}
 }
 
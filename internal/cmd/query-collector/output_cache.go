@@ -3,12 +3,10 @@ package main
 import (
 	"bufio"
 	"context"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"mysql-load-test/pkg/query"
 	"os"
-	"sync"
 )
 
 type OutputCacheConfig struct {
@@ -18,10 +16,11 @@ type OutputCacheConfig struct {
 }
 
 type OutputCache struct {
-	cfg        OutputCacheConfig
-	closers    []io.Closer
-	writer     *bufio.Writer
-	bufferPool *sync.Pool
+	cfg         OutputCacheConfig
+	common      *OutputCommon
+	closers     []io.Closer
+	writer      *bufio.Writer
+	cacheWriter *query.CacheWriter
 }
 
 func NewCacheOutput(cfg OutputCacheConfig, common *OutputCommon) (*OutputCache, error) {
@@ -34,17 +33,18 @@ func NewCacheOutput(cfg OutputCacheConfig, common *OutputCommon) (*OutputCache,
 	closers := []io.Closer{file}
 
 	bufioWriter := bufio.NewWriterSize(writer, 1024*1024)
+	cacheWriter, err := query.NewCacheWriter(bufioWriter)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
 
 	return &OutputCache{
-		cfg:     cfg,
-		writer:  bufioWriter,
-		closers: closers,
-		bufferPool: &sync.Pool{
-			New: func() interface{} {
-				b := make([]byte, 32)
-				return &b
-			},
-		},
+		cfg:         cfg,
+		common:      common,
+		writer:      bufioWriter,
+		cacheWriter: cacheWriter,
+		closers:     closers,
 	}, nil
 }
 
@@ -79,20 +79,10 @@ func (o *OutputCache) StartOutput(ctx context.Context, inQueryChan <-chan *query
 			continue
 		}
 
-		totalSize := 32
-
-		bufPtr := o.bufferPool.Get().(*[]byte)
-		buf := *bufPtr
-		defer o.bufferPool.Put(bufPtr)
-
-		binary.LittleEndian.PutUint64(buf[0:8], q.Hash)
-		binary.LittleEndian.PutUint64(buf[8:16], q.FingerprintHash)
-		binary.LittleEndian.PutUint64(buf[16:24], q.Offset)
-		binary.LittleEndian.PutUint64(buf[24:32], q.Length)
-
-		if _, err := o.writer.Write(buf[:totalSize]); err != nil {
+		if err := o.cacheWriter.Write(q); err != nil {
 			return fmt.Errorf("error writing query data: %w", err)
 		}
+		o.common.countWritten(1)
 	}
 
 	return nil
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -25,21 +26,31 @@ type OutputDBConfig struct {
 	DBName    string `json:"name"`
 	Truncate  bool   `json:"truncate"`
 	BatchSize int    `json:"batch_size"`
+
+	// Source identifies the input in the batch ledger, Resume skips the
+	// queries of batches a previous run over the same source committed
+	Source string `json:"source"`
+	Resume bool   `json:"resume"`
 }
 
 type OutputDB struct {
 	cfg             OutputDBConfig
+	common          *OutputCommon
 	db              *DB
 	insertedQueries atomic.Uint64
 	insertLats      chan time.Duration
 	pool            pond.Pool
+
+	// failure is the error the error policy stopped the output with
+	failMu  sync.Mutex
+	failure error
 }
 
 type DB struct {
 	*sqlx.DB
 }
 
-func NewDBOutput(cfg OutputDBConfig) (*OutputDB, error) {
+func NewDBOutput(cfg OutputDBConfig, common *OutputCommon) (*OutputDB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true",
 		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DBName)
 
@@ -56,6 +67,7 @@ func NewDBOutput(cfg OutputDBConfig) (*OutputDB, error) {
 
 	return &OutputDB{
 		cfg:             cfg,
+		common:          common,
 		db:              _db,
 		insertedQueries: atomic.Uint64{},
 		insertLats:      make(chan time.Duration, 100),
@@ -83,6 +95,14 @@ func (o *OutputDB) truncateTables(ctx context.Context) error {
 		return fmt.Errorf("failed to truncate QueryFingerprint table: %w", err)
 	}
 
+	if _, err := tx.ExecContext(ctx, "TRUNCATE TABLE QueryHourlyCount"); err != nil {
+		return fmt.Errorf("failed to truncate QueryHourlyCount table: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "TRUNCATE TABLE CollectorBatch"); err != nil {
+		return fmt.Errorf("failed to truncate CollectorBatch table: %w", err)
+	}
+
 	if _, err := tx.ExecContext(ctx, "SET FOREIGN_KEY_CHECKS = 1"); err != nil {
 		return fmt.Errorf("failed to enable foreign key checks: %w", err)
 	}
@@ -90,6 +110,14 @@ func (o *OutputDB) truncateTables(ctx context.Context) error {
 	return tx.Commit()
 }
 
+// fingerprintText is NULL when the query has no fingerprint
+func fingerprintText(q *query.Query) any {
+	if len(q.Fingerprint) == 0 {
+		return nil
+	}
+	return string(q.Fingerprint)
+}
+
 func (o *OutputDB) insertBatch(ctx context.Context, batch []*query.Query) (int, error) {
 	if len(batch) == 0 {
 		return 0, nil
@@ -102,20 +130,38 @@ func (o *OutputDB) insertBatch(ctx context.Context, batch []*query.Query) (int,
 	defer tx.Rollback()
 
 	fingerprintValues := make([]string, 0, len(batch))
-	fingerprintArgs := make([]interface{}, 0, len(batch)*2)
-	seenFingerprints := make(map[uint64]bool)
+	fingerprintArgs := make([]interface{}, 0, len(batch)*6)
+	// seenFingerprints holds the position of the arguments of each
+	// fingerprint, the stats of the queries of the batch are merged into
+	// stats
+	seenFingerprints := make(map[uint64]int)
+	stats := make(map[int]*query.FingerprintStats)
 
 	for _, q := range batch {
-		if !seenFingerprints[q.FingerprintHash] {
-			seenFingerprints[q.FingerprintHash] = true
-			fingerprintValues = append(fingerprintValues, "(?)")
-			fingerprintArgs = append(fingerprintArgs, q.FingerprintHash)
+		pos, seen := seenFingerprints[q.FingerprintHash]
+		if !seen {
+			pos = len(fingerprintArgs)
+			seenFingerprints[q.FingerprintHash] = pos
+			fingerprintValues = append(fingerprintValues, "(?, ?, ?, ?, ?, ?)")
+			fingerprintArgs = append(fingerprintArgs, q.FingerprintHash, fingerprintText(q), nil, nil, nil, nil)
+		}
+		if q.Stats != nil {
+			if stats[pos] == nil {
+				stats[pos] = &query.FingerprintStats{}
+			}
+			stats[pos].Merge(*q.Stats)
 		}
 	}
+	for pos, s := range stats {
+		fingerprintArgs[pos+2] = s.AvgExecTime.Microseconds()
+		fingerprintArgs[pos+3] = s.Count
+		fingerprintArgs[pos+4] = s.AvgRowsExamined
+		fingerprintArgs[pos+5] = s.AvgRowsSent
+	}
 
 	if len(fingerprintValues) > 0 {
 		fingerprintSQL := fmt.Sprintf(`
-				INSERT IGNORE INTO QueryFingerprint (Hash)
+				INSERT IGNORE INTO QueryFingerprint (Hash, Fingerprint, AVGExecutionTime, TimesCalled, AVGRowsScanned, AVGRowsReturned)
 				VALUES %s
 			`, strings.Join(fingerprintValues, ", "))
 		if _, err := tx.ExecContext(ctx, fingerprintSQL, fingerprintArgs...); err != nil {
@@ -123,18 +169,27 @@ func (o *OutputDB) insertBatch(ctx context.Context, batch []*query.Query) (int,
 		}
 	}
 
+	if err := o.insertHourlyCounts(ctx, tx, batch); err != nil {
+		return 0, err
+	}
+
+	if err := o.insertLedger(ctx, tx, batch); err != nil {
+		return 0, err
+	}
+
 	queryValues := make([]string, 0, len(batch))
-	queryArgs := make([]interface{}, 0, len(batch)*4)
-	seenQueries := make(map[uint64]bool)
+	queryArgs := make([]interface{}, 0, len(batch)*7)
+	seenQueries := make(map[queryKey]bool)
 
 	for _, q := range batch {
-		if !isValidQuery(q.Raw) {
+		if !isValidSessionQuery(q) {
 			continue
 		}
-		if !seenQueries[q.Hash] {
-			seenQueries[q.Hash] = true
-			queryValues = append(queryValues, "(?, ?, ?, ?)")
-			queryArgs = append(queryArgs, q.Hash, q.Offset, q.Length, q.FingerprintHash)
+		key := queryKey{q.Hash, q.Session, q.SessionSeq}
+		if !seenQueries[key] {
+			seenQueries[key] = true
+			queryValues = append(queryValues, "(?, ?, ?, ?, ?, ?, ?)")
+			queryArgs = append(queryArgs, q.Hash, q.Offset, q.Length, q.FingerprintHash, q.Session, q.SessionSeq, q.Timestamp)
 		}
 	}
 
@@ -143,7 +198,7 @@ func (o *OutputDB) insertBatch(ctx context.Context, batch []*query.Query) (int,
 	}
 
 	querySQL := fmt.Sprintf(`
-    INSERT INTO Query (Hash, Offset, Length, FingerprintHash)
+    INSERT INTO Query (Hash, Offset, Length, FingerprintHash, Session, SessionSeq, Timestamp)
     VALUES %s
     `, strings.Join(queryValues, ", "))
 
@@ -155,6 +210,51 @@ func (o *OutputDB) insertBatch(ctx context.Context, batch []*query.Query) (int,
 
 }
 
+// queryKey is the unique key of the Query table, the queries of a session
+// are kept once per execution
+type queryKey struct {
+	hash, session, seq uint64
+}
+
+type hourlyCountKey struct {
+	fingerprintHash uint64
+	hour            int
+}
+
+// insertHourlyCounts adds the executions of the batch to the per hour of day
+// counts, queries without a capture timestamp are skipped
+func (o *OutputDB) insertHourlyCounts(ctx context.Context, tx *sqlx.Tx, batch []*query.Query) error {
+	counts := make(map[hourlyCountKey]int)
+	for _, q := range batch {
+		if q.Timestamp == 0 {
+			continue
+		}
+		hour := time.Unix(int64(q.Timestamp), 0).UTC().Hour()
+		counts[hourlyCountKey{q.FingerprintHash, hour}]++
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(counts))
+	args := make([]interface{}, 0, len(counts)*3)
+	for key, count := range counts {
+		values = append(values, "(?, ?, ?)")
+		args = append(args, key.fingerprintHash, key.hour, count)
+	}
+
+	hourlySQL := fmt.Sprintf(`
+    INSERT INTO QueryHourlyCount (FingerprintHash, Hour, Count)
+    VALUES %s
+    ON DUPLICATE KEY UPDATE Count = Count + VALUES(Count)
+    `, strings.Join(values, ", "))
+
+	if _, err := o.execContext(ctx, tx, hourlySQL, args...); err != nil {
+		return fmt.Errorf("failed to batch insert hourly counts: %w", err)
+	}
+	return nil
+}
+
 func (o *OutputDB) execContext(ctx context.Context, tx *sqlx.Tx, query string, args ...interface{}) (sql.Result, error) {
 	start := time.Now()
 	res, err := tx.ExecContext(ctx, query, args...)
@@ -170,6 +270,15 @@ func (o *OutputDB) StartOutput(ctx context.Context, inQueryChan <-chan *query.Qu
 		}
 	}
 
+	var committed map[uint64]struct{}
+	if o.cfg.Resume {
+		var err error
+		if committed, err = o.loadCommittedOffsets(ctx); err != nil {
+			return err
+		}
+		fmt.Printf("Resuming, skipping %d committed queries\n", len(committed))
+	}
+
 	reporterCtx, reporterStop := context.WithCancel(ctx)
 	defer reporterStop()
 
@@ -204,34 +313,56 @@ func (o *OutputDB) StartOutput(ctx context.Context, inQueryChan <-chan *query.Qu
 
 	batch := make([]*query.Query, 0, o.cfg.BatchSize)
 	for q := range inQueryChan {
+		if _, ok := committed[q.Offset]; ok {
+			continue
+		}
 		batch = append(batch, q)
 		if len(batch) >= o.cfg.BatchSize {
+			if o.failed() != nil {
+				break
+			}
 			currentBatch := batch
 			o.pool.Submit(func() {
-				if n, err := o.insertBatch(ctx, currentBatch); err != nil {
-					fmt.Fprintf(os.Stderr, "error inserting batch: %v\n", err)
-				} else {
-					o.insertedQueries.Add(uint64(n))
-				}
+				o.submitBatch(ctx, currentBatch, "error inserting batch")
 			})
 			batch = make([]*query.Query, 0, o.cfg.BatchSize)
 		}
 	}
 
 	// CRITICAL FIX: Process the final partial batch
-	if len(batch) > 0 {
+	if len(batch) > 0 && o.failed() == nil {
 		currentBatch := batch
 		o.pool.Submit(func() {
-			if n, err := o.insertBatch(ctx, currentBatch); err != nil {
-				fmt.Fprintf(os.Stderr, "error inserting final batch: %v\n", err)
-			} else {
-				o.insertedQueries.Add(uint64(n))
-			}
+			o.submitBatch(ctx, currentBatch, "error inserting final batch")
 		})
 	}
 
 	o.pool.StopAndWait()
-	return nil
+	return o.failed()
+}
+
+// submitBatch inserts batch and applies the error policy when it fails
+func (o *OutputDB) submitBatch(ctx context.Context, batch []*query.Query, msg string) {
+	n, err := o.insertBatch(ctx, batch)
+	if err == nil {
+		o.insertedQueries.Add(uint64(n))
+		o.common.countWritten(n)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%s: %v\n", msg, err)
+	if err := o.common.errors.Handle(StageOutput, withQueries(err, batch)); err != nil {
+		o.failMu.Lock()
+		defer o.failMu.Unlock()
+		if o.failure == nil {
+			o.failure = err
+		}
+	}
+}
+
+func (o *OutputDB) failed() error {
+	o.failMu.Lock()
+	defer o.failMu.Unlock()
+	return o.failure
 }
 
 func (o *OutputDB) Concurrency() OutputConcurrencyInfo {
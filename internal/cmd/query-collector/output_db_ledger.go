@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"mysql-load-test/pkg/query"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const batchStatusCommitted = "committed"
+
+// encodeOffsets sorts the offsets and encodes them as uvarint deltas
+func encodeOffsets(offsets []uint64) []byte {
+	slices.Sort(offsets)
+	buf := make([]byte, 0, len(offsets)*3)
+	var prev uint64
+	for _, o := range offsets {
+		buf = binary.AppendUvarint(buf, o-prev)
+		prev = o
+	}
+	return buf
+}
+
+func decodeOffsets(buf []byte, fn func(offset uint64)) error {
+	var offset uint64
+	for len(buf) > 0 {
+		delta, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return fmt.Errorf("invalid batch offsets")
+		}
+		offset += delta
+		fn(offset)
+		buf = buf[n:]
+	}
+	return nil
+}
+
+// insertLedger records the batch as committed, it must run in the
+// transaction of the batch so the ledger never disagrees with the data
+func (o *OutputDB) insertLedger(ctx context.Context, tx *sqlx.Tx, batch []*query.Query) error {
+	offsets := make([]uint64, len(batch))
+	start, end := batch[0].Offset, batch[0].Offset+batch[0].Length
+	for i, q := range batch {
+		offsets[i] = q.Offset
+		start = min(start, q.Offset)
+		end = max(end, q.Offset+q.Length)
+	}
+	_, err := o.execContext(ctx, tx, `
+    INSERT INTO CollectorBatch (Source, StartOffset, EndOffset, Queries, Offsets, Status)
+    VALUES (?, ?, ?, ?, ?, ?)
+    `, o.cfg.Source, start, end, len(batch), encodeOffsets(offsets), batchStatusCommitted)
+	if err != nil {
+		return fmt.Errorf("failed to insert batch ledger: %w", err)
+	}
+	return nil
+}
+
+// loadCommittedOffsets returns the offsets of the queries committed by
+// previous runs over the same source
+func (o *OutputDB) loadCommittedOffsets(ctx context.Context) (map[uint64]struct{}, error) {
+	rows, err := o.db.QueryContext(ctx, "SELECT Offsets FROM CollectorBatch WHERE Source = ? AND Status = ?", o.cfg.Source, batchStatusCommitted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load batch ledger: %w", err)
+	}
+	defer rows.Close()
+
+	committed := make(map[uint64]struct{})
+	for rows.Next() {
+		var buf []byte
+		if err := rows.Scan(&buf); err != nil {
+			return nil, fmt.Errorf("failed to scan batch ledger: %w", err)
+		}
+		if err := decodeOffsets(buf, func(offset uint64) { committed[offset] = struct{}{} }); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to load batch ledger: %w", err)
+	}
+	return committed, nil
+}
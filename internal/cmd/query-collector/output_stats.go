@@ -9,12 +9,14 @@ import (
 )
 
 type OutputStats struct {
+	common            *OutputCommon
 	queryCounts       map[string]int
 	fingerprintCounts map[string]int
 }
 
-func NewOutputStats() *OutputStats {
+func NewOutputStats(common *OutputCommon) *OutputStats {
 	return &OutputStats{
+		common:            common,
 		queryCounts:       make(map[string]int),
 		fingerprintCounts: make(map[string]int),
 	}
@@ -24,6 +26,7 @@ func (o *OutputStats) StartOutput(ctx context.Context, inQueryChan <-chan *query
 	for q := range inQueryChan {
 		o.queryCounts[string(q.Raw)]++
 		o.fingerprintCounts[string(q.Fingerprint)]++
+		o.common.countWritten(1)
 	}
 	o.printStats()
 	return nil
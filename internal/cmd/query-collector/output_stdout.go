@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"mysql-load-test/pkg/query"
+)
+
+// OutputStdoutConfig prints the processed queries as they come, to look at
+// what the processor makes of an input before a long load into the
+// database
+type OutputStdoutConfig struct {
+	// Rate caps the printed queries per second, the others are counted and
+	// skipped so a live capture isn't held back. 0 prints them all.
+	Rate float64 `json:"rate"`
+	// Format is pretty, a block per query, or json, an object per line
+	Format string `json:"format"`
+}
+
+type OutputStdout struct {
+	cfg    OutputStdoutConfig
+	common *OutputCommon
+	w      *bufio.Writer
+
+	printed, skipped int
+}
+
+func NewOutputStdout(cfg OutputStdoutConfig, common *OutputCommon) (*OutputStdout, error) {
+	switch cfg.Format {
+	case "":
+		cfg.Format = "pretty"
+	case "pretty", "json":
+	default:
+		return nil, fmt.Errorf("unsupported stdout format: %s", cfg.Format)
+	}
+	if cfg.Rate < 0 {
+		return nil, fmt.Errorf("invalid stdout rate %g", cfg.Rate)
+	}
+	return &OutputStdout{cfg: cfg, common: common, w: bufio.NewWriter(os.Stdout)}, nil
+}
+
+// stdoutQuery is the json format of a query, with the texts as strings
+type stdoutQuery struct {
+	Timestamp       uint64                  `json:"timestamp,omitempty"`
+	Hash            string                  `json:"hash"`
+	FingerprintHash string                  `json:"fingerprint_hash"`
+	Session         uint64                  `json:"session,omitempty"`
+	SessionSeq      uint64                  `json:"session_seq,omitempty"`
+	Raw             string                  `json:"raw"`
+	Fingerprint     string                  `json:"fingerprint"`
+	Stats           *query.FingerprintStats `json:"stats,omitempty"`
+}
+
+func (o *OutputStdout) StartOutput(ctx context.Context, inQueryChan <-chan *query.Query) error {
+	var interval time.Duration
+	if o.cfg.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / o.cfg.Rate)
+	}
+	var next time.Time
+	enc := json.NewEncoder(o.w)
+	enc.SetEscapeHTML(false)
+
+	for q := range inQueryChan {
+		if interval > 0 {
+			now := time.Now()
+			if now.Before(next) {
+				o.skipped++
+				continue
+			}
+			next = now.Add(interval)
+		}
+
+		var err error
+		if o.cfg.Format == "json" {
+			err = enc.Encode(stdoutQuery{
+				Timestamp:       q.Timestamp,
+				Hash:            strconv.FormatUint(q.Hash, 10),
+				FingerprintHash: strconv.FormatUint(q.FingerprintHash, 10),
+				Session:         q.Session,
+				SessionSeq:      q.SessionSeq,
+				Raw:             string(q.Raw),
+				Fingerprint:     string(q.Fingerprint),
+				Stats:           q.Stats,
+			})
+		} else {
+			err = writePrettyQuery(o.w, q)
+		}
+		if err == nil {
+			// every query shows up as it comes
+			err = o.w.Flush()
+		}
+		if err != nil {
+			return fmt.Errorf("error writing to stdout: %w", err)
+		}
+		o.printed++
+		o.common.countWritten(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Printed %d queries, skipped %d over the rate\n", o.printed, o.skipped)
+	return nil
+}
+
+// writePrettyQuery writes a header line with the capture time and hashes,
+// then the query and its fingerprint
+func writePrettyQuery(w io.Writer, q *query.Query) error {
+	header := "-"
+	if q.Timestamp != 0 {
+		header = time.Unix(int64(q.Timestamp), 0).UTC().Format(time.RFC3339)
+	}
+	header += fmt.Sprintf("  fingerprint %d", q.FingerprintHash)
+	if q.Session != 0 {
+		header += fmt.Sprintf("  session %d #%d", q.Session, q.SessionSeq)
+	}
+	if q.Stats != nil {
+		header += fmt.Sprintf("  count %d avg %s", q.Stats.Count, q.Stats.AvgExecTime)
+	}
+	_, err := fmt.Fprintf(w, "%s\n  %s\n  => %s\n\n", header, q.Raw, q.Fingerprint)
+	return err
+}
+
+func (o *OutputStdout) Concurrency() OutputConcurrencyInfo {
+	return OutputConcurrencyInfo{
+		MaxConcurrency:     0,
+		CurrentConcurrency: 0,
+	}
+}
+
+func (o *OutputStdout) Destroy() error {
+	return o.w.Flush()
+}
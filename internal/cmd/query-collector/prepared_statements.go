@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	comStmtPrepare  = 0x16
+	comStmtExecute  = 0x17
+	comStmtLongData = 0x18
+	comStmtClose    = 0x19
+
+	// stmtPrepareOK is the status of the COM_STMT_PREPARE response, followed
+	// by the statement id, column count and parameter count
+	stmtPrepareOK    = 0x00
+	stmtPrepareOKLen = 12
+
+	// parameterCountAvailable is the COM_STMT_EXECUTE flag telling a
+	// parameter count is sent even for statements without parameters
+	parameterCountAvailable = 0x08
+
+	// maxConnStatements bounds the statements kept per connection, clients
+	// leaking statements would otherwise grow it forever
+	maxConnStatements = 4096
+)
+
+var (
+	errShortExecute = errors.New("statement execution truncated")
+	errLongData     = errors.New("parameter sent as long data")
+)
+
+// preparedStatement is a statement prepared on a connection
+type preparedStatement struct {
+	text   []byte
+	params int
+	// types are the parameter types of the last execution binding them, the
+	// next executions may leave them out. The high byte is the unsigned
+	// flag.
+	types []uint16
+	// longData is set when parameters were sent with COM_STMT_SEND_LONG_DATA
+	// since the last execution, their values are not in the execution
+	longData bool
+}
+
+// connStatements follows the prepared statements of a connection
+type connStatements struct {
+	stmts map[uint32]*preparedStatement
+}
+
+func newConnStatements() *connStatements {
+	return &connStatements{stmts: make(map[uint32]*preparedStatement)}
+}
+
+// prepared records the statement text was prepared as, packet is the
+// server's response, header included. An error response prepared nothing.
+func (c *connStatements) prepared(text, packet []byte) {
+	if len(packet) < 4+stmtPrepareOKLen || packet[3] != 1 || packet[4] != stmtPrepareOK {
+		return
+	}
+	id := binary.LittleEndian.Uint32(packet[5:9])
+	params := int(binary.LittleEndian.Uint16(packet[11:13]))
+	if len(c.stmts) >= maxConnStatements {
+		clear(c.stmts)
+	}
+	c.stmts[id] = &preparedStatement{text: text, params: params}
+}
+
+// execute returns the statement text of a COM_STMT_EXECUTE body with its
+// parameter values bound. ok is false for statements prepared before the
+// capture started.
+func (c *connStatements) execute(body []byte, queryAttributes, attributesKnown bool) ([]byte, bool, error) {
+	if len(body) < 9 {
+		return nil, true, errShortExecute
+	}
+	stmt, ok := c.stmts[binary.LittleEndian.Uint32(body)]
+	if !ok {
+		return nil, false, nil
+	}
+	longData := stmt.longData
+	stmt.longData = false
+	if longData {
+		return nil, true, errLongData
+	}
+
+	var values []string
+	var err error
+	if attributesKnown {
+		values, err = stmt.values(body, queryAttributes)
+	} else if values, err = stmt.values(body, false); err != nil {
+		// the handshake wasn't captured, the client may send attributes
+		values, err = stmt.values(body, true)
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	text, err := bindParams(stmt.text, values)
+	return text, true, err
+}
+
+// longData marks the statement of a COM_STMT_SEND_LONG_DATA body
+func (c *connStatements) longData(body []byte) {
+	if len(body) < 4 {
+		return
+	}
+	if stmt, ok := c.stmts[binary.LittleEndian.Uint32(body)]; ok {
+		stmt.longData = true
+	}
+}
+
+// close forgets the statement of a COM_STMT_CLOSE body
+func (c *connStatements) close(body []byte) {
+	if len(body) >= 4 {
+		delete(c.stmts, binary.LittleEndian.Uint32(body))
+	}
+}
+
+// values decodes the parameter values of an execution as SQL literals. The
+// body has to be consumed exactly, so a wrong guess of the query attributes
+// is told apart.
+func (s *preparedStatement) values(body []byte, queryAttributes bool) ([]string, error) {
+	flags := body[4]
+	// statement id, flags and iteration count
+	body = body[9:]
+
+	count := uint64(s.params)
+	if queryAttributes && (s.params > 0 || flags&parameterCountAvailable != 0) {
+		var n int
+		if count, n = readLenEncInt(body); n == 0 || count < uint64(s.params) {
+			return nil, errShortExecute
+		}
+		body = body[n:]
+	}
+	if count == 0 {
+		if len(body) != 0 {
+			return nil, errShortExecute
+		}
+		return nil, nil
+	}
+	if count > uint64(len(body)) {
+		return nil, errShortExecute
+	}
+
+	nullBitmapLen := int((count + 7) / 8)
+	if len(body) < nullBitmapLen+1 {
+		return nil, errShortExecute
+	}
+	nullBitmap := body[:nullBitmapLen]
+	newParamsBound := body[nullBitmapLen] == 1
+	body = body[nullBitmapLen+1:]
+
+	types := s.types
+	if newParamsBound {
+		types = make([]uint16, count)
+		for i := range types {
+			if len(body) < 2 {
+				return nil, errShortExecute
+			}
+			types[i] = binary.LittleEndian.Uint16(body)
+			body = body[2:]
+			if queryAttributes {
+				nameLen, n := readLenEncInt(body)
+				if n == 0 || uint64(len(body)-n) < nameLen {
+					return nil, errShortExecute
+				}
+				body = body[n+int(nameLen):]
+			}
+		}
+	} else if uint64(len(types)) != count {
+		return nil, errShortExecute
+	}
+
+	values := make([]string, count)
+	for i, t := range types {
+		if nullBitmap[i/8]&(1<<(i%8)) != 0 {
+			values[i] = "NULL"
+			continue
+		}
+		n := binaryValueLen(byte(t), body)
+		if n < 0 || n > len(body) {
+			return nil, errShortExecute
+		}
+		values[i] = binaryLiteral(t, body[:n])
+		body = body[n:]
+	}
+	if len(body) != 0 {
+		return nil, errShortExecute
+	}
+	s.types = types
+	// the query attributes follow the parameters
+	return values[:s.params], nil
+}
+
+// binaryLiteral formats a binary protocol value as an SQL literal, the high
+// byte of fieldType is the unsigned flag
+func binaryLiteral(fieldType uint16, b []byte) string {
+	unsigned := fieldType&0x8000 != 0
+	switch byte(fieldType) {
+	case 0x06: // NULL
+		return "NULL"
+	case 0x01: // TINY
+		if unsigned {
+			return strconv.FormatUint(uint64(b[0]), 10)
+		}
+		return strconv.FormatInt(int64(int8(b[0])), 10)
+	case 0x02, 0x0d: // SHORT, YEAR
+		v := binary.LittleEndian.Uint16(b)
+		if unsigned {
+			return strconv.FormatUint(uint64(v), 10)
+		}
+		return strconv.FormatInt(int64(int16(v)), 10)
+	case 0x03, 0x09: // LONG, INT24
+		v := binary.LittleEndian.Uint32(b)
+		if unsigned {
+			return strconv.FormatUint(uint64(v), 10)
+		}
+		return strconv.FormatInt(int64(int32(v)), 10)
+	case 0x08: // LONGLONG
+		v := binary.LittleEndian.Uint64(b)
+		if unsigned {
+			return strconv.FormatUint(v, 10)
+		}
+		return strconv.FormatInt(int64(v), 10)
+	case 0x04: // FLOAT
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'g', -1, 32)
+	case 0x05: // DOUBLE
+		return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'g', -1, 64)
+	case 0x07, 0x0a, 0x0c: // TIMESTAMP, DATE, DATETIME
+		return "'" + binaryDatetime(b[1:]) + "'"
+	case 0x0b: // TIME
+		return "'" + binaryTime(b[1:]) + "'"
+	}
+
+	l, n := readLenEncInt(b)
+	value := b[n : n+int(l)]
+	switch byte(fieldType) {
+	case 0x00, 0xf6: // DECIMAL, NEWDECIMAL
+		return string(value)
+	}
+	return quoteString(value)
+}
+
+// binaryDatetime formats the year, month, day, hour, minute, second and
+// microseconds of a date, the trailing ones may be left out
+func binaryDatetime(b []byte) string {
+	if len(b) < 4 {
+		return "0000-00-00"
+	}
+	s := fmt.Sprintf("%04d-%02d-%02d", binary.LittleEndian.Uint16(b), b[2], b[3])
+	if len(b) >= 7 {
+		s += fmt.Sprintf(" %02d:%02d:%02d", b[4], b[5], b[6])
+	}
+	if len(b) >= 11 {
+		s += fmt.Sprintf(".%06d", binary.LittleEndian.Uint32(b[7:]))
+	}
+	return s
+}
+
+// binaryTime formats the sign, days, hours, minutes, seconds and
+// microseconds of a time
+func binaryTime(b []byte) string {
+	if len(b) < 8 {
+		return "00:00:00"
+	}
+	sign := ""
+	if b[0] == 1 {
+		sign = "-"
+	}
+	hours := binary.LittleEndian.Uint32(b[1:])*24 + uint32(b[5])
+	s := fmt.Sprintf("%s%02d:%02d:%02d", sign, hours, b[6], b[7])
+	if len(b) >= 12 {
+		s += fmt.Sprintf(".%06d", binary.LittleEndian.Uint32(b[8:]))
+	}
+	return s
+}
+
+// quoteString quotes a string value, binary values not valid UTF-8 become
+// hex literals
+func quoteString(b []byte) string {
+	if !utf8.Valid(b) {
+		return "X'" + hex.EncodeToString(b) + "'"
+	}
+	var sb strings.Builder
+	sb.Grow(len(b) + 2)
+	sb.WriteByte('\'')
+	for _, c := range b {
+		switch c {
+		case '\'':
+			sb.WriteString(`\'`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case 0:
+			sb.WriteString(`\0`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case 0x1a:
+			sb.WriteString(`\Z`)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	sb.WriteByte('\'')
+	return sb.String()
+}
+
+// bindParams replaces the ? placeholders of a statement with values,
+// skipping those in quotes and comments
+func bindParams(text []byte, values []string) ([]byte, error) {
+	out := make([]byte, 0, len(text)+16*len(values))
+	next := 0
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := i + 1
+			for end < len(text) && text[end] != c {
+				if text[end] == '\\' && c != '`' {
+					end++
+				}
+				end++
+			}
+			out = append(out, text[i:min(end+1, len(text))]...)
+			i = end
+		case c == '#' || c == '-' && i+2 < len(text) && text[i+1] == '-' && (text[i+2] == ' ' || text[i+2] == '\t'):
+			end := i
+			for end < len(text) && text[end] != '\n' {
+				end++
+			}
+			out = append(out, text[i:end]...)
+			i = end - 1
+		case c == '/' && i+1 < len(text) && text[i+1] == '*':
+			end := strings.Index(string(text[i+2:]), "*/")
+			if end < 0 {
+				end = len(text)
+			} else {
+				end += i + 4
+			}
+			out = append(out, text[i:end]...)
+			i = end - 1
+		case c == '?':
+			if next >= len(values) {
+				return nil, fmt.Errorf("more placeholders than the %d parameters", len(values))
+			}
+			out = append(out, values[next]...)
+			next++
+		default:
+			out = append(out, c)
+		}
+	}
+	if next != len(values) {
+		return nil, fmt.Errorf("%d placeholders for %d parameters", next, len(values))
+	}
+	return out, nil
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// protocolPacket decodes the hex encoded fields of a packet
+func protocolPacket(fields ...string) []byte {
+	b, err := hex.DecodeString(strings.Join(fields, ""))
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// prepareOK is the COM_STMT_PREPARE response of statement 1 with params
+// parameters
+func prepareOK(params int) []byte {
+	return protocolPacket(
+		"0c0000",   // payload length
+		"01",       // sequence id
+		"00",       // status
+		"01000000", // statement id
+		"0000",     // column count
+		hex.EncodeToString([]byte{byte(params), byte(params >> 8)}), // parameter count
+		"00",   // filler
+		"0000", // warning count
+	)
+}
+
+// executeHeader starts the COM_STMT_EXECUTE body of statement 1, the command
+// byte left out
+const executeHeader = "01000000" + // statement id
+	"00" + // flags
+	"01000000" // iteration count
+
+func Test_ConnStatementsExecuteTypes(t *testing.T) {
+	tests := []struct {
+		name  string
+		typ   string
+		value string
+		want  string
+	}{
+		{"tiny", "0100", "ff", "-1"},
+		{"tiny unsigned", "0180", "ff", "255"},
+		{"short", "0200", "0080", "-32768"},
+		{"short unsigned", "0280", "0080", "32768"},
+		{"year", "0d00", "e807", "2024"},
+		{"long", "0300", "2efbffff", "-1234"},
+		{"long unsigned", "0380", "ffffffff", "4294967295"},
+		{"int24", "0900", "feffffff", "-2"},
+		{"longlong", "0800", "0000000000000080", "-9223372036854775808"},
+		{"longlong unsigned", "0880", "ffffffffffffffff", "18446744073709551615"},
+		{"float", "0400", "0000c03f", "1.5"},
+		{"double", "0500", "000000000000f0bf", "-1"},
+		{"null type", "0600", "", "NULL"},
+		{"date", "0a00", "04e8070301", "'2024-03-01'"},
+		{"datetime", "0c00", "07e80703010a141e", "'2024-03-01 10:20:30'"},
+		{"datetime micros", "0c00", "0be80703010a141e40e20100", "'2024-03-01 10:20:30.123456'"},
+		{"timestamp zero", "0700", "00", "'0000-00-00'"},
+		{"time", "0b00", "080101000000020304", "'-26:03:04'"},
+		{"time micros", "0b00", "0c00000000000a141e40e20100", "'10:20:30.123456'"},
+		{"time zero", "0b00", "00", "'00:00:00'"},
+		{"newdecimal", "f600", "0531322e3334", "12.34"},
+		{"decimal", "0000", "022d35", "-5"},
+		{"var string", "fd00", "084f27427269656e0a", `'O\'Brien\n'`},
+		{"string escapes", "fe00", "045c001a0d", `'\\\0\Z\r'`},
+		{"blob binary", "fc00", "02fffe", "X'fffe'"},
+		{"long string", "fe00", "fc2c01" + strings.Repeat("61", 300), "'" + strings.Repeat("a", 300) + "'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConnStatements()
+			c.prepared([]byte("SELECT ?"), prepareOK(1))
+			body := protocolPacket(
+				executeHeader,
+				"00", // null bitmap
+				"01", // new params bound
+				tt.typ,
+				tt.value,
+			)
+			got, known, err := c.execute(body, false, true)
+			if err != nil || !known {
+				t.Fatalf("execute() known = %v, error = %v", known, err)
+			}
+			if want := "SELECT " + tt.want; string(got) != want {
+				t.Errorf("execute() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func Test_ConnStatementsExecute(t *testing.T) {
+	tests := []struct {
+		name            string
+		text            string
+		params          int
+		body            []byte
+		queryAttributes bool
+		attributesKnown bool
+		want            string
+		wantErr         error
+	}{
+		{
+			name:   "null bitmap",
+			text:   "INSERT INTO t VALUES (?, ?)",
+			params: 2,
+			body: protocolPacket(
+				executeHeader,
+				"02",       // null bitmap: second parameter
+				"01",       // new params bound
+				"0300",     // long
+				"fd00",     // var string
+				"01000000", // 1
+			),
+			attributesKnown: true,
+			want:            "INSERT INTO t VALUES (1, NULL)",
+		},
+		{
+			name:   "null bitmap second byte",
+			text:   "SELECT ?,?,?,?,?,?,?,?,?",
+			params: 9,
+			body: protocolPacket(
+				executeHeader,
+				"0001", // null bitmap: ninth parameter
+				"01",   // new params bound
+				strings.Repeat("0100", 9),
+				"0102030405060708",
+			),
+			attributesKnown: true,
+			want:            "SELECT 1,2,3,4,5,6,7,8,NULL",
+		},
+		{
+			name:   "all null",
+			text:   "SELECT ?, ?",
+			params: 2,
+			body: protocolPacket(
+				executeHeader,
+				"03", // null bitmap: both
+				"01", // new params bound
+				"0800",
+				"0800",
+			),
+			attributesKnown: true,
+			want:            "SELECT NULL, NULL",
+		},
+		{
+			name:   "types not bound on the first execution",
+			text:   "SELECT ?",
+			params: 1,
+			body: protocolPacket(
+				executeHeader,
+				"00", // null bitmap
+				"00", // new params bound: no
+				"01000000",
+			),
+			attributesKnown: true,
+			wantErr:         errShortExecute,
+		},
+		{
+			name:   "query attributes",
+			text:   "SELECT ?",
+			params: 1,
+			body: protocolPacket(
+				executeHeader,
+				"02",         // parameter count, one attribute
+				"00",         // null bitmap
+				"01",         // new params bound
+				"0300", "00", // long, no name
+				"fd00",             // var string
+				"0774726163656964", // name traceid
+				"01000000",         // 1
+				"03616263",         // abc
+			),
+			queryAttributes: true,
+			attributesKnown: true,
+			want:            "SELECT 1",
+		},
+		{
+			name:   "query attributes without a captured handshake",
+			text:   "SELECT ?",
+			params: 1,
+			body: protocolPacket(
+				executeHeader,
+				"02",         // parameter count, one attribute
+				"00",         // null bitmap
+				"01",         // new params bound
+				"0300", "00", // long, no name
+				"fd00", "0474726163", // var string, name trac
+				"01000000", // 1
+				"03616263", // abc
+			),
+			want: "SELECT 1",
+		},
+		{
+			name:   "parameter count without parameters",
+			text:   "SELECT 1",
+			params: 0,
+			body: protocolPacket(
+				"01000000", // statement id
+				"08",       // flags: parameter count available
+				"01000000", // iteration count
+				"00",       // parameter count
+			),
+			queryAttributes: true,
+			attributesKnown: true,
+			want:            "SELECT 1",
+		},
+		{
+			name:   "trailing bytes",
+			text:   "SELECT ?",
+			params: 1,
+			body: protocolPacket(
+				executeHeader,
+				"00",       // null bitmap
+				"01",       // new params bound
+				"0300",     // long
+				"01000000", // 1
+				"ff",
+			),
+			attributesKnown: true,
+			wantErr:         errShortExecute,
+		},
+		{
+			name:   "truncated value",
+			text:   "SELECT ?",
+			params: 1,
+			body: protocolPacket(
+				executeHeader,
+				"00",   // null bitmap
+				"01",   // new params bound
+				"fd00", // var string
+				"0561", // 5 bytes announced, 1 sent
+			),
+			attributesKnown: true,
+			wantErr:         errShortExecute,
+		},
+		{
+			name:            "short body",
+			text:            "SELECT 1",
+			body:            protocolPacket("01000000", "00"),
+			attributesKnown: true,
+			wantErr:         errShortExecute,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConnStatements()
+			c.prepared([]byte(tt.text), prepareOK(tt.params))
+			got, known, err := c.execute(tt.body, tt.queryAttributes, tt.attributesKnown)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("execute() error = %v, want %v", err, tt.wantErr)
+			}
+			if !known {
+				t.Errorf("execute() known = false")
+			}
+			if string(got) != tt.want {
+				t.Errorf("execute() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// the parameter types are sent once, the next executions reuse them until
+// they are bound again
+func Test_ConnStatementsExecuteReusesTypes(t *testing.T) {
+	c := newConnStatements()
+	c.prepared([]byte("SELECT ?"), prepareOK(1))
+
+	executions := []struct {
+		body []byte
+		want string
+	}{
+		{protocolPacket(executeHeader, "00", "01", "0300", "01000000"), "SELECT 1"},
+		{protocolPacket(executeHeader, "00", "00", "02000000"), "SELECT 2"},
+		{protocolPacket(executeHeader, "00", "01", "fd00", "0161"), "SELECT 'a'"},
+		{protocolPacket(executeHeader, "00", "00", "0162"), "SELECT 'b'"},
+		{protocolPacket(executeHeader, "01", "00"), "SELECT NULL"},
+	}
+	for n, e := range executions {
+		got, _, err := c.execute(e.body, false, true)
+		if err != nil {
+			t.Fatalf("execution %d: error = %v", n, err)
+		}
+		if string(got) != e.want {
+			t.Errorf("execution %d = %s, want %s", n, got, e.want)
+		}
+	}
+}
+
+func Test_ConnStatementsLifecycle(t *testing.T) {
+	c := newConnStatements()
+	execute := protocolPacket(executeHeader, "00", "01", "0300", "01000000")
+
+	// an error response prepared nothing
+	c.prepared([]byte("SELECT ?"), protocolPacket("090000", "01", "ff", "2804", "233432303030"))
+	if _, known, _ := c.execute(execute, false, true); known {
+		t.Errorf("execute() of an error response known = true")
+	}
+
+	c.prepared([]byte("SELECT ?"), prepareOK(1))
+	c.longData(protocolPacket("01000000", "0000", "61"))
+	if _, _, err := c.execute(execute, false, true); !errors.Is(err, errLongData) {
+		t.Errorf("execute() after long data error = %v, want %v", err, errLongData)
+	}
+	if got, _, err := c.execute(execute, false, true); err != nil || string(got) != "SELECT 1" {
+		t.Errorf("execute() after the long data execution = %s, %v", got, err)
+	}
+
+	c.close(protocolPacket("01000000"))
+	if _, known, _ := c.execute(execute, false, true); known {
+		t.Errorf("execute() of a closed statement known = true")
+	}
+}
+
+func Test_BindParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		values  []string
+		want    string
+		wantErr bool
+	}{
+		{"placeholders", "SELECT * FROM t WHERE a = ? AND b IN (?, ?)", []string{"1", "'x'", "NULL"}, "SELECT * FROM t WHERE a = 1 AND b IN ('x', NULL)", false},
+		{"quoted", `SELECT '?', "?", ` + "`?`" + `, ?`, []string{"1"}, `SELECT '?', "?", ` + "`?`" + `, 1`, false},
+		{"escaped quote", `SELECT 'it\'s ?', ?`, []string{"1"}, `SELECT 'it\'s ?', 1`, false},
+		{"comments", "SELECT ? -- ?\n, ? # ?\n, /* ? */ ?", []string{"1", "2", "3"}, "SELECT 1 -- ?\n, 2 # ?\n, /* ? */ 3", false},
+		{"unterminated comment", "SELECT ? /* ?", []string{"1"}, "SELECT 1 /* ?", false},
+		{"too few values", "SELECT ?, ?", []string{"1"}, "", true},
+		{"too many values", "SELECT ?", []string{"1", "2"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bindParams([]byte(tt.text), tt.values)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("bindParams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("bindParams() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
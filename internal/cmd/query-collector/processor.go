@@ -3,15 +3,22 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"hash"
+	"io"
 	"log"
+	"net/http"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"mysql-load-test/internal/dedup"
+	myerror "mysql-load-test/internal/error"
+	"mysql-load-test/pkg/fingerprint"
 	httpclient "mysql-load-test/pkg/http_client"
 	"mysql-load-test/pkg/query"
+	"mysql-load-test/pkg/transform"
 
 	"github.com/alitto/pond/v2"
 	"github.com/bagaswh/mysql-toolkit/pkg/lexer"
@@ -22,6 +29,8 @@ import (
 type cache[I any] struct {
 	mu   sync.RWMutex
 	data map[string]I
+
+	hits, misses atomic.Uint64
 }
 
 func NewCache[I any]() *cache[I] {
@@ -34,9 +43,25 @@ func (c *cache[I]) Get(key []byte) (I, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	val, ok := c.data[string(key)]
+	if ok {
+		c.hits.Add(1)
+	} else {
+		c.misses.Add(1)
+	}
 	return val, ok
 }
 
+func (c *cache[I]) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.data)
+}
+
+// Stats returns the hit and miss counts and the number of entries
+func (c *cache[I]) Stats() (hits, misses uint64, entries int) {
+	return c.hits.Load(), c.misses.Load(), c.Len()
+}
+
 func (c *cache[I]) Set(key []byte, val I) I {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -45,17 +70,50 @@ func (c *cache[I]) Set(key []byte, val I) I {
 }
 
 type ProcessorConfig struct {
-	MaxConcurrency     int
-	FingerprintServers []string
-	ProgressInterval   time.Duration
+	MaxConcurrency   int
+	ProgressInterval time.Duration
+
+	// FingerprintServers, when set, moves normalization and hashing to the
+	// given fingerprint servers, queried in batches of FingerprintBatchSize.
+	FingerprintServers   []string
+	FingerprintBatchSize int
+	// FingerprintTimeout bounds each request to a fingerprint server and
+	// FingerprintRetries is how many other servers are tried when one is
+	// unreachable. Servers failing FingerprintBreakerThreshold requests in a
+	// row are skipped for FingerprintBreakerCooldown.
+	FingerprintTimeout          time.Duration
+	FingerprintRetries          int
+	FingerprintBreakerThreshold int
+	FingerprintBreakerCooldown  time.Duration
+
+	// Dedup drops queries whose hash was already emitted. It is one of
+	// "" (disabled), "memory" or "disk".
+	Dedup              string
+	DedupDir           string
+	DedupExpectedItems uint64
+
+	// Transforms names registered transformers applied, in order, to every
+	// valid query before normalization.
+	Transforms []string
+
+	// Audit counts the sensitive literals in valid queries and whether the
+	// transforms scrubbed them.
+	Audit bool
 }
 
 type Processor struct {
 	cfg            ProcessorConfig
+	summary        *ExtractionSummary
 	workerPool     pond.Pool
 	httpClient     *httpclient.LoadBalancedClient
 	progressTicker *time.Ticker
 	progress       atomic.Int64
+	dedupIndex     dedup.Index
+	transformers   transform.Chain
+	duplicates     atomic.Int64
+	errors         atomic.Uint64
+	errorPolicy    *ErrorPolicy
+	audit          *AnonymizationAudit
 
 	rawQueriesCache       *cache[[]byte]
 	rawQueriesHashCache   *cache[uint64]
@@ -69,18 +127,52 @@ type Processor struct {
 	normalizeFingerprintConfig normalizer.Config
 }
 
-func NewProcessor(cfg ProcessorConfig) (*Processor, error) {
+func NewProcessor(cfg ProcessorConfig, summary *ExtractionSummary, errorPolicy *ErrorPolicy) (*Processor, error) {
 	if cfg.MaxConcurrency <= 0 {
 		return nil, fmt.Errorf("max concurrency must be greater than 0: %d", cfg.MaxConcurrency)
 	}
 
 	var httpClient *httpclient.LoadBalancedClient
 	if len(cfg.FingerprintServers) > 0 {
+		if cfg.FingerprintBatchSize <= 0 {
+			return nil, fmt.Errorf("fingerprint batch size must be greater than 0: %d", cfg.FingerprintBatchSize)
+		}
 		var err error
 		httpClient, err = httpclient.NewLoadBalancedClient(cfg.FingerprintServers, nil)
 		if err != nil {
 			return nil, fmt.Errorf("error creating http client: %w", err)
 		}
+		httpClient.SetPolicy(httpclient.LeastOutstanding)
+		httpClient.SetTimeout(cfg.FingerprintTimeout)
+		httpClient.SetRetryConfig(httpclient.RetryConfig{MaxRetries: cfg.FingerprintRetries})
+		httpClient.SetCircuitBreaker(httpclient.CircuitBreakerConfig{
+			FailureThreshold: cfg.FingerprintBreakerThreshold,
+			OpenDuration:     cfg.FingerprintBreakerCooldown,
+		})
+	}
+
+	transformers, err := transform.Lookup(cfg.Transforms)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving transformers: %w", err)
+	}
+
+	var dedupIndex dedup.Index
+	switch cfg.Dedup {
+	case "":
+	case "memory":
+		dedupIndex = dedup.NewMemoryIndex()
+	case "disk":
+		var err error
+		dedupIndex, err = dedup.NewDiskIndex(dedup.DiskIndexConfig{
+			Dir:               cfg.DedupDir,
+			ExpectedItems:     cfg.DedupExpectedItems,
+			FalsePositiveRate: 0.01,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error creating disk dedup index: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported dedup mode: %s", cfg.Dedup)
 	}
 
 	rawQueriesCache := NewCache[[]byte]()
@@ -106,10 +198,20 @@ func NewProcessor(cfg ProcessorConfig) (*Processor, error) {
 		},
 	}
 
+	var audit *AnonymizationAudit
+	if cfg.Audit {
+		audit = NewAnonymizationAudit()
+	}
+
 	return &Processor{
 		cfg:            cfg,
+		audit:          audit,
+		summary:        summary,
+		errorPolicy:    errorPolicy,
 		httpClient:     httpClient,
 		progressTicker: time.NewTicker(time.Second),
+		dedupIndex:     dedupIndex,
+		transformers:   transformers,
 
 		rawQueriesCache:       rawQueriesCache,
 		rawQueriesHashCache:   rawQueriesHashCache,
@@ -131,6 +233,11 @@ func NewProcessor(cfg ProcessorConfig) (*Processor, error) {
 
 func (p *Processor) Close() {
 	p.progressTicker.Stop()
+	if p.dedupIndex != nil {
+		if err := p.dedupIndex.Close(); err != nil {
+			log.Printf("Error closing dedup index: %v\n", err)
+		}
+	}
 }
 
 func (p *Processor) startProgressReporting(ctx context.Context) {
@@ -144,6 +251,9 @@ func (p *Processor) startProgressReporting(ctx context.Context) {
 				progress := p.progress.Load()
 				if progress > 0 {
 					fmt.Printf("%d queries processed (%d/s)\n", progress, int64(progress-lastProgress))
+					if p.dedupIndex != nil {
+						fmt.Printf("%d unique queries, %d duplicates dropped\n", p.dedupIndex.Len(), p.duplicates.Load())
+					}
 				}
 				lastProgress = progress
 			}
@@ -203,6 +313,11 @@ func removeEscapedWhitespaces(q []byte) []byte {
 }
 
 func (p *Processor) processorGoroutine(ctx context.Context, inQueryChan <-chan *query.Query, outQueryChan chan<- *query.Query, errsChan chan<- error, fatalErrsChan chan<- error) {
+	if p.httpClient != nil {
+		p.remoteProcessorGoroutine(ctx, inQueryChan, outQueryChan, errsChan, fatalErrsChan)
+		return
+	}
+
 	hasher := p.hasherPool.Get().(hash.Hash64)
 	defer p.hasherPool.Put(hasher)
 	lexer := p.lexerPool.Get().(*lexer.Lexer)
@@ -216,20 +331,8 @@ func (p *Processor) processorGoroutine(ctx context.Context, inQueryChan <-chan *
 		case <-ctx.Done():
 			return
 		default:
-			p.incrementProgress()
-
-			// origRaw := make([]byte, len(q.Raw))
-			// copy(origRaw, q.Raw)
-
-			// q.Raw = bytesTrimSpace(q.Raw)
-			q.Raw = removeEscapedWhitespaces(q.Raw)
-			q.Raw = bytes.TrimSpace(q.Raw)
-
-			if q.CompletelyProcessed {
-				continue
-			}
-
-			if !isValidQuery(q.Raw) {
+			q, ok := p.prepare(q, errsChan)
+			if !ok {
 				continue
 			}
 
@@ -239,9 +342,11 @@ func (p *Processor) processorGoroutine(ctx context.Context, inQueryChan <-chan *
 			}
 
 			var err error
+			raw := q.Raw
 			q.Raw, buf, err = normalizeAndPutToCache(q.Raw, p.rawQueriesCache, p.normalizeRawConfig, lexer, buf)
 			if err != nil {
-				errsChan <- fmt.Errorf("error normalizing query: %w", err)
+				p.summary.Skip(SkipNormalizeError)
+				errsChan <- withRecord(fmt.Errorf("error normalizing query: %w", err), q.Offset, raw)
 				continue
 			}
 			if q.Hash == 0 {
@@ -256,15 +361,12 @@ func (p *Processor) processorGoroutine(ctx context.Context, inQueryChan <-chan *
 			if q.Fingerprint == nil || len(q.Fingerprint) == 0 {
 				q.Fingerprint, buf, err = normalizeAndPutToCache(q.Raw, p.fingerprintsCache, p.normalizeFingerprintConfig, lexer, buf)
 				if err != nil {
-					errsChan <- fmt.Errorf("error normalizing fingerprint for query: %w", err)
+					p.summary.Skip(SkipNormalizeError)
+					errsChan <- withRecord(fmt.Errorf("error normalizing fingerprint for query: %w", err), q.Offset, q.Raw)
 					continue
 				}
 			}
 
-			if len(q.Fingerprint) == 0 {
-				continue
-			}
-
 			if q.FingerprintHash == 0 && len(q.Fingerprint) > 0 {
 				existingHash, ok := p.fingerprintsHashCache.Get(q.Fingerprint)
 				if ok {
@@ -274,15 +376,205 @@ func (p *Processor) processorGoroutine(ctx context.Context, inQueryChan <-chan *
 				}
 			}
 
-			if !isValidFingerprint(q.Fingerprint) {
+			if !p.finish(q, outQueryChan, fatalErrsChan) {
+				return
+			}
+		}
+	}
+}
+
+// remoteProcessorGoroutine delegates normalization and hashing to the
+// fingerprint servers, sending queries in batches. Partial batches are
+// flushed periodically so slow inputs don't hold queries back indefinitely.
+func (p *Processor) remoteProcessorGoroutine(ctx context.Context, inQueryChan <-chan *query.Query, outQueryChan chan<- *query.Query, errsChan chan<- error, fatalErrsChan chan<- error) {
+	batch := make([]*query.Query, 0, p.cfg.FingerprintBatchSize)
+	flushTicker := time.NewTicker(100 * time.Millisecond)
+	defer flushTicker.Stop()
+
+	flush := func() bool {
+		if len(batch) == 0 {
+			return true
+		}
+		defer func() { batch = batch[:0] }()
+
+		results, err := p.fingerprintRemote(ctx, batch)
+		if err != nil {
+			for range batch {
+				p.summary.Skip(SkipNormalizeError)
+			}
+			errsChan <- withQueries(myerror.Wrap(err, "error fingerprinting batch", "queries", len(batch)), batch)
+			return true
+		}
+
+		for i, q := range batch {
+			res := results[i]
+			if res.Error != "" {
+				p.summary.Skip(SkipNormalizeError)
+				errsChan <- withRecord(fmt.Errorf("error normalizing query: %s", res.Error), q.Offset, q.Raw)
+				continue
+			}
+			q.Raw = []byte(res.Query)
+			q.Hash = res.Hash
+			q.Fingerprint = []byte(res.Fingerprint)
+			q.FingerprintHash = res.FingerprintHash
+			if !p.finish(q, outQueryChan, fatalErrsChan) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-flushTicker.C:
+			if !flush() {
+				return
+			}
+		case q, ok := <-inQueryChan:
+			if !ok {
+				flush()
+				return
+			}
+			q, ok = p.prepare(q, errsChan)
+			if !ok {
 				continue
 			}
+			batch = append(batch, q)
+			if len(batch) >= p.cfg.FingerprintBatchSize {
+				if !flush() {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Processor) fingerprintRemote(ctx context.Context, batch []*query.Query) ([]fingerprint.Result, error) {
+	req := fingerprint.BatchRequest{
+		Queries: make([]string, len(batch)),
+	}
+	for i, q := range batch {
+		req.Queries[i] = string(q.Raw)
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, fingerprint.BatchPath, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, myerror.New("unexpected status from fingerprint server", "status", resp.StatusCode, "body", string(bytes.TrimSpace(msg)))
+	}
+
+	var batchResp fingerprint.BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batchResp); err != nil {
+		return nil, fmt.Errorf("error decoding response: %w", err)
+	}
+	if len(batchResp.Results) != len(batch) {
+		return nil, myerror.New("unexpected number of fingerprint results", "expected", len(batch), "got", len(batchResp.Results))
+	}
+	return batchResp.Results, nil
+}
+
+// prepare cleans up an extracted query and runs the checks and transforms
+// that come before normalization.
+func (p *Processor) prepare(q *query.Query, errsChan chan<- error) (*query.Query, bool) {
+	p.incrementProgress()
+
+	// origRaw := make([]byte, len(q.Raw))
+	// copy(origRaw, q.Raw)
+
+	// q.Raw = bytesTrimSpaceInPlace(q.Raw)
+	q.Raw = removeEscapedWhitespaces(q.Raw)
+	q.Raw = bytes.TrimSpace(q.Raw)
+
+	if q.CompletelyProcessed {
+		return nil, false
+	}
+
+	if !isValidSessionQuery(q) {
+		p.summary.Skip(SkipInvalidQuery)
+		return nil, false
+	}
+
+	// literals found before the transforms had a chance to scrub them
+	var literals uint
+	if p.audit != nil {
+		literals = matchLiterals(q.Raw)
+	}
+
+	if len(p.transformers) > 0 {
+		transformed, err := p.transformers.Apply(q)
+		if err != nil {
+			p.summary.Skip(SkipTransformError)
+			errsChan <- withRecord(myerror.Wrap(err, "error transforming query", "transforms", p.cfg.Transforms), q.Offset, q.Raw)
+			return nil, false
+		}
+		if transformed == nil {
+			if p.audit != nil {
+				p.audit.Observe(literals, nil)
+			}
+			p.summary.Skip(SkipTransformDropped)
+			return nil, false
+		}
+		q = transformed
+	}
 
-			q.CompletelyProcessed = true
+	if p.audit != nil {
+		p.audit.Observe(literals, q.Raw)
+	}
+	return q, true
+}
 
-			outQueryChan <- q
+// finish validates a normalized and hashed query and emits it. It returns
+// false when processing must stop because of a fatal error.
+func (p *Processor) finish(q *query.Query, outQueryChan chan<- *query.Query, fatalErrsChan chan<- error) bool {
+	if len(q.Fingerprint) == 0 {
+		p.summary.Skip(SkipInvalidFingerprint)
+		return true
+	}
+
+	if !isValidFingerprint(q.Fingerprint) {
+		p.summary.Skip(SkipInvalidFingerprint)
+		return true
+	}
+
+	// a replayed session needs every query of it
+	if p.dedupIndex != nil && q.Session == 0 {
+		seen, err := p.dedupIndex.SeenOrAdd(q.Hash)
+		if err != nil {
+			select {
+			case fatalErrsChan <- myerror.Wrap(err, "error checking dedup index", "hash", q.Hash):
+			default:
+			}
+			return false
+		}
+		if seen {
+			p.duplicates.Add(1)
+			p.summary.Skip(SkipDuplicate)
+			return true
 		}
 	}
+
+	q.CompletelyProcessed = true
+
+	p.summary.Emitted(q.FingerprintHash)
+	outQueryChan <- q
+	return true
 }
 
 func (p *Processor) StartProcessingQueries(ctx context.Context, inQueryChan <-chan *query.Query, outQueryChan chan<- *query.Query) error {
@@ -290,6 +582,13 @@ func (p *Processor) StartProcessingQueries(ctx context.Context, inQueryChan <-ch
 	defer cancel(nil)
 
 	p.startProgressReporting(ctx)
+	if p.httpClient != nil {
+		p.httpClient.StartHealthChecks(newCtx, httpclient.HealthCheckConfig{
+			Path:     "/healthz",
+			Interval: 5 * time.Second,
+			Timeout:  time.Second,
+		})
+	}
 
 	errsChan := make(chan error, 100)
 	fatalErrsChan := make(chan error, 1)
@@ -299,19 +598,31 @@ func (p *Processor) StartProcessingQueries(ctx context.Context, inQueryChan <-ch
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			p.processorGoroutine(ctx, inQueryChan, outQueryChan, errsChan, fatalErrsChan)
+			p.processorGoroutine(newCtx, inQueryChan, outQueryChan, errsChan, fatalErrsChan)
 		}()
 	}
 
 	go func() {
+		// after the error policy stopped the run the errors still in flight
+		// are drained so the workers can exit
+		failed := false
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case err := <-errsChan:
+				if failed {
+					continue
+				}
+				p.errors.Add(1)
 				log.Printf("Error processing query: %v\n", err)
+				if err := p.errorPolicy.Handle(StageProcessor, err); err != nil {
+					failed = true
+					cancel(err)
+				}
 			case err := <-fatalErrsChan:
-				log.Printf("Fatal error: %v\n", err)
+				log.Printf("Fatal error: %s\n", myerror.Verbose(err))
+				cancel(err)
 				return
 			}
 		}
@@ -2,7 +2,8 @@ package main
 
 import (
 	stdbytes "bytes"
-	"fmt"
+
+	"mysql-load-test/pkg/query"
 
 	"github.com/bagaswh/mysql-toolkit/pkg/bytes"
 )
@@ -27,7 +28,9 @@ func bytesContainsWeirdChars(b []byte) bool {
 	return false
 }
 
-func bytesTrimSpace(b []byte) []byte {
+// bytesTrimSpaceInPlace trims the whitespace around b by moving its content
+// to the start of b, keeping the capacity of b
+func bytesTrimSpaceInPlace(b []byte) []byte {
 	return bytesTrimFuncInPlace(b, isWhitespace)
 }
 
@@ -35,9 +38,7 @@ func bytesTrimFuncInPlace(b []byte, fn func(byte) bool) []byte {
 	i := 0
 	bi := 0
 	trailingTrimAt := -1
-	iter := 0
 	for i < len(b) {
-		iter++
 		if bi == 0 {
 			if fn(b[i]) {
 				i++
@@ -56,12 +57,10 @@ func bytesTrimFuncInPlace(b []byte, fn func(byte) bool) []byte {
 		i++
 	}
 
-	fmt.Println("iter", iter, "len", len(b))
-
 	if trailingTrimAt != -1 {
-		return b[:trailingTrimAt:trailingTrimAt]
+		return b[:trailingTrimAt]
 	}
-	return b[:bi:bi]
+	return b[:bi]
 }
 
 var (
@@ -86,6 +85,19 @@ func isValidQuery(q []byte) bool {
 	return true
 }
 
+// isSessionStatement tells the SET statements kept for the queries of a
+// recorded session, they change the state the next queries of the session
+// run in
+func isSessionStatement(q []byte) bool {
+	return len(q) > 4 && stdbytes.EqualFold(q[:4], []byte("set ")) && !bytesContainsWeirdChars(q[:min(len(q), 25)])
+}
+
+// isValidSessionQuery is isValidQuery, also accepting the session
+// statements of queries recorded with their session
+func isValidSessionQuery(q *query.Query) bool {
+	return isValidQuery(q.Raw) || q.Session != 0 && isSessionStatement(q.Raw)
+}
+
 var invalidFingerprintPrefixes = [][]byte{
 	// this contains multiple select somehow
 	// []byte("select ticket_status, chat_log_id_start, chat_log_id_end from botika_helpdesk_tickets where bot_id = ? and ticket_status != ? and ticket_status != ? and ticket_group = ? select ticket_status, chat_log_id_start, chat_log_id_end from botika_helpdesk_tickets where bot_id = ? and ticket_status != ? and ticket_status != ? and user_id = ? order by ticket_idx desc limit ?"),
@@ -16,20 +16,35 @@ func Test_BytesTrimSpaceInPlace(t *testing.T) {
 			b:    []byte("  select * from test  "),
 			want: []byte("select * from test"),
 		},
-		// {
-		// 	name: "no trim",
-		// 	b:    []byte("select * from test"),
-		// 	want: []byte("select * from test"),
-		// },
+		{
+			name: "no trim",
+			b:    []byte("select * from test"),
+			want: []byte("select * from test"),
+		},
+		{
+			name: "tabs and newlines",
+			b:    []byte("\t\nselect 1\r\n"),
+			want: []byte("select 1"),
+		},
+		{
+			name: "inner space kept",
+			b:    []byte("select  1 "),
+			want: []byte("select  1"),
+		},
+		{
+			name: "only space",
+			b:    []byte(" \t "),
+			want: []byte{},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			newb := bytesTrimSpaceInPlace(tt.b)
 			if !bytes.Equal(newb, tt.want) {
-				t.Errorf("bytesTrimSpaceInPlace()\n\t% x,\n\t% x\n", string(tt.b), string(tt.want))
+				t.Errorf("bytesTrimSpaceInPlace() = %q, want %q", newb, tt.want)
 			}
 			if cap(newb) != cap(tt.b) {
-				t.Errorf("bytesTrimSpaceInPlace() cap = %d, want %d", cap(newb), cap(tt.want))
+				t.Errorf("bytesTrimSpaceInPlace() cap = %d, want %d", cap(newb), cap(tt.b))
 			}
 		})
 	}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type SkipReason int
+
+const (
+	SkipNotComQuery SkipReason = iota
+	SkipTruncatedCapture
+	SkipNoPayload
+	SkipParseError
+	SkipFiltered
+	SkipInvalidQuery
+	SkipInvalidFingerprint
+	SkipNormalizeError
+	SkipDuplicate
+	SkipTransformDropped
+	SkipTransformError
+	SkipUnknownStatement
+	numSkipReasons
+)
+
+var skipReasonNames = [numSkipReasons]string{
+	SkipNotComQuery:        "not_com_query",
+	SkipTruncatedCapture:   "truncated_capture",
+	SkipNoPayload:          "no_payload",
+	SkipParseError:         "parse_error",
+	SkipFiltered:           "filtered",
+	SkipInvalidQuery:       "invalid_query",
+	SkipInvalidFingerprint: "invalid_fingerprint",
+	SkipNormalizeError:     "normalize_error",
+	SkipDuplicate:          "duplicate",
+	SkipTransformDropped:   "transform_dropped",
+	SkipTransformError:     "transform_error",
+	SkipUnknownStatement:   "unknown_statement",
+}
+
+func (r SkipReason) String() string {
+	return skipReasonNames[r]
+}
+
+// ExtractionSummary accumulates counters from every pipeline stage so the end
+// of a run can tell how much of the input actually made it into the corpus.
+type ExtractionSummary struct {
+	recordsRead atomic.Uint64
+	extracted   atomic.Uint64
+	emitted     atomic.Uint64
+	skipped     [numSkipReasons]atomic.Uint64
+	// stageErrors counts the outcomes of the error policy by stage
+	stageErrors [numErrorStages][numErrorOutcomes]atomic.Uint64
+
+	mu             sync.Mutex
+	encapsulations map[string]uint64
+	parseErrors    map[string]uint64
+	// fingerprints counts the emitted queries per fingerprint hash, only
+	// when tracked
+	fingerprints map[uint64]uint64
+}
+
+func NewExtractionSummary() *ExtractionSummary {
+	return &ExtractionSummary{
+		encapsulations: make(map[string]uint64),
+		parseErrors:    make(map[string]uint64),
+	}
+}
+
+// RecordRead counts a packet or line read from the input, whatever its fate.
+func (s *ExtractionSummary) RecordRead() {
+	s.recordsRead.Add(1)
+}
+
+// Extracted counts a query handed from the input to the processor.
+func (s *ExtractionSummary) Extracted() {
+	s.extracted.Add(1)
+}
+
+// TrackFingerprints makes the summary count the emitted queries per
+// fingerprint, it must be called before the pipeline starts
+func (s *ExtractionSummary) TrackFingerprints() {
+	s.fingerprints = make(map[uint64]uint64)
+}
+
+// Emitted counts a query handed from the processor to the output.
+func (s *ExtractionSummary) Emitted(fingerprintHash uint64) {
+	s.emitted.Add(1)
+	if s.fingerprints != nil {
+		s.mu.Lock()
+		s.fingerprints[fingerprintHash]++
+		s.mu.Unlock()
+	}
+}
+
+// FingerprintCounts returns a copy of the emitted queries per fingerprint,
+// nil unless tracked
+func (s *ExtractionSummary) FingerprintCounts() map[uint64]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.fingerprints == nil {
+		return nil
+	}
+	counts := make(map[uint64]uint64, len(s.fingerprints))
+	for hash, n := range s.fingerprints {
+		counts[hash] = n
+	}
+	return counts
+}
+
+func (s *ExtractionSummary) Skip(reason SkipReason) {
+	s.skipped[reason].Add(1)
+}
+
+// StageError counts n records dropped by a recoverable error of stage by what the error policy
+// did with it.
+func (s *ExtractionSummary) StageError(stage ErrorStage, outcome ErrorOutcome, n uint64) {
+	s.stageErrors[stage][outcome].Add(n)
+}
+
+// ParseError counts an input record that failed to parse, by error kind.
+func (s *ExtractionSummary) ParseError(kind string) {
+	s.mu.Lock()
+	s.parseErrors[kind]++
+	s.mu.Unlock()
+}
+
+func (s *ExtractionSummary) Encapsulation(name string) {
+	s.mu.Lock()
+	s.encapsulations[name]++
+	s.mu.Unlock()
+}
+
+type ExtractionSummarySnapshot struct {
+	RecordsRead    uint64            `json:"records_read"`
+	Extracted      uint64            `json:"extracted"`
+	Emitted        uint64            `json:"emitted"`
+	Skipped        map[string]uint64 `json:"skipped"`
+	SkippedTotal   uint64            `json:"skipped_total"`
+	Encapsulations map[string]uint64 `json:"encapsulations,omitempty"`
+	ParseErrors    map[string]uint64 `json:"parse_errors,omitempty"`
+	// StageErrors counts the error policy outcomes by stage, e.g.
+	// {"output": {"dead_lettered": 3}}
+	StageErrors map[string]map[string]uint64 `json:"stage_errors,omitempty"`
+}
+
+func (s *ExtractionSummary) Snapshot() ExtractionSummarySnapshot {
+	snap := ExtractionSummarySnapshot{
+		RecordsRead: s.recordsRead.Load(),
+		Extracted:   s.extracted.Load(),
+		Emitted:     s.emitted.Load(),
+		Skipped:     make(map[string]uint64, numSkipReasons),
+	}
+	for reason := SkipReason(0); reason < numSkipReasons; reason++ {
+		n := s.skipped[reason].Load()
+		snap.Skipped[reason.String()] = n
+		snap.SkippedTotal += n
+	}
+	for stage := ErrorStage(0); stage < numErrorStages; stage++ {
+		for outcome := ErrorOutcome(0); outcome < numErrorOutcomes; outcome++ {
+			n := s.stageErrors[stage][outcome].Load()
+			if n == 0 {
+				continue
+			}
+			if snap.StageErrors == nil {
+				snap.StageErrors = make(map[string]map[string]uint64)
+			}
+			if snap.StageErrors[stage.String()] == nil {
+				snap.StageErrors[stage.String()] = make(map[string]uint64)
+			}
+			snap.StageErrors[stage.String()][outcome.String()] = n
+		}
+	}
+
+	s.mu.Lock()
+	snap.Encapsulations = copyCounts(s.encapsulations)
+	snap.ParseErrors = copyCounts(s.parseErrors)
+	s.mu.Unlock()
+
+	return snap
+}
+
+func (s *ExtractionSummary) Print() {
+	snap := s.Snapshot()
+
+	fmt.Println("\n" + strings.Repeat("=", 80))
+	fmt.Println("EXTRACTION SUMMARY")
+	fmt.Println(strings.Repeat("=", 80))
+	fmt.Printf("%-32s %d\n", "records read", snap.RecordsRead)
+	fmt.Printf("%-32s %d\n", "queries extracted", snap.Extracted)
+	fmt.Printf("%-32s %d\n", "queries emitted", snap.Emitted)
+	fmt.Printf("%-32s %d\n", "skipped", snap.SkippedTotal)
+	for reason := SkipReason(0); reason < numSkipReasons; reason++ {
+		if n := snap.Skipped[reason.String()]; n > 0 {
+			fmt.Printf("  %-30s %d (%.2f%%)\n", reason.String(), n, percentOf(n, snap.RecordsRead))
+		}
+	}
+
+	printCounts("parse errors by kind", snap.ParseErrors)
+	if len(snap.StageErrors) > 0 {
+		fmt.Println("errors by stage")
+		for stage := ErrorStage(0); stage < numErrorStages; stage++ {
+			for outcome := ErrorOutcome(0); outcome < numErrorOutcomes; outcome++ {
+				if n := snap.StageErrors[stage.String()][outcome.String()]; n > 0 {
+					fmt.Printf("  %-30s %d\n", stage.String()+" "+outcome.String(), n)
+				}
+			}
+		}
+	}
+	printCounts("packets by encapsulation", snap.Encapsulations)
+	fmt.Println(strings.Repeat("=", 80))
+}
+
+func printCounts(title string, counts map[string]uint64) {
+	if len(counts) == 0 {
+		return
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println(title)
+	for _, name := range names {
+		fmt.Printf("  %-30s %d\n", name, counts[name])
+	}
+}
+
+func copyCounts(counts map[string]uint64) map[string]uint64 {
+	if len(counts) == 0 {
+		return nil
+	}
+	dst := make(map[string]uint64, len(counts))
+	for name, n := range counts {
+		dst[name] = n
+	}
+	return dst
+}
+
+func (s *ExtractionSummary) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(s.Snapshot(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling extraction summary: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("error writing extraction summary: %w", err)
+	}
+	return nil
+}
+
+func percentOf(n, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total) * 100
+}
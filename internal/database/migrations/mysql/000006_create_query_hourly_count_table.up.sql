@@ -0,0 +1,7 @@
+-- Executions per fingerprint and hour of day (UTC) of the capture, for time-of-day weighted replay
+CREATE TABLE QueryHourlyCount (
+    FingerprintHash BIGINT UNSIGNED NOT NULL,
+    `Hour` TINYINT UNSIGNED NOT NULL,
+    `Count` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    PRIMARY KEY (FingerprintHash, `Hour`)
+);
@@ -0,0 +1 @@
+ALTER TABLE QueryFingerprint DROP COLUMN Fingerprint;
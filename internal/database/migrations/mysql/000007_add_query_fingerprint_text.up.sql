@@ -0,0 +1,2 @@
+-- Normalized fingerprint text, so fingerprints loaded from a cache file are readable
+ALTER TABLE QueryFingerprint ADD COLUMN Fingerprint TEXT AFTER ID;
@@ -0,0 +1,14 @@
+-- Ledger of the batches the collector committed, written in the transaction of each batch so a
+-- resumed run can skip the queries already loaded
+CREATE TABLE CollectorBatch (
+    ID BIGINT UNSIGNED PRIMARY KEY AUTO_INCREMENT,
+    Source VARCHAR(768) NOT NULL,
+    StartOffset BIGINT UNSIGNED NOT NULL,
+    EndOffset BIGINT UNSIGNED NOT NULL,
+    Queries INT UNSIGNED NOT NULL,
+    -- sorted source offsets of the queries, delta encoded as uvarints
+    Offsets MEDIUMBLOB NOT NULL,
+    `Status` VARCHAR(16) NOT NULL,
+    CommittedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx__CollectorBatch__Source (Source)
+);
@@ -0,0 +1,3 @@
+-- Raw query text backfilled from the corpus file by mlt corpus backfill-text, NULL until then.
+-- Compressed values are in the format of COMPRESS(), read them with UNCOMPRESS(QueryText).
+ALTER TABLE Query ADD COLUMN QueryText MEDIUMBLOB NULL;
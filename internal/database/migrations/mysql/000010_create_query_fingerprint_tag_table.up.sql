@@ -0,0 +1,9 @@
+-- Labels of fingerprints, e.g. service=checkout, set with mlt tag and used by the load test to
+-- select and mix fingerprints and to break its report down
+CREATE TABLE QueryFingerprintTag (
+    FingerprintHash BIGINT UNSIGNED NOT NULL,
+    `Name` VARCHAR(64) NOT NULL,
+    `Value` VARCHAR(255) NOT NULL,
+    PRIMARY KEY (FingerprintHash, `Name`),
+    INDEX idx__QueryFingerprintTag__Name_Value (`Name`, `Value`)
+);
@@ -0,0 +1,2 @@
+DELETE FROM Query WHERE `Session` <> 0;
+ALTER TABLE Query DROP INDEX idx_hash, DROP COLUMN SessionSeq, DROP COLUMN `Session`, ADD UNIQUE KEY idx_hash (`Hash`);
@@ -0,0 +1,7 @@
+-- Client connection of the query and its position in the connection, 0 for queries collected without sessions.
+-- The queries of a session are kept once per execution, so the unique key covers the session.
+ALTER TABLE Query
+    ADD COLUMN `Session` BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    ADD COLUMN SessionSeq BIGINT UNSIGNED NOT NULL DEFAULT 0,
+    DROP INDEX idx_hash,
+    ADD UNIQUE KEY idx_hash (`Hash`, `Session`, SessionSeq);
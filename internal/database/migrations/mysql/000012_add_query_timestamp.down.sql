@@ -0,0 +1 @@
+ALTER TABLE Query DROP COLUMN `Timestamp`;
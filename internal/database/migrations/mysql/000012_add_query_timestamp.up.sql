@@ -0,0 +1,2 @@
+-- Capture time of the query in Unix seconds, 0 when the input has none. The replay run mode of load-test schedules on it.
+ALTER TABLE Query ADD COLUMN `Timestamp` BIGINT UNSIGNED NOT NULL DEFAULT 0;
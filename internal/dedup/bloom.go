@@ -0,0 +1,57 @@
+package dedup
+
+import "math"
+
+// bloomFilter is a fixed-size bloom filter over already hashed keys. The two
+// halves of the 64-bit key are used for double hashing, so no extra hashing
+// is done per probe.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+func newBloomFilter(expectedItems uint64, falsePositiveRate float64) *bloomFilter {
+	if expectedItems == 0 {
+		expectedItems = 1
+	}
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		falsePositiveRate = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(expectedItems) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) &^ 63
+	k := uint64(math.Round(float64(m) / float64(expectedItems) * math.Ln2))
+	if k == 0 {
+		k = 1
+	}
+
+	return &bloomFilter{
+		bits: make([]uint64, m/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+func (b *bloomFilter) location(key uint64, i uint64) uint64 {
+	h1 := key & 0xffffffff
+	h2 := key >> 32
+	return (h1 + i*h2) % b.m
+}
+
+func (b *bloomFilter) add(key uint64) {
+	for i := uint64(0); i < b.k; i++ {
+		loc := b.location(key, i)
+		b.bits[loc/64] |= 1 << (loc % 64)
+	}
+}
+
+func (b *bloomFilter) mayContain(key uint64) bool {
+	for i := uint64(0); i < b.k; i++ {
+		loc := b.location(key, i)
+		if b.bits[loc/64]&(1<<(loc%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
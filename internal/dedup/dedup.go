@@ -0,0 +1,42 @@
+package dedup
+
+import "sync"
+
+// Index remembers query hashes that were already seen by the collector.
+type Index interface {
+	// SeenOrAdd reports whether hash was already present, adding it otherwise.
+	SeenOrAdd(hash uint64) (bool, error)
+	Len() uint64
+	Close() error
+}
+
+type MemoryIndex struct {
+	mu   sync.Mutex
+	seen map[uint64]struct{}
+}
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		seen: make(map[uint64]struct{}),
+	}
+}
+
+func (m *MemoryIndex) SeenOrAdd(hash uint64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.seen[hash]; ok {
+		return true, nil
+	}
+	m.seen[hash] = struct{}{}
+	return false, nil
+}
+
+func (m *MemoryIndex) Len() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return uint64(len(m.seen))
+}
+
+func (m *MemoryIndex) Close() error {
+	return nil
+}
@@ -0,0 +1,65 @@
+package dedup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskIndex(t *testing.T) {
+	idx, err := NewDiskIndex(DiskIndexConfig{
+		Dir:           t.TempDir(),
+		ExpectedItems: 1000,
+	})
+	assert.NoError(t, err)
+	defer idx.Close()
+
+	for i := uint64(0); i < 1000; i++ {
+		seen, err := idx.SeenOrAdd(i * 0x9e3779b97f4a7c15)
+		assert.NoError(t, err)
+		assert.False(t, seen)
+	}
+	for i := uint64(0); i < 1000; i++ {
+		seen, err := idx.SeenOrAdd(i * 0x9e3779b97f4a7c15)
+		assert.NoError(t, err)
+		assert.True(t, seen)
+	}
+	assert.Equal(t, uint64(1000), idx.Len())
+
+	// only the bloom positives probe the table, hash 0 is kept out of it
+	stats := idx.Stats()
+	assert.Equal(t, uint64(1998), stats.BloomNegatives+stats.DiskLookups)
+	assert.Equal(t, 999+stats.BloomFalsePositives, stats.DiskLookups)
+}
+
+func TestDiskIndexGrows(t *testing.T) {
+	idx, err := NewDiskIndex(DiskIndexConfig{
+		Dir:           t.TempDir(),
+		ExpectedItems: 10,
+	})
+	assert.NoError(t, err)
+	defer idx.Close()
+
+	for i := uint64(1); i <= 10000; i++ {
+		seen, err := idx.SeenOrAdd(i)
+		assert.NoError(t, err)
+		assert.False(t, seen)
+	}
+	for i := uint64(1); i <= 10000; i++ {
+		seen, err := idx.SeenOrAdd(i)
+		assert.NoError(t, err)
+		assert.True(t, seen)
+	}
+	assert.Equal(t, uint64(10000), idx.Len())
+	assert.Greater(t, idx.Stats().Grows, uint64(5))
+}
+
+func TestMemoryIndex(t *testing.T) {
+	idx := NewMemoryIndex()
+
+	seen, _ := idx.SeenOrAdd(42)
+	assert.False(t, seen)
+	seen, _ = idx.SeenOrAdd(42)
+	assert.True(t, seen)
+	assert.Equal(t, uint64(1), idx.Len())
+}
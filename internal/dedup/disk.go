@@ -0,0 +1,231 @@
+package dedup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+)
+
+// maxLoadFactor keeps linear probing sequences short. Past it the table is
+// doubled instead of degrading into full table scans.
+const maxLoadFactor = 0.8
+
+type DiskIndexConfig struct {
+	Dir               string
+	ExpectedItems     uint64
+	FalsePositiveRate float64
+}
+
+type DiskIndexStats struct {
+	BloomNegatives      uint64
+	BloomFalsePositives uint64
+	DiskLookups         uint64
+	// Grows counts the times the table was doubled
+	Grows uint64
+}
+
+// DiskIndex is an open-addressing hash table of query hashes backed by a
+// memory-mapped file, fronted by an in-memory bloom filter. The page cache
+// decides which parts of the table stay resident, so the index can grow far
+// beyond available RAM while the bloom filter keeps most lookups for new
+// hashes from touching the disk at all. ExpectedItems only sizes the initial
+// table, which is rehashed into one twice as large when it fills up.
+type DiskIndex struct {
+	mu       sync.Mutex
+	cfg      DiskIndexConfig
+	file     *os.File
+	data     []byte
+	mask     uint64
+	maxItems uint64
+	count    uint64
+	hasZero  bool
+	bloom    *bloomFilter
+
+	bloomNegatives     atomic.Uint64
+	bloomFalsePositive atomic.Uint64
+	diskLookups        atomic.Uint64
+	grows              atomic.Uint64
+}
+
+func NewDiskIndex(cfg DiskIndexConfig) (*DiskIndex, error) {
+	if cfg.ExpectedItems == 0 {
+		return nil, fmt.Errorf("expected items must be greater than 0")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating dedup directory: %w", err)
+	}
+
+	slots := uint64(1) << bits.Len64(uint64(float64(cfg.ExpectedItems)/maxLoadFactor))
+	file, data, err := mapTable(cfg.Dir, slots)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskIndex{
+		cfg:      cfg,
+		file:     file,
+		data:     data,
+		mask:     slots - 1,
+		maxItems: uint64(float64(slots) * maxLoadFactor),
+		bloom:    newBloomFilter(cfg.ExpectedItems, cfg.FalsePositiveRate),
+	}, nil
+}
+
+// mapTable memory-maps a new empty table of slots in dir
+func mapTable(dir string, slots uint64) (*os.File, []byte, error) {
+	size := int64(slots * 8)
+	file, err := os.CreateTemp(dir, "dedup-*.idx")
+	if err != nil {
+		return nil, nil, fmt.Errorf("error creating dedup index file: %w", err)
+	}
+	// The index only lives for the duration of a run.
+	os.Remove(filepath.Join(dir, filepath.Base(file.Name())))
+
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error sizing dedup index file: %w", err)
+	}
+
+	data, err := syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("error memory-mapping dedup index file: %w", err)
+	}
+	return file, data, nil
+}
+
+// grow rehashes the table into one twice as large, with a bloom filter sized
+// for it. Both tables are on disk until the old one is unmapped.
+func (d *DiskIndex) grow() error {
+	slots := (d.mask + 1) * 2
+	file, data, err := mapTable(d.cfg.Dir, slots)
+	if err != nil {
+		return fmt.Errorf("error growing dedup index: %w", err)
+	}
+
+	old, oldFile, oldSlots := d.data, d.file, d.mask+1
+	d.file, d.data, d.mask = file, data, slots-1
+	d.maxItems = uint64(float64(slots) * maxLoadFactor)
+	d.bloom = newBloomFilter(d.maxItems, d.cfg.FalsePositiveRate)
+	for i := uint64(0); i < oldSlots; i++ {
+		hash := binary.LittleEndian.Uint64(old[i*8 : i*8+8])
+		if hash == 0 {
+			continue
+		}
+		j := hash & d.mask
+		for d.slot(j) != 0 {
+			j = (j + 1) & d.mask
+		}
+		d.setSlot(j, hash)
+		d.bloom.add(hash)
+	}
+
+	d.grows.Add(1)
+	if err := syscall.Munmap(old); err != nil {
+		oldFile.Close()
+		return fmt.Errorf("error unmapping the previous dedup index: %w", err)
+	}
+	return oldFile.Close()
+}
+
+func (d *DiskIndex) slot(i uint64) uint64 {
+	return binary.LittleEndian.Uint64(d.data[i*8 : i*8+8])
+}
+
+func (d *DiskIndex) setSlot(i uint64, hash uint64) {
+	binary.LittleEndian.PutUint64(d.data[i*8:i*8+8], hash)
+}
+
+func (d *DiskIndex) SeenOrAdd(hash uint64) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// Zero marks empty slots, so it is tracked out of band.
+	if hash == 0 {
+		seen := d.hasZero
+		d.hasZero = true
+		return seen, nil
+	}
+
+	// A bloom negative is certainly new, the table is only probed for the
+	// free slot to insert it into.
+	if !d.bloom.mayContain(hash) {
+		d.bloomNegatives.Add(1)
+		return false, d.insert(hash)
+	}
+
+	d.diskLookups.Add(1)
+	i := hash & d.mask
+	for {
+		existing := d.slot(i)
+		if existing == hash {
+			return true, nil
+		}
+		if existing == 0 {
+			break
+		}
+		i = (i + 1) & d.mask
+	}
+	d.bloomFalsePositive.Add(1)
+	return false, d.insert(hash)
+}
+
+// insert adds a hash known not to be in the table
+func (d *DiskIndex) insert(hash uint64) error {
+	if d.count >= d.maxItems {
+		if err := d.grow(); err != nil {
+			return err
+		}
+	}
+	i := hash & d.mask
+	for d.slot(i) != 0 {
+		i = (i + 1) & d.mask
+	}
+	d.setSlot(i, hash)
+	d.bloom.add(hash)
+	d.count++
+	return nil
+}
+
+func (d *DiskIndex) Len() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.hasZero {
+		return d.count + 1
+	}
+	return d.count
+}
+
+func (d *DiskIndex) Stats() DiskIndexStats {
+	return DiskIndexStats{
+		BloomNegatives:      d.bloomNegatives.Load(),
+		BloomFalsePositives: d.bloomFalsePositive.Load(),
+		DiskLookups:         d.diskLookups.Load(),
+		Grows:               d.grows.Load(),
+	}
+}
+
+func (d *DiskIndex) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var errs []error
+	if d.data != nil {
+		if err := syscall.Munmap(d.data); err != nil {
+			errs = append(errs, err)
+		}
+		d.data = nil
+	}
+	if err := d.file.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("error closing dedup index: %w", errs[0])
+	}
+	return nil
+}
@@ -1,5 +1,16 @@
 package error
 
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"runtime"
+	"strconv"
+)
+
+// MyError is an error carrying a message, the error it wraps, the stack
+// where the failure originated and key-value context
 type MyError struct {
 	Inner      error
 	Message    []byte
@@ -7,15 +18,170 @@ type MyError struct {
 	Misc       map[string]any
 }
 
-func Wrap(err error, message string, stackTrace []byte, misc map[string]any) *MyError {
-	return &MyError{
-		Inner:      err,
-		Message:    []byte(message),
-		StackTrace: stackTrace,
-		Misc:       misc,
+// New creates an error with a stack trace of the caller. kv are
+// alternating keys and values added as context.
+func New(message string, kv ...any) *MyError {
+	return newError(nil, message, kv)
+}
+
+// Wrap annotates err with a message and context. The stack is only captured
+// when err doesn't carry one already so the origin of the failure is kept.
+func Wrap(err error, message string, kv ...any) *MyError {
+	return newError(err, message, kv)
+}
+
+func newError(err error, message string, kv []any) *MyError {
+	e := &MyError{
+		Inner:   err,
+		Message: []byte(message),
+	}
+	if Stack(err) == nil {
+		e.StackTrace = captureStack(3)
+	}
+	e.addContext(kv)
+	return e
+}
+
+// With adds key-value context to e and returns it
+func (e *MyError) With(kv ...any) *MyError {
+	e.addContext(kv)
+	return e
+}
+
+func (e *MyError) addContext(kv []any) {
+	if len(kv) == 0 {
+		return
+	}
+	if e.Misc == nil {
+		e.Misc = make(map[string]any, len(kv)/2)
+	}
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		if i+1 == len(kv) {
+			e.Misc["!BADKEY"] = kv[i]
+			break
+		}
+		e.Misc[key] = kv[i+1]
 	}
 }
 
 func (e *MyError) Error() string {
-	return string(e.Message)
+	if e.Inner == nil {
+		return string(e.Message)
+	}
+	if len(e.Message) == 0 {
+		return e.Inner.Error()
+	}
+	return string(e.Message) + ": " + e.Inner.Error()
+}
+
+func (e *MyError) Unwrap() error {
+	return e.Inner
+}
+
+// Format prints the message with %v and %s, and additionally the context
+// and stack trace of the whole chain with %+v
+func (e *MyError) Format(s fmt.State, verb rune) {
+	switch verb {
+	case 'v':
+		if s.Flag('+') {
+			io.WriteString(s, Verbose(e))
+			return
+		}
+		io.WriteString(s, e.Error())
+	case 's':
+		io.WriteString(s, e.Error())
+	case 'q':
+		fmt.Fprintf(s, "%q", e.Error())
+	}
+}
+
+// Stack returns the stack trace of the innermost MyError in err's chain
+// that has one, or nil
+func Stack(err error) []byte {
+	var stack []byte
+	for err != nil {
+		var e *MyError
+		if !errors.As(err, &e) {
+			break
+		}
+		if e.StackTrace != nil {
+			stack = e.StackTrace
+		}
+		err = e.Inner
+	}
+	return stack
+}
+
+// Context merges the context of every MyError in err's chain. Outer errors
+// take precedence over inner ones for the same key.
+func Context(err error) map[string]any {
+	var chain []*MyError
+	for err != nil {
+		var e *MyError
+		if !errors.As(err, &e) {
+			break
+		}
+		chain = append(chain, e)
+		err = e.Inner
+	}
+
+	var ctx map[string]any
+	for i := len(chain) - 1; i >= 0; i-- {
+		if len(chain[i].Misc) == 0 {
+			continue
+		}
+		if ctx == nil {
+			ctx = make(map[string]any)
+		}
+		maps.Copy(ctx, chain[i].Misc)
+	}
+	return ctx
+}
+
+// Verbose formats err with the context and stack trace found in its chain,
+// even when the outermost error is not a MyError
+func Verbose(err error) string {
+	msg := err.Error()
+	if ctx := Context(err); len(ctx) > 0 {
+		msg += fmt.Sprintf(" %v", ctx)
+	}
+	if stack := Stack(err); stack != nil {
+		msg += "\n" + string(stack)
+	}
+	return msg
+}
+
+// MarshalStack returns err's stack trace as a string, or nil. It fits
+// zerolog.ErrorStackMarshaler.
+func MarshalStack(err error) any {
+	stack := Stack(err)
+	if stack == nil {
+		return nil
+	}
+	return string(stack)
+}
+
+func captureStack(skip int) []byte {
+	pcs := make([]uintptr, 32)
+	n := runtime.Callers(skip+1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var buf []byte
+	for {
+		frame, more := frames.Next()
+		buf = append(buf, frame.Function...)
+		buf = append(buf, "\n\t"...)
+		buf = append(buf, frame.File...)
+		buf = append(buf, ':')
+		buf = strconv.AppendInt(buf, int64(frame.Line), 10)
+		buf = append(buf, '\n')
+		if !more {
+			break
+		}
+	}
+	return buf
 }
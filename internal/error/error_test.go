@@ -0,0 +1,49 @@
+package error
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func failingOperation() error {
+	return Wrap(io.ErrUnexpectedEOF, "error reading batch", "offset", 42)
+}
+
+func TestWrap(t *testing.T) {
+	err := fmt.Errorf("error loading file: %w", Wrap(failingOperation(), "error processing", "file", "a.txt"))
+
+	assert.Equal(t, "error loading file: error processing: error reading batch: unexpected EOF", err.Error())
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	var e *MyError
+	assert.True(t, errors.As(err, &e))
+	assert.Equal(t, "error processing", string(e.Message))
+
+	assert.Equal(t, map[string]any{"offset": 42, "file": "a.txt"}, Context(err))
+
+	// The stack is captured where the failure originated
+	stack := string(Stack(err))
+	assert.True(t, strings.HasPrefix(stack, "mysql-load-test/internal/error.failingOperation\n"), stack)
+	assert.Nil(t, e.StackTrace)
+}
+
+func TestFormat(t *testing.T) {
+	err := New("boom").With("query_id", 7)
+
+	assert.Equal(t, "boom", fmt.Sprintf("%v", err))
+	verbose := fmt.Sprintf("%+v", err)
+	assert.True(t, strings.HasPrefix(verbose, "boom map[query_id:7]\nmysql-load-test/internal/error.TestFormat\n"), verbose)
+}
+
+func TestVerbose(t *testing.T) {
+	err := fmt.Errorf("error running: %w", New("boom", "attempt", 2))
+
+	verbose := Verbose(err)
+	assert.True(t, strings.HasPrefix(verbose, "error running: boom map[attempt:2]\nmysql-load-test/internal/error.TestVerbose\n"), verbose)
+	assert.Equal(t, "plain", Verbose(errors.New("plain")))
+}
@@ -0,0 +1,224 @@
+package lrucache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type costEntry[K comparable, V any] struct {
+	key   K
+	value V
+	cost  int64
+	// expiresAt is zero for entries that never expire
+	expiresAt time.Time
+}
+
+// CostLRUCache is an LRU cache bounded by the total cost of its entries
+// rather than their count. The cost of an entry is usually its size in
+// bytes. Entries costing more than the whole budget are never cached.
+type CostLRUCache[K comparable, V any] struct {
+	cache   map[K]*list.Element
+	list    *list.List
+	maxCost int64
+	cost    int64
+	costFn  func(K, V) int64
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	stats   counters
+}
+
+// NewCost creates a cache holding entries worth at most maxCost, as
+// measured by costFn
+func NewCost[K comparable, V any](maxCost int64, costFn func(K, V) int64) *CostLRUCache[K, V] {
+	return NewCostWithTTL(maxCost, costFn, 0)
+}
+
+// NewCostWithTTL is NewCost with entries expiring ttl after they were set,
+// see NewWithTTL
+func NewCostWithTTL[K comparable, V any](maxCost int64, costFn func(K, V) int64, ttl time.Duration) *CostLRUCache[K, V] {
+	return &CostLRUCache[K, V]{
+		cache:   make(map[K]*list.Element),
+		list:    list.New(),
+		maxCost: maxCost,
+		costFn:  costFn,
+		ttl:     ttl,
+		now:     time.Now,
+	}
+}
+
+// lookup returns the live element for key, removing it if it has expired.
+// c.mu must be held.
+func (c *CostLRUCache[K, V]) lookup(key K) (*list.Element, bool) {
+	elem, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if e := elem.Value.(costEntry[K, V]); !e.expiresAt.IsZero() && !c.now().Before(e.expiresAt) {
+		c.remove(elem)
+		c.stats.expirations.Add(1)
+		return nil, false
+	}
+	return elem, true
+}
+
+// remove deletes elem from the cache. c.mu must be held.
+func (c *CostLRUCache[K, V]) remove(elem *list.Element) {
+	e := elem.Value.(costEntry[K, V])
+	delete(c.cache, e.key)
+	c.list.Remove(elem)
+	c.cost -= e.cost
+}
+
+// EvictExpired removes all expired entries and returns how many were removed
+func (c *CostLRUCache[K, V]) EvictExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	removed := 0
+	for elem := c.list.Back(); elem != nil; {
+		prev := elem.Prev()
+		if e := elem.Value.(costEntry[K, V]); !e.expiresAt.IsZero() && !now.Before(e.expiresAt) {
+			c.remove(elem)
+			removed++
+		}
+		elem = prev
+	}
+	c.stats.expirations.Add(int64(removed))
+	return removed
+}
+
+// StartExpiry runs EvictExpired every interval until ctx is done
+func (c *CostLRUCache[K, V]) StartExpiry(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.EvictExpired()
+			}
+		}
+	}()
+}
+
+// StringCost measures a string entry by the length of its value
+func StringCost[K comparable](_ K, v string) int64 {
+	return int64(len(v))
+}
+
+// Stats returns a snapshot of the cache counters. It is safe to call
+// concurrently with any other method.
+func (c *CostLRUCache[K, V]) Stats() LRUCacheStats {
+	return c.stats.snapshot()
+}
+
+// Cost returns the total cost of the cached entries
+func (c *CostLRUCache[K, V]) Cost() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.cost
+}
+
+func (c *CostLRUCache[K, V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.list.Len()
+}
+
+// put inserts or replaces key and evicts from the back until the budget is
+// met again. c.mu must be held.
+func (c *CostLRUCache[K, V]) put(key K, val V) {
+	cost := c.costFn(key, val)
+	if elem, ok := c.cache[key]; ok {
+		c.remove(elem)
+	}
+	if cost > c.maxCost {
+		return
+	}
+
+	for c.cost+cost > c.maxCost {
+		back := c.list.Back()
+		if back == nil {
+			break
+		}
+		c.remove(back)
+		c.stats.evictions.Add(1)
+	}
+
+	e := costEntry[K, V]{key: key, value: val, cost: cost}
+	if c.ttl > 0 {
+		e.expiresAt = c.now().Add(c.ttl)
+	}
+	c.cache[key] = c.list.PushFront(e)
+	c.cost += cost
+	c.stats.newItems.Add(1)
+}
+
+func (c *CostLRUCache[K, V]) GetOrSet(key K, fn func() (V, error)) (V, bool) {
+	c.mu.Lock()
+	if elem, ok := c.lookup(key); ok {
+		c.stats.hits.Add(1)
+		c.list.MoveToFront(elem)
+		c.stats.moveToFront.Add(1)
+		c.mu.Unlock()
+		return elem.Value.(costEntry[K, V]).value, true
+	}
+	c.stats.misses.Add(1)
+	c.mu.Unlock()
+
+	newv, err := fn()
+	if err != nil {
+		var zero V
+		return zero, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.lookup(key); ok {
+		c.stats.hits.Add(1)
+		c.list.MoveToFront(elem)
+		c.stats.moveToFront.Add(1)
+		return elem.Value.(costEntry[K, V]).value, true
+	}
+
+	c.put(key, newv)
+	return newv, false
+}
+
+func (c *CostLRUCache[K, V]) Get(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.lookup(key); ok {
+		c.stats.hits.Add(1)
+		c.list.MoveToFront(elem)
+		c.stats.moveToFront.Add(1)
+		return elem.Value.(costEntry[K, V]).value, true
+	}
+	c.stats.misses.Add(1)
+	var zero V
+	return zero, false
+}
+
+func (c *CostLRUCache[K, V]) Set(key K, val V) V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.put(key, val)
+	return val
+}
+
+func (c *CostLRUCache[K, V]) Peek(key K) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.lookup(key); ok {
+		return elem.Value.(costEntry[K, V]).value, true
+	}
+	var zero V
+	return zero, false
+}
@@ -0,0 +1,69 @@
+package lrucache
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCostLRUCache(t *testing.T) {
+	cache := NewCost[int, string](10, StringCost[int])
+
+	cache.Set(1, "aaaa")
+	cache.Set(2, "bbbb")
+	assert.Equal(t, int64(8), cache.Cost())
+
+	// Touch 1 so 2 is evicted to make room for 3
+	_, ok := cache.Get(1)
+	assert.True(t, ok)
+	cache.Set(3, "cccc")
+	_, ok = cache.Peek(2)
+	assert.False(t, ok)
+	assert.Equal(t, int64(8), cache.Cost())
+
+	// One large entry evicts several small ones
+	val, ok := cache.GetOrSet(4, func() (string, error) {
+		return "dddddddd", nil
+	})
+	assert.False(t, ok)
+	assert.Equal(t, "dddddddd", val)
+	assert.Equal(t, 1, cache.Len())
+	assert.Equal(t, int64(8), cache.Cost())
+
+	// Replacing an entry accounts for its new cost
+	cache.Set(4, "dd")
+	assert.Equal(t, int64(2), cache.Cost())
+
+	// Entries over budget are not cached
+	cache.Set(5, strings.Repeat("e", 11))
+	_, ok = cache.Peek(5)
+	assert.False(t, ok)
+	assert.Equal(t, int64(2), cache.Cost())
+	assert.Equal(t, 3, cache.Stats().EvictionsTotal)
+}
+
+func TestCostLRUCacheTTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewCostWithTTL[int, string](10, StringCost[int], time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.Set(1, "aaaa")
+	now = now.Add(30 * time.Second)
+	cache.Set(2, "bbbb")
+	_, ok := cache.Get(1)
+	assert.True(t, ok)
+
+	// Lazy expiry on access gives the cost back
+	now = now.Add(45 * time.Second)
+	_, ok = cache.Get(1)
+	assert.False(t, ok)
+	assert.Equal(t, int64(4), cache.Cost())
+
+	now = now.Add(time.Minute)
+	assert.Equal(t, 1, cache.EvictExpired())
+	assert.Equal(t, 0, cache.Len())
+	assert.Equal(t, int64(0), cache.Cost())
+	assert.Equal(t, 2, cache.Stats().ExpirationsTotal)
+}
@@ -2,52 +2,128 @@ package lrucache
 
 import (
 	"container/list"
+	"context"
 	"sync"
+	"time"
 )
 
 type entry[K comparable, V any] struct {
 	key   K
 	value V
+	// expiresAt is zero for entries that never expire
+	expiresAt time.Time
 }
 
-type LRUCacheStats struct {
-	HitsTotal, MissesTotal int
-	EvictionsTotal         int
-	MoveToFrontTotal       int
-	NewItemsTotal          int
+func (e entry[K, V]) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
 }
 
 type LRUCache[K comparable, V any] struct {
 	cache map[K]*list.Element
 	list  *list.List
 	size  int
+	ttl   time.Duration
+	now   func() time.Time
 	mu    sync.Mutex
-	stats *LRUCacheStats
+	stats counters
 }
 
 func New[K comparable, V any](size int) *LRUCache[K, V] {
+	return NewWithTTL[K, V](size, 0)
+}
+
+// NewWithTTL creates a cache whose entries expire ttl after they were set.
+// Expired entries are dropped lazily on access, by EvictExpired or by the
+// goroutine started with StartExpiry. A zero ttl disables expiry.
+func NewWithTTL[K comparable, V any](size int, ttl time.Duration) *LRUCache[K, V] {
 	return &LRUCache[K, V]{
 		cache: make(map[K]*list.Element),
 		list:  list.New(),
 		size:  size,
-		stats: &LRUCacheStats{},
+		ttl:   ttl,
+		now:   time.Now,
+	}
+}
+
+func (c *LRUCache[K, V]) expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return c.now().Add(ttl)
+}
+
+// lookup returns the live element for key, removing it if it has expired.
+// c.mu must be held.
+func (c *LRUCache[K, V]) lookup(key K) (*list.Element, bool) {
+	elem, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if elem.Value.(entry[K, V]).expired(c.now()) {
+		c.remove(elem)
+		c.stats.expirations.Add(1)
+		return nil, false
+	}
+	return elem, true
+}
+
+// remove deletes elem from the cache. c.mu must be held.
+func (c *LRUCache[K, V]) remove(elem *list.Element) {
+	delete(c.cache, elem.Value.(entry[K, V]).key)
+	c.list.Remove(elem)
+}
+
+// EvictExpired removes all expired entries and returns how many were removed
+func (c *LRUCache[K, V]) EvictExpired() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	removed := 0
+	for elem := c.list.Back(); elem != nil; {
+		prev := elem.Prev()
+		if elem.Value.(entry[K, V]).expired(now) {
+			c.remove(elem)
+			removed++
+		}
+		elem = prev
 	}
+	c.stats.expirations.Add(int64(removed))
+	return removed
+}
+
+// StartExpiry runs EvictExpired every interval until ctx is done
+func (c *LRUCache[K, V]) StartExpiry(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.EvictExpired()
+			}
+		}
+	}()
 }
 
+// Stats returns a snapshot of the cache counters. It is safe to call
+// concurrently with any other method.
 func (c *LRUCache[K, V]) Stats() LRUCacheStats {
-	return *c.stats
+	return c.stats.snapshot()
 }
 
 func (c *LRUCache[K, V]) GetOrSet(key K, fn func() (V, error)) (V, bool) {
 	c.mu.Lock()
-	if elem, ok := c.cache[key]; ok {
-		c.stats.HitsTotal++
+	if elem, ok := c.lookup(key); ok {
+		c.stats.hits.Add(1)
 		c.list.MoveToFront(elem)
-		c.stats.MoveToFrontTotal++
+		c.stats.moveToFront.Add(1)
 		c.mu.Unlock()
 		return elem.Value.(entry[K, V]).value, true
 	}
-	c.stats.MissesTotal++
+	c.stats.misses.Add(1)
 	c.mu.Unlock()
 
 	newv, err := fn()
@@ -59,59 +135,63 @@ func (c *LRUCache[K, V]) GetOrSet(key K, fn func() (V, error)) (V, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if elem, ok := c.cache[key]; ok {
-		c.stats.HitsTotal++
+	if elem, ok := c.lookup(key); ok {
+		c.stats.hits.Add(1)
 		c.list.MoveToFront(elem)
-		c.stats.MoveToFrontTotal++
+		c.stats.moveToFront.Add(1)
 		return elem.Value.(entry[K, V]).value, true
 	}
 
-	c.stats.MissesTotal++
+	c.stats.misses.Add(1)
 
 	if c.list.Len() >= c.size {
 		back := c.list.Back()
 		if back != nil {
-			evicted := back.Value.(entry[K, V])
-			delete(c.cache, evicted.key)
-			c.list.Remove(back)
-			c.stats.EvictionsTotal++
+			c.remove(back)
+			c.stats.evictions.Add(1)
 		}
 	}
 
-	e := entry[K, V]{key: key, value: newv}
+	e := entry[K, V]{key: key, value: newv, expiresAt: c.expiresAt(c.ttl)}
 	elem := c.list.PushFront(e)
 	c.cache[key] = elem
-	c.stats.NewItemsTotal++
+	c.stats.newItems.Add(1)
 	return newv, false
 }
 
 func (c *LRUCache[K, V]) Get(key K) (V, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if elem, ok := c.cache[key]; ok {
-		c.stats.HitsTotal++
+	if elem, ok := c.lookup(key); ok {
+		c.stats.hits.Add(1)
 		c.list.MoveToFront(elem)
-		c.stats.MoveToFrontTotal++
+		c.stats.moveToFront.Add(1)
 		return elem.Value.(entry[K, V]).value, true
 	}
-	c.stats.MissesTotal++
+	c.stats.misses.Add(1)
 	var zero V
 	return zero, false
 }
 
 func (c *LRUCache[K, V]) Set(key K, val V) V {
+	return c.SetWithTTL(key, val, c.ttl)
+}
+
+// SetWithTTL is like Set but overrides the cache's default TTL for this
+// entry. A zero ttl means the entry never expires.
+func (c *LRUCache[K, V]) SetWithTTL(key K, val V, ttl time.Duration) V {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	elem, ok := c.cache[key]
-	e := entry[K, V]{key: key, value: val}
+	e := entry[K, V]{key: key, value: val, expiresAt: c.expiresAt(ttl)}
 	if ok {
 		elem.Value = e
 		c.list.MoveToFront(elem)
-		c.stats.MoveToFrontTotal++
+		c.stats.moveToFront.Add(1)
 		return elem.Value.(entry[K, V]).value
 	}
 	elem = c.list.PushFront(e)
-	c.stats.NewItemsTotal++
+	c.stats.newItems.Add(1)
 	c.cache[key] = elem
 	return val
 }
@@ -119,7 +199,7 @@ func (c *LRUCache[K, V]) Set(key K, val V) V {
 func (c *LRUCache[K, V]) Peek(key K) (V, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if elem, ok := c.cache[key]; ok {
+	if elem, ok := c.lookup(key); ok {
 		return elem.Value.(entry[K, V]).value, true
 	}
 	var zero V
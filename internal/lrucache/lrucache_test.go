@@ -1,7 +1,9 @@
 package lrucache
 
 import (
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -88,3 +90,62 @@ func TestLRUCache(t *testing.T) {
 	assert.Equal(t, "b", lruKey)
 	assert.Equal(t, "bravo", lruVal)
 }
+
+func TestLRUCacheTTL(t *testing.T) {
+	now := time.Unix(0, 0)
+	cache := NewWithTTL[string, string](10, time.Minute)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("a", "alpha")
+	cache.SetWithTTL("b", "bravo", 0)
+	cache.SetWithTTL("c", "charlie", time.Hour)
+
+	now = now.Add(30 * time.Second)
+	val, ok := cache.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, "alpha", val)
+
+	// Lazy expiry on access
+	now = now.Add(time.Minute)
+	_, ok = cache.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, 1, cache.Stats().ExpirationsTotal)
+
+	// GetOrSet refills an expired entry
+	cache.Set("d", "delta")
+	now = now.Add(2 * time.Minute)
+	val, ok = cache.GetOrSet("d", func() (string, error) {
+		return "delta-prime", nil
+	})
+	assert.False(t, ok)
+	assert.Equal(t, "delta-prime", val)
+
+	now = now.Add(time.Hour)
+	assert.Equal(t, 2, cache.EvictExpired())
+	_, ok = cache.Peek("b")
+	assert.True(t, ok)
+	_, ok = cache.Peek("c")
+	assert.False(t, ok)
+	assert.Equal(t, 4, cache.Stats().ExpirationsTotal)
+}
+
+func TestLRUCacheStatsConcurrent(t *testing.T) {
+	cache := New[int, int](16)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				cache.GetOrSet(i%32, func() (int, error) { return i, nil })
+				cache.Stats()
+			}
+		}()
+	}
+	wg.Wait()
+
+	stats := cache.Stats()
+	assert.GreaterOrEqual(t, stats.HitsTotal+stats.MissesTotal, 4000)
+	assert.LessOrEqual(t, stats.NewItemsTotal, stats.MissesTotal)
+}
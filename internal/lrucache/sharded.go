@@ -0,0 +1,100 @@
+package lrucache
+
+import (
+	"context"
+	"hash/maphash"
+	"time"
+)
+
+// ShardedLRUCache spreads keys over independent LRUCache shards so
+// concurrent callers rarely contend on the same mutex. Recency is tracked
+// per shard, so eviction is only approximately LRU across the whole cache.
+type ShardedLRUCache[K comparable, V any] struct {
+	shards []*LRUCache[K, V]
+	seed   maphash.Seed
+}
+
+// NewSharded creates a cache of the given total size split over shards
+func NewSharded[K comparable, V any](shards, size int) *ShardedLRUCache[K, V] {
+	return NewShardedWithTTL[K, V](shards, size, 0)
+}
+
+// NewShardedWithTTL is NewSharded with a default entry TTL, see NewWithTTL
+func NewShardedWithTTL[K comparable, V any](shards, size int, ttl time.Duration) *ShardedLRUCache[K, V] {
+	if shards <= 0 {
+		shards = 1
+	}
+	shardSize := (size + shards - 1) / shards
+	c := &ShardedLRUCache[K, V]{
+		shards: make([]*LRUCache[K, V], shards),
+		seed:   maphash.MakeSeed(),
+	}
+	for i := range c.shards {
+		c.shards[i] = NewWithTTL[K, V](shardSize, ttl)
+	}
+	return c
+}
+
+func (c *ShardedLRUCache[K, V]) shard(key K) *LRUCache[K, V] {
+	return c.shards[maphash.Comparable(c.seed, key)%uint64(len(c.shards))]
+}
+
+// Stats returns the sum of all shards' stats
+func (c *ShardedLRUCache[K, V]) Stats() LRUCacheStats {
+	var stats LRUCacheStats
+	for _, s := range c.shards {
+		shardStats := s.Stats()
+		stats.HitsTotal += shardStats.HitsTotal
+		stats.MissesTotal += shardStats.MissesTotal
+		stats.EvictionsTotal += shardStats.EvictionsTotal
+		stats.ExpirationsTotal += shardStats.ExpirationsTotal
+		stats.MoveToFrontTotal += shardStats.MoveToFrontTotal
+		stats.NewItemsTotal += shardStats.NewItemsTotal
+	}
+	return stats
+}
+
+func (c *ShardedLRUCache[K, V]) GetOrSet(key K, fn func() (V, error)) (V, bool) {
+	return c.shard(key).GetOrSet(key, fn)
+}
+
+func (c *ShardedLRUCache[K, V]) Get(key K) (V, bool) {
+	return c.shard(key).Get(key)
+}
+
+func (c *ShardedLRUCache[K, V]) Set(key K, val V) V {
+	return c.shard(key).Set(key, val)
+}
+
+func (c *ShardedLRUCache[K, V]) SetWithTTL(key K, val V, ttl time.Duration) V {
+	return c.shard(key).SetWithTTL(key, val, ttl)
+}
+
+func (c *ShardedLRUCache[K, V]) Peek(key K) (V, bool) {
+	return c.shard(key).Peek(key)
+}
+
+// EvictExpired removes expired entries from every shard
+func (c *ShardedLRUCache[K, V]) EvictExpired() int {
+	removed := 0
+	for _, s := range c.shards {
+		removed += s.EvictExpired()
+	}
+	return removed
+}
+
+// StartExpiry runs EvictExpired every interval until ctx is done
+func (c *ShardedLRUCache[K, V]) StartExpiry(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				c.EvictExpired()
+			}
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package lrucache
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShardedLRUCache(t *testing.T) {
+	cache := NewSharded[int, string](4, 64)
+
+	for i := 0; i < 32; i++ {
+		val, ok := cache.GetOrSet(i, func() (string, error) {
+			return fmt.Sprint(i), nil
+		})
+		assert.False(t, ok)
+		assert.Equal(t, fmt.Sprint(i), val)
+	}
+	for i := 0; i < 32; i++ {
+		val, ok := cache.Get(i)
+		assert.True(t, ok)
+		assert.Equal(t, fmt.Sprint(i), val)
+	}
+
+	cache.Set(0, "zero")
+	val, ok := cache.Peek(0)
+	assert.True(t, ok)
+	assert.Equal(t, "zero", val)
+
+	stats := cache.Stats()
+	assert.Equal(t, 32, stats.HitsTotal)
+	assert.Equal(t, 64, stats.MissesTotal)
+	assert.Equal(t, 32, stats.NewItemsTotal)
+}
+
+const benchKeys = 1 << 14
+
+type benchCache interface {
+	GetOrSet(key int, fn func() (string, error)) (string, bool)
+}
+
+func benchmarkParallel(b *testing.B, cache benchCache) {
+	var seq atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(seq.Add(1)) * 7919
+		for pb.Next() {
+			key := i % benchKeys
+			cache.GetOrSet(key, func() (string, error) {
+				return "query", nil
+			})
+			i++
+		}
+	})
+}
+
+func BenchmarkLRUCacheParallel(b *testing.B) {
+	benchmarkParallel(b, New[int, string](benchKeys/2))
+}
+
+func BenchmarkShardedLRUCacheParallel(b *testing.B) {
+	for _, shards := range []int{4, 16, 64} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			benchmarkParallel(b, NewSharded[int, string](shards, benchKeys/2))
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package lrucache
+
+import "sync/atomic"
+
+type LRUCacheStats struct {
+	HitsTotal, MissesTotal int
+	EvictionsTotal         int
+	ExpirationsTotal       int
+	MoveToFrontTotal       int
+	NewItemsTotal          int
+}
+
+// counters holds a cache's statistics. They are updated atomically since
+// not every update happens under the cache lock, which makes Stats() safe to
+// call from reporters at any time.
+type counters struct {
+	hits        atomic.Int64
+	misses      atomic.Int64
+	evictions   atomic.Int64
+	expirations atomic.Int64
+	moveToFront atomic.Int64
+	newItems    atomic.Int64
+}
+
+func (c *counters) snapshot() LRUCacheStats {
+	return LRUCacheStats{
+		HitsTotal:        int(c.hits.Load()),
+		MissesTotal:      int(c.misses.Load()),
+		EvictionsTotal:   int(c.evictions.Load()),
+		ExpirationsTotal: int(c.expirations.Load()),
+		MoveToFrontTotal: int(c.moveToFront.Load()),
+		NewItemsTotal:    int(c.newItems.Load()),
+	}
+}
@@ -38,6 +38,13 @@ var (
 		},
 	)
 
+	QuerySourceDegraded = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mysql_load_test_query_source_degraded",
+			Help: "1 while the metadata database is unavailable and picks are served from the cached fingerprints",
+		},
+	)
+
 	FetchWeightsLatency = promauto.NewHistogram(
 		prometheus.HistogramOpts{
 			Name:    "mysql_load_test_query_source_fetch_weights_latency_seconds",
@@ -56,6 +63,111 @@ var (
 		[]string{"type"}, // type can be "explain" or "execute"
 	)
 
+	QueryLatencyBreakdown = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mysql_load_test_query_latency_breakdown_seconds",
+			Help:    "Query latency by component: conn_wait, execute and drain",
+			Buckets: prometheus.ExponentialBuckets(0.00005, 2, 18),
+		},
+		[]string{"component"},
+	)
+
+	ReadYourWritesOutcomes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mysql_load_test_read_your_writes_total",
+			Help: "Total number of read your writes checks by outcome",
+		},
+		[]string{"outcome"},
+	)
+
+	ReadYourWritesLag = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "mysql_load_test_read_your_writes_lag_seconds",
+			Help:    "Time from a write returning until the row was read back",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 2, 16),
+		},
+	)
+
+	StatementExecutionLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mysql_load_test_statement_execution_latency_seconds",
+			Help:    "Latency of successful query executions in seconds by statement type",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"statement"}, // select, insert, update, delete or other
+	)
+
+	StatementExecutionErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mysql_load_test_statement_execution_errors_total",
+			Help: "Total number of failed query executions by statement type",
+		},
+		[]string{"statement"},
+	)
+
+	QueryResultsTruncated = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "mysql_load_test_query_results_truncated_total",
+			Help: "Total number of results cut short by the result size limits",
+		},
+	)
+
+	EndpointConnections = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mysql_load_test_endpoint_connections",
+			Help: "Open load connections per resolved target address",
+		},
+		[]string{"addr"},
+	)
+
+	EndpointExecutionLatency = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "mysql_load_test_endpoint_execution_latency_seconds",
+			Help:    "Latency of successful query executions in seconds per resolved target address",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"addr"},
+	)
+
+	EndpointExecutionErrors = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mysql_load_test_endpoint_execution_errors_total",
+			Help: "Total number of failed query executions per resolved target address",
+		},
+		[]string{"addr"},
+	)
+
+	AggregateQPS = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "mysql_load_test_aggregate_qps",
+			Help: "Completed queries per second of the latest report aggregate",
+		},
+	)
+
+	AggregateLatency = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mysql_load_test_aggregate_latency_seconds",
+			Help: "Query latency quantiles of the latest report aggregate",
+		},
+		[]string{"quantile"},
+	)
+
+	MaintenanceRecovery = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "mysql_load_test_maintenance_recovery_seconds",
+			Help: "Time the p99 latency took to return to its baseline after a maintenance window",
+		},
+		[]string{"window"},
+	)
+
+	QueryWarnings = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "mysql_load_test_query_warnings_total",
+			Help: "Total number of warnings reported by SHOW WARNINGS on sampled executions",
+		},
+		[]string{"level", "code"},
+	)
+
 	QueryExecutionErrors = promauto.NewCounterVec(
 		prometheus.CounterOpts{
 			Name: "mysql_load_test_query_execution_errors_total",
@@ -0,0 +1,152 @@
+// Package resultsdb holds the schema of the SQLite database a load test run
+// writes its results to, and the canned queries used to read it back, as well
+// as the trends database accumulating a summary row of every run.
+package resultsdb
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Schema creates the tables of a run database. Latencies are in microseconds
+// and times are fixed width UTC RFC 3339 strings (TimeFormat) so they compare
+// and join as text.
+const Schema = `
+CREATE TABLE IF NOT EXISTS run (
+	started_at TEXT NOT NULL,
+	metadata   TEXT
+);
+
+CREATE TABLE IF NOT EXISTS aggregates (
+	window_start       TEXT NOT NULL,
+	window_end         TEXT NOT NULL,
+	statement_type     TEXT NOT NULL DEFAULT '',
+	qps                REAL NOT NULL,
+	offered_qps        REAL,
+	dispatch_qps       REAL,
+	worker_utilization REAL,
+	generator_bound    INTEGER,
+	avg_us             REAL NOT NULL,
+	fastest_us         REAL NOT NULL,
+	slowest_us         REAL NOT NULL,
+	p50_us             REAL NOT NULL,
+	p95_us             REAL NOT NULL,
+	p99_us             REAL NOT NULL,
+	corrected_p50_us   REAL,
+	corrected_p95_us   REAL,
+	corrected_p99_us   REAL,
+	conn_wait_avg_us   REAL,
+	conn_wait_max_us   REAL,
+	execute_avg_us     REAL,
+	drain_avg_us       REAL,
+	num_res            INTEGER NOT NULL,
+	PRIMARY KEY (window_end, statement_type)
+);
+
+CREATE TABLE IF NOT EXISTS host_aggregates (
+	window_start TEXT NOT NULL,
+	window_end   TEXT NOT NULL,
+	host         TEXT NOT NULL,
+	qps          REAL NOT NULL,
+	avg_us       REAL NOT NULL,
+	p50_us       REAL NOT NULL,
+	p95_us       REAL NOT NULL,
+	p99_us       REAL NOT NULL,
+	errors       INTEGER NOT NULL,
+	num_res      INTEGER NOT NULL,
+	PRIMARY KEY (window_end, host)
+);
+
+CREATE TABLE IF NOT EXISTS fingerprint_stats (
+	fingerprint_hash TEXT PRIMARY KEY,
+	executions       INTEGER NOT NULL,
+	errors           INTEGER NOT NULL,
+	total_us         INTEGER NOT NULL,
+	max_us           INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fingerprint_tags (
+	fingerprint_hash TEXT NOT NULL,
+	tag              TEXT NOT NULL,
+	PRIMARY KEY (fingerprint_hash, tag)
+);
+
+CREATE TABLE IF NOT EXISTS errors (
+	error TEXT PRIMARY KEY,
+	count INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS annotations (
+	time   TEXT NOT NULL,
+	source TEXT NOT NULL,
+	text   TEXT NOT NULL,
+	labels TEXT
+);
+`
+
+// TimeFormat is the format of every time column
+const TimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// Open opens or creates the run database at path
+func Open(path string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening results database: %w", err)
+	}
+	// sqlite serializes writers, a single connection avoids busy errors
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(Schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating results database schema: %w", err)
+	}
+	return db, nil
+}
+
+// Presets are the canned queries of mlt report query
+var Presets = map[string]string{
+	"summary": `SELECT COUNT(*) AS windows, SUM(num_res) AS results,
+	ROUND(AVG(qps), 1) AS avg_qps, ROUND(MAX(qps), 1) AS max_qps,
+	ROUND(AVG(p99_us)) AS avg_p99_us, ROUND(MAX(p99_us)) AS max_p99_us,
+	MIN(window_start) AS first_window, MAX(window_end) AS last_window
+FROM aggregates WHERE statement_type = ''`,
+	"aggregates": `SELECT window_start, window_end, ROUND(qps, 1) AS qps,
+	ROUND(dispatch_qps, 1) AS dispatch_qps, ROUND(p50_us) AS p50_us,
+	ROUND(p95_us) AS p95_us, ROUND(p99_us) AS p99_us,
+	ROUND(corrected_p99_us) AS corrected_p99_us, num_res
+FROM aggregates WHERE statement_type = '' ORDER BY window_end`,
+	"breakdown": `SELECT window_end, ROUND(avg_us) AS avg_us, ROUND(conn_wait_avg_us) AS conn_wait_us,
+	ROUND(conn_wait_max_us) AS conn_wait_max_us, ROUND(execute_avg_us) AS execute_us,
+	ROUND(drain_avg_us) AS drain_us
+FROM aggregates WHERE statement_type = '' ORDER BY window_end`,
+	"statements": `SELECT statement_type, SUM(num_res) AS results,
+	ROUND(AVG(qps), 1) AS avg_qps, ROUND(AVG(p99_us)) AS avg_p99_us
+FROM aggregates WHERE statement_type != '' GROUP BY statement_type ORDER BY results DESC`,
+	"hosts": `SELECT host, SUM(num_res) AS results, SUM(errors) AS errors,
+	ROUND(AVG(qps), 1) AS avg_qps, ROUND(AVG(p50_us)) AS avg_p50_us, ROUND(AVG(p99_us)) AS avg_p99_us
+FROM host_aggregates GROUP BY host ORDER BY host`,
+	"slowest": `SELECT fingerprint_hash, executions, errors,
+	ROUND(CAST(total_us AS REAL) / NULLIF(executions - errors, 0)) AS avg_us, max_us
+FROM fingerprint_stats ORDER BY avg_us DESC LIMIT 20`,
+	"busiest": `SELECT fingerprint_hash, executions, errors, total_us,
+	ROUND(CAST(total_us AS REAL) / NULLIF(executions - errors, 0)) AS avg_us
+FROM fingerprint_stats ORDER BY total_us DESC LIMIT 20`,
+	"tags": `SELECT t.tag, COUNT(*) AS fingerprints, SUM(s.executions) AS executions, SUM(s.errors) AS errors,
+	ROUND(CAST(SUM(s.total_us) AS REAL) / NULLIF(SUM(s.executions - s.errors), 0)) AS avg_us, MAX(s.max_us) AS max_us
+FROM fingerprint_tags t JOIN fingerprint_stats s ON s.fingerprint_hash = t.fingerprint_hash
+GROUP BY t.tag ORDER BY executions DESC`,
+	"errors":      `SELECT error, count FROM errors ORDER BY count DESC`,
+	"annotations": `SELECT time, source, text, labels FROM annotations ORDER BY time`,
+}
+
+// PresetNames returns the preset names sorted
+func PresetNames() []string {
+	names := make([]string, 0, len(Presets))
+	for name := range Presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,73 @@
+package resultsdb
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOpenCreatesSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.db")
+	db, err := Open(path)
+	require.NoError(t, err)
+	defer db.Close()
+
+	_, err = db.Exec(`INSERT INTO aggregates (window_start, window_end, qps, avg_us, fastest_us, slowest_us, p50_us, p95_us, p99_us, num_res)
+		VALUES ('2025-01-01T00:00:00Z', '2025-01-01T00:00:05Z', 100, 10, 1, 50, 8, 30, 45, 500)`)
+	require.NoError(t, err)
+
+	// reopening keeps the data
+	db.Close()
+	db, err = Open(path)
+	require.NoError(t, err)
+	var windows int
+	require.NoError(t, db.QueryRow("SELECT COUNT(*) FROM aggregates").Scan(&windows))
+	assert.Equal(t, 1, windows)
+}
+
+func TestPresets(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.db"))
+	require.NoError(t, err)
+	defer db.Close()
+
+	for _, name := range PresetNames() {
+		rows, err := db.Query(Presets[name])
+		require.NoError(t, err, name)
+		rows.Close()
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	db, err := OpenTrends(filepath.Join(t.TempDir(), "trends.db"))
+	require.NoError(t, err)
+	defer db.Close()
+	ctx := context.Background()
+
+	for i, started := range []string{"2025-01-08T00:00:00.000000Z", "2025-01-01T00:00:00.000000Z", "2025-01-15T00:00:00.000000Z"} {
+		_, err := SaveRunSummary(ctx, db, RunSummary{StartedAt: started, FinishedAt: started, Target: "db1:3306/app", P99: float64(i)})
+		require.NoError(t, err)
+	}
+	id, err := SaveRunSummary(ctx, db, RunSummary{StartedAt: "2025-01-02T00:00:00.000000Z", Target: "db2:3306/app"})
+	require.NoError(t, err)
+	_, err = SaveRunSummary(ctx, db, RunSummary{ID: id, Results: 10})
+	require.NoError(t, err)
+
+	runs, err := RunHistory(ctx, db, "db1:3306/app", 2)
+	require.NoError(t, err)
+	require.Len(t, runs, 2)
+	assert.Equal(t, "2025-01-08T00:00:00.000000Z", runs[0].StartedAt)
+	assert.Equal(t, 2.0, runs[1].P99)
+
+	runs, err = RunHistory(ctx, db, "db2:3306/app", 0)
+	require.NoError(t, err)
+	require.Len(t, runs, 1)
+	assert.Equal(t, int64(10), runs[0].Results)
+
+	targets, err := TrendTargets(ctx, db)
+	require.NoError(t, err)
+	require.Len(t, targets, 2)
+	assert.Equal(t, int64(3), targets[0].Runs)
+}
@@ -0,0 +1,192 @@
+package resultsdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+// TrendsMySQLPrefix marks a trends database DSN as MySQL, anything else is
+// the path of a SQLite database
+const TrendsMySQLPrefix = "mysql://"
+
+// trendsSchema creates the run_summary table of a trends database, one
+// statement at a time as the MySQL driver runs a single one per Exec
+var trendsSchema = map[string][]string{
+	"sqlite3": {
+		`CREATE TABLE IF NOT EXISTS run_summary (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	started_at  TEXT NOT NULL,
+	finished_at TEXT NOT NULL,
+	target      TEXT NOT NULL,
+	corpus      TEXT NOT NULL,
+	corpus_sha  TEXT NOT NULL,
+	config_hash TEXT NOT NULL,
+	results     INTEGER NOT NULL,
+	errors      INTEGER NOT NULL,
+	error_rate  REAL NOT NULL,
+	qps         REAL NOT NULL,
+	p50_us      REAL NOT NULL,
+	p95_us      REAL NOT NULL,
+	p99_us      REAL NOT NULL
+)`,
+		`CREATE INDEX IF NOT EXISTS run_summary_target ON run_summary (target, started_at)`,
+	},
+	"mysql": {
+		`CREATE TABLE IF NOT EXISTS run_summary (
+	id          BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+	started_at  VARCHAR(32) NOT NULL,
+	finished_at VARCHAR(32) NOT NULL,
+	target      VARCHAR(255) NOT NULL,
+	corpus      VARCHAR(255) NOT NULL,
+	corpus_sha  VARCHAR(64) NOT NULL,
+	config_hash VARCHAR(64) NOT NULL,
+	results     BIGINT NOT NULL,
+	errors      BIGINT NOT NULL,
+	error_rate  DOUBLE NOT NULL,
+	qps         DOUBLE NOT NULL,
+	p50_us      DOUBLE NOT NULL,
+	p95_us      DOUBLE NOT NULL,
+	p99_us      DOUBLE NOT NULL,
+	INDEX run_summary_target (target, started_at)
+)`,
+	},
+}
+
+// RunSummary is the row a run leaves in the trends database. Times are in
+// TimeFormat, latencies in microseconds.
+type RunSummary struct {
+	ID         int64   `json:"id"`
+	StartedAt  string  `json:"started_at"`
+	FinishedAt string  `json:"finished_at"`
+	Target     string  `json:"target"`
+	Corpus     string  `json:"corpus"`
+	CorpusSHA  string  `json:"corpus_sha"`
+	ConfigHash string  `json:"config_hash"`
+	Results    int64   `json:"results"`
+	Errors     int64   `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	QPS        float64 `json:"qps"`
+	P50        float64 `json:"p50_us"`
+	P95        float64 `json:"p95_us"`
+	P99        float64 `json:"p99_us"`
+}
+
+// OpenTrends opens or creates the trends database, a MySQL database when dsn
+// starts with TrendsMySQLPrefix and a SQLite file otherwise
+func OpenTrends(dsn string) (*sql.DB, error) {
+	driver, source := "sqlite3", dsn
+	if rest, ok := strings.CutPrefix(dsn, TrendsMySQLPrefix); ok {
+		driver, source = "mysql", rest
+	}
+	db, err := sql.Open(driver, source)
+	if err != nil {
+		return nil, fmt.Errorf("error opening trends database: %w", err)
+	}
+	if driver == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
+	for _, stmt := range trendsSchema[driver] {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("error creating trends database schema: %w", err)
+		}
+	}
+	return db, nil
+}
+
+// SaveRunSummary inserts s, or updates its row when s.ID is set, and returns
+// the ID of the row
+func SaveRunSummary(ctx context.Context, db *sql.DB, s RunSummary) (int64, error) {
+	if s.ID != 0 {
+		_, err := db.ExecContext(ctx, `UPDATE run_summary SET finished_at = ?, results = ?, errors = ?,
+	error_rate = ?, qps = ?, p50_us = ?, p95_us = ?, p99_us = ? WHERE id = ?`,
+			s.FinishedAt, s.Results, s.Errors, s.ErrorRate, s.QPS, s.P50, s.P95, s.P99, s.ID)
+		if err != nil {
+			return 0, fmt.Errorf("error updating run summary: %w", err)
+		}
+		return s.ID, nil
+	}
+	res, err := db.ExecContext(ctx, `INSERT INTO run_summary (started_at, finished_at, target, corpus, corpus_sha,
+	config_hash, results, errors, error_rate, qps, p50_us, p95_us, p99_us)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.StartedAt, s.FinishedAt, s.Target, s.Corpus, s.CorpusSHA, s.ConfigHash,
+		s.Results, s.Errors, s.ErrorRate, s.QPS, s.P50, s.P95, s.P99)
+	if err != nil {
+		return 0, fmt.Errorf("error inserting run summary: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error reading run summary id: %w", err)
+	}
+	return id, nil
+}
+
+// RunHistory returns the last limit runs against target, oldest first. A
+// limit of 0 returns every run.
+func RunHistory(ctx context.Context, db *sql.DB, target string, limit int) ([]RunSummary, error) {
+	query := `SELECT id, started_at, finished_at, target, corpus, corpus_sha, config_hash,
+	results, errors, error_rate, qps, p50_us, p95_us, p99_us
+FROM run_summary WHERE target = ? ORDER BY started_at DESC, id DESC`
+	args := []any{target}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying run history: %w", err)
+	}
+	defer rows.Close()
+
+	var runs []RunSummary
+	for rows.Next() {
+		var s RunSummary
+		if err := rows.Scan(&s.ID, &s.StartedAt, &s.FinishedAt, &s.Target, &s.Corpus, &s.CorpusSHA, &s.ConfigHash,
+			&s.Results, &s.Errors, &s.ErrorRate, &s.QPS, &s.P50, &s.P95, &s.P99); err != nil {
+			return nil, fmt.Errorf("error scanning run summary: %w", err)
+		}
+		runs = append(runs, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading run history: %w", err)
+	}
+	for i, j := 0, len(runs)-1; i < j; i, j = i+1, j-1 {
+		runs[i], runs[j] = runs[j], runs[i]
+	}
+	return runs, nil
+}
+
+// TrendTarget is a target of the trends database with its number of runs
+type TrendTarget struct {
+	Target   string `json:"target"`
+	Runs     int64  `json:"runs"`
+	LastRun  string `json:"last_run"`
+	FirstRun string `json:"first_run"`
+}
+
+// TrendTargets lists the targets of the trends database
+func TrendTargets(ctx context.Context, db *sql.DB) ([]TrendTarget, error) {
+	rows, err := db.QueryContext(ctx, `SELECT target, COUNT(*), MIN(started_at), MAX(started_at)
+FROM run_summary GROUP BY target ORDER BY target`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying trend targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []TrendTarget
+	for rows.Next() {
+		var t TrendTarget
+		if err := rows.Scan(&t.Target, &t.Runs, &t.FirstRun, &t.LastRun); err != nil {
+			return nil, fmt.Errorf("error scanning trend target: %w", err)
+		}
+		targets = append(targets, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error reading trend targets: %w", err)
+	}
+	return targets, nil
+}
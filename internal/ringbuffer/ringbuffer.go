@@ -10,6 +10,9 @@ type RingBuffer[T any] struct {
 	count int
 	data  []T
 	mu    sync.Mutex
+
+	snapMu  sync.Mutex
+	scratch []T
 }
 
 func NewRingBuffer[T any](size int) *RingBuffer[T] {
@@ -40,12 +43,30 @@ func (r *RingBuffer[T]) GetAll(dst []T) []T {
 	off := r.off
 	for c > 0 {
 		c--
-		dst = append(dst, r.data[off])
 		off = (off - 1 + r.size) % r.size
+		dst = append(dst, r.data[off])
 	}
 	return dst
 }
 
+// Snapshot summarizes the buffered values, ordered by compare, at the given
+// percentiles (0-100). Sorting happens on an internal scratch copy so
+// callers don't have to copy and sort the buffer themselves.
+func (r *RingBuffer[T]) Snapshot(compare func(a, b T) int, percentiles ...float64) Snapshot[T] {
+	r.snapMu.Lock()
+	defer r.snapMu.Unlock()
+
+	r.mu.Lock()
+	if r.count < r.size {
+		r.scratch = append(r.scratch[:0], r.data[:r.count]...)
+	} else {
+		r.scratch = append(r.scratch[:0], r.data...)
+	}
+	r.mu.Unlock()
+
+	return newSnapshot(r.scratch, compare, percentiles)
+}
+
 func (r *RingBuffer[T]) Count() int {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -0,0 +1,70 @@
+package ringbuffer
+
+import (
+	"cmp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingBufferGetAll(t *testing.T) {
+	r := NewRingBuffer[int](3)
+	assert.Nil(t, r.GetAll(nil))
+
+	r.Append(1)
+	r.Append(2)
+	assert.Equal(t, []int{2, 1}, r.GetAll(nil))
+
+	r.Append(3)
+	r.Append(4)
+	assert.Equal(t, []int{4, 3, 2}, r.GetAll(nil))
+	assert.Equal(t, 3, r.Count())
+}
+
+func TestRingBufferSnapshot(t *testing.T) {
+	r := NewRingBuffer[int](100)
+	assert.Equal(t, 0, r.Snapshot(cmp.Compare[int], 50).Count)
+
+	for i := 200; i > 0; i-- {
+		r.Append(i)
+	}
+
+	snap := r.Snapshot(cmp.Compare[int], 50, 95, 99)
+	assert.Equal(t, 100, snap.Count)
+	assert.Equal(t, 1, snap.Min)
+	assert.Equal(t, 100, snap.Max)
+	assert.Equal(t, []int{50, 95, 99}, snap.Percentiles)
+}
+
+func TestSingleProducer(t *testing.T) {
+	r := NewSingleProducer[time.Duration](4)
+	for i := 1; i <= 6; i++ {
+		r.Append(time.Duration(i))
+	}
+
+	assert.Equal(t, 4, r.Count())
+	assert.Equal(t, []time.Duration{6, 5, 4, 3}, r.GetAll(nil))
+
+	snap := r.Snapshot(50)
+	assert.Equal(t, time.Duration(3), snap.Min)
+	assert.Equal(t, time.Duration(6), snap.Max)
+	assert.Equal(t, []time.Duration{4}, snap.Percentiles)
+}
+
+func TestSnapshotAll(t *testing.T) {
+	assert.Equal(t, 0, SnapshotAll[time.Duration](nil, 50).Count)
+
+	a := NewSingleProducer[time.Duration](2)
+	b := NewSingleProducer[time.Duration](3)
+	for i := 1; i <= 3; i++ {
+		a.Append(time.Duration(i))
+		b.Append(time.Duration(10 * i))
+	}
+
+	snap := SnapshotAll([]*SingleProducer[time.Duration]{a, b}, 50, 100)
+	assert.Equal(t, 5, snap.Count)
+	assert.Equal(t, time.Duration(2), snap.Min)
+	assert.Equal(t, time.Duration(30), snap.Max)
+	assert.Equal(t, []time.Duration{10, 30}, snap.Percentiles)
+}
@@ -0,0 +1,69 @@
+package ringbuffer
+
+import (
+	"cmp"
+	"sync"
+	"sync/atomic"
+)
+
+// SingleProducer is a lock-free ring buffer of int64-like values for hot
+// paths with exactly one writer. Readers never block the writer; a value
+// overwritten while a reader is copying the buffer may be read as either its
+// old or its new value.
+type SingleProducer[T ~int64] struct {
+	data    []atomic.Int64
+	written atomic.Uint64
+
+	snapMu  sync.Mutex
+	scratch []T
+}
+
+func NewSingleProducer[T ~int64](size int) *SingleProducer[T] {
+	return &SingleProducer[T]{
+		data: make([]atomic.Int64, size),
+	}
+}
+
+// Append adds a value. It must not be called concurrently.
+func (r *SingleProducer[T]) Append(d T) {
+	n := r.written.Load()
+	r.data[n%uint64(len(r.data))].Store(int64(d))
+	r.written.Store(n + 1)
+}
+
+func (r *SingleProducer[T]) Count() int {
+	return int(min(r.written.Load(), uint64(len(r.data))))
+}
+
+// GetAll appends the buffered values to dst, newest first
+func (r *SingleProducer[T]) GetAll(dst []T) []T {
+	n := r.written.Load()
+	size := uint64(len(r.data))
+	for i := uint64(0); i < min(n, size); i++ {
+		dst = append(dst, T(r.data[(n-1-i)%size].Load()))
+	}
+	return dst
+}
+
+// Snapshot summarizes the buffered values, see RingBuffer.Snapshot
+func (r *SingleProducer[T]) Snapshot(percentiles ...float64) Snapshot[T] {
+	r.snapMu.Lock()
+	defer r.snapMu.Unlock()
+
+	r.scratch = r.GetAll(r.scratch[:0])
+	return newSnapshot(r.scratch, cmp.Compare[T], percentiles)
+}
+
+// SnapshotAll summarizes the values of several buffers together, e.g. one
+// buffer per worker
+func SnapshotAll[T ~int64](buffers []*SingleProducer[T], percentiles ...float64) Snapshot[T] {
+	n := 0
+	for _, r := range buffers {
+		n += r.Count()
+	}
+	values := make([]T, 0, n)
+	for _, r := range buffers {
+		values = r.GetAll(values)
+	}
+	return newSnapshot(values, cmp.Compare[T], percentiles)
+}
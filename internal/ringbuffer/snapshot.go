@@ -0,0 +1,35 @@
+package ringbuffer
+
+import (
+	"math"
+	"slices"
+)
+
+// Snapshot summarizes the values of a ring buffer at one point in time
+type Snapshot[T any] struct {
+	Count    int
+	Min, Max T
+	// Percentiles holds one value per requested percentile, in order
+	Percentiles []T
+}
+
+// newSnapshot sorts values in place and summarizes them
+func newSnapshot[T any](values []T, compare func(a, b T) int, percentiles []float64) Snapshot[T] {
+	snap := Snapshot[T]{
+		Count:       len(values),
+		Percentiles: make([]T, len(percentiles)),
+	}
+	if len(values) == 0 {
+		return snap
+	}
+
+	slices.SortFunc(values, compare)
+	snap.Min = values[0]
+	snap.Max = values[len(values)-1]
+	for i, p := range percentiles {
+		idx := int(math.Ceil(p/100*float64(len(values)))) - 1
+		idx = max(0, min(idx, len(values)-1))
+		snap.Percentiles[i] = values[idx]
+	}
+	return snap
+}
@@ -0,0 +1,165 @@
+// Package filemap gives random access to large line-oriented corpus files
+// through a read-only memory mapping and a persisted index of line offsets.
+// The slices returned by Bytes, Segment, Line and PickRandom are the mapping
+// itself: they must not be kept or used after Close, copy them to keep them.
+package filemap
+
+import (
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sync"
+	"syscall"
+)
+
+// File is a read-locked, memory-mapped file
+type File struct {
+	path string
+	file *os.File
+	info os.FileInfo
+	data []byte
+
+	indexOnce sync.Once
+	index     *Index
+	indexErr  error
+}
+
+var _ io.ReaderAt = (*File)(nil)
+
+// Open memory-maps path for reading. A shared lock is held on the file
+// until Close so writers using locks can't change it underneath readers.
+func Open(path string) (*File, error) {
+	file, err := openFileWithReadLock(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	var data []byte
+	if info.Size() > 0 {
+		data, err = syscall.Mmap(int(file.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to mmap file: %v", err)
+		}
+	}
+
+	return &File{path: path, file: file, info: info, data: data}, nil
+}
+
+// Close unmaps the file and releases its lock. Slices returned by the File
+// must not be used afterwards.
+func (f *File) Close() error {
+	if f.data != nil {
+		if err := syscall.Munmap(f.data); err != nil {
+			return fmt.Errorf("failed to unmap file: %v", err)
+		}
+		f.data = nil
+	}
+	return f.file.Close()
+}
+
+// Len returns the size of the file
+func (f *File) Len() int64 {
+	return int64(len(f.data))
+}
+
+// Bytes returns the whole mapped file
+func (f *File) Bytes() []byte {
+	return f.data
+}
+
+// Segment returns the mapped bytes from offset to offset+length without
+// copying them, they are only valid until Close
+func (f *File) Segment(offset, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 || offset+length > int64(len(f.data)) {
+		return nil, fmt.Errorf("segment at offset %d with length %d is out of bounds of file with size %d", offset, length, len(f.data))
+	}
+	return f.data[offset : offset+length], nil
+}
+
+// ReadAt implements io.ReaderAt
+func (f *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Index returns the line index of the file, loading it from its sidecar
+// file or building it on first use
+func (f *File) Index() (*Index, error) {
+	f.indexOnce.Do(func() {
+		f.index, f.indexErr = loadOrBuildIndex(f)
+	})
+	return f.index, f.indexErr
+}
+
+// Lines returns the number of indexed lines
+func (f *File) Lines() (int, error) {
+	idx, err := f.Index()
+	if err != nil {
+		return 0, err
+	}
+	return idx.Len(), nil
+}
+
+// Line returns line i without its trailing newline
+func (f *File) Line(i int) ([]byte, error) {
+	idx, err := f.Index()
+	if err != nil {
+		return nil, err
+	}
+	if i < 0 || i >= idx.Len() {
+		return nil, fmt.Errorf("line %d out of range [0, %d)", i, idx.Len())
+	}
+	start, end := idx.Bounds(i)
+	return f.Segment(start, end-start)
+}
+
+// PickRandom returns a uniformly random line
+func (f *File) PickRandom() ([]byte, error) {
+	n, err := f.Lines()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("file %s has no lines", f.path)
+	}
+	return f.Line(rand.IntN(n))
+}
+
+func openFileWithReadLock(filename string) (*os.File, error) {
+	file, err := os.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening file: %v", err)
+	}
+
+	lock := syscall.Flock_t{
+		Type:   syscall.F_RDLCK,
+		Whence: 0,
+		Start:  0,
+		Len:    0,
+	}
+
+	lockErr := syscall.FcntlFlock(file.Fd(), syscall.F_SETLK, &lock)
+	if lockErr != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to acquire read lock: %v", lockErr)
+	}
+
+	return file, nil
+}
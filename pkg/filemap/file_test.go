@@ -0,0 +1,236 @@
+package filemap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuildIndex(t *testing.T) {
+	testCases := []struct {
+		name     string
+		content  string
+		expected []int64
+	}{
+		{
+			name:     "Empty file",
+			content:  "",
+			expected: []int64{},
+		},
+		{
+			name:     "Single line",
+			content:  "hello world",
+			expected: []int64{},
+		},
+		{
+			name:     "Single line with newline",
+			content:  "hello world\n",
+			expected: []int64{11},
+		},
+		{
+			name:     "Multiple lines",
+			content:  "line1\nline2\nline3\n",
+			expected: []int64{5, 11, 17},
+		},
+		{
+			name:     "Multiple lines without final newline",
+			content:  "line1\nline2\nline3",
+			expected: []int64{5, 11},
+		},
+		{
+			name:     "Empty lines",
+			content:  "\n\nline3\n",
+			expected: []int64{0, 1, 7},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			idx := BuildIndex([]byte(tc.content))
+
+			if idx.Len() != len(tc.expected) {
+				t.Fatalf("Expected %d positions, got %d: %v", len(tc.expected), idx.Len(), idx.positions)
+			}
+			for i, pos := range tc.expected {
+				if idx.positions[i] != pos {
+					t.Errorf("Position %d: expected %d, got %d", i, pos, idx.positions[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSegmentAndReadAt(t *testing.T) {
+	content := "line1\nline2\nline3\n"
+	f := openTempFile(t, content)
+
+	testCases := []struct {
+		name         string
+		offset       int64
+		length       int64
+		expectedData string
+		expectError  bool
+	}{
+		{name: "Read first line", offset: 0, length: 6, expectedData: "line1\n"},
+		{name: "Read second line", offset: 6, length: 6, expectedData: "line2\n"},
+		{name: "Read partial", offset: 3, length: 4, expectedData: "e1\nl"},
+		{name: "Read beyond file", offset: int64(len(content)) + 10, length: 5, expectError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			seg, err := f.Segment(tc.offset, tc.length)
+			if tc.expectError {
+				if err == nil {
+					t.Fatal("Expected an error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(seg) != tc.expectedData {
+				t.Errorf("Expected data '%s', got '%s'", tc.expectedData, seg)
+			}
+
+			buf := make([]byte, tc.length)
+			n, err := f.ReadAt(buf, tc.offset)
+			if err != nil && err != io.EOF {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if string(buf[:n]) != tc.expectedData {
+				t.Errorf("Expected data '%s', got '%s'", tc.expectedData, buf[:n])
+			}
+		})
+	}
+}
+
+func TestPickRandom(t *testing.T) {
+	f := openTempFile(t, "line1\nline2\nline3\nline4\n")
+
+	n, err := f.Lines()
+	if err != nil {
+		t.Fatalf("Failed to index file: %v", err)
+	}
+	if n != 4 {
+		t.Fatalf("Expected 4 lines, got %d", n)
+	}
+
+	validLines := map[string]bool{"line1": true, "line2": true, "line3": true, "line4": true}
+	for i := 0; i < 10; i++ { // Run multiple times to increase chance of covering different random selections
+		line, err := f.PickRandom()
+		if err != nil {
+			t.Fatalf("PickRandom failed: %v", err)
+		}
+		if !validLines[string(line)] {
+			t.Errorf("Picked unexpected line: '%s'", line)
+		}
+	}
+}
+
+func TestIndexSidecar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(path, []byte("line1\nline2\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	f, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := f.Index(); err != nil {
+		t.Fatalf("Index failed: %v", err)
+	}
+	f.Close()
+	if _, err := os.Stat(path + IndexSuffix); err != nil {
+		t.Fatalf("Expected index sidecar to be written: %v", err)
+	}
+
+	// A changed file invalidates the sidecar
+	if err := os.WriteFile(path, []byte("line1\nline2\nline3\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(path, future, future)
+
+	f, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+	line, err := f.Line(2)
+	if err != nil {
+		t.Fatalf("Line failed: %v", err)
+	}
+	if string(line) != "line3" {
+		t.Errorf("Expected 'line3', got '%s'", line)
+	}
+}
+
+func TestCorruptIndexSidecar(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus.txt")
+	if err := os.WriteFile(path, []byte("line1\nline2\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	// a sidecar matching the file but with a corrupt count is rebuilt
+	for _, count := range []int64{-1, 1 << 40, 1} {
+		var buf bytes.Buffer
+		header := indexHeader{Magic: indexMagic, Size: info.Size(), ModTime: info.ModTime().UnixNano(), Count: count}
+		binary.Write(&buf, binary.LittleEndian, header)
+		binary.Write(&buf, binary.LittleEndian, []int64{5, 11})
+		if err := os.WriteFile(path+IndexSuffix, buf.Bytes(), 0o644); err != nil {
+			t.Fatalf("Failed to write sidecar: %v", err)
+		}
+
+		f, err := Open(path)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		n, err := f.Lines()
+		f.Close()
+		if err != nil {
+			t.Fatalf("Lines failed with count %d: %v", count, err)
+		}
+		if n != 2 {
+			t.Errorf("Expected 2 lines with count %d, got %d", count, n)
+		}
+	}
+}
+
+func TestOpenFileWithReadLock(t *testing.T) {
+	f := openTempFile(t, "test content")
+
+	if string(f.Bytes()) != "test content" {
+		t.Errorf("Expected 'test content', got '%s'", f.Bytes())
+	}
+
+	// Test with non-existent file
+	if _, err := Open("/non/existent/file"); err == nil {
+		t.Error("Expected error for non-existent file, got nil")
+	}
+}
+
+// Helper function to open a temporary file with the given content
+func openTempFile(t *testing.T, content string) *File {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "nlm_test.txt")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	f, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
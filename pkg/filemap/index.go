@@ -0,0 +1,152 @@
+package filemap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// IndexSuffix is appended to a corpus path to name its index sidecar file
+const IndexSuffix = ".nlidx"
+
+var indexMagic = [8]byte{'N', 'L', 'I', 'D', 'X', 0, 0, 1}
+
+var errStaleIndex = errors.New("stale index")
+
+// Index holds the offsets of the newlines of a file. A trailing line without
+// a newline is not indexed.
+type Index struct {
+	positions []int64
+}
+
+// BuildIndex scans data for newlines
+func BuildIndex(data []byte) *Index {
+	idx := &Index{positions: make([]int64, 0)}
+	off := 0
+	for {
+		i := bytes.IndexByte(data[off:], '\n')
+		if i < 0 {
+			return idx
+		}
+		off += i
+		idx.positions = append(idx.positions, int64(off))
+		off++
+	}
+}
+
+// Len returns the number of lines
+func (idx *Index) Len() int {
+	return len(idx.positions)
+}
+
+// Bounds returns the offsets of the first byte of line i and of its newline
+func (idx *Index) Bounds(i int) (start, end int64) {
+	if i > 0 {
+		start = idx.positions[i-1] + 1
+	}
+	return start, idx.positions[i]
+}
+
+// sidecar header: magic, size and modification time of the indexed file,
+// number of positions
+type indexHeader struct {
+	Magic   [8]byte
+	Size    int64
+	ModTime int64
+	Count   int64
+}
+
+func loadOrBuildIndex(f *File) (*Index, error) {
+	sidecar := f.path + IndexSuffix
+	header := indexHeader{
+		Magic:   indexMagic,
+		Size:    f.info.Size(),
+		ModTime: f.info.ModTime().UnixNano(),
+	}
+
+	idx, err := readIndex(sidecar, header)
+	if err == nil {
+		return idx, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) && !errors.Is(err, errStaleIndex) {
+		return nil, fmt.Errorf("failed to read index %s: %v", sidecar, err)
+	}
+
+	idx = BuildIndex(f.data)
+	// The index can always be rebuilt, so failing to persist it (e.g. in a
+	// read-only directory) only costs time on the next open
+	_ = writeIndex(sidecar, header, idx)
+	return idx, nil
+}
+
+func readIndex(path string, want indexHeader) (*Index, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var header indexHeader
+	if err := binary.Read(r, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", errStaleIndex, err)
+	}
+	if header.Magic != want.Magic || header.Size != want.Size || header.ModTime != want.ModTime {
+		return nil, errStaleIndex
+	}
+	// a corrupt count can't size the positions, there is at most a newline
+	// per byte and the sidecar holds exactly the positions
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if header.Count < 0 || header.Count > header.Size || info.Size() != int64(binary.Size(header))+header.Count*8 {
+		return nil, fmt.Errorf("%w: %d positions in a sidecar of %d bytes", errStaleIndex, header.Count, info.Size())
+	}
+
+	positions := make([]int64, header.Count)
+	if err := binary.Read(r, binary.LittleEndian, positions); err != nil {
+		return nil, fmt.Errorf("%w: %v", errStaleIndex, err)
+	}
+	prev := int64(-1)
+	for _, pos := range positions {
+		if pos <= prev || pos >= header.Size {
+			return nil, fmt.Errorf("%w: position %d out of order", errStaleIndex, pos)
+		}
+		prev = pos
+	}
+	return &Index{positions: positions}, nil
+}
+
+func writeIndex(path string, header indexHeader, idx *Index) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	header.Count = int64(len(idx.positions))
+	w := bufio.NewWriter(tmp)
+	if err := writeAll(w, header, idx.positions); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeAll(w *bufio.Writer, header indexHeader, positions []int64) error {
+	if err := binary.Write(w, binary.LittleEndian, header); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.LittleEndian, positions); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,117 @@
+// Package fingerprint normalizes and hashes queries the same way the
+// collector does, and defines the batch API spoken by fingerprint servers.
+package fingerprint
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bagaswh/mysql-toolkit/pkg/lexer"
+	"github.com/bagaswh/mysql-toolkit/pkg/normalizer"
+	"github.com/cespare/xxhash"
+)
+
+// BatchPath is the HTTP path of the batch fingerprinting endpoint.
+const BatchPath = "/v1/fingerprint"
+
+type BatchRequest struct {
+	Queries []string `json:"queries"`
+}
+
+type Result struct {
+	Query           string `json:"query"`
+	Hash            uint64 `json:"hash"`
+	Fingerprint     string `json:"fingerprint"`
+	FingerprintHash uint64 `json:"fingerprint_hash"`
+	Error           string `json:"error,omitempty"`
+}
+
+type BatchResponse struct {
+	Results []Result `json:"results"`
+}
+
+var (
+	RawConfig = normalizer.Config{
+		KeywordCase:    normalizer.CaseLower,
+		RemoveLiterals: false,
+	}
+	FingerprintConfig = normalizer.Config{
+		KeywordCase:    normalizer.CaseLower,
+		RemoveLiterals: true,
+	}
+)
+
+// Hash is the hash used for both normalized queries and fingerprints.
+func Hash(data []byte) uint64 {
+	return xxhash.Sum64(data)
+}
+
+type Fingerprinter struct {
+	lexerPool  sync.Pool
+	bufferPool sync.Pool
+}
+
+func NewFingerprinter() *Fingerprinter {
+	return &Fingerprinter{
+		lexerPool: sync.Pool{
+			New: func() interface{} {
+				return lexer.NewLexer()
+			},
+		},
+		bufferPool: sync.Pool{
+			New: func() interface{} {
+				b := []byte{}
+				return &b
+			},
+		},
+	}
+}
+
+// Fingerprint normalizes q keeping its literals, then normalizes the result
+// again without literals, and hashes both.
+func (f *Fingerprinter) Fingerprint(q []byte) (Result, error) {
+	lex := f.lexerPool.Get().(*lexer.Lexer)
+	defer f.lexerPool.Put(lex)
+	bufPtr := f.bufferPool.Get().(*[]byte)
+	defer f.bufferPool.Put(bufPtr)
+
+	raw, err := normalize(RawConfig, lex, q, bufPtr)
+	if err != nil {
+		return Result{}, fmt.Errorf("error normalizing query: %w", err)
+	}
+	fingerprint, err := normalize(FingerprintConfig, lex, raw, bufPtr)
+	if err != nil {
+		return Result{}, fmt.Errorf("error normalizing fingerprint: %w", err)
+	}
+
+	return Result{
+		Query:           string(raw),
+		Hash:            Hash(raw),
+		Fingerprint:     string(fingerprint),
+		FingerprintHash: Hash(fingerprint),
+	}, nil
+}
+
+func normalize(config normalizer.Config, lex *lexer.Lexer, q []byte, bufPtr *[]byte) ([]byte, error) {
+	buf := *bufPtr
+	if cap(buf) < len(q)*2 {
+		buf = make([]byte, len(q)*2+1024)
+	}
+	buf = buf[:cap(buf)]
+
+	for {
+		n, _, err := normalizer.Normalize(config, lex, q, buf)
+		if err == normalizer.ErrBufferTooSmall {
+			buf = make([]byte, cap(buf)*2)
+			continue
+		} else if err != nil {
+			*bufPtr = buf
+			return nil, err
+		}
+
+		result := make([]byte, n)
+		copy(result, buf[:n])
+		*bufPtr = buf
+		return result, nil
+	}
+}
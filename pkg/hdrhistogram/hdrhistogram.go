@@ -0,0 +1,122 @@
+package hdrhistogram
+
+import (
+	"math"
+	"math/bits"
+)
+
+// subBucketHalfCountMagnitude gives 2048 sub-buckets per power of two, enough
+// to tell values apart to 3 significant digits
+const (
+	subBucketHalfCountMagnitude = 10
+	subBucketHalfCount          = 1 << subBucketHalfCountMagnitude
+	subBucketMask               = 2*subBucketHalfCount - 1
+)
+
+// Histogram counts non-negative integer values in log-linear buckets, in the
+// manner of HdrHistogram. Its memory only depends on the highest trackable
+// value, and the quantiles it returns are within 0.1% of the recorded values
+// however many were recorded. It isn't safe for concurrent use.
+type Histogram struct {
+	highest int64
+	counts  []int64
+
+	total    int64
+	min, max int64
+	sum      float64
+}
+
+// New returns a histogram tracking values from 0 to highest, higher values are
+// recorded as highest
+func New(highest int64) *Histogram {
+	highest = max(highest, 1)
+	return &Histogram{
+		highest: highest,
+		counts:  make([]int64, countsIndex(highest)+1),
+		min:     math.MaxInt64,
+	}
+}
+
+// countsIndex is the bucket of v, the first sub-bucket half of every power of
+// two but the first overlaps the previous one and is skipped
+func countsIndex(v int64) int {
+	bucket := 64 - bits.LeadingZeros64(uint64(v)|subBucketMask) - (subBucketHalfCountMagnitude + 1)
+	subBucket := int(v >> bucket)
+	return (bucket+1)<<subBucketHalfCountMagnitude + subBucket - subBucketHalfCount
+}
+
+// valueRange returns the lowest value of the bucket at index and its width
+func valueRange(index int) (int64, int64) {
+	bucket := index>>subBucketHalfCountMagnitude - 1
+	subBucket := int64(index&(subBucketHalfCount-1)) + subBucketHalfCount
+	if bucket < 0 {
+		subBucket -= subBucketHalfCount
+		bucket = 0
+	}
+	return subBucket << bucket, 1 << bucket
+}
+
+// Record counts v
+func (h *Histogram) Record(v int64) {
+	v = min(max(v, 0), h.highest)
+	h.counts[countsIndex(v)]++
+	h.total++
+	h.min = min(h.min, v)
+	h.max = max(h.max, v)
+	h.sum += float64(v)
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() int64 {
+	return h.total
+}
+
+// Min returns the lowest recorded value, 0 when empty
+func (h *Histogram) Min() int64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.min
+}
+
+// Max returns the highest recorded value
+func (h *Histogram) Max() int64 {
+	return h.max
+}
+
+// Mean returns the mean of the recorded values
+func (h *Histogram) Mean() float64 {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / float64(h.total)
+}
+
+// ValueAtQuantile returns the value q percent of the recorded values are at
+// or below, q in [0, 100]
+func (h *Histogram) ValueAtQuantile(q float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	q = min(max(q, 0), 100)
+	rank := max(int64(math.Ceil(q/100*float64(h.total))), 1)
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			low, width := valueRange(i)
+			// the bucket holds values up to low+width-1, none past the max
+			return min(max(low+width-1, h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// Reset empties the histogram, keeping its memory
+func (h *Histogram) Reset() {
+	clear(h.counts)
+	h.total = 0
+	h.min = math.MaxInt64
+	h.max = 0
+	h.sum = 0
+}
@@ -0,0 +1,55 @@
+package hdrhistogram
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexRoundTrip(t *testing.T) {
+	for _, v := range []int64{0, 1, 1023, 1024, 2047, 2048, 2049, 4095, 4096, 123456, 3_600_000_000} {
+		low, width := valueRange(countsIndex(v))
+		assert.LessOrEqual(t, low, v, "value %d", v)
+		assert.Less(t, v, low+width, "value %d", v)
+		// exact below 2048, within 0.1% above
+		assert.True(t, width == 1 || width*1000 <= low, "value %d", v)
+	}
+}
+
+func TestValueAtQuantile(t *testing.T) {
+	h := New(3_600_000_000)
+	rng := rand.New(rand.NewSource(1))
+	values := make([]int64, 200000)
+	for i := range values {
+		values[i] = int64(rng.ExpFloat64() * 5000)
+		h.Record(values[i])
+	}
+	slices.Sort(values)
+
+	assert.Equal(t, int64(len(values)), h.Count())
+	assert.Equal(t, values[0], h.Min())
+	assert.Equal(t, values[len(values)-1], h.Max())
+	for _, q := range []float64{50, 95, 99, 99.9} {
+		want := float64(values[int(q/100*float64(len(values)))-1])
+		assert.InEpsilon(t, want, float64(h.ValueAtQuantile(q)), 0.002, "p%g", q)
+	}
+	assert.Equal(t, h.Max(), h.ValueAtQuantile(100))
+}
+
+func TestClampAndReset(t *testing.T) {
+	h := New(1000)
+	h.Record(-5)
+	h.Record(50000)
+	assert.Equal(t, int64(0), h.Min())
+	assert.Equal(t, int64(1000), h.Max())
+	assert.Equal(t, 500.0, h.Mean())
+
+	h.Reset()
+	assert.Equal(t, int64(0), h.Count())
+	assert.Equal(t, int64(0), h.ValueAtQuantile(99))
+	h.Record(7)
+	assert.Equal(t, int64(7), h.Min())
+	assert.Equal(t, int64(7), h.ValueAtQuantile(50))
+}
@@ -0,0 +1,120 @@
+package httpclient
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreakerConfig configures the per-backend circuit breaker
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failed requests that
+	// opens the circuit. Zero disables the breaker.
+	FailureThreshold int
+	// OpenDuration is how long an open circuit rejects requests before a
+	// single trial request is let through
+	OpenDuration time.Duration
+}
+
+// circuitBreaker stops sending requests to a backend after repeated
+// failures, probing it again with one request once OpenDuration has passed
+type circuitBreaker struct {
+	mu            sync.Mutex
+	state         breakerState
+	failures      int
+	openedAt      time.Time
+	trialInFlight bool
+}
+
+// allow reports whether a request may be sent to the backend
+func (cb *circuitBreaker) allow(cfg CircuitBreakerConfig, now time.Time) bool {
+	if cfg.FailureThreshold <= 0 {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if now.Sub(cb.openedAt) < cfg.OpenDuration {
+			return false
+		}
+		cb.state = breakerHalfOpen
+		cb.trialInFlight = true
+		return true
+	case breakerHalfOpen:
+		if cb.trialInFlight {
+			return false
+		}
+		cb.trialInFlight = true
+		return true
+	default:
+		return true
+	}
+}
+
+// available is like allow but doesn't claim the half-open trial slot
+func (cb *circuitBreaker) available(cfg CircuitBreakerConfig, now time.Time) bool {
+	if cfg.FailureThreshold <= 0 {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		return now.Sub(cb.openedAt) >= cfg.OpenDuration
+	case breakerHalfOpen:
+		return !cb.trialInFlight
+	default:
+		return true
+	}
+}
+
+func (cb *circuitBreaker) record(cfg CircuitBreakerConfig, success bool, now time.Time) {
+	if cfg.FailureThreshold <= 0 {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.trialInFlight = false
+	if success {
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cfg.FailureThreshold {
+		cb.state = breakerOpen
+		cb.openedAt = now
+	}
+}
+
+func (cb *circuitBreaker) currentState() breakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
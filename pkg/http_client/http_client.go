@@ -1,21 +1,96 @@
 package httpclient
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
-// LoadBalancedClient wraps http.Client with round-robin load balancing
+// Policy selects the backend used for the next request
+type Policy int
+
+const (
+	// RoundRobin cycles through healthy backends in order
+	RoundRobin Policy = iota
+	// LeastOutstanding picks the healthy backend with the fewest in-flight requests
+	LeastOutstanding
+)
+
+// backend tracks the health and load of a single server
+type backend struct {
+	server      string
+	healthy     atomic.Bool
+	outstanding atomic.Int64
+	requests    atomic.Uint64
+	errors      atomic.Uint64
+	breaker     circuitBreaker
+
+	// consecutive health check results, only touched by the health checker
+	successes int
+	failures  int
+}
+
+func newBackend(server string) *backend {
+	b := &backend{server: server}
+	b.healthy.Store(true)
+	return b
+}
+
+// BackendStats is a point-in-time view of a backend
+type BackendStats struct {
+	Server      string `json:"server"`
+	Healthy     bool   `json:"healthy"`
+	Outstanding int64  `json:"outstanding"`
+	Requests    uint64 `json:"requests"`
+	Errors      uint64 `json:"errors"`
+	Circuit     string `json:"circuit"`
+}
+
+// HealthCheckConfig configures active health checking of backends
+type HealthCheckConfig struct {
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// UnhealthyThreshold is the number of consecutive failed checks before a
+	// backend is ejected
+	UnhealthyThreshold int
+	// HealthyThreshold is the number of consecutive successful checks before
+	// an ejected backend is readmitted
+	HealthyThreshold int
+}
+
+// RetryConfig configures retries of requests that failed to reach a backend.
+// Each retry goes to a backend that wasn't tried yet for the same request.
+type RetryConfig struct {
+	MaxRetries     int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	BackoffFactor  float64
+}
+
+// ErrNoBackendAvailable is returned when every backend was either tried
+// already or has an open circuit
+var ErrNoBackendAvailable = errors.New("no backend available")
+
+// LoadBalancedClient wraps http.Client with load balancing across servers
 type LoadBalancedClient struct {
-	servers []string
+	servers []*backend
 	client  *http.Client
 	counter uint64
+	policy  Policy
 	mu      sync.RWMutex
 	reqPool sync.Pool
+
+	timeout time.Duration
+	retry   RetryConfig
+	breaker CircuitBreakerConfig
 }
 
 // NewLoadBalancedClient creates a new load-balanced HTTP client
@@ -36,10 +111,12 @@ func NewLoadBalancedClient(servers []string, client *http.Client) (*LoadBalanced
 	}
 
 	lbc := &LoadBalancedClient{
-		servers: make([]string, len(servers)),
+		servers: make([]*backend, len(servers)),
 		client:  client,
 	}
-	copy(lbc.servers, servers)
+	for i, server := range servers {
+		lbc.servers[i] = newBackend(server)
+	}
 
 	// Initialize request pool
 	lbc.reqPool.New = func() interface{} {
@@ -49,17 +126,93 @@ func NewLoadBalancedClient(servers []string, client *http.Client) (*LoadBalanced
 	return lbc, nil
 }
 
-// nextServer returns the next server using round-robin algorithm
-func (c *LoadBalancedClient) nextServer() string {
+// SetPolicy changes how backends are selected
+func (c *LoadBalancedClient) SetPolicy(policy Policy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policy = policy
+}
+
+// SetTimeout sets the timeout of each attempt of a request, including
+// reading the response body. Zero means no timeout.
+func (c *LoadBalancedClient) SetTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = timeout
+}
+
+// SetRetryConfig enables retrying requests on connection failures
+func (c *LoadBalancedClient) SetRetryConfig(cfg RetryConfig) {
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 50 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 2 * time.Second
+	}
+	if cfg.BackoffFactor <= 0 {
+		cfg.BackoffFactor = 2.0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.retry = cfg
+}
+
+// SetCircuitBreaker enables the per-backend circuit breaker
+func (c *LoadBalancedClient) SetCircuitBreaker(cfg CircuitBreakerConfig) {
+	if cfg.OpenDuration <= 0 {
+		cfg.OpenDuration = 10 * time.Second
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.breaker = cfg
+}
+
+// nextServer returns the next healthy backend according to the policy,
+// skipping backends in tried and backends with an open circuit. If every
+// remaining backend is ejected by health checks, they are considered anyway
+// so requests still have a chance to succeed.
+func (c *LoadBalancedClient) nextServer(tried map[*backend]bool) *backend {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	if len(c.servers) == 0 {
-		return ""
+		return nil
+	}
+
+	now := time.Now()
+	candidates := make([]*backend, 0, len(c.servers))
+	fallback := make([]*backend, 0, len(c.servers))
+	for _, b := range c.servers {
+		if tried[b] || !b.breaker.available(c.breaker, now) {
+			continue
+		}
+		if b.healthy.Load() {
+			candidates = append(candidates, b)
+		} else {
+			fallback = append(fallback, b)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = fallback
+	}
+	if len(candidates) == 0 {
+		return nil
 	}
 
 	index := atomic.AddUint64(&c.counter, 1) - 1
-	return c.servers[index%uint64(len(c.servers))]
+	if c.policy == LeastOutstanding {
+		// Start at a rotating offset so ties are spread across backends
+		best := candidates[index%uint64(len(candidates))]
+		for _, b := range candidates {
+			if b.outstanding.Load() < best.outstanding.Load() {
+				best = b
+			}
+		}
+		return best
+	}
+	return candidates[index%uint64(len(candidates))]
 }
 
 // copyRequest efficiently copies a request using a pooled request object
@@ -113,13 +266,8 @@ func (c *LoadBalancedClient) returnRequest(req *http.Request) {
 	c.reqPool.Put(req)
 }
 
-// buildURL constructs the full URL with the selected server
-func (c *LoadBalancedClient) buildURL(path string) (*url.URL, error) {
-	server := c.nextServer()
-	if server == "" {
-		return nil, fmt.Errorf("no servers available")
-	}
-
+// resolveURL constructs the full URL of path on the given server
+func resolveURL(server, path string) (*url.URL, error) {
 	baseURL, err := url.Parse(server)
 	if err != nil {
 		return nil, fmt.Errorf("invalid server URL: %v", err)
@@ -133,57 +281,259 @@ func (c *LoadBalancedClient) buildURL(path string) (*url.URL, error) {
 	return baseURL.ResolveReference(pathURL), nil
 }
 
-// Do executes an HTTP request using round-robin load balancing
-func (c *LoadBalancedClient) Do(req *http.Request) (*http.Response, error) {
-	// Build the full URL with load balancing
-	fullURL, err := c.buildURL(req.URL.String())
+// cancelOnClose releases an attempt's timeout once the body is consumed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// attempt sends req to backend b, keeping the backend's outstanding,
+// request and error counters and its circuit breaker up to date. A request
+// is outstanding until its response headers arrive.
+func (c *LoadBalancedClient) attempt(req *http.Request, b *backend, timeout time.Duration, breaker CircuitBreakerConfig) (*http.Response, error) {
+	fullURL, err := resolveURL(b.server, req.URL.String())
 	if err != nil {
 		return nil, err
 	}
 
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
 	// Get a pooled request and copy the original
 	newReq := c.copyRequest(req, fullURL)
 	defer c.returnRequest(newReq)
+	newReq = newReq.WithContext(ctx)
+
+	b.outstanding.Add(1)
+	b.requests.Add(1)
+	resp, err := c.client.Do(newReq)
+	b.outstanding.Add(-1)
+
+	failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+	if failed {
+		b.errors.Add(1)
+	}
+	b.breaker.record(breaker, !failed, time.Now())
 
-	return c.client.Do(newReq)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
 }
 
-// Get performs a GET request using round-robin load balancing
+// Do executes an HTTP request using load balancing. req.URL is resolved
+// against the selected server. Requests that fail to reach a backend are
+// retried on another backend if retries are configured and the body can be
+// replayed through req.GetBody.
+func (c *LoadBalancedClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.RLock()
+	timeout, retry, breaker := c.timeout, c.retry, c.breaker
+	c.mu.RUnlock()
+
+	tried := make(map[*backend]bool)
+	backoff := retry.InitialBackoff
+	var lastErr error
+
+	for attempt := 0; attempt <= retry.MaxRetries; attempt++ {
+		if attempt > 0 {
+			if req.Body != nil && req.GetBody == nil {
+				break
+			}
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, fmt.Errorf("error rewinding request body: %v", err)
+				}
+				req.Body = body
+			}
+
+			select {
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			case <-time.After(backoff):
+			}
+			backoff = time.Duration(float64(backoff) * retry.BackoffFactor)
+			if backoff > retry.MaxBackoff {
+				backoff = retry.MaxBackoff
+			}
+		}
+
+		b := c.nextServer(tried)
+		if b == nil {
+			if lastErr != nil {
+				return nil, fmt.Errorf("%w after %d attempts: %v", ErrNoBackendAvailable, attempt, lastErr)
+			}
+			return nil, ErrNoBackendAvailable
+		}
+		if !b.breaker.allow(breaker, time.Now()) {
+			// Lost the half-open trial slot to a concurrent request
+			tried[b] = true
+			attempt--
+			continue
+		}
+		tried[b] = true
+
+		resp, err := c.attempt(req, b, timeout, breaker)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+		if req.Context().Err() != nil {
+			break
+		}
+	}
+
+	return nil, lastErr
+}
+
+// Get performs a GET request using load balancing
 func (c *LoadBalancedClient) Get(path string, params url.Values) (*http.Response, error) {
-	fullURL, err := c.buildURL(path)
+	req, err := http.NewRequest(http.MethodGet, path, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid path: %v", err)
 	}
-	fullURL.RawQuery = params.Encode()
-	return c.client.Get(fullURL.String())
+	req.URL.RawQuery = params.Encode()
+	return c.Do(req)
 }
 
-// Post performs a POST request using round-robin load balancing
+// Post performs a POST request using load balancing
 func (c *LoadBalancedClient) Post(path, contentType string, bodyReader io.Reader) (*http.Response, error) {
-	fullURL, err := c.buildURL(path)
+	req, err := http.NewRequest(http.MethodPost, path, bodyReader)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid path: %v", err)
 	}
-
-	return c.client.Post(fullURL.String(), contentType, bodyReader)
+	req.Header.Set("Content-Type", contentType)
+	return c.Do(req)
 }
 
-// Head performs a HEAD request using round-robin load balancing
+// Head performs a HEAD request using load balancing
 func (c *LoadBalancedClient) Head(path string) (*http.Response, error) {
-	fullURL, err := c.buildURL(path)
+	req, err := http.NewRequest(http.MethodHead, path, nil)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("invalid path: %v", err)
 	}
-	return c.client.Head(fullURL.String())
+	return c.Do(req)
 }
 
-// PostForm performs a POST form request using round-robin load balancing
+// PostForm performs a POST form request using load balancing
 func (c *LoadBalancedClient) PostForm(path string, data url.Values) (*http.Response, error) {
-	fullURL, err := c.buildURL(path)
+	return c.Post(path, "application/x-www-form-urlencoded", strings.NewReader(data.Encode()))
+}
+
+// StartHealthChecks periodically probes every backend until ctx is done,
+// ejecting backends that fail cfg.UnhealthyThreshold checks in a row and
+// readmitting them after cfg.HealthyThreshold successful checks.
+func (c *LoadBalancedClient) StartHealthChecks(ctx context.Context, cfg HealthCheckConfig) {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Second
+	}
+	if cfg.UnhealthyThreshold <= 0 {
+		cfg.UnhealthyThreshold = 2
+	}
+	if cfg.HealthyThreshold <= 0 {
+		cfg.HealthyThreshold = 2
+	}
+
+	go func() {
+		ticker := time.NewTicker(cfg.Interval)
+		defer ticker.Stop()
+		for {
+			c.checkBackends(ctx, cfg)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *LoadBalancedClient) checkBackends(ctx context.Context, cfg HealthCheckConfig) {
+	c.mu.RLock()
+	backends := make([]*backend, len(c.servers))
+	copy(backends, c.servers)
+	c.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, b := range backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.checkBackend(ctx, cfg, b)
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *LoadBalancedClient) checkBackend(ctx context.Context, cfg HealthCheckConfig, b *backend) {
+	ok := c.probe(ctx, cfg, b.server)
+	if ok {
+		b.successes++
+		b.failures = 0
+		if !b.healthy.Load() && b.successes >= cfg.HealthyThreshold {
+			b.healthy.Store(true)
+		}
+		return
+	}
+	b.failures++
+	b.successes = 0
+	if b.healthy.Load() && b.failures >= cfg.UnhealthyThreshold {
+		b.healthy.Store(false)
+	}
+}
+
+func (c *LoadBalancedClient) probe(ctx context.Context, cfg HealthCheckConfig, server string) bool {
+	fullURL, err := resolveURL(server, cfg.Path)
 	if err != nil {
-		return nil, err
+		return false
 	}
-	return c.client.PostForm(fullURL.String(), data)
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL.String(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusBadRequest
+}
+
+// BackendStats returns the health and request counters of every backend
+func (c *LoadBalancedClient) BackendStats() []BackendStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := make([]BackendStats, len(c.servers))
+	for i, b := range c.servers {
+		stats[i] = BackendStats{
+			Server:      b.server,
+			Healthy:     b.healthy.Load(),
+			Outstanding: b.outstanding.Load(),
+			Requests:    b.requests.Load(),
+			Errors:      b.errors.Load(),
+			Circuit:     b.breaker.currentState().String(),
+		}
+	}
+	return stats
 }
 
 // AddServer adds a new server to the load balancer
@@ -194,7 +544,7 @@ func (c *LoadBalancedClient) AddServer(server string) error {
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	c.servers = append(c.servers, server)
+	c.servers = append(c.servers, newBackend(server))
 	return nil
 }
 
@@ -203,8 +553,8 @@ func (c *LoadBalancedClient) RemoveServer(server string) bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	for i, s := range c.servers {
-		if s == server {
+	for i, b := range c.servers {
+		if b.server == server {
 			c.servers = append(c.servers[:i], c.servers[i+1:]...)
 			return true
 		}
@@ -218,7 +568,9 @@ func (c *LoadBalancedClient) GetServers() []string {
 	defer c.mu.RUnlock()
 
 	servers := make([]string, len(c.servers))
-	copy(servers, c.servers)
+	for i, b := range c.servers {
+		servers[i] = b.server
+	}
 	return servers
 }
 
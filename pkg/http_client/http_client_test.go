@@ -0,0 +1,113 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadBalancedClientEjectsUnhealthyBackend(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer flaky.Close()
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer stable.Close()
+
+	c, err := NewLoadBalancedClient([]string{flaky.URL, stable.URL}, nil)
+	assert.NoError(t, err)
+
+	cfg := HealthCheckConfig{
+		Path:               "/healthz",
+		Timeout:            time.Second,
+		UnhealthyThreshold: 1,
+		HealthyThreshold:   1,
+	}
+
+	healthy.Store(false)
+	c.checkBackends(context.Background(), cfg)
+	for i := 0; i < 4; i++ {
+		resp, err := c.Get("/", nil)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	stats := c.BackendStats()
+	assert.False(t, stats[0].Healthy)
+	assert.Equal(t, uint64(0), stats[0].Requests)
+	assert.Equal(t, uint64(4), stats[1].Requests)
+
+	healthy.Store(true)
+	c.checkBackends(context.Background(), cfg)
+	assert.True(t, c.BackendStats()[0].Healthy)
+}
+
+func TestLoadBalancedClientLeastOutstanding(t *testing.T) {
+	c, err := NewLoadBalancedClient([]string{"http://a", "http://b", "http://c"}, nil)
+	assert.NoError(t, err)
+	c.SetPolicy(LeastOutstanding)
+
+	c.servers[0].outstanding.Store(5)
+	c.servers[1].outstanding.Store(1)
+	c.servers[2].outstanding.Store(3)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, "http://b", c.nextServer(nil).server)
+	}
+}
+
+func TestLoadBalancedClientRetriesOnAnotherBackend(t *testing.T) {
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer stable.Close()
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	down.Close()
+
+	c, err := NewLoadBalancedClient([]string{down.URL, stable.URL}, nil)
+	assert.NoError(t, err)
+	c.SetRetryConfig(RetryConfig{MaxRetries: 1, InitialBackoff: time.Millisecond})
+	c.SetCircuitBreaker(CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Hour})
+
+	for i := 0; i < 4; i++ {
+		resp, err := c.Get("/", nil)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	stats := c.BackendStats()
+	assert.Equal(t, "open", stats[0].Circuit)
+	assert.Equal(t, uint64(1), stats[0].Requests)
+	assert.Equal(t, uint64(4), stats[1].Requests)
+}
+
+func TestLoadBalancedClientTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	c, err := NewLoadBalancedClient([]string{slow.URL}, nil)
+	assert.NoError(t, err)
+	c.SetTimeout(20 * time.Millisecond)
+
+	_, err = c.Get("/", nil)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(1), c.BackendStats()[0].Errors)
+}
@@ -0,0 +1,538 @@
+// Package inventory extracts the tables and columns a query references, with
+// the lexer the collector normalizes queries with. It reads the common shapes
+// of MySQL statements without parsing them fully, so the references are a
+// best effort: a column is attributed to every table of its statement unless
+// it is qualified.
+package inventory
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/bagaswh/mysql-toolkit/pkg/lexer"
+)
+
+type Table struct {
+	Schema string `json:"schema,omitempty"`
+	Name   string `json:"name"`
+}
+
+func (t Table) String() string {
+	if t.Schema == "" {
+		return t.Name
+	}
+	return t.Schema + "." + t.Name
+}
+
+// Column is a column reference. Tables are the tables it may belong to: the
+// one it is qualified with, or every table of the statement. Kind is inferred
+// from the literals the column is compared with or assigned.
+type Column struct {
+	Name   string  `json:"name"`
+	Tables []Table `json:"tables"`
+	Kind   Kind    `json:"kind,omitempty"`
+}
+
+// Refs are the objects referenced by a query, each once
+type Refs struct {
+	Tables  []Table
+	Columns []Column
+}
+
+// Extractor extracts references, it is not safe for concurrent use
+type Extractor struct {
+	lexer  *lexer.Lexer
+	tokens []token
+}
+
+func NewExtractor() *Extractor {
+	return &Extractor{lexer: lexer.NewLexer()}
+}
+
+type token struct {
+	typ lexer.TokenType
+	// text is the lexeme, without the backticks of quoted identifiers
+	text string
+	// word is the uppercased text of unquoted builtin keywords, empty
+	// otherwise
+	word string
+	// variable is set for identifiers prefixed with @, which the lexer
+	// drops
+	variable bool
+}
+
+var comparisons = map[string]bool{"=": true, "<>": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "<=>": true}
+
+// valueWords are builtin keywords standing for values, never columns
+var valueWords = map[string]bool{
+	"NULL": true, "TRUE": true, "FALSE": true, "DEFAULT": true, "UNKNOWN": true, "DUAL": true,
+	"CURRENT_DATE": true, "CURRENT_TIME": true, "CURRENT_TIMESTAMP": true, "CURRENT_USER": true,
+	"LOCALTIME": true, "LOCALTIMESTAMP": true, "UTC_DATE": true, "UTC_TIME": true, "UTC_TIMESTAMP": true,
+	"SIGNED": true, "UNSIGNED": true, "CHAR": true, "DATETIME": true, "DECIMAL": true, "INTEGER": true,
+	"JSON": true, "BINARY": true,
+}
+
+// Extract returns the tables and columns query references
+func (e *Extractor) Extract(query []byte) Refs {
+	e.tokenize(query)
+	s := &statement{
+		tokens:   e.tokens,
+		consumed: make([]bool, len(e.tokens)),
+		aliases:  make(map[string]Table),
+		derived:  make(map[string]bool),
+		ctes:     make(map[string]bool),
+	}
+	s.readTables()
+	s.readColumns()
+	return s.refs
+}
+
+func (e *Extractor) tokenize(query []byte) {
+	e.tokens = e.tokens[:0]
+	e.lexer.Reset()
+	e.lexer.Parse(query)
+	for {
+		tok := e.lexer.NextToken()
+		if tok.Type == lexer.TokenEOF {
+			return
+		}
+		if tok.Type == lexer.TokenComment {
+			continue
+		}
+		lexeme := tok.LexemeRef(query)
+		if len(lexeme) == 0 {
+			continue
+		}
+		t := token{typ: tok.Type, text: string(lexeme)}
+		if tok.Type == lexer.TokenKeyword {
+			// the lexeme aliases query, its capacity tells where it starts
+			start := cap(query) - cap(lexeme)
+			t.variable = start > 0 && query[start-1] == '@'
+			if tok.IsQuotedWithBacktick(query) {
+				t.text = string(bytes.ReplaceAll(lexeme[1:len(lexeme)-1], []byte("``"), []byte("`")))
+			} else if tok.IsBuiltInKeyword() {
+				t.word = strings.ToUpper(t.text)
+			}
+		}
+		e.tokens = append(e.tokens, t)
+	}
+}
+
+type statement struct {
+	tokens   []token
+	consumed []bool
+	// aliases maps the lowercased aliases and names of the tables to them
+	aliases map[string]Table
+	// derived are the aliases of derived tables and common table
+	// expressions, whose columns are not checked
+	derived map[string]bool
+	ctes    map[string]bool
+	refs    Refs
+}
+
+func (s *statement) is(i int, typ lexer.TokenType) bool {
+	return i >= 0 && i < len(s.tokens) && s.tokens[i].typ == typ
+}
+
+// isWord tells whether token i is one of the builtin keywords words
+func (s *statement) isWord(i int, words ...string) bool {
+	if !s.is(i, lexer.TokenKeyword) || s.tokens[i].word == "" {
+		return false
+	}
+	for _, w := range words {
+		if s.tokens[i].word == w {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdent tells whether token i is an identifier, not a builtin keyword
+func (s *statement) isIdent(i int) bool {
+	return s.is(i, lexer.TokenKeyword) && s.tokens[i].word == "" && !s.tokens[i].variable
+}
+
+// isName tells whether token i may name an object, builtin keywords such as
+// user or status included
+func (s *statement) isName(i int) bool {
+	return s.is(i, lexer.TokenKeyword) && !s.tokens[i].variable
+}
+
+// matching returns the index of the parenthesis closing the one at i
+func (s *statement) matching(i int) int {
+	depth := 0
+	for j := i; j < len(s.tokens); j++ {
+		switch s.tokens[j].typ {
+		case lexer.TokenOpenParen:
+			depth++
+		case lexer.TokenCloseParen:
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return len(s.tokens)
+}
+
+// consume marks the identifiers up to token end as read
+func (s *statement) consume(start, end int) {
+	for j := start; j <= end && j < len(s.tokens); j++ {
+		if s.is(j, lexer.TokenKeyword) {
+			s.consumed[j] = true
+		}
+	}
+}
+
+func (s *statement) addTable(t Table) {
+	for _, existing := range s.refs.Tables {
+		if existing == t {
+			return
+		}
+	}
+	s.refs.Tables = append(s.refs.Tables, t)
+}
+
+func (s *statement) addColumn(name string, tables []Table, kind Kind) {
+	for i, c := range s.refs.Columns {
+		if strings.EqualFold(c.Name, name) && sameTables(c.Tables, tables) {
+			s.refs.Columns[i].Kind = MergeKinds(c.Kind, kind)
+			return
+		}
+	}
+	s.refs.Columns = append(s.refs.Columns, Column{Name: name, Tables: tables, Kind: kind})
+}
+
+func sameTables(a, b []Table) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readTables reads the table references, following FROM, JOIN, UPDATE,
+// INTO and TABLE
+func (s *statement) readTables() {
+	var parens []int
+	for i := 0; i < len(s.tokens); i++ {
+		switch s.tokens[i].typ {
+		case lexer.TokenOpenParen:
+			parens = append(parens, i)
+			continue
+		case lexer.TokenCloseParen:
+			if len(parens) > 0 {
+				parens = parens[:len(parens)-1]
+			}
+			continue
+		}
+		if s.tokens[i].word == "" {
+			continue
+		}
+		switch s.tokens[i].word {
+		case "WITH":
+			s.readCTEs(i + 1)
+		case "DELETE":
+			// the tables of a multiple-table DELETE are named before FROM
+			for j := i + 1; j < len(s.tokens) && !s.isWord(j, "FROM"); j++ {
+				s.consume(j, j)
+			}
+		case "INDEX", "KEY", "PARTITION":
+			// index hints, key parts and partition lists
+			if s.is(i+1, lexer.TokenOpenParen) {
+				s.consume(i+1, s.matching(i+1))
+			} else if s.isIdent(i+1) && s.is(i+2, lexer.TokenOpenParen) {
+				s.consume(i+1, i+1)
+			}
+		case "FROM", "JOIN", "STRAIGHT_JOIN":
+			// EXTRACT(YEAR FROM d) and the like read no table, FROM only
+			// follows SELECT at the top level or in a subquery
+			if len(parens) > 0 && !s.isWord(parens[len(parens)-1]+1, "SELECT", "WITH") {
+				if p := parens[len(parens)-1] - 1; p >= 0 && strings.EqualFold(s.tokens[p].text, "EXTRACT") {
+					// the unit, neither YEAR nor EXTRACT are keywords of the
+					// lexer
+					s.consume(i-1, i-1)
+				}
+				continue
+			}
+			s.readTableList(i+1, s.tokens[i].word == "FROM")
+		case "UPDATE":
+			// SELECT ... FOR UPDATE and ON DUPLICATE KEY UPDATE
+			if s.isWord(i-1, "FOR", "KEY") {
+				continue
+			}
+			s.readTableList(i+1, true)
+		case "INTO":
+			s.readInto(i + 1)
+		case "TABLE":
+			s.readTableList(i+1, false)
+		case "TRUNCATE":
+			if !s.isWord(i+1, "TABLE") {
+				s.readTableList(i+1, false)
+			}
+		}
+	}
+}
+
+// readCTEs reads the names of the common table expressions following WITH
+func (s *statement) readCTEs(i int) {
+	if s.isWord(i, "RECURSIVE") {
+		i++
+	}
+	for s.isName(i) && !s.isWord(i, "ROLLUP") {
+		s.ctes[strings.ToLower(s.tokens[i].text)] = true
+		s.derived[strings.ToLower(s.tokens[i].text)] = true
+		s.consume(i, i)
+		i++
+		if s.is(i, lexer.TokenOpenParen) {
+			end := s.matching(i)
+			s.consume(i, end)
+			i = end + 1
+		}
+		if !s.isWord(i, "AS") || !s.is(i+1, lexer.TokenOpenParen) {
+			return
+		}
+		i = s.matching(i+1) + 1
+		if !s.is(i, lexer.TokenComma) {
+			return
+		}
+		i++
+	}
+}
+
+// readTableList reads the table references from i, separated by commas when
+// list is set
+func (s *statement) readTableList(i int, list bool) {
+	for {
+		i, _ = s.readTableRef(i)
+		if !list || !s.is(i, lexer.TokenComma) {
+			return
+		}
+		i++
+	}
+}
+
+// readTableRef reads a table reference and its alias, and returns the index
+// after them and the table, nil for derived tables
+func (s *statement) readTableRef(i int) (int, *Table) {
+	for s.isWord(i, "LOW_PRIORITY", "IGNORE", "IF", "NOT", "EXISTS", "LATERAL") {
+		i++
+	}
+	if s.is(i, lexer.TokenOpenParen) {
+		// a derived table, its own query is read like the rest
+		return s.readAlias(s.matching(i)+1, nil), nil
+	}
+	if !s.isName(i) || s.isWord(i, "SELECT", "WITH", "DUAL", "OUTFILE", "DUMPFILE") {
+		return i, nil
+	}
+	table := Table{Name: s.tokens[i].text}
+	s.consume(i, i)
+	i++
+	if s.is(i, lexer.TokenDot) && s.isName(i+1) {
+		table = Table{Schema: table.Name, Name: s.tokens[i+1].text}
+		s.consume(i+1, i+1)
+		i += 2
+	}
+	if table.Schema == "" && s.ctes[strings.ToLower(table.Name)] {
+		return s.readAlias(i, nil), nil
+	}
+	s.addTable(table)
+	s.aliases[strings.ToLower(table.Name)] = table
+	return s.readAlias(i, &table), &table
+}
+
+// readAlias reads the alias of a table reference at i, derived when table is
+// nil
+func (s *statement) readAlias(i int, table *Table) int {
+	if s.isWord(i, "AS") {
+		i++
+	} else if !s.isIdent(i) {
+		return i
+	}
+	if !s.isName(i) {
+		return i
+	}
+	alias := strings.ToLower(s.tokens[i].text)
+	if table != nil {
+		s.aliases[alias] = *table
+	} else {
+		s.derived[alias] = true
+	}
+	s.consume(i, i)
+	return i + 1
+}
+
+// readInto reads the table of an INSERT or REPLACE, and the columns it lists
+// with the kinds of the values of its VALUES rows
+func (s *statement) readInto(i int) {
+	i, table := s.readTableRef(i)
+	if table == nil || !s.is(i, lexer.TokenOpenParen) {
+		return
+	}
+	end := s.matching(i)
+	var columns []string
+	for j := i + 1; j < end; j++ {
+		if s.isName(j) {
+			columns = append(columns, s.tokens[j].text)
+		}
+	}
+	s.consume(i, end)
+
+	kinds := make([]Kind, len(columns))
+	if s.isWord(end+1, "VALUES", "VALUE") {
+		for row := end + 2; s.is(row, lexer.TokenOpenParen); {
+			rowEnd := s.matching(row)
+			column := 0
+			for j := row + 1; j < rowEnd && column < len(kinds); j++ {
+				// only values of a single token, not expressions
+				if s.is(j-1, lexer.TokenComma) || j == row+1 {
+					if s.is(j+1, lexer.TokenComma) || j+1 == rowEnd {
+						kinds[column] = MergeKinds(kinds[column], s.valueKind(j))
+					}
+				}
+				if s.is(j, lexer.TokenOpenParen) {
+					j = s.matching(j)
+				} else if s.is(j, lexer.TokenComma) {
+					column++
+				}
+			}
+			if !s.is(rowEnd+1, lexer.TokenComma) {
+				break
+			}
+			row = rowEnd + 2
+		}
+	}
+	for j, name := range columns {
+		s.addColumn(name, []Table{*table}, kinds[j])
+	}
+}
+
+// readColumns reads the column references left after the tables were read
+func (s *statement) readColumns() {
+	for i := 0; i < len(s.tokens); i++ {
+		if !s.isName(i) || s.consumed[i] {
+			continue
+		}
+		if s.is(i+1, lexer.TokenDot) {
+			i = s.readQualified(i)
+			continue
+		}
+		if s.is(i+1, lexer.TokenOpenParen) || s.isWord(i-1, "AS", "COLLATE", "CHARSET") {
+			continue
+		}
+		if s.tokens[i].word != "" {
+			if !s.columnContext(i) {
+				continue
+			}
+		} else if s.isName(i-1) && s.tokens[i-1].word == "" || s.is(i-1, lexer.TokenLiteral) || s.is(i-1, lexer.TokenCloseParen) {
+			// an alias without AS
+			continue
+		} else if s.isWord(i-1, "SET") && s.isWord(i-2, "CHARACTER", "CHAR") {
+			continue
+		}
+		// statements without tables, such as SET or USE, have no columns
+		if len(s.refs.Tables) == 0 {
+			continue
+		}
+		s.addColumn(s.tokens[i].text, s.refs.Tables, s.kindAfter(i))
+	}
+}
+
+// readQualified reads the qualified column at i, and returns the index of its
+// last part
+func (s *statement) readQualified(i int) int {
+	if !s.isName(i + 2) {
+		// t.*
+		return i + 1
+	}
+	var table Table
+	last := i + 2
+	if s.is(i+3, lexer.TokenDot) && s.isName(i+4) {
+		table = Table{Schema: s.tokens[i].text, Name: s.tokens[i+2].text}
+		last = i + 4
+	} else {
+		qualifier := strings.ToLower(s.tokens[i].text)
+		if s.derived[qualifier] {
+			return last
+		}
+		var ok bool
+		if table, ok = s.aliases[qualifier]; !ok {
+			return last
+		}
+	}
+	// schema.function()
+	if s.is(last+1, lexer.TokenOpenParen) {
+		return last
+	}
+	s.addColumn(s.tokens[last].text, []Table{table}, s.kindAfter(last))
+	return last
+}
+
+// columnContext tells whether the builtin keyword at i is used as a column,
+// compared or listed, like status in WHERE status = 1
+func (s *statement) columnContext(i int) bool {
+	if valueWords[s.tokens[i].word] {
+		return false
+	}
+	before := s.is(i-1, lexer.TokenComma) || s.is(i-1, lexer.TokenOpenParen) ||
+		s.isWord(i-1, "SELECT", "DISTINCT", "WHERE", "AND", "OR", "NOT", "ON", "SET", "WHEN", "BY")
+	if !before {
+		return false
+	}
+	if i+1 == len(s.tokens) || s.is(i+1, lexer.TokenComma) || s.is(i+1, lexer.TokenCloseParen) ||
+		s.isWord(i+1, "FROM", "IN", "IS", "LIKE", "BETWEEN", "REGEXP", "RLIKE", "ASC", "DESC") {
+		return true
+	}
+	return s.is(i+1, lexer.TokenOperator) && comparisons[s.tokens[i+1].text]
+}
+
+// kindAfter infers the kind of the column at i from the value it is compared
+// with or assigned
+func (s *statement) kindAfter(i int) Kind {
+	j := i + 1
+	if s.isWord(j, "NOT") {
+		j++
+	}
+	switch {
+	case s.is(j, lexer.TokenOperator) && comparisons[s.tokens[j].text]:
+		return s.valueKind(j + 1)
+	case s.isWord(j, "BETWEEN"):
+		return s.valueKind(j + 1)
+	case s.isWord(j, "LIKE"):
+		return KindString
+	case s.isWord(j, "IN") && s.is(j+1, lexer.TokenOpenParen):
+		var kind Kind
+		end := s.matching(j + 1)
+		for k := j + 2; k < end; k++ {
+			if s.is(k, lexer.TokenLiteral) {
+				kind = MergeKinds(kind, LiteralKind(s.tokens[k].text))
+			}
+		}
+		return kind
+	}
+	return ""
+}
+
+// valueKind returns the kind of the value at i, a literal or a function
+// returning the current date or time
+func (s *statement) valueKind(i int) Kind {
+	if s.is(i, lexer.TokenLiteral) {
+		return LiteralKind(s.tokens[i].text)
+	}
+	if !s.isName(i) {
+		return ""
+	}
+	switch strings.ToUpper(s.tokens[i].text) {
+	case "TRUE", "FALSE":
+		return KindInteger
+	case "NOW", "SYSDATE", "CURRENT_TIMESTAMP", "LOCALTIMESTAMP", "UTC_TIMESTAMP":
+		return KindDatetime
+	case "CURDATE", "CURRENT_DATE", "UTC_DATE":
+		return KindDate
+	}
+	return ""
+}
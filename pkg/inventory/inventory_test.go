@@ -0,0 +1,134 @@
+package inventory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtract(t *testing.T) {
+	users := Table{Name: "users"}
+	orders := Table{Name: "orders"}
+	shopOrders := Table{Schema: "shop", Name: "orders"}
+
+	tests := []struct {
+		name  string
+		query string
+		want  Refs
+	}{
+		{
+			name:  "select",
+			query: "SELECT id, `name` FROM users WHERE status = 1 AND deleted_at IS NULL",
+			want: Refs{
+				Tables: []Table{users},
+				Columns: []Column{
+					{Name: "id", Tables: []Table{users}},
+					{Name: "name", Tables: []Table{users}},
+					{Name: "status", Tables: []Table{users}, Kind: KindInteger},
+					{Name: "deleted_at", Tables: []Table{users}},
+				},
+			},
+		},
+		{
+			name:  "qualified join",
+			query: "SELECT o.id, u.email FROM shop.orders o JOIN users AS u ON u.id = o.user_id WHERE o.created_at > '2024-01-01'",
+			want: Refs{
+				Tables: []Table{shopOrders, users},
+				Columns: []Column{
+					{Name: "id", Tables: []Table{shopOrders}},
+					{Name: "email", Tables: []Table{users}},
+					{Name: "id", Tables: []Table{users}},
+					{Name: "user_id", Tables: []Table{shopOrders}},
+					{Name: "created_at", Tables: []Table{shopOrders}, Kind: KindDate},
+				},
+			},
+		},
+		{
+			name:  "insert",
+			query: "INSERT INTO t (a, b, c) VALUES (1, 'x', '2024-01-01 10:00:00'), (2.5, CONCAT('y', 'z'), NULL) ON DUPLICATE KEY UPDATE b = VALUES(b)",
+			want: Refs{
+				Tables: []Table{{Name: "t"}},
+				Columns: []Column{
+					{Name: "a", Tables: []Table{{Name: "t"}}, Kind: KindDecimal},
+					{Name: "b", Tables: []Table{{Name: "t"}}, Kind: KindString},
+					{Name: "c", Tables: []Table{{Name: "t"}}, Kind: KindDatetime},
+				},
+			},
+		},
+		{
+			name:  "update",
+			query: "UPDATE LOW_PRIORITY accounts SET balance = balance - 10 WHERE id = 7",
+			want: Refs{
+				Tables: []Table{{Name: "accounts"}},
+				Columns: []Column{
+					{Name: "balance", Tables: []Table{{Name: "accounts"}}},
+					{Name: "id", Tables: []Table{{Name: "accounts"}}, Kind: KindInteger},
+				},
+			},
+		},
+		{
+			name:  "common table expression",
+			query: "WITH recent AS (SELECT id FROM orders WHERE created_at > '2024-01-01') SELECT r.id FROM recent r",
+			want: Refs{
+				Tables: []Table{orders},
+				Columns: []Column{
+					{Name: "id", Tables: []Table{orders}},
+					{Name: "created_at", Tables: []Table{orders}, Kind: KindDate},
+				},
+			},
+		},
+		{
+			name:  "derived table",
+			query: "SELECT COUNT(*) AS n FROM (SELECT user_id FROM orders) d WHERE d.user_id > 0",
+			want: Refs{
+				Tables:  []Table{orders},
+				Columns: []Column{{Name: "user_id", Tables: []Table{orders}}},
+			},
+		},
+		{
+			name:  "functions, variables and hints",
+			query: "SELECT EXTRACT(YEAR FROM created_at), @v := 1 FROM t FORCE INDEX (idx_a) FOR UPDATE",
+			want: Refs{
+				Tables:  []Table{{Name: "t"}},
+				Columns: []Column{{Name: "created_at", Tables: []Table{{Name: "t"}}}},
+			},
+		},
+		{
+			name:  "no tables",
+			query: "SET NAMES utf8mb4",
+			want:  Refs{},
+		},
+	}
+
+	e := NewExtractor()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, e.Extract([]byte(tt.query)))
+		})
+	}
+}
+
+func TestLiteralKind(t *testing.T) {
+	tests := map[string]Kind{
+		"42":                    KindInteger,
+		"-7":                    KindInteger,
+		"3.14":                  KindDecimal,
+		"1e3":                   KindDecimal,
+		"'abc'":                 KindString,
+		`"abc"`:                 KindString,
+		"'2024-02-29'":          KindDate,
+		"'2024-02-29 12:00:01'": KindDatetime,
+		"x'ff'":                 KindBinary,
+		"0x1F":                  KindBinary,
+	}
+	for literal, want := range tests {
+		assert.Equal(t, want, LiteralKind(literal), literal)
+	}
+}
+
+func TestMergeKinds(t *testing.T) {
+	assert.Equal(t, KindInteger, MergeKinds("", KindInteger))
+	assert.Equal(t, KindDecimal, MergeKinds(KindInteger, KindDecimal))
+	assert.Equal(t, KindDatetime, MergeKinds(KindDatetime, KindDate))
+	assert.Equal(t, KindString, MergeKinds(KindDate, KindInteger))
+}
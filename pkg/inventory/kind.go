@@ -0,0 +1,66 @@
+package inventory
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Kind is the type of a column as far as the literals it is compared with or
+// assigned tell. The empty kind is unknown.
+type Kind string
+
+const (
+	KindInteger  Kind = "integer"
+	KindDecimal  Kind = "decimal"
+	KindString   Kind = "string"
+	KindDate     Kind = "date"
+	KindDatetime Kind = "datetime"
+	KindBinary   Kind = "binary"
+)
+
+var (
+	dateLiteral     = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	datetimeLiteral = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?$`)
+)
+
+// LiteralKind returns the kind of a literal as the lexer returns it, quotes
+// included
+func LiteralKind(literal string) Kind {
+	if literal == "" {
+		return ""
+	}
+	switch literal[0] {
+	case '\'', '"':
+		text := strings.Trim(literal, `'"`)
+		switch {
+		case dateLiteral.MatchString(text):
+			return KindDate
+		case datetimeLiteral.MatchString(text):
+			return KindDatetime
+		}
+		return KindString
+	}
+	lower := strings.ToLower(literal)
+	if strings.HasPrefix(lower, "x'") || strings.HasPrefix(lower, "0x") || strings.HasPrefix(lower, "b'") || strings.HasPrefix(lower, "0b") {
+		return KindBinary
+	}
+	if strings.ContainsAny(lower, ".e") {
+		return KindDecimal
+	}
+	return KindInteger
+}
+
+// MergeKinds returns the kind fitting the values of both kinds
+func MergeKinds(a, b Kind) Kind {
+	switch {
+	case a == "" || a == b:
+		return b
+	case b == "":
+		return a
+	case a == KindInteger && b == KindDecimal || a == KindDecimal && b == KindInteger:
+		return KindDecimal
+	case a == KindDate && b == KindDatetime || a == KindDatetime && b == KindDate:
+		return KindDatetime
+	}
+	return KindString
+}
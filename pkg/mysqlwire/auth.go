@@ -0,0 +1,92 @@
+package mysqlwire
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+const (
+	pluginNativePassword  = "mysql_native_password"
+	pluginCachingSHA2     = "caching_sha2_password"
+	cachingSHA2FastOK     = 3
+	cachingSHA2FullAuth   = 4
+	cachingSHA2RequestKey = 2
+)
+
+// scramblePassword computes the auth response of plugin for the server
+// scramble
+func scramblePassword(plugin string, scramble []byte, password string) ([]byte, error) {
+	switch plugin {
+	case pluginNativePassword:
+		return scrambleNativePassword(scramble, password), nil
+	case pluginCachingSHA2:
+		return scrambleSHA256Password(scramble, password), nil
+	default:
+		return nil, fmt.Errorf("unsupported auth plugin %q", plugin)
+	}
+}
+
+// scrambleNativePassword is SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password)))
+func scrambleNativePassword(scramble []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+
+	h := sha1.New()
+	h.Write(scramble[:20])
+	h.Write(stage2[:])
+	out := h.Sum(nil)
+	for i := range out {
+		out[i] ^= stage1[i]
+	}
+	return out
+}
+
+// scrambleSHA256Password is SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble)
+func scrambleSHA256Password(scramble []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	m1 := sha256.Sum256([]byte(password))
+	m2 := sha256.Sum256(m1[:])
+
+	h := sha256.New()
+	h.Write(m2[:])
+	h.Write(scramble[:20])
+	out := h.Sum(nil)
+	for i := range out {
+		out[i] ^= m1[i]
+	}
+	return out
+}
+
+// encryptPassword encrypts the NUL-terminated password xored with the
+// scramble using the server public key, for caching_sha2_password full
+// authentication without TLS
+func encryptPassword(password string, scramble, pemKey []byte) ([]byte, error) {
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, fmt.Errorf("invalid server public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing server public key: %w", err)
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("server public key is not RSA")
+	}
+
+	plain := append([]byte(password), 0)
+	for i := range plain {
+		plain[i] ^= scramble[i%20]
+	}
+	return rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, plain, nil)
+}
@@ -0,0 +1,522 @@
+// Package mysqlwire is a minimal MySQL client speaking the wire protocol
+// directly. It only executes text queries and discards their results, which
+// is all a load generator needs, without the interface and allocation
+// overhead of database/sql.
+package mysqlwire
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	clientLongPassword     = 0x00000001
+	clientLongFlag         = 0x00000004
+	clientConnectWithDB    = 0x00000008
+	clientProtocol41       = 0x00000200
+	clientTransactions     = 0x00002000
+	clientSecureConnection = 0x00008000
+	clientMultiResults     = 0x00020000
+	clientPluginAuth       = 0x00080000
+	clientPluginAuthLenEnc = 0x00200000
+	clientDeprecateEOF     = 0x01000000
+
+	clientFlags = clientLongPassword | clientLongFlag | clientProtocol41 | clientTransactions |
+		clientSecureConnection | clientMultiResults | clientPluginAuth | clientPluginAuthLenEnc |
+		clientDeprecateEOF
+
+	serverMoreResultsExist = 0x0008
+
+	comQuit   = 0x01
+	comInitDB = 0x02
+	comQuery  = 0x03
+
+	iOK          = 0x00
+	iAuthMore    = 0x01
+	iLocalInfile = 0xfb
+	iEOF         = 0xfe
+	iERR         = 0xff
+
+	// utf8mb4_general_ci
+	defaultCollation = 45
+)
+
+var (
+	// ErrBadConn is returned by a connection that failed earlier and must be
+	// discarded
+	ErrBadConn = errors.New("mysqlwire: bad connection")
+	// ErrLocalInfile is returned for LOAD DATA LOCAL INFILE statements
+	ErrLocalInfile = errors.New("mysqlwire: LOAD DATA LOCAL INFILE is not supported")
+	// ErrResultTruncated is returned when a result set exceeds the configured
+	// limits. The rest of the result is not read and the connection is broken.
+	ErrResultTruncated = errors.New("mysqlwire: result set truncated")
+)
+
+// Error is an error packet sent by the server
+type Error struct {
+	Number   uint16
+	SQLState string
+	Message  string
+}
+
+func (e *Error) Error() string {
+	if e.SQLState != "" {
+		return fmt.Sprintf("Error %d (%s): %s", e.Number, e.SQLState, e.Message)
+	}
+	return fmt.Sprintf("Error %d: %s", e.Number, e.Message)
+}
+
+type Config struct {
+	// Net is "tcp" or "unix", defaults to "tcp"
+	Net         string
+	Addr        string
+	User        string
+	Password    string
+	DBName      string
+	DialTimeout time.Duration
+	// DialContext replaces the default dialer, e.g. to spread connections
+	// over the addresses behind a name
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+	// MaxResultRows and MaxResultBytes stop reading a result set past them,
+	// zero means unlimited
+	MaxResultRows  int64
+	MaxResultBytes int64
+}
+
+// Conn is a single connection. It is not safe for concurrent use.
+type Conn struct {
+	conn   net.Conn
+	r      *bufio.Reader
+	rbuf   []byte
+	wbuf   []byte
+	seq    byte
+	flags  uint32
+	broken bool
+	schema string
+	// firstResponse is when the first packet answering the last command
+	// arrived
+	firstResponse time.Time
+
+	maxResultRows  int64
+	maxResultBytes int64
+
+	ConnectionID  uint32
+	ServerVersion string
+}
+
+// Dial connects and authenticates to the server
+func Dial(ctx context.Context, cfg Config) (*Conn, error) {
+	network := cfg.Net
+	if network == "" {
+		network = "tcp"
+	}
+	dial := cfg.DialContext
+	if dial == nil {
+		dialer := net.Dialer{Timeout: cfg.DialTimeout, KeepAlive: 30 * time.Second}
+		dial = dialer.DialContext
+	}
+	nc, err := dial(ctx, network, cfg.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("error dialing %s: %w", cfg.Addr, err)
+	}
+
+	c := &Conn{
+		conn: nc,
+		r:    bufio.NewReaderSize(nc, 16*1024),
+		rbuf: make([]byte, 0, 16*1024),
+		wbuf: make([]byte, 0, 4*1024),
+
+		maxResultRows:  cfg.MaxResultRows,
+		maxResultBytes: cfg.MaxResultBytes,
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+	if err := c.handshake(cfg); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+	c.schema = cfg.DBName
+	return c, nil
+}
+
+func (c *Conn) handshake(cfg Config) error {
+	data, err := c.readPacket()
+	if err != nil {
+		return err
+	}
+	if data[0] == iERR {
+		return parseError(data)
+	}
+	if data[0] != 10 {
+		return fmt.Errorf("unsupported protocol version %d", data[0])
+	}
+
+	pos := 1
+	end := bytes.IndexByte(data[pos:], 0)
+	if end < 0 {
+		return fmt.Errorf("malformed handshake packet")
+	}
+	c.ServerVersion = string(data[pos : pos+end])
+	pos += end + 1
+	if len(data) < pos+4+8+1+2 {
+		return fmt.Errorf("malformed handshake packet")
+	}
+	c.ConnectionID = binary.LittleEndian.Uint32(data[pos:])
+	pos += 4
+	scramble := make([]byte, 0, 20)
+	scramble = append(scramble, data[pos:pos+8]...)
+	pos += 8 + 1
+	serverFlags := uint32(binary.LittleEndian.Uint16(data[pos:]))
+	pos += 2
+
+	plugin := pluginNativePassword
+	if len(data) >= pos+16+12 {
+		// charset [1], status [2]
+		pos += 3
+		serverFlags |= uint32(binary.LittleEndian.Uint16(data[pos:])) << 16
+		// auth data length [1], reserved [10]
+		pos += 2 + 11
+		scramble = append(scramble, data[pos:pos+12]...)
+		pos += 13
+		if serverFlags&clientPluginAuth != 0 && pos < len(data) {
+			name := data[pos:]
+			if end := bytes.IndexByte(name, 0); end >= 0 {
+				name = name[:end]
+			}
+			if len(name) > 0 {
+				plugin = string(name)
+			}
+		}
+	}
+	if serverFlags&clientProtocol41 == 0 {
+		return fmt.Errorf("server does not support protocol 4.1")
+	}
+
+	c.flags = clientFlags & serverFlags
+	if cfg.DBName != "" {
+		c.flags |= clientConnectWithDB & serverFlags
+	}
+
+	authResp, err := scramblePassword(plugin, scramble, cfg.Password)
+	if err != nil {
+		// Answer with the default plugin and let the server switch
+		plugin = pluginNativePassword
+		authResp = scrambleNativePassword(scramble, cfg.Password)
+	}
+
+	buf := c.newPacket()
+	buf = binary.LittleEndian.AppendUint32(buf, c.flags)
+	buf = binary.LittleEndian.AppendUint32(buf, maxPacketSize)
+	buf = append(buf, defaultCollation)
+	buf = append(buf, make([]byte, 23)...)
+	buf = append(append(buf, cfg.User...), 0)
+	if c.flags&clientPluginAuthLenEnc != 0 {
+		buf = appendLenEnc(buf, uint64(len(authResp)))
+	} else {
+		buf = append(buf, byte(len(authResp)))
+	}
+	buf = append(buf, authResp...)
+	if c.flags&clientConnectWithDB != 0 {
+		buf = append(append(buf, cfg.DBName...), 0)
+	}
+	if c.flags&clientPluginAuth != 0 {
+		buf = append(append(buf, plugin...), 0)
+	}
+	c.wbuf = buf
+	if err := c.writePacket(buf); err != nil {
+		return err
+	}
+
+	return c.auth(cfg.Password, plugin, scramble)
+}
+
+// auth follows the server through auth switches and caching_sha2_password
+// exchanges until the final OK or error
+func (c *Conn) auth(password, plugin string, scramble []byte) error {
+	requestedKey := false
+	for {
+		data, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+
+		switch data[0] {
+		case iOK:
+			return nil
+		case iERR:
+			return parseError(data)
+		case iEOF:
+			end := bytes.IndexByte(data[1:], 0)
+			if end < 0 {
+				return fmt.Errorf("unsupported old password auth switch")
+			}
+			plugin = string(data[1 : 1+end])
+			scramble = bytes.TrimSuffix(append([]byte(nil), data[2+end:]...), []byte{0})
+			authResp, err := scramblePassword(plugin, scramble, password)
+			if err != nil {
+				return err
+			}
+			if err := c.writePacket(append(c.newPacket(), authResp...)); err != nil {
+				return err
+			}
+		case iAuthMore:
+			if plugin != pluginCachingSHA2 || len(data) < 2 {
+				return fmt.Errorf("unexpected auth data for plugin %q", plugin)
+			}
+			switch {
+			case requestedKey:
+				encrypted, err := encryptPassword(password, scramble, data[1:])
+				if err != nil {
+					return err
+				}
+				if err := c.writePacket(append(c.newPacket(), encrypted...)); err != nil {
+					return err
+				}
+			case data[1] == cachingSHA2FastOK:
+				// The OK packet follows
+			case data[1] == cachingSHA2FullAuth:
+				requestedKey = true
+				if err := c.writePacket(append(c.newPacket(), cachingSHA2RequestKey)); err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unexpected caching_sha2_password state %d", data[1])
+			}
+		default:
+			return fmt.Errorf("unexpected auth packet 0x%02x", data[0])
+		}
+	}
+}
+
+// Exec runs query and discards its results. A server error is returned as
+// *Error and leaves the connection usable; any other error breaks it.
+func (c *Conn) Exec(ctx context.Context, query string) error {
+	return c.command(ctx, comQuery, query)
+}
+
+// Timings splits the duration of an execution
+type Timings struct {
+	// Wait is the time spent acquiring a connection, dialing included
+	Wait time.Duration
+	// Execute runs from sending the query to the first response packet,
+	// network round trip and server execution
+	Execute time.Duration
+	// Drain is the time spent reading the rest of the results
+	Drain time.Duration
+	// Addr is the remote address of the connection, only set by the pool
+	Addr string
+}
+
+// ExecTimed is Exec, also returning how long the server took to answer and
+// how long the results took to read
+func (c *Conn) ExecTimed(ctx context.Context, query string) (Timings, error) {
+	start := time.Now()
+	c.firstResponse = time.Time{}
+	err := c.command(ctx, comQuery, query)
+	end := time.Now()
+	if c.firstResponse.IsZero() {
+		return Timings{Execute: end.Sub(start)}, err
+	}
+	return Timings{Execute: c.firstResponse.Sub(start), Drain: end.Sub(c.firstResponse)}, err
+}
+
+// UseSchema changes the default schema of the connection, skipping the
+// round trip when it is already selected
+func (c *Conn) UseSchema(ctx context.Context, schema string) error {
+	if schema == c.schema {
+		return nil
+	}
+	if err := c.command(ctx, comInitDB, schema); err != nil {
+		return err
+	}
+	c.schema = schema
+	return nil
+}
+
+func (c *Conn) command(ctx context.Context, cmd byte, arg string) error {
+	if c.broken {
+		return ErrBadConn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetDeadline(deadline)
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() {
+			// Unblocks pending reads and writes, the connection is lost
+			c.conn.SetDeadline(time.Unix(1, 0))
+		})
+		defer func() {
+			if !stop() {
+				// The deadline may have been cut after the query completed
+				c.broken = true
+			}
+		}()
+	}
+
+	c.seq = 0
+	buf := append(c.newPacket(), cmd)
+	buf = append(buf, arg...)
+	c.wbuf = buf
+	if err := c.writePacket(buf); err != nil {
+		return err
+	}
+	return c.readResults()
+}
+
+func (c *Conn) readResults() error {
+	for {
+		data, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if c.firstResponse.IsZero() {
+			c.firstResponse = time.Now()
+		}
+
+		var status uint16
+		switch data[0] {
+		case iOK:
+			status, err = parseOKStatus(data)
+			if err != nil {
+				return c.fail(err)
+			}
+		case iERR:
+			return parseError(data)
+		case iLocalInfile:
+			// An empty packet tells the server there is no file
+			if err := c.writePacket(c.newPacket()); err != nil {
+				return err
+			}
+			if data, err = c.readPacket(); err != nil {
+				return err
+			}
+			if data[0] == iERR {
+				return parseError(data)
+			}
+			return ErrLocalInfile
+		default:
+			if status, err = c.discardResultSet(data); err != nil {
+				return err
+			}
+		}
+
+		if status&serverMoreResultsExist == 0 {
+			return nil
+		}
+	}
+}
+
+// discardResultSet skips the column definitions and rows of a result set
+// whose column count packet is data, returning the final status flags
+func (c *Conn) discardResultSet(data []byte) (uint16, error) {
+	columns, _, err := readLenEnc(data)
+	if err != nil {
+		return 0, c.fail(fmt.Errorf("malformed column count: %w", err))
+	}
+	for range columns {
+		if _, err := c.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+	deprecateEOF := c.flags&clientDeprecateEOF != 0
+	if !deprecateEOF {
+		if _, err := c.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+
+	var rows, size int64
+	for {
+		data, err := c.readPacket()
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case data[0] == iERR:
+			return 0, parseError(data)
+		case data[0] == iEOF && deprecateEOF && len(data) < maxPacketSize:
+			status, err := parseOKStatus(data)
+			if err != nil {
+				return 0, c.fail(err)
+			}
+			return status, nil
+		case data[0] == iEOF && !deprecateEOF && len(data) < 9:
+			if len(data) < 5 {
+				return 0, nil
+			}
+			return binary.LittleEndian.Uint16(data[3:]), nil
+		}
+
+		rows++
+		size += int64(len(data))
+		if c.maxResultRows > 0 && rows > c.maxResultRows || c.maxResultBytes > 0 && size > c.maxResultBytes {
+			return 0, c.fail(ErrResultTruncated)
+		}
+	}
+}
+
+// Close sends COM_QUIT and closes the connection
+func (c *Conn) Close() error {
+	if !c.broken {
+		c.seq = 0
+		c.conn.SetDeadline(time.Now().Add(time.Second))
+		c.writePacket(append(c.newPacket(), comQuit))
+	}
+	c.broken = true
+	return c.conn.Close()
+}
+
+// RemoteAddr returns the address the connection is connected to
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Broken reports whether the connection failed and must be discarded
+func (c *Conn) Broken() bool {
+	return c.broken
+}
+
+func (c *Conn) fail(err error) error {
+	c.broken = true
+	return err
+}
+
+func parseOKStatus(data []byte) (uint16, error) {
+	pos := 1
+	for range 2 {
+		// affected rows, last insert id
+		_, n, err := readLenEnc(data[pos:])
+		if err != nil {
+			return 0, fmt.Errorf("malformed OK packet: %w", err)
+		}
+		pos += n
+	}
+	if len(data) < pos+2 {
+		return 0, nil
+	}
+	return binary.LittleEndian.Uint16(data[pos:]), nil
+}
+
+func parseError(data []byte) error {
+	if len(data) < 3 {
+		return &Error{Message: "malformed error packet"}
+	}
+	e := &Error{Number: binary.LittleEndian.Uint16(data[1:3])}
+	msg := data[3:]
+	if len(msg) >= 6 && msg[0] == '#' {
+		e.SQLState = string(msg[1:6])
+		msg = msg[6:]
+	}
+	e.Message = string(msg)
+	return e
+}
@@ -0,0 +1,499 @@
+package mysqlwire
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"database/sql"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeServer speaks enough of the server side of the protocol to test the
+// client: it authenticates one user and answers COM_QUERY with canned
+// results depending on the query text.
+type fakeServer struct {
+	ln           net.Listener
+	password     string
+	plugin       string
+	fullAuth     bool
+	deprecateEOF bool
+	key          *rsa.PrivateKey
+
+	conns   atomic.Int64
+	queries atomic.Int64
+	initDBs atomic.Int64
+	wg      sync.WaitGroup
+}
+
+func newFakeServer(t testing.TB, opts ...func(*fakeServer)) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeServer{ln: ln, password: "secret", plugin: pluginNativePassword, deprecateEOF: true}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *fakeServer) config() Config {
+	return Config{Addr: s.ln.Addr().String(), User: "root", Password: s.password, DBName: "test"}
+}
+
+func (s *fakeServer) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.conns.Add(1)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer nc.Close()
+			s.handle(&fakeConn{conn: nc, r: bufio.NewReader(nc)})
+		}()
+	}
+}
+
+type fakeConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	seq  byte
+}
+
+func (c *fakeConn) read() ([]byte, error) {
+	var payload []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return nil, err
+		}
+		size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+		c.seq = header[3] + 1
+		chunk := make([]byte, size)
+		if _, err := io.ReadFull(c.r, chunk); err != nil {
+			return nil, err
+		}
+		payload = append(payload, chunk...)
+		if size < maxPacketSize {
+			return payload, nil
+		}
+	}
+}
+
+func (c *fakeConn) write(payload []byte) error {
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), c.seq}
+	c.seq++
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
+
+func okPacket(status uint16) []byte {
+	return binary.LittleEndian.AppendUint16([]byte{iOK, 0, 0}, status)
+}
+
+func errPacket(number uint16, state, msg string) []byte {
+	b := binary.LittleEndian.AppendUint16([]byte{iERR}, number)
+	return append(append(append(b, '#'), state...), msg...)
+}
+
+func (s *fakeServer) handle(c *fakeConn) {
+	scramble := []byte("abcdefghijklmnopqrst")
+	serverFlags := uint32(clientFlags | clientConnectWithDB)
+	if !s.deprecateEOF {
+		serverFlags &^= clientDeprecateEOF
+	}
+
+	hs := append([]byte{10}, "8.0.0-fake\x00"...)
+	hs = binary.LittleEndian.AppendUint32(hs, 7)
+	hs = append(append(hs, scramble[:8]...), 0)
+	hs = binary.LittleEndian.AppendUint16(hs, uint16(serverFlags))
+	hs = append(hs, defaultCollation, 2, 0)
+	hs = binary.LittleEndian.AppendUint16(hs, uint16(serverFlags>>16))
+	hs = append(hs, 21)
+	hs = append(hs, make([]byte, 10)...)
+	hs = append(append(hs, scramble[8:]...), 0)
+	hs = append(append(hs, s.plugin...), 0)
+	if err := c.write(hs); err != nil {
+		return
+	}
+
+	resp, err := c.read()
+	if err != nil {
+		return
+	}
+	flags := binary.LittleEndian.Uint32(resp)
+	pos := 4 + 4 + 1 + 23
+	pos += bytes.IndexByte(resp[pos:], 0) + 1
+	authLen, n, _ := readLenEnc(resp[pos:])
+	authResp := resp[pos+n : pos+n+int(authLen)]
+
+	if !s.authenticate(c, scramble, authResp) {
+		c.write(errPacket(1045, "28000", "Access denied"))
+		return
+	}
+	if err := c.write(okPacket(2)); err != nil {
+		return
+	}
+
+	deprecateEOF := flags&serverFlags&clientDeprecateEOF != 0
+	for {
+		c.seq = 0
+		cmd, err := c.read()
+		if err != nil {
+			return
+		}
+		switch cmd[0] {
+		case comQuit:
+			return
+		case comQuery:
+			s.queries.Add(1)
+			if err := s.query(c, string(cmd[1:]), deprecateEOF); err != nil {
+				return
+			}
+		case comInitDB:
+			s.initDBs.Add(1)
+			if err := c.write(okPacket(2)); err != nil {
+				return
+			}
+		default:
+			if err := c.write(okPacket(2)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (s *fakeServer) authenticate(c *fakeConn, scramble, authResp []byte) bool {
+	switch {
+	case s.plugin == pluginNativePassword:
+		return bytes.Equal(authResp, scrambleNativePassword(scramble, s.password))
+	case !s.fullAuth:
+		if !bytes.Equal(authResp, scrambleSHA256Password(scramble, s.password)) {
+			return false
+		}
+		return c.write([]byte{iAuthMore, cachingSHA2FastOK}) == nil
+	default:
+		if c.write([]byte{iAuthMore, cachingSHA2FullAuth}) != nil {
+			return false
+		}
+		if req, err := c.read(); err != nil || !bytes.Equal(req, []byte{cachingSHA2RequestKey}) {
+			return false
+		}
+		der, _ := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+		if c.write(append([]byte{iAuthMore}, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})...)) != nil {
+			return false
+		}
+		encrypted, err := c.read()
+		if err != nil {
+			return false
+		}
+		plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, s.key, encrypted, nil)
+		if err != nil {
+			return false
+		}
+		for i := range plain {
+			plain[i] ^= scramble[i%20]
+		}
+		return string(plain) == s.password+"\x00"
+	}
+}
+
+// query answers "ERROR" with an error packet, "SELECT n" with n rows,
+// "MULTI" with an OK followed by a result set, "SLEEP" never and anything
+// else with OK
+func (s *fakeServer) query(c *fakeConn, q string, deprecateEOF bool) error {
+	switch {
+	case q == "ERROR":
+		return c.write(errPacket(1064, "42000", "You have an error in your SQL syntax"))
+	case q == "SLEEP":
+		c.read()
+		return io.EOF
+	case q == "MULTI":
+		if err := c.write(okPacket(2 | serverMoreResultsExist)); err != nil {
+			return err
+		}
+		return s.resultSet(c, 3, deprecateEOF)
+	case strings.HasPrefix(q, "SELECT "):
+		var rows int
+		for _, d := range q[len("SELECT "):] {
+			rows = rows*10 + int(d-'0')
+		}
+		return s.resultSet(c, rows, deprecateEOF)
+	default:
+		return c.write(okPacket(2))
+	}
+}
+
+func (s *fakeServer) resultSet(c *fakeConn, rows int, deprecateEOF bool) error {
+	eof := []byte{iEOF, 0, 0, 2, 0}
+	if deprecateEOF {
+		eof = []byte{iEOF, 0, 0, 2, 0, 0, 0}
+	}
+	if err := c.write([]byte{1}); err != nil {
+		return err
+	}
+	if err := c.write(append([]byte{3}, "def\x00\x00\x00\x01v\x00\x0c\x2d\x00\x10\x00\x00\x00\xfd\x00\x00\x00\x00\x00"...)); err != nil {
+		return err
+	}
+	if !deprecateEOF {
+		if err := c.write(eof); err != nil {
+			return err
+		}
+	}
+	for range rows {
+		if err := c.write(append([]byte{5}, "value"...)); err != nil {
+			return err
+		}
+	}
+	return c.write(eof)
+}
+
+func TestExec(t *testing.T) {
+	for _, deprecateEOF := range []bool{true, false} {
+		s := newFakeServer(t, func(s *fakeServer) { s.deprecateEOF = deprecateEOF })
+		c, err := Dial(context.Background(), s.config())
+		require.NoError(t, err)
+		assert.Equal(t, "8.0.0-fake", c.ServerVersion)
+		assert.Equal(t, uint32(7), c.ConnectionID)
+
+		require.NoError(t, c.Exec(context.Background(), "UPDATE t SET a = 1"))
+		require.NoError(t, c.Exec(context.Background(), "SELECT 100"))
+		require.NoError(t, c.Exec(context.Background(), "MULTI"))
+
+		err = c.Exec(context.Background(), "ERROR")
+		var mysqlErr *Error
+		require.True(t, errors.As(err, &mysqlErr))
+		assert.Equal(t, uint16(1064), mysqlErr.Number)
+		assert.Equal(t, "42000", mysqlErr.SQLState)
+		assert.False(t, c.Broken())
+
+		require.NoError(t, c.Exec(context.Background(), "SELECT 1"))
+		require.NoError(t, c.Close())
+		assert.ErrorIs(t, c.Exec(context.Background(), "SELECT 1"), ErrBadConn)
+	}
+}
+
+func TestExecResultLimits(t *testing.T) {
+	s := newFakeServer(t)
+	for _, cfg := range []Config{{MaxResultRows: 10}, {MaxResultBytes: 60}} {
+		serverCfg := s.config()
+		cfg.Addr, cfg.User, cfg.Password = serverCfg.Addr, serverCfg.User, serverCfg.Password
+		c, err := Dial(context.Background(), cfg)
+		require.NoError(t, err)
+
+		require.NoError(t, c.Exec(context.Background(), "SELECT 10"))
+		assert.ErrorIs(t, c.Exec(context.Background(), "SELECT 11"), ErrResultTruncated)
+		assert.True(t, c.Broken())
+		c.Close()
+	}
+}
+
+func TestExecLargeQuery(t *testing.T) {
+	s := newFakeServer(t)
+	c, err := Dial(context.Background(), s.config())
+	require.NoError(t, err)
+	defer c.Close()
+
+	query := "INSERT INTO t VALUES ('" + strings.Repeat("x", maxPacketSize) + "')"
+	require.NoError(t, c.Exec(context.Background(), query))
+	require.NoError(t, c.Exec(context.Background(), "SELECT 1"))
+}
+
+func TestAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		opt  func(*fakeServer)
+	}{
+		{"native", func(s *fakeServer) {}},
+		{"caching_sha2 fast", func(s *fakeServer) { s.plugin = pluginCachingSHA2 }},
+		{"caching_sha2 full", func(s *fakeServer) { s.plugin, s.fullAuth, s.key = pluginCachingSHA2, true, key }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newFakeServer(t, tt.opt)
+			c, err := Dial(context.Background(), s.config())
+			require.NoError(t, err)
+			require.NoError(t, c.Exec(context.Background(), "SELECT 1"))
+			c.Close()
+
+			cfg := s.config()
+			cfg.Password = "wrong"
+			_, err = Dial(context.Background(), cfg)
+			var mysqlErr *Error
+			require.True(t, errors.As(err, &mysqlErr))
+			assert.Equal(t, uint16(1045), mysqlErr.Number)
+		})
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	s := newFakeServer(t)
+	cfg := s.config()
+	addr := cfg.Addr
+	cfg.Addr = "db.example:3306"
+	var dialed string
+	cfg.DialContext = func(ctx context.Context, network, a string) (net.Conn, error) {
+		dialed = a
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+
+	c, err := Dial(context.Background(), cfg)
+	require.NoError(t, err)
+	defer c.Close()
+	assert.Equal(t, "db.example:3306", dialed)
+	require.NoError(t, c.Exec(context.Background(), "SELECT 1"))
+}
+
+func TestExecCanceled(t *testing.T) {
+	s := newFakeServer(t)
+	c, err := Dial(context.Background(), s.config())
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, c.Exec(ctx, "SLEEP"))
+	assert.True(t, c.Broken())
+}
+
+func TestPool(t *testing.T) {
+	s := newFakeServer(t)
+	p := NewPool(s.config(), 2)
+	defer p.Close()
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				assert.NoError(t, p.Exec(context.Background(), "SELECT 3"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, s.conns.Load(), int64(2))
+	assert.Equal(t, int64(400), s.queries.Load())
+
+	require.NoError(t, p.Close())
+	assert.ErrorIs(t, p.Exec(context.Background(), "SELECT 1"), ErrPoolClosed)
+}
+
+func TestPoolExecIn(t *testing.T) {
+	s := newFakeServer(t)
+	p := NewPool(s.config(), 1)
+	defer p.Close()
+
+	for _, schema := range []string{"test", "shard_001", "shard_001", "shard_002", ""} {
+		require.NoError(t, p.ExecIn(context.Background(), schema, "SELECT 1"))
+	}
+	// The connection starts in "test" and only switches twice
+	assert.Equal(t, int64(2), s.initDBs.Load())
+	assert.Equal(t, int64(5), s.queries.Load())
+}
+
+func TestPoolExecInTimed(t *testing.T) {
+	s := newFakeServer(t)
+	p := NewPool(s.config(), 1)
+	defer p.Close()
+
+	for _, query := range []string{"UPDATE t SET a = 1", "SELECT 100"} {
+		start := time.Now()
+		timings, err := p.ExecInTimed(context.Background(), "shard_001", query)
+		require.NoError(t, err)
+		assert.Positive(t, timings.Wait, query)
+		assert.Positive(t, timings.Execute, query)
+		assert.Equal(t, s.config().Addr, timings.Addr, query)
+		assert.LessOrEqual(t, timings.Wait+timings.Execute+timings.Drain, time.Since(start), query)
+	}
+}
+
+func TestPoolCloseIdle(t *testing.T) {
+	s := newFakeServer(t)
+	p := NewPool(s.config(), 1)
+	defer p.Close()
+
+	ctx := context.Background()
+	require.NoError(t, p.Exec(ctx, "SELECT 1"))
+	require.NoError(t, p.Exec(ctx, "SELECT 1"))
+	assert.EqualValues(t, 1, s.conns.Load())
+
+	p.CloseIdle()
+	require.NoError(t, p.Exec(ctx, "SELECT 1"))
+	assert.EqualValues(t, 2, s.conns.Load())
+}
+
+func BenchmarkExec(b *testing.B) {
+	for _, query := range []string{"UPDATE t SET a = 1", "SELECT 20"} {
+		b.Run("raw/"+query, func(b *testing.B) {
+			s := newFakeServer(b)
+			p := NewPool(s.config(), 8)
+			defer p.Close()
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := p.Exec(ctx, query); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+
+		b.Run("sql/"+query, func(b *testing.B) {
+			s := newFakeServer(b)
+			cfg := mysql.NewConfig()
+			cfg.Addr, cfg.User, cfg.Passwd, cfg.DBName = s.ln.Addr().String(), "root", s.password, "test"
+			cfg.AllowNativePasswords = true
+			connector, err := mysql.NewConnector(cfg)
+			require.NoError(b, err)
+			db := sql.OpenDB(connector)
+			db.SetMaxOpenConns(8)
+			db.SetMaxIdleConns(8)
+			defer db.Close()
+			ctx := context.Background()
+
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if _, err := db.ExecContext(ctx, query); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
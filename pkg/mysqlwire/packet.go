@@ -0,0 +1,120 @@
+package mysqlwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const maxPacketSize = 1<<24 - 1
+
+// readPacket reads one logical packet into c.rbuf, joining packets split at
+// the 16MB boundary. The returned slice is only valid until the next read.
+func (c *Conn) readPacket() ([]byte, error) {
+	c.rbuf = c.rbuf[:0]
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(c.r, header[:]); err != nil {
+			return nil, c.fail(fmt.Errorf("error reading packet header: %w", err))
+		}
+		size := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+		if header[3] != c.seq {
+			return nil, c.fail(fmt.Errorf("packet out of sequence: got %d, want %d", header[3], c.seq))
+		}
+		c.seq++
+
+		start := len(c.rbuf)
+		if cap(c.rbuf)-start < size {
+			grown := make([]byte, start, start+size)
+			copy(grown, c.rbuf)
+			c.rbuf = grown
+		}
+		c.rbuf = c.rbuf[:start+size]
+		if _, err := io.ReadFull(c.r, c.rbuf[start:]); err != nil {
+			return nil, c.fail(fmt.Errorf("error reading packet payload: %w", err))
+		}
+		if size < maxPacketSize {
+			return c.rbuf, nil
+		}
+	}
+}
+
+// writePacket sends buf, whose first 4 bytes are reserved for the header,
+// splitting the payload at the 16MB boundary.
+func (c *Conn) writePacket(buf []byte) error {
+	payload := buf[4:]
+	if len(payload) < maxPacketSize {
+		c.putHeader(buf, len(payload))
+		if _, err := c.conn.Write(buf); err != nil {
+			return c.fail(fmt.Errorf("error writing packet: %w", err))
+		}
+		return nil
+	}
+
+	for {
+		size := min(len(payload), maxPacketSize)
+		var header [4]byte
+		c.putHeader(header[:], size)
+		if _, err := c.conn.Write(header[:]); err != nil {
+			return c.fail(fmt.Errorf("error writing packet: %w", err))
+		}
+		if _, err := c.conn.Write(payload[:size]); err != nil {
+			return c.fail(fmt.Errorf("error writing packet: %w", err))
+		}
+		payload = payload[size:]
+		if size < maxPacketSize {
+			return nil
+		}
+	}
+}
+
+func (c *Conn) putHeader(header []byte, size int) {
+	header[0], header[1], header[2] = byte(size), byte(size>>8), byte(size>>16)
+	header[3] = c.seq
+	c.seq++
+}
+
+// newPacket returns c.wbuf reset to the 4 header bytes
+func (c *Conn) newPacket() []byte {
+	return append(c.wbuf[:0], 0, 0, 0, 0)
+}
+
+// readLenEnc decodes a length-encoded integer, returning it and the number
+// of bytes consumed
+func readLenEnc(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, io.ErrUnexpectedEOF
+	}
+	switch b[0] {
+	case 0xfc:
+		if len(b) < 3 {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		return uint64(binary.LittleEndian.Uint16(b[1:])), 3, nil
+	case 0xfd:
+		if len(b) < 4 {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, 4, nil
+	case 0xfe:
+		if len(b) < 9 {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		return binary.LittleEndian.Uint64(b[1:]), 9, nil
+	default:
+		return uint64(b[0]), 1, nil
+	}
+}
+
+func appendLenEnc(b []byte, n uint64) []byte {
+	switch {
+	case n < 0xfb:
+		return append(b, byte(n))
+	case n < 1<<16:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	default:
+		return binary.LittleEndian.AppendUint64(append(b, 0xfe), n)
+	}
+}
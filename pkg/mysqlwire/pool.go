@@ -0,0 +1,129 @@
+package mysqlwire
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrPoolClosed = errors.New("mysqlwire: pool closed")
+
+// Pool keeps up to size connections, dialing them lazily
+type Pool struct {
+	cfg  Config
+	sem  chan struct{}
+	idle chan *Conn
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func NewPool(cfg Config, size int) *Pool {
+	return &Pool{
+		cfg:  cfg,
+		sem:  make(chan struct{}, size),
+		idle: make(chan *Conn, size),
+	}
+}
+
+// Exec runs query on a pooled connection and discards its results
+func (p *Pool) Exec(ctx context.Context, query string) error {
+	c, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.Exec(ctx, query)
+	p.put(c)
+	return err
+}
+
+// ExecIn runs query in schema on a pooled connection and discards its
+// results
+func (p *Pool) ExecIn(ctx context.Context, schema, query string) error {
+	_, err := p.ExecInTimed(ctx, schema, query)
+	return err
+}
+
+// ExecInTimed is ExecIn, also returning the timings of the execution. The
+// schema change counts toward the wait.
+func (p *Pool) ExecInTimed(ctx context.Context, schema, query string) (Timings, error) {
+	start := time.Now()
+	c, err := p.get(ctx)
+	if err != nil {
+		return Timings{Wait: time.Since(start)}, err
+	}
+	defer p.put(c)
+	if schema != "" {
+		if err := c.UseSchema(ctx, schema); err != nil {
+			return Timings{Wait: time.Since(start)}, err
+		}
+	}
+	wait := time.Since(start)
+	timings, err := c.ExecTimed(ctx, query)
+	timings.Wait = wait
+	timings.Addr = c.RemoteAddr().String()
+	return timings, err
+}
+
+func (p *Pool) get(ctx context.Context) (*Conn, error) {
+	select {
+	case p.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	p.mu.Lock()
+	closed := p.closed
+	p.mu.Unlock()
+	if closed {
+		<-p.sem
+		return nil, ErrPoolClosed
+	}
+
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+	c, err := Dial(ctx, p.cfg)
+	if err != nil {
+		<-p.sem
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *Pool) put(c *Conn) {
+	p.mu.Lock()
+	if c.Broken() || p.closed {
+		c.Close()
+	} else {
+		p.idle <- c
+	}
+	p.mu.Unlock()
+	<-p.sem
+}
+
+// Close closes the idle connections. Connections in use are closed when
+// they are returned.
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	p.mu.Unlock()
+	p.CloseIdle()
+	return nil
+}
+
+// CloseIdle closes the idle connections and keeps the pool open, the next
+// executions dial fresh sessions
+func (p *Pool) CloseIdle() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package query
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// CacheRecordSize is the size of the fixed part of a query in the cache file
+// written by the collector: hash, fingerprint hash, offset and length as
+// little endian uint64
+const CacheRecordSize = 32
+
+// cacheMagic starts version 2 cache files, whose records are followed by the
+// length of the fingerprint text as a little endian uint32 and the text.
+// Version 1 files have no header and only hold the fixed part.
+var cacheMagic = [8]byte{'M', 'L', 'T', 'C', 'A', 'C', 'H', '2'}
+
+// cacheMagicV3 starts version 3 cache files, whose records also hold the
+// session and the position in the session as little endian uint64 between
+// the fixed part and the fingerprint
+var cacheMagicV3 = [8]byte{'M', 'L', 'T', 'C', 'A', 'C', 'H', '3'}
+
+// cacheMagicV4 starts version 4 cache files, whose records also hold the
+// capture timestamp as a little endian uint64 after the session
+var cacheMagicV4 = [8]byte{'M', 'L', 'T', 'C', 'A', 'C', 'H', '4'}
+
+// maxCacheFingerprintLen guards against reading garbage as a length
+const maxCacheFingerprintLen = 16 << 20
+
+// CacheReader reads the queries of a cache file of any version
+type CacheReader struct {
+	r       *bufio.Reader
+	version int
+}
+
+// NewCacheReader detects the version of the cache file read from r
+func NewCacheReader(r io.Reader) (*CacheReader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	c := &CacheReader{r: br, version: 1}
+	header, err := br.Peek(len(cacheMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error reading cache header: %w", err)
+	}
+	switch {
+	case bytes.Equal(header, cacheMagic[:]):
+		br.Discard(len(cacheMagic))
+		c.version = 2
+	case bytes.Equal(header, cacheMagicV3[:]):
+		br.Discard(len(cacheMagicV3))
+		c.version = 3
+	case bytes.Equal(header, cacheMagicV4[:]):
+		br.Discard(len(cacheMagicV4))
+		c.version = 4
+	}
+	return c, nil
+}
+
+// Version is the format version of the file
+func (c *CacheReader) Version() int {
+	return c.version
+}
+
+// Read reads the next query into q, returning io.EOF at the end of the file.
+// The fingerprint is only set from version 2 on, the session from version 3
+// on and the timestamp from version 4 on.
+func (c *CacheReader) Read(q *Query) error {
+	var buf [CacheRecordSize]byte
+	if _, err := io.ReadFull(c.r, buf[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("truncated cache record: %w", err)
+		}
+		return err
+	}
+	q.Hash = binary.LittleEndian.Uint64(buf[0:8])
+	q.FingerprintHash = binary.LittleEndian.Uint64(buf[8:16])
+	q.Offset = binary.LittleEndian.Uint64(buf[16:24])
+	q.Length = binary.LittleEndian.Uint64(buf[24:32])
+	q.Fingerprint = nil
+	q.Session, q.SessionSeq, q.Timestamp = 0, 0, 0
+	if c.version < 2 {
+		return nil
+	}
+
+	if c.version >= 3 {
+		var extraBuf [24]byte
+		extra := extraBuf[:16]
+		if c.version >= 4 {
+			extra = extraBuf[:24]
+		}
+		if _, err := io.ReadFull(c.r, extra); err != nil {
+			return fmt.Errorf("truncated cache record: %w", err)
+		}
+		q.Session = binary.LittleEndian.Uint64(extra[0:8])
+		q.SessionSeq = binary.LittleEndian.Uint64(extra[8:16])
+		if c.version >= 4 {
+			q.Timestamp = binary.LittleEndian.Uint64(extra[16:24])
+		}
+	}
+
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(c.r, lenBuf[:]); err != nil {
+		return fmt.Errorf("truncated cache record: %w", err)
+	}
+	n := binary.LittleEndian.Uint32(lenBuf[:])
+	if n > maxCacheFingerprintLen {
+		return fmt.Errorf("invalid cache record fingerprint length: %d", n)
+	}
+	if n > 0 {
+		q.Fingerprint = make([]byte, n)
+		if _, err := io.ReadFull(c.r, q.Fingerprint); err != nil {
+			return fmt.Errorf("truncated cache record: %w", err)
+		}
+	}
+	return nil
+}
+
+// CacheWriter writes queries in the version 4 cache file format
+type CacheWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+// NewCacheWriter writes the file header to w
+func NewCacheWriter(w io.Writer) (*CacheWriter, error) {
+	if _, err := w.Write(cacheMagicV4[:]); err != nil {
+		return nil, fmt.Errorf("error writing cache header: %w", err)
+	}
+	return &CacheWriter{w: w, buf: make([]byte, cacheRecordSizeV4+4)}, nil
+}
+
+// cacheRecordSizeV4 is the fixed part of a version 4 record, session and
+// timestamp included
+const cacheRecordSizeV4 = CacheRecordSize + 24
+
+func (c *CacheWriter) Write(q *Query) error {
+	buf := c.buf[:cacheRecordSizeV4+4]
+	binary.LittleEndian.PutUint64(buf[0:8], q.Hash)
+	binary.LittleEndian.PutUint64(buf[8:16], q.FingerprintHash)
+	binary.LittleEndian.PutUint64(buf[16:24], q.Offset)
+	binary.LittleEndian.PutUint64(buf[24:32], q.Length)
+	binary.LittleEndian.PutUint64(buf[32:40], q.Session)
+	binary.LittleEndian.PutUint64(buf[40:48], q.SessionSeq)
+	binary.LittleEndian.PutUint64(buf[48:56], q.Timestamp)
+	binary.LittleEndian.PutUint32(buf[56:60], uint32(len(q.Fingerprint)))
+	buf = append(buf, q.Fingerprint...)
+	c.buf = buf
+	_, err := c.w.Write(buf)
+	return err
+}
@@ -0,0 +1,93 @@
+package query
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheRoundTrip(t *testing.T) {
+	queries := []Query{
+		{Hash: 1, FingerprintHash: 10, Offset: 0, Length: 20, Fingerprint: []byte("select * from t where id = ?")},
+		{Hash: 2, FingerprintHash: 11, Offset: 20, Length: 5, Session: 42, SessionSeq: 3, Timestamp: 1718000000},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewCacheWriter(&buf)
+	require.NoError(t, err)
+	for i := range queries {
+		require.NoError(t, w.Write(&queries[i]))
+	}
+
+	r, err := NewCacheReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 4, r.Version())
+	for _, want := range queries {
+		var q Query
+		require.NoError(t, r.Read(&q))
+		assert.Equal(t, want, q)
+	}
+	var q Query
+	assert.ErrorIs(t, r.Read(&q), io.EOF)
+}
+
+func TestCacheReaderVersion3(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(cacheMagicV3[:])
+	for _, v := range []uint64{7, 70, 100, 12, 42, 3} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+
+	r, err := NewCacheReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 3, r.Version())
+	q := Query{Timestamp: 5}
+	require.NoError(t, r.Read(&q))
+	assert.Equal(t, Query{Hash: 7, FingerprintHash: 70, Offset: 100, Length: 12, Session: 42, SessionSeq: 3}, q)
+	assert.ErrorIs(t, r.Read(&q), io.EOF)
+}
+
+func TestCacheReaderVersion2(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(cacheMagic[:])
+	for _, v := range []uint64{7, 70, 100, 12} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(3))
+	buf.WriteString("a ?")
+
+	r, err := NewCacheReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.Version())
+	q := Query{Session: 5}
+	require.NoError(t, r.Read(&q))
+	assert.Equal(t, Query{Hash: 7, FingerprintHash: 70, Offset: 100, Length: 12, Fingerprint: []byte("a ?")}, q)
+	assert.ErrorIs(t, r.Read(&q), io.EOF)
+}
+
+func TestCacheReaderVersion1(t *testing.T) {
+	var buf bytes.Buffer
+	for _, v := range []uint64{7, 70, 100, 12} {
+		binary.Write(&buf, binary.LittleEndian, v)
+	}
+
+	r, err := NewCacheReader(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.Version())
+	var q Query
+	require.NoError(t, r.Read(&q))
+	assert.Equal(t, Query{Hash: 7, FingerprintHash: 70, Offset: 100, Length: 12}, q)
+	assert.ErrorIs(t, r.Read(&q), io.EOF)
+}
+
+func TestCacheReaderEmpty(t *testing.T) {
+	r, err := NewCacheReader(bytes.NewReader(nil))
+	require.NoError(t, err)
+	var q Query
+	assert.ErrorIs(t, r.Read(&q), io.EOF)
+}
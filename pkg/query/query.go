@@ -3,6 +3,7 @@ package query
 import (
 	"encoding/binary"
 	"fmt"
+	"time"
 )
 
 type Query struct {
@@ -14,6 +15,40 @@ type Query struct {
 	CompletelyProcessed bool   `json:"completely_processed"`
 	Offset              uint64 `json:"offset"`
 	Length              uint64 `json:"length"`
+
+	// Session identifies the client connection the query was captured on
+	// and SessionSeq orders the queries of the session, both are 0 when the
+	// input doesn't follow connections
+	Session    uint64 `json:"session,omitempty"`
+	SessionSeq uint64 `json:"session_seq,omitempty"`
+
+	// Stats are the execution stats of the fingerprint when the input
+	// reports them, they are not part of the cache format
+	Stats *FingerprintStats `json:"stats,omitempty"`
+}
+
+// FingerprintStats summarizes the executions of a fingerprint, e.g. a
+// class of a pt-query-digest report
+type FingerprintStats struct {
+	Count           uint64        `json:"count"`
+	AvgExecTime     time.Duration `json:"avg_exec_time"`
+	AvgRowsExamined uint64        `json:"avg_rows_examined"`
+	AvgRowsSent     uint64        `json:"avg_rows_sent"`
+}
+
+// Merge adds the executions of other, e.g. of a single query of a slow log
+func (s *FingerprintStats) Merge(other FingerprintStats) {
+	total := s.Count + other.Count
+	if total == 0 {
+		return
+	}
+	avg := func(a, b uint64) uint64 {
+		return uint64((float64(a)*float64(s.Count) + float64(b)*float64(other.Count)) / float64(total))
+	}
+	s.AvgExecTime = time.Duration(avg(uint64(s.AvgExecTime), uint64(other.AvgExecTime)))
+	s.AvgRowsExamined = avg(s.AvgRowsExamined, other.AvgRowsExamined)
+	s.AvgRowsSent = avg(s.AvgRowsSent, other.AvgRowsSent)
+	s.Count = total
 }
 
 const (
@@ -0,0 +1,15 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprintStatsMerge(t *testing.T) {
+	s := FingerprintStats{}
+	s.Merge(FingerprintStats{Count: 1, AvgExecTime: 10 * time.Millisecond, AvgRowsExamined: 100, AvgRowsSent: 1})
+	s.Merge(FingerprintStats{Count: 3, AvgExecTime: 2 * time.Millisecond, AvgRowsExamined: 20, AvgRowsSent: 5})
+	assert.Equal(t, FingerprintStats{Count: 4, AvgExecTime: 4 * time.Millisecond, AvgRowsExamined: 40, AvgRowsSent: 4}, s)
+}
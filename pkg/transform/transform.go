@@ -0,0 +1,94 @@
+// Package transform holds the registry of custom processing stages the query
+// collector can apply to queries. Transformers live in their own packages and
+// register themselves from init, so adding one only takes a blank import in
+// the collector.
+package transform
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"mysql-load-test/pkg/query"
+)
+
+// Transformer is a custom processing stage applied to every valid query
+// before it is normalized and hashed, e.g. to scramble tenant ids or remap
+// shard keys. Returning a nil query drops it from the corpus.
+//
+// Transformers are called concurrently from all processor goroutines.
+type Transformer interface {
+	Transform(q *query.Query) (*query.Query, error)
+}
+
+type Func func(q *query.Query) (*query.Query, error)
+
+func (f Func) Transform(q *query.Query) (*query.Query, error) {
+	return f(q)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Transformer{}
+)
+
+// Register makes a transformer available to --processor.transforms under the
+// given name. It is meant to be called from init functions and panics if the
+// name is already taken.
+func Register(name string, t Transformer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, ok := registry[name]; ok {
+		panic(fmt.Sprintf("transformer already registered: %s", name))
+	}
+	registry[name] = t
+}
+
+// Names returns the registered transformer names, sorted.
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	return registeredNames()
+}
+
+// registeredNames must be called with registryMu held.
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain is an ordered list of transformers.
+type Chain []Transformer
+
+// Lookup resolves the configured transformer names, preserving their order as
+// the order of the chain.
+func Lookup(names []string) (Chain, error) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	chain := make(Chain, 0, len(names))
+	for _, name := range names {
+		t, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown transformer %q (registered: %v)", name, registeredNames())
+		}
+		chain = append(chain, t)
+	}
+	return chain, nil
+}
+
+// Apply runs q through the chain in order. It stops at the first error or
+// at the first transformer that drops the query, returning a nil query.
+func (c Chain) Apply(q *query.Query) (*query.Query, error) {
+	for _, t := range c {
+		var err error
+		q, err = t.Transform(q)
+		if err != nil || q == nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,69 @@
+package transform
+
+import (
+	"errors"
+	"testing"
+
+	"mysql-load-test/pkg/query"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func appendRaw(suffix string) Func {
+	return func(q *query.Query) (*query.Query, error) {
+		q.Raw = append(q.Raw, suffix...)
+		return q, nil
+	}
+}
+
+func TestLookupPreservesOrder(t *testing.T) {
+	Register("test-order-a", appendRaw("a"))
+	Register("test-order-b", appendRaw("b"))
+	Register("test-order-c", appendRaw("c"))
+
+	chain, err := Lookup([]string{"test-order-c", "test-order-a", "test-order-b"})
+	require.NoError(t, err)
+	q, err := chain.Apply(&query.Query{Raw: []byte("x")})
+	require.NoError(t, err)
+	assert.Equal(t, "xcab", string(q.Raw))
+
+	assert.Subset(t, Names(), []string{"test-order-a", "test-order-b", "test-order-c"})
+}
+
+func TestLookupUnknown(t *testing.T) {
+	_, err := Lookup([]string{"test-unknown"})
+	assert.ErrorContains(t, err, `unknown transformer "test-unknown"`)
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	Register("test-duplicate", appendRaw("a"))
+	assert.PanicsWithValue(t, "transformer already registered: test-duplicate", func() {
+		Register("test-duplicate", appendRaw("b"))
+	})
+}
+
+func TestApplyStopsOnDropAndError(t *testing.T) {
+	called := false
+	last := Func(func(q *query.Query) (*query.Query, error) {
+		called = true
+		return q, nil
+	})
+	drop := Func(func(q *query.Query) (*query.Query, error) { return nil, nil })
+	fail := Func(func(q *query.Query) (*query.Query, error) { return nil, errors.New("boom") })
+
+	q, err := Chain{drop, last}.Apply(&query.Query{})
+	assert.NoError(t, err)
+	assert.Nil(t, q)
+	assert.False(t, called)
+
+	q, err = Chain{fail, last}.Apply(&query.Query{})
+	assert.EqualError(t, err, "boom")
+	assert.Nil(t, q)
+	assert.False(t, called)
+
+	in := &query.Query{Raw: []byte("x")}
+	q, err = Chain(nil).Apply(in)
+	assert.NoError(t, err)
+	assert.Same(t, in, q)
+}